@@ -0,0 +1,245 @@
+// Package edge authenticates the requests accepted by the gateway. The
+// access token is verified once, here, and its session validated with
+// authsvc. The services behind the gateway are then handed a signed
+// identity, see the identity package, instead of checking the token again.
+package edge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/identity"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authendpoint"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+)
+
+type contextKey string
+
+// ClaimsContextKey holds the claims of the authenticated caller in the
+// context of the request, for the middlewares which run after the
+// authenticator.
+const ClaimsContextKey contextKey = "Claims"
+
+// StreamPaths are the routes of the gateway which serve streams of events.
+var StreamPaths = []string{"/task/v1/stream"}
+
+var (
+	ErrTokenMissing = errors.New("access token was missing")
+	ErrTokenInvalid = errors.New("access token was invalid or expired")
+	ErrUnavailable  = errors.New("authentication is unavailable")
+)
+
+// Authenticator is a middleware which refuses the requests to every route
+// but the public ones, unless they carry a valid access token or personal
+// access token.
+type Authenticator struct {
+	keys     *jwks.Cache
+	resolve  pat.Resolver
+	validate endpoint.Endpoint
+	signer   *identity.Signer
+	public   []string
+	logger   log.Logger
+}
+
+// NewAuthenticator returns an authenticator which validates the sessions
+// with the Validate endpoint of authsvc. The identity is only forwarded if
+// signer is not nil, the services verify the token themselves otherwise.
+func NewAuthenticator(keys *jwks.Cache, resolve pat.Resolver, validate endpoint.Endpoint, signer *identity.Signer, public []string, logger log.Logger) *Authenticator {
+	return &Authenticator{
+		keys:     keys,
+		resolve:  resolve,
+		validate: validate,
+		signer:   signer,
+		public:   public,
+		logger:   logger,
+	}
+}
+
+// ParseRoutes splits a comma separated list of public routes. A route
+// ending in a slash matches every path below it, any other route matches
+// the path exactly.
+func ParseRoutes(s string) []string {
+	var routes []string
+	for _, route := range strings.Split(s, ",") {
+		if route = strings.TrimSpace(route); route != "" {
+			routes = append(routes, route)
+		}
+	}
+	return routes
+}
+
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Identities are only issued by the gateway.
+		r.Header.Del(identity.Header)
+
+		if a.isPublic(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := bearerToken(r)
+		if token == "" && IsStream(r) {
+			token = queryToken(r)
+		}
+
+		claims, err := a.authenticate(r.Context(), token)
+		if err != nil {
+			if err == ErrUnavailable {
+				a.logger.Log("path", r.URL.Path, "err", err)
+			}
+			encodeError(w, err)
+			return
+		}
+
+		if a.signer != nil {
+			id, err := a.signer.Sign(claims)
+			if err != nil {
+				a.logger.Log("during", "Sign", "err", err)
+				encodeError(w, ErrUnavailable)
+				return
+			}
+			r.Header.Set(identity.Header, id)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), ClaimsContextKey, claims)))
+	})
+}
+
+// authenticate returns the claims of the token. Personal access tokens are
+// resolved by authsvc, which also checks that they were not revoked.
+func (a *Authenticator) authenticate(ctx context.Context, token string) (stdjwt.MapClaims, error) {
+	if token == "" {
+		return nil, ErrTokenMissing
+	}
+
+	if pat.IsToken(token) {
+		t, err := a.resolve(ctx, token)
+		switch err {
+		case nil:
+			return pat.Claims(t), nil
+		case authsvc.ErrInvalidToken, usersvc.ErrUserDisabled, usersvc.ErrPasswordResetRequired:
+			return nil, err
+		default:
+			return nil, ErrUnavailable
+		}
+	}
+
+	parsed, err := stdjwt.ParseWithClaims(token, stdjwt.MapClaims{}, func(t *stdjwt.Token) (interface{}, error) {
+		if t.Method != a.keys.Method() {
+			return nil, ErrTokenInvalid
+		}
+		return a.keys.Keyfunc(t)
+	})
+	if err != nil || !parsed.Valid {
+		return nil, ErrTokenInvalid
+	}
+
+	// Only access tokens are accepted, refresh tokens have no uuid claim.
+	claims := parsed.Claims.(stdjwt.MapClaims)
+	accessUUID, _ := claims["uuid"].(string)
+	if accessUUID == "" {
+		return nil, ErrTokenInvalid
+	}
+
+	response, err := a.validate(ctx, authendpoint.ValidateRequest{AccessUUID: accessUUID})
+	if err != nil {
+		return nil, ErrUnavailable
+	}
+	if resp := response.(authendpoint.ValidateResponse); resp.Err != nil || !resp.V {
+		return nil, ErrTokenInvalid
+	}
+	return claims, nil
+}
+
+// UserID returns the ID of the authenticated caller. It is not found on the
+// public routes.
+func UserID(r *http.Request) (uint64, bool) {
+	claims, ok := r.Context().Value(ClaimsContextKey).(stdjwt.MapClaims)
+	if !ok {
+		return 0, false
+	}
+	userID, ok := claims["user_id"].(float64)
+	if !ok || userID <= 0 {
+		return 0, false
+	}
+	return uint64(userID), true
+}
+
+func (a *Authenticator) isPublic(path string) bool {
+	for _, route := range a.public {
+		if path == route || (strings.HasSuffix(route, "/") && strings.HasPrefix(path, route)) {
+			return true
+		}
+	}
+	return false
+}
+
+func bearerToken(r *http.Request) string {
+	parts := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// IsStream reports whether the request opens an EventSource or a WebSocket,
+// which browsers cannot set the Authorization header of. Only the requests
+// for one of the StreamPaths are streams, whatever their headers say, so
+// that the token of no other route is taken from the URL.
+func IsStream(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	for _, path := range StreamPaths {
+		if r.URL.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// queryToken takes the token from the access_token query parameter. It is
+// moved to the Authorization header, so that it is forwarded like the other
+// tokens and kept out of the URLs logged behind the gateway.
+func queryToken(r *http.Request) string {
+	q := r.URL.Query()
+	token := q.Get("access_token")
+	if token == "" {
+		return ""
+	}
+
+	q.Del("access_token")
+	r.URL.RawQuery = q.Encode()
+	r.RequestURI = r.URL.RequestURI()
+	r.Header.Set("Authorization", "Bearer "+token)
+	return token
+}
+
+func encodeError(w http.ResponseWriter, err error) {
+	code := http.StatusUnauthorized
+	switch err {
+	case usersvc.ErrUserDisabled, usersvc.ErrPasswordResetRequired:
+		code = http.StatusForbidden
+	case ErrUnavailable:
+		code = http.StatusServiceUnavailable
+	default:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="gtdkit"`)
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errorWrapper{Error: err.Error()})
+}
+
+type errorWrapper struct {
+	Error string `json:"error"`
+}
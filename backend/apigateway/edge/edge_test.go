@@ -0,0 +1,254 @@
+package edge_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/apigateway/edge"
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/identity"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authendpoint"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+)
+
+var identityKey = []byte("identity key")
+
+// issuer signs tokens like authsvc, with the key of the cache.
+type issuer struct {
+	key  ed25519.PrivateKey
+	keys *jwks.Cache
+}
+
+func newIssuer(t *testing.T) issuer {
+	t.Helper()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := jwks.NewKey("k", "EdDSA", pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetch := func(context.Context) (jwks.KeySet, error) {
+		return jwks.KeySet{Keys: []jwks.Key{k}}, nil
+	}
+	return issuer{key: key, keys: jwks.NewCache(fetch, jwks.SigningMethodEdDSA, time.Hour)}
+}
+
+func (i issuer) sign(t *testing.T, claims stdjwt.MapClaims) string {
+	t.Helper()
+
+	claims["exp"] = time.Now().Add(time.Minute).Unix()
+	token := stdjwt.NewWithClaims(jwks.SigningMethodEdDSA, claims)
+	token.Header["kid"] = "k"
+	s, err := token.SignedString(i.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func (i issuer) accessToken(t *testing.T) string {
+	return i.sign(t, stdjwt.MapClaims{"uuid": "session", "user_id": float64(1), "roles": []string{usersvc.RoleUser}})
+}
+
+// validator answers the Validate calls of the authenticator, counting them.
+type validator struct {
+	valid bool
+	err   error
+	calls int
+}
+
+func (v *validator) validate(_ context.Context, request interface{}) (interface{}, error) {
+	v.calls++
+	if v.err != nil {
+		return nil, v.err
+	}
+	return authendpoint.ValidateResponse{V: v.valid && request.(authendpoint.ValidateRequest).AccessUUID == "session"}, nil
+}
+
+// recorder is the handler behind the authenticator, which keeps the last
+// request it was passed.
+type recorder struct {
+	r *http.Request
+}
+
+func (rec *recorder) ServeHTTP(_ http.ResponseWriter, r *http.Request) {
+	rec.r = r
+}
+
+func serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func bearer(r *http.Request, token string) *http.Request {
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+// TestMiddlewareStripsIdentity checks that the identities sent by clients
+// never reach the services, which are only handed the ones of the gateway.
+func TestMiddlewareStripsIdentity(t *testing.T) {
+	t.Parallel()
+
+	i := newIssuer(t)
+	signer, err := identity.NewSigner(identityKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := signer.Sign(stdjwt.MapClaims{"user_id": float64(2), "roles": []string{usersvc.RoleAdmin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range []*identity.Signer{nil, signer} {
+		var next recorder
+		a := edge.NewAuthenticator(i.keys, nil, (&validator{valid: true}).validate, s, []string{"/auth/v1/login"}, log.NewNopLogger())
+		h := a.Middleware(&next)
+
+		r := httptest.NewRequest(http.MethodPost, "/auth/v1/login", nil)
+		r.Header.Set(identity.Header, forged)
+		if w := serve(h, r); w.Code != http.StatusOK || next.r.Header.Get(identity.Header) != "" {
+			t.Errorf("public route: want the identity stripped, got %d %q", w.Code, next.r.Header.Get(identity.Header))
+		}
+
+		r = bearer(httptest.NewRequest(http.MethodGet, "/task/v1/tasks", nil), i.accessToken(t))
+		r.Header.Set(identity.Header, forged)
+		if w := serve(h, r); w.Code != http.StatusOK {
+			t.Fatalf("want the request authenticated, got %d %s", w.Code, w.Body)
+		}
+		got := next.r.Header.Get(identity.Header)
+		if s == nil {
+			if got != "" {
+				t.Errorf("without signer: want the identity stripped, got %q", got)
+			}
+			continue
+		}
+		claims, err := identity.Verify(identityKey, got)
+		if err != nil || claims["user_id"] != float64(1) {
+			t.Errorf("with signer: want the identity of user 1, got %v, %v", claims, err)
+		}
+		if userID, ok := edge.UserID(next.r); !ok || userID != 1 {
+			t.Errorf("want user 1 in the context, got %d, %v", userID, ok)
+		}
+	}
+}
+
+// TestMiddlewareRefusesRefreshTokens checks that a refresh token, which
+// has no uuid claim, is refused without asking authsvc.
+func TestMiddlewareRefusesRefreshTokens(t *testing.T) {
+	t.Parallel()
+
+	i := newIssuer(t)
+	v := &validator{valid: true}
+	h := edge.NewAuthenticator(i.keys, nil, v.validate, nil, nil, log.NewNopLogger()).Middleware(&recorder{})
+
+	refresh := i.sign(t, stdjwt.MapClaims{"access_uuid": "session", "refresh_uuid": "refresh", "user_id": float64(1)})
+	if w := serve(h, bearer(httptest.NewRequest(http.MethodGet, "/task/v1/tasks", nil), refresh)); w.Code != http.StatusUnauthorized {
+		t.Errorf("want %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+	if v.calls != 0 {
+		t.Errorf("want the refresh token refused before validation, validated %d times", v.calls)
+	}
+}
+
+// TestQueryTokenOnStreamPaths checks that the token is only taken from the
+// query by GET on a stream route, and then moved to the header.
+func TestQueryTokenOnStreamPaths(t *testing.T) {
+	t.Parallel()
+
+	i := newIssuer(t)
+	token := i.accessToken(t)
+
+	for _, tc := range []struct {
+		method, target string
+		code           int
+	}{
+		{http.MethodGet, "/task/v1/stream?access_token=" + token + "&since=1", http.StatusOK},
+		{http.MethodPost, "/task/v1/stream?access_token=" + token, http.StatusUnauthorized},
+		{http.MethodGet, "/task/v1/stream/more?access_token=" + token, http.StatusUnauthorized},
+		{http.MethodGet, "/task/v1/tasks?access_token=" + token, http.StatusUnauthorized},
+	} {
+		var next recorder
+		h := edge.NewAuthenticator(i.keys, nil, (&validator{valid: true}).validate, nil, nil, log.NewNopLogger()).Middleware(&next)
+
+		w := serve(h, httptest.NewRequest(tc.method, tc.target, nil))
+		if w.Code != tc.code {
+			t.Errorf("%s %s: want %d, got %d", tc.method, tc.target, tc.code, w.Code)
+			continue
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+		if next.r.Header.Get("Authorization") != "Bearer "+token {
+			t.Errorf("want the token moved to the header, got %q", next.r.Header.Get("Authorization"))
+		}
+		if next.r.URL.RawQuery != "since=1" || next.r.RequestURI != "/task/v1/stream?since=1" {
+			t.Errorf("want the token removed from the URL, got %q", next.r.RequestURI)
+		}
+	}
+}
+
+// TestMiddlewareErrorCodes checks that invalid credentials are answered
+// with 401, disabled users with 403 and failures of authsvc with 503.
+func TestMiddlewareErrorCodes(t *testing.T) {
+	t.Parallel()
+
+	i := newIssuer(t)
+	other := newIssuer(t)
+	secret, err := pat.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name       string
+		token      string
+		validator  validator
+		resolveErr error
+		code       int
+	}{
+		{name: "valid token", token: i.accessToken(t), validator: validator{valid: true}, code: http.StatusOK},
+		{name: "no token", validator: validator{valid: true}, code: http.StatusUnauthorized},
+		{name: "other key", token: other.accessToken(t), validator: validator{valid: true}, code: http.StatusUnauthorized},
+		{name: "revoked session", token: i.accessToken(t), validator: validator{valid: false}, code: http.StatusUnauthorized},
+		{name: "authsvc down", token: i.accessToken(t), validator: validator{err: errors.New("no endpoints")}, code: http.StatusServiceUnavailable},
+		{name: "valid PAT", token: secret, code: http.StatusOK},
+		{name: "revoked PAT", token: secret, resolveErr: authsvc.ErrInvalidToken, code: http.StatusUnauthorized},
+		{name: "disabled user", token: secret, resolveErr: usersvc.ErrUserDisabled, code: http.StatusForbidden},
+		{name: "password reset", token: secret, resolveErr: usersvc.ErrPasswordResetRequired, code: http.StatusForbidden},
+		{name: "authsvc down for PAT", token: secret, resolveErr: errors.New("no endpoints"), code: http.StatusServiceUnavailable},
+	} {
+		v := tc.validator
+		resolveErr := tc.resolveErr
+		resolve := func(context.Context, string) (pat.Token, error) {
+			return pat.Token{ID: "pat", UserID: 1}, resolveErr
+		}
+		h := edge.NewAuthenticator(i.keys, resolve, v.validate, nil, nil, log.NewNopLogger()).Middleware(&recorder{})
+
+		r := httptest.NewRequest(http.MethodGet, "/task/v1/tasks", nil)
+		if tc.token != "" {
+			bearer(r, tc.token)
+		}
+		w := serve(h, r)
+		if w.Code != tc.code {
+			t.Errorf("%s: want %d, got %d %s", tc.name, tc.code, w.Code, w.Body)
+		}
+		if challenge := w.Header().Get("WWW-Authenticate"); (w.Code == http.StatusUnauthorized) != (challenge != "") {
+			t.Errorf("%s: want a challenge with 401 only, got %d %q", tc.name, w.Code, challenge)
+		}
+	}
+}
@@ -0,0 +1,679 @@
+package graphql
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+var (
+	ErrSyntax          = errors.New("syntax error")
+	ErrInvalidDocument = errors.New("invalid document")
+	ErrTooComplex      = errors.New("query is too complex")
+)
+
+const (
+	// MaxDepth bounds the nesting of the fields of an operation, and
+	// MaxFields the number of fields it selects once its fragments are
+	// spread, so that one request cannot fan out into unbounded calls to
+	// the services.
+	MaxDepth  = 10
+	MaxFields = 500
+)
+
+// Request is a GraphQL request, as posted by the clients.
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+// Response holds the data, which is missing if the request was refused
+// before the execution, and the errors.
+type Response struct {
+	Data   json.RawMessage `json:"data,omitempty"`
+	Errors []*Error        `json:"errors,omitempty"`
+}
+
+type Error struct {
+	Message   string        `json:"message"`
+	Locations []Location    `json:"locations,omitempty"`
+	Path      []interface{} `json:"path,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+type Location struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+}
+
+// Execute runs the request against the schema. The fields are resolved one
+// after the other, for the queries as well as for the mutations, so that
+// the resolvers of a request share its loaders without locking.
+func Execute(ctx context.Context, schema *Schema, req Request) *Response {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	op, err := selectOperation(doc, req.OperationName)
+	if err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+
+	root := schema.Query
+	if op.kind == "mutation" {
+		if schema.Mutation == nil {
+			return &Response{Errors: []*Error{{Message: fmt.Sprintf("%v: mutations are not supported", ErrInvalidDocument)}}}
+		}
+		root = schema.Mutation
+	}
+
+	e := &executor{doc: doc, op: op}
+	if e.vars, err = e.coerceVariables(op.variables, req.Variables); err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	// The operation is measured first, as the validation spreads the
+	// fragments too.
+	var fields int
+	if err := e.measure(op.selection, 1, &fields, nil); err != nil {
+		return &Response{Errors: []*Error{{Message: err.Error()}}}
+	}
+	if errs := e.validate(root, op.selection, nil); len(errs) > 0 {
+		return &Response{Errors: errs}
+	}
+
+	// The data is null if a non-null root field is.
+	b := []byte("null")
+	if data := e.executeSelection(ctx, root, nil, op.selection, nil); data != nil {
+		if b, err = json.Marshal(data); err != nil {
+			return &Response{Errors: []*Error{{Message: err.Error()}}}
+		}
+	}
+	return &Response{Data: b, Errors: e.errors}
+}
+
+// IsMutation reports whether the request would run a mutation, which is
+// refused on GET.
+func IsMutation(req Request) bool {
+	doc, err := parse(req.Query)
+	if err != nil {
+		return false
+	}
+	op, err := selectOperation(doc, req.OperationName)
+	return err == nil && op.kind == "mutation"
+}
+
+func selectOperation(doc *document, name string) (*operation, error) {
+	if name == "" {
+		if len(doc.operations) > 1 {
+			return nil, fmt.Errorf("%w: operationName is required with several operations", ErrInvalidDocument)
+		}
+		return doc.operations[0], nil
+	}
+	for _, op := range doc.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidDocument, name)
+}
+
+type executor struct {
+	doc    *document
+	op     *operation
+	vars   map[string]interface{}
+	errors []*Error
+}
+
+// scalar returns the type of a variable, which may only be a built-in
+// scalar.
+func scalar(name string) *Scalar {
+	switch name {
+	case "Int":
+		return Int
+	case "Float":
+		return Float
+	case "String":
+		return String
+	case "Boolean":
+		return Boolean
+	case "ID":
+		return ID
+	}
+	return nil
+}
+
+func (e *executor) typeOf(t typeRef) (Type, error) {
+	var typ Type
+	if t.elem != nil {
+		elem, err := e.typeOf(*t.elem)
+		if err != nil {
+			return nil, err
+		}
+		typ = &List{Of: elem}
+	} else {
+		s := scalar(t.name)
+		if s == nil {
+			return nil, fmt.Errorf("%w: unknown input type %s", ErrInvalidDocument, t.name)
+		}
+		typ = s
+	}
+	if t.nonNull {
+		typ = &NonNull{Of: typ}
+	}
+	return typ, nil
+}
+
+func (e *executor) coerceVariables(defs []*variableDefinition, input map[string]interface{}) (map[string]interface{}, error) {
+	vars := make(map[string]interface{})
+	for _, def := range defs {
+		t, err := e.typeOf(def.typ)
+		if err != nil {
+			return nil, err
+		}
+
+		v, ok := input[def.name]
+		if !ok {
+			if def.defValue != nil {
+				if vars[def.name], err = e.coerceLiteral(t, def.defValue); err != nil {
+					return nil, fmt.Errorf("%w: variable $%s: %v", ErrInvalidDocument, def.name, err)
+				}
+				continue
+			}
+			if _, nonNull := t.(*NonNull); nonNull {
+				return nil, fmt.Errorf("%w: variable $%s of type %s is required", ErrInvalidDocument, def.name, t)
+			}
+			continue
+		}
+		if vars[def.name], err = coerceInput(t, v); err != nil {
+			return nil, fmt.Errorf("%w: variable $%s: %v", ErrInvalidDocument, def.name, err)
+		}
+	}
+	return vars, nil
+}
+
+// coerceInput parses a value decoded from JSON, or an already parsed
+// variable, as the type.
+func coerceInput(t Type, v interface{}) (interface{}, error) {
+	switch t := t.(type) {
+	case *NonNull:
+		if v == nil {
+			return nil, fmt.Errorf("expected %s, found null", t)
+		}
+		return coerceInput(t.Of, v)
+	case *List:
+		if v == nil {
+			return nil, nil
+		}
+		items, ok := v.([]interface{})
+		if !ok {
+			item, err := coerceInput(t.Of, v)
+			if err != nil {
+				return nil, err
+			}
+			return []interface{}{item}, nil
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			var err error
+			if list[i], err = coerceInput(t.Of, item); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	case *Scalar:
+		if v == nil {
+			return nil, nil
+		}
+		return t.Parse(v)
+	}
+	return nil, fmt.Errorf("%s is not an input type", t)
+}
+
+// coerceLiteral parses a value of the document as the type.
+func (e *executor) coerceLiteral(t Type, v value) (interface{}, error) {
+	switch v := v.(type) {
+	case variable:
+		value, ok := e.vars[string(v)]
+		if !ok {
+			value = nil
+		}
+		return coerceInput(t, value)
+	case []value:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			if _, ok := item.(variable); ok {
+				var err error
+				if items[i], err = e.coerceLiteral(elemType(t), item); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			items[i] = literalToInput(item)
+		}
+		return coerceInput(t, items)
+	case enumValue, map[string]value:
+		return nil, fmt.Errorf("expected %s, found %v", t, v)
+	}
+	return coerceInput(t, v)
+}
+
+func elemType(t Type) Type {
+	if n, ok := t.(*NonNull); ok {
+		t = n.Of
+	}
+	if l, ok := t.(*List); ok {
+		return l.Of
+	}
+	return t
+}
+
+func literalToInput(v value) interface{} {
+	if list, ok := v.([]value); ok {
+		items := make([]interface{}, len(list))
+		for i, item := range list {
+			items[i] = literalToInput(item)
+		}
+		return items
+	}
+	return v
+}
+
+// validate checks the selection against the schema, before anything is
+// resolved. visiting holds the fragments being validated, to refuse the
+// cycles.
+func (e *executor) validate(obj *Object, sel []selection, visiting map[string]bool) []*Error {
+	var errs []*Error
+	for _, s := range sel {
+		switch s := s.(type) {
+		case *field:
+			errs = append(errs, e.validateDirectives(s.directives, s.pos)...)
+			if s.name == "__typename" {
+				if s.selection != nil {
+					errs = append(errs, errorAt(s.pos, "field __typename cannot have a selection"))
+				}
+				continue
+			}
+			if strings.HasPrefix(s.name, "__") {
+				errs = append(errs, errorAt(s.pos, "introspection is not supported, the schema is served as SDL"))
+				continue
+			}
+
+			f := obj.field(s.name)
+			if f == nil {
+				errs = append(errs, errorAt(s.pos, "cannot query field %q on type %q", s.name, obj.Name))
+				continue
+			}
+			for name, v := range s.arguments {
+				a := f.arg(name)
+				if a == nil {
+					errs = append(errs, errorAt(s.pos, "unknown argument %q of field %q", name, s.name))
+					continue
+				}
+				errs = append(errs, e.validateValue(v, s.pos)...)
+			}
+			for _, a := range f.Args {
+				if _, nonNull := a.Type.(*NonNull); !nonNull {
+					continue
+				}
+				if v, ok := s.arguments[a.Name]; !ok || v == nil {
+					errs = append(errs, errorAt(s.pos, "argument %q of type %s is required", a.Name, a.Type))
+				}
+			}
+
+			child, isObject := namedType(f.Type).(*Object)
+			switch {
+			case isObject && s.selection == nil:
+				errs = append(errs, errorAt(s.pos, "field %q of type %s must have a selection", s.name, f.Type))
+			case !isObject && s.selection != nil:
+				errs = append(errs, errorAt(s.pos, "field %q of type %s cannot have a selection", s.name, f.Type))
+			case isObject:
+				errs = append(errs, e.validate(child, s.selection, visiting)...)
+			}
+		case *fragmentSpread:
+			errs = append(errs, e.validateDirectives(s.directives, s.pos)...)
+			frag, ok := e.doc.fragments[s.name]
+			if !ok {
+				errs = append(errs, errorAt(s.pos, "unknown fragment %q", s.name))
+				continue
+			}
+			if frag.typeCondition != obj.Name {
+				errs = append(errs, errorAt(s.pos, "fragment %q on %s cannot be spread within %s", s.name, frag.typeCondition, obj.Name))
+				continue
+			}
+			if visiting[s.name] {
+				errs = append(errs, errorAt(s.pos, "fragment %q spreads itself", s.name))
+				continue
+			}
+			v := map[string]bool{s.name: true}
+			for name := range visiting {
+				v[name] = true
+			}
+			errs = append(errs, e.validate(obj, frag.selection, v)...)
+		case *inlineFragment:
+			errs = append(errs, e.validateDirectives(s.directives, position{})...)
+			if s.typeCondition != "" && s.typeCondition != obj.Name {
+				errs = append(errs, &Error{Message: fmt.Sprintf("fragment on %s cannot be spread within %s", s.typeCondition, obj.Name)})
+				continue
+			}
+			errs = append(errs, e.validate(obj, s.selection, visiting)...)
+		}
+	}
+	return errs
+}
+
+// measure adds the fields of the selection, nested at depth, to count and
+// fails as soon as the operation exceeds MaxDepth or MaxFields. The fields
+// skipped by a directive are counted too. The unknown fragments and those
+// in visiting, which spread themselves, are left to validate.
+func (e *executor) measure(sel []selection, depth int, count *int, visiting map[string]bool) error {
+	for _, s := range sel {
+		switch s := s.(type) {
+		case *field:
+			if depth > MaxDepth {
+				return fmt.Errorf("%w: fields are nested deeper than %d", ErrTooComplex, MaxDepth)
+			}
+			if *count++; *count > MaxFields {
+				return fmt.Errorf("%w: more than %d fields are selected", ErrTooComplex, MaxFields)
+			}
+			if err := e.measure(s.selection, depth+1, count, visiting); err != nil {
+				return err
+			}
+		case *fragmentSpread:
+			frag, ok := e.doc.fragments[s.name]
+			if !ok || visiting[s.name] {
+				continue
+			}
+			v := map[string]bool{s.name: true}
+			for name := range visiting {
+				v[name] = true
+			}
+			if err := e.measure(frag.selection, depth, count, v); err != nil {
+				return err
+			}
+		case *inlineFragment:
+			if err := e.measure(s.selection, depth, count, visiting); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (e *executor) validateDirectives(directives []*directive, pos position) []*Error {
+	var errs []*Error
+	for _, d := range directives {
+		if d.name != "skip" && d.name != "include" {
+			errs = append(errs, errorAt(pos, "unknown directive @%s", d.name))
+			continue
+		}
+		if _, err := e.coerceLiteral(&NonNull{Of: Boolean}, d.arguments["if"]); err != nil {
+			errs = append(errs, errorAt(pos, "directive @%s: %v", d.name, err))
+		}
+	}
+	return errs
+}
+
+// validateValue checks that the variables of the value are defined.
+func (e *executor) validateValue(v value, pos position) []*Error {
+	switch v := v.(type) {
+	case variable:
+		if !e.defined(string(v)) {
+			return []*Error{errorAt(pos, "variable $%s is not defined", v)}
+		}
+	case []value:
+		var errs []*Error
+		for _, item := range v {
+			errs = append(errs, e.validateValue(item, pos)...)
+		}
+		return errs
+	}
+	return nil
+}
+
+func (e *executor) defined(name string) bool {
+	for _, def := range e.op.variables {
+		if def.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func namedType(t Type) Type {
+	for {
+		switch u := t.(type) {
+		case *NonNull:
+			t = u.Of
+		case *List:
+			t = u.Of
+		default:
+			return t
+		}
+	}
+}
+
+// collectFields groups the fields of the selection by their response key,
+// in the order they are first selected. The fields skipped by a directive
+// are left out.
+func (e *executor) collectFields(sel []selection, keys []string, groups map[string][]*field) ([]string, map[string][]*field) {
+	for _, s := range sel {
+		switch s := s.(type) {
+		case *field:
+			if !e.included(s.directives) {
+				continue
+			}
+			key := s.key()
+			if _, ok := groups[key]; !ok {
+				keys = append(keys, key)
+			}
+			groups[key] = append(groups[key], s)
+		case *fragmentSpread:
+			if !e.included(s.directives) {
+				continue
+			}
+			keys, groups = e.collectFields(e.doc.fragments[s.name].selection, keys, groups)
+		case *inlineFragment:
+			if !e.included(s.directives) {
+				continue
+			}
+			keys, groups = e.collectFields(s.selection, keys, groups)
+		}
+	}
+	return keys, groups
+}
+
+func (e *executor) included(directives []*directive) bool {
+	for _, d := range directives {
+		v, _ := e.coerceLiteral(Boolean, d.arguments["if"])
+		b, _ := v.(bool)
+		if d.name == "skip" && b || d.name == "include" && !b {
+			return false
+		}
+	}
+	return true
+}
+
+// executeSelection resolves the fields of an object. It returns nil if a
+// non-null field is null, the object is then null itself.
+func (e *executor) executeSelection(ctx context.Context, obj *Object, source interface{}, sel []selection, path []interface{}) *orderedMap {
+	keys, groups := e.collectFields(sel, nil, make(map[string][]*field))
+
+	result := &orderedMap{}
+	for _, key := range keys {
+		fields := groups[key]
+		fieldPath := append(append([]interface{}{}, path...), key)
+
+		if fields[0].name == "__typename" {
+			result.set(key, obj.Name)
+			continue
+		}
+
+		f := obj.field(fields[0].name)
+		v := e.resolveField(ctx, f, source, fields, fieldPath)
+		if v == nil {
+			if _, nonNull := f.Type.(*NonNull); nonNull {
+				return nil
+			}
+		}
+		result.set(key, v)
+	}
+	return result
+}
+
+func (e *executor) resolveField(ctx context.Context, f *Field, source interface{}, fields []*field, path []interface{}) interface{} {
+	args := make(map[string]interface{})
+	for _, a := range f.Args {
+		lit, ok := fields[0].arguments[a.Name]
+		if !ok {
+			continue
+		}
+		if v, isVar := lit.(variable); isVar {
+			if _, given := e.vars[string(v)]; !given {
+				continue
+			}
+		}
+		v, err := e.coerceLiteral(a.Type, lit)
+		if err != nil {
+			e.fail(fields[0], path, fmt.Errorf("argument %q: %v", a.Name, err))
+			return nil
+		}
+		args[a.Name] = v
+	}
+
+	v, err := f.Resolve(ctx, source, args)
+	if err != nil {
+		e.fail(fields[0], path, err)
+		return nil
+	}
+	return e.completeValue(ctx, f.Type, fields, v, path)
+}
+
+// completeValue converts the value returned by a resolver for the response.
+// A nil slice is an empty list, the other nil values are null.
+func (e *executor) completeValue(ctx context.Context, t Type, fields []*field, v interface{}, path []interface{}) interface{} {
+	if n, ok := t.(*NonNull); ok {
+		completed := e.completeValue(ctx, n.Of, fields, v, path)
+		if completed == nil && !e.failedBelow(path) {
+			e.fail(fields[0], path, fmt.Errorf("cannot return null for non-nullable field"))
+		}
+		return completed
+	}
+
+	rv := reflect.ValueOf(v)
+	if v == nil || (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Map || rv.Kind() == reflect.Interface) && rv.IsNil() {
+		return nil
+	}
+
+	switch t := t.(type) {
+	case *List:
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.fail(fields[0], path, fmt.Errorf("expected a list, found %T", v))
+			return nil
+		}
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			itemPath := append(append([]interface{}{}, path...), i)
+			list[i] = e.completeValue(ctx, t.Of, fields, rv.Index(i).Interface(), itemPath)
+			if list[i] == nil {
+				if _, nonNull := t.Of.(*NonNull); nonNull {
+					return nil
+				}
+			}
+		}
+		return list
+	case *Scalar:
+		s, err := t.Serialize(v)
+		if err != nil {
+			e.fail(fields[0], path, err)
+			return nil
+		}
+		return s
+	case *Object:
+		var sel []selection
+		for _, f := range fields {
+			sel = append(sel, f.selection...)
+		}
+		if m := e.executeSelection(ctx, t, v, sel, path); m != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+func (e *executor) fail(f *field, path []interface{}, err error) {
+	e.errors = append(e.errors, &Error{
+		Message:   err.Error(),
+		Locations: []Location{{Line: f.pos.line, Column: f.pos.column}},
+		Path:      path,
+	})
+}
+
+// failedBelow reports whether an error was recorded at or below the path,
+// so that a null propagated by it is not reported again.
+func (e *executor) failedBelow(path []interface{}) bool {
+	for _, err := range e.errors {
+		if len(err.Path) < len(path) {
+			continue
+		}
+		match := true
+		for i := range path {
+			if err.Path[i] != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func errorAt(pos position, format string, a ...interface{}) *Error {
+	return &Error{
+		Message:   fmt.Sprintf(format, a...),
+		Locations: []Location{{Line: pos.line, Column: pos.column}},
+	}
+}
+
+// orderedMap is an object of the response, whose fields are written in the
+// order they were selected.
+type orderedMap struct {
+	keys   []string
+	values []interface{}
+}
+
+func (m *orderedMap) set(key string, v interface{}) {
+	m.keys = append(m.keys, key)
+	m.values = append(m.values, v)
+}
+
+func (m *orderedMap) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, key := range m.keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(m.values[i])
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
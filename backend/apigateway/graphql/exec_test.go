@@ -0,0 +1,311 @@
+package graphql_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/ichigozero/gtdkit/backend/apigateway/graphql"
+)
+
+type user struct {
+	ID   string
+	Name string
+}
+
+// testSchema serves users, each of which has the next one as a friend, and
+// fields which fail.
+func testSchema() *graphql.Schema {
+	userType := &graphql.Object{Name: "User"}
+	userType.Fields = []*graphql.Field{
+		{
+			Name: "id",
+			Type: &graphql.NonNull{Of: graphql.ID},
+			Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				return source.(*user).ID, nil
+			},
+		},
+		{
+			Name: "name",
+			Args: []*graphql.Argument{{Name: "upper", Type: graphql.Boolean}},
+			Type: graphql.String,
+			Resolve: func(_ context.Context, source interface{}, args map[string]interface{}) (interface{}, error) {
+				if upper, _ := args["upper"].(bool); upper {
+					return strings.ToUpper(source.(*user).Name), nil
+				}
+				return source.(*user).Name, nil
+			},
+		},
+		{
+			Name: "friend",
+			Type: userType,
+			Resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				return &user{ID: source.(*user).ID + "+", Name: "friend"}, nil
+			},
+		},
+		{
+			Name: "secret",
+			Type: graphql.String,
+			Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+				return nil, errors.New("permission denied")
+			},
+		},
+		{
+			Name: "missing",
+			Type: &graphql.NonNull{Of: graphql.String},
+			Resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+				return nil, nil
+			},
+		},
+	}
+
+	return &graphql.Schema{
+		Query: &graphql.Object{
+			Name: "Query",
+			Fields: []*graphql.Field{
+				{
+					Name: "user",
+					Args: []*graphql.Argument{{Name: "id", Type: &graphql.NonNull{Of: graphql.ID}}},
+					Type: userType,
+					Resolve: func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+						return &user{ID: args["id"].(string), Name: "ann"}, nil
+					},
+				},
+				{
+					Name: "sum",
+					Args: []*graphql.Argument{{Name: "terms", Type: &graphql.NonNull{Of: &graphql.List{Of: &graphql.NonNull{Of: graphql.Int}}}}},
+					Type: graphql.Int,
+					Resolve: func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+						var sum int
+						for _, n := range args["terms"].([]interface{}) {
+							sum += n.(int)
+						}
+						return sum, nil
+					},
+				},
+			},
+		},
+	}
+}
+
+func execute(query string, vars map[string]interface{}) *graphql.Response {
+	return graphql.Execute(context.Background(), testSchema(), graphql.Request{Query: query, Variables: vars})
+}
+
+// messages returns the messages of the errors, joined for the failures.
+func messages(resp *graphql.Response) string {
+	var msgs []string
+	for _, err := range resp.Errors {
+		msgs = append(msgs, err.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func TestExecuteRefusesSyntaxErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, query := range []string{
+		"",
+		"{",
+		"{ user(id: ) { id } }",
+		`{ user(id: "1" { id } }`,
+		`{ user(id: "1) { id } }`,
+		"{ user(id: 1) { id } } }",
+		"query Q($id: ID! { user(id: $id) { id } }",
+		"fragment F User { id }",
+	} {
+		resp := execute(query, nil)
+		if resp.Data != nil {
+			t.Errorf("%q: want no data, got %s", query, resp.Data)
+		}
+		if len(resp.Errors) != 1 || !strings.HasPrefix(resp.Errors[0].Message, graphql.ErrSyntax.Error()) &&
+			!strings.HasPrefix(resp.Errors[0].Message, graphql.ErrInvalidDocument.Error()) {
+			t.Errorf("%q: want a syntax error, got %q", query, messages(resp))
+		}
+	}
+}
+
+func TestExecuteRefusesFragmentCycles(t *testing.T) {
+	t.Parallel()
+
+	for _, query := range []string{
+		`{ user(id: 1) { ...A } } fragment A on User { id ...A }`,
+		`{ user(id: 1) { ...A } } fragment A on User { friend { ...B } } fragment B on User { id ...A }`,
+		`{ user(id: 1) { ...A } } fragment A on User { ... on User { ...A } }`,
+	} {
+		resp := execute(query, nil)
+		if resp.Data != nil || !strings.Contains(messages(resp), "spreads itself") {
+			t.Errorf("%q: want the cycle refused, got %s, %q", query, resp.Data, messages(resp))
+		}
+	}
+}
+
+func TestExecuteCoercesVariables(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		query string
+		vars  map[string]interface{}
+		data  string
+		err   string
+	}{
+		{
+			query: `query ($id: ID!) { user(id: $id) { id } }`,
+			vars:  map[string]interface{}{"id": float64(7)},
+			data:  `{"user":{"id":"7"}}`,
+		},
+		{
+			query: `query ($id: ID = "8") { user(id: $id) { id } }`,
+			data:  `{"user":{"id":"8"}}`,
+		},
+		{
+			query: `query ($terms: [Int!]!) { sum(terms: $terms) }`,
+			vars:  map[string]interface{}{"terms": []interface{}{float64(1), float64(2)}},
+			data:  `{"sum":3}`,
+		},
+		{
+			query: `query ($terms: [Int!]!) { sum(terms: $terms) }`,
+			vars:  map[string]interface{}{"terms": float64(4)},
+			data:  `{"sum":4}`,
+		},
+		{
+			query: `query ($n: Int!) { sum(terms: [1, $n]) }`,
+			vars:  map[string]interface{}{"n": float64(5)},
+			data:  `{"sum":6}`,
+		},
+		{
+			query: `query ($id: ID!) { user(id: $id) { id } }`,
+			err:   "variable $id of type ID! is required",
+		},
+		{
+			query: `query ($terms: [Int!]!) { sum(terms: $terms) }`,
+			vars:  map[string]interface{}{"terms": []interface{}{float64(1), "2"}},
+			err:   "variable $terms: Int cannot represent 2",
+		},
+		{
+			query: `query ($terms: [Int!]!) { sum(terms: $terms) }`,
+			vars:  map[string]interface{}{"terms": []interface{}{float64(1), nil}},
+			err:   "variable $terms: expected Int!, found null",
+		},
+		{
+			query: `query ($n: Int = "one") { sum(terms: [$n]) }`,
+			err:   "variable $n: Int cannot represent one",
+		},
+		{
+			query: `query ($u: User) { user(id: 1) { id } }`,
+			err:   "unknown input type User",
+		},
+		{
+			query: `{ user(id: $id) { id } }`,
+			err:   "variable $id is not defined",
+		},
+	} {
+		resp := execute(tc.query, tc.vars)
+		if tc.err != "" {
+			if resp.Data != nil || !strings.Contains(messages(resp), tc.err) {
+				t.Errorf("%q: want %q, got %s, %q", tc.query, tc.err, resp.Data, messages(resp))
+			}
+			continue
+		}
+		if len(resp.Errors) > 0 || string(resp.Data) != tc.data {
+			t.Errorf("%q: want %s, got %s, %q", tc.query, tc.data, resp.Data, messages(resp))
+		}
+	}
+}
+
+func TestExecuteAppliesDirectives(t *testing.T) {
+	t.Parallel()
+
+	query := `query ($on: Boolean!) {
+		user(id: 1) {
+			id @skip(if: true)
+			name @include(if: $on)
+			...F @skip(if: $on)
+			... on User @include(if: $on) { friend { id } }
+		}
+	}
+	fragment F on User { secret }`
+
+	for _, tc := range []struct {
+		on   bool
+		data string
+	}{
+		{on: true, data: `{"user":{"name":"ann","friend":{"id":"1+"}}}`},
+		{on: false, data: `{"user":{"secret":null}}`},
+	} {
+		resp := execute(query, map[string]interface{}{"on": tc.on})
+		if string(resp.Data) != tc.data {
+			t.Errorf("on %v: want %s, got %s, %q", tc.on, tc.data, resp.Data, messages(resp))
+		}
+	}
+
+	resp := execute(`{ user(id: 1) { id @defer } }`, nil)
+	if resp.Data != nil || !strings.Contains(messages(resp), "unknown directive @defer") {
+		t.Errorf("want the unknown directive refused, got %s, %q", resp.Data, messages(resp))
+	}
+	resp = execute(`{ user(id: 1) { id @skip(if: "yes") } }`, nil)
+	if resp.Data != nil || !strings.Contains(messages(resp), "directive @skip") {
+		t.Errorf("want the string condition refused, got %s, %q", resp.Data, messages(resp))
+	}
+}
+
+func TestExecuteReportsPartialErrors(t *testing.T) {
+	t.Parallel()
+
+	resp := execute(`{ user(id: 1) { id secret } other: user(id: 2) { friend { missing } } }`, nil)
+
+	want := `{"user":{"id":"1","secret":null},"other":{"friend":null}}`
+	if string(resp.Data) != want {
+		t.Errorf("want %s, got %s", want, resp.Data)
+	}
+	if len(resp.Errors) != 2 {
+		t.Fatalf("want 2 errors, got %q", messages(resp))
+	}
+	for i, tc := range []struct {
+		message string
+		path    string
+	}{
+		{message: "permission denied", path: "[user secret]"},
+		{message: "cannot return null for non-nullable field", path: "[other friend missing]"},
+	} {
+		err := resp.Errors[i]
+		if err.Message != tc.message || fmt.Sprint(err.Path) != tc.path || len(err.Locations) != 1 {
+			t.Errorf("want %q at %s, got %q at %v, %v", tc.message, tc.path, err.Message, err.Path, err.Locations)
+		}
+	}
+}
+
+func TestExecuteLimitsComplexity(t *testing.T) {
+	t.Parallel()
+
+	nested := func(depth int) string {
+		return "{ user(id: 1) " + strings.Repeat("{ friend ", depth-2) + "{ id }" + strings.Repeat(" }", depth-2) + " }"
+	}
+	if resp := execute(nested(graphql.MaxDepth), nil); len(resp.Errors) > 0 {
+		t.Errorf("want a query at the maximum depth executed, got %q", messages(resp))
+	}
+
+	var wide strings.Builder
+	wide.WriteString("{ user(id: 1) {")
+	for i := 0; i < graphql.MaxFields; i++ {
+		fmt.Fprintf(&wide, " f%d: id", i)
+	}
+	wide.WriteString(" } }")
+
+	// Each fragment spreads the next one twice, which selects 2^20 fields.
+	var fanOut strings.Builder
+	fanOut.WriteString("{ user(id: 1) { ...F0 } }")
+	for i := 0; i < 20; i++ {
+		fmt.Fprintf(&fanOut, " fragment F%d on User { ...F%d ...F%d }", i, i+1, i+1)
+	}
+	fanOut.WriteString(" fragment F20 on User { id }")
+
+	for _, query := range []string{nested(graphql.MaxDepth + 1), wide.String(), fanOut.String()} {
+		resp := execute(query, nil)
+		if resp.Data != nil || !strings.HasPrefix(messages(resp), graphql.ErrTooComplex.Error()) {
+			t.Errorf("want the query refused as too complex, got %s, %q", resp.Data, messages(resp))
+		}
+	}
+}
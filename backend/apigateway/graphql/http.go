@@ -0,0 +1,103 @@
+// Package graphql serves a GraphQL endpoint on the gateway, so that the
+// frontend can fetch everything a view needs in one request. The executor
+// supports the queries and mutations of the GraphQL spec, with variables,
+// fragments and the @skip and @include directives; introspection is not
+// supported, the schema is served as SDL instead. The operations nested
+// deeper than MaxDepth or selecting more than MaxFields fields are refused
+// before they are executed. The fields are resolved through the clients of
+// the services, which authorize the calls, and the calls of a request are
+// batched by its loader.
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/ichigozero/gtdkit/backend/authsvc/identity"
+)
+
+// NewHTTPHandler returns the routes of the endpoint: POST and GET on
+// /graphql, GET only for queries, and the schema on /graphql/schema. The
+// caller is identified by userID, as authenticated by the gateway.
+func NewHTTPHandler(schema *Schema, endpoints Endpoints, userID func(*http.Request) (uint64, bool), logger log.Logger) *mux.Router {
+	h := handler{schema: schema, endpoints: endpoints, userID: userID, logger: logger}
+	sdl := schema.String()
+
+	r := mux.NewRouter()
+	r.Methods("POST").Path("/graphql").HandlerFunc(h.post)
+	r.Methods("GET").Path("/graphql").HandlerFunc(h.get)
+	r.Methods("GET").Path("/graphql/schema").HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(sdl))
+	})
+	return r
+}
+
+type handler struct {
+	schema    *Schema
+	endpoints Endpoints
+	userID    func(*http.Request) (uint64, bool)
+	logger    log.Logger
+}
+
+func (h handler) post(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		encodeResponse(w, http.StatusBadRequest, &Response{Errors: []*Error{{Message: "request body is not a GraphQL request"}}})
+		return
+	}
+	h.serve(w, r, req)
+}
+
+// get reads the request from the query string, where the variables are
+// JSON encoded.
+func (h handler) get(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := Request{Query: q.Get("query"), OperationName: q.Get("operationName")}
+	if v := q.Get("variables"); v != "" {
+		if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+			encodeResponse(w, http.StatusBadRequest, &Response{Errors: []*Error{{Message: "variables are not a JSON object"}}})
+			return
+		}
+	}
+	if IsMutation(req) {
+		w.Header().Set("Allow", "POST")
+		encodeResponse(w, http.StatusMethodNotAllowed, &Response{Errors: []*Error{{Message: "mutations must be posted"}}})
+		return
+	}
+	h.serve(w, r, req)
+}
+
+func (h handler) serve(w http.ResponseWriter, r *http.Request, req Request) {
+	// The token and the identity are forwarded to the services by their
+	// clients.
+	ctx := r.Context()
+	ctx = kitjwt.HTTPToContext()(ctx, r)
+	ctx = identity.HTTPToContext()(ctx, r)
+
+	userID, _ := h.userID(r)
+	ctx = context.WithValue(ctx, loaderContextKey, newLoader(h.endpoints, userID))
+
+	resp := Execute(ctx, h.schema, req)
+	for _, err := range resp.Errors {
+		h.logger.Log("operation", req.OperationName, "path", err.Path, "err", err.Message)
+	}
+
+	// The requests which were refused before they were executed have no
+	// data, the others succeed even if some of their fields failed.
+	code := http.StatusOK
+	if resp.Data == nil {
+		code = http.StatusBadRequest
+	}
+	encodeResponse(w, code, resp)
+}
+
+func encodeResponse(w http.ResponseWriter, code int, resp *Response) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(resp)
+}
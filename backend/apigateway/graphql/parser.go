@@ -0,0 +1,725 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The documents are parsed into the subset of the GraphQL AST the executor
+// supports: operations, fragments, fields with arguments and directives,
+// and variables. Type system definitions are not accepted, the schema is
+// defined in Go.
+
+type document struct {
+	operations []*operation
+	fragments  map[string]*fragment
+}
+
+type operation struct {
+	kind      string // "query" or "mutation"
+	name      string
+	variables []*variableDefinition
+	selection []selection
+}
+
+type variableDefinition struct {
+	name     string
+	typ      typeRef
+	defValue value
+}
+
+// typeRef is a type as written in a variable definition.
+type typeRef struct {
+	name    string
+	elem    *typeRef
+	nonNull bool
+}
+
+func (t typeRef) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+type fragment struct {
+	name          string
+	typeCondition string
+	selection     []selection
+}
+
+// selection is a *field, a *fragmentSpread or an *inlineFragment.
+type selection interface{}
+
+type field struct {
+	alias      string
+	name       string
+	arguments  map[string]value
+	directives []*directive
+	selection  []selection
+	pos        position
+}
+
+// key is the name of the field in the response.
+func (f *field) key() string {
+	if f.alias != "" {
+		return f.alias
+	}
+	return f.name
+}
+
+type fragmentSpread struct {
+	name       string
+	directives []*directive
+	pos        position
+}
+
+type inlineFragment struct {
+	typeCondition string
+	directives    []*directive
+	selection     []selection
+}
+
+type directive struct {
+	name      string
+	arguments map[string]value
+}
+
+// value is a literal of the document: nil for null, bool, int64, float64,
+// string, enumValue, variable, []value or map[string]value.
+type value interface{}
+
+type enumValue string
+
+type variable string
+
+type position struct {
+	line   int
+	column int
+}
+
+type token struct {
+	kind  tokenKind
+	value string
+	pos   position
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunctuator
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+type lexer struct {
+	src  string
+	i    int
+	line int
+	col  int
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+	pos := position{line: l.line, column: l.col}
+	if l.i >= len(l.src) {
+		return token{kind: tokenEOF, pos: pos}, nil
+	}
+
+	c := l.src[l.i]
+	switch {
+	case strings.IndexByte("!$()...:=@[]{|}", c) >= 0:
+		if c == '.' {
+			if !strings.HasPrefix(l.src[l.i:], "...") {
+				return token{}, syntaxError(pos, "unexpected %q", ".")
+			}
+			l.advance(3)
+			return token{kind: tokenPunctuator, value: "...", pos: pos}, nil
+		}
+		l.advance(1)
+		return token{kind: tokenPunctuator, value: string(c), pos: pos}, nil
+	case c == '_' || isLetter(c):
+		start := l.i
+		for l.i < len(l.src) && (l.src[l.i] == '_' || isLetter(l.src[l.i]) || isDigit(l.src[l.i])) {
+			l.advance(1)
+		}
+		return token{kind: tokenName, value: l.src[start:l.i], pos: pos}, nil
+	case c == '-' || isDigit(c):
+		return l.number(pos)
+	case c == '"':
+		return l.string(pos)
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.i:])
+	return token{}, syntaxError(pos, "unexpected character %q", r)
+}
+
+// skipIgnored skips the white space, the line terminators, the commas and
+// the comments.
+func (l *lexer) skipIgnored() {
+	for l.i < len(l.src) {
+		switch c := l.src[l.i]; c {
+		case ' ', '\t', ',', '\r':
+			l.advance(1)
+		case '\n':
+			l.i++
+			l.line++
+			l.col = 1
+		case '#':
+			for l.i < len(l.src) && l.src[l.i] != '\n' {
+				l.advance(1)
+			}
+		default:
+			if strings.HasPrefix(l.src[l.i:], "\ufeff") {
+				l.advance(len("\ufeff"))
+				continue
+			}
+			return
+		}
+	}
+}
+
+func (l *lexer) advance(n int) {
+	l.i += n
+	l.col += n
+}
+
+func (l *lexer) number(pos position) (token, error) {
+	start := l.i
+	if l.src[l.i] == '-' {
+		l.advance(1)
+	}
+	digits := func() int {
+		n := 0
+		for l.i < len(l.src) && isDigit(l.src[l.i]) {
+			l.advance(1)
+			n++
+		}
+		return n
+	}
+
+	if digits() == 0 {
+		return token{}, syntaxError(pos, "invalid number")
+	}
+	kind := tokenInt
+	if l.i < len(l.src) && l.src[l.i] == '.' {
+		l.advance(1)
+		if digits() == 0 {
+			return token{}, syntaxError(pos, "invalid number")
+		}
+		kind = tokenFloat
+	}
+	if l.i < len(l.src) && (l.src[l.i] == 'e' || l.src[l.i] == 'E') {
+		l.advance(1)
+		if l.i < len(l.src) && (l.src[l.i] == '+' || l.src[l.i] == '-') {
+			l.advance(1)
+		}
+		if digits() == 0 {
+			return token{}, syntaxError(pos, "invalid number")
+		}
+		kind = tokenFloat
+	}
+	if l.i < len(l.src) && (l.src[l.i] == '_' || l.src[l.i] == '.' || isLetter(l.src[l.i])) {
+		return token{}, syntaxError(pos, "invalid number")
+	}
+	return token{kind: kind, value: l.src[start:l.i], pos: pos}, nil
+}
+
+// string reads a string value. Block strings are not supported.
+func (l *lexer) string(pos position) (token, error) {
+	if strings.HasPrefix(l.src[l.i:], `"""`) {
+		return token{}, syntaxError(pos, "block strings are not supported")
+	}
+	l.advance(1)
+
+	var b strings.Builder
+	for l.i < len(l.src) {
+		c := l.src[l.i]
+		switch {
+		case c == '"':
+			l.advance(1)
+			return token{kind: tokenString, value: b.String(), pos: pos}, nil
+		case c == '\n' || c == '\r':
+			return token{}, syntaxError(pos, "unterminated string")
+		case c == '\\':
+			if l.i+1 >= len(l.src) {
+				return token{}, syntaxError(pos, "unterminated string")
+			}
+			switch e := l.src[l.i+1]; e {
+			case '"', '\\', '/':
+				b.WriteByte(e)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if l.i+6 > len(l.src) {
+					return token{}, syntaxError(pos, "invalid escape")
+				}
+				r, err := strconv.ParseUint(l.src[l.i+2:l.i+6], 16, 32)
+				if err != nil {
+					return token{}, syntaxError(pos, "invalid escape")
+				}
+				b.WriteRune(rune(r))
+				l.advance(4)
+			default:
+				return token{}, syntaxError(pos, "invalid escape")
+			}
+			l.advance(2)
+		default:
+			_, size := utf8.DecodeRuneInString(l.src[l.i:])
+			b.WriteString(l.src[l.i : l.i+size])
+			l.i += size
+			l.col++
+		}
+	}
+	return token{}, syntaxError(pos, "unterminated string")
+}
+
+func isLetter(c byte) bool { return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' }
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+type parser struct {
+	lexer *lexer
+	tok   token
+}
+
+func parse(src string) (*document, error) {
+	p := &parser{lexer: &lexer{src: src, line: 1, col: 1}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	doc := &document{fragments: make(map[string]*fragment)}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek(tokenPunctuator, "{"):
+			sel, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, &operation{kind: "query", selection: sel})
+		case p.peek(tokenName, "query"), p.peek(tokenName, "mutation"):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.operations = append(doc.operations, op)
+		case p.peek(tokenName, "fragment"):
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := doc.fragments[f.name]; ok {
+				return nil, fmt.Errorf("%w: fragment %q is defined more than once", ErrInvalidDocument, f.name)
+			}
+			doc.fragments[f.name] = f
+		case p.peek(tokenName, "subscription"):
+			return nil, fmt.Errorf("%w: subscriptions are not supported", ErrInvalidDocument)
+		default:
+			return nil, p.unexpected()
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, fmt.Errorf("%w: no operation", ErrInvalidDocument)
+	}
+	return doc, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lexer.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) peek(kind tokenKind, v string) bool {
+	return p.tok.kind == kind && p.tok.value == v
+}
+
+// skip consumes the punctuator if it is next.
+func (p *parser) skip(v string) (bool, error) {
+	if !p.peek(tokenPunctuator, v) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+func (p *parser) expect(v string) error {
+	if !p.peek(tokenPunctuator, v) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return syntaxError(p.tok.pos, "unexpected end of document")
+	}
+	return syntaxError(p.tok.pos, "unexpected %q", p.tok.value)
+}
+
+func (p *parser) operation() (*operation, error) {
+	op := &operation{kind: p.tok.value}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokenName {
+		op.name = p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if ok, err := p.skip("("); err != nil {
+		return nil, err
+	} else if ok {
+		for !p.peek(tokenPunctuator, ")") {
+			v, err := p.variableDefinition()
+			if err != nil {
+				return nil, err
+			}
+			op.variables = append(op.variables, v)
+		}
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek(tokenPunctuator, "@") {
+		return nil, fmt.Errorf("%w: directives of operations are not supported", ErrInvalidDocument)
+	}
+
+	sel, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selection = sel
+	return op, nil
+}
+
+func (p *parser) variableDefinition() (*variableDefinition, error) {
+	if err := p.expect("$"); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	typ, err := p.typeRef()
+	if err != nil {
+		return nil, err
+	}
+
+	v := &variableDefinition{name: name, typ: typ}
+	if ok, err := p.skip("="); err != nil {
+		return nil, err
+	} else if ok {
+		if v.defValue, err = p.value(true); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (p *parser) typeRef() (typeRef, error) {
+	var t typeRef
+	if ok, err := p.skip("["); err != nil {
+		return t, err
+	} else if ok {
+		elem, err := p.typeRef()
+		if err != nil {
+			return t, err
+		}
+		if err := p.expect("]"); err != nil {
+			return t, err
+		}
+		t.elem = &elem
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return t, err
+		}
+		t.name = name
+	}
+
+	ok, err := p.skip("!")
+	t.nonNull = ok
+	return t, err
+}
+
+func (p *parser) fragment() (*fragment, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if name == "on" {
+		return nil, syntaxError(p.tok.pos, "fragment cannot be named %q", name)
+	}
+	if !p.peek(tokenName, "on") {
+		return nil, p.unexpected()
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	typeCondition, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek(tokenPunctuator, "@") {
+		return nil, fmt.Errorf("%w: directives of fragment definitions are not supported", ErrInvalidDocument)
+	}
+
+	sel, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	return &fragment{name: name, typeCondition: typeCondition, selection: sel}, nil
+}
+
+func (p *parser) selectionSet() ([]selection, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	var sel []selection
+	for !p.peek(tokenPunctuator, "}") {
+		s, err := p.selection()
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, s)
+	}
+	if len(sel) == 0 {
+		return nil, syntaxError(p.tok.pos, "empty selection set")
+	}
+	return sel, p.advance()
+}
+
+func (p *parser) selection() (selection, error) {
+	pos := p.tok.pos
+	if ok, err := p.skip("..."); err != nil {
+		return nil, err
+	} else if ok {
+		return p.fragmentSelection(pos)
+	}
+
+	f := &field{pos: pos}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		f.alias = name
+		if name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	f.name = name
+
+	if f.arguments, err = p.arguments(); err != nil {
+		return nil, err
+	}
+	if f.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek(tokenPunctuator, "{") {
+		if f.selection, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) fragmentSelection(pos position) (selection, error) {
+	if p.tok.kind == tokenName && p.tok.value != "on" {
+		name := p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		directives, err := p.directives()
+		if err != nil {
+			return nil, err
+		}
+		return &fragmentSpread{name: name, directives: directives, pos: pos}, nil
+	}
+
+	f := &inlineFragment{}
+	if p.peek(tokenName, "on") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		f.typeCondition = name
+	}
+
+	var err error
+	if f.directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if f.selection, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *parser) arguments() (map[string]value, error) {
+	if ok, err := p.skip("("); err != nil || !ok {
+		return nil, err
+	}
+
+	args := make(map[string]value)
+	for !p.peek(tokenPunctuator, ")") {
+		pos := p.tok.pos
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := args[name]; ok {
+			return nil, syntaxError(pos, "argument %q is given more than once", name)
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if args[name], err = p.value(false); err != nil {
+			return nil, err
+		}
+	}
+	if len(args) == 0 {
+		return nil, syntaxError(p.tok.pos, "empty arguments")
+	}
+	return args, p.advance()
+}
+
+func (p *parser) directives() ([]*directive, error) {
+	var directives []*directive
+	for p.peek(tokenPunctuator, "@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		args, err := p.arguments()
+		if err != nil {
+			return nil, err
+		}
+		directives = append(directives, &directive{name: name, arguments: args})
+	}
+	return directives, nil
+}
+
+// value parses a literal, constant ones do not accept variables.
+func (p *parser) value(constant bool) (value, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokenPunctuator:
+		switch tok.value {
+		case "$":
+			if constant {
+				return nil, p.unexpected()
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			return variable(name), err
+		case "[":
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			list := []value{}
+			for !p.peek(tokenPunctuator, "]") {
+				v, err := p.value(constant)
+				if err != nil {
+					return nil, err
+				}
+				list = append(list, v)
+			}
+			return list, p.advance()
+		case "{":
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			obj := make(map[string]value)
+			for !p.peek(tokenPunctuator, "}") {
+				name, err := p.name()
+				if err != nil {
+					return nil, err
+				}
+				if err := p.expect(":"); err != nil {
+					return nil, err
+				}
+				if obj[name], err = p.value(constant); err != nil {
+					return nil, err
+				}
+			}
+			return obj, p.advance()
+		}
+	case tokenInt:
+		n, err := strconv.ParseInt(tok.value, 10, 64)
+		if err != nil {
+			return nil, syntaxError(tok.pos, "invalid integer %s", tok.value)
+		}
+		return n, p.advance()
+	case tokenFloat:
+		f, err := strconv.ParseFloat(tok.value, 64)
+		if err != nil {
+			return nil, syntaxError(tok.pos, "invalid float %s", tok.value)
+		}
+		return f, p.advance()
+	case tokenString:
+		return tok.value, p.advance()
+	case tokenName:
+		switch tok.value {
+		case "true":
+			return true, p.advance()
+		case "false":
+			return false, p.advance()
+		case "null":
+			return nil, p.advance()
+		}
+		return enumValue(tok.value), p.advance()
+	}
+	return nil, p.unexpected()
+}
+
+func syntaxError(pos position, format string, a ...interface{}) error {
+	return fmt.Errorf("%w: %d:%d: %s", ErrSyntax, pos.line, pos.column, fmt.Sprintf(format, a...))
+}
@@ -0,0 +1,490 @@
+package graphql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authendpoint"
+	"github.com/ichigozero/gtdkit/backend/tasksvc"
+	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/taskendpoint"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/userendpoint"
+)
+
+var (
+	ErrUnauthenticated = errors.New("caller is not authenticated")
+	ErrInvalidID       = errors.New("invalid ID")
+)
+
+// Endpoints are the clients of the services the fields are resolved with.
+type Endpoints struct {
+	Tasks taskendpoint.Set
+	Auth  authendpoint.Set
+	Users userendpoint.Set
+}
+
+type contextKey string
+
+const loaderContextKey contextKey = "Loader"
+
+// loader batches the calls of a request: however many fields need the
+// tasks, the profile or the sessions of the caller, each is fetched once.
+// The task fields are resolved from the tasks of the caller, rather than
+// one call per task. The mutations reset the tasks, so that the fields
+// selected after them see the change.
+type loader struct {
+	endpoints Endpoints
+	userID    uint64
+	calls     map[string]*call
+}
+
+type call struct {
+	v   interface{}
+	err error
+}
+
+func newLoader(endpoints Endpoints, userID uint64) *loader {
+	return &loader{endpoints: endpoints, userID: userID, calls: make(map[string]*call)}
+}
+
+func loaderFrom(ctx context.Context) (*loader, error) {
+	l, ok := ctx.Value(loaderContextKey).(*loader)
+	if !ok || l.userID == 0 {
+		return nil, ErrUnauthenticated
+	}
+	return l, nil
+}
+
+func (l *loader) load(key string, fn func() (interface{}, error)) (interface{}, error) {
+	if c, ok := l.calls[key]; ok {
+		return c.v, c.err
+	}
+	v, err := fn()
+	l.calls[key] = &call{v: v, err: err}
+	return v, err
+}
+
+func (l *loader) profile(ctx context.Context) (usersvc.User, error) {
+	v, err := l.load("profile", func() (interface{}, error) {
+		response, err := l.endpoints.Users.ProfileEndpoint(ctx, userendpoint.ProfileRequest{ID: l.userID})
+		if err != nil {
+			return nil, err
+		}
+
+		resp := response.(userendpoint.ProfileResponse)
+		return resp.User, resp.Err
+	})
+	if err != nil {
+		return usersvc.User{}, err
+	}
+	return v.(usersvc.User), nil
+}
+
+func (l *loader) tasks(ctx context.Context) ([]tasksvc.Task, error) {
+	v, err := l.load("tasks", func() (interface{}, error) {
+		response, err := l.endpoints.Tasks.TasksEndpoint(ctx, taskendpoint.TasksRequest{})
+		if err != nil {
+			return nil, err
+		}
+
+		resp := response.(taskendpoint.TasksResponse)
+		return resp.Tasks, resp.Err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]tasksvc.Task), nil
+}
+
+// task returns the task of the caller, nil if there is none with the ID.
+func (l *loader) task(ctx context.Context, id uint64) (*tasksvc.Task, error) {
+	tasks, err := l.tasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range tasks {
+		if tasks[i].ID == id {
+			return &tasks[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (l *loader) sessions(ctx context.Context) ([]authsvc.Session, error) {
+	v, err := l.load("sessions", func() (interface{}, error) {
+		response, err := l.endpoints.Auth.SessionsEndpoint(ctx, authendpoint.SessionsRequest{})
+		if err != nil {
+			return nil, err
+		}
+
+		resp := response.(authendpoint.SessionsResponse)
+		return resp.Sessions, resp.Err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]authsvc.Session), nil
+}
+
+func (l *loader) resetTasks() {
+	delete(l.calls, "tasks")
+}
+
+// Time is written in RFC 3339.
+var Time = &Scalar{
+	Name: "Time",
+	Serialize: func(v interface{}) (interface{}, error) {
+		t, ok := v.(time.Time)
+		if !ok {
+			return nil, fmt.Errorf("Time cannot represent %v", v)
+		}
+		return t.UTC().Format(time.RFC3339), nil
+	},
+	Parse: func(v interface{}) (interface{}, error) {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("Time cannot represent %v", v)
+		}
+		return time.Parse(time.RFC3339, s)
+	},
+}
+
+// NewSchema returns the schema of the gateway, covering the caller and
+// their tasks and sessions.
+func NewSchema() *Schema {
+	session := &Object{
+		Name:        "Session",
+		Description: "A signed in session of the caller.",
+		Fields: []*Field{
+			{Name: "id", Type: &NonNull{Of: ID}, Resolve: sessionField(func(s authsvc.Session) interface{} { return s.AccessUUID })},
+			{Name: "userAgent", Type: String, Resolve: sessionField(func(s authsvc.Session) interface{} { return optional(s.UserAgent) })},
+			{Name: "ip", Type: String, Resolve: sessionField(func(s authsvc.Session) interface{} { return optional(s.IP) })},
+			{Name: "clientId", Description: "Set on the sessions of third-party clients.", Type: String, Resolve: sessionField(func(s authsvc.Session) interface{} { return optional(s.ClientID) })},
+			{Name: "scopes", Type: &NonNull{Of: &List{Of: &NonNull{Of: String}}}, Resolve: sessionField(func(s authsvc.Session) interface{} { return s.Scopes })},
+			{Name: "current", Description: "Whether the request was made with this session.", Type: &NonNull{Of: Boolean}, Resolve: sessionField(func(s authsvc.Session) interface{} { return s.Current })},
+			{Name: "createdAt", Type: &NonNull{Of: Time}, Resolve: sessionField(func(s authsvc.Session) interface{} { return s.CreatedAt })},
+			{Name: "lastUsedAt", Type: &NonNull{Of: Time}, Resolve: sessionField(func(s authsvc.Session) interface{} { return s.LastUsedAt })},
+			{Name: "expiresAt", Type: &NonNull{Of: Time}, Resolve: sessionField(func(s authsvc.Session) interface{} { return s.ExpiresAt })},
+		},
+	}
+
+	user := &Object{Name: "User", Description: "The caller."}
+	task := &Object{
+		Name: "Task",
+		Fields: []*Field{
+			{Name: "id", Type: &NonNull{Of: ID}, Resolve: taskField(func(t tasksvc.Task) interface{} { return t.ID })},
+			{Name: "title", Type: &NonNull{Of: String}, Resolve: taskField(func(t tasksvc.Task) interface{} { return t.Title })},
+			{Name: "description", Type: &NonNull{Of: String}, Resolve: taskField(func(t tasksvc.Task) interface{} { return t.Description })},
+			{Name: "done", Type: &NonNull{Of: Boolean}, Resolve: taskField(func(t tasksvc.Task) interface{} { return t.Done })},
+			{
+				Name:        "owner",
+				Description: "The tasks are only visible to their owner, the caller.",
+				Type:        &NonNull{Of: user},
+				Resolve: func(ctx context.Context, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+					return resolveMe(ctx)
+				},
+			},
+		},
+	}
+
+	tasksField := &Field{
+		Name:        "tasks",
+		Description: "The tasks of the caller, filtered by the given arguments.",
+		Args:        taskFilterArgs,
+		Type:        &NonNull{Of: &List{Of: &NonNull{Of: task}}},
+		Resolve:     resolveTasks,
+	}
+
+	user.Fields = []*Field{
+		{Name: "id", Type: &NonNull{Of: ID}, Resolve: userField(func(u usersvc.User) interface{} { return u.ID })},
+		{Name: "name", Type: &NonNull{Of: String}, Resolve: userField(func(u usersvc.User) interface{} { return u.Name })},
+		{Name: "email", Type: String, Resolve: userField(func(u usersvc.User) interface{} { return optional(u.Email) })},
+		{Name: "roles", Type: &NonNull{Of: &List{Of: &NonNull{Of: String}}}, Resolve: userField(func(u usersvc.User) interface{} { return []string(u.Roles) })},
+		{Name: "totpEnabled", Type: &NonNull{Of: Boolean}, Resolve: userField(func(u usersvc.User) interface{} { return u.TOTPEnabled })},
+		{Name: "lastLoginAt", Type: Time, Resolve: userField(func(u usersvc.User) interface{} {
+			if u.LastLoginAt == nil {
+				return nil
+			}
+			return *u.LastLoginAt
+		})},
+		tasksField,
+		{
+			Name: "sessions",
+			Type: &NonNull{Of: &List{Of: &NonNull{Of: session}}},
+			Resolve: func(ctx context.Context, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+				l, err := loaderFrom(ctx)
+				if err != nil {
+					return nil, err
+				}
+				return l.sessions(ctx)
+			},
+		},
+	}
+
+	query := &Object{
+		Name: "Query",
+		Fields: []*Field{
+			{
+				Name: "me",
+				Type: &NonNull{Of: user},
+				Resolve: func(ctx context.Context, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+					return resolveMe(ctx)
+				},
+			},
+			tasksField,
+			{
+				Name:        "task",
+				Description: "The task of the caller with the ID, null if there is none.",
+				Args:        []*Argument{{Name: "id", Type: &NonNull{Of: ID}}},
+				Type:        task,
+				Resolve: func(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+					l, err := loaderFrom(ctx)
+					if err != nil {
+						return nil, err
+					}
+					id, err := parseID(args["id"])
+					if err != nil {
+						return nil, err
+					}
+					return l.task(ctx, id)
+				},
+			},
+		},
+	}
+
+	mutation := &Object{
+		Name: "Mutation",
+		Fields: []*Field{
+			{
+				Name: "createTask",
+				Args: []*Argument{
+					{Name: "title", Type: &NonNull{Of: String}},
+					{Name: "description", Type: String},
+				},
+				Type:    &NonNull{Of: task},
+				Resolve: resolveCreateTask,
+			},
+			{
+				Name:        "updateTask",
+				Description: "Updates the given fields of the task, the others are kept.",
+				Args: []*Argument{
+					{Name: "id", Type: &NonNull{Of: ID}},
+					{Name: "title", Type: String},
+					{Name: "description", Type: String},
+					{Name: "done", Type: Boolean},
+				},
+				Type:    &NonNull{Of: task},
+				Resolve: resolveUpdateTask,
+			},
+			{
+				Name:    "deleteTask",
+				Args:    []*Argument{{Name: "id", Type: &NonNull{Of: ID}}},
+				Type:    &NonNull{Of: Boolean},
+				Resolve: resolveDeleteTask,
+			},
+		},
+	}
+
+	return &Schema{Query: query, Mutation: mutation}
+}
+
+var taskFilterArgs = []*Argument{
+	{Name: "done", Description: "Only the tasks done, or not done.", Type: Boolean},
+	{Name: "search", Description: "Matches the title or the description, case-insensitively.", Type: String},
+	{Name: "offset", Description: "Number of tasks skipped.", Type: Int},
+	{Name: "first", Description: "Maximum number of tasks returned.", Type: Int},
+}
+
+func resolveMe(ctx context.Context) (interface{}, error) {
+	l, err := loaderFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return l.profile(ctx)
+}
+
+func resolveTasks(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	l, err := loaderFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := l.tasks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	done, filterDone := args["done"].(bool)
+	search, _ := args["search"].(string)
+	search = strings.ToLower(search)
+
+	filtered := []tasksvc.Task{}
+	for _, t := range tasks {
+		if filterDone && t.Done != done {
+			continue
+		}
+		if search != "" && !strings.Contains(strings.ToLower(t.Title), search) && !strings.Contains(strings.ToLower(t.Description), search) {
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+
+	if offset, ok := args["offset"].(int); ok {
+		if offset < 0 {
+			return nil, tasksvc.ErrInvalidArgument
+		}
+		if offset > len(filtered) {
+			offset = len(filtered)
+		}
+		filtered = filtered[offset:]
+	}
+	if first, ok := args["first"].(int); ok {
+		if first < 0 {
+			return nil, tasksvc.ErrInvalidArgument
+		}
+		if first < len(filtered) {
+			filtered = filtered[:first]
+		}
+	}
+	return filtered, nil
+}
+
+func resolveCreateTask(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	l, err := loaderFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	req := taskendpoint.CreateTaskRequest{}
+	req.Title, _ = args["title"].(string)
+	req.Description, _ = args["description"].(string)
+
+	response, err := l.endpoints.Tasks.CreateTaskEndpoint(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	l.resetTasks()
+
+	resp := response.(taskendpoint.CreateTaskResponse)
+	return resp.Task, resp.Err
+}
+
+// resolveUpdateTask reads the task first, as the update of tasksvc resets
+// the fields it is not given.
+func resolveUpdateTask(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	l, err := loaderFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseID(args["id"])
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := l.endpoints.Tasks.TaskEndpoint(ctx, taskendpoint.TaskRequest{TaskID: id})
+	if err != nil {
+		return nil, err
+	}
+	current := response.(taskendpoint.TaskResponse)
+	if current.Err != nil {
+		return nil, current.Err
+	}
+
+	req := taskendpoint.UpdateTaskRequest{
+		TaskID:      id,
+		Title:       current.Task.Title,
+		Description: current.Task.Description,
+		Done:        current.Task.Done,
+	}
+	if v, ok := args["title"].(string); ok {
+		req.Title = v
+	}
+	if v, ok := args["description"].(string); ok {
+		req.Description = v
+	}
+	if v, ok := args["done"].(bool); ok {
+		req.Done = v
+	}
+
+	response, err = l.endpoints.Tasks.UpdateTaskEndpoint(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	l.resetTasks()
+
+	resp := response.(taskendpoint.UpdateTaskResponse)
+	return resp.Task, resp.Err
+}
+
+func resolveDeleteTask(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+	l, err := loaderFrom(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := parseID(args["id"])
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := l.endpoints.Tasks.DeleteTaskEndpoint(ctx, taskendpoint.DeleteTaskRequest{TaskID: id})
+	if err != nil {
+		return nil, err
+	}
+	l.resetTasks()
+
+	resp := response.(taskendpoint.DeleteTaskResponse)
+	return resp.Result, resp.Err
+}
+
+func parseID(v interface{}) (uint64, error) {
+	s, _ := v.(string)
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || id == 0 {
+		return 0, ErrInvalidID
+	}
+	return id, nil
+}
+
+// optional returns nil for the empty strings, which are null.
+func optional(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func taskField(get func(tasksvc.Task) interface{}) ResolveFunc {
+	return func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+		switch t := source.(type) {
+		case tasksvc.Task:
+			return get(t), nil
+		case *tasksvc.Task:
+			return get(*t), nil
+		}
+		return nil, fmt.Errorf("unexpected task %T", source)
+	}
+}
+
+func userField(get func(usersvc.User) interface{}) ResolveFunc {
+	return func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+		u, ok := source.(usersvc.User)
+		if !ok {
+			return nil, fmt.Errorf("unexpected user %T", source)
+		}
+		return get(u), nil
+	}
+}
+
+func sessionField(get func(authsvc.Session) interface{}) ResolveFunc {
+	return func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+		s, ok := source.(authsvc.Session)
+		if !ok {
+			return nil, fmt.Errorf("unexpected session %T", source)
+		}
+		return get(s), nil
+	}
+}
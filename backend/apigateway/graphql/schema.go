@@ -0,0 +1,306 @@
+package graphql
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Type is a Scalar, an Object, a List or a NonNull.
+type Type interface {
+	String() string
+}
+
+// Scalar is a leaf type. Serialize converts the value returned by a
+// resolver for the response, Parse converts the value of an argument,
+// decoded from the document or the JSON variables.
+type Scalar struct {
+	Name      string
+	Serialize func(interface{}) (interface{}, error)
+	Parse     func(interface{}) (interface{}, error)
+}
+
+func (s *Scalar) String() string { return s.Name }
+
+type Object struct {
+	Name        string
+	Description string
+	Fields      []*Field
+}
+
+func (o *Object) String() string { return o.Name }
+
+func (o *Object) field(name string) *Field {
+	for _, f := range o.Fields {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+type List struct {
+	Of Type
+}
+
+func (l *List) String() string { return "[" + l.Of.String() + "]" }
+
+type NonNull struct {
+	Of Type
+}
+
+func (n *NonNull) String() string { return n.Of.String() + "!" }
+
+// ResolveFunc returns the value of a field of the source, the value
+// returned by the resolver of the parent field. The arguments are already
+// parsed, the omitted ones are missing from args.
+type ResolveFunc func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error)
+
+type Field struct {
+	Name        string
+	Description string
+	Args        []*Argument
+	Type        Type
+	Resolve     ResolveFunc
+}
+
+func (f *Field) arg(name string) *Argument {
+	for _, a := range f.Args {
+		if a.Name == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// Argument is an argument of a field. Only scalars, and lists and non-null
+// scalars, are accepted as arguments.
+type Argument struct {
+	Name        string
+	Description string
+	Type        Type
+}
+
+// Schema holds the root types. Mutation is optional.
+type Schema struct {
+	Query    *Object
+	Mutation *Object
+}
+
+// String returns the schema in the GraphQL schema definition language, for
+// the clients and the tools which generate them.
+func (s *Schema) String() string {
+	var (
+		objects = make(map[string]*Object)
+		scalars = make(map[string]bool)
+		visit   func(Type)
+	)
+	visit = func(t Type) {
+		switch t := t.(type) {
+		case *NonNull:
+			visit(t.Of)
+		case *List:
+			visit(t.Of)
+		case *Scalar:
+			scalars[t.Name] = true
+		case *Object:
+			if _, ok := objects[t.Name]; ok {
+				return
+			}
+			objects[t.Name] = t
+			for _, f := range t.Fields {
+				visit(f.Type)
+				for _, a := range f.Args {
+					visit(a.Type)
+				}
+			}
+		}
+	}
+	visit(s.Query)
+	if s.Mutation != nil {
+		visit(s.Mutation)
+	}
+
+	var b strings.Builder
+	b.WriteString("schema {\n  query: " + s.Query.Name + "\n")
+	if s.Mutation != nil {
+		b.WriteString("  mutation: " + s.Mutation.Name + "\n")
+	}
+	b.WriteString("}\n")
+
+	var names []string
+	for name := range scalars {
+		if !builtinScalars[name] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString("\nscalar " + name + "\n")
+	}
+
+	names = names[:0]
+	for name := range objects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		o := objects[name]
+		b.WriteString("\n")
+		writeDescription(&b, "", o.Description)
+		b.WriteString("type " + o.Name + " {\n")
+		for _, f := range o.Fields {
+			writeDescription(&b, "  ", f.Description)
+			b.WriteString("  " + f.Name)
+			if len(f.Args) > 0 {
+				args := make([]string, len(f.Args))
+				for i, a := range f.Args {
+					args[i] = a.Name + ": " + a.Type.String()
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + f.Type.String() + "\n")
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+func writeDescription(b *strings.Builder, indent, description string) {
+	if description != "" {
+		b.WriteString(indent + strconv.Quote(description) + "\n")
+	}
+}
+
+var builtinScalars = map[string]bool{"Int": true, "Float": true, "String": true, "Boolean": true, "ID": true}
+
+// The built-in scalars. The integers returned by the resolvers may be of
+// any Go integer type.
+var (
+	Int = &Scalar{
+		Name: "Int",
+		Serialize: func(v interface{}) (interface{}, error) {
+			n, ok := toInt64(v)
+			if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, fmt.Errorf("Int cannot represent %v", v)
+			}
+			return n, nil
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			n, ok := toInt64(v)
+			if !ok || n < math.MinInt32 || n > math.MaxInt32 {
+				return nil, fmt.Errorf("Int cannot represent %v", v)
+			}
+			return int(n), nil
+		},
+	}
+	Float = &Scalar{
+		Name: "Float",
+		Serialize: func(v interface{}) (interface{}, error) {
+			if f, ok := toFloat64(v); ok {
+				return f, nil
+			}
+			return nil, fmt.Errorf("Float cannot represent %v", v)
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			if f, ok := toFloat64(v); ok {
+				return f, nil
+			}
+			return nil, fmt.Errorf("Float cannot represent %v", v)
+		},
+	}
+	String = &Scalar{
+		Name: "String",
+		Serialize: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			return nil, fmt.Errorf("String cannot represent %v", v)
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			return nil, fmt.Errorf("String cannot represent %v", v)
+		},
+	}
+	Boolean = &Scalar{
+		Name: "Boolean",
+		Serialize: func(v interface{}) (interface{}, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, fmt.Errorf("Boolean cannot represent %v", v)
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			if b, ok := v.(bool); ok {
+				return b, nil
+			}
+			return nil, fmt.Errorf("Boolean cannot represent %v", v)
+		},
+	}
+	// ID is written as a string, and accepts strings and integers.
+	ID = &Scalar{
+		Name: "ID",
+		Serialize: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if n, ok := v.(uint64); ok {
+				return strconv.FormatUint(n, 10), nil
+			}
+			if n, ok := toInt64(v); ok {
+				return strconv.FormatInt(n, 10), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent %v", v)
+		},
+		Parse: func(v interface{}) (interface{}, error) {
+			if s, ok := v.(string); ok {
+				return s, nil
+			}
+			if n, ok := toInt64(v); ok {
+				return strconv.FormatInt(n, 10), nil
+			}
+			return nil, fmt.Errorf("ID cannot represent %v", v)
+		},
+	}
+)
+
+// toInt64 converts the integers, and the floats without a fractional part
+// which encoding/json decodes the numbers into.
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint32:
+		return int64(n), true
+	case uint64:
+		if n > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(n), true
+	case float64:
+		if n != math.Trunc(n) || n < math.MinInt64 || n > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(n), true
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	if f, ok := v.(float64); ok {
+		return f, true
+	}
+	if n, ok := toInt64(v); ok {
+		return float64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,292 @@
+// Package headers sets the response headers the browser frontend relies
+// on: CORS, as the frontend is served from another origin than the
+// gateway, and the security headers. Both are configured per route prefix,
+// the longest matching prefix applies.
+package headers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidRule = errors.New("invalid header rule")
+
+// Duration is a time.Duration written as a string like "10m" in the
+// config file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// CORSRule allows the cross-origin requests below Prefix. A rule without
+// origins refuses them, "*" allows any origin but cannot be combined with
+// credentials.
+type CORSRule struct {
+	Prefix           string   `json:"prefix"`
+	AllowedOrigins   []string `json:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods,omitempty"`
+	AllowedHeaders   []string `json:"allowed_headers,omitempty"`
+	ExposedHeaders   []string `json:"exposed_headers,omitempty"`
+	AllowCredentials bool     `json:"allow_credentials,omitempty"`
+	// MaxAge is how long the browser may cache the preflight response.
+	MaxAge Duration `json:"max_age,omitempty"`
+}
+
+// SecurityRule holds the security headers of the responses below Prefix.
+// Empty headers are not set.
+type SecurityRule struct {
+	Prefix                  string `json:"prefix"`
+	StrictTransportSecurity string `json:"strict_transport_security,omitempty"`
+	ContentSecurityPolicy   string `json:"content_security_policy,omitempty"`
+	FrameOptions            string `json:"frame_options,omitempty"`
+	ContentTypeOptions      string `json:"content_type_options,omitempty"`
+	ReferrerPolicy          string `json:"referrer_policy,omitempty"`
+}
+
+type Config struct {
+	CORS     []CORSRule     `json:"cors"`
+	Security []SecurityRule `json:"security"`
+}
+
+// DefaultConfig allows the given origins, typically the one of the
+// frontend, to call every route but those of the administrators, served
+// both by usersvc's admin handler and by the REST mapping of its RPCs. The
+// OIDC routes are called with credentials, as the login state is bound to
+// the browser by a cookie. The responses of the gateway are JSON only, so
+// they may neither load resources nor be framed.
+func DefaultConfig(origins []string) Config {
+	return Config{
+		CORS: []CORSRule{
+			{
+				Prefix:         "/",
+				AllowedOrigins: origins,
+				AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+				AllowedHeaders: []string{"Authorization", "Content-Type", "Last-Event-ID"},
+				ExposedHeaders: []string{"RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "RateLimit-Policy", "Retry-After"},
+				MaxAge:         Duration(10 * time.Minute),
+			},
+			{
+				Prefix:           "/auth/v1/oidc/",
+				AllowedOrigins:   origins,
+				AllowedMethods:   []string{"GET", "POST"},
+				AllowedHeaders:   []string{"Content-Type"},
+				AllowCredentials: !contains(origins, "*"),
+				MaxAge:           Duration(10 * time.Minute),
+			},
+			{Prefix: "/admin/v1/", AllowedOrigins: []string{}},
+			{Prefix: "/api/v1/admin/", AllowedOrigins: []string{}},
+		},
+		Security: []SecurityRule{
+			{
+				Prefix:                  "/",
+				StrictTransportSecurity: "max-age=63072000; includeSubDomains",
+				ContentSecurityPolicy:   "default-src 'none'; frame-ancestors 'none'",
+				FrameOptions:            "DENY",
+				ContentTypeOptions:      "nosniff",
+				ReferrerPolicy:          "no-referrer",
+			},
+		},
+	}
+}
+
+// ParseOrigins splits a comma separated list of origins.
+func ParseOrigins(s string) []string {
+	var origins []string
+	for _, origin := range strings.Split(s, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// LoadConfig reads the config from a JSON file.
+func LoadConfig(path string) (Config, error) {
+	var c Config
+
+	f, err := os.Open(path)
+	if err != nil {
+		return c, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&c); err != nil {
+		return c, err
+	}
+	return c, c.validate()
+}
+
+func (c Config) validate() error {
+	for _, rule := range c.CORS {
+		if !strings.HasPrefix(rule.Prefix, "/") {
+			return fmt.Errorf("%w: prefix %q", ErrInvalidRule, rule.Prefix)
+		}
+		if rule.AllowCredentials && contains(rule.AllowedOrigins, "*") {
+			return fmt.Errorf("%w: credentials cannot be allowed for any origin", ErrInvalidRule)
+		}
+	}
+	for _, rule := range c.Security {
+		if !strings.HasPrefix(rule.Prefix, "/") {
+			return fmt.Errorf("%w: prefix %q", ErrInvalidRule, rule.Prefix)
+		}
+	}
+	return nil
+}
+
+// Headers provides the middlewares of the config.
+type Headers struct {
+	config Config
+}
+
+func New(c Config) (*Headers, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &Headers{config: c}, nil
+}
+
+// CORS answers the preflight requests, which must therefore reach it
+// before any authentication, and allows the other cross-origin requests
+// by their response headers.
+func (h *Headers) CORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		rule, ok := h.corsRule(r.URL.Path)
+		if origin == "" || !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		hdr := w.Header()
+		hdr.Add("Vary", "Origin")
+
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			hdr.Add("Vary", "Access-Control-Request-Method")
+			hdr.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if !rule.allows(origin) {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if contains(rule.AllowedOrigins, "*") && !rule.AllowCredentials {
+			hdr.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			hdr.Set("Access-Control-Allow-Origin", origin)
+		}
+		if rule.AllowCredentials {
+			hdr.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(rule.ExposedHeaders) > 0 {
+				hdr.Set("Access-Control-Expose-Headers", strings.Join(rule.ExposedHeaders, ", "))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if len(rule.AllowedMethods) > 0 {
+			hdr.Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+		}
+		if len(rule.AllowedHeaders) > 0 {
+			hdr.Set("Access-Control-Allow-Headers", strings.Join(rule.AllowedHeaders, ", "))
+		}
+		if rule.MaxAge > 0 {
+			hdr.Set("Access-Control-Max-Age", strconv.Itoa(int(time.Duration(rule.MaxAge).Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// Security sets the security headers before the request is handled, so
+// that the error responses carry them as well.
+func (h *Headers) Security(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rule, ok := h.securityRule(r.URL.Path); ok {
+			hdr := w.Header()
+			for name, value := range map[string]string{
+				"Strict-Transport-Security": rule.StrictTransportSecurity,
+				"Content-Security-Policy":   rule.ContentSecurityPolicy,
+				"X-Frame-Options":           rule.FrameOptions,
+				"X-Content-Type-Options":    rule.ContentTypeOptions,
+				"Referrer-Policy":           rule.ReferrerPolicy,
+			} {
+				if value != "" {
+					hdr.Set(name, value)
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (h *Headers) corsRule(path string) (CORSRule, bool) {
+	var (
+		match CORSRule
+		found bool
+	)
+	for _, rule := range h.config.CORS {
+		if strings.HasPrefix(path, rule.Prefix) && (!found || len(rule.Prefix) > len(match.Prefix)) {
+			match, found = rule, true
+		}
+	}
+	return match, found
+}
+
+func (h *Headers) securityRule(path string) (SecurityRule, bool) {
+	var (
+		match SecurityRule
+		found bool
+	)
+	for _, rule := range h.config.Security {
+		if strings.HasPrefix(path, rule.Prefix) && (!found || len(rule.Prefix) > len(match.Prefix)) {
+			match, found = rule, true
+		}
+	}
+	return match, found
+}
+
+func (rule CORSRule) allows(origin string) bool {
+	for _, o := range rule.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
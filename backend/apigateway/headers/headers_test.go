@@ -0,0 +1,91 @@
+package headers_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ichigozero/gtdkit/backend/apigateway/headers"
+)
+
+// TestAdminRoutesRefuseCrossOrigin checks that the frontend origin may call
+// the routes of the users but none of the administrators, whichever way
+// they are mounted, with the default config and the shipped one alike.
+func TestAdminRoutesRefuseCrossOrigin(t *testing.T) {
+	t.Parallel()
+
+	shipped, err := headers.LoadConfig("../headers.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, c := range map[string]headers.Config{
+		"default": headers.DefaultConfig([]string{"http://localhost:3000"}),
+		"shipped": shipped,
+	} {
+		h, err := headers.New(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := h.CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		for path, code := range map[string]int{
+			"/task/v1/tasks":        http.StatusNoContent,
+			"/api/v1/tasks":         http.StatusNoContent,
+			"/admin/v1/users":       http.StatusForbidden,
+			"/api/v1/admin/users":   http.StatusForbidden,
+			"/api/v1/admin/users/1": http.StatusForbidden,
+		} {
+			r := httptest.NewRequest(http.MethodOptions, path, nil)
+			r.Header.Set("Origin", "http://localhost:3000")
+			r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != code {
+				t.Errorf("%s %s: want %d, got %d", name, path, code, w.Code)
+			}
+		}
+	}
+}
+
+// TestOIDCRoutesAllowCredentials checks that the frontend origin may send
+// the cookie binding the OIDC login state, and only to the OIDC routes.
+func TestOIDCRoutesAllowCredentials(t *testing.T) {
+	t.Parallel()
+
+	shipped, err := headers.LoadConfig("../headers.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, c := range map[string]headers.Config{
+		"default": headers.DefaultConfig([]string{"http://localhost:3000"}),
+		"shipped": shipped,
+	} {
+		h, err := headers.New(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		handler := h.CORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		for path, want := range map[string]string{
+			"/auth/v1/oidc/sso/callback": "true",
+			"/auth/v1/login":             "",
+		} {
+			r := httptest.NewRequest(http.MethodOptions, path, nil)
+			r.Header.Set("Origin", "http://localhost:3000")
+			r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if got := w.Header().Get("Access-Control-Allow-Credentials"); got != want {
+				t.Errorf("%s %s: want credentials %q, got %q", name, path, want, got)
+			}
+		}
+	}
+
+	if _, err := headers.New(headers.DefaultConfig([]string{"*"})); err != nil {
+		t.Errorf("default config for any origin: %v", err)
+	}
+}
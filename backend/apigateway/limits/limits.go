@@ -0,0 +1,316 @@
+// Package limits bounds the requests handled by the gateway: the size of
+// their bodies and the time they may take. Both are configured per route
+// prefix, the longest matching prefix applies. The streams of events, GET
+// on one of the stream routes given to New, are exempt: they last as long
+// as their clients want.
+package limits
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type contextKey int
+
+const connContextKey contextKey = iota
+
+var (
+	ErrInvalidRule  = errors.New("invalid limit rule")
+	ErrBodyTooLarge = errors.New("request body too large")
+	ErrTimeout      = errors.New("request timed out")
+)
+
+// Duration is a time.Duration written as a string like "30s" in the
+// config file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Rule bounds the requests below Prefix. A zero value leaves them
+// unbounded.
+type Rule struct {
+	Prefix string `json:"prefix"`
+	// MaxBodyBytes is the size of the largest body accepted, the others
+	// are refused with 413.
+	MaxBodyBytes int64 `json:"max_body_bytes,omitempty"`
+	// Timeout is the deadline of the context of the request, which the
+	// clients of the services pass on to them. The requests failing past
+	// it are answered with 504.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+//go:embed limits.json
+var defaultConfig string
+
+// DefaultConfig is used when no config file is given. It is read from
+// limits.json, which is the example of a config file as well. The syncs of
+// offline clients may carry larger bodies than the other routes, the
+// routes of authsvc smaller ones.
+var DefaultConfig = func() Config {
+	c, err := decodeConfig(strings.NewReader(defaultConfig))
+	if err != nil {
+		panic(err)
+	}
+	return c
+}()
+
+// LoadConfig reads the config from a JSON file.
+func LoadConfig(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer f.Close()
+
+	return decodeConfig(f)
+}
+
+func decodeConfig(r io.Reader) (Config, error) {
+	var c Config
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return c, err
+	}
+	return c, c.validate()
+}
+
+func (c Config) validate() error {
+	for _, rule := range c.Rules {
+		if !strings.HasPrefix(rule.Prefix, "/") {
+			return fmt.Errorf("%w: prefix %q", ErrInvalidRule, rule.Prefix)
+		}
+		if rule.MaxBodyBytes < 0 || rule.Timeout < 0 {
+			return fmt.Errorf("%w: negative limit for %q", ErrInvalidRule, rule.Prefix)
+		}
+	}
+	return nil
+}
+
+// Limits provides the middleware of the config.
+type Limits struct {
+	config      Config
+	streamPaths map[string]bool
+
+	once    sync.Once
+	closing chan struct{}
+}
+
+// New returns the limits of the config. The requests for GET on one of the
+// streamPaths are streams, whatever their headers say, so that no other
+// route escapes the limits.
+func New(c Config, streamPaths []string) (*Limits, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]bool, len(streamPaths))
+	for _, path := range streamPaths {
+		paths[path] = true
+	}
+	return &Limits{config: c, streamPaths: paths, closing: make(chan struct{})}, nil
+}
+
+// ConnContext is meant to be the ConnContext of the server. It keeps the
+// connection, so that the deadlines the server sets on it can be lifted
+// for the streams.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey, c)
+}
+
+// CloseStreams ends the streams, which the shutdown of the server does not
+// wait for. It is meant to be registered with RegisterOnShutdown.
+func (l *Limits) CloseStreams() {
+	l.once.Do(func() { close(l.closing) })
+}
+
+// Middleware applies the rule of the path. The bodies announced larger
+// than the limit are refused before the request is handled, the others are
+// cut at the limit, and the response of the handler is then replaced.
+func (l *Limits) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.isStream(r) {
+			l.serveStream(w, r, next)
+			return
+		}
+
+		rule, _ := l.rule(r.URL.Path)
+		if rule.MaxBodyBytes > 0 && r.ContentLength > rule.MaxBodyBytes {
+			encodeError(w, http.StatusRequestEntityTooLarge, ErrBodyTooLarge)
+			return
+		}
+
+		if rule.Timeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), time.Duration(rule.Timeout))
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+
+		lw := &limitedWriter{ResponseWriter: w, ctx: r.Context()}
+		if rule.MaxBodyBytes > 0 && r.Body != nil && r.Body != http.NoBody {
+			lw.body = &limitedBody{ReadCloser: r.Body, remaining: rule.MaxBodyBytes}
+			r.Body = lw.body
+		}
+		next.ServeHTTP(lw, r)
+	})
+}
+
+// serveStream lifts the deadlines the server set on the connection, which
+// the stream outlives, and ends the stream once the server shuts down. Go
+// applies the write timeout to every HTTP/2 stream on its own, so the
+// streams served over HTTP/2 still end after it, and their clients resume
+// them from the last event.
+func (l *Limits) serveStream(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	if c, ok := r.Context().Value(connContextKey).(net.Conn); ok && r.ProtoMajor == 1 {
+		c.SetDeadline(time.Time{})
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		select {
+		case <-l.closing:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+func (l *Limits) isStream(r *http.Request) bool {
+	return r.Method == http.MethodGet && l.streamPaths[r.URL.Path]
+}
+
+func (l *Limits) rule(path string) (Rule, bool) {
+	var (
+		match Rule
+		found bool
+	)
+	for _, rule := range l.config.Rules {
+		if strings.HasPrefix(path, rule.Prefix) && (!found || len(rule.Prefix) > len(match.Prefix)) {
+			match, found = rule, true
+		}
+	}
+	return match, found
+}
+
+// limitedBody fails with ErrBodyTooLarge once more than remaining bytes
+// are read.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, ErrBodyTooLarge
+	}
+
+	if int64(len(p)) > b.remaining+1 {
+		p = p[:b.remaining+1]
+	}
+	n, err := b.ReadCloser.Read(p)
+	if int64(n) <= b.remaining {
+		b.remaining -= int64(n)
+		return n, err
+	}
+
+	n = int(b.remaining)
+	b.remaining = 0
+	b.exceeded = true
+	return n, ErrBodyTooLarge
+}
+
+// limitedWriter replaces the response of the handler with the limit it
+// hit: 413 if the body was cut, whatever the handler made of it, or 504 if
+// the handler failed past the deadline.
+type limitedWriter struct {
+	http.ResponseWriter
+	ctx  context.Context
+	body *limitedBody
+
+	wroteHeader bool
+	replaced    bool
+}
+
+func (w *limitedWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	switch {
+	case w.body != nil && w.body.exceeded:
+		w.replaced = true
+		encodeError(w.ResponseWriter, http.StatusRequestEntityTooLarge, ErrBodyTooLarge)
+	case code >= http.StatusInternalServerError && errors.Is(w.ctx.Err(), context.DeadlineExceeded):
+		w.replaced = true
+		encodeError(w.ResponseWriter, http.StatusGatewayTimeout, ErrTimeout)
+	default:
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *limitedWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.replaced {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *limitedWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.replaced {
+		f.Flush()
+	}
+}
+
+// encodeError drops the headers the handler set for its own response.
+func encodeError(w http.ResponseWriter, code int, err error) {
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if code == http.StatusRequestEntityTooLarge {
+		// The rest of the body is not read.
+		w.Header().Set("Connection", "close")
+	}
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errorWrapper{Error: err.Error()})
+}
+
+type errorWrapper struct {
+	Error string `json:"error"`
+}
@@ -0,0 +1,83 @@
+package limits_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/apigateway/limits"
+)
+
+// TestStreamsExemptByRoute checks that only GET on a stream route escapes
+// the limits, whatever headers the request has.
+func TestStreamsExemptByRoute(t *testing.T) {
+	t.Parallel()
+
+	l, err := limits.New(limits.Config{
+		Rules: []limits.Rule{{Prefix: "/", MaxBodyBytes: 4, Timeout: limits.Duration(time.Minute)}},
+	}, []string{"/task/v1/stream"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			w.Header().Set("X-Deadline", "set")
+		}
+	}))
+
+	for _, tc := range []struct {
+		method, path string
+		body         string
+		deadline     bool
+		code         int
+	}{
+		{method: "GET", path: "/task/v1/stream", deadline: false, code: http.StatusOK},
+		{method: "GET", path: "/task/v1/stream", body: "too large", deadline: false, code: http.StatusOK},
+		{method: "POST", path: "/task/v1/stream", body: "too large", code: http.StatusRequestEntityTooLarge},
+		{method: "GET", path: "/task/v1/tasks", deadline: true, code: http.StatusOK},
+		{method: "GET", path: "/task/v1/stream/", deadline: true, code: http.StatusOK},
+		{method: "POST", path: "/auth/v1/login", body: "too large", code: http.StatusRequestEntityTooLarge},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		r.Header.Set("Accept", "text/event-stream")
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tc.code {
+			t.Errorf("%s %s: want %d, got %d", tc.method, tc.path, tc.code, w.Code)
+		}
+		if deadline := w.Header().Get("X-Deadline") != ""; tc.code == http.StatusOK && deadline != tc.deadline {
+			t.Errorf("%s %s: want deadline %v, got %v", tc.method, tc.path, tc.deadline, deadline)
+		}
+	}
+}
+
+// TestDefaultConfigBoundsEveryRoute checks that every rule of the config
+// embedded in the gateway has a timeout, as a zero one is unbounded, and
+// that the syncs and the routes of authsvc keep their own rules.
+func TestDefaultConfigBoundsEveryRoute(t *testing.T) {
+	t.Parallel()
+
+	c := limits.DefaultConfig
+	if _, err := limits.New(c, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	prefixes := make(map[string]bool)
+	for _, rule := range c.Rules {
+		if rule.Timeout <= 0 || rule.MaxBodyBytes <= 0 {
+			t.Errorf("rule %q leaves the requests unbounded", rule.Prefix)
+		}
+		prefixes[rule.Prefix] = true
+	}
+	for _, prefix := range []string{"/", "/auth/v1/", "/api/v1/sync"} {
+		if !prefixes[prefix] {
+			t.Errorf("no rule for %q", prefix)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -10,23 +12,59 @@ import (
 	"syscall"
 	"time"
 
+	stdjwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
 	"github.com/go-kit/kit/log"
 	consulsd "github.com/go-kit/kit/sd/consul"
 	"github.com/gorilla/mux"
 	"github.com/hashicorp/consul/api"
+	"github.com/ichigozero/gtdkit/backend/apigateway/edge"
+	"github.com/ichigozero/gtdkit/backend/apigateway/graphql"
+	"github.com/ichigozero/gtdkit/backend/apigateway/headers"
+	"github.com/ichigozero/gtdkit/backend/apigateway/limits"
+	"github.com/ichigozero/gtdkit/backend/apigateway/openapi"
+	"github.com/ichigozero/gtdkit/backend/apigateway/ratelimit"
+	"github.com/ichigozero/gtdkit/backend/apigateway/rest"
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/authz"
 	authclient "github.com/ichigozero/gtdkit/backend/authsvc/client"
-	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/identity"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
 	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authtransport"
+	"github.com/ichigozero/gtdkit/backend/exportsvc/pkg/exportendpoint"
+	"github.com/ichigozero/gtdkit/backend/exportsvc/pkg/exportservice"
+	"github.com/ichigozero/gtdkit/backend/exportsvc/pkg/exporttransport"
+	"github.com/ichigozero/gtdkit/backend/tasksvc"
 	taskclient "github.com/ichigozero/gtdkit/backend/tasksvc/client"
+	taskpb "github.com/ichigozero/gtdkit/backend/tasksvc/pb"
 	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/tasktransport"
+	"github.com/ichigozero/gtdkit/backend/tlsconfig"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+	userclient "github.com/ichigozero/gtdkit/backend/usersvc/client"
+	userpb "github.com/ichigozero/gtdkit/backend/usersvc/pb"
+	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/usertransport"
 )
 
 func main() {
 	var (
-		httpAddr     = flag.String("http.addr", getEnv("HTTP_ADDR", ":8000"), "Address for HTTP (JSON) server")
-		consulAddr   = flag.String("consul.addr", getEnv("CONSUL_ADDR", ""), "Consul agent address")
-		retryMax     = flag.Int("retry.max", getEnvAsInt("RETRY_MAX", 3), "per-request retries to different instances")
-		retryTimeout = flag.Duration("retry.timeout", time.Duration(getEnvAsInt("RETRY_TIMEOUT", 500))*time.Millisecond, "per-request timeout, including retries")
+		httpAddr         = flag.String("http.addr", getEnv("HTTP_ADDR", ":8000"), "Address for HTTP (JSON) server")
+		httpReadTimeout  = flag.Duration("http.read.timeout", time.Duration(getEnvAsInt("HTTP_READ_TIMEOUT", 15))*time.Second, "time to read a request, including its body")
+		httpWriteTimeout = flag.Duration("http.write.timeout", time.Duration(getEnvAsInt("HTTP_WRITE_TIMEOUT", 60))*time.Second, "time to write a response, from the end of the request headers; the streams are exempt over HTTP/1.1")
+		httpIdleTimeout  = flag.Duration("http.idle.timeout", time.Duration(getEnvAsInt("HTTP_IDLE_TIMEOUT", 120))*time.Second, "time a keep-alive connection is kept open between requests")
+		shutdownTimeout  = flag.Duration("shutdown.timeout", time.Duration(getEnvAsInt("SHUTDOWN_TIMEOUT", 30))*time.Second, "time given to the requests in flight to complete on SIGTERM")
+		consulAddr       = flag.String("consul.addr", getEnv("CONSUL_ADDR", ""), "Consul agent address")
+		retryMax         = flag.Int("retry.max", getEnvAsInt("RETRY_MAX", 3), "per-request retries to different instances")
+		retryTimeout     = flag.Duration("retry.timeout", time.Duration(getEnvAsInt("RETRY_TIMEOUT", 500))*time.Millisecond, "per-request timeout, including retries")
+		jwtAlg           = flag.String("jwt.alg", getEnv("JWT_ALG", "RS256"), "Signing algorithm of the access tokens, RS256 or EdDSA")
+		identityKey      = flag.String("identity.key", getEnv("IDENTITY_KEY", ""), "Key shared with the services to sign the identities, the services verify the tokens themselves if empty")
+		rateLimits       = flag.String("ratelimit.config", getEnv("RATELIMIT_CONFIG", ""), "JSON file of the rate limit policies, the defaults are used if empty")
+		limitsFile       = flag.String("limits.config", getEnv("LIMITS_CONFIG", ""), "JSON file of the body size and timeout rules per route prefix, the defaults are used if empty")
+		corsOrigins      = flag.String("cors.origins", getEnv("CORS_ORIGINS", "http://localhost:3000"), "Comma separated origins allowed to call the gateway from a browser, used unless a headers config is given")
+		headersFile      = flag.String("headers.config", getEnv("HEADERS_CONFIG", ""), "JSON file of the CORS and security header rules per route prefix")
+		tlsCA            = flag.String("tls.ca", getEnv("TLS_CA", ""), "CA of the certificates the services present, the system roots are used if empty")
+		tlsCert          = flag.String("tls.cert", getEnv("TLS_CERT", ""), "Certificate served to the clients and presented to the services, TLS is disabled if empty")
+		tlsKey           = flag.String("tls.key", getEnv("TLS_KEY", ""), "Private key of the certificate")
+		publicRoutes     = flag.String("public.routes", getEnv("PUBLIC_ROUTES", "/auth/v1/,/task/v1/metrics,/openapi.json"), "Comma separated routes served without authentication, routes ending in a slash match every path below")
 	)
 	flag.Parse()
 
@@ -37,10 +75,20 @@ func main() {
 		logger = log.With(logger, "caller", log.DefaultCaller)
 	}
 
-	var (
-		client      consulsd.Client
-		inmemClient inmem.Client
-	)
+	// The clients of the gateway are not asked for a certificate, unlike
+	// the services calling each other.
+	serverTLS, err := tlsconfig.Server(tlsconfig.Files{Cert: *tlsCert, Key: *tlsKey})
+	if err != nil {
+		logger.Log("during", "tlsconfig.Server", "err", err)
+		os.Exit(1)
+	}
+	clientTLS, err := tlsconfig.Client(tlsconfig.Files{CA: *tlsCA, Cert: *tlsCert, Key: *tlsKey})
+	if err != nil {
+		logger.Log("during", "tlsconfig.Client", "err", err)
+		os.Exit(1)
+	}
+
+	var client consulsd.Client
 	{
 		consulConfig := api.DefaultConfig()
 		if len(*consulAddr) > 0 {
@@ -54,20 +102,215 @@ func main() {
 		}
 
 		client = consulsd.NewClient(consulClient)
-		inmemClient = inmem.NewClient(consulClient)
 	}
 
+	var (
+		authEndpoints, _ = authclient.New(client, clientTLS, logger, *retryMax, *retryTimeout)
+		taskEndpoints, _ = taskclient.New(client, clientTLS, logger, *retryMax, *retryTimeout)
+		userEndpoints, _ = userclient.New(client, clientTLS, logger, *retryMax, *retryTimeout)
+	)
+
+	method := stdjwt.GetSigningMethod(*jwtAlg)
+	if method == nil {
+		logger.Log("err", "unsupported JWT signing algorithm", "alg", *jwtAlg)
+		os.Exit(1)
+	}
+	keys := jwks.NewCache(authEndpoints.JWKS, method, jwks.DefaultTTL)
+
+	// Without a key, the services keep verifying the tokens forwarded to
+	// them, which the gateway still authenticates.
+	var signer *identity.Signer
+	if *identityKey != "" {
+		signer, err = identity.NewSigner([]byte(*identityKey))
+		if err != nil {
+			logger.Log("during", "identity.NewSigner", "err", err)
+			os.Exit(1)
+		}
+	}
+	authenticator := edge.NewAuthenticator(
+		keys,
+		authEndpoints.ResolvePAT,
+		authEndpoints.ValidateEndpoint,
+		signer,
+		edge.ParseRoutes(*publicRoutes),
+		log.With(logger, "component", "edge"),
+	)
+
+	config := ratelimit.DefaultConfig
+	if *rateLimits != "" {
+		c, err := ratelimit.LoadConfig(*rateLimits)
+		if err != nil {
+			logger.Log("during", "LoadConfig", "err", err)
+			os.Exit(1)
+		}
+		config = c
+	}
+	limiter, err := ratelimit.New(config, edge.UserID)
+	if err != nil {
+		logger.Log("during", "ratelimit.New", "err", err)
+		os.Exit(1)
+	}
+
+	limitsConfig := limits.DefaultConfig
+	if *limitsFile != "" {
+		c, err := limits.LoadConfig(*limitsFile)
+		if err != nil {
+			logger.Log("during", "LoadConfig", "err", err)
+			os.Exit(1)
+		}
+		limitsConfig = c
+	}
+	lims, err := limits.New(limitsConfig, edge.StreamPaths)
+	if err != nil {
+		logger.Log("during", "limits.New", "err", err)
+		os.Exit(1)
+	}
+
+	headersConfig := headers.DefaultConfig(headers.ParseOrigins(*corsOrigins))
+	if *headersFile != "" {
+		c, err := headers.LoadConfig(*headersFile)
+		if err != nil {
+			logger.Log("during", "LoadConfig", "err", err)
+			os.Exit(1)
+		}
+		headersConfig = c
+	}
+	hdrs, err := headers.New(headersConfig)
+	if err != nil {
+		logger.Log("during", "headers.New", "err", err)
+		os.Exit(1)
+	}
+
+	spec, err := openapi.Load()
+	if err != nil {
+		logger.Log("during", "openapi.Load", "err", err)
+		os.Exit(1)
+	}
+
+	// The routes mounted below are checked against the OpenAPI document by
+	// the tests of the openapi package, which mount them the same way.
 	r := mux.NewRouter()
+	// OPTIONS is matched so that the CORS middleware answers the preflight
+	// requests, which the router would otherwise refuse with 405 before
+	// running any middleware.
+	r.Methods("GET", "OPTIONS").Path("/openapi.json").Handler(spec)
 	{
-		endpoints, _ := authclient.New(client, logger, *retryMax, *retryTimeout)
-		authHTTPHandler := authtransport.NewHTTPHandler(endpoints, inmemClient, logger)
+		authHTTPHandler := authtransport.NewHTTPHandler(authEndpoints, keys, logger)
 		r.PathPrefix("/auth/v1").Handler(http.StripPrefix("/auth/v1", authHTTPHandler))
 	}
 	{
-		endpoints, _ := taskclient.New(client, logger, *retryMax, *retryTimeout)
-		taskHTTPHandler := tasktransport.NewHTTPHandler(endpoints, logger)
+		// The identity signed by the authenticator is trusted here, and
+		// forwarded to tasksvc.
+		taskHTTPHandler := tasktransport.NewHTTPHandler(taskEndpoints, keys, authEndpoints.ResolvePAT, []byte(*identityKey), logger)
 		r.PathPrefix("/task/v1").Handler(http.StripPrefix("/task/v1", taskHTTPHandler))
 	}
+	{
+		// The administrator roles are checked by usersvc itself, the
+		// gateway only forwards the access token.
+		adminHTTPHandler := usertransport.NewAdminHTTPHandler(userEndpoints, keys, logger)
+		r.PathPrefix("/admin/v1").Handler(http.StripPrefix("/admin/v1", adminHTTPHandler))
+	}
+	{
+		// The export is assembled here, in the gateway, from the data held
+		// by the other services.
+		var service exportservice.Service
+		{
+			service = exportservice.New(
+				userEndpoints.PersonalDataEndpoint,
+				taskEndpoints.TasksEndpoint,
+				authEndpoints.SessionsEndpoint,
+				authEndpoints.ConsentsEndpoint,
+				authEndpoints.PATsEndpoint,
+				logger,
+			)
+			service = exportservice.ProxingMiddleware(
+				context.Background(),
+				authEndpoints.ValidateEndpoint,
+			)(service)
+		}
+		endpoints := exportendpoint.New(service, logger)
+		exportHTTPHandler := exporttransport.NewHTTPHandler(endpoints, keys, logger)
+		r.PathPrefix("/export/v1").Handler(http.StripPrefix("/export/v1", exportHTTPHandler))
+	}
+
+	{
+		// The routes of the RPCs annotated in the proto files of the
+		// services, which authorize the calls themselves.
+		restHTTPHandler, err := rest.NewHTTPHandler(client, []rest.Service{
+			{
+				Name:       "tasksvc",
+				Descriptor: taskpb.File_tasksvc_proto.Services().ByName("TaskSVC"),
+				Errors: map[error]int{
+					authz.ErrForbidden:                http.StatusForbidden,
+					usersvc.ErrUserDisabled:           http.StatusForbidden,
+					usersvc.ErrPasswordResetRequired:  http.StatusForbidden,
+					kitjwt.ErrTokenExpired:            http.StatusUnauthorized,
+					kitjwt.ErrUnexpectedSigningMethod: http.StatusUnauthorized,
+					kitjwt.ErrTokenInvalid:            http.StatusUnauthorized,
+					kitjwt.ErrTokenMalformed:          http.StatusUnauthorized,
+					kitjwt.ErrTokenContextMissing:     http.StatusUnauthorized,
+					identity.ErrInvalidIdentity:       http.StatusUnauthorized,
+					jwks.ErrKeyIDMissing:              http.StatusUnauthorized,
+					jwks.ErrUnknownKey:                http.StatusUnauthorized,
+					usersvc.ErrUserNotFound:           http.StatusUnauthorized,
+					authsvc.ErrUserIDContextMissing:   http.StatusUnauthorized,
+					authsvc.ErrInvalidToken:           http.StatusUnauthorized,
+					tasksvc.ErrInvalidArgument:        http.StatusBadRequest,
+				},
+			},
+			{
+				Name:       "usersvc",
+				Descriptor: userpb.File_usersvc_proto.Services().ByName("User"),
+				Errors: map[error]int{
+					usersvc.ErrCannotModifySelf:       http.StatusForbidden,
+					kitjwt.ErrTokenExpired:            http.StatusUnauthorized,
+					kitjwt.ErrUnexpectedSigningMethod: http.StatusUnauthorized,
+					kitjwt.ErrTokenInvalid:            http.StatusUnauthorized,
+					kitjwt.ErrTokenMalformed:          http.StatusUnauthorized,
+					kitjwt.ErrTokenContextMissing:     http.StatusUnauthorized,
+					jwks.ErrKeyIDMissing:              http.StatusUnauthorized,
+					jwks.ErrUnknownKey:                http.StatusUnauthorized,
+					usersvc.ErrClaimsMissing:          http.StatusUnauthorized,
+					usersvc.ErrUserNotFound:           http.StatusNotFound,
+					usersvc.ErrInvalidArgument:        http.StatusBadRequest,
+				},
+			},
+		}, clientTLS, logger, *retryMax, *retryTimeout)
+		if err != nil {
+			logger.Log("during", "rest.NewHTTPHandler", "err", err)
+			os.Exit(1)
+		}
+		r.PathPrefix("/api").Handler(http.StripPrefix("/api", restHTTPHandler))
+	}
+
+	{
+		// The fields are resolved by the same clients as the routes above,
+		// for the caller authenticated by the gateway.
+		graphqlHTTPHandler := graphql.NewHTTPHandler(
+			graphql.NewSchema(),
+			graphql.Endpoints{Tasks: taskEndpoints, Auth: authEndpoints, Users: userEndpoints},
+			edge.UserID,
+			log.With(logger, "component", "graphql"),
+		)
+		r.PathPrefix("/graphql").Handler(graphqlHTTPHandler)
+	}
+
+	// The limits come first, so that the deadline of the request covers
+	// the calls made to authenticate it.
+	r.Use(lims.Middleware)
+	// The gateway is the edge of the system, so the client addresses sent
+	// by clients are not trusted.
+	r.Use(forwardedFor)
+	// The preflight requests carry no token, so they are answered before
+	// the authenticator.
+	r.Use(hdrs.Security, hdrs.CORS)
+	// Every request is limited per IP before it is authenticated, so that
+	// the tokens refused by the authenticator cost their sender as well.
+	r.Use(limiter.IPMiddleware)
+	r.Use(authenticator.Middleware)
+	// The limiter runs after the authenticator, so that the requests of
+	// authenticated users are limited per user rather than per IP.
+	r.Use(limiter.Middleware)
 
 	// Interrupt handler.
 	errc := make(chan error)
@@ -78,13 +321,46 @@ func main() {
 	}()
 
 	// HTTP transport.
+	server := &http.Server{
+		Addr:              *httpAddr,
+		Handler:           r,
+		TLSConfig:         serverTLS,
+		ReadHeaderTimeout: *httpReadTimeout,
+		ReadTimeout:       *httpReadTimeout,
+		WriteTimeout:      *httpWriteTimeout,
+		IdleTimeout:       *httpIdleTimeout,
+		ConnContext:       limits.ConnContext,
+	}
+	server.RegisterOnShutdown(lims.CloseStreams)
 	go func() {
-		logger.Log("transport", "HTTP", "addr", *httpAddr)
-		errc <- http.ListenAndServe(*httpAddr, r)
+		logger.Log("transport", "HTTP", "addr", *httpAddr, "tls", serverTLS != nil)
+		if serverTLS == nil {
+			errc <- server.ListenAndServe()
+			return
+		}
+		errc <- server.ListenAndServeTLS("", "")
 	}()
 
 	// Run!
 	logger.Log("exit", <-errc)
+
+	// The requests in flight are drained, the streams are ended.
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		logger.Log("during", "Shutdown", "err", err)
+	}
+}
+
+// forwardedFor overwrites X-Forwarded-For with the address of the accepted
+// connection. authsvc relies on it to throttle logins per client IP.
+func forwardedFor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			r.Header.Set("X-Forwarded-For", host)
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
 func getEnv(key, fallback string) string {
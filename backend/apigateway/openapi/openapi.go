@@ -0,0 +1,136 @@
+// Package openapi serves the OpenAPI document of the gateway, which
+// describes the routes, schemas and errors of the HTTP API. The document is
+// maintained by hand in openapi.json, Undocumented reports the routes it
+// does not describe and Responds the status codes it omits.
+package openapi
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+var ErrNotRouter = errors.New("handler is not a mux router")
+
+//go:embed openapi.json
+var document []byte
+
+// Spec is the parsed OpenAPI document.
+type Spec struct {
+	raw   []byte
+	paths map[string]map[string]json.RawMessage
+}
+
+// Load parses the embedded document.
+func Load() (*Spec, error) {
+	var doc struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(document, &doc); err != nil {
+		return nil, err
+	}
+	return &Spec{raw: document, paths: doc.Paths}, nil
+}
+
+// ServeHTTP writes the document. The preflight requests of browsers are
+// answered by the CORS middleware, so OPTIONS only lists the methods.
+func (s *Spec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "GET, OPTIONS")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(s.raw)
+}
+
+// Route is an operation served by a router, whose path is a mux template.
+type Route struct {
+	Method string
+	Path   string
+}
+
+func (r Route) String() string {
+	return r.Method + " " + r.Path
+}
+
+// Routes returns the operations of the router. OPTIONS is left out, as it
+// only answers the preflight requests of browsers.
+func Routes(h http.Handler) ([]Route, error) {
+	r, ok := h.(*mux.Router)
+	if !ok {
+		return nil, ErrNotRouter
+	}
+
+	var routes []Route
+	err := r.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			// Routes without a path, such as the mounts of the gateway,
+			// are documented by their own routes.
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+
+		for _, method := range methods {
+			if method != http.MethodOptions {
+				routes = append(routes, Route{Method: method, Path: path})
+			}
+		}
+		return nil
+	})
+	return routes, err
+}
+
+// Undocumented returns the routes of the router mounted at prefix which
+// the document does not describe, as "METHOD /path".
+func (s *Spec) Undocumented(prefix string, h http.Handler) ([]string, error) {
+	routes, err := Routes(h)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []string
+	for _, route := range routes {
+		if !s.Documents(route.Method, prefix+route.Path) {
+			missing = append(missing, route.Method+" "+prefix+route.Path)
+		}
+	}
+	sort.Strings(missing)
+	return missing, nil
+}
+
+// Documents reports whether the document describes the operation. The path
+// is a mux template, whose variables are written the same way.
+func (s *Spec) Documents(method, path string) bool {
+	_, ok := s.paths[path][strings.ToLower(method)]
+	return ok
+}
+
+// Responds reports whether the document lists the status code among the
+// responses of the operation, either as such or by its range, e.g. 4XX.
+func (s *Spec) Responds(method, path string, code int) bool {
+	var op struct {
+		Responses map[string]json.RawMessage `json:"responses"`
+	}
+	if err := json.Unmarshal(s.paths[path][strings.ToLower(method)], &op); err != nil {
+		return false
+	}
+
+	c := strconv.Itoa(code)
+	for _, key := range []string{c, c[:1] + "XX", "default"} {
+		if _, ok := op.Responses[key]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package openapi_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/consul/api"
+	"github.com/ichigozero/gtdkit/backend/apigateway/edge"
+	"github.com/ichigozero/gtdkit/backend/apigateway/graphql"
+	"github.com/ichigozero/gtdkit/backend/apigateway/headers"
+	"github.com/ichigozero/gtdkit/backend/apigateway/openapi"
+	"github.com/ichigozero/gtdkit/backend/apigateway/rest"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authendpoint"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authtransport"
+	"github.com/ichigozero/gtdkit/backend/exportsvc/pkg/exportendpoint"
+	"github.com/ichigozero/gtdkit/backend/exportsvc/pkg/exporttransport"
+	taskpb "github.com/ichigozero/gtdkit/backend/tasksvc/pb"
+	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/taskendpoint"
+	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/tasktransport"
+	userpb "github.com/ichigozero/gtdkit/backend/usersvc/pb"
+	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/userendpoint"
+	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/usertransport"
+)
+
+// unreachableConsul is the Consul client of the REST routes, whose
+// instances are never looked up by the test.
+type unreachableConsul struct{}
+
+func (unreachableConsul) Register(*api.AgentServiceRegistration) error   { return nil }
+func (unreachableConsul) Deregister(*api.AgentServiceRegistration) error { return nil }
+
+func (unreachableConsul) Service(string, string, bool, *api.QueryOptions) ([]*api.ServiceEntry, *api.QueryMeta, error) {
+	return nil, nil, errors.New("consul is unreachable in the test")
+}
+
+// errUnavailable is returned by every endpoint of the test, as if the
+// services were down.
+var errUnavailable = errors.New("service unavailable")
+
+// failing sets every endpoint of the set, a pointer to a struct, to one
+// failing with errUnavailable.
+func failing(set interface{}) interface{} {
+	v := reflect.ValueOf(set).Elem()
+	e := reflect.ValueOf(endpoint.Endpoint(func(context.Context, interface{}) (interface{}, error) {
+		return nil, errUnavailable
+	}))
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).Type() == e.Type() {
+			v.Field(i).Set(e)
+		}
+	}
+	return v.Interface()
+}
+
+type mount struct {
+	prefix  string
+	handler http.Handler
+}
+
+// newMounts returns the routers of the gateway, built as in its main
+// package, with the prefixes they are mounted at. The GraphQL routes are
+// not stripped of theirs.
+func newMounts(t *testing.T, spec *openapi.Spec) []mount {
+	t.Helper()
+
+	logger := log.NewNopLogger()
+	keys := jwks.NewCache(nil, stdjwt.SigningMethodRS256, jwks.DefaultTTL)
+
+	root := mux.NewRouter()
+	root.Methods("GET", "OPTIONS").Path("/openapi.json").Handler(spec)
+
+	restHTTPHandler, err := rest.NewHTTPHandler(unreachableConsul{}, []rest.Service{
+		{Name: "tasksvc", Descriptor: taskpb.File_tasksvc_proto.Services().ByName("TaskSVC")},
+		{Name: "usersvc", Descriptor: userpb.File_usersvc_proto.Services().ByName("User")},
+	}, nil, logger, 1, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authEndpoints := failing(&authendpoint.Set{}).(authendpoint.Set)
+	taskEndpoints := failing(&taskendpoint.Set{}).(taskendpoint.Set)
+	userEndpoints := failing(&userendpoint.Set{}).(userendpoint.Set)
+	exportEndpoints := failing(&exportendpoint.Set{}).(exportendpoint.Set)
+	return []mount{
+		{"", root},
+		{"/auth/v1", authtransport.NewHTTPHandler(authEndpoints, keys, logger)},
+		{"/task/v1", tasktransport.NewHTTPHandler(taskEndpoints, keys, nil, nil, logger)},
+		{"/admin/v1", usertransport.NewAdminHTTPHandler(userEndpoints, keys, logger)},
+		{"/export/v1", exporttransport.NewHTTPHandler(exportEndpoints, keys, logger)},
+		{"/api", restHTTPHandler},
+		{"", graphql.NewHTTPHandler(graphql.NewSchema(), graphql.Endpoints{}, edge.UserID, logger)},
+	}
+}
+
+// TestRoutesDocumented fails for every route mounted by the gateway which
+// the document does not describe.
+func TestRoutesDocumented(t *testing.T) {
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range newMounts(t, spec) {
+		routes, err := spec.Undocumented(m.prefix, m.handler)
+		if err != nil {
+			t.Errorf("%q: %v", m.prefix, err)
+			continue
+		}
+		for _, route := range routes {
+			t.Errorf("undocumented route %s", route)
+		}
+	}
+}
+
+// TestResponsesDocumented calls every route without a token nor a body,
+// while the services are down, and fails for every status code answered
+// which the document does not list for the operation.
+func TestResponsesDocumented(t *testing.T) {
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, m := range newMounts(t, spec) {
+		routes, err := openapi.Routes(m.handler)
+		if err != nil {
+			t.Errorf("%q: %v", m.prefix, err)
+			continue
+		}
+		for _, route := range routes {
+			// The path variables are set to a valid ID.
+			path := pathVariable.ReplaceAllString(route.Path, "1")
+			r := httptest.NewRequest(route.Method, path, http.NoBody)
+			w := httptest.NewRecorder()
+			m.handler.ServeHTTP(w, r)
+
+			if !spec.Responds(route.Method, m.prefix+route.Path, w.Code) {
+				t.Errorf("%s %s: undocumented response %d %s", route.Method, m.prefix+route.Path, w.Code, w.Body)
+			}
+		}
+	}
+}
+
+var pathVariable = regexp.MustCompile(`{[^}]*}`)
+
+// TestSpecPreflight checks that the preflight requests for the document
+// reach the CORS middleware instead of being refused by the router.
+func TestSpecPreflight(t *testing.T) {
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdrs, err := headers.New(headers.DefaultConfig([]string{"https://app.example.com"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := newMounts(t, spec)[0].handler.(*mux.Router)
+	root.Use(hdrs.CORS)
+
+	r := httptest.NewRequest(http.MethodOptions, "/openapi.json", nil)
+	r.Header.Set("Origin", "https://app.example.com")
+	r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	w := httptest.NewRecorder()
+	root.ServeHTTP(w, r)
+
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("want the preflight allowed, got %d %v", w.Code, w.Header())
+	}
+}
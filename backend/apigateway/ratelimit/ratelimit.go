@@ -0,0 +1,314 @@
+// Package ratelimit limits the requests accepted by the gateway per caller.
+// Every caller has a token bucket per policy, keyed by the ID of the
+// authenticated user or else by the client IP. The buckets are held in
+// memory, so the limits apply to every gateway instance on its own.
+//
+// The IP policy of the config applies to every request before it is
+// authenticated, see Limiter.IPMiddleware, so that the requests refused by
+// the authenticator are limited too.
+package ratelimit
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// KeyUser limits the authenticated users, falling back to the client
+	// IP on public routes.
+	KeyUser = "user"
+	// KeyIP limits the client IPs, even if the user is authenticated.
+	KeyIP = "ip"
+
+	// sweepInterval is how often the buckets which filled up again are
+	// dropped, as they are no different from new ones.
+	sweepInterval = time.Minute
+)
+
+var (
+	ErrInvalidPolicy = errors.New("invalid rate limit policy")
+	ErrLimitExceeded = errors.New("too many requests")
+)
+
+// Duration is a time.Duration written as a string like "1m" in the
+// config file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Policy allows Limit requests per Period, with bursts of up to Burst
+// requests, which defaults to Limit. A Limit of zero does not limit the
+// route at all.
+type Policy struct {
+	// Method and Path select the requests of the policy, an empty method
+	// matches any. A path ending in a slash matches every path below it,
+	// any other path matches exactly.
+	Method string   `json:"method,omitempty"`
+	Path   string   `json:"path,omitempty"`
+	Limit  int      `json:"limit"`
+	Period Duration `json:"period"`
+	Burst  int      `json:"burst,omitempty"`
+	// Key is either KeyUser, the default, or KeyIP.
+	Key string `json:"key,omitempty"`
+}
+
+// Config holds the route policies, of which the first matching one
+// applies, and the default policy of the other routes. The IP policy
+// applies to every route on top of those, per client IP, whatever its Key.
+type Config struct {
+	IP      Policy   `json:"ip"`
+	Default Policy   `json:"default"`
+	Routes  []Policy `json:"routes"`
+}
+
+//go:embed ratelimit.json
+var defaultConfig string
+
+// DefaultConfig is used when no config file is given. It is read from
+// ratelimit.json, which is the example of a config file as well. Logins
+// are limited per IP, as they are not authenticated yet.
+var DefaultConfig = func() Config {
+	c, err := decodeConfig(strings.NewReader(defaultConfig))
+	if err != nil {
+		panic(err)
+	}
+	return c
+}()
+
+// LoadConfig reads the config from a JSON file.
+func LoadConfig(path string) (Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer f.Close()
+
+	return decodeConfig(f)
+}
+
+func decodeConfig(r io.Reader) (Config, error) {
+	var c Config
+	if err := json.NewDecoder(r).Decode(&c); err != nil {
+		return c, err
+	}
+	return c, c.validate()
+}
+
+func (c Config) validate() error {
+	for _, p := range append([]Policy{c.IP, c.Default}, c.Routes...) {
+		if p.Limit < 0 || p.Burst < 0 || (p.Limit > 0 && p.Period <= 0) {
+			return fmt.Errorf("%w: %s %s", ErrInvalidPolicy, p.Method, p.Path)
+		}
+		if p.Key != "" && p.Key != KeyUser && p.Key != KeyIP {
+			return fmt.Errorf("%w: unknown key %q", ErrInvalidPolicy, p.Key)
+		}
+	}
+	return nil
+}
+
+// UserFunc returns the ID of the authenticated user of the request, if
+// any.
+type UserFunc func(r *http.Request) (uint64, bool)
+
+// Limiter is a middleware which answers the requests exceeding their
+// policy with 429 Too Many Requests. The state of the bucket is reported
+// in the RateLimit-* headers of every limited response.
+type Limiter struct {
+	config Config
+	user   UserFunc
+
+	mtx     sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+}
+
+func New(c Config, user UserFunc) (*Limiter, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &Limiter{
+		config:  c,
+		user:    user,
+		buckets: make(map[string]*bucket),
+	}, nil
+}
+
+// Middleware applies the route policies. It runs after the authenticator,
+// so that the authenticated users are limited per user.
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i, p := l.policy(r)
+		if l.limit(w, fmt.Sprintf("%d/%s", i, l.caller(r, p)), p) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// IPMiddleware applies the IP policy. It runs before the authenticator,
+// which calls authsvc for most of the tokens it refuses.
+func (l *Limiter) IPMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.limit(w, "ip/"+clientIP(r), l.config.IP) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// limit takes a token from the bucket of the key and reports whether the
+// request may be served. The request is answered otherwise.
+func (l *Limiter) limit(w http.ResponseWriter, key string, p Policy) bool {
+	if p.Limit == 0 {
+		return true
+	}
+
+	allowed, remaining, reset, retry := l.take(key, p)
+
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(burst(p)))
+	h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(seconds(reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", p.Limit, seconds(time.Duration(p.Period))))
+
+	if !allowed {
+		h.Set("Retry-After", strconv.Itoa(seconds(retry)))
+		h.Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(errorWrapper{Error: ErrLimitExceeded.Error()})
+		return false
+	}
+	return true
+}
+
+type errorWrapper struct {
+	Error string `json:"error"`
+}
+
+// policy returns the first route policy matching the request along with
+// its index, which is -1 for the default policy.
+func (l *Limiter) policy(r *http.Request) (int, Policy) {
+	for i, p := range l.config.Routes {
+		if p.Method != "" && p.Method != r.Method {
+			continue
+		}
+		if r.URL.Path == p.Path || (strings.HasSuffix(p.Path, "/") && strings.HasPrefix(r.URL.Path, p.Path)) {
+			return i, p
+		}
+	}
+	return -1, l.config.Default
+}
+
+// caller returns the key of the caller.
+func (l *Limiter) caller(r *http.Request, p Policy) string {
+	if p.Key != KeyIP && l.user != nil {
+		if userID, ok := l.user(r); ok {
+			return "user/" + strconv.FormatUint(userID, 10)
+		}
+	}
+	return "ip/" + clientIP(r)
+}
+
+// clientIP is taken from X-Forwarded-For, which the gateway sets to the
+// address of the connection.
+func clientIP(r *http.Request) string {
+	ip := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-For"), ",")[0])
+	if ip == "" {
+		ip, _, _ = net.SplitHostPort(r.RemoteAddr)
+	}
+	return ip
+}
+
+// take takes a token from the bucket of the key. It returns the tokens
+// left, the time until the bucket is full again and, if no token was
+// left, the time until the next one.
+func (l *Limiter) take(key string, p Policy) (allowed bool, remaining int, reset, retry time.Duration) {
+	now := time.Now()
+	rate := float64(p.Limit) / time.Duration(p.Period).Seconds()
+	capacity := float64(burst(p))
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if now.Sub(l.sweptAt) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, rate: rate, capacity: capacity, last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		allowed = true
+	} else {
+		retry = seconds2duration((1 - b.tokens) / rate)
+	}
+	return allowed, int(b.tokens), seconds2duration((capacity - b.tokens) / rate), retry
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	l.sweptAt = now
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= b.capacity {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type bucket struct {
+	tokens   float64
+	rate     float64
+	capacity float64
+	last     time.Time
+}
+
+func (b *bucket) refill(now time.Time) {
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+func burst(p Policy) int {
+	if p.Burst > 0 {
+		return p.Burst
+	}
+	return p.Limit
+}
+
+// seconds rounds up, so that a client waiting for the given seconds is
+// never refused again.
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+func seconds2duration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
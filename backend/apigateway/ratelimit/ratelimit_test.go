@@ -0,0 +1,60 @@
+package ratelimit_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/apigateway/ratelimit"
+)
+
+// TestIPPolicyLimitsRefusedRequests checks that the IP policy applies to
+// the requests which never reach the route policies, like those refused by
+// the authenticator.
+func TestIPPolicyLimitsRefusedRequests(t *testing.T) {
+	t.Parallel()
+
+	l, err := ratelimit.New(ratelimit.Config{
+		IP:      ratelimit.Policy{Limit: 2, Period: ratelimit.Duration(time.Minute)},
+		Default: ratelimit.Policy{Limit: 100, Period: ratelimit.Duration(time.Minute)},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refuse := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	h := l.IPMiddleware(refuse)
+
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		r := httptest.NewRequest("GET", "/task/v1/tasks", nil)
+		r.Header.Set("X-Forwarded-For", "192.0.2.1")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != want {
+			t.Errorf("request %d: want %d, got %d", i, want, w.Code)
+		}
+	}
+
+	// Another client is not affected.
+	r := httptest.NewRequest("GET", "/task/v1/tasks", nil)
+	r.Header.Set("X-Forwarded-For", "192.0.2.2")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("other client: want %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+// TestDefaultConfigLimitsEveryIP checks that the config shipped with the
+// gateway limits the clients before they are authenticated.
+func TestDefaultConfigLimitsEveryIP(t *testing.T) {
+	t.Parallel()
+
+	if ratelimit.DefaultConfig.IP.Limit <= 0 {
+		t.Error("the default config does not limit the requests per IP")
+	}
+}
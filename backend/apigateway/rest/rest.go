@@ -0,0 +1,431 @@
+// Package rest serves the RPCs annotated with a google.api.http rule in the
+// proto files of the services as REST endpoints. The routes and the JSON of
+// the requests and replies are derived from the descriptors, so that a new
+// annotated RPC is served without changes to the gateway, and the fields
+// are named the same way on every route: lowerCamelCase, as protojson
+// writes them.
+package rest
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/circuitbreaker"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/ratelimit"
+	"github.com/go-kit/kit/sd"
+	consulsd "github.com/go-kit/kit/sd/consul"
+	"github.com/go-kit/kit/sd/lb"
+	"github.com/go-kit/kit/transport"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/ichigozero/gtdkit/backend/authsvc/identity"
+	"github.com/sony/gobreaker"
+	"golang.org/x/time/rate"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// errField is the field of the replies carrying the error of the service,
+// see err2str in the transports of the services.
+const errField = "err"
+
+var (
+	ErrUnsupportedRule = errors.New("unsupported HTTP rule")
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+// Service is a gRPC service whose instances are registered in Consul.
+type Service struct {
+	// Name is the name the instances are registered with.
+	Name       string
+	Descriptor protoreflect.ServiceDescriptor
+	// Errors maps the errors returned in the err field of the replies to
+	// HTTP status codes, the other errors are internal server errors.
+	Errors map[error]int
+}
+
+// NewHTTPHandler returns the routes of the annotated RPCs of the services.
+// The access token and the identity signed by the gateway are forwarded to
+// the services, which authorize the calls. The services are called over
+// TLS if tlsConfig is not nil.
+func NewHTTPHandler(apiclient consulsd.Client, services []Service, tlsConfig *tls.Config, logger log.Logger, retryMax int, retryTimeout time.Duration) (*mux.Router, error) {
+	r := mux.NewRouter()
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		httptransport.ServerBefore(kitjwt.HTTPToContext(), identity.HTTPToContext()),
+	}
+
+	for _, s := range services {
+		// A single connection is dialed per instance, which every route of
+		// the service shares along with its limiter and circuit breaker.
+		var (
+			tags        = []string{}
+			passingOnly = true
+			instancer   = consulsd.NewInstancer(apiclient, logger, s.Name, tags, passingOnly)
+			factory     = factoryFor(s.Name, tlsConfig)
+			endpointer  = sd.NewEndpointer(instancer, factory, logger)
+			balancer    = lb.NewRoundRobin(endpointer)
+			retry       = lb.Retry(retryMax, retryTimeout, balancer)
+			methods     = s.Descriptor.Methods()
+		)
+		for i := 0; i < methods.Len(); i++ {
+			m := methods.Get(i)
+			rule, ok := proto.GetExtension(m.Options(), annotations.E_Http).(*annotations.HttpRule)
+			if !ok || rule == nil {
+				continue
+			}
+			if m.IsStreamingClient() || m.IsStreamingServer() {
+				return nil, fmt.Errorf("%w: %s is streaming", ErrUnsupportedRule, m.FullName())
+			}
+
+			rt, err := newRoute(m, rule, s.Errors)
+			if err != nil {
+				return nil, err
+			}
+
+			r.Methods(rt.method).Path(rt.path).Handler(httptransport.NewServer(
+				retry,
+				rt.decodeRequest,
+				rt.encodeResponse,
+				append(options, httptransport.ServerErrorEncoder(rt.errorEncoder))...,
+			))
+		}
+	}
+	return r, nil
+}
+
+// route is an annotated RPC.
+type route struct {
+	fullMethod string
+	method     string
+	path       string
+	// body is the field the request body is decoded into, "*" for the
+	// whole request and empty if the request has no body.
+	body   string
+	params []protoreflect.FieldDescriptor
+	input  protoreflect.MessageType
+	output protoreflect.MessageType
+	errors map[error]int
+}
+
+func newRoute(m protoreflect.MethodDescriptor, rule *annotations.HttpRule, errs map[error]int) (*route, error) {
+	rt := &route{
+		fullMethod: fmt.Sprintf("/%s/%s", m.Parent().FullName(), m.Name()),
+		body:       rule.GetBody(),
+		errors:     errs,
+	}
+
+	switch p := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		rt.method, rt.path = http.MethodGet, p.Get
+	case *annotations.HttpRule_Post:
+		rt.method, rt.path = http.MethodPost, p.Post
+	case *annotations.HttpRule_Put:
+		rt.method, rt.path = http.MethodPut, p.Put
+	case *annotations.HttpRule_Patch:
+		rt.method, rt.path = http.MethodPatch, p.Patch
+	case *annotations.HttpRule_Delete:
+		rt.method, rt.path = http.MethodDelete, p.Delete
+	default:
+		return nil, fmt.Errorf("%w: %s has no method", ErrUnsupportedRule, m.FullName())
+	}
+	if len(rule.GetAdditionalBindings()) > 0 {
+		return nil, fmt.Errorf("%w: %s has additional bindings", ErrUnsupportedRule, m.FullName())
+	}
+
+	var err error
+	if rt.input, err = protoregistry.GlobalTypes.FindMessageByName(m.Input().FullName()); err != nil {
+		return nil, err
+	}
+	if rt.output, err = protoregistry.GlobalTypes.FindMessageByName(m.Output().FullName()); err != nil {
+		return nil, err
+	}
+
+	// Only the variables naming a scalar field of the request are
+	// supported, e.g. {task_id}, which is also the syntax of mux.
+	fields := m.Input().Fields()
+	for _, segment := range strings.Split(rt.path, "/") {
+		if !strings.HasPrefix(segment, "{") {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(segment, "{"), "}")
+		fd := fields.ByName(protoreflect.Name(name))
+		if fd == nil || !isScalar(fd) || fd.IsList() {
+			return nil, fmt.Errorf("%w: %s has variable %s", ErrUnsupportedRule, m.FullName(), segment)
+		}
+		rt.params = append(rt.params, fd)
+	}
+
+	if rt.body != "" && rt.body != "*" {
+		fd := fields.ByName(protoreflect.Name(rt.body))
+		if fd == nil || fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+			return nil, fmt.Errorf("%w: %s has body %s", ErrUnsupportedRule, m.FullName(), rt.body)
+		}
+	}
+	return rt, nil
+}
+
+// call is the request of a route, decoded by decodeRequest.
+type call struct {
+	rt      *route
+	request proto.Message
+}
+
+// response is the reply of a service, or the error it returned.
+type response struct {
+	reply proto.Message
+	err   error
+}
+
+func (r response) Failed() error { return r.err }
+
+// factoryFor returns the factory of the endpoints of the named service,
+// which call the RPC of any route.
+func factoryFor(name string, tlsConfig *tls.Config) sd.Factory {
+	return func(instance string) (endpoint.Endpoint, io.Closer, error) {
+		creds := grpc.WithInsecure()
+		if tlsConfig != nil {
+			creds = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+		}
+		conn, err := grpc.Dial(instance, creds)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var e endpoint.Endpoint
+		{
+			e = invoke(conn)
+			e = ratelimit.NewErroringLimiter(rate.NewLimiter(rate.Every(time.Second), 100))(e)
+			e = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+				Name:    name,
+				Timeout: 30 * time.Second,
+			}))(e)
+		}
+		return e, conn, nil
+	}
+}
+
+// invoke calls the RPC of the route of the call. The statuses returned by
+// the service, rather than by the connection, are responses, so that they
+// are neither retried by the load balancer nor counted as failures by the
+// circuit breaker.
+func invoke(conn *grpc.ClientConn) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		c := request.(call)
+
+		md := metadata.MD{}
+		ctx = kitjwt.ContextToGRPC()(ctx, &md)
+		ctx = identity.ContextToGRPC()(ctx, &md)
+		ctx = metadata.NewOutgoingContext(ctx, md)
+
+		reply := c.rt.output.New().Interface()
+		if err := conn.Invoke(ctx, c.rt.fullMethod, c.request, reply); err != nil {
+			switch status.Code(err) {
+			case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted:
+				return nil, err
+			}
+			return response{err: err}, nil
+		}
+
+		m := reply.ProtoReflect()
+		if fd := m.Descriptor().Fields().ByName(errField); fd != nil && fd.Kind() == protoreflect.StringKind {
+			if s := m.Get(fd).String(); s != "" {
+				return response{err: errors.New(s)}, nil
+			}
+			m.Clear(fd)
+		}
+		return response{reply: reply}, nil
+	}
+}
+
+// decodeRequest decodes the body first, then the variables of the path and
+// the query parameters, which overwrite the fields of the body.
+func (rt *route) decodeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req := rt.input.New()
+
+	if rt.body != "" {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) > 0 {
+			target := req
+			if rt.body != "*" {
+				target = req.Mutable(req.Descriptor().Fields().ByName(protoreflect.Name(rt.body))).Message()
+			}
+			if err := protojson.Unmarshal(b, target.Interface()); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+			}
+		}
+	}
+
+	vars := mux.Vars(r)
+	for _, fd := range rt.params {
+		v, err := parseScalar(fd, vars[string(fd.Name())])
+		if err != nil {
+			return nil, err
+		}
+		req.Set(fd, v)
+	}
+
+	// The query parameters only fill the fields the body does not cover.
+	if rt.body != "*" {
+		fields := req.Descriptor().Fields()
+		for key, values := range r.URL.Query() {
+			fd := fields.ByJSONName(key)
+			if fd == nil {
+				fd = fields.ByName(protoreflect.Name(key))
+			}
+			if fd == nil || !isScalar(fd) || string(fd.Name()) == rt.body {
+				continue
+			}
+			if !fd.IsList() {
+				v, err := parseScalar(fd, values[len(values)-1])
+				if err != nil {
+					return nil, err
+				}
+				req.Set(fd, v)
+				continue
+			}
+			list := req.Mutable(fd).List()
+			for _, s := range values {
+				v, err := parseScalar(fd, s)
+				if err != nil {
+					return nil, err
+				}
+				list.Append(v)
+			}
+		}
+	}
+	return call{rt: rt, request: req.Interface()}, nil
+}
+
+// encodeResponse writes the reply without its err field. The fields left
+// to their default values are written as well, so that e.g. a task which is
+// not done reads "done": false.
+func (rt *route) encodeResponse(ctx context.Context, w http.ResponseWriter, resp interface{}) error {
+	r := resp.(response)
+	if r.err != nil {
+		rt.errorEncoder(ctx, r.err, w)
+		return nil
+	}
+
+	b, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(r.reply)
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	delete(fields, errField)
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(fields)
+}
+
+func (rt *route) errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
+	msg := err.Error()
+	if s, ok := status.FromError(err); ok {
+		msg = s.Message()
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(rt.err2code(err))
+	json.NewEncoder(w).Encode(errorWrapper{Error: msg})
+}
+
+type errorWrapper struct {
+	Error string `json:"error"`
+}
+
+func (rt *route) err2code(err error) int {
+	if errors.Is(err, ErrInvalidArgument) {
+		return http.StatusBadRequest
+	}
+	var retryErr lb.RetryError
+	if errors.As(err, &retryErr) {
+		return http.StatusServiceUnavailable
+	}
+
+	msg := err.Error()
+	if s, ok := status.FromError(err); ok {
+		msg = s.Message()
+		switch s.Code() {
+		case codes.InvalidArgument:
+			return http.StatusBadRequest
+		case codes.Unauthenticated:
+			return http.StatusUnauthorized
+		case codes.PermissionDenied:
+			return http.StatusForbidden
+		case codes.NotFound:
+			return http.StatusNotFound
+		case codes.Unavailable, codes.ResourceExhausted:
+			return http.StatusServiceUnavailable
+		case codes.DeadlineExceeded:
+			return http.StatusGatewayTimeout
+		case codes.Unimplemented:
+			return http.StatusNotImplemented
+		}
+	}
+	// The errors of the services only carry their message.
+	for e, code := range rt.errors {
+		if e.Error() == msg {
+			return code
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+func isScalar(fd protoreflect.FieldDescriptor) bool {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return false
+	}
+	return !fd.IsMap()
+}
+
+// parseScalar parses a path variable or a query parameter the way protojson
+// reads the value of the field.
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	var b []byte
+	switch fd.Kind() {
+	case protoreflect.StringKind, protoreflect.BytesKind, protoreflect.EnumKind:
+		b, _ = json.Marshal(s)
+	default:
+		b = []byte(s)
+	}
+
+	// A message with the field alone is decoded, which reuses the parsing
+	// of protojson for every kind.
+	if fd.IsList() {
+		b = []byte("[" + string(b) + "]")
+	}
+	m := dynamicpb.NewMessage(fd.ContainingMessage())
+	if err := protojson.Unmarshal([]byte(fmt.Sprintf("{%q:%s}", fd.JSONName(), b)), m); err != nil {
+		return protoreflect.Value{}, fmt.Errorf("%w: %s", ErrInvalidArgument, fd.Name())
+	}
+	if fd.IsList() {
+		return m.Get(fd).List().Get(0), nil
+	}
+	return m.Get(fd), nil
+}
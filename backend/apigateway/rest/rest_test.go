@@ -0,0 +1,160 @@
+package rest
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/sd/lb"
+	"github.com/gorilla/mux"
+	taskpb "github.com/ichigozero/gtdkit/backend/tasksvc/pb"
+	userpb "github.com/ichigozero/gtdkit/backend/usersvc/pb"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+func routeOf(t *testing.T, sd protoreflect.ServiceDescriptor, name string, errs map[error]int) *route {
+	t.Helper()
+
+	m := sd.Methods().ByName(protoreflect.Name(name))
+	rule, _ := proto.GetExtension(m.Options(), annotations.E_Http).(*annotations.HttpRule)
+	rt, err := newRoute(m, rule, errs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rt
+}
+
+func decode(t *testing.T, rt *route, target, body string, vars map[string]string) (proto.Message, error) {
+	t.Helper()
+
+	r := httptest.NewRequest(rt.method, target, strings.NewReader(body))
+	r = mux.SetURLVars(r, vars)
+	c, err := rt.decodeRequest(r.Context(), r)
+	if err != nil {
+		return nil, err
+	}
+	return c.(call).request, nil
+}
+
+// TestDecodeRequestWholeBody checks that the path variables overwrite the
+// fields of a "*" body, and that the query is ignored then.
+func TestDecodeRequestWholeBody(t *testing.T) {
+	t.Parallel()
+
+	rt := routeOf(t, taskpb.File_tasksvc_proto.Services().ByName("TaskSVC"), "UpdateTask", nil)
+	m, err := decode(t, rt, "/v1/tasks/7?title=query", `{"taskId":"9","title":"body","done":true}`, map[string]string{"task_id": "7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := m.(*taskpb.UpdateTaskRequest)
+	if req.TaskId != 7 || req.Title != "body" || !req.Done {
+		t.Errorf("want task 7 titled body and done, got %v", req)
+	}
+}
+
+// TestDecodeRequestQuery checks that the query fills the scalar fields and
+// lists of a request without body, by their JSON or proto name.
+func TestDecodeRequestQuery(t *testing.T) {
+	t.Parallel()
+
+	users := userpb.File_usersvc_proto.Services().ByName("User")
+
+	rt := routeOf(t, users, "ListUsers", nil)
+	m, err := decode(t, rt, "/v1/admin/users?query=ann&offset=10&limit=5&limit=20&unknown=1", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req := m.(*userpb.ListUsersRequest); req.Query != "ann" || req.Offset != 10 || req.Limit != 20 {
+		t.Errorf("want query ann from 10 limited to 20, got %v", req)
+	}
+
+	// The roles are not in the body of the route, for the sake of the
+	// test.
+	rt = routeOf(t, users, "AssignRoles", nil)
+	rt.body = ""
+	m, err = decode(t, rt, "/v1/admin/users/3/roles?roles=admin&roles=user", "", map[string]string{"id": "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req := m.(*userpb.AssignRolesRequest); req.Id != 3 || strings.Join(req.Roles, ",") != "admin,user" {
+		t.Errorf("want roles admin,user for user 3, got %v", req)
+	}
+}
+
+func TestDecodeRequestInvalid(t *testing.T) {
+	t.Parallel()
+
+	tasks := taskpb.File_tasksvc_proto.Services().ByName("TaskSVC")
+	users := userpb.File_usersvc_proto.Services().ByName("User")
+
+	for _, tc := range []struct {
+		rt     *route
+		target string
+		body   string
+		vars   map[string]string
+	}{
+		{routeOf(t, tasks, "UpdateTask", nil), "/v1/tasks/x", "{}", map[string]string{"task_id": "x"}},
+		{routeOf(t, tasks, "UpdateTask", nil), "/v1/tasks/1", `{"done":"maybe"}`, map[string]string{"task_id": "1"}},
+		{routeOf(t, tasks, "UpdateTask", nil), "/v1/tasks/-1", "{}", map[string]string{"task_id": "-1"}},
+		{routeOf(t, users, "ListUsers", nil), "/v1/admin/users?limit=many", "", nil},
+	} {
+		if _, err := decode(t, tc.rt, tc.target, tc.body, tc.vars); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%s %q: want %v, got %v", tc.target, tc.body, ErrInvalidArgument, err)
+		}
+	}
+}
+
+func TestParseScalar(t *testing.T) {
+	t.Parallel()
+
+	fields := (&userpb.ListUsersRequest{}).ProtoReflect().Descriptor().Fields()
+
+	v, err := parseScalar(fields.ByName("query"), `a "quoted" string`)
+	if err != nil || v.String() != `a "quoted" string` {
+		t.Errorf("want the string as is, got %v, %v", v, err)
+	}
+	v, err = parseScalar(fields.ByName("offset"), "42")
+	if err != nil || v.Int() != 42 {
+		t.Errorf("want 42, got %v, %v", v, err)
+	}
+	if _, err := parseScalar(fields.ByName("offset"), "4294967296"); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("want %v for an overflow, got %v", ErrInvalidArgument, err)
+	}
+}
+
+func TestErr2Code(t *testing.T) {
+	t.Parallel()
+
+	errNotFound := errors.New("user not found")
+	rt := &route{errors: map[error]int{errNotFound: http.StatusNotFound}}
+
+	for _, tc := range []struct {
+		err  error
+		code int
+	}{
+		{ErrInvalidArgument, http.StatusBadRequest},
+		{lb.RetryError{Final: errors.New("no endpoints available")}, http.StatusServiceUnavailable},
+		{status.Error(codes.InvalidArgument, "bad"), http.StatusBadRequest},
+		{status.Error(codes.Unauthenticated, "who"), http.StatusUnauthorized},
+		{status.Error(codes.PermissionDenied, "no"), http.StatusForbidden},
+		{status.Error(codes.NotFound, "gone"), http.StatusNotFound},
+		{status.Error(codes.Unavailable, "down"), http.StatusServiceUnavailable},
+		{status.Error(codes.DeadlineExceeded, "slow"), http.StatusGatewayTimeout},
+		{status.Error(codes.Unimplemented, "what"), http.StatusNotImplemented},
+		// The errors of the services are told apart by their message.
+		{errors.New("user not found"), http.StatusNotFound},
+		{status.Error(codes.Unknown, "user not found"), http.StatusNotFound},
+		{errors.New("boom"), http.StatusInternalServerError},
+	} {
+		if code := rt.err2code(tc.err); code != tc.code {
+			t.Errorf("%v: want %d, got %d", tc.err, tc.code, code)
+		}
+	}
+}
@@ -1,6 +1,6 @@
 #!/bin/sh
 
-ACCESS_SECRET=uV3gqXaPOQrvJCQ1CgB2QML3ZhQ66cNk
+JWT_ALG=RS256
 RETRY_TIMEOUT=5000
 
 go run main.go --http.addr=127.0.0.1:8000 --consul.addr=127.0.0.1:8500
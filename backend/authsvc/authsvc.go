@@ -1,15 +1,87 @@
 package authsvc
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 type contextKey string
 
 const UserIDContextKey contextKey = "UserID"
 const JWTUUIDContextKey contextKey = "JWTUUID"
+const ClientIPContextKey contextKey = "ClientIP"
+const MFARequiredContextKey contextKey = "MFARequired"
+const MFAVerifierContextKey contextKey = "MFAVerifier"
+const RolesContextKey contextKey = "Roles"
+const UserAgentContextKey contextKey = "UserAgent"
+const IdentityLinkerContextKey contextKey = "IdentityLinker"
+const CallerTokenContextKey contextKey = "CallerToken"
+
+// Session describes a signed-in device, i.e. an access/refresh token pair
+// which has not been logged out yet. It is kept across refreshes, with the
+// access UUID of the latest pair as its ID.
+type Session struct {
+	AccessUUID string    `json:"access_uuid"`
+	UserID     uint64    `json:"user_id"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// ClientID and Scopes are only set on the sessions of third-party
+	// clients, which were signed in through OAuth.
+	ClientID string   `json:"client_id,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+	// Current is only set in the listing, on the session of the caller.
+	Current bool `json:"current,omitempty"`
+}
+
+// Revocation records that the access token was signed out before it
+// expired, so that the services which cache validations can drop it.
+type Revocation struct {
+	// Seq numbers the revocations in the order they were recorded.
+	Seq        uint64    `json:"seq"`
+	AccessUUID string    `json:"access_uuid"`
+	UserID     uint64    `json:"user_id"`
+	RevokedAt  time.Time `json:"revoked_at"`
+}
+
+// Account is the user an identity of an external provider is linked to,
+// as returned by the identity linker passed through the context.
+type Account struct {
+	UserID      uint64
+	Roles       []string
+	MFARequired bool
+}
 
 var (
-	ErrInvalidArgument      = errors.New("invalid argument")
-	ErrUserIDContextMissing = errors.New("user ID was not passed through the context")
-	ErrClaimsMissing        = errors.New("JWT claims was not passed through the context")
-	ErrClaimsInvalid        = errors.New("JWT claims was invalid")
+	ErrInvalidArgument           = errors.New("invalid argument")
+	ErrUserIDContextMissing      = errors.New("user ID was not passed through the context")
+	ErrMFAVerifierContextMissing = errors.New("MFA verifier was not passed through the context")
+	ErrClaimsMissing             = errors.New("JWT claims was not passed through the context")
+	ErrClaimsInvalid             = errors.New("JWT claims was invalid")
+	ErrTooManyAttempts           = errors.New("too many failed login attempts")
+	ErrAccountLocked             = errors.New("account is temporarily locked")
+	ErrInvalidMFACode            = errors.New("invalid MFA code")
+	ErrSessionNotFound           = errors.New("session not found")
+	ErrRefreshTokenReused        = errors.New("refresh token was already used")
+	ErrClientNotFound            = errors.New("client not found")
+	ErrConsentNotFound           = errors.New("consent not found")
+	ErrProviderNotFound          = errors.New("identity provider not found")
+	ErrInvalidLoginState         = errors.New("login state was invalid or expired")
+	ErrLinkerContextMissing      = errors.New("identity linker was not passed through the context")
+	ErrTokenNotFound             = errors.New("personal access token not found")
+	ErrInvalidToken              = errors.New("personal access token was invalid, expired or revoked")
+	ErrRevocationsLost           = errors.New("revocations were lost since the cursor was issued")
 )
+
+// LockoutError is returned when a login is refused because of previous
+// failed attempts. Err is either ErrTooManyAttempts or ErrAccountLocked.
+type LockoutError struct {
+	Err   error
+	Until time.Time
+}
+
+func (e LockoutError) Error() string { return e.Err.Error() }
+
+func (e LockoutError) Unwrap() error { return e.Err }
@@ -0,0 +1,169 @@
+// Package authz authorizes callers by the roles embedded in their access
+// token and, for tokens issued to third-party clients, by their scopes.
+package authz
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+)
+
+type Permission string
+
+const (
+	ReadTasks    Permission = "tasks:read"
+	WriteTasks   Permission = "tasks:write"
+	ManageUsers  Permission = "users:manage"
+	ReadAuditLog Permission = "audit:read"
+	// IntrospectTokens allows a resource server to introspect the tokens
+	// presented to it.
+	IntrospectTokens Permission = "tokens:introspect"
+)
+
+var ErrForbidden = errors.New("permission denied")
+
+// Policy maps a role to the permissions it grants.
+type Policy map[string][]Permission
+
+var DefaultPolicy = Policy{
+	usersvc.RoleUser:    {ReadTasks, WriteTasks},
+	usersvc.RoleAdmin:   {ReadTasks, WriteTasks, ManageUsers, ReadAuditLog, IntrospectTokens},
+	usersvc.RoleService: {IntrospectTokens},
+}
+
+// Allows reports whether any of the roles grants perm.
+func (p Policy) Allows(roles []string, perm Permission) bool {
+	for _, role := range roles {
+		for _, granted := range p[role] {
+			if granted == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Require returns a middleware which fails with ErrForbidden unless the
+// roles of the caller grant every one of perms. Tokens of third-party
+// clients must also hold a scope named after each permission. It relies on
+// the claims put in the context by the JWT parser, so it must be wrapped by
+// it.
+func Require(p Policy, perms ...Permission) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			claims, err := claims(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if !Granted(claims, p, perms...) {
+				return nil, ErrForbidden
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// Granted reports whether the claims grant every one of perms as checked
+// by Require, for the callers which verified the token themselves.
+func Granted(claims stdjwt.MapClaims, p Policy, perms ...Permission) bool {
+	roles := roles(claims)
+	scopes, scoped := scopes(claims)
+	for _, perm := range perms {
+		if !p.Allows(roles, perm) {
+			return false
+		}
+		if scoped && !has(scopes, string(perm)) {
+			return false
+		}
+	}
+	return true
+}
+
+// RequireRole is like Require, but checks that the caller has one of the
+// roles instead. Tokens of third-party clients are always refused, as
+// roles are not covered by scopes.
+func RequireRole(allowed ...string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			claims, err := claims(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if _, scoped := scopes(claims); scoped {
+				return nil, ErrForbidden
+			}
+
+			roles := roles(claims)
+			for _, role := range allowed {
+				if usersvc.Roles(roles).Has(role) {
+					return next(ctx, request)
+				}
+			}
+			return nil, ErrForbidden
+		}
+	}
+}
+
+// FirstParty returns a middleware which fails with ErrForbidden for the
+// tokens of third-party clients, e.g. to keep them from managing the
+// account of the user.
+func FirstParty() endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			claims, err := claims(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if _, scoped := scopes(claims); scoped {
+				return nil, ErrForbidden
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+func claims(ctx context.Context) (stdjwt.MapClaims, error) {
+	claims, ok := ctx.Value(kitjwt.JWTClaimsContextKey).(stdjwt.MapClaims)
+	if !ok {
+		return nil, authsvc.ErrClaimsMissing
+	}
+	return claims, nil
+}
+
+func roles(claims stdjwt.MapClaims) []string {
+	// The claims are decoded from JSON, so the list holds interface{}.
+	values, _ := claims["roles"].([]interface{})
+	roles := make([]string, 0, len(values))
+	for _, v := range values {
+		if role, ok := v.(string); ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// scopes returns the space separated "scope" claim, which is only present
+// in the tokens of third-party clients.
+func scopes(claims stdjwt.MapClaims) ([]string, bool) {
+	v, ok := claims["scope"]
+	if !ok {
+		return nil, false
+	}
+	scope, _ := v.(string)
+	return strings.Fields(scope), true
+}
+
+func has(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
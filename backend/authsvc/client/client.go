@@ -1,6 +1,7 @@
 package client
 
 import (
+	"crypto/tls"
 	"io"
 	"time"
 
@@ -14,48 +15,229 @@ import (
 	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authtransport"
 )
 
-func New(apiclient consulsd.Client, logger log.Logger, retryMax int, retryTimeout time.Duration) (authendpoint.Set, error) {
+// New returns the endpoints of the instances registered in Consul. They
+// are called over TLS if tlsConfig is not nil. The admin operations are
+// called on the internal listener of the instances, which is registered
+// as a service of its own.
+func New(apiclient consulsd.Client, tlsConfig *tls.Config, logger log.Logger, retryMax int, retryTimeout time.Duration) (authendpoint.Set, error) {
 	var (
 		tags        = []string{}
 		passingOnly = true
 		endpoints   = authendpoint.Set{}
 		instancer   = consulsd.NewInstancer(apiclient, logger, "authsvc", tags, passingOnly)
+		internal    = consulsd.NewInstancer(apiclient, logger, "authsvc-internal", tags, passingOnly)
 	)
 	{
-		factory := factoryFor(authendpoint.MakeLoginEndpoint, logger)
+		factory := factoryFor(authendpoint.MakeLoginEndpoint, tlsConfig, logger)
 		endpointer := sd.NewEndpointer(instancer, factory, logger)
 		balancer := lb.NewRoundRobin(endpointer)
 		retry := lb.Retry(retryMax, retryTimeout, balancer)
 		endpoints.LoginEndpoint = retry
 	}
 	{
-		factory := factoryFor(authendpoint.MakeLogoutEndpoint, logger)
+		factory := factoryFor(authendpoint.MakeLogoutEndpoint, tlsConfig, logger)
 		endpointer := sd.NewEndpointer(instancer, factory, logger)
 		balancer := lb.NewRoundRobin(endpointer)
 		retry := lb.Retry(retryMax, retryTimeout, balancer)
 		endpoints.LogoutEndpoint = retry
 	}
 	{
-		factory := factoryFor(authendpoint.MakeRefreshEndpoint, logger)
+		factory := factoryFor(authendpoint.MakeRefreshEndpoint, tlsConfig, logger)
 		endpointer := sd.NewEndpointer(instancer, factory, logger)
 		balancer := lb.NewRoundRobin(endpointer)
 		retry := lb.Retry(retryMax, retryTimeout, balancer)
 		endpoints.RefreshEndpoint = retry
 	}
 	{
-		factory := factoryFor(authendpoint.MakeValidateEndpoint, logger)
-		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		factory := factoryFor(authendpoint.MakeValidateEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(internal, factory, logger)
 		balancer := lb.NewRoundRobin(endpointer)
 		retry := lb.Retry(retryMax, retryTimeout, balancer)
 		endpoints.ValidateEndpoint = retry
 	}
+	{
+		factory := factoryFor(authendpoint.MakeSessionsEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.SessionsEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeRevokeSessionEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.RevokeSessionEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeRevokeAllSessionsEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.RevokeAllSessionsEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeRevokeUserSessionsEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(internal, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.RevokeUserSessionsEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeVerifyMFAEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.VerifyMFAEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeEnrollTOTPEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.EnrollTOTPEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeConfirmTOTPEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.ConfirmTOTPEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeDisableTOTPEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.DisableTOTPEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeChangePasswordEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.ChangePasswordEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeJWKSEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.JWKSEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeRegisterClientEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.RegisterClientEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeClientEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.ClientEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeAuthorizeEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.AuthorizeEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeTokenEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.TokenEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeConsentsEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.ConsentsEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeRevokeConsentEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.RevokeConsentEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeIntrospectEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.IntrospectEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeRevokeTokenEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.RevokeTokenEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeOIDCLoginEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.OIDCLoginEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeOIDCCallbackEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.OIDCCallbackEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeCreatePATEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.CreatePATEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakePATsEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.PATsEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeRevokePATEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.RevokePATEndpoint = retry
+	}
+	{
+		factory := factoryFor(authendpoint.MakeResolvePATEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(internal, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.ResolvePATEndpoint = retry
+	}
+	{
+		// The request waits for a revocation, which the timeout must allow.
+		factory := factoryFor(authendpoint.MakeRevocationsEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(internal, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, authservice.MaxRevocationWait+retryTimeout, balancer)
+		endpoints.RevocationsEndpoint = retry
+	}
 
 	return endpoints, nil
 }
 
-func factoryFor(makeEndpoint func(authservice.Service) endpoint.Endpoint, logger log.Logger) sd.Factory {
+func factoryFor(makeEndpoint func(authservice.Service) endpoint.Endpoint, tlsConfig *tls.Config, logger log.Logger) sd.Factory {
 	return func(instance string) (endpoint.Endpoint, io.Closer, error) {
-		service, err := authtransport.NewHTTPClient(instance, logger)
+		service, err := authtransport.NewHTTPClient(instance, tlsConfig, logger)
 		if err != nil {
 			return nil, nil, err
 		}
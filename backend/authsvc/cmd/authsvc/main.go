@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"net"
@@ -13,14 +14,19 @@ import (
 	"text/tabwriter"
 	"time"
 
+	stdjwt "github.com/dgrijalva/jwt-go"
 	"github.com/go-kit/kit/log"
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	consulsd "github.com/go-kit/kit/sd/consul"
+	"github.com/gorilla/mux"
 	"github.com/hashicorp/consul/api"
 	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oidc"
 	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authendpoint"
 	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authservice"
 	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authtransport"
+	"github.com/ichigozero/gtdkit/backend/tlsconfig"
 	userclient "github.com/ichigozero/gtdkit/backend/usersvc/client"
 	"github.com/oklog/oklog/pkg/group"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
@@ -35,16 +41,91 @@ func main() {
 			getEnv("HTTP_ADDR", ":8081"),
 			"HTTP listen address",
 		)
+		httpInternalAddr = fs.String(
+			"http.internal.addr",
+			getEnv("HTTP_INTERNAL_ADDR", ":8091"),
+			"HTTP listen address of the admin operations, which must only be reachable by the other services",
+		)
 		consulAddr = fs.String(
 			"consul.addr",
 			getEnv("CONSUL_ADDR", ""),
 			"Consul agent address",
 		)
+		jwtAlg = fs.String(
+			"jwt.alg",
+			getEnv("JWT_ALG", "RS256"),
+			"Signing algorithm of the access tokens, RS256 or EdDSA",
+		)
+		jwtKeyFile = fs.String(
+			"jwt.key",
+			getEnv("JWT_KEY_FILE", ""),
+			"PEM encoded private key the tokens are signed with, the rotated keyring is used if empty",
+		)
+		keyringSecret = fs.String(
+			"keyring.secret",
+			getEnv("KEYRING_SECRET", ""),
+			"Secret the private keys of the keyring are encrypted with in the token store, required unless jwt.key is set",
+		)
+		tlsCA = fs.String(
+			"tls.ca",
+			getEnv("TLS_CA", ""),
+			"CA of the certificates the services present to each other, client certificates are not required if empty",
+		)
+		tlsCert = fs.String(
+			"tls.cert",
+			getEnv("TLS_CERT", ""),
+			"Certificate served and presented to the other services, TLS is disabled if empty",
+		)
+		tlsKey = fs.String(
+			"tls.key",
+			getEnv("TLS_KEY", ""),
+			"Private key of the certificate",
+		)
 		retryMax = flag.Int(
 			"retry.max",
 			getEnvAsInt("RETRY_MAX", 3),
 			"per-request retries to different instances",
 		)
+		store = fs.String(
+			"store",
+			getEnv("STORE", inmem.BackendConsul),
+			"Backend of the token store, consul, redis or memory",
+		)
+		redisAddr = fs.String(
+			"redis.addr",
+			getEnv("REDIS_ADDR", ""),
+			"Redis address of the redis store",
+		)
+		redisPassword = fs.String(
+			"redis.password",
+			getEnv("REDIS_PASSWORD", ""),
+			"Redis password of the redis store, if any",
+		)
+		oidcName = fs.String(
+			"oidc.name",
+			getEnv("OIDC_NAME", "sso"),
+			"Name of the OpenID Connect provider in the login URL",
+		)
+		oidcIssuer = fs.String(
+			"oidc.issuer",
+			getEnv("OIDC_ISSUER", ""),
+			"Issuer URL of the OpenID Connect provider, the login through it is disabled if empty",
+		)
+		oidcClientID = fs.String(
+			"oidc.client-id",
+			getEnv("OIDC_CLIENT_ID", ""),
+			"Client ID registered with the OpenID Connect provider",
+		)
+		oidcClientSecret = fs.String(
+			"oidc.client-secret",
+			getEnv("OIDC_CLIENT_SECRET", ""),
+			"Client secret registered with the OpenID Connect provider, if any",
+		)
+		oidcRedirectURL = fs.String(
+			"oidc.redirect-url",
+			getEnv("OIDC_REDIRECT_URL", ""),
+			"Frontend URL the OpenID Connect provider redirects back to",
+		)
 		retryTimeout = flag.Duration(
 			"retry.timeout",
 			time.Duration(getEnvAsInt("RETRY_TIMEOUT", 500))*time.Millisecond,
@@ -62,9 +143,22 @@ func main() {
 		logger = log.With(logger, "caller", log.DefaultCaller)
 	}
 
+	// The certificate is both served and presented to the other services.
+	tlsFiles := tlsconfig.Files{CA: *tlsCA, Cert: *tlsCert, Key: *tlsKey}
+	serverTLS, err := tlsconfig.Server(tlsFiles)
+	if err != nil {
+		logger.Log("during", "tlsconfig.Server", "err", err)
+		os.Exit(1)
+	}
+	clientTLS, err := tlsconfig.Client(tlsFiles)
+	if err != nil {
+		logger.Log("during", "tlsconfig.Client", "err", err)
+		os.Exit(1)
+	}
+
 	var (
 		client      consulsd.Client
-		registrar   *consulsd.Registrar
+		registrar   registrars
 		inmemClient inmem.Client
 	)
 	{
@@ -78,38 +172,81 @@ func main() {
 			os.Exit(1)
 		}
 
-		host, port, err := net.SplitHostPort(*httpAddr)
+		// The memory store is not shared, so it only fits a single
+		// instance.
+		inmemClient, err = inmem.New(*store, consulClient, *redisAddr, *redisPassword)
 		if err != nil {
-			logger.Log("err", err)
+			logger.Log("during", "inmem.New", "err", err)
 			os.Exit(1)
 		}
-		if host == "" {
-			host = "localhost"
-		}
-
-		p, _ := strconv.Atoi(port)
-		asr := &api.AgentServiceRegistration{
-			ID:      uuid.NewV4().String(),
-			Name:    "authsvc",
-			Address: host,
-			Port:    p,
-		}
 
+		// The admin operations are discovered as a service of their own,
+		// so that they are called on the internal listener.
 		client = consulsd.NewClient(consulClient)
-		registrar = consulsd.NewRegistrar(client, asr, logger)
+		for name, addr := range map[string]string{"authsvc": *httpAddr, "authsvc-internal": *httpInternalAddr} {
+			asr, err := registration(name, addr)
+			if err != nil {
+				logger.Log("err", err)
+				os.Exit(1)
+			}
+			registrar = append(registrar, consulsd.NewRegistrar(client, asr, logger))
+		}
 		registrar.Register()
 		defer registrar.Deregister()
-
-		inmemClient = inmem.NewClient(consulClient)
 	}
 
-	userEndpoints, _ := userclient.New(client, logger, *retryMax, *retryTimeout)
+	userEndpoints, _ := userclient.New(client, clientTLS, logger, *retryMax, *retryTimeout)
+
+	var (
+		keyProvider authservice.KeyProvider
+		keyring     *authservice.Keyring
+	)
+	{
+		var err error
+		if *jwtKeyFile != "" {
+			keyProvider, err = authservice.LoadKeyProvider(*jwtKeyFile)
+		} else {
+			// The keyring is shared by all instances through Consul and
+			// rotated by the rotatekeys command. The other services reach
+			// the store too, hence the private keys are encrypted.
+			keyring, err = authservice.NewKeyring(inmemClient, *jwtAlg, []byte(*keyringSecret))
+			keyProvider = keyring
+		}
+		if err != nil {
+			logger.Log("during", "keyProvider", "err", err)
+			registrar.Deregister()
+			os.Exit(1)
+		}
+
+		if _, method, _ := keyProvider.SigningKey(); method.Alg() != *jwtAlg {
+			logger.Log("err", "signing key does not match jwt.alg", "alg", method.Alg())
+			registrar.Deregister()
+			os.Exit(1)
+		}
+	}
 
 	fieldKeys := []string{"method"}
 
+	throttler := authservice.NewThrottler(
+		inmemClient,
+		authservice.DefaultUserPolicy,
+		authservice.DefaultIPPolicy,
+	)
+
+	providers := oidc.Providers{}
+	if *oidcIssuer != "" {
+		providers[*oidcName] = oidc.NewProvider(oidc.Config{
+			Name:         *oidcName,
+			Issuer:       *oidcIssuer,
+			ClientID:     *oidcClientID,
+			ClientSecret: *oidcClientSecret,
+			RedirectURL:  *oidcRedirectURL,
+		}, &http.Client{Timeout: 10 * time.Second})
+	}
+
 	var service authservice.Service
 	{
-		service = authservice.New(authservice.NewTokenizer(), inmemClient, logger)
+		service = authservice.New(authservice.NewTokenizer(keyProvider), throttler, inmemClient, providers, logger)
 		service = authservice.InstrumentingMiddleware(
 			kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
 				Namespace: "api",
@@ -127,15 +264,26 @@ func main() {
 		)(service)
 		service = authservice.ProxingMiddleware(
 			context.Background(),
-			userEndpoints.UserIDEndpoint,
-			userEndpoints.IsExistsEndpoint,
+			userEndpoints,
+			throttler,
 		)(service)
 	}
 
+	// The tokens are verified against the keys published by this very
+	// instance, the same way as by the other services.
 	var (
-		endpoints   = authendpoint.New(service, logger)
-		httpHandler = authtransport.NewHTTPHandler(endpoints, inmemClient, logger)
+		endpoints           = authendpoint.New(service, logger)
+		keys                = jwks.NewCache(endpoints.JWKS, stdjwt.GetSigningMethod(*jwtAlg), jwks.DefaultTTL)
+		httpHandler         = authtransport.NewHTTPHandler(endpoints, keys, logger)
+		httpInternalHandler http.Handler
 	)
+	{
+		// The admin operations are only served on the internal listener,
+		// neither on the public one nor by the gateway.
+		r := mux.NewRouter()
+		r.PathPrefix("/admin").Handler(http.StripPrefix("/admin", authtransport.NewAdminHTTPHandler(endpoints, keys, logger)))
+		httpInternalHandler = r
+	}
 
 	var g group.Group
 	{
@@ -146,6 +294,9 @@ func main() {
 			registrar.Deregister()
 			os.Exit(1)
 		}
+		if serverTLS != nil {
+			httpListener = tls.NewListener(httpListener, serverTLS)
+		}
 		g.Add(func() error {
 			logger.Log("transport", "HTTP", "addr", *httpAddr)
 			return http.Serve(httpListener, httpHandler)
@@ -153,6 +304,46 @@ func main() {
 			httpListener.Close()
 		})
 	}
+	{
+		// The internal listener is not exposed by the deployment, on top
+		// of the client certificates required by TLS.
+		httpInternalListener, err := net.Listen("tcp", *httpInternalAddr)
+		if err != nil {
+			logger.Log("transport", "internal HTTP", "during", "Listen", "err", err)
+			registrar.Deregister()
+			os.Exit(1)
+		}
+		if serverTLS != nil {
+			httpInternalListener = tls.NewListener(httpInternalListener, serverTLS)
+		}
+		g.Add(func() error {
+			logger.Log("transport", "internal HTTP", "addr", *httpInternalAddr)
+			return http.Serve(httpInternalListener, httpInternalHandler)
+		}, func(error) {
+			httpInternalListener.Close()
+		})
+	}
+	if keyring != nil {
+		// The keys rotated in the meantime are picked up, the keyring is
+		// kept as it is if Consul is not reachable.
+		cancelReload := make(chan struct{})
+		g.Add(func() error {
+			ticker := time.NewTicker(authservice.KeyringReloadInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					if err := keyring.Reload(); err != nil {
+						logger.Log("during", "keyring.Reload", "err", err)
+					}
+				case <-cancelReload:
+					return nil
+				}
+			}
+		}, func(error) {
+			close(cancelReload)
+		})
+	}
 	{
 		// This function just sits and waits for ctrl-C.
 		cancelInterrupt := make(chan struct{})
@@ -172,6 +363,40 @@ func main() {
 	logger.Log("exit", g.Run())
 }
 
+// registration describes the service listening at addr to Consul.
+func registration(name, addr string) (*api.AgentServiceRegistration, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = "localhost"
+	}
+
+	p, _ := strconv.Atoi(port)
+	return &api.AgentServiceRegistration{
+		ID:      uuid.NewV4().String(),
+		Name:    name,
+		Address: host,
+		Port:    p,
+	}, nil
+}
+
+// registrars register the services of both listeners together.
+type registrars []*consulsd.Registrar
+
+func (rs registrars) Register() {
+	for _, r := range rs {
+		r.Register()
+	}
+}
+
+func (rs registrars) Deregister() {
+	for _, r := range rs {
+		r.Deregister()
+	}
+}
+
 func usageFor(fs *flag.FlagSet, short string) func() {
 	return func() {
 		fmt.Fprintf(os.Stderr, "USAGE\n")
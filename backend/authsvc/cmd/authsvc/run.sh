@@ -1,7 +1,13 @@
 #!/bin/sh
 
-ACCESS_SECRET=uV3gqXaPOQrvJCQ1CgB2QML3ZhQ66cNk
-REFRESH_SECRET=zvphqW5UVYDn7Ew8H113woAYAJhOGpyO
+JWT_ALG=RS256
+STORE=consul
 RETRY_TIMEOUT=5000
 
-go run main.go --http.addr=127.0.0.1:8081 --consul.addr=127.0.0.1:8500
+# The login through the stub identity provider of cmd/stubidp.
+OIDC_ISSUER=http://127.0.0.1:9000
+OIDC_CLIENT_ID=gtdkit
+OIDC_CLIENT_SECRET=secret
+OIDC_REDIRECT_URL=http://127.0.0.1:3000/oidc/callback
+
+go run main.go --http.addr=127.0.0.1:8081 --http.internal.addr=127.0.0.1:8091 --consul.addr=127.0.0.1:8500
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/hashicorp/consul/api"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authservice"
+	"github.com/oklog/oklog/pkg/group"
+)
+
+func main() {
+	fs := flag.NewFlagSet("rotatekeys", flag.ExitOnError)
+	var (
+		consulAddr = fs.String(
+			"consul.addr",
+			getEnv("CONSUL_ADDR", ""),
+			"Consul agent address",
+		)
+		store = fs.String(
+			"store",
+			getEnv("STORE", inmem.BackendConsul),
+			"Backend of the token store holding the keyring, consul or redis",
+		)
+		redisAddr = fs.String(
+			"redis.addr",
+			getEnv("REDIS_ADDR", ""),
+			"Redis address of the redis store",
+		)
+		redisPassword = fs.String(
+			"redis.password",
+			getEnv("REDIS_PASSWORD", ""),
+			"Redis password of the redis store, if any",
+		)
+		jwtAlg = fs.String(
+			"jwt.alg",
+			getEnv("JWT_ALG", "RS256"),
+			"Signing algorithm of the new keys, RS256 or EdDSA",
+		)
+		keyringSecret = fs.String(
+			"keyring.secret",
+			getEnv("KEYRING_SECRET", ""),
+			"Secret the private keys are encrypted with, the one given to authsvc",
+		)
+		every = fs.Duration(
+			"every",
+			0,
+			"rotate the keys periodically, rotate once and exit if zero",
+		)
+		force = fs.Bool(
+			"force",
+			false,
+			"rotate even if the next key was not published long enough, which invalidates the tokens verified with a stale key set",
+		)
+	)
+
+	fs.Usage = usageFor(fs, os.Args[0]+" [flags]")
+	fs.Parse(os.Args[1:])
+
+	var logger log.Logger
+	{
+		logger = log.NewLogfmtLogger(os.Stderr)
+		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+		logger = log.With(logger, "caller", log.DefaultCaller)
+	}
+
+	if *every != 0 && *every < authservice.MinNextKeyAge {
+		logger.Log("err", "rotation interval is too short", "min", authservice.MinNextKeyAge)
+		os.Exit(1)
+	}
+
+	// The keyring of authsvc is out of reach if it is kept in memory.
+	if *store == inmem.BackendMemory {
+		logger.Log("err", "keys cannot be rotated in the memory store")
+		os.Exit(1)
+	}
+
+	var inmemClient inmem.Client
+	{
+		consulConfig := api.DefaultConfig()
+		if len(*consulAddr) > 0 {
+			consulConfig.Address = *consulAddr
+		}
+		consulClient, err := api.NewClient(consulConfig)
+		if err != nil {
+			logger.Log("err", err)
+			os.Exit(1)
+		}
+		inmemClient, err = inmem.New(*store, consulClient, *redisAddr, *redisPassword)
+		if err != nil {
+			logger.Log("during", "inmem.New", "err", err)
+			os.Exit(1)
+		}
+	}
+
+	rotate := func(force bool) error {
+		kid, err := authservice.Rotate(inmemClient, *jwtAlg, []byte(*keyringSecret), force)
+		if err != nil {
+			return err
+		}
+		logger.Log("msg", "keys rotated", "active", kid)
+		return nil
+	}
+
+	if *every == 0 {
+		if err := rotate(*force); err != nil {
+			logger.Log("during", "Rotate", "err", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	var g group.Group
+	{
+		cancelRotate := make(chan struct{})
+		g.Add(func() error {
+			ticker := time.NewTicker(*every)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					// A failed rotation is retried on the next tick.
+					if err := rotate(false); err != nil {
+						logger.Log("during", "Rotate", "err", err)
+					}
+				case <-cancelRotate:
+					return nil
+				}
+			}
+		}, func(error) {
+			close(cancelRotate)
+		})
+	}
+	{
+		// This function just sits and waits for ctrl-C.
+		cancelInterrupt := make(chan struct{})
+		g.Add(func() error {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+			select {
+			case sig := <-c:
+				return fmt.Errorf("received signal %s", sig)
+			case <-cancelInterrupt:
+				return nil
+			}
+		}, func(error) {
+			close(cancelInterrupt)
+		})
+	}
+	logger.Log("exit", g.Run())
+}
+
+func usageFor(fs *flag.FlagSet, short string) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, "USAGE\n")
+		fmt.Fprintf(os.Stderr, "  %s\n", short)
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "FLAGS\n")
+		w := tabwriter.NewWriter(os.Stderr, 0, 2, 2, ' ', 0)
+		fs.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(w, "\t-%s %s\t%s\n", f.Name, f.DefValue, f.Usage)
+		})
+		w.Flush()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+}
+
+func getEnv(key, fallback string) string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		value = fallback
+	}
+	return value
+}
@@ -0,0 +1,5 @@
+#!/bin/sh
+
+JWT_ALG=RS256
+
+go run main.go --consul.addr=127.0.0.1:8500 --every=24h
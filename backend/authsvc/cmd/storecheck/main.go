@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/go-kit/kit/log"
+	"github.com/hashicorp/consul/api"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem/inmemtest"
+)
+
+// storecheck runs the conformance suite of inmem.Client against a backend
+// of the token store, e.g. to verify a Redis compatible server before
+// authsvc is switched to it.
+func main() {
+	fs := flag.NewFlagSet("storecheck", flag.ExitOnError)
+	var (
+		consulAddr = fs.String(
+			"consul.addr",
+			getEnv("CONSUL_ADDR", ""),
+			"Consul agent address",
+		)
+		store = fs.String(
+			"store",
+			getEnv("STORE", inmem.BackendConsul),
+			"Backend of the token store, consul, redis or memory",
+		)
+		redisAddr = fs.String(
+			"redis.addr",
+			getEnv("REDIS_ADDR", ""),
+			"Redis address of the redis store",
+		)
+		redisPassword = fs.String(
+			"redis.password",
+			getEnv("REDIS_PASSWORD", ""),
+			"Redis password of the redis store, if any",
+		)
+	)
+
+	fs.Usage = usageFor(fs, os.Args[0]+" [flags]")
+	fs.Parse(os.Args[1:])
+
+	var logger log.Logger
+	{
+		logger = log.NewLogfmtLogger(os.Stderr)
+		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+		logger = log.With(logger, "caller", log.DefaultCaller)
+	}
+
+	consulConfig := api.DefaultConfig()
+	if len(*consulAddr) > 0 {
+		consulConfig.Address = *consulAddr
+	}
+	consulClient, err := api.NewClient(consulConfig)
+	if err != nil {
+		logger.Log("err", err)
+		os.Exit(1)
+	}
+
+	client, err := inmem.New(*store, consulClient, *redisAddr, *redisPassword)
+	if err != nil {
+		logger.Log("during", "inmem.New", "err", err)
+		os.Exit(1)
+	}
+
+	if err := inmemtest.TestClient(client); err != nil {
+		logger.Log("store", *store, "err", err)
+		os.Exit(1)
+	}
+	logger.Log("store", *store, "msg", "all checks passed")
+}
+
+func usageFor(fs *flag.FlagSet, short string) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, "USAGE\n")
+		fmt.Fprintf(os.Stderr, "  %s\n", short)
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "FLAGS\n")
+		w := tabwriter.NewWriter(os.Stderr, 0, 2, 2, ' ', 0)
+		fs.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(w, "\t-%s %s\t%s\n", f.Name, f.DefValue, f.Usage)
+		})
+		w.Flush()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+}
+
+func getEnv(key, fallback string) string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		value = fallback
+	}
+	return value
+}
@@ -0,0 +1,5 @@
+#!/bin/sh
+
+STORE=consul
+
+go run main.go --consul.addr=127.0.0.1:8500
@@ -0,0 +1,108 @@
+// Command stubidp is a minimal OpenID Connect provider to try out and test
+// the login through an external identity provider locally. Every
+// authorization request is approved right away for the configured user.
+// It must not be used in production.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"text/tabwriter"
+
+	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oidc/oidctest"
+)
+
+func main() {
+	fs := flag.NewFlagSet("stubidp", flag.ExitOnError)
+	var (
+		httpAddr = fs.String(
+			"http.addr",
+			getEnv("HTTP_ADDR", "127.0.0.1:9000"),
+			"HTTP listen address",
+		)
+		issuer = fs.String(
+			"issuer",
+			getEnv("ISSUER", "http://127.0.0.1:9000"),
+			"Issuer URL, which must be the URL the provider is reached at",
+		)
+		clientID = fs.String(
+			"client-id",
+			getEnv("CLIENT_ID", "gtdkit"),
+			"Client ID of authsvc",
+		)
+		clientSecret = fs.String(
+			"client-secret",
+			getEnv("CLIENT_SECRET", "secret"),
+			"Client secret of authsvc, public clients are accepted if empty",
+		)
+		email = fs.String(
+			"email",
+			getEnv("EMAIL", "user@example.com"),
+			"Email of the signed-in user",
+		)
+		subject = fs.String(
+			"subject",
+			getEnv("SUBJECT", ""),
+			"Subject of the signed-in user, derived from the email if empty",
+		)
+		emailVerified = fs.Bool(
+			"email-verified",
+			true,
+			"Whether the email is reported as verified",
+		)
+	)
+
+	fs.Usage = usageFor(fs, os.Args[0]+" [flags]")
+	fs.Parse(os.Args[1:])
+
+	var logger log.Logger
+	{
+		logger = log.NewLogfmtLogger(os.Stderr)
+		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+		logger = log.With(logger, "caller", log.DefaultCaller)
+	}
+
+	// The key is generated on every start, authsvc fetches the new key set
+	// as soon as it sees the unknown key ID.
+	idp, err := oidctest.NewProvider(oidctest.Config{
+		Issuer:        *issuer,
+		ClientID:      *clientID,
+		ClientSecret:  *clientSecret,
+		Email:         *email,
+		Subject:       *subject,
+		EmailVerified: *emailVerified,
+	})
+	if err != nil {
+		logger.Log("during", "NewProvider", "err", err)
+		os.Exit(1)
+	}
+
+	logger.Log("transport", "HTTP", "addr", *httpAddr, "issuer", *issuer, "email", *email)
+	logger.Log("exit", http.ListenAndServe(*httpAddr, idp))
+}
+
+func usageFor(fs *flag.FlagSet, short string) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, "USAGE\n")
+		fmt.Fprintf(os.Stderr, "  %s\n", short)
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "FLAGS\n")
+		w := tabwriter.NewWriter(os.Stderr, 0, 2, 2, ' ', 0)
+		fs.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(w, "\t-%s %s\t%s\n", f.Name, f.DefValue, f.Usage)
+		})
+		w.Flush()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+}
+
+func getEnv(key, fallback string) string {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		value = fallback
+	}
+	return value
+}
@@ -0,0 +1,3 @@
+#!/bin/sh
+
+go run main.go --http.addr=127.0.0.1:9000 --issuer=http://127.0.0.1:9000 --email=user@example.com
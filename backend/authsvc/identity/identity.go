@@ -0,0 +1,164 @@
+// Package identity passes the caller authenticated by the gateway on to
+// the services behind it. The gateway verifies the access token, validates
+// its session with authsvc and signs the claims into a short-lived internal
+// token, which the services trust instead of verifying the access token
+// and calling authsvc again. The key is shared by the gateway and the
+// services only.
+package identity
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// Header carries the identity in HTTP requests. The gateway drops it
+	// from the requests it accepts, so that it cannot be forged by clients.
+	Header = "X-Gtd-Identity"
+	// Claim marks the claims of an identity, whose session was already
+	// validated by the gateway.
+	Claim = "edge"
+	// Issuer is the issuer of the identities.
+	Issuer = "apigateway"
+
+	// ttl is short, as the identity is only used for a single request.
+	ttl = 30 * time.Second
+)
+
+// metadataKey carries the identity in gRPC metadata, which is lowercase.
+const metadataKey = "x-gtd-identity"
+
+type contextKey string
+
+// ContextKey holds the signed identity in the context.
+const ContextKey contextKey = "Identity"
+
+var (
+	ErrKeyMissing      = errors.New("identity key was not configured")
+	ErrInvalidIdentity = errors.New("identity was invalid or expired")
+)
+
+// Signer signs the identities in the gateway.
+type Signer struct {
+	key []byte
+}
+
+func NewSigner(key []byte) (*Signer, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyMissing
+	}
+	return &Signer{key: key}, nil
+}
+
+// Sign returns the identity of the verified claims. It expires with the
+// access token, but at most after ttl.
+func (s *Signer) Sign(claims stdjwt.MapClaims) (string, error) {
+	now := time.Now()
+
+	c := make(stdjwt.MapClaims, len(claims)+3)
+	for k, v := range claims {
+		c[k] = v
+	}
+	c["iss"] = Issuer
+	c["iat"] = now.Unix()
+	c[Claim] = true
+
+	exp := now.Add(ttl).Unix()
+	if e, ok := claims["exp"].(float64); ok && int64(e) < exp {
+		exp = int64(e)
+	}
+	c["exp"] = exp
+
+	return stdjwt.NewWithClaims(stdjwt.SigningMethodHS256, c).SignedString(s.key)
+}
+
+// Verify returns the claims of the identity.
+func Verify(key []byte, identity string) (stdjwt.MapClaims, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyMissing
+	}
+
+	token, err := stdjwt.ParseWithClaims(identity, stdjwt.MapClaims{}, func(token *stdjwt.Token) (interface{}, error) {
+		if token.Method != stdjwt.SigningMethodHS256 {
+			return nil, ErrInvalidIdentity
+		}
+		return key, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidIdentity
+	}
+
+	claims := token.Claims.(stdjwt.MapClaims)
+	if !claims.VerifyIssuer(Issuer, true) {
+		return nil, ErrInvalidIdentity
+	}
+	if edge, _ := claims[Claim].(bool); !edge {
+		return nil, ErrInvalidIdentity
+	}
+	return claims, nil
+}
+
+// NewParser returns a middleware which puts the claims of the identity in
+// the context like kitjwt does, and hands the requests without one to
+// parser, usually the parser of the access tokens. Without a key the
+// identities are ignored, so that they cannot be forged.
+func NewParser(key []byte, parser endpoint.Middleware) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		parsed := parser(next)
+
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			identity, ok := ctx.Value(ContextKey).(string)
+			if !ok || identity == "" || len(key) == 0 {
+				return parsed(ctx, request)
+			}
+
+			claims, err := Verify(key, identity)
+			if err != nil {
+				return nil, err
+			}
+
+			ctx = context.WithValue(ctx, kitjwt.JWTClaimsContextKey, claims)
+			return next(ctx, request)
+		}
+	}
+}
+
+// HTTPToContext moves the identity from the request header to the context.
+func HTTPToContext() httptransport.RequestFunc {
+	return func(ctx context.Context, r *http.Request) context.Context {
+		if identity := r.Header.Get(Header); identity != "" {
+			ctx = context.WithValue(ctx, ContextKey, identity)
+		}
+		return ctx
+	}
+}
+
+// GRPCToContext moves the identity from the request metadata to the
+// context.
+func GRPCToContext() grpctransport.ServerRequestFunc {
+	return func(ctx context.Context, md metadata.MD) context.Context {
+		if values := md.Get(metadataKey); len(values) > 0 && values[0] != "" {
+			ctx = context.WithValue(ctx, ContextKey, values[0])
+		}
+		return ctx
+	}
+}
+
+// ContextToGRPC forwards the identity found in the context to the service.
+func ContextToGRPC() grpctransport.ClientRequestFunc {
+	return func(ctx context.Context, md *metadata.MD) context.Context {
+		if identity, ok := ctx.Value(ContextKey).(string); ok && identity != "" {
+			(*md)[metadataKey] = []string{identity}
+		}
+		return ctx
+	}
+}
@@ -0,0 +1,313 @@
+package inmem
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// Consul limits the TTL of a session to this range.
+const (
+	minSessionTTL = 10 * time.Second
+	maxSessionTTL = 24 * time.Hour
+)
+
+const (
+	// expiryPrefix holds a marker for each key put with a TTL longer than
+	// a session may last, so that sweep finds them without listing the
+	// whole store.
+	expiryPrefix = "inmem/expiry/"
+	// consulSweepInterval is the least time between two sweeps.
+	consulSweepInterval = 10 * time.Minute
+)
+
+type consulClient struct {
+	consul *consul.Client
+
+	mtx       sync.Mutex
+	sessions  map[time.Duration]bucketSession
+	lastSweep time.Time
+}
+
+// bucketSession is the session acquiring the keys whose TTL falls into a
+// bucket, which may be reused by the keys expiring before the session.
+type bucketSession struct {
+	id      string
+	expires time.Time
+}
+
+// NewConsulClient stores the keys in Consul KV. Keys put with a TTL are
+// acquired by a session which deletes them once it expired. A session is
+// shared by the keys whose TTL rounds up to the same power of two multiple
+// of the minimum session TTL, and is replaced once a key would outlive it.
+// As the session TTL is limited to 24 hours, longer lived keys are deleted
+// by a sweep, which runs in the background at most every ten minutes
+// after a write.
+func NewConsulClient(c *consul.Client) Client {
+	return &consulClient{consul: c, sessions: make(map[time.Duration]bucketSession)}
+}
+
+func (c *consulClient) Get(key string) ([]byte, error) {
+	kv, _, err := c.consul.KV().Get(key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if kv == nil {
+		return nil, ErrKeyNotFound
+	}
+	if expired(kv) {
+		c.consul.KV().DeleteCAS(kv, nil)
+		return nil, ErrKeyNotFound
+	}
+
+	return kv.Value, nil
+}
+
+func (c *consulClient) Put(key string, value []byte) error {
+	return c.put(key, value, 0)
+}
+
+// PutTTL also stores the expiry time as Unix time in milliseconds in the
+// flags of the pair, since the session may outlive its TTL by up to twice
+// the TTL. Expired pairs are treated as missing when read.
+func (c *consulClient) PutTTL(key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	return c.put(key, value, ttl)
+}
+
+func (c *consulClient) CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error) {
+	if ttl < 0 {
+		return false, ErrInvalidTTL
+	}
+
+	kv, _, err := c.consul.KV().Get(key, nil)
+	if err != nil {
+		return false, err
+	}
+
+	current := kv
+	if kv != nil && expired(kv) {
+		current = nil
+	}
+	if (current != nil) != (old != nil) || current != nil && !bytes.Equal(current.Value, old) {
+		return false, nil
+	}
+	return c.write(key, kv, value, ttl)
+}
+
+// put writes the value whatever the key holds, it only reads the key again
+// if it was written in between.
+func (c *consulClient) put(key string, value []byte, ttl time.Duration) error {
+	for i := 0; i < maxUpdateAttempts; i++ {
+		kv, _, err := c.consul.KV().Get(key, nil)
+		if err != nil {
+			return err
+		}
+
+		ok, err := c.write(key, kv, value, ttl)
+		if err != nil || ok {
+			return err
+		}
+	}
+	return ErrConflict
+}
+
+// write replaces prev, the pair last read, in a transaction which fails if
+// the key was written since. The key is released from the session of a
+// previous PutTTL, so that it is not deleted with that session, and
+// acquired by the session of its bucket if the ttl is not longer than a
+// session may last. Otherwise a marker is written for sweep.
+func (c *consulClient) write(key string, prev *consul.KVPair, value []byte, ttl time.Duration) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		var ops consul.TxnOps
+		if prev == nil {
+			ops = append(ops, &consul.TxnOp{KV: &consul.KVTxnOp{Verb: consul.KVCheckNotExists, Key: key}})
+		} else {
+			ops = append(ops, &consul.TxnOp{KV: &consul.KVTxnOp{Verb: consul.KVCheckIndex, Key: key, Index: prev.ModifyIndex}})
+		}
+
+		set := &consul.KVTxnOp{Verb: consul.KVSet, Key: key, Value: value}
+		if ttl > 0 {
+			set.Flags = uint64(time.Now().Add(ttl).UnixMilli())
+		}
+		if ttl > 0 && ttl <= maxSessionTTL {
+			session, err := c.session(ttl)
+			if err != nil {
+				return false, err
+			}
+			set.Verb, set.Session = consul.KVLock, session
+		}
+		if ttl > maxSessionTTL {
+			ops = append(ops, &consul.TxnOp{KV: &consul.KVTxnOp{Verb: consul.KVSet, Key: expiryPrefix + key, Flags: set.Flags}})
+		}
+		if prev != nil && prev.Session != "" && prev.Session != set.Session {
+			ops = append(ops, &consul.TxnOp{KV: &consul.KVTxnOp{Verb: consul.KVUnlock, Key: key, Session: prev.Session}})
+		}
+		ops = append(ops, &consul.TxnOp{KV: set})
+
+		ok, resp, _, err := c.consul.Txn().Txn(ops, nil)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			c.sweepLater()
+			return true, nil
+		}
+
+		// The transaction fails at the check if the key was written since,
+		// or at the lock if the session is gone before it expired, e.g. as
+		// it was invalidated by an operator. The latter is retried once
+		// with a new session.
+		if set.Session == "" || attempt > 0 || !failedAt(resp, len(ops)-1) {
+			return false, nil
+		}
+		c.forget(set.Session)
+	}
+}
+
+// session returns the session of the bucket of the ttl, creating a new one
+// if the key would outlive the current one.
+func (c *consulClient) session(ttl time.Duration) (string, error) {
+	bucket := minSessionTTL
+	for bucket < ttl {
+		bucket *= 2
+	}
+	if bucket > maxSessionTTL {
+		bucket = maxSessionTTL
+	}
+
+	c.mtx.Lock()
+	s, ok := c.sessions[bucket]
+	c.mtx.Unlock()
+	if ok && !time.Now().Add(ttl).After(s.expires) {
+		return s.id, nil
+	}
+
+	// The session lasts twice the bucket, so that it is reused for at
+	// least the bucket. Consul expires a session between its TTL and twice
+	// its TTL after the last renewal, thus the keys are deleted within
+	// four times their bucket.
+	sessionTTL := 2 * bucket
+	if sessionTTL > maxSessionTTL {
+		sessionTTL = maxSessionTTL
+	}
+	begin := time.Now()
+	// The lock delay would otherwise keep the keys from being acquired
+	// again for 15 seconds after the session expired.
+	id, _, err := c.consul.Session().CreateNoChecks(&consul.SessionEntry{
+		Name:      "inmem:" + bucket.String(),
+		TTL:       sessionTTL.String(),
+		Behavior:  consul.SessionBehaviorDelete,
+		LockDelay: time.Millisecond,
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+
+	c.mtx.Lock()
+	c.sessions[bucket] = bucketSession{id: id, expires: begin.Add(sessionTTL)}
+	c.mtx.Unlock()
+	return id, nil
+}
+
+// forget drops the session, so that its bucket gets a new one.
+func (c *consulClient) forget(id string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for bucket, s := range c.sessions {
+		if s.id == id {
+			delete(c.sessions, bucket)
+		}
+	}
+}
+
+// sweepLater starts a sweep in the background unless one was started
+// within the consulSweepInterval.
+func (c *consulClient) sweepLater() {
+	c.mtx.Lock()
+	due := time.Since(c.lastSweep) >= consulSweepInterval
+	if due {
+		c.lastSweep = time.Now()
+	}
+	c.mtx.Unlock()
+
+	if due {
+		go c.sweep()
+	}
+}
+
+// sweep deletes the expired keys which are not acquired by a session,
+// found through their markers. A key or marker which was written since it
+// was read is left alone. The marker of a key which was put again without
+// a marker is deleted once it expired.
+func (c *consulClient) sweep() {
+	markers, _, err := c.consul.KV().List(expiryPrefix, nil)
+	if err != nil {
+		return
+	}
+
+	for _, m := range markers {
+		if !expired(m) {
+			continue
+		}
+
+		kv, _, err := c.consul.KV().Get(strings.TrimPrefix(m.Key, expiryPrefix), nil)
+		if err != nil {
+			continue
+		}
+		if kv != nil && expired(kv) {
+			c.consul.KV().DeleteCAS(kv, nil)
+		}
+		c.consul.KV().DeleteCAS(m, nil)
+	}
+}
+
+// failedAt reports whether the transaction was rolled back because of the
+// operation at the index.
+func failedAt(resp *consul.TxnResponse, index int) bool {
+	if resp == nil {
+		return false
+	}
+	for _, e := range resp.Errors {
+		if e.OpIndex == index {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *consulClient) Delete(key string) error {
+	_, err := c.consul.KV().Delete(key, nil)
+
+	return err
+}
+
+func (c *consulClient) List(prefix string) ([][]byte, error) {
+	pairs, _, err := c.consul.KV().List(prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, 0, len(pairs))
+	for _, p := range pairs {
+		if expired(p) {
+			c.consul.KV().DeleteCAS(p, nil)
+			continue
+		}
+		values = append(values, p.Value)
+	}
+
+	return values, nil
+}
+
+func expired(p *consul.KVPair) bool {
+	return p.Flags != 0 && time.Now().UnixMilli() >= int64(p.Flags)
+}
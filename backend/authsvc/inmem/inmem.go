@@ -2,48 +2,90 @@ package inmem
 
 import (
 	"errors"
+	"fmt"
+	"time"
 
 	consul "github.com/hashicorp/consul/api"
 )
 
+// Client is a key-value store holding short-lived state such as tokens,
+// sessions and login attempts. Keys are paths separated by slashes, so that
+// related keys can be listed by their common prefix.
 type Client interface {
-	Get(key string) error
+	Get(key string) ([]byte, error)
+	// Put stores the value without expiry, removing any expiry set by a
+	// previous PutTTL.
 	Put(key string, value []byte) error
+	// PutTTL stores the value until the ttl elapsed, after which the key is
+	// treated as missing and eventually removed from the store.
+	PutTTL(key string, value []byte, ttl time.Duration) error
+	// Delete removes the key, deleting a missing key is not an error.
 	Delete(key string) error
+	// List returns the values of the unexpired keys with the given prefix,
+	// in no particular order.
+	List(prefix string) ([][]byte, error)
+	// CompareAndSwap stores the value if the key holds old, or is missing
+	// if old is nil, and reports whether it did. The value expires after
+	// the ttl as with PutTTL, or never if the ttl is zero.
+	CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error)
 }
 
-type client struct {
-	consul *consul.Client
-}
-
-func NewClient(c *consul.Client) Client {
-	return &client{c}
-}
-
-func (c *client) Get(key string) error {
-	kv, _, err := c.consul.KV().Get(key, nil)
-	if err != nil {
-		return err
-	}
+var (
+	ErrKeyNotFound = errors.New("key not found")
+	ErrInvalidTTL  = errors.New("ttl must be positive")
+	ErrConflict    = errors.New("key changed concurrently")
+)
 
-	if kv == nil {
-		return ErrKeyNotFound
-	}
+// maxUpdateAttempts is the number of times Update reads the key again
+// after it was changed concurrently.
+const maxUpdateAttempts = 50
 
-	return nil
-}
+// Update replaces the value of the key with the one returned by fn, which
+// is given the current value, or nil if the key is missing. The returned
+// ttl is that of CompareAndSwap. Nothing is stored if fn fails. As fn is
+// called again whenever the key was changed in between, it must not have
+// side effects.
+func Update(c Client, key string, fn func(value []byte) ([]byte, time.Duration, error)) error {
+	for i := 0; i < maxUpdateAttempts; i++ {
+		old, err := c.Get(key)
+		if err != nil && err != ErrKeyNotFound {
+			return err
+		}
 
-func (c *client) Put(key string, value []byte) error {
-	p := &consul.KVPair{Key: key, Value: value}
-	_, err := c.consul.KV().Put(p, nil)
+		value, ttl, err := fn(old)
+		if err != nil {
+			return err
+		}
 
-	return err
+		ok, err := c.CompareAndSwap(key, old, value, ttl)
+		if err != nil || ok {
+			return err
+		}
+	}
+	return ErrConflict
 }
 
-func (c *client) Delete(key string) error {
-	_, err := c.consul.KV().Delete(key, nil)
+// The backends of New.
+const (
+	BackendConsul = "consul"
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
 
-	return err
+// New returns the client of the named backend. The Consul client is only
+// used by the consul backend, the Redis address and password only by the
+// redis backend.
+func New(backend string, c *consul.Client, redisAddr, redisPassword string) (Client, error) {
+	switch backend {
+	case BackendConsul:
+		return NewConsulClient(c), nil
+	case BackendMemory:
+		return NewMemoryClient(), nil
+	case BackendRedis:
+		if redisAddr == "" {
+			return nil, errors.New("redis address is required")
+		}
+		return NewRedisClient(redisAddr, redisPassword), nil
+	}
+	return nil, fmt.Errorf("unknown store backend %q", backend)
 }
-
-var ErrKeyNotFound = errors.New("key not found")
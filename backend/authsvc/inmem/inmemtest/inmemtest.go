@@ -0,0 +1,285 @@
+// Package inmemtest implements a conformance suite for the implementations
+// of inmem.Client.
+package inmemtest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/twinj/uuid"
+)
+
+// TTL is the TTL of the keys put by TestClient, which waits for them to
+// expire. It is long enough to tell it from the latency of the store.
+const TTL = 2 * time.Second
+
+// TestClient checks that the client behaves as documented by inmem.Client.
+// The keys are written below a random prefix which is removed afterwards,
+// so that the suite can be run against a store in use. It returns the
+// failed checks joined into a single error.
+func TestClient(c inmem.Client) error {
+	t := &tester{c: c, prefix: "inmemtest/" + uuid.NewV4().String() + "/"}
+	defer t.cleanup()
+
+	t.testGetMissing()
+	t.testPut()
+	t.testDelete()
+	t.testList()
+	t.testInvalidTTL()
+	t.testCompareAndSwap()
+	t.testTTL()
+
+	if len(t.errs) == 0 {
+		return nil
+	}
+
+	var b bytes.Buffer
+	for _, err := range t.errs {
+		fmt.Fprintf(&b, "\n%v", err)
+	}
+	return fmt.Errorf("inmemtest: %d check(s) failed:%s", len(t.errs), b.String())
+}
+
+type tester struct {
+	c      inmem.Client
+	prefix string
+	keys   []string
+	errs   []error
+}
+
+func (t *tester) key(name string) string {
+	k := t.prefix + name
+	t.keys = append(t.keys, k)
+	return k
+}
+
+func (t *tester) errorf(format string, args ...interface{}) {
+	t.errs = append(t.errs, fmt.Errorf(format, args...))
+}
+
+func (t *tester) cleanup() {
+	for _, k := range t.keys {
+		t.c.Delete(k)
+	}
+}
+
+// expect checks that the key holds the value, or is missing if want is nil.
+func (t *tester) expect(check, key string, want []byte) {
+	got, err := t.c.Get(key)
+	switch {
+	case want == nil && err != inmem.ErrKeyNotFound:
+		t.errorf("%s: Get(%q) = %q, %v; want ErrKeyNotFound", check, key, got, err)
+	case want != nil && err != nil:
+		t.errorf("%s: Get(%q) failed: %v", check, key, err)
+	case want != nil && !bytes.Equal(got, want):
+		t.errorf("%s: Get(%q) = %q; want %q", check, key, got, want)
+	}
+}
+
+func (t *tester) testGetMissing() {
+	t.expect("get missing", t.key("missing"), nil)
+}
+
+func (t *tester) testPut() {
+	k := t.key("put")
+	if err := t.c.Put(k, []byte("v1")); err != nil {
+		t.errorf("put: Put failed: %v", err)
+		return
+	}
+	t.expect("put", k, []byte("v1"))
+
+	if err := t.c.Put(k, []byte("v2")); err != nil {
+		t.errorf("put: overwriting Put failed: %v", err)
+		return
+	}
+	t.expect("put overwrites", k, []byte("v2"))
+
+	// Values are arbitrary bytes.
+	binary := []byte{0, '\r', '\n', 0xff, '$', '*'}
+	if err := t.c.Put(k, binary); err != nil {
+		t.errorf("put binary: Put failed: %v", err)
+		return
+	}
+	t.expect("put binary", k, binary)
+}
+
+func (t *tester) testDelete() {
+	k := t.key("delete")
+	if err := t.c.Put(k, []byte("v")); err != nil {
+		t.errorf("delete: Put failed: %v", err)
+		return
+	}
+	if err := t.c.Delete(k); err != nil {
+		t.errorf("delete: Delete failed: %v", err)
+	}
+	t.expect("delete", k, nil)
+
+	if err := t.c.Delete(k); err != nil {
+		t.errorf("delete missing: Delete failed: %v", err)
+	}
+}
+
+func (t *tester) testList() {
+	var (
+		a     = t.key("list/a")
+		b     = t.key("list/b")
+		other = t.key("listother")
+		glob  = t.key("list*/c")
+	)
+	for _, k := range []string{a, b, other, glob} {
+		if err := t.c.Put(k, []byte(k)); err != nil {
+			t.errorf("list: Put failed: %v", err)
+			return
+		}
+	}
+
+	t.expectList("list", t.prefix+"list/", a, b)
+	// The prefix is matched literally.
+	t.expectList("list literal prefix", t.prefix+"list*", glob)
+	t.expectList("list missing", t.prefix+"none/")
+}
+
+// expectList checks that the prefix lists the values of exactly the keys,
+// whose value is the key itself.
+func (t *tester) expectList(check, prefix string, keys ...string) {
+	values, err := t.c.List(prefix)
+	if err != nil {
+		t.errorf("%s: List(%q) failed: %v", check, prefix, err)
+		return
+	}
+
+	got := make([]string, 0, len(values))
+	for _, v := range values {
+		got = append(got, string(v))
+	}
+	sort.Strings(got)
+	sort.Strings(keys)
+
+	if fmt.Sprint(got) != fmt.Sprint(keys) {
+		t.errorf("%s: List(%q) = %q; want %q", check, prefix, got, keys)
+	}
+}
+
+func (t *tester) testInvalidTTL() {
+	k := t.key("invalid-ttl")
+	for _, ttl := range []time.Duration{0, -time.Second} {
+		if err := t.c.PutTTL(k, []byte("v"), ttl); !errors.Is(err, inmem.ErrInvalidTTL) {
+			t.errorf("invalid ttl: PutTTL(%v) = %v; want ErrInvalidTTL", ttl, err)
+		}
+	}
+	t.expect("invalid ttl", k, nil)
+}
+
+func (t *tester) testCompareAndSwap() {
+	k := t.key("cas")
+	swap := func(check string, old, value []byte, ttl time.Duration, want bool) {
+		ok, err := t.c.CompareAndSwap(k, old, value, ttl)
+		if err != nil {
+			t.errorf("%s: CompareAndSwap failed: %v", check, err)
+		} else if ok != want {
+			t.errorf("%s: CompareAndSwap(%q, %q) = %v; want %v", check, old, value, ok, want)
+		}
+	}
+
+	swap("cas missing", nil, []byte("v1"), 0, true)
+	t.expect("cas missing", k, []byte("v1"))
+	swap("cas not missing", nil, []byte("v2"), 0, false)
+	swap("cas mismatch", []byte("v2"), []byte("v3"), 0, false)
+	t.expect("cas mismatch", k, []byte("v1"))
+	swap("cas match", []byte("v1"), []byte("v2"), time.Hour, true)
+	t.expect("cas match", k, []byte("v2"))
+
+	// A Put is seen by the next CompareAndSwap.
+	if err := t.c.Put(k, []byte("v3")); err != nil {
+		t.errorf("cas: Put failed: %v", err)
+		return
+	}
+	swap("cas after put", []byte("v2"), []byte("v4"), 0, false)
+	swap("cas after put", []byte("v3"), []byte("v4"), 0, true)
+
+	if _, err := t.c.CompareAndSwap(k, []byte("v4"), []byte("v5"), -time.Second); !errors.Is(err, inmem.ErrInvalidTTL) {
+		t.errorf("cas invalid ttl: CompareAndSwap = %v; want ErrInvalidTTL", err)
+	}
+	t.expect("cas invalid ttl", k, []byte("v4"))
+
+	if err := t.c.Delete(k); err != nil {
+		t.errorf("cas: Delete failed: %v", err)
+		return
+	}
+	swap("cas deleted", []byte("v4"), []byte("v5"), 0, false)
+	swap("cas deleted", nil, []byte("v5"), 0, true)
+}
+
+// testTTL puts all keys first and waits for them to expire only once.
+func (t *tester) testTTL() {
+	var (
+		expiring  = t.key("ttl/expiring")
+		persisted = t.key("ttl/persisted")
+		renewed   = t.key("ttl/renewed")
+		swapped   = t.key("ttl/swapped")
+		listed    = t.key("ttl/list/expiring")
+		kept      = t.key("ttl/list/kept")
+	)
+
+	puts := []struct {
+		key string
+		ttl time.Duration
+	}{
+		{expiring, TTL},
+		{persisted, TTL},
+		{renewed, TTL},
+		{swapped, TTL},
+		{listed, TTL},
+		{kept, 0},
+	}
+	for _, p := range puts {
+		var err error
+		if p.ttl == 0 {
+			err = t.c.Put(p.key, []byte(p.key))
+		} else {
+			err = t.c.PutTTL(p.key, []byte(p.key), p.ttl)
+		}
+		if err != nil {
+			t.errorf("ttl: putting %q failed: %v", p.key, err)
+			return
+		}
+	}
+	begin := time.Now()
+
+	t.expect("ttl before expiry", expiring, []byte(expiring))
+	t.expectList("ttl list before expiry", t.prefix+"ttl/list/", listed, kept)
+
+	// A Put removes the TTL, a PutTTL replaces it.
+	if err := t.c.Put(persisted, []byte("persisted")); err != nil {
+		t.errorf("ttl: Put failed: %v", err)
+	}
+	if err := t.c.PutTTL(renewed, []byte("renewed"), time.Hour); err != nil {
+		t.errorf("ttl: PutTTL failed: %v", err)
+	}
+
+	time.Sleep(TTL - time.Since(begin) + TTL/2)
+
+	t.expect("ttl after expiry", expiring, nil)
+	t.expect("put removes ttl", persisted, []byte("persisted"))
+	t.expect("put ttl replaces ttl", renewed, []byte("renewed"))
+	t.expectList("ttl list after expiry", t.prefix+"ttl/list/", kept)
+
+	// An expired key is missing to CompareAndSwap too.
+	if ok, err := t.c.CompareAndSwap(swapped, []byte(swapped), []byte("swapped"), 0); err != nil || ok {
+		t.errorf("cas after expiry: CompareAndSwap(value) = %v, %v; want false", ok, err)
+	}
+	if ok, err := t.c.CompareAndSwap(swapped, nil, []byte("swapped"), 0); err != nil || !ok {
+		t.errorf("cas after expiry: CompareAndSwap(nil) = %v, %v; want true", ok, err)
+	}
+	t.expect("cas after expiry", swapped, []byte("swapped"))
+
+	// An expired key can be put again right away.
+	if err := t.c.PutTTL(expiring, []byte("again"), time.Hour); err != nil {
+		t.errorf("ttl: PutTTL after expiry failed: %v", err)
+	}
+	t.expect("put after expiry", expiring, []byte("again"))
+}
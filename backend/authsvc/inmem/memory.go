@@ -0,0 +1,134 @@
+package inmem
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the expired keys are removed from the memory
+// client, on the next write.
+const sweepInterval = time.Minute
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expires.IsZero() && !now.Before(e.expires)
+}
+
+type memoryClient struct {
+	mtx       sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+// NewMemoryClient keeps the keys in the memory of the process. It is meant
+// for development and tests, as the keys are neither shared between the
+// instances nor persisted.
+func NewMemoryClient() Client {
+	return &memoryClient{entries: map[string]memoryEntry{}, lastSweep: time.Now()}
+}
+
+func (c *memoryClient) Get(key string) ([]byte, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || e.expired(time.Now()) {
+		return nil, ErrKeyNotFound
+	}
+	return copyBytes(e.value), nil
+}
+
+func (c *memoryClient) Put(key string, value []byte) error {
+	c.put(key, memoryEntry{value: copyBytes(value)})
+	return nil
+}
+
+func (c *memoryClient) PutTTL(key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	c.put(key, memoryEntry{value: copyBytes(value), expires: time.Now().Add(ttl)})
+	return nil
+}
+
+func (c *memoryClient) Delete(key string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	delete(c.entries, key)
+	return nil
+}
+
+func (c *memoryClient) List(prefix string) ([][]byte, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := time.Now()
+	var values [][]byte
+	for k, e := range c.entries {
+		if strings.HasPrefix(k, prefix) && !e.expired(now) {
+			values = append(values, copyBytes(e.value))
+		}
+	}
+	return values, nil
+}
+
+func (c *memoryClient) CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error) {
+	if ttl < 0 {
+		return false, ErrInvalidTTL
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := time.Now()
+	e, ok := c.entries[key]
+	if ok && e.expired(now) {
+		ok = false
+	}
+	if ok != (old != nil) || ok && !bytes.Equal(e.value, old) {
+		return false, nil
+	}
+
+	e = memoryEntry{value: copyBytes(value)}
+	if ttl > 0 {
+		e.expires = now.Add(ttl)
+	}
+	c.putLocked(key, e)
+	return true, nil
+}
+
+func (c *memoryClient) put(key string, e memoryEntry) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.putLocked(key, e)
+}
+
+func (c *memoryClient) putLocked(key string, e memoryEntry) {
+	c.entries[key] = e
+
+	now := time.Now()
+	if now.Sub(c.lastSweep) < sweepInterval {
+		return
+	}
+	for k, e := range c.entries {
+		if e.expired(now) {
+			delete(c.entries, k)
+		}
+	}
+	c.lastSweep = now
+}
+
+// copyBytes keeps the stored values from being modified by the callers.
+// An empty value is not nil, which is a missing key to CompareAndSwap.
+func copyBytes(b []byte) []byte {
+	return append(make([]byte, 0, len(b)), b...)
+}
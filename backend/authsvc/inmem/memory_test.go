@@ -0,0 +1,55 @@
+package inmem_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem/inmemtest"
+)
+
+func TestMemoryClient(t *testing.T) {
+	t.Parallel()
+
+	if err := inmemtest.TestClient(inmem.NewMemoryClient()); err != nil {
+		t.Error(err)
+	}
+}
+
+// The clients of the external stores are only tested if the address of the
+// store is set in the environment, e.g.
+//
+//	CONSUL_ADDR=localhost:8500 REDIS_ADDR=localhost:6379 go test ./authsvc/inmem
+func TestConsulClient(t *testing.T) {
+	t.Parallel()
+
+	addr := os.Getenv("CONSUL_ADDR")
+	if addr == "" {
+		t.Skip("CONSUL_ADDR is not set")
+	}
+
+	config := api.DefaultConfig()
+	config.Address = addr
+	c, err := api.NewClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := inmemtest.TestClient(inmem.NewConsulClient(c)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRedisClient(t *testing.T) {
+	t.Parallel()
+
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR is not set")
+	}
+
+	if err := inmemtest.TestClient(inmem.NewRedisClient(addr, os.Getenv("REDIS_PASSWORD"))); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,287 @@
+package inmem
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	redisPoolSize = 8
+	redisTimeout  = 5 * time.Second
+	// redisScanCount is the number of keys a SCAN is hinted to visit.
+	redisScanCount = 100
+)
+
+// compareAndSwapScript sets KEYS[1] to ARGV[3] if it holds ARGV[2], or is
+// missing if ARGV[1] is 0. ARGV[4] is the TTL in milliseconds, 0 for none.
+const compareAndSwapScript = `
+local v = redis.call('GET', KEYS[1])
+if ARGV[1] == '1' then
+	if v ~= ARGV[2] then
+		return 0
+	end
+elseif v then
+	return 0
+end
+if ARGV[4] == '0' then
+	redis.call('SET', KEYS[1], ARGV[3])
+else
+	redis.call('SET', KEYS[1], ARGV[3], 'PX', ARGV[4])
+end
+return 1
+`
+
+// RedisError is an error reply of the Redis server.
+type RedisError string
+
+func (e RedisError) Error() string { return string(e) }
+
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+type redisClient struct {
+	addr     string
+	password string
+	pool     chan *redisConn
+}
+
+// NewRedisClient stores the keys in a server speaking the Redis protocol,
+// e.g. Redis or KeyDB, which expires the keys put with a TTL by itself.
+// Connections are dialed on demand and up to redisPoolSize idle connections
+// are kept. An empty password skips the authentication.
+func NewRedisClient(addr, password string) Client {
+	return &redisClient{
+		addr:     addr,
+		password: password,
+		pool:     make(chan *redisConn, redisPoolSize),
+	}
+}
+
+func (c *redisClient) Get(key string) ([]byte, error) {
+	reply, err := c.do("GET", key)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrKeyNotFound
+	}
+	return reply.([]byte), nil
+}
+
+func (c *redisClient) Put(key string, value []byte) error {
+	_, err := c.do("SET", key, string(value))
+	return err
+}
+
+func (c *redisClient) PutTTL(key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return ErrInvalidTTL
+	}
+
+	// The TTL is rounded up, as PX 0 is refused by the server.
+	ms := (ttl + time.Millisecond - 1) / time.Millisecond
+	_, err := c.do("SET", key, string(value), "PX", strconv.FormatInt(int64(ms), 10))
+	return err
+}
+
+// CompareAndSwap runs as a script, which the server does not interleave
+// with other commands.
+func (c *redisClient) CompareAndSwap(key string, old, value []byte, ttl time.Duration) (bool, error) {
+	if ttl < 0 {
+		return false, ErrInvalidTTL
+	}
+
+	exists := "0"
+	if old != nil {
+		exists = "1"
+	}
+	ms := (ttl + time.Millisecond - 1) / time.Millisecond
+	reply, err := c.do("EVAL", compareAndSwapScript, "1", key, exists, string(old), string(value), strconv.FormatInt(int64(ms), 10))
+	if err != nil {
+		return false, err
+	}
+	return reply == int64(1), nil
+}
+
+func (c *redisClient) Delete(key string) error {
+	_, err := c.do("DEL", key)
+	return err
+}
+
+// List scans the keys matching the prefix and reads them at once. Keys
+// which expired in between are skipped.
+func (c *redisClient) List(prefix string) ([][]byte, error) {
+	var (
+		keys   []string
+		cursor = "0"
+	)
+	for {
+		reply, err := c.do("SCAN", cursor, "MATCH", escapeGlob(prefix)+"*", "COUNT", strconv.Itoa(redisScanCount))
+		if err != nil {
+			return nil, err
+		}
+
+		page, ok := reply.([]interface{})
+		if !ok || len(page) != 2 {
+			return nil, fmt.Errorf("unexpected SCAN reply %v", reply)
+		}
+		for _, k := range page[1].([]interface{}) {
+			keys = append(keys, string(k.([]byte)))
+		}
+
+		cursor = string(page[0].([]byte))
+		if cursor == "0" {
+			break
+		}
+	}
+
+	values := make([][]byte, 0, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	reply, err := c.do("MGET", keys...)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range reply.([]interface{}) {
+		if v != nil {
+			values = append(values, v.([]byte))
+		}
+	}
+	return values, nil
+}
+
+// do sends the command and returns its reply, which is nil, a string for
+// status replies, an int64, a []byte or an []interface{} of those. The
+// connection is only reused if the reply was read completely.
+func (c *redisClient) do(cmd string, args ...string) (interface{}, error) {
+	conn, err := c.conn()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(cmd, args...)
+	var redisErr RedisError
+	if err != nil && !errors.As(err, &redisErr) {
+		conn.Close()
+		return nil, err
+	}
+
+	select {
+	case c.pool <- conn:
+	default:
+		conn.Close()
+	}
+	return reply, err
+}
+
+func (c *redisClient) conn() (*redisConn, error) {
+	select {
+	case conn := <-c.pool:
+		return conn, nil
+	default:
+	}
+
+	nc, err := net.DialTimeout("tcp", c.addr, redisTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: nc, r: bufio.NewReader(nc)}
+
+	if c.password != "" {
+		if _, err := conn.do("AUTH", c.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (conn *redisConn) do(cmd string, args ...string) (interface{}, error) {
+	conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "*%d\r\n$%d\r\n%s\r\n", len(args)+1, len(cmd), cmd)
+	for _, arg := range args {
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return conn.readReply()
+}
+
+func (conn *redisConn) readReply() (interface{}, error) {
+	line, err := conn.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("malformed reply %q", line)
+	}
+	line = line[:len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, RedisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(conn.r, b); err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		// An error reply within an array fails the whole command, the
+		// remaining elements are still read to keep the connection usable.
+		var firstErr error
+		values := make([]interface{}, n)
+		for i := range values {
+			values[i], err = conn.readReply()
+			var redisErr RedisError
+			if err != nil && !errors.As(err, &redisErr) {
+				return nil, err
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return values, firstErr
+	}
+	return nil, fmt.Errorf("malformed reply %q", line)
+}
+
+// escapeGlob escapes the characters which have a meaning in the patterns
+// of SCAN MATCH.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
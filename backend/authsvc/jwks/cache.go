@@ -0,0 +1,154 @@
+package jwks
+
+import (
+	"context"
+	"crypto"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	ErrKeyIDMissing = errors.New("JWT key ID was missing")
+	ErrUnknownKey   = errors.New("JWT key ID is unknown")
+)
+
+const (
+	// DefaultTTL is how long a fetched key set is used before it is
+	// fetched again.
+	DefaultTTL = 5 * time.Minute
+	// minRefresh limits how often a token signed by an unknown key causes
+	// the key set to be fetched, e.g. right after authsvc restarted.
+	minRefresh = 10 * time.Second
+)
+
+// Fetcher returns the key set to resolve the keys from. The JWKS method of
+// the authsvc endpoint set is typically used, the key set of an external
+// identity provider is fetched from its jwks_uri instead.
+type Fetcher func(ctx context.Context) (KeySet, error)
+
+// Cache resolves the verification keys of the tokens by their key ID from
+// the last fetched key set. The key set is fetched without holding the lock
+// of the cache, once for all the tokens waiting on it, so that the tokens
+// of the keys already known are verified meanwhile.
+type Cache struct {
+	fetch  Fetcher
+	method jwt.SigningMethod
+	ttl    time.Duration
+
+	mtx       sync.Mutex
+	keys      map[string]crypto.PublicKey
+	checkedAt time.Time
+	inflight  *refresh
+}
+
+// refresh is a fetch of the key set, done is closed once it completed.
+type refresh struct {
+	done chan struct{}
+	err  error
+}
+
+// NewCache returns a cache which only accepts keys for the given signing
+// method, as the method of the token must be fixed in advance.
+func NewCache(fetch Fetcher, method jwt.SigningMethod, ttl time.Duration) *Cache {
+	return &Cache{fetch: fetch, method: method, ttl: ttl}
+}
+
+// Method returns the signing method to be passed to kitjwt.NewParser along
+// with Keyfunc.
+func (c *Cache) Method() jwt.SigningMethod {
+	return c.method
+}
+
+// Keyfunc is a jwt.Keyfunc which returns the public key named by the "kid"
+// header of the token. A known key is returned right away, even if the key
+// set expired and is being fetched again. An unknown key is waited for, but
+// the key set is fetched at most every minRefresh for those.
+func (c *Cache) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	if kid == "" {
+		return nil, ErrKeyIDMissing
+	}
+
+	c.mtx.Lock()
+	key, ok := c.keys[kid]
+	since := time.Since(c.checkedAt)
+	var r *refresh
+	if since > c.ttl || (!ok && since > minRefresh) || (!ok && c.inflight != nil) {
+		r = c.refreshLocked()
+	}
+	c.mtx.Unlock()
+
+	if ok {
+		return key, nil
+	}
+	if r == nil {
+		return nil, ErrUnknownKey
+	}
+
+	<-r.done
+	c.mtx.Lock()
+	key, ok = c.keys[kid]
+	c.mtx.Unlock()
+	if !ok {
+		if r.err != nil {
+			return nil, r.err
+		}
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// refreshLocked starts fetching the key set, unless a fetch is in flight
+// already, and returns the fetch. c.mtx must be held.
+func (c *Cache) refreshLocked() *refresh {
+	if c.inflight != nil {
+		return c.inflight
+	}
+
+	r := &refresh{done: make(chan struct{})}
+	c.inflight = r
+	c.checkedAt = time.Now()
+
+	go func() {
+		keys, err := c.load()
+
+		// The previous keys are kept if the key set cannot be fetched.
+		c.mtx.Lock()
+		if err == nil {
+			c.keys = keys
+		}
+		c.inflight = nil
+		c.mtx.Unlock()
+
+		r.err = err
+		close(r.done)
+	}()
+	return r
+}
+
+// load fetches the key set and returns the keys usable with the signing
+// method by their ID.
+func (c *Cache) load() (map[string]crypto.PublicKey, error) {
+	set, err := c.fetch(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		// Identity providers may publish keys without the optional alg,
+		// the signing method then rejects keys of the wrong type.
+		if k.Kid == "" || (k.Use != "" && k.Use != "sig") || (k.Alg != "" && k.Alg != c.method.Alg()) {
+			continue
+		}
+		pub, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+	return keys, nil
+}
@@ -0,0 +1,118 @@
+package jwks_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+)
+
+func newKey(t *testing.T, kid string) jwks.Key {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := jwks.NewKey(kid, "EdDSA", pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func tokenOf(kid string) *jwt.Token {
+	return &jwt.Token{Header: map[string]interface{}{"kid": kid}}
+}
+
+// TestKnownKeyNotBlockedByFetch checks that the tokens of a known key are
+// verified while the key set is fetched for an unknown one, and that the
+// tokens waiting for it share a single fetch.
+func TestKnownKeyNotBlockedByFetch(t *testing.T) {
+	t.Parallel()
+
+	a, b := newKey(t, "a"), newKey(t, "b")
+	var (
+		fetches int32
+		release = make(chan struct{})
+	)
+	fetch := func(ctx context.Context) (jwks.KeySet, error) {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			return jwks.KeySet{Keys: []jwks.Key{a}}, nil
+		}
+		<-release
+		return jwks.KeySet{Keys: []jwks.Key{a, b}}, nil
+	}
+	// Every call finds the key set expired.
+	c := jwks.NewCache(fetch, jwt.GetSigningMethod("EdDSA"), time.Nanosecond)
+
+	if _, err := c.Keyfunc(tokenOf("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Keyfunc(tokenOf("b")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Keyfunc(tokenOf("a"))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(time.Second):
+		t.Error("known key waited for the fetch of the key set")
+	}
+
+	// The fetches started after the release are not counted.
+	for atomic.LoadInt32(&fetches) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	n := atomic.LoadInt32(&fetches)
+	close(release)
+	wg.Wait()
+	if n != 2 {
+		t.Errorf("want a single fetch in flight, got %d", n-1)
+	}
+}
+
+// TestUnknownKeysRefreshRarely checks that the tokens of unknown keys do not
+// fetch the key set every time.
+func TestUnknownKeysRefreshRarely(t *testing.T) {
+	t.Parallel()
+
+	a := newKey(t, "a")
+	var fetches int32
+	fetch := func(ctx context.Context) (jwks.KeySet, error) {
+		atomic.AddInt32(&fetches, 1)
+		return jwks.KeySet{Keys: []jwks.Key{a}}, nil
+	}
+	c := jwks.NewCache(fetch, jwt.GetSigningMethod("EdDSA"), time.Hour)
+
+	for i := 0; i < 10; i++ {
+		if _, err := c.Keyfunc(tokenOf("unknown")); err != jwks.ErrUnknownKey {
+			t.Errorf("want %v, got %v", jwks.ErrUnknownKey, err)
+		}
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("want the key set fetched once, got %d", n)
+	}
+}
@@ -0,0 +1,137 @@
+// Package jwks publishes and resolves the public keys which the access
+// tokens issued by authsvc are verified with, in the JSON Web Key Set
+// format of RFC 7517.
+package jwks
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+var (
+	ErrUnsupportedKey = errors.New("unsupported key type")
+	ErrKeyInvalid     = errors.New("JSON web key was invalid")
+)
+
+// Key is a public JSON Web Key. Only the members used by RSA and Ed25519
+// keys are supported.
+type Key struct {
+	Kty string `json:"kty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Kid string `json:"kid,omitempty"`
+
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type KeySet struct {
+	Keys []Key `json:"keys"`
+}
+
+// NewKey returns the JSON Web Key of an RSA or Ed25519 public key. The key
+// ID is the RFC 7638 thumbprint of the key when kid is empty.
+func NewKey(kid, alg string, pub crypto.PublicKey) (Key, error) {
+	var k Key
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		k = Key{
+			Kty: "RSA",
+			N:   encode(pub.N.Bytes()),
+			E:   encode(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		k = Key{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   encode(pub),
+		}
+	default:
+		return Key{}, ErrUnsupportedKey
+	}
+
+	k.Use = "sig"
+	k.Alg = alg
+	k.Kid = kid
+	if k.Kid == "" {
+		k.Kid = k.Thumbprint()
+	}
+	return k, nil
+}
+
+// Thumbprint returns the base64url encoded SHA-256 thumbprint of the key.
+func (k Key) Thumbprint() string {
+	// The members are marshalled in lexicographic order as required.
+	var members interface{}
+	switch k.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{k.E, k.Kty, k.N}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{k.Crv, k.Kty, k.X}
+	}
+
+	b, _ := json.Marshal(members)
+	sum := sha256.Sum256(b)
+	return encode(sum[:])
+}
+
+// PublicKey returns the key in the form expected by the signing methods.
+func (k Key) PublicKey() (crypto.PublicKey, error) {
+	switch {
+	case k.Kty == "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exp := new(big.Int).SetBytes(e)
+		if len(n) == 0 || !exp.IsInt64() || exp.Int64() < 2 || exp.Int64() > 1<<31-1 {
+			return nil, ErrKeyInvalid
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exp.Int64())}, nil
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, ErrKeyInvalid
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, ErrUnsupportedKey
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrKeyInvalid
+	}
+	return b, nil
+}
@@ -0,0 +1,249 @@
+// Package oauth holds the types of the OAuth 2.0 authorization code flow
+// with PKCE (RFC 6749, RFC 7636) which third-party clients use to obtain
+// scoped access tokens from authsvc.
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc/authz"
+)
+
+const (
+	GrantAuthorizationCode = "authorization_code"
+	GrantRefreshToken      = "refresh_token"
+
+	ResponseTypeCode = "code"
+
+	// The token type hints of RFC 7009, which are also used by RFC 7662.
+	TokenTypeAccessToken  = "access_token"
+	TokenTypeRefreshToken = "refresh_token"
+	// MethodS256 is the only supported code challenge method, "plain"
+	// would not protect the code if the authorization request leaked.
+	MethodS256 = "S256"
+)
+
+// SupportedScopes are the scopes which clients may be granted. They are
+// named after the permissions they allow, so that authz can enforce them.
+var SupportedScopes = Scopes{string(authz.ReadTasks), string(authz.WriteTasks)}
+
+// Client is a registered third-party application. Clients are public, i.e.
+// they have no secret, so PKCE binds the code to the client which requested
+// it.
+type Client struct {
+	ID           string    `json:"client_id"`
+	Name         string    `json:"client_name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Scopes       Scopes    `json:"scopes"`
+	OwnerID      uint64    `json:"owner_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Consent records the scopes a user granted to a client.
+type Consent struct {
+	ClientID   string    `json:"client_id"`
+	ClientName string    `json:"client_name"`
+	Scopes     Scopes    `json:"scopes"`
+	GrantedAt  time.Time `json:"granted_at"`
+}
+
+// AuthorizeRequest is sent on behalf of the signed-in user once the consent
+// was given.
+type AuthorizeRequest struct {
+	ResponseType        string `json:"response_type"`
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	Scope               string `json:"scope"`
+	State               string `json:"state"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// TokenRequest is sent by the client. The claims of the refresh token are
+// filled in from the parsed token for the refresh token grant.
+type TokenRequest struct {
+	GrantType    string
+	Code         string
+	RedirectURI  string
+	ClientID     string
+	CodeVerifier string
+
+	AccessUUID  string
+	RefreshUUID string
+	UserID      uint64
+}
+
+// Token is the successful response of the token endpoint.
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	Scope        string `json:"scope"`
+}
+
+// IntrospectionRequest asks about a token, either to introspect it (RFC
+// 7662) or to revoke it (RFC 7009). The claims are filled in from the token
+// when it could be verified, so they are empty for invalid tokens and for
+// personal access tokens, which are looked up by Token.
+type IntrospectionRequest struct {
+	Token         string
+	TokenTypeHint string
+	ClientID      string
+
+	AccessUUID  string
+	RefreshUUID string
+	UserID      uint64
+	ExpiresAt   int64
+}
+
+// Introspection is the response of the introspection endpoint. Only Active
+// is set for tokens which are invalid, expired or revoked.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Jti       string `json:"jti,omitempty"`
+}
+
+// Scopes is a set of scopes, written space separated in requests and
+// tokens.
+type Scopes []string
+
+func ParseScopes(s string) Scopes {
+	return Scopes(strings.Fields(s))
+}
+
+func (s Scopes) String() string {
+	return strings.Join(s, " ")
+}
+
+func (s Scopes) Has(scope string) bool {
+	for _, v := range s {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains reports whether every scope of other is in s.
+func (s Scopes) Contains(other Scopes) bool {
+	for _, v := range other {
+		if !s.Has(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Union returns the scopes of s followed by the ones only in other.
+func (s Scopes) Union(other Scopes) Scopes {
+	union := append(Scopes(nil), s...)
+	for _, v := range other {
+		if !union.Has(v) {
+			union = append(union, v)
+		}
+	}
+	return union
+}
+
+// Challenge returns the S256 challenge of the code verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// VerifyChallenge checks the code verifier against the S256 challenge.
+func VerifyChallenge(challenge, verifier string) bool {
+	want := Challenge(verifier)
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(want)) == 1
+}
+
+// ValidVerifier reports whether the code verifier has the length and the
+// characters required by RFC 7636.
+func ValidVerifier(verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	for _, r := range verifier {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		case r == '-', r == '.', r == '_', r == '~':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// ValidRedirectURI accepts absolute URIs without fragment. Plain HTTP is
+// only allowed for the loopback interface used by native apps, while mobile
+// apps may use a private-use scheme named after a domain they own in
+// reverse order as of RFC 8252 section 7.1, e.g. com.example.app. Any other
+// scheme, e.g. javascript or data, is rejected.
+func ValidRedirectURI(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !u.IsAbs() || u.Fragment != "" {
+		return false
+	}
+
+	switch u.Scheme {
+	case "https":
+		return u.Host != ""
+	case "http":
+		host := u.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	}
+	return privateUseScheme(u.Scheme)
+}
+
+// privateUseScheme reports whether the scheme is a reverse domain name of
+// at least two labels.
+func privateUseScheme(scheme string) bool {
+	labels := strings.Split(scheme, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if label == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Error codes of RFC 6749.
+const (
+	ErrorInvalidRequest       = "invalid_request"
+	ErrorInvalidClient        = "invalid_client"
+	ErrorInvalidGrant         = "invalid_grant"
+	ErrorInvalidScope         = "invalid_scope"
+	ErrorUnauthorizedClient   = "unauthorized_client"
+	ErrorUnsupportedGrantType = "unsupported_grant_type"
+	ErrorUnsupportedResponse  = "unsupported_response_type"
+)
+
+// Error is an error response of RFC 6749.
+type Error struct {
+	Code        string
+	Description string
+}
+
+func Errorf(code, format string, args ...interface{}) Error {
+	return Error{Code: code, Description: fmt.Sprintf(format, args...)}
+}
+
+func (e Error) Error() string {
+	return e.Code + ": " + e.Description
+}
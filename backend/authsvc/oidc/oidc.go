@@ -0,0 +1,338 @@
+// Package oidc implements the relying party of the OpenID Connect
+// authorization code flow, so that users can sign in through an external
+// identity provider such as a company SSO.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+)
+
+var (
+	ErrDiscoveryFailed  = errors.New("identity provider discovery failed")
+	ErrExchangeFailed   = errors.New("identity provider rejected the authorization code")
+	ErrInvalidIDToken   = errors.New("ID token was invalid")
+	ErrEmailNotVerified = errors.New("email was not verified by the identity provider")
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	// leeway tolerates the clock skew between authsvc and the provider.
+	leeway = time.Minute
+	// maxResponseSize bounds the responses read from the provider.
+	maxResponseSize = 1 << 20
+)
+
+// DefaultScopes request the claims which accounts are linked by.
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// Config describes a provider and the client registered with it.
+type Config struct {
+	// Name identifies the provider in the login and callback URLs.
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the page of the frontend which receives the code and
+	// passes it on to the callback endpoint.
+	RedirectURL string
+	Scopes      []string
+}
+
+// Metadata is the part of the provider configuration which is used.
+type Metadata struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+}
+
+// Identity is the account at the provider which signed in.
+type Identity struct {
+	Issuer  string
+	Subject string
+	Email   string
+	Name    string
+}
+
+// Providers are the configured providers by name.
+type Providers map[string]*Provider
+
+// Provider is an identity provider whose metadata is discovered on first
+// use, so that authsvc starts while the provider cannot be reached.
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mtx      sync.Mutex
+	metadata *Metadata
+	keys     *jwks.Cache
+}
+
+func NewProvider(config Config, client *http.Client) *Provider {
+	if len(config.Scopes) == 0 {
+		config.Scopes = DefaultScopes
+	}
+	return &Provider{config: config, client: client}
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// AuthCodeURL returns the URL the user is sent to in order to sign in. The
+// state and the nonce have to be checked on the callback, the verifier is
+// needed for the exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", ErrDiscoveryFailed
+	}
+
+	q := u.Query()
+	q.Set("response_type", oauth.ResponseTypeCode)
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", p.config.RedirectURL)
+	q.Set("scope", strings.Join(p.config.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", oauth.Challenge(verifier))
+	q.Set("code_challenge_method", oauth.MethodS256)
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// Exchange redeems the code for an ID token and returns the identity it
+// asserts. Only identities with a verified email are returned, as accounts
+// are linked by their email.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Identity, error) {
+	m, keys, err := p.discover(ctx)
+	if err != nil {
+		return Identity{}, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", oauth.GrantAuthorizationCode)
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+	if p.config.ClientSecret == "" {
+		form.Set("client_id", p.config.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Identity{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		// RFC 6749 requires the credentials to be form encoded first.
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.do(req, &token); err != nil {
+		return Identity{}, ErrExchangeFailed
+	}
+	if token.IDToken == "" {
+		return Identity{}, ErrExchangeFailed
+	}
+
+	return p.verify(token.IDToken, m, keys, nonce)
+}
+
+// verify checks the ID token as required by OpenID Connect Core 3.1.3.7.
+func (p *Provider) verify(raw string, m *Metadata, keys *jwks.Cache, nonce string) (Identity, error) {
+	parser := jwt.Parser{
+		ValidMethods: []string{keys.Method().Alg()},
+		// The time claims are checked below with leeway.
+		SkipClaimsValidation: true,
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := parser.ParseWithClaims(raw, claims, keys.Keyfunc); err != nil {
+		return Identity{}, ErrInvalidIDToken
+	}
+
+	if iss, _ := claims["iss"].(string); iss != m.Issuer {
+		return Identity{}, ErrInvalidIDToken
+	}
+	aud := audience(claims["aud"])
+	if !contains(aud, p.config.ClientID) {
+		return Identity{}, ErrInvalidIDToken
+	}
+	if azp, ok := claims["azp"].(string); (ok || len(aud) > 1) && azp != p.config.ClientID {
+		return Identity{}, ErrInvalidIDToken
+	}
+	if n, _ := claims["nonce"].(string); n == "" || n != nonce {
+		return Identity{}, ErrInvalidIDToken
+	}
+
+	now := time.Now()
+	exp, ok := unixTime(claims["exp"])
+	if !ok || now.After(exp.Add(leeway)) {
+		return Identity{}, ErrInvalidIDToken
+	}
+	if iat, ok := unixTime(claims["iat"]); ok && iat.After(now.Add(leeway)) {
+		return Identity{}, ErrInvalidIDToken
+	}
+	if nbf, ok := unixTime(claims["nbf"]); ok && nbf.After(now.Add(leeway)) {
+		return Identity{}, ErrInvalidIDToken
+	}
+
+	identity := Identity{Issuer: m.Issuer}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	if identity.Subject == "" {
+		return Identity{}, ErrInvalidIDToken
+	}
+	if identity.Email == "" || !verified(claims["email_verified"]) {
+		return Identity{}, ErrEmailNotVerified
+	}
+
+	return identity, nil
+}
+
+// discover fetches the metadata once it succeeded, a failed discovery is
+// retried by the next login.
+func (p *Provider) discover(ctx context.Context) (*Metadata, *jwks.Cache, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, p.keys, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(p.config.Issuer, "/")+discoveryPath, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var m Metadata
+	if err := p.do(req, &m); err != nil {
+		return nil, nil, ErrDiscoveryFailed
+	}
+	// The issuer must match exactly, so that a provider cannot issue
+	// tokens on behalf of another one.
+	if m.Issuer != p.config.Issuer || m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, nil, ErrDiscoveryFailed
+	}
+
+	method, err := signingMethod(m.SigningAlgs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p.metadata = &m
+	p.keys = jwks.NewCache(p.fetchKeys(m.JWKSURI), method, jwks.DefaultTTL)
+	return p.metadata, p.keys, nil
+}
+
+func (p *Provider) fetchKeys(uri string) jwks.Fetcher {
+	return func(ctx context.Context) (jwks.KeySet, error) {
+		var set jwks.KeySet
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+		if err != nil {
+			return set, err
+		}
+
+		err = p.do(req, &set)
+		return set, err
+	}
+}
+
+func (p *Provider) do(req *http.Request, v interface{}) error {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v)
+}
+
+// signingMethod picks RS256, which every provider has to support, unless
+// the provider only signs with Ed25519 keys.
+func signingMethod(algs []string) (jwt.SigningMethod, error) {
+	if len(algs) == 0 || contains(algs, jwt.SigningMethodRS256.Alg()) {
+		return jwt.SigningMethodRS256, nil
+	}
+	if m := jwt.GetSigningMethod("EdDSA"); m != nil && contains(algs, m.Alg()) {
+		return m, nil
+	}
+	return nil, ErrDiscoveryFailed
+}
+
+// audience returns the aud claim, which is either a string or an array.
+func audience(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		aud := make([]string, 0, len(v))
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+		return aud
+	}
+	return nil
+}
+
+func unixTime(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case json.Number:
+		n, err := v.Int64()
+		return time.Unix(n, 0), err == nil
+	}
+	return time.Time{}, false
+}
+
+// verified accepts the string "true" as well, which some providers send.
+func verified(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	}
+	return false
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,252 @@
+// Package oidctest implements a minimal OpenID Connect provider, which
+// approves every authorization request right away for the configured user.
+// It backs the stubidp command and the tests of the relying party, and must
+// not be used in production.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+)
+
+const (
+	codeTTL    = time.Minute
+	idTokenTTL = 5 * time.Minute
+)
+
+// Config describes the provider, the client registered with it and the
+// user which signs in.
+type Config struct {
+	// Issuer must be the URL the provider is reached at.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Email        string
+	// Subject is derived from the email if empty.
+	Subject       string
+	EmailVerified bool
+}
+
+// Provider serves the discovery, authorization, token and key set
+// endpoints.
+type Provider struct {
+	config  Config
+	key     *rsa.PrivateKey
+	jwk     jwks.Key
+	handler http.Handler
+
+	mtx   sync.Mutex
+	codes map[string]authCode
+}
+
+type authCode struct {
+	RedirectURI string
+	Nonce       string
+	Challenge   string
+	ExpiresAt   time.Time
+}
+
+// NewProvider generates the signing key of the provider, so the key set
+// changes on every start.
+func NewProvider(c Config) (*Provider, error) {
+	if c.Subject == "" {
+		c.Subject = base64.RawURLEncoding.EncodeToString([]byte(c.Email))
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	jwk, err := jwks.NewKey("", stdjwt.SigningMethodRS256.Alg(), &key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		config: c,
+		key:    key,
+		jwk:    jwk,
+		codes:  make(map[string]authCode),
+	}
+
+	r := mux.NewRouter()
+	r.Methods("GET").Path("/.well-known/openid-configuration").HandlerFunc(p.discovery)
+	r.Methods("GET").Path("/authorize").HandlerFunc(p.authorize)
+	r.Methods("POST").Path("/token").HandlerFunc(p.token)
+	r.Methods("GET").Path("/jwks").HandlerFunc(p.keys)
+	p.handler = r
+
+	return p, nil
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.handler.ServeHTTP(w, r)
+}
+
+func (p *Provider) discovery(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                p.config.Issuer,
+		"authorization_endpoint":                p.config.Issuer + "/authorize",
+		"token_endpoint":                        p.config.Issuer + "/token",
+		"jwks_uri":                              p.config.Issuer + "/jwks",
+		"response_types_supported":              []string{oauth.ResponseTypeCode},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{stdjwt.SigningMethodRS256.Alg()},
+		"code_challenge_methods_supported":      []string{oauth.MethodS256},
+	})
+}
+
+// authorize approves the request without asking the user and redirects
+// back with the code.
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != p.config.ClientID {
+		http.Error(w, "unknown client_id", http.StatusBadRequest)
+		return
+	}
+	if q.Get("response_type") != oauth.ResponseTypeCode {
+		http.Error(w, "unsupported response_type", http.StatusBadRequest)
+		return
+	}
+	redirectURI, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || !redirectURI.IsAbs() {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	if q.Get("code_challenge") != "" && q.Get("code_challenge_method") != oauth.MethodS256 {
+		http.Error(w, "unsupported code_challenge_method", http.StatusBadRequest)
+		return
+	}
+
+	code, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	p.mtx.Lock()
+	p.codes[code] = authCode{
+		RedirectURI: q.Get("redirect_uri"),
+		Nonce:       q.Get("nonce"),
+		Challenge:   q.Get("code_challenge"),
+		ExpiresAt:   time.Now().Add(codeTTL),
+	}
+	p.mtx.Unlock()
+
+	v := redirectURI.Query()
+	v.Set("code", code)
+	v.Set("state", q.Get("state"))
+	redirectURI.RawQuery = v.Encode()
+	http.Redirect(w, r, redirectURI.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		tokenError(w, oauth.ErrorInvalidRequest)
+		return
+	}
+
+	id, secret, ok := r.BasicAuth()
+	if ok {
+		id, _ = url.QueryUnescape(id)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		id = r.PostForm.Get("client_id")
+	}
+	if id != p.config.ClientID || secret != p.config.ClientSecret {
+		tokenError(w, oauth.ErrorInvalidClient)
+		return
+	}
+	if r.PostForm.Get("grant_type") != oauth.GrantAuthorizationCode {
+		tokenError(w, oauth.ErrorUnsupportedGrantType)
+		return
+	}
+
+	p.mtx.Lock()
+	c, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	p.mtx.Unlock()
+
+	if !ok || time.Now().After(c.ExpiresAt) || c.RedirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError(w, oauth.ErrorInvalidGrant)
+		return
+	}
+	if c.Challenge != "" && !oauth.VerifyChallenge(c.Challenge, r.PostForm.Get("code_verifier")) {
+		tokenError(w, oauth.ErrorInvalidGrant)
+		return
+	}
+
+	now := time.Now()
+	claims := stdjwt.MapClaims{
+		"iss":            p.config.Issuer,
+		"sub":            p.config.Subject,
+		"aud":            p.config.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(idTokenTTL).Unix(),
+		"email":          p.config.Email,
+		"email_verified": p.config.EmailVerified,
+	}
+	if c.Nonce != "" {
+		claims["nonce"] = c.Nonce
+	}
+
+	token := stdjwt.NewWithClaims(stdjwt.SigningMethodRS256, claims)
+	token.Header["kid"] = p.jwk.Kid
+	idToken, err := token.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The access token is not accepted anywhere, authsvc only needs the ID
+	// token.
+	accessToken, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": accessToken,
+		"token_type":   "Bearer",
+		"expires_in":   int64(idTokenTTL.Seconds()),
+		"id_token":     idToken,
+	})
+}
+
+func (p *Provider) keys(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, jwks.KeySet{Keys: []jwks.Key{p.jwk}})
+}
+
+func tokenError(w http.ResponseWriter, code string) {
+	status := http.StatusBadRequest
+	if code == oauth.ErrorInvalidClient {
+		status = http.StatusUnauthorized
+	}
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,118 @@
+// Package pat holds personal access tokens, the long-lived credentials of
+// scripts and cron jobs. A token is resolved by authsvc on every request
+// into the claims of a scoped access token, so that authz authorizes both
+// alike.
+package pat
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+)
+
+// Prefix tells personal access tokens apart from JWTs in the Authorization
+// header, and makes leaked tokens easy to find by secret scanners.
+const Prefix = "gtd_pat_"
+
+// Claim marks the claims of a resolved token, which was already checked
+// against revocation and expiry by authsvc.
+const Claim = "pat"
+
+// Token is a personal access token. Only its hash is stored, so the secret
+// cannot be shown again after the token was created.
+type Token struct {
+	ID         string       `json:"id"`
+	UserID     uint64       `json:"user_id"`
+	Name       string       `json:"name"`
+	Scopes     oauth.Scopes `json:"scopes"`
+	CreatedAt  time.Time    `json:"created_at"`
+	ExpiresAt  *time.Time   `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time   `json:"last_used_at,omitempty"`
+	// Roles are read from usersvc whenever the token is resolved, so that
+	// role changes apply right away. They are not stored.
+	Roles []string `json:"roles,omitempty"`
+	// Secret is only set in the response to the creation.
+	Secret string `json:"token,omitempty"`
+}
+
+// Expired reports whether the token expired at t. Tokens without an expiry
+// are valid until they are revoked.
+func (t Token) Expired(at time.Time) bool {
+	return t.ExpiresAt != nil && !at.Before(*t.ExpiresAt)
+}
+
+// Generate returns a new secret.
+func Generate() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return Prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Hash returns the key the token is stored under. The secret is random, so
+// a fast hash suffices.
+func Hash(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// IsToken reports whether the bearer token is a personal access token.
+func IsToken(token string) bool {
+	return strings.HasPrefix(token, Prefix)
+}
+
+// Claims returns the claims the token stands in for. The scope claim is
+// always set, so that authz treats the token like the one of a third-party
+// client and keeps it away from the account management.
+func Claims(t Token) stdjwt.MapClaims {
+	roles := make([]interface{}, len(t.Roles))
+	for i, role := range t.Roles {
+		roles[i] = role
+	}
+
+	return stdjwt.MapClaims{
+		"uuid":    t.ID,
+		"user_id": float64(t.UserID),
+		"roles":   roles,
+		"scope":   t.Scopes.String(),
+		Claim:     true,
+	}
+}
+
+// Resolver returns the token of the secret, typically by calling authsvc.
+type Resolver func(ctx context.Context, secret string) (Token, error)
+
+// NewParser returns a middleware which resolves personal access tokens and
+// hands every other token to parser, usually kitjwt.NewParser. Either way
+// the claims are put in the context like kitjwt does, so it is a drop-in
+// replacement for the JWT parser.
+func NewParser(resolve Resolver, parser endpoint.Middleware) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		parsed := parser(next)
+
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			secret, ok := ctx.Value(kitjwt.JWTContextKey).(string)
+			if !ok || !IsToken(secret) {
+				return parsed(ctx, request)
+			}
+
+			t, err := resolve(ctx, secret)
+			if err != nil {
+				return nil, err
+			}
+
+			ctx = context.WithValue(ctx, kitjwt.JWTClaimsContextKey, Claims(t))
+			return next(ctx, request)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package pat_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
+)
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	a, err := pat.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := pat.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if a == b {
+		t.Error("want every secret unique")
+	}
+	if !pat.IsToken(a) || len(a) != len(pat.Prefix)+43 {
+		t.Errorf("want 32 random bytes after the prefix, got %q", a)
+	}
+	if pat.IsToken("eyJhbGciOiJFZERTQSJ9.e30.") {
+		t.Error("want a JWT not taken for a personal access token")
+	}
+}
+
+// TestHash checks that the key of a token is derived from the whole secret,
+// and does not reveal it.
+func TestHash(t *testing.T) {
+	t.Parallel()
+
+	secret := pat.Prefix + strings.Repeat("a", 43)
+	h := pat.Hash(secret)
+
+	if h != pat.Hash(secret) {
+		t.Error("want the hash of a secret stable")
+	}
+	if len(h) != 64 || strings.Contains(h, "aaaa") {
+		t.Errorf("want the hex encoded SHA-256 of the secret, got %q", h)
+	}
+	if h == pat.Hash(secret[:len(secret)-1]+"b") {
+		t.Error("want secrets differing in the last character hashed apart")
+	}
+}
+
+func TestExpired(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+
+	for _, tc := range []struct {
+		at   time.Time
+		want bool
+	}{
+		{now, false},
+		{expiresAt.Add(-time.Nanosecond), false},
+		{expiresAt, true},
+		{expiresAt.Add(time.Hour), true},
+	} {
+		if got := (pat.Token{ExpiresAt: &expiresAt}).Expired(tc.at); got != tc.want {
+			t.Errorf("at %v: want %v, got %v", tc.at.Sub(now), tc.want, got)
+		}
+	}
+
+	if (pat.Token{}).Expired(now.Add(100 * 365 * 24 * time.Hour)) {
+		t.Error("want a token without expiry never expired")
+	}
+}
+
+// TestNewParser checks that personal access tokens are resolved into scoped
+// claims, and that every other token is handed to the JWT parser.
+func TestNewParser(t *testing.T) {
+	t.Parallel()
+
+	errJWT := errors.New("parsed as JWT")
+	jwtParser := func(endpoint.Endpoint) endpoint.Endpoint {
+		return func(context.Context, interface{}) (interface{}, error) {
+			return nil, errJWT
+		}
+	}
+
+	errUnknown := errors.New("unknown token")
+	secret, err := pat.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolve := func(_ context.Context, s string) (pat.Token, error) {
+		if s != secret {
+			return pat.Token{}, errUnknown
+		}
+		return pat.Token{ID: "id", UserID: 7, Roles: []string{"user"}, Scopes: []string{"tasks:read"}}, nil
+	}
+
+	claimsOf := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return ctx.Value(kitjwt.JWTClaimsContextKey), nil
+	}
+	e := pat.NewParser(resolve, jwtParser)(claimsOf)
+
+	got, err := e(context.WithValue(context.Background(), kitjwt.JWTContextKey, secret), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := got.(stdjwt.MapClaims)
+	if claims["uuid"] != "id" || claims["user_id"] != float64(7) || claims["scope"] != "tasks:read" || claims[pat.Claim] != true {
+		t.Errorf("want the claims of the token, got %v", claims)
+	}
+
+	for token, want := range map[string]error{
+		pat.Prefix + "unknown": errUnknown,
+		"eyJhbGciOiJFZERTQSJ9":  errJWT,
+	} {
+		if _, err := e(context.WithValue(context.Background(), kitjwt.JWTContextKey, token), nil); err != want {
+			t.Errorf("%s: want %v, got %v", token, want, err)
+		}
+	}
+	if _, err := e(context.Background(), nil); err != errJWT {
+		t.Errorf("no token: want %v, got %v", errJWT, err)
+	}
+}
@@ -4,13 +4,19 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"time"
 
 	stdjwt "github.com/dgrijalva/jwt-go"
 	kitjwt "github.com/go-kit/kit/auth/jwt"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/authz"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
 	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authservice"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
 )
 
 type Set struct {
@@ -18,6 +24,40 @@ type Set struct {
 	LogoutEndpoint   endpoint.Endpoint
 	RefreshEndpoint  endpoint.Endpoint
 	ValidateEndpoint endpoint.Endpoint
+	SessionsEndpoint endpoint.Endpoint
+	UnlockEndpoint   endpoint.Endpoint
+
+	RevokeSessionEndpoint      endpoint.Endpoint
+	RevokeAllSessionsEndpoint  endpoint.Endpoint
+	RevokeUserSessionsEndpoint endpoint.Endpoint
+
+	VerifyMFAEndpoint   endpoint.Endpoint
+	EnrollTOTPEndpoint  endpoint.Endpoint
+	ConfirmTOTPEndpoint endpoint.Endpoint
+	DisableTOTPEndpoint endpoint.Endpoint
+
+	ChangePasswordEndpoint endpoint.Endpoint
+
+	JWKSEndpoint endpoint.Endpoint
+
+	RegisterClientEndpoint endpoint.Endpoint
+	ClientEndpoint         endpoint.Endpoint
+	AuthorizeEndpoint      endpoint.Endpoint
+	TokenEndpoint          endpoint.Endpoint
+	ConsentsEndpoint       endpoint.Endpoint
+	RevokeConsentEndpoint  endpoint.Endpoint
+	IntrospectEndpoint     endpoint.Endpoint
+	RevokeTokenEndpoint    endpoint.Endpoint
+
+	OIDCLoginEndpoint    endpoint.Endpoint
+	OIDCCallbackEndpoint endpoint.Endpoint
+
+	CreatePATEndpoint  endpoint.Endpoint
+	PATsEndpoint       endpoint.Endpoint
+	RevokePATEndpoint  endpoint.Endpoint
+	ResolvePATEndpoint endpoint.Endpoint
+
+	RevocationsEndpoint endpoint.Endpoint
 }
 
 func New(svc authservice.Service, logger log.Logger) Set {
@@ -45,11 +85,215 @@ func New(svc authservice.Service, logger log.Logger) Set {
 		validateEndpoint = LoggingMiddleware(log.With(logger, "method", "Validate"))(validateEndpoint)
 	}
 
+	var sessionsEndpoint endpoint.Endpoint
+	{
+		sessionsEndpoint = MakeSessionsEndpoint(svc)
+		sessionsEndpoint = authz.FirstParty()(sessionsEndpoint)
+		sessionsEndpoint = LoggingMiddleware(log.With(logger, "method", "Sessions"))(sessionsEndpoint)
+	}
+
+	var revokeSessionEndpoint endpoint.Endpoint
+	{
+		revokeSessionEndpoint = MakeRevokeSessionEndpoint(svc)
+		revokeSessionEndpoint = authz.FirstParty()(revokeSessionEndpoint)
+		revokeSessionEndpoint = LoggingMiddleware(log.With(logger, "method", "RevokeSession"))(revokeSessionEndpoint)
+	}
+
+	var revokeAllSessionsEndpoint endpoint.Endpoint
+	{
+		revokeAllSessionsEndpoint = MakeRevokeAllSessionsEndpoint(svc)
+		revokeAllSessionsEndpoint = authz.FirstParty()(revokeAllSessionsEndpoint)
+		revokeAllSessionsEndpoint = LoggingMiddleware(log.With(logger, "method", "RevokeAllSessions"))(revokeAllSessionsEndpoint)
+	}
+
+	var revokeUserSessionsEndpoint endpoint.Endpoint
+	{
+		revokeUserSessionsEndpoint = MakeRevokeUserSessionsEndpoint(svc)
+		revokeUserSessionsEndpoint = authz.RequireRole(usersvc.RoleAdmin)(revokeUserSessionsEndpoint)
+		revokeUserSessionsEndpoint = LoggingMiddleware(log.With(logger, "method", "RevokeUserSessions"))(revokeUserSessionsEndpoint)
+	}
+
+	var unlockEndpoint endpoint.Endpoint
+	{
+		unlockEndpoint = MakeUnlockEndpoint(svc)
+		unlockEndpoint = authz.RequireRole(usersvc.RoleAdmin)(unlockEndpoint)
+		unlockEndpoint = LoggingMiddleware(log.With(logger, "method", "Unlock"))(unlockEndpoint)
+	}
+
+	var verifyMFAEndpoint endpoint.Endpoint
+	{
+		verifyMFAEndpoint = MakeVerifyMFAEndpoint(svc)
+		verifyMFAEndpoint = LoggingMiddleware(log.With(logger, "method", "VerifyMFA"))(verifyMFAEndpoint)
+	}
+
+	var enrollTOTPEndpoint endpoint.Endpoint
+	{
+		enrollTOTPEndpoint = MakeEnrollTOTPEndpoint(svc)
+		enrollTOTPEndpoint = authz.FirstParty()(enrollTOTPEndpoint)
+		enrollTOTPEndpoint = LoggingMiddleware(log.With(logger, "method", "EnrollTOTP"))(enrollTOTPEndpoint)
+	}
+
+	var confirmTOTPEndpoint endpoint.Endpoint
+	{
+		confirmTOTPEndpoint = MakeConfirmTOTPEndpoint(svc)
+		confirmTOTPEndpoint = authz.FirstParty()(confirmTOTPEndpoint)
+		confirmTOTPEndpoint = LoggingMiddleware(log.With(logger, "method", "ConfirmTOTP"))(confirmTOTPEndpoint)
+	}
+
+	var disableTOTPEndpoint endpoint.Endpoint
+	{
+		disableTOTPEndpoint = MakeDisableTOTPEndpoint(svc)
+		disableTOTPEndpoint = authz.FirstParty()(disableTOTPEndpoint)
+		disableTOTPEndpoint = LoggingMiddleware(log.With(logger, "method", "DisableTOTP"))(disableTOTPEndpoint)
+	}
+
+	var changePasswordEndpoint endpoint.Endpoint
+	{
+		changePasswordEndpoint = MakeChangePasswordEndpoint(svc)
+		changePasswordEndpoint = LoggingMiddleware(log.With(logger, "method", "ChangePassword"))(changePasswordEndpoint)
+	}
+
+	var jwksEndpoint endpoint.Endpoint
+	{
+		jwksEndpoint = MakeJWKSEndpoint(svc)
+		jwksEndpoint = LoggingMiddleware(log.With(logger, "method", "JWKS"))(jwksEndpoint)
+	}
+
+	var registerClientEndpoint endpoint.Endpoint
+	{
+		registerClientEndpoint = MakeRegisterClientEndpoint(svc)
+		registerClientEndpoint = authz.FirstParty()(registerClientEndpoint)
+		registerClientEndpoint = LoggingMiddleware(log.With(logger, "method", "RegisterClient"))(registerClientEndpoint)
+	}
+
+	var clientEndpoint endpoint.Endpoint
+	{
+		clientEndpoint = MakeClientEndpoint(svc)
+		clientEndpoint = LoggingMiddleware(log.With(logger, "method", "Client"))(clientEndpoint)
+	}
+
+	var authorizeEndpoint endpoint.Endpoint
+	{
+		authorizeEndpoint = MakeAuthorizeEndpoint(svc)
+		authorizeEndpoint = authz.FirstParty()(authorizeEndpoint)
+		authorizeEndpoint = LoggingMiddleware(log.With(logger, "method", "Authorize"))(authorizeEndpoint)
+	}
+
+	var tokenEndpoint endpoint.Endpoint
+	{
+		tokenEndpoint = MakeTokenEndpoint(svc)
+		tokenEndpoint = LoggingMiddleware(log.With(logger, "method", "Token"))(tokenEndpoint)
+	}
+
+	var consentsEndpoint endpoint.Endpoint
+	{
+		consentsEndpoint = MakeConsentsEndpoint(svc)
+		consentsEndpoint = authz.FirstParty()(consentsEndpoint)
+		consentsEndpoint = LoggingMiddleware(log.With(logger, "method", "Consents"))(consentsEndpoint)
+	}
+
+	var revokeConsentEndpoint endpoint.Endpoint
+	{
+		revokeConsentEndpoint = MakeRevokeConsentEndpoint(svc)
+		revokeConsentEndpoint = authz.FirstParty()(revokeConsentEndpoint)
+		revokeConsentEndpoint = LoggingMiddleware(log.With(logger, "method", "RevokeConsent"))(revokeConsentEndpoint)
+	}
+
+	var introspectEndpoint endpoint.Endpoint
+	{
+		introspectEndpoint = MakeIntrospectEndpoint(svc)
+		introspectEndpoint = LoggingMiddleware(log.With(logger, "method", "Introspect"))(introspectEndpoint)
+	}
+
+	var revokeTokenEndpoint endpoint.Endpoint
+	{
+		revokeTokenEndpoint = MakeRevokeTokenEndpoint(svc)
+		revokeTokenEndpoint = LoggingMiddleware(log.With(logger, "method", "RevokeToken"))(revokeTokenEndpoint)
+	}
+
+	var oidcLoginEndpoint endpoint.Endpoint
+	{
+		oidcLoginEndpoint = MakeOIDCLoginEndpoint(svc)
+		oidcLoginEndpoint = LoggingMiddleware(log.With(logger, "method", "OIDCLogin"))(oidcLoginEndpoint)
+	}
+
+	var oidcCallbackEndpoint endpoint.Endpoint
+	{
+		oidcCallbackEndpoint = MakeOIDCCallbackEndpoint(svc)
+		oidcCallbackEndpoint = LoggingMiddleware(log.With(logger, "method", "OIDCCallback"))(oidcCallbackEndpoint)
+	}
+
+	var createPATEndpoint endpoint.Endpoint
+	{
+		createPATEndpoint = MakeCreatePATEndpoint(svc)
+		createPATEndpoint = authz.FirstParty()(createPATEndpoint)
+		createPATEndpoint = LoggingMiddleware(log.With(logger, "method", "CreatePAT"))(createPATEndpoint)
+	}
+
+	var patsEndpoint endpoint.Endpoint
+	{
+		patsEndpoint = MakePATsEndpoint(svc)
+		patsEndpoint = authz.FirstParty()(patsEndpoint)
+		patsEndpoint = LoggingMiddleware(log.With(logger, "method", "PATs"))(patsEndpoint)
+	}
+
+	var revokePATEndpoint endpoint.Endpoint
+	{
+		revokePATEndpoint = MakeRevokePATEndpoint(svc)
+		revokePATEndpoint = authz.FirstParty()(revokePATEndpoint)
+		revokePATEndpoint = LoggingMiddleware(log.With(logger, "method", "RevokePAT"))(revokePATEndpoint)
+	}
+
+	var resolvePATEndpoint endpoint.Endpoint
+	{
+		resolvePATEndpoint = MakeResolvePATEndpoint(svc)
+		resolvePATEndpoint = LoggingMiddleware(log.With(logger, "method", "ResolvePAT"))(resolvePATEndpoint)
+	}
+
+	var revocationsEndpoint endpoint.Endpoint
+	{
+		revocationsEndpoint = MakeRevocationsEndpoint(svc)
+		revocationsEndpoint = LoggingMiddleware(log.With(logger, "method", "Revocations"))(revocationsEndpoint)
+	}
+
 	return Set{
-		LoginEndpoint:    loginEndpoint,
-		LogoutEndpoint:   logoutEndpoint,
-		RefreshEndpoint:  refreshEndpoint,
-		ValidateEndpoint: validateEndpoint,
+		LoginEndpoint:       loginEndpoint,
+		LogoutEndpoint:      logoutEndpoint,
+		RefreshEndpoint:     refreshEndpoint,
+		ValidateEndpoint:    validateEndpoint,
+		SessionsEndpoint:    sessionsEndpoint,
+		UnlockEndpoint:      unlockEndpoint,
+		VerifyMFAEndpoint:   verifyMFAEndpoint,
+		EnrollTOTPEndpoint:  enrollTOTPEndpoint,
+		ConfirmTOTPEndpoint: confirmTOTPEndpoint,
+		DisableTOTPEndpoint: disableTOTPEndpoint,
+
+		RevokeSessionEndpoint:      revokeSessionEndpoint,
+		RevokeAllSessionsEndpoint:  revokeAllSessionsEndpoint,
+		RevokeUserSessionsEndpoint: revokeUserSessionsEndpoint,
+
+		ChangePasswordEndpoint: changePasswordEndpoint,
+
+		JWKSEndpoint: jwksEndpoint,
+
+		RegisterClientEndpoint: registerClientEndpoint,
+		ClientEndpoint:         clientEndpoint,
+		AuthorizeEndpoint:      authorizeEndpoint,
+		TokenEndpoint:          tokenEndpoint,
+		ConsentsEndpoint:       consentsEndpoint,
+		RevokeConsentEndpoint:  revokeConsentEndpoint,
+		IntrospectEndpoint:     introspectEndpoint,
+		RevokeTokenEndpoint:    revokeTokenEndpoint,
+
+		OIDCLoginEndpoint:    oidcLoginEndpoint,
+		OIDCCallbackEndpoint: oidcCallbackEndpoint,
+
+		CreatePATEndpoint:  createPATEndpoint,
+		PATsEndpoint:       patsEndpoint,
+		RevokePATEndpoint:  revokePATEndpoint,
+		ResolvePATEndpoint: resolvePATEndpoint,
+
+		RevocationsEndpoint: revocationsEndpoint,
 	}
 }
 
@@ -93,6 +337,280 @@ func (s Set) Validate(ctx context.Context, accessUUID string) (bool, error) {
 	return resp.V, resp.Err
 }
 
+func (s Set) Sessions(ctx context.Context, userID uint64) ([]authsvc.Session, error) {
+	response, err := s.SessionsEndpoint(ctx, SessionsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := response.(SessionsResponse)
+	return resp.Sessions, resp.Err
+}
+
+func (s Set) RevokeSession(ctx context.Context, accessUUID string, userID uint64, sessionID string) (bool, error) {
+	response, err := s.RevokeSessionEndpoint(ctx, RevokeSessionRequest{SessionID: sessionID})
+	if err != nil {
+		return false, err
+	}
+
+	resp := response.(RevokeSessionResponse)
+	return resp.Success, resp.Err
+}
+
+func (s Set) RevokeAllSessions(ctx context.Context, accessUUID string, userID uint64) (int, error) {
+	response, err := s.RevokeAllSessionsEndpoint(ctx, RevokeAllSessionsRequest{})
+	if err != nil {
+		return 0, err
+	}
+
+	resp := response.(RevokeAllSessionsResponse)
+	return resp.Revoked, resp.Err
+}
+
+func (s Set) RevokeUserSessions(ctx context.Context, accessUUID string, userID uint64) (int, error) {
+	response, err := s.RevokeUserSessionsEndpoint(ctx, RevokeUserSessionsRequest{UserID: userID})
+	if err != nil {
+		return 0, err
+	}
+
+	resp := response.(RevokeUserSessionsResponse)
+	return resp.Revoked, resp.Err
+}
+
+func (s Set) Unlock(ctx context.Context, accessUUID, username, ip string) (bool, error) {
+	response, err := s.UnlockEndpoint(ctx, UnlockRequest{Username: username, IP: ip})
+	if err != nil {
+		return false, err
+	}
+
+	resp := response.(UnlockResponse)
+	return resp.Success, resp.Err
+}
+
+func (s Set) VerifyMFA(ctx context.Context, mfaUUID string, userID uint64, code string) (map[string]string, error) {
+	response, err := s.VerifyMFAEndpoint(ctx, VerifyMFARequest{Code: code})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := response.(VerifyMFAResponse)
+	return resp.Tokens, resp.Err
+}
+
+func (s Set) EnrollTOTP(ctx context.Context, accessUUID string, userID uint64) (usersvc.TOTPKey, error) {
+	response, err := s.EnrollTOTPEndpoint(ctx, EnrollTOTPRequest{})
+	if err != nil {
+		return usersvc.TOTPKey{}, err
+	}
+
+	resp := response.(EnrollTOTPResponse)
+	return resp.Key, resp.Err
+}
+
+func (s Set) ConfirmTOTP(ctx context.Context, accessUUID string, userID uint64, code string) ([]string, error) {
+	response, err := s.ConfirmTOTPEndpoint(ctx, ConfirmTOTPRequest{Code: code})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := response.(ConfirmTOTPResponse)
+	return resp.RecoveryCodes, resp.Err
+}
+
+func (s Set) DisableTOTP(ctx context.Context, accessUUID string, userID uint64, code string) (bool, error) {
+	response, err := s.DisableTOTPEndpoint(ctx, DisableTOTPRequest{Code: code})
+	if err != nil {
+		return false, err
+	}
+
+	resp := response.(DisableTOTPResponse)
+	return resp.Success, resp.Err
+}
+
+func (s Set) ChangePassword(ctx context.Context, username, oldPassword, newPassword string) (bool, error) {
+	response, err := s.ChangePasswordEndpoint(ctx, ChangePasswordRequest{
+		Username:    username,
+		OldPassword: oldPassword,
+		NewPassword: newPassword,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	resp := response.(ChangePasswordResponse)
+	return resp.Success, resp.Err
+}
+
+func (s Set) JWKS(ctx context.Context) (jwks.KeySet, error) {
+	response, err := s.JWKSEndpoint(ctx, JWKSRequest{})
+	if err != nil {
+		return jwks.KeySet{}, err
+	}
+
+	resp := response.(JWKSResponse)
+	return resp.KeySet, resp.Err
+}
+
+func (s Set) RegisterClient(ctx context.Context, accessUUID string, userID uint64, c oauth.Client) (oauth.Client, error) {
+	response, err := s.RegisterClientEndpoint(ctx, RegisterClientRequest{
+		Name:         c.Name,
+		RedirectURIs: c.RedirectURIs,
+		Scopes:       c.Scopes,
+	})
+	if err != nil {
+		return oauth.Client{}, err
+	}
+
+	resp := response.(RegisterClientResponse)
+	return resp.Client, resp.Err
+}
+
+func (s Set) Client(ctx context.Context, clientID string) (oauth.Client, error) {
+	response, err := s.ClientEndpoint(ctx, ClientRequest{ClientID: clientID})
+	if err != nil {
+		return oauth.Client{}, err
+	}
+
+	resp := response.(ClientResponse)
+	return resp.Client, resp.Err
+}
+
+func (s Set) Authorize(ctx context.Context, accessUUID string, userID uint64, req oauth.AuthorizeRequest) (string, error) {
+	response, err := s.AuthorizeEndpoint(ctx, AuthorizeRequest{req})
+	if err != nil {
+		return "", err
+	}
+
+	resp := response.(AuthorizeResponse)
+	return resp.RedirectURI, resp.Err
+}
+
+func (s Set) Token(ctx context.Context, req oauth.TokenRequest) (oauth.Token, error) {
+	response, err := s.TokenEndpoint(ctx, TokenRequest{req})
+	if err != nil {
+		return oauth.Token{}, err
+	}
+
+	resp := response.(TokenResponse)
+	return resp.Token, resp.Err
+}
+
+func (s Set) Consents(ctx context.Context, userID uint64) ([]oauth.Consent, error) {
+	response, err := s.ConsentsEndpoint(ctx, ConsentsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := response.(ConsentsResponse)
+	return resp.Consents, resp.Err
+}
+
+func (s Set) RevokeConsent(ctx context.Context, accessUUID string, userID uint64, clientID string) (bool, error) {
+	response, err := s.RevokeConsentEndpoint(ctx, RevokeConsentRequest{ClientID: clientID})
+	if err != nil {
+		return false, err
+	}
+
+	resp := response.(RevokeConsentResponse)
+	return resp.Success, resp.Err
+}
+
+func (s Set) Introspect(ctx context.Context, req oauth.IntrospectionRequest) (oauth.Introspection, error) {
+	response, err := s.IntrospectEndpoint(ctx, IntrospectRequest{req})
+	if err != nil {
+		return oauth.Introspection{}, err
+	}
+
+	resp := response.(IntrospectResponse)
+	return resp.Introspection, resp.Err
+}
+
+func (s Set) RevokeToken(ctx context.Context, req oauth.IntrospectionRequest) error {
+	response, err := s.RevokeTokenEndpoint(ctx, RevokeTokenRequest{req})
+	if err != nil {
+		return err
+	}
+
+	resp := response.(RevokeTokenResponse)
+	return resp.Err
+}
+
+func (s Set) OIDCLogin(ctx context.Context, provider string) (string, string, error) {
+	response, err := s.OIDCLoginEndpoint(ctx, OIDCLoginRequest{Provider: provider})
+	if err != nil {
+		return "", "", err
+	}
+
+	resp := response.(OIDCLoginResponse)
+	return resp.URL, resp.Binding, resp.Err
+}
+
+func (s Set) OIDCCallback(ctx context.Context, provider, code, state, binding string) (map[string]string, error) {
+	response, err := s.OIDCCallbackEndpoint(ctx, OIDCCallbackRequest{Provider: provider, Code: code, State: state, Binding: binding})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := response.(OIDCCallbackResponse)
+	return resp.Tokens, resp.Err
+}
+
+func (s Set) CreatePAT(ctx context.Context, accessUUID string, userID uint64, name string, scopes []string, ttl time.Duration) (pat.Token, error) {
+	response, err := s.CreatePATEndpoint(ctx, CreatePATRequest{
+		Name:      name,
+		Scopes:    scopes,
+		ExpiresIn: int64(ttl / time.Second),
+	})
+	if err != nil {
+		return pat.Token{}, err
+	}
+
+	resp := response.(CreatePATResponse)
+	return resp.Token, resp.Err
+}
+
+func (s Set) PATs(ctx context.Context, userID uint64) ([]pat.Token, error) {
+	response, err := s.PATsEndpoint(ctx, PATsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := response.(PATsResponse)
+	return resp.Tokens, resp.Err
+}
+
+func (s Set) RevokePAT(ctx context.Context, accessUUID string, userID uint64, id string) (bool, error) {
+	response, err := s.RevokePATEndpoint(ctx, RevokePATRequest{ID: id})
+	if err != nil {
+		return false, err
+	}
+
+	resp := response.(RevokePATResponse)
+	return resp.Success, resp.Err
+}
+
+// ResolvePAT has the signature of pat.Resolver, so that the other services
+// can hand it to pat.NewParser.
+func (s Set) ResolvePAT(ctx context.Context, secret string) (pat.Token, error) {
+	response, err := s.ResolvePATEndpoint(ctx, ResolvePATRequest{Token: secret})
+	if err != nil {
+		return pat.Token{}, err
+	}
+
+	resp := response.(ResolvePATResponse)
+	return resp.Token, resp.Err
+}
+
+func (s Set) Revocations(ctx context.Context, since uint64, wait time.Duration) ([]authsvc.Revocation, uint64, error) {
+	response, err := s.RevocationsEndpoint(ctx, RevocationsRequest{Since: since, Wait: int64(wait / time.Millisecond)})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp := response.(RevocationsResponse)
+	return resp.Revocations, resp.Next, resp.Err
+}
+
 func MakeLoginEndpoint(s authservice.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(LoginRequest)
@@ -159,16 +677,432 @@ func MakeValidateEndpoint(s authservice.Service) endpoint.Endpoint {
 	}
 }
 
-var (
-	_ endpoint.Failer = LoginResponse{}
-	_ endpoint.Failer = LogoutResponse{}
-	_ endpoint.Failer = RefreshResponse{}
-	_ endpoint.Failer = ValidateResponse{}
-)
+func MakeSessionsEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		// The refresh and MFA tokens are signed with the same keys, so the
+		// claims of an access token are required.
+		accessUUID, userID, err := accessClaims(ctx)
+		if err != nil {
+			return SessionsResponse{Err: err}, nil
+		}
 
-type LoginRequest struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+		_ = request.(SessionsRequest)
+		sessions, err := s.Sessions(ctx, userID)
+		for i := range sessions {
+			sessions[i].Current = sessions[i].AccessUUID == accessUUID
+		}
+
+		return SessionsResponse{Sessions: sessions, Err: err}, nil
+	}
+}
+
+func MakeRevokeSessionEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		accessUUID, userID, err := accessClaims(ctx)
+		if err != nil {
+			return RevokeSessionResponse{Err: err}, nil
+		}
+
+		req := request.(RevokeSessionRequest)
+		success, err := s.RevokeSession(ctx, accessUUID, userID, req.SessionID)
+
+		return RevokeSessionResponse{Success: success, Err: err}, nil
+	}
+}
+
+func MakeRevokeAllSessionsEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		accessUUID, userID, err := accessClaims(ctx)
+		if err != nil {
+			return RevokeAllSessionsResponse{Err: err}, nil
+		}
+
+		_ = request.(RevokeAllSessionsRequest)
+		n, err := s.RevokeAllSessions(ctx, accessUUID, userID)
+
+		return RevokeAllSessionsResponse{Revoked: n, Err: err}, nil
+	}
+}
+
+func MakeRevokeUserSessionsEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		accessUUID, _, err := accessClaims(ctx)
+		if err != nil {
+			return RevokeUserSessionsResponse{Err: err}, nil
+		}
+
+		req := request.(RevokeUserSessionsRequest)
+		n, err := s.RevokeUserSessions(ctx, accessUUID, req.UserID)
+
+		return RevokeUserSessionsResponse{Revoked: n, Err: err}, nil
+	}
+}
+
+func MakeUnlockEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		accessUUID, _, err := accessClaims(ctx)
+		if err != nil {
+			return UnlockResponse{Err: err}, nil
+		}
+
+		req := request.(UnlockRequest)
+		success, err := s.Unlock(ctx, accessUUID, req.Username, req.IP)
+
+		return UnlockResponse{Success: success, Err: err}, nil
+	}
+}
+
+func MakeVerifyMFAEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		claims, ok := ctx.Value(kitjwt.JWTClaimsContextKey).(stdjwt.MapClaims)
+		if !ok {
+			return VerifyMFAResponse{Err: authsvc.ErrClaimsMissing}, nil
+		}
+
+		mfaUUID, ok := claims["mfa_uuid"].(string)
+		if !ok {
+			return VerifyMFAResponse{Err: authsvc.ErrClaimsInvalid}, nil
+		}
+
+		userID, err := strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+		if err != nil {
+			return VerifyMFAResponse{Err: authsvc.ErrClaimsInvalid}, nil
+		}
+
+		req := request.(VerifyMFARequest)
+		t, err := s.VerifyMFA(ctx, mfaUUID, userID, req.Code)
+
+		return VerifyMFAResponse{Tokens: t, Err: err}, nil
+	}
+}
+
+func MakeEnrollTOTPEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		accessUUID, userID, err := accessClaims(ctx)
+		if err != nil {
+			return EnrollTOTPResponse{Err: err}, nil
+		}
+
+		_ = request.(EnrollTOTPRequest)
+		k, err := s.EnrollTOTP(ctx, accessUUID, userID)
+
+		return EnrollTOTPResponse{Key: k, Err: err}, nil
+	}
+}
+
+func MakeConfirmTOTPEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		accessUUID, userID, err := accessClaims(ctx)
+		if err != nil {
+			return ConfirmTOTPResponse{Err: err}, nil
+		}
+
+		req := request.(ConfirmTOTPRequest)
+		codes, err := s.ConfirmTOTP(ctx, accessUUID, userID, req.Code)
+
+		return ConfirmTOTPResponse{RecoveryCodes: codes, Err: err}, nil
+	}
+}
+
+func MakeDisableTOTPEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		accessUUID, userID, err := accessClaims(ctx)
+		if err != nil {
+			return DisableTOTPResponse{Err: err}, nil
+		}
+
+		req := request.(DisableTOTPRequest)
+		success, err := s.DisableTOTP(ctx, accessUUID, userID, req.Code)
+
+		return DisableTOTPResponse{Success: success, Err: err}, nil
+	}
+}
+
+func MakeChangePasswordEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ChangePasswordRequest)
+		success, err := s.ChangePassword(ctx, req.Username, req.OldPassword, req.NewPassword)
+
+		return ChangePasswordResponse{Success: success, Err: err}, nil
+	}
+}
+
+func MakeJWKSEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		_ = request.(JWKSRequest)
+		set, err := s.JWKS(ctx)
+
+		return JWKSResponse{KeySet: set, Err: err}, nil
+	}
+}
+
+func MakeRegisterClientEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		accessUUID, userID, err := accessClaims(ctx)
+		if err != nil {
+			return RegisterClientResponse{Err: err}, nil
+		}
+
+		req := request.(RegisterClientRequest)
+		c, err := s.RegisterClient(ctx, accessUUID, userID, oauth.Client{
+			Name:         req.Name,
+			RedirectURIs: req.RedirectURIs,
+			Scopes:       req.Scopes,
+		})
+
+		return RegisterClientResponse{Client: c, Err: err}, nil
+	}
+}
+
+func MakeClientEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ClientRequest)
+		c, err := s.Client(ctx, req.ClientID)
+
+		return ClientResponse{Client: c, Err: err}, nil
+	}
+}
+
+func MakeAuthorizeEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		accessUUID, userID, err := accessClaims(ctx)
+		if err != nil {
+			return AuthorizeResponse{Err: err}, nil
+		}
+
+		req := request.(AuthorizeRequest)
+		uri, err := s.Authorize(ctx, accessUUID, userID, req.AuthorizeRequest)
+
+		return AuthorizeResponse{RedirectURI: uri, Err: err}, nil
+	}
+}
+
+// MakeTokenEndpoint takes the claims of the refresh token from the context
+// for the refresh token grant, where the transport puts the parsed token.
+func MakeTokenEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(TokenRequest)
+
+		if req.GrantType == oauth.GrantRefreshToken {
+			claims, _ := ctx.Value(kitjwt.JWTClaimsContextKey).(stdjwt.MapClaims)
+			req.AccessUUID, _ = claims["access_uuid"].(string)
+			req.RefreshUUID, _ = claims["refresh_uuid"].(string)
+			req.UserID, _ = strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+		}
+
+		t, err := s.Token(ctx, req.TokenRequest)
+
+		return TokenResponse{Token: t, Err: err}, nil
+	}
+}
+
+func MakeConsentsEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		_, userID, err := accessClaims(ctx)
+		if err != nil {
+			return ConsentsResponse{Err: err}, nil
+		}
+
+		_ = request.(ConsentsRequest)
+		consents, err := s.Consents(ctx, userID)
+
+		return ConsentsResponse{Consents: consents, Err: err}, nil
+	}
+}
+
+func MakeRevokeConsentEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		accessUUID, userID, err := accessClaims(ctx)
+		if err != nil {
+			return RevokeConsentResponse{Err: err}, nil
+		}
+
+		req := request.(RevokeConsentRequest)
+		success, err := s.RevokeConsent(ctx, accessUUID, userID, req.ClientID)
+
+		return RevokeConsentResponse{Success: success, Err: err}, nil
+	}
+}
+
+// MakeIntrospectEndpoint takes the claims of the token from the context,
+// where the transport puts the token if it could be verified.
+func MakeIntrospectEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(IntrospectRequest)
+		tokenClaims(ctx, &req.IntrospectionRequest)
+		i, err := s.Introspect(ctx, req.IntrospectionRequest)
+
+		return IntrospectResponse{Introspection: i, Err: err}, nil
+	}
+}
+
+// MakeRevokeTokenEndpoint takes the claims of the token from the context
+// like MakeIntrospectEndpoint.
+func MakeRevokeTokenEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RevokeTokenRequest)
+		tokenClaims(ctx, &req.IntrospectionRequest)
+		err := s.RevokeToken(ctx, req.IntrospectionRequest)
+
+		return RevokeTokenResponse{Err: err}, nil
+	}
+}
+
+func MakeOIDCLoginEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(OIDCLoginRequest)
+		u, b, err := s.OIDCLogin(ctx, req.Provider)
+
+		return OIDCLoginResponse{URL: u, Binding: b, Err: err}, nil
+	}
+}
+
+func MakeOIDCCallbackEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(OIDCCallbackRequest)
+		t, err := s.OIDCCallback(ctx, req.Provider, req.Code, req.State, req.Binding)
+
+		return OIDCCallbackResponse{Tokens: t, Err: err}, nil
+	}
+}
+
+func MakeCreatePATEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		accessUUID, userID, err := accessClaims(ctx)
+		if err != nil {
+			return CreatePATResponse{Err: err}, nil
+		}
+
+		req := request.(CreatePATRequest)
+		t, err := s.CreatePAT(ctx, accessUUID, userID, req.Name, req.Scopes, time.Duration(req.ExpiresIn)*time.Second)
+
+		return CreatePATResponse{Token: t, Err: err}, nil
+	}
+}
+
+func MakePATsEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		_, userID, err := accessClaims(ctx)
+		if err != nil {
+			return PATsResponse{Err: err}, nil
+		}
+
+		_ = request.(PATsRequest)
+		tokens, err := s.PATs(ctx, userID)
+
+		return PATsResponse{Tokens: tokens, Err: err}, nil
+	}
+}
+
+func MakeRevokePATEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		accessUUID, userID, err := accessClaims(ctx)
+		if err != nil {
+			return RevokePATResponse{Err: err}, nil
+		}
+
+		req := request.(RevokePATRequest)
+		success, err := s.RevokePAT(ctx, accessUUID, userID, req.ID)
+
+		return RevokePATResponse{Success: success, Err: err}, nil
+	}
+}
+
+func MakeResolvePATEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(ResolvePATRequest)
+		t, err := s.ResolvePAT(ctx, req.Token)
+
+		return ResolvePATResponse{Token: t, Err: err}, nil
+	}
+}
+
+func MakeRevocationsEndpoint(s authservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(RevocationsRequest)
+		revocations, next, err := s.Revocations(ctx, req.Since, time.Duration(req.Wait)*time.Millisecond)
+
+		return RevocationsResponse{Revocations: revocations, Next: next, Err: err}, nil
+	}
+}
+
+// tokenClaims fills in the claims of an access or a refresh token. Refresh
+// tokens are told apart by their "refresh_uuid" claim.
+func tokenClaims(ctx context.Context, req *oauth.IntrospectionRequest) {
+	claims, ok := ctx.Value(kitjwt.JWTClaimsContextKey).(stdjwt.MapClaims)
+	if !ok {
+		return
+	}
+
+	if refreshUUID, ok := claims["refresh_uuid"].(string); ok {
+		req.RefreshUUID = refreshUUID
+		req.AccessUUID, _ = claims["access_uuid"].(string)
+	} else {
+		req.AccessUUID, _ = claims["uuid"].(string)
+	}
+	req.UserID, _ = strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+	if exp, ok := claims["exp"].(float64); ok {
+		req.ExpiresAt = int64(exp)
+	}
+}
+
+// accessClaims returns the access UUID and the user ID of the access token
+// parsed by the transport.
+func accessClaims(ctx context.Context) (string, uint64, error) {
+	claims, ok := ctx.Value(kitjwt.JWTClaimsContextKey).(stdjwt.MapClaims)
+	if !ok {
+		return "", 0, authsvc.ErrClaimsMissing
+	}
+
+	accessUUID, ok := claims["uuid"].(string)
+	if !ok {
+		return "", 0, authsvc.ErrClaimsInvalid
+	}
+
+	userID, err := strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+	if err != nil {
+		return "", 0, authsvc.ErrClaimsInvalid
+	}
+
+	return accessUUID, userID, nil
+}
+
+var (
+	_ endpoint.Failer = LoginResponse{}
+	_ endpoint.Failer = LogoutResponse{}
+	_ endpoint.Failer = RefreshResponse{}
+	_ endpoint.Failer = ValidateResponse{}
+	_ endpoint.Failer = SessionsResponse{}
+	_ endpoint.Failer = RevokeSessionResponse{}
+	_ endpoint.Failer = RevokeAllSessionsResponse{}
+	_ endpoint.Failer = RevokeUserSessionsResponse{}
+	_ endpoint.Failer = UnlockResponse{}
+	_ endpoint.Failer = VerifyMFAResponse{}
+	_ endpoint.Failer = EnrollTOTPResponse{}
+	_ endpoint.Failer = ConfirmTOTPResponse{}
+	_ endpoint.Failer = DisableTOTPResponse{}
+	_ endpoint.Failer = ChangePasswordResponse{}
+	_ endpoint.Failer = JWKSResponse{}
+	_ endpoint.Failer = RegisterClientResponse{}
+	_ endpoint.Failer = ClientResponse{}
+	_ endpoint.Failer = AuthorizeResponse{}
+	_ endpoint.Failer = TokenResponse{}
+	_ endpoint.Failer = ConsentsResponse{}
+	_ endpoint.Failer = RevokeConsentResponse{}
+	_ endpoint.Failer = IntrospectResponse{}
+	_ endpoint.Failer = RevokeTokenResponse{}
+	_ endpoint.Failer = OIDCLoginResponse{}
+	_ endpoint.Failer = OIDCCallbackResponse{}
+	_ endpoint.Failer = CreatePATResponse{}
+	_ endpoint.Failer = PATsResponse{}
+	_ endpoint.Failer = RevokePATResponse{}
+	_ endpoint.Failer = ResolvePATResponse{}
+	_ endpoint.Failer = RevocationsResponse{}
+)
+
+type LoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
 }
 
 type LoginResponse struct {
@@ -206,3 +1140,299 @@ type ValidateResponse struct {
 }
 
 func (r ValidateResponse) Failed() error { return r.Err }
+
+type SessionsRequest struct{}
+
+type SessionsResponse struct {
+	Sessions []authsvc.Session `json:"sessions"`
+	Err      error             `json:"-"`
+}
+
+func (r SessionsResponse) Failed() error { return r.Err }
+
+type RevokeSessionRequest struct {
+	SessionID string `json:"session_id"`
+}
+
+type RevokeSessionResponse struct {
+	Success bool  `json:"success"`
+	Err     error `json:"-"`
+}
+
+func (r RevokeSessionResponse) Failed() error { return r.Err }
+
+type RevokeAllSessionsRequest struct{}
+
+type RevokeAllSessionsResponse struct {
+	Revoked int   `json:"revoked"`
+	Err     error `json:"-"`
+}
+
+func (r RevokeAllSessionsResponse) Failed() error { return r.Err }
+
+type RevokeUserSessionsRequest struct {
+	UserID uint64 `json:"user_id"`
+}
+
+type RevokeUserSessionsResponse struct {
+	Revoked int   `json:"revoked"`
+	Err     error `json:"-"`
+}
+
+func (r RevokeUserSessionsResponse) Failed() error { return r.Err }
+
+type UnlockRequest struct {
+	Username string `json:"username"`
+	IP       string `json:"ip"`
+}
+
+type UnlockResponse struct {
+	Success bool  `json:"success"`
+	Err     error `json:"-"`
+}
+
+func (r UnlockResponse) Failed() error { return r.Err }
+
+type VerifyMFARequest struct {
+	Code string `json:"code"`
+}
+
+type VerifyMFAResponse struct {
+	Tokens map[string]string `json:"tokens"`
+	Err    error             `json:"-"`
+}
+
+func (r VerifyMFAResponse) Failed() error { return r.Err }
+
+type EnrollTOTPRequest struct{}
+
+type EnrollTOTPResponse struct {
+	Key usersvc.TOTPKey `json:"key"`
+	Err error           `json:"-"`
+}
+
+func (r EnrollTOTPResponse) Failed() error { return r.Err }
+
+type ConfirmTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+type ConfirmTOTPResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	Err           error    `json:"-"`
+}
+
+func (r ConfirmTOTPResponse) Failed() error { return r.Err }
+
+type DisableTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+type DisableTOTPResponse struct {
+	Success bool  `json:"success"`
+	Err     error `json:"-"`
+}
+
+func (r DisableTOTPResponse) Failed() error { return r.Err }
+
+type ChangePasswordRequest struct {
+	Username    string `json:"username"`
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type ChangePasswordResponse struct {
+	Success bool  `json:"success"`
+	Err     error `json:"-"`
+}
+
+func (r ChangePasswordResponse) Failed() error { return r.Err }
+
+type JWKSRequest struct{}
+
+// JWKSResponse is encoded as the bare key set document.
+type JWKSResponse struct {
+	KeySet jwks.KeySet
+	Err    error
+}
+
+func (r JWKSResponse) Failed() error { return r.Err }
+
+type RegisterClientRequest struct {
+	Name         string       `json:"client_name"`
+	RedirectURIs []string     `json:"redirect_uris"`
+	Scopes       oauth.Scopes `json:"scopes"`
+}
+
+type RegisterClientResponse struct {
+	Client oauth.Client `json:"client"`
+	Err    error        `json:"-"`
+}
+
+func (r RegisterClientResponse) Failed() error { return r.Err }
+
+type ClientRequest struct {
+	ClientID string `json:"client_id"`
+}
+
+type ClientResponse struct {
+	Client oauth.Client `json:"client"`
+	Err    error        `json:"-"`
+}
+
+func (r ClientResponse) Failed() error { return r.Err }
+
+type AuthorizeRequest struct {
+	oauth.AuthorizeRequest
+}
+
+type AuthorizeResponse struct {
+	RedirectURI string `json:"redirect_uri"`
+	Err         error  `json:"-"`
+}
+
+func (r AuthorizeResponse) Failed() error { return r.Err }
+
+type TokenRequest struct {
+	oauth.TokenRequest
+}
+
+// TokenResponse is encoded as the bare token response of RFC 6749.
+type TokenResponse struct {
+	Token oauth.Token
+	Err   error
+}
+
+func (r TokenResponse) Failed() error { return r.Err }
+
+type ConsentsRequest struct{}
+
+type ConsentsResponse struct {
+	Consents []oauth.Consent `json:"consents"`
+	Err      error           `json:"-"`
+}
+
+func (r ConsentsResponse) Failed() error { return r.Err }
+
+type RevokeConsentRequest struct {
+	ClientID string `json:"client_id"`
+}
+
+type RevokeConsentResponse struct {
+	Success bool  `json:"success"`
+	Err     error `json:"-"`
+}
+
+func (r RevokeConsentResponse) Failed() error { return r.Err }
+
+type IntrospectRequest struct {
+	oauth.IntrospectionRequest
+}
+
+// IntrospectResponse is encoded as the bare introspection response of RFC
+// 7662.
+type IntrospectResponse struct {
+	Introspection oauth.Introspection
+	Err           error
+}
+
+func (r IntrospectResponse) Failed() error { return r.Err }
+
+type RevokeTokenRequest struct {
+	oauth.IntrospectionRequest
+}
+
+type RevokeTokenResponse struct {
+	Err error `json:"-"`
+}
+
+func (r RevokeTokenResponse) Failed() error { return r.Err }
+
+type OIDCLoginRequest struct {
+	Provider string `json:"provider"`
+}
+
+// OIDCLoginResponse leaves the binding out of the body, the HTTP transport
+// passes it in a cookie which scripts cannot read.
+type OIDCLoginResponse struct {
+	URL     string `json:"url"`
+	Binding string `json:"-"`
+	Err     error  `json:"-"`
+}
+
+func (r OIDCLoginResponse) Failed() error { return r.Err }
+
+type OIDCCallbackRequest struct {
+	Provider string `json:"provider"`
+	Code     string `json:"code"`
+	State    string `json:"state"`
+	Binding  string `json:"-"`
+}
+
+type OIDCCallbackResponse struct {
+	Tokens map[string]string `json:"tokens"`
+	Err    error             `json:"-"`
+}
+
+func (r OIDCCallbackResponse) Failed() error { return r.Err }
+
+// CreatePATRequest takes the lifetime in seconds, zero creates a token
+// which does not expire.
+type CreatePATRequest struct {
+	Name      string       `json:"name"`
+	Scopes    oauth.Scopes `json:"scopes"`
+	ExpiresIn int64        `json:"expires_in"`
+}
+
+type CreatePATResponse struct {
+	Token pat.Token `json:"token"`
+	Err   error     `json:"-"`
+}
+
+func (r CreatePATResponse) Failed() error { return r.Err }
+
+type PATsRequest struct{}
+
+type PATsResponse struct {
+	Tokens []pat.Token `json:"tokens"`
+	Err    error       `json:"-"`
+}
+
+func (r PATsResponse) Failed() error { return r.Err }
+
+type RevokePATRequest struct {
+	ID string `json:"id"`
+}
+
+type RevokePATResponse struct {
+	Success bool  `json:"success"`
+	Err     error `json:"-"`
+}
+
+func (r RevokePATResponse) Failed() error { return r.Err }
+
+type ResolvePATRequest struct {
+	Token string `json:"token"`
+}
+
+type ResolvePATResponse struct {
+	Token pat.Token `json:"token"`
+	Err   error     `json:"-"`
+}
+
+func (r ResolvePATResponse) Failed() error { return r.Err }
+
+// RevocationsRequest takes the time to wait for a revocation in
+// milliseconds.
+type RevocationsRequest struct {
+	Since uint64 `json:"since"`
+	Wait  int64  `json:"wait"`
+}
+
+type RevocationsResponse struct {
+	Revocations []authsvc.Revocation `json:"revocations"`
+	Next        uint64               `json:"next"`
+	Err         error                `json:"-"`
+}
+
+func (r RevocationsResponse) Failed() error { return r.Err }
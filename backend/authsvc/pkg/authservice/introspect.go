@@ -0,0 +1,176 @@
+package authservice
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
+)
+
+// Introspect reports whether the token is active, together with the user
+// and the client it was issued to, as defined by RFC 7662. The kind of the
+// token is told by its claims, the hint is not needed. Tokens which are
+// invalid or unknown are not an error, they are reported inactive. The
+// tokens of disabled users, including their personal access tokens, are
+// reported inactive by ProxingMiddleware, which knows the users.
+func (s *basicService) Introspect(_ context.Context, req oauth.IntrospectionRequest) (oauth.Introspection, error) {
+	if req.Token == "" {
+		return oauth.Introspection{}, oauth.Errorf(oauth.ErrorInvalidRequest, "token is required")
+	}
+
+	switch {
+	case pat.IsToken(req.Token):
+		r, err := s.lookupPAT(req.Token)
+		if err == authsvc.ErrInvalidToken {
+			return oauth.Introspection{}, nil
+		}
+		if err != nil {
+			return oauth.Introspection{}, err
+		}
+
+		i := oauth.Introspection{
+			Active:    true,
+			Scope:     r.Scopes.String(),
+			TokenType: "Bearer",
+			Iat:       r.CreatedAt.Unix(),
+			Sub:       strconv.FormatUint(r.UserID, 10),
+			Jti:       r.ID,
+		}
+		if r.ExpiresAt != nil {
+			i.Exp = r.ExpiresAt.Unix()
+		}
+		return i, nil
+
+	case req.RefreshUUID != "":
+		rs, err := s.refreshState(req.RefreshUUID)
+		if err == inmem.ErrKeyNotFound {
+			return oauth.Introspection{}, nil
+		}
+		if err != nil {
+			return oauth.Introspection{}, err
+		}
+		// A used refresh token can no longer be exchanged.
+		if rs.UserID != req.UserID || rs.Used {
+			return oauth.Introspection{}, nil
+		}
+
+		return oauth.Introspection{
+			Active:   true,
+			Scope:    strings.Join(rs.Scopes, " "),
+			ClientID: rs.ClientID,
+			Exp:      rs.ExpiresAt.Unix(),
+			Sub:      strconv.FormatUint(rs.UserID, 10),
+			Jti:      req.RefreshUUID,
+		}, nil
+
+	case req.AccessUUID != "":
+		session, err := s.session(req.AccessUUID)
+		if err == inmem.ErrKeyNotFound {
+			return oauth.Introspection{}, nil
+		}
+		if err != nil {
+			return oauth.Introspection{}, err
+		}
+		if session.UserID != req.UserID {
+			return oauth.Introspection{}, nil
+		}
+
+		return oauth.Introspection{
+			Active:    true,
+			Scope:     strings.Join(session.Scopes, " "),
+			ClientID:  session.ClientID,
+			TokenType: "Bearer",
+			Exp:       req.ExpiresAt,
+			Sub:       strconv.FormatUint(session.UserID, 10),
+			Jti:       req.AccessUUID,
+		}, nil
+	}
+
+	return oauth.Introspection{}, nil
+}
+
+// RevokeToken revokes an access token or a refresh token as defined by RFC
+// 7009. The client must be registered and the token must have been issued
+// to it, first-party sessions sign out through Logout and personal access
+// tokens are revoked by their owner. Revoking either token of a pair signs
+// out the session, for refresh tokens the latest pair of the family is
+// signed out. Tokens which are invalid or already revoked are ignored.
+func (s *basicService) RevokeToken(_ context.Context, req oauth.IntrospectionRequest) error {
+	if req.Token == "" {
+		return oauth.Errorf(oauth.ErrorInvalidRequest, "token is required")
+	}
+	if req.ClientID == "" {
+		return oauth.Errorf(oauth.ErrorInvalidClient, "client_id is required")
+	}
+
+	// The clients are public, they are authenticated by their ID.
+	if _, err := s.oauthClient(req.ClientID); err != nil {
+		if err == authsvc.ErrClientNotFound {
+			return oauth.Errorf(oauth.ErrorInvalidClient, "unknown client")
+		}
+		return err
+	}
+
+	switch {
+	case pat.IsToken(req.Token):
+		_, err := s.lookupPAT(req.Token)
+		if err == authsvc.ErrInvalidToken {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return oauth.Errorf(oauth.ErrorUnauthorizedClient, "token was not issued to the client")
+
+	case req.RefreshUUID != "":
+		rs, err := s.refreshState(req.RefreshUUID)
+		if err == inmem.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if rs.UserID != req.UserID {
+			return nil
+		}
+		if rs.ClientID != req.ClientID {
+			return oauth.Errorf(oauth.ErrorUnauthorizedClient, "token was not issued to the client")
+		}
+		return s.revokeFamily(rs.Family)
+
+	case req.AccessUUID != "":
+		session, err := s.session(req.AccessUUID)
+		if err == inmem.ErrKeyNotFound {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if session.UserID != req.UserID {
+			return nil
+		}
+		if session.ClientID != req.ClientID {
+			return oauth.Errorf(oauth.ErrorUnauthorizedClient, "token was not issued to the client")
+		}
+		return s.revoke(session.UserID, req.AccessUUID)
+	}
+
+	return nil
+}
+
+func (s *basicService) refreshState(refreshUUID string) (refreshState, error) {
+	var rs refreshState
+
+	value, err := s.client.Get(refreshUUID)
+	if err != nil {
+		return rs, err
+	}
+
+	err = json.Unmarshal(value, &rs)
+	return rs, err
+}
@@ -0,0 +1,335 @@
+package authservice
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+)
+
+// The keys of the keyring go through the following states. A key is
+// published as the next key for at least MinNextKeyAge before it is used for
+// signing, so that every verifier has fetched it by then, and it is still
+// published while retiring until the last token it signed has expired.
+const (
+	KeyNext     = "next"
+	KeyActive   = "active"
+	KeyRetiring = "retiring"
+)
+
+const (
+	keyringKey = "jwt/keyring"
+
+	// KeyringReloadInterval is how often authsvc reloads the keyring, i.e.
+	// how long an instance may keep signing with a key after it retired.
+	KeyringReloadInterval = time.Minute
+	// MinNextKeyAge is the minimum time between two rotations.
+	MinNextKeyAge = jwks.DefaultTTL + KeyringReloadInterval
+	// retiringPeriod covers the lifetime of the longest lived token, the
+	// refresh token.
+	retiringPeriod = refreshTokenTTL + KeyringReloadInterval
+)
+
+var (
+	ErrKeyringMissing = errors.New("keyring does not exist")
+	ErrRotationEarly  = errors.New("next key was not published long enough")
+	ErrSecretMissing  = errors.New("keyring secret is required")
+	ErrKeySealBroken  = errors.New("keyring key could not be decrypted with the secret")
+)
+
+// ringKey is a key of the keyring as stored in the KV store. The private
+// key is sealed with the keyring secret, which only authsvc and rotatekeys
+// are given, so that the other services reading the store cannot sign
+// tokens.
+type ringKey struct {
+	Kid       string    `json:"kid"`
+	Alg       string    `json:"alg"`
+	State     string    `json:"state"`
+	Sealed    []byte    `json:"sealed"`
+	CreatedAt time.Time `json:"created_at"`
+	RetiredAt time.Time `json:"retired_at,omitempty"`
+}
+
+// Keyring is a KeyProvider whose keys are shared by all authsvc instances
+// through the KV store and rotated by Rotate.
+type Keyring struct {
+	client inmem.Client
+	aead   cipher.AEAD
+
+	mtx    sync.RWMutex
+	active *keyProvider
+	public jwks.KeySet
+}
+
+// NewKeyring loads the keyring, which is created with a new active and next
+// key of the given algorithm if it does not exist yet. The private keys are
+// sealed with the secret.
+func NewKeyring(c inmem.Client, alg string, secret []byte) (*Keyring, error) {
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return nil, err
+	}
+	k := &Keyring{client: c, aead: aead}
+
+	err = k.Reload()
+	if err == ErrKeyringMissing {
+		if err := createKeyring(c, aead, alg); err != nil {
+			return nil, err
+		}
+		err = k.Reload()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (k *Keyring) SigningKey() (string, jwt.SigningMethod, crypto.Signer) {
+	k.mtx.RLock()
+	defer k.mtx.RUnlock()
+	return k.active.SigningKey()
+}
+
+func (k *Keyring) PublicKeys() jwks.KeySet {
+	k.mtx.RLock()
+	defer k.mtx.RUnlock()
+	return k.public
+}
+
+// Reload reads the keyring from the KV store, it should be called every
+// KeyringReloadInterval.
+func (k *Keyring) Reload() error {
+	keys, err := loadKeys(k.client)
+	if err != nil {
+		return err
+	}
+
+	var (
+		active *keyProvider
+		public jwks.KeySet
+	)
+	for _, rk := range keys {
+		signer, err := rk.signer(k.aead)
+		if err != nil {
+			return err
+		}
+		p, err := NewKeyProvider(signer)
+		if err != nil {
+			return err
+		}
+
+		if rk.State == KeyActive {
+			active = p.(*keyProvider)
+		}
+		if rk.State != KeyRetiring || time.Since(rk.RetiredAt) < retiringPeriod {
+			public.Keys = append(public.Keys, p.(*keyProvider).public)
+		}
+	}
+	if active == nil {
+		return ErrKeyringMissing
+	}
+
+	k.mtx.Lock()
+	k.active, k.public = active, public
+	k.mtx.Unlock()
+
+	return nil
+}
+
+// Rotate retires the active key, activates the next key and generates a new
+// next key with the given algorithm. Retiring keys whose tokens have all
+// expired are removed. Unless force is set, the rotation is refused when the
+// next key was published less than MinNextKeyAge ago. The secret must be
+// the one authsvc is given. It returns the ID of the new active key.
+//
+// The keyring is replaced with a compare-and-swap, so that no key added by
+// a concurrent rotation is lost along with the tokens it signed.
+func Rotate(c inmem.Client, alg string, secret []byte, force bool) (string, error) {
+	aead, err := newAEAD(secret)
+	if err != nil {
+		return "", err
+	}
+
+	var kid string
+	err = inmem.Update(c, keyringKey, func(value []byte) ([]byte, time.Duration, error) {
+		keys, err := decodeKeys(value)
+		if err != nil && err != ErrKeyringMissing {
+			return nil, 0, err
+		}
+
+		var rotated []ringKey
+		kid, rotated, err = rotateKeys(aead, alg, keys, force, time.Now().UTC())
+		if err != nil {
+			return nil, 0, err
+		}
+
+		b, err := json.Marshal(rotated)
+		return b, 0, err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return kid, nil
+}
+
+// createKeyring stores a new keyring unless another instance did first, in
+// which case that one is kept.
+func createKeyring(c inmem.Client, aead cipher.AEAD, alg string) error {
+	_, keys, err := rotateKeys(aead, alg, nil, true, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(keys)
+	if err != nil {
+		return err
+	}
+	_, err = c.CompareAndSwap(keyringKey, nil, b, 0)
+	return err
+}
+
+// rotateKeys returns the ID of the new active key and the keys of the
+// rotated keyring, see Rotate.
+func rotateKeys(aead cipher.AEAD, alg string, keys []ringKey, force bool, now time.Time) (string, []ringKey, error) {
+	var (
+		next    *ringKey
+		rotated []ringKey
+	)
+	for i := range keys {
+		switch keys[i].State {
+		case KeyNext:
+			next = &keys[i]
+		case KeyActive:
+			keys[i].State = KeyRetiring
+			keys[i].RetiredAt = now
+		}
+	}
+	if next != nil && !force && now.Sub(next.CreatedAt) < MinNextKeyAge {
+		return "", nil, ErrRotationEarly
+	}
+
+	// A keyring without a next key, i.e. a new one, gets its active key
+	// right away.
+	if next == nil {
+		rk, err := newRingKey(aead, alg, now)
+		if err != nil {
+			return "", nil, err
+		}
+		keys = append(keys, rk)
+		next = &keys[len(keys)-1]
+	}
+	next.State = KeyActive
+	kid := next.Kid
+
+	for _, rk := range keys {
+		if rk.State == KeyRetiring && now.Sub(rk.RetiredAt) >= retiringPeriod {
+			continue
+		}
+		rotated = append(rotated, rk)
+	}
+
+	rk, err := newRingKey(aead, alg, now)
+	if err != nil {
+		return "", nil, err
+	}
+	rotated = append(rotated, rk)
+
+	return kid, rotated, nil
+}
+
+func loadKeys(c inmem.Client) ([]ringKey, error) {
+	b, err := c.Get(keyringKey)
+	if err == inmem.ErrKeyNotFound {
+		return nil, ErrKeyringMissing
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeKeys(b)
+}
+
+// decodeKeys reads the stored keyring, nil if it is missing.
+func decodeKeys(b []byte) ([]ringKey, error) {
+	if b == nil {
+		return nil, ErrKeyringMissing
+	}
+
+	var keys []ringKey
+	err := json.Unmarshal(b, &keys)
+	return keys, err
+}
+
+func newRingKey(aead cipher.AEAD, alg string, now time.Time) (ringKey, error) {
+	signer, err := generateKey(alg)
+	if err != nil {
+		return ringKey{}, err
+	}
+	p, err := NewKeyProvider(signer)
+	if err != nil {
+		return ringKey{}, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(signer)
+	if err != nil {
+		return ringKey{}, err
+	}
+
+	kid, method, _ := p.SigningKey()
+
+	// The key ID is authenticated along, so that a sealed key cannot be
+	// passed off as another.
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return ringKey{}, err
+	}
+	sealed := aead.Seal(nonce, nonce, der, []byte(kid))
+
+	return ringKey{Kid: kid, Alg: method.Alg(), State: KeyNext, Sealed: sealed, CreatedAt: now}, nil
+}
+
+func (rk ringKey) signer(aead cipher.AEAD) (crypto.Signer, error) {
+	n := aead.NonceSize()
+	if len(rk.Sealed) < n {
+		return nil, ErrKeySealBroken
+	}
+	der, err := aead.Open(nil, rk.Sealed[:n], rk.Sealed[n:], []byte(rk.Kid))
+	if err != nil {
+		return nil, ErrKeySealBroken
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, jwks.ErrUnsupportedKey
+	}
+	return signer, nil
+}
+
+// newAEAD returns the AES-GCM cipher the private keys are sealed with, whose
+// key is derived from the secret.
+func newAEAD(secret []byte) (cipher.AEAD, error) {
+	if len(secret) == 0 {
+		return nil, ErrSecretMissing
+	}
+
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
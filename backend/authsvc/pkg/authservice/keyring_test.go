@@ -0,0 +1,103 @@
+package authservice_test
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authservice"
+)
+
+func TestKeyringSealsPrivateKeys(t *testing.T) {
+	t.Parallel()
+
+	c := inmem.NewMemoryClient()
+	k, err := authservice.NewKeyring(c, "EdDSA", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := c.Get("jwt/keyring")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(value, []byte(`"private"`)) {
+		t.Errorf("keyring holds plain private keys: %s", value)
+	}
+	if len(k.PublicKeys().Keys) != 2 {
+		t.Errorf("want the active and next key published, got %d keys", len(k.PublicKeys().Keys))
+	}
+
+	if _, err := authservice.NewKeyring(c, "EdDSA", []byte("other")); err != authservice.ErrKeySealBroken {
+		t.Errorf("want %v with another secret, got %v", authservice.ErrKeySealBroken, err)
+	}
+	if _, err := authservice.NewKeyring(c, "EdDSA", nil); err != authservice.ErrSecretMissing {
+		t.Errorf("want %v without a secret, got %v", authservice.ErrSecretMissing, err)
+	}
+}
+
+func TestRotateKeepsKeysSealed(t *testing.T) {
+	t.Parallel()
+
+	c := inmem.NewMemoryClient()
+	k, err := authservice.NewKeyring(c, "EdDSA", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, _, _ := k.SigningKey()
+
+	kid, err := authservice.Rotate(c, "EdDSA", []byte("secret"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.Reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	after, _, _ := k.SigningKey()
+	if after != kid || after == before {
+		t.Errorf("want %s active after rotating from %s, got %s", kid, before, after)
+	}
+}
+
+// TestConcurrentRotationsKeepEveryKey checks that no rotation overwrites
+// another, which would drop the keys it activated.
+func TestConcurrentRotationsKeepEveryKey(t *testing.T) {
+	t.Parallel()
+
+	c := inmem.NewMemoryClient()
+	if _, err := authservice.NewKeyring(c, "EdDSA", []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 8
+	kids := make([]string, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			kid, err := authservice.Rotate(c, "EdDSA", []byte("secret"), true)
+			if err != nil {
+				t.Error(err)
+			}
+			kids[i] = kid
+		}(i)
+	}
+	wg.Wait()
+
+	k, err := authservice.NewKeyring(c, "EdDSA", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := make(map[string]bool)
+	for _, key := range k.PublicKeys().Keys {
+		published[key.Kid] = true
+	}
+	for _, kid := range kids {
+		if !published[kid] {
+			t.Errorf("key %s activated by a rotation is not published", kid)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package authservice
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+)
+
+var ErrNoPrivateKey = errors.New("no private key found")
+
+// KeyProvider holds the key pairs the tokens are signed with. Only the
+// public keys are published, so that no other service is able to mint
+// tokens.
+type KeyProvider interface {
+	// SigningKey returns the key ID, the signing method and the private key
+	// which new tokens are signed with.
+	SigningKey() (kid string, method jwt.SigningMethod, key crypto.Signer)
+	// PublicKeys returns the keys which the issued tokens are verified with.
+	PublicKeys() jwks.KeySet
+}
+
+type keyProvider struct {
+	kid    string
+	method jwt.SigningMethod
+	key    crypto.Signer
+	public jwks.Key
+}
+
+// NewKeyProvider signs with RS256 for RSA keys and with EdDSA for Ed25519
+// keys. The key ID is the thumbprint of the public key.
+func NewKeyProvider(key crypto.Signer) (KeyProvider, error) {
+	var method jwt.SigningMethod
+	switch key.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case ed25519.PrivateKey:
+		method = jwks.SigningMethodEdDSA
+	default:
+		return nil, jwks.ErrUnsupportedKey
+	}
+
+	public, err := jwks.NewKey("", method.Alg(), key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return &keyProvider{kid: public.Kid, method: method, key: key, public: public}, nil
+}
+
+// LoadKeyProvider reads a PEM encoded PKCS #8 or PKCS #1 private key.
+func LoadKeyProvider(path string) (KeyProvider, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := parsePrivateKey(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKeyProvider(key)
+}
+
+func (p *keyProvider) SigningKey() (string, jwt.SigningMethod, crypto.Signer) {
+	return p.kid, p.method, p.key
+}
+
+func (p *keyProvider) PublicKeys() jwks.KeySet {
+	return jwks.KeySet{Keys: []jwks.Key{p.public}}
+}
+
+// generateKey generates a new key pair for the RS256 or the EdDSA algorithm.
+func generateKey(alg string) (crypto.Signer, error) {
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case jwks.SigningMethodEdDSA.Alg():
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	}
+	return nil, jwks.ErrUnsupportedKey
+}
+
+func parsePrivateKey(b []byte) (crypto.Signer, error) {
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return nil, ErrNoPrivateKey
+		}
+
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				return nil, jwks.ErrUnsupportedKey
+			}
+			return signer, nil
+		case "RSA PRIVATE KEY":
+			return x509.ParsePKCS1PrivateKey(block.Bytes)
+		}
+	}
+}
@@ -2,12 +2,16 @@ package authservice
 
 import (
 	"context"
+	"strconv"
 	"time"
 
-	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics"
 	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
 	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/userendpoint"
 )
 
@@ -52,9 +56,192 @@ func (mw loggingMiddleware) Validate(ctx context.Context, accessUUID string) (v
 	return mw.next.Validate(ctx, accessUUID)
 }
 
-func ProxingMiddleware(ctx context.Context, userIDEndpoint, isUserExists endpoint.Endpoint) Middleware {
+func (mw loggingMiddleware) Sessions(ctx context.Context, userID uint64) (sessions []authsvc.Session, err error) {
+	defer func() {
+		mw.logger.Log("method", "Sessions", "user_id", userID, "sessions", len(sessions), "err", err)
+	}()
+	return mw.next.Sessions(ctx, userID)
+}
+
+func (mw loggingMiddleware) RevokeSession(ctx context.Context, accessUUID string, userID uint64, sessionID string) (success bool, err error) {
+	defer func() {
+		mw.logger.Log("method", "RevokeSession", "user_id", userID, "session_id", sessionID, "success", success, "err", err)
+	}()
+	return mw.next.RevokeSession(ctx, accessUUID, userID, sessionID)
+}
+
+func (mw loggingMiddleware) RevokeAllSessions(ctx context.Context, accessUUID string, userID uint64) (n int, err error) {
+	defer func() {
+		mw.logger.Log("method", "RevokeAllSessions", "user_id", userID, "revoked", n, "err", err)
+	}()
+	return mw.next.RevokeAllSessions(ctx, accessUUID, userID)
+}
+
+func (mw loggingMiddleware) RevokeUserSessions(ctx context.Context, accessUUID string, userID uint64) (n int, err error) {
+	defer func() {
+		mw.logger.Log("method", "RevokeUserSessions", "user_id", userID, "revoked", n, "err", err)
+	}()
+	return mw.next.RevokeUserSessions(ctx, accessUUID, userID)
+}
+
+func (mw loggingMiddleware) Unlock(ctx context.Context, accessUUID, username, ip string) (success bool, err error) {
+	defer func() {
+		mw.logger.Log("method", "Unlock", "username", username, "ip", ip, "success", success, "err", err)
+	}()
+	return mw.next.Unlock(ctx, accessUUID, username, ip)
+}
+
+func (mw loggingMiddleware) VerifyMFA(ctx context.Context, mfaUUID string, userID uint64, code string) (tokens map[string]string, err error) {
+	defer func() {
+		mw.logger.Log("method", "VerifyMFA", "user_id", userID, "err", err)
+	}()
+	return mw.next.VerifyMFA(ctx, mfaUUID, userID, code)
+}
+
+func (mw loggingMiddleware) EnrollTOTP(ctx context.Context, accessUUID string, userID uint64) (k usersvc.TOTPKey, err error) {
+	defer func() {
+		mw.logger.Log("method", "EnrollTOTP", "user_id", userID, "err", err)
+	}()
+	return mw.next.EnrollTOTP(ctx, accessUUID, userID)
+}
+
+func (mw loggingMiddleware) ConfirmTOTP(ctx context.Context, accessUUID string, userID uint64, code string) (codes []string, err error) {
+	defer func() {
+		mw.logger.Log("method", "ConfirmTOTP", "user_id", userID, "err", err)
+	}()
+	return mw.next.ConfirmTOTP(ctx, accessUUID, userID, code)
+}
+
+func (mw loggingMiddleware) DisableTOTP(ctx context.Context, accessUUID string, userID uint64, code string) (success bool, err error) {
+	defer func() {
+		mw.logger.Log("method", "DisableTOTP", "user_id", userID, "success", success, "err", err)
+	}()
+	return mw.next.DisableTOTP(ctx, accessUUID, userID, code)
+}
+
+func (mw loggingMiddleware) ChangePassword(ctx context.Context, username, oldPassword, newPassword string) (success bool, err error) {
+	defer func() {
+		mw.logger.Log("method", "ChangePassword", "username", username, "success", success, "err", err)
+	}()
+	return mw.next.ChangePassword(ctx, username, oldPassword, newPassword)
+}
+
+func (mw loggingMiddleware) JWKS(ctx context.Context) (set jwks.KeySet, err error) {
+	defer func() {
+		mw.logger.Log("method", "JWKS", "keys", len(set.Keys), "err", err)
+	}()
+	return mw.next.JWKS(ctx)
+}
+
+func (mw loggingMiddleware) RegisterClient(ctx context.Context, accessUUID string, userID uint64, c oauth.Client) (client oauth.Client, err error) {
+	defer func() {
+		mw.logger.Log("method", "RegisterClient", "user_id", userID, "client_id", client.ID, "err", err)
+	}()
+	return mw.next.RegisterClient(ctx, accessUUID, userID, c)
+}
+
+func (mw loggingMiddleware) Client(ctx context.Context, clientID string) (c oauth.Client, err error) {
+	defer func() {
+		mw.logger.Log("method", "Client", "client_id", clientID, "err", err)
+	}()
+	return mw.next.Client(ctx, clientID)
+}
+
+func (mw loggingMiddleware) Authorize(ctx context.Context, accessUUID string, userID uint64, req oauth.AuthorizeRequest) (redirectURI string, err error) {
+	defer func() {
+		mw.logger.Log("method", "Authorize", "user_id", userID, "client_id", req.ClientID, "scope", req.Scope, "err", err)
+	}()
+	return mw.next.Authorize(ctx, accessUUID, userID, req)
+}
+
+func (mw loggingMiddleware) Token(ctx context.Context, req oauth.TokenRequest) (t oauth.Token, err error) {
+	defer func() {
+		mw.logger.Log("method", "Token", "client_id", req.ClientID, "grant_type", req.GrantType, "err", err)
+	}()
+	return mw.next.Token(ctx, req)
+}
+
+func (mw loggingMiddleware) Consents(ctx context.Context, userID uint64) (consents []oauth.Consent, err error) {
+	defer func() {
+		mw.logger.Log("method", "Consents", "user_id", userID, "consents", len(consents), "err", err)
+	}()
+	return mw.next.Consents(ctx, userID)
+}
+
+func (mw loggingMiddleware) RevokeConsent(ctx context.Context, accessUUID string, userID uint64, clientID string) (success bool, err error) {
+	defer func() {
+		mw.logger.Log("method", "RevokeConsent", "user_id", userID, "client_id", clientID, "success", success, "err", err)
+	}()
+	return mw.next.RevokeConsent(ctx, accessUUID, userID, clientID)
+}
+
+func (mw loggingMiddleware) Introspect(ctx context.Context, req oauth.IntrospectionRequest) (i oauth.Introspection, err error) {
+	defer func() {
+		mw.logger.Log("method", "Introspect", "hint", req.TokenTypeHint, "active", i.Active, "err", err)
+	}()
+	return mw.next.Introspect(ctx, req)
+}
+
+func (mw loggingMiddleware) RevokeToken(ctx context.Context, req oauth.IntrospectionRequest) (err error) {
+	defer func() {
+		mw.logger.Log("method", "RevokeToken", "hint", req.TokenTypeHint, "client_id", req.ClientID, "err", err)
+	}()
+	return mw.next.RevokeToken(ctx, req)
+}
+
+func (mw loggingMiddleware) OIDCLogin(ctx context.Context, provider string) (url, binding string, err error) {
+	defer func() {
+		mw.logger.Log("method", "OIDCLogin", "provider", provider, "err", err)
+	}()
+	return mw.next.OIDCLogin(ctx, provider)
+}
+
+func (mw loggingMiddleware) OIDCCallback(ctx context.Context, provider, code, state, binding string) (tokens map[string]string, err error) {
+	defer func() {
+		mw.logger.Log("method", "OIDCCallback", "provider", provider, "err", err)
+	}()
+	return mw.next.OIDCCallback(ctx, provider, code, state, binding)
+}
+
+func (mw loggingMiddleware) CreatePAT(ctx context.Context, accessUUID string, userID uint64, name string, scopes []string, ttl time.Duration) (t pat.Token, err error) {
+	defer func() {
+		mw.logger.Log("method", "CreatePAT", "user_id", userID, "name", name, "id", t.ID, "err", err)
+	}()
+	return mw.next.CreatePAT(ctx, accessUUID, userID, name, scopes, ttl)
+}
+
+func (mw loggingMiddleware) PATs(ctx context.Context, userID uint64) (tokens []pat.Token, err error) {
+	defer func() {
+		mw.logger.Log("method", "PATs", "user_id", userID, "tokens", len(tokens), "err", err)
+	}()
+	return mw.next.PATs(ctx, userID)
+}
+
+func (mw loggingMiddleware) RevokePAT(ctx context.Context, accessUUID string, userID uint64, id string) (success bool, err error) {
+	defer func() {
+		mw.logger.Log("method", "RevokePAT", "user_id", userID, "id", id, "success", success, "err", err)
+	}()
+	return mw.next.RevokePAT(ctx, accessUUID, userID, id)
+}
+
+// ResolvePAT never logs the secret.
+func (mw loggingMiddleware) ResolvePAT(ctx context.Context, secret string) (t pat.Token, err error) {
+	defer func() {
+		mw.logger.Log("method", "ResolvePAT", "id", t.ID, "user_id", t.UserID, "err", err)
+	}()
+	return mw.next.ResolvePAT(ctx, secret)
+}
+
+func (mw loggingMiddleware) Revocations(ctx context.Context, since uint64, wait time.Duration) (revocations []authsvc.Revocation, next uint64, err error) {
+	defer func() {
+		mw.logger.Log("method", "Revocations", "since", since, "wait", wait, "revocations", len(revocations), "err", err)
+	}()
+	return mw.next.Revocations(ctx, since, wait)
+}
+
+func ProxingMiddleware(ctx context.Context, users userendpoint.Set, th Throttler) Middleware {
 	return func(next Service) Service {
-		return proxingMiddleware{next, userIDEndpoint, isUserExists}
+		return proxingMiddleware{next, users, th}
 	}
 }
 
@@ -106,24 +293,285 @@ func (mw instrumentingMiddleware) Validate(ctx context.Context, accessUUID strin
 	return mw.next.Validate(ctx, accessUUID)
 }
 
+func (mw instrumentingMiddleware) Sessions(ctx context.Context, userID uint64) (sessions []authsvc.Session, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "sessions").Add(1)
+		mw.requestLatency.With("method", "sessions").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.Sessions(ctx, userID)
+}
+
+func (mw instrumentingMiddleware) RevokeSession(ctx context.Context, accessUUID string, userID uint64, sessionID string) (success bool, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "revoke_session").Add(1)
+		mw.requestLatency.With("method", "revoke_session").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.RevokeSession(ctx, accessUUID, userID, sessionID)
+}
+
+func (mw instrumentingMiddleware) RevokeAllSessions(ctx context.Context, accessUUID string, userID uint64) (n int, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "revoke_all_sessions").Add(1)
+		mw.requestLatency.With("method", "revoke_all_sessions").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.RevokeAllSessions(ctx, accessUUID, userID)
+}
+
+func (mw instrumentingMiddleware) RevokeUserSessions(ctx context.Context, accessUUID string, userID uint64) (n int, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "revoke_user_sessions").Add(1)
+		mw.requestLatency.With("method", "revoke_user_sessions").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.RevokeUserSessions(ctx, accessUUID, userID)
+}
+
+func (mw instrumentingMiddleware) Unlock(ctx context.Context, accessUUID, username, ip string) (success bool, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "unlock").Add(1)
+		mw.requestLatency.With("method", "unlock").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.Unlock(ctx, accessUUID, username, ip)
+}
+
+func (mw instrumentingMiddleware) VerifyMFA(ctx context.Context, mfaUUID string, userID uint64, code string) (tokens map[string]string, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "verify_mfa").Add(1)
+		mw.requestLatency.With("method", "verify_mfa").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.VerifyMFA(ctx, mfaUUID, userID, code)
+}
+
+func (mw instrumentingMiddleware) EnrollTOTP(ctx context.Context, accessUUID string, userID uint64) (k usersvc.TOTPKey, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "enroll_totp").Add(1)
+		mw.requestLatency.With("method", "enroll_totp").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.EnrollTOTP(ctx, accessUUID, userID)
+}
+
+func (mw instrumentingMiddleware) ConfirmTOTP(ctx context.Context, accessUUID string, userID uint64, code string) (codes []string, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "confirm_totp").Add(1)
+		mw.requestLatency.With("method", "confirm_totp").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.ConfirmTOTP(ctx, accessUUID, userID, code)
+}
+
+func (mw instrumentingMiddleware) DisableTOTP(ctx context.Context, accessUUID string, userID uint64, code string) (success bool, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "disable_totp").Add(1)
+		mw.requestLatency.With("method", "disable_totp").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.DisableTOTP(ctx, accessUUID, userID, code)
+}
+
+func (mw instrumentingMiddleware) ChangePassword(ctx context.Context, username, oldPassword, newPassword string) (success bool, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "change_password").Add(1)
+		mw.requestLatency.With("method", "change_password").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.ChangePassword(ctx, username, oldPassword, newPassword)
+}
+
+func (mw instrumentingMiddleware) JWKS(ctx context.Context) (set jwks.KeySet, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "jwks").Add(1)
+		mw.requestLatency.With("method", "jwks").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.JWKS(ctx)
+}
+
+func (mw instrumentingMiddleware) RegisterClient(ctx context.Context, accessUUID string, userID uint64, c oauth.Client) (client oauth.Client, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "register_client").Add(1)
+		mw.requestLatency.With("method", "register_client").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.RegisterClient(ctx, accessUUID, userID, c)
+}
+
+func (mw instrumentingMiddleware) Client(ctx context.Context, clientID string) (c oauth.Client, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "client").Add(1)
+		mw.requestLatency.With("method", "client").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.Client(ctx, clientID)
+}
+
+func (mw instrumentingMiddleware) Authorize(ctx context.Context, accessUUID string, userID uint64, req oauth.AuthorizeRequest) (redirectURI string, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "authorize").Add(1)
+		mw.requestLatency.With("method", "authorize").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.Authorize(ctx, accessUUID, userID, req)
+}
+
+func (mw instrumentingMiddleware) Token(ctx context.Context, req oauth.TokenRequest) (t oauth.Token, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "token").Add(1)
+		mw.requestLatency.With("method", "token").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.Token(ctx, req)
+}
+
+func (mw instrumentingMiddleware) Consents(ctx context.Context, userID uint64) (consents []oauth.Consent, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "consents").Add(1)
+		mw.requestLatency.With("method", "consents").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.Consents(ctx, userID)
+}
+
+func (mw instrumentingMiddleware) RevokeConsent(ctx context.Context, accessUUID string, userID uint64, clientID string) (success bool, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "revoke_consent").Add(1)
+		mw.requestLatency.With("method", "revoke_consent").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.RevokeConsent(ctx, accessUUID, userID, clientID)
+}
+
+func (mw instrumentingMiddleware) Introspect(ctx context.Context, req oauth.IntrospectionRequest) (i oauth.Introspection, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "introspect").Add(1)
+		mw.requestLatency.With("method", "introspect").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.Introspect(ctx, req)
+}
+
+func (mw instrumentingMiddleware) RevokeToken(ctx context.Context, req oauth.IntrospectionRequest) (err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "revoke_token").Add(1)
+		mw.requestLatency.With("method", "revoke_token").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.RevokeToken(ctx, req)
+}
+
+func (mw instrumentingMiddleware) OIDCLogin(ctx context.Context, provider string) (url, binding string, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "oidc_login").Add(1)
+		mw.requestLatency.With("method", "oidc_login").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.OIDCLogin(ctx, provider)
+}
+
+func (mw instrumentingMiddleware) OIDCCallback(ctx context.Context, provider, code, state, binding string) (tokens map[string]string, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "oidc_callback").Add(1)
+		mw.requestLatency.With("method", "oidc_callback").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.OIDCCallback(ctx, provider, code, state, binding)
+}
+
+func (mw instrumentingMiddleware) CreatePAT(ctx context.Context, accessUUID string, userID uint64, name string, scopes []string, ttl time.Duration) (t pat.Token, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "create_pat").Add(1)
+		mw.requestLatency.With("method", "create_pat").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.CreatePAT(ctx, accessUUID, userID, name, scopes, ttl)
+}
+
+func (mw instrumentingMiddleware) PATs(ctx context.Context, userID uint64) (tokens []pat.Token, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "pats").Add(1)
+		mw.requestLatency.With("method", "pats").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.PATs(ctx, userID)
+}
+
+func (mw instrumentingMiddleware) RevokePAT(ctx context.Context, accessUUID string, userID uint64, id string) (success bool, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "revoke_pat").Add(1)
+		mw.requestLatency.With("method", "revoke_pat").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.RevokePAT(ctx, accessUUID, userID, id)
+}
+
+func (mw instrumentingMiddleware) ResolvePAT(ctx context.Context, secret string) (t pat.Token, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "resolve_pat").Add(1)
+		mw.requestLatency.With("method", "resolve_pat").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.ResolvePAT(ctx, secret)
+}
+
+func (mw instrumentingMiddleware) Revocations(ctx context.Context, since uint64, wait time.Duration) (revocations []authsvc.Revocation, next uint64, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "revocations").Add(1)
+		mw.requestLatency.With("method", "revocations").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.Revocations(ctx, since, wait)
+}
+
 type proxingMiddleware struct {
-	next         Service
-	userID       endpoint.Endpoint
-	isUserExists endpoint.Endpoint
+	next      Service
+	users     userendpoint.Set
+	throttler Throttler
 }
 
+// Login only forwards the credentials to usersvc when neither the username
+// nor the client IP is being throttled because of previous failures.
 func (mw proxingMiddleware) Login(ctx context.Context, username, password string) (map[string]string, error) {
-	response, err := mw.userID(ctx, userendpoint.UserIDRequest{Name: username, Password: password})
+	ip, _ := ctx.Value(authsvc.ClientIPContextKey).(string)
+	if err := mw.throttler.Allow(username, ip); err != nil {
+		return nil, err
+	}
+
+	response, err := mw.users.UserIDEndpoint(ctx, userendpoint.UserIDRequest{Name: username, Password: password})
 	if err != nil {
 		return nil, err
 	}
 
 	resp := response.(userendpoint.UserIDResponse)
+	if resp.Err == usersvc.ErrUserNotFound {
+		if err := mw.throttler.Fail(username, ip); err != nil {
+			return nil, err
+		}
+	}
 	if resp.Err != nil {
 		return nil, resp.Err
 	}
 
+	profile, err := mw.users.Profile(ctx, resp.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The failures are only forgotten once the login is complete, which
+	// takes the second factor of the users who enabled it.
+	if !profile.TOTPEnabled {
+		if err := mw.throttler.Succeed(username); err != nil {
+			return nil, err
+		}
+	}
+
 	ctx = context.WithValue(ctx, authsvc.UserIDContextKey, resp.ID)
+	ctx = context.WithValue(ctx, authsvc.MFARequiredContextKey, profile.TOTPEnabled)
+	ctx = context.WithValue(ctx, authsvc.RolesContextKey, []string(profile.Roles))
 
 	return mw.next.Login(ctx, username, password)
 }
@@ -132,20 +580,282 @@ func (mw proxingMiddleware) Logout(ctx context.Context, accessUUID string) (bool
 	return mw.next.Logout(ctx, accessUUID)
 }
 
+// Refresh reads the roles again, so that role changes take effect with the
+// next token pair. Users who were disabled or have to reset their password
+// cannot refresh their session either.
 func (mw proxingMiddleware) Refresh(ctx context.Context, accessUUID, refreshUUID string, userID uint64) (map[string]string, error) {
-	response, err := mw.isUserExists(ctx, userendpoint.IsExistsRequest{ID: userID})
+	profile, err := mw.users.Profile(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
-
-	resp := response.(userendpoint.IsExistsResponse)
-	if resp.Err != nil {
-		return nil, resp.Err
+	if profile.Disabled {
+		return nil, usersvc.ErrUserDisabled
+	}
+	if profile.PasswordResetRequired {
+		return nil, usersvc.ErrPasswordResetRequired
 	}
 
+	ctx = context.WithValue(ctx, authsvc.RolesContextKey, []string(profile.Roles))
+
 	return mw.next.Refresh(ctx, accessUUID, refreshUUID, userID)
 }
 
 func (mw proxingMiddleware) Validate(ctx context.Context, accessUUID string) (bool, error) {
 	return mw.next.Validate(ctx, accessUUID)
 }
+
+func (mw proxingMiddleware) Sessions(ctx context.Context, userID uint64) ([]authsvc.Session, error) {
+	return mw.next.Sessions(ctx, userID)
+}
+
+func (mw proxingMiddleware) RevokeSession(ctx context.Context, accessUUID string, userID uint64, sessionID string) (bool, error) {
+	return mw.next.RevokeSession(ctx, accessUUID, userID, sessionID)
+}
+
+func (mw proxingMiddleware) RevokeAllSessions(ctx context.Context, accessUUID string, userID uint64) (int, error) {
+	return mw.next.RevokeAllSessions(ctx, accessUUID, userID)
+}
+
+func (mw proxingMiddleware) RevokeUserSessions(ctx context.Context, accessUUID string, userID uint64) (int, error) {
+	return mw.next.RevokeUserSessions(ctx, accessUUID, userID)
+}
+
+func (mw proxingMiddleware) Unlock(ctx context.Context, accessUUID, username, ip string) (bool, error) {
+	return mw.next.Unlock(ctx, accessUUID, username, ip)
+}
+
+// VerifyMFA counts the wrong codes as failed logins, so that the lockout
+// also bounds the guesses of whoever knows the password and can ask for
+// new challenges.
+func (mw proxingMiddleware) VerifyMFA(ctx context.Context, mfaUUID string, userID uint64, code string) (map[string]string, error) {
+	profile, err := mw.users.Profile(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ip, _ := ctx.Value(authsvc.ClientIPContextKey).(string)
+	if err := mw.throttler.Allow(profile.Name, ip); err != nil {
+		return nil, err
+	}
+
+	verify := func(code string) (bool, error) {
+		return mw.users.VerifyTOTP(ctx, userID, code)
+	}
+
+	ctx = context.WithValue(ctx, authsvc.MFAVerifierContextKey, verify)
+	ctx = context.WithValue(ctx, authsvc.RolesContextKey, []string(profile.Roles))
+
+	tokens, err := mw.next.VerifyMFA(ctx, mfaUUID, userID, code)
+	if err == authsvc.ErrInvalidMFACode {
+		if err := mw.throttler.Fail(profile.Name, ip); err != nil {
+			return nil, err
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := mw.throttler.Succeed(profile.Name); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (mw proxingMiddleware) EnrollTOTP(ctx context.Context, accessUUID string, userID uint64) (usersvc.TOTPKey, error) {
+	if _, err := mw.next.EnrollTOTP(ctx, accessUUID, userID); err != nil {
+		return usersvc.TOTPKey{}, err
+	}
+	return mw.users.EnrollTOTP(ctx, userID)
+}
+
+func (mw proxingMiddleware) ConfirmTOTP(ctx context.Context, accessUUID string, userID uint64, code string) ([]string, error) {
+	if _, err := mw.next.ConfirmTOTP(ctx, accessUUID, userID, code); err != nil {
+		return nil, err
+	}
+	return mw.users.ConfirmTOTP(ctx, userID, code)
+}
+
+func (mw proxingMiddleware) DisableTOTP(ctx context.Context, accessUUID string, userID uint64, code string) (bool, error) {
+	if _, err := mw.next.DisableTOTP(ctx, accessUUID, userID, code); err != nil {
+		return false, err
+	}
+	return mw.users.DisableTOTP(ctx, userID, code)
+}
+
+// ChangePassword is throttled like Login, as it checks the old password.
+func (mw proxingMiddleware) ChangePassword(ctx context.Context, username, oldPassword, newPassword string) (bool, error) {
+	if _, err := mw.next.ChangePassword(ctx, username, oldPassword, newPassword); err != nil {
+		return false, err
+	}
+
+	ip, _ := ctx.Value(authsvc.ClientIPContextKey).(string)
+	if err := mw.throttler.Allow(username, ip); err != nil {
+		return false, err
+	}
+
+	success, err := mw.users.ChangePassword(ctx, username, oldPassword, newPassword)
+	if err == usersvc.ErrUserNotFound {
+		if err := mw.throttler.Fail(username, ip); err != nil {
+			return false, err
+		}
+	}
+	return success, err
+}
+
+func (mw proxingMiddleware) JWKS(ctx context.Context) (jwks.KeySet, error) {
+	return mw.next.JWKS(ctx)
+}
+
+func (mw proxingMiddleware) RegisterClient(ctx context.Context, accessUUID string, userID uint64, c oauth.Client) (oauth.Client, error) {
+	return mw.next.RegisterClient(ctx, accessUUID, userID, c)
+}
+
+func (mw proxingMiddleware) Client(ctx context.Context, clientID string) (oauth.Client, error) {
+	return mw.next.Client(ctx, clientID)
+}
+
+// Authorize reads the roles, which are embedded in the tokens issued for
+// the code. Disabled users cannot authorize clients.
+func (mw proxingMiddleware) Authorize(ctx context.Context, accessUUID string, userID uint64, req oauth.AuthorizeRequest) (string, error) {
+	profile, err := mw.users.Profile(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if profile.Disabled {
+		return "", usersvc.ErrUserDisabled
+	}
+
+	ctx = context.WithValue(ctx, authsvc.RolesContextKey, []string(profile.Roles))
+
+	return mw.next.Authorize(ctx, accessUUID, userID, req)
+}
+
+// Token checks the user like Refresh for the refresh token grant, the
+// authorization code grant uses the roles read by Authorize.
+func (mw proxingMiddleware) Token(ctx context.Context, req oauth.TokenRequest) (oauth.Token, error) {
+	if req.GrantType == oauth.GrantRefreshToken && req.UserID != 0 {
+		profile, err := mw.users.Profile(ctx, req.UserID)
+		if err != nil {
+			return oauth.Token{}, err
+		}
+		if profile.Disabled || profile.PasswordResetRequired {
+			return oauth.Token{}, oauth.Errorf(oauth.ErrorInvalidGrant, "user cannot be signed in")
+		}
+
+		ctx = context.WithValue(ctx, authsvc.RolesContextKey, []string(profile.Roles))
+	}
+
+	return mw.next.Token(ctx, req)
+}
+
+func (mw proxingMiddleware) Consents(ctx context.Context, userID uint64) ([]oauth.Consent, error) {
+	return mw.next.Consents(ctx, userID)
+}
+
+func (mw proxingMiddleware) RevokeConsent(ctx context.Context, accessUUID string, userID uint64, clientID string) (bool, error) {
+	return mw.next.RevokeConsent(ctx, accessUUID, userID, clientID)
+}
+
+// Introspect reports the tokens of users who were deleted or disabled as
+// inactive, as they are refused everywhere else too. Like ResolvePAT, the
+// personal access tokens of users who have to reset their password are
+// inactive as well.
+func (mw proxingMiddleware) Introspect(ctx context.Context, req oauth.IntrospectionRequest) (oauth.Introspection, error) {
+	i, err := mw.next.Introspect(ctx, req)
+	if err != nil || !i.Active {
+		return i, err
+	}
+
+	userID, err := strconv.ParseUint(i.Sub, 10, 64)
+	if err != nil {
+		return oauth.Introspection{}, err
+	}
+
+	profile, err := mw.users.Profile(ctx, userID)
+	if err == usersvc.ErrUserNotFound {
+		return oauth.Introspection{}, nil
+	}
+	if err != nil {
+		return oauth.Introspection{}, err
+	}
+	if profile.Disabled {
+		return oauth.Introspection{}, nil
+	}
+	if pat.IsToken(req.Token) && profile.PasswordResetRequired {
+		return oauth.Introspection{}, nil
+	}
+	return i, nil
+}
+
+func (mw proxingMiddleware) RevokeToken(ctx context.Context, req oauth.IntrospectionRequest) error {
+	return mw.next.RevokeToken(ctx, req)
+}
+
+func (mw proxingMiddleware) OIDCLogin(ctx context.Context, provider string) (string, string, error) {
+	return mw.next.OIDCLogin(ctx, provider)
+}
+
+// OIDCCallback passes the linker of the verified identity to usersvc, which
+// returns the linked or newly provisioned user. Disabled users cannot sign
+// in, while a forced password reset is not enforced, as such users log in
+// without a password.
+func (mw proxingMiddleware) OIDCCallback(ctx context.Context, provider, code, state, binding string) (map[string]string, error) {
+	link := func(issuer, subject, email string) (authsvc.Account, error) {
+		profile, err := mw.users.LinkIdentity(ctx, issuer, subject, email)
+		if err != nil {
+			return authsvc.Account{}, err
+		}
+		if profile.Disabled {
+			return authsvc.Account{}, usersvc.ErrUserDisabled
+		}
+
+		return authsvc.Account{
+			UserID:      profile.ID,
+			Roles:       []string(profile.Roles),
+			MFARequired: profile.TOTPEnabled,
+		}, nil
+	}
+	ctx = context.WithValue(ctx, authsvc.IdentityLinkerContextKey, link)
+
+	return mw.next.OIDCCallback(ctx, provider, code, state, binding)
+}
+
+func (mw proxingMiddleware) CreatePAT(ctx context.Context, accessUUID string, userID uint64, name string, scopes []string, ttl time.Duration) (pat.Token, error) {
+	return mw.next.CreatePAT(ctx, accessUUID, userID, name, scopes, ttl)
+}
+
+func (mw proxingMiddleware) PATs(ctx context.Context, userID uint64) ([]pat.Token, error) {
+	return mw.next.PATs(ctx, userID)
+}
+
+func (mw proxingMiddleware) RevokePAT(ctx context.Context, accessUUID string, userID uint64, id string) (bool, error) {
+	return mw.next.RevokePAT(ctx, accessUUID, userID, id)
+}
+
+// ResolvePAT reads the roles of the owner on every use, so that role
+// changes take effect right away. Like Refresh, the tokens of users who
+// were disabled or have to reset their password are refused.
+func (mw proxingMiddleware) ResolvePAT(ctx context.Context, secret string) (pat.Token, error) {
+	t, err := mw.next.ResolvePAT(ctx, secret)
+	if err != nil {
+		return pat.Token{}, err
+	}
+
+	profile, err := mw.users.Profile(ctx, t.UserID)
+	if err != nil {
+		return pat.Token{}, err
+	}
+	if profile.Disabled {
+		return pat.Token{}, usersvc.ErrUserDisabled
+	}
+	if profile.PasswordResetRequired {
+		return pat.Token{}, usersvc.ErrPasswordResetRequired
+	}
+
+	t.Roles = []string(profile.Roles)
+	return t, nil
+}
+
+func (mw proxingMiddleware) Revocations(ctx context.Context, since uint64, wait time.Duration) ([]authsvc.Revocation, uint64, error) {
+	return mw.next.Revocations(ctx, since, wait)
+}
@@ -0,0 +1,431 @@
+package authservice
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+	stduuid "github.com/twinj/uuid"
+)
+
+// authCodeTTL is the lifetime of an authorization code, which is exchanged
+// by the client right after the redirect.
+const authCodeTTL = time.Minute
+
+// authCode is stored under the code until it expires. The code is kept
+// after it was exchanged, so that a second exchange is detected.
+type authCode struct {
+	ClientID    string    `json:"client_id"`
+	UserID      uint64    `json:"user_id"`
+	RedirectURI string    `json:"redirect_uri"`
+	Scopes      []string  `json:"scopes"`
+	Challenge   string    `json:"challenge"`
+	Roles       []string  `json:"roles"`
+	Family      string    `json:"family"`
+	Used        bool      `json:"used"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// RegisterClient registers a public client owned by the user. The client
+// is granted every supported scope unless it asks for fewer.
+func (s *basicService) RegisterClient(ctx context.Context, accessUUID string, userID uint64, c oauth.Client) (oauth.Client, error) {
+	if userID == 0 || c.Name == "" || len(c.RedirectURIs) == 0 {
+		return oauth.Client{}, authsvc.ErrInvalidArgument
+	}
+	for _, uri := range c.RedirectURIs {
+		if !oauth.ValidRedirectURI(uri) {
+			return oauth.Client{}, authsvc.ErrInvalidArgument
+		}
+	}
+	if len(c.Scopes) == 0 {
+		c.Scopes = oauth.SupportedScopes
+	}
+	if !oauth.SupportedScopes.Contains(c.Scopes) {
+		return oauth.Client{}, authsvc.ErrInvalidArgument
+	}
+
+	if _, err := s.validate(ctx, accessUUID); err != nil {
+		return oauth.Client{}, err
+	}
+
+	c.ID = stduuid.NewV4().String()
+	c.OwnerID = userID
+	c.CreatedAt = time.Now().UTC()
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return oauth.Client{}, err
+	}
+	if err := s.client.Put(clientKey(c.ID), b); err != nil {
+		return oauth.Client{}, err
+	}
+	return c, nil
+}
+
+// Client is public, as the consent screen shows the name of the client
+// before the user decided.
+func (s *basicService) Client(_ context.Context, clientID string) (oauth.Client, error) {
+	if clientID == "" {
+		return oauth.Client{}, authsvc.ErrInvalidArgument
+	}
+	return s.oauthClient(clientID)
+}
+
+// Authorize is called on behalf of the signed-in user once the scopes were
+// consented to. It records the consent and returns the redirect URI of the
+// client with the authorization code. Errors are not redirected to the
+// client, the consent screen shows them instead.
+func (s *basicService) Authorize(ctx context.Context, accessUUID string, userID uint64, req oauth.AuthorizeRequest) (string, error) {
+	if userID == 0 || req.ClientID == "" {
+		return "", authsvc.ErrInvalidArgument
+	}
+
+	if _, err := s.validate(ctx, accessUUID); err != nil {
+		return "", err
+	}
+
+	c, err := s.oauthClient(req.ClientID)
+	if err != nil {
+		return "", err
+	}
+	if !contains(c.RedirectURIs, req.RedirectURI) {
+		return "", oauth.Errorf(oauth.ErrorInvalidRequest, "redirect_uri is not registered")
+	}
+	if req.ResponseType != oauth.ResponseTypeCode {
+		return "", oauth.Errorf(oauth.ErrorUnsupportedResponse, "only the code response type is supported")
+	}
+	if req.CodeChallengeMethod != oauth.MethodS256 || len(req.CodeChallenge) != 43 {
+		return "", oauth.Errorf(oauth.ErrorInvalidRequest, "an S256 code_challenge is required")
+	}
+
+	scopes := oauth.ParseScopes(req.Scope)
+	if len(scopes) == 0 {
+		scopes = c.Scopes
+	}
+	if !c.Scopes.Contains(scopes) {
+		return "", oauth.Errorf(oauth.ErrorInvalidScope, "scope exceeds the scopes of the client")
+	}
+
+	if err := s.consent(userID, c, scopes); err != nil {
+		return "", err
+	}
+
+	code, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+
+	roles, _ := ctx.Value(authsvc.RolesContextKey).([]string)
+	ac := authCode{
+		ClientID:    c.ID,
+		UserID:      userID,
+		RedirectURI: req.RedirectURI,
+		Scopes:      scopes,
+		Challenge:   req.CodeChallenge,
+		Roles:       roles,
+		Family:      newFamily(),
+		ExpiresAt:   time.Now().Add(authCodeTTL).UTC(),
+	}
+	if err := s.putAuthCode(code, ac); err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Set("code", code)
+	if req.State != "" {
+		q.Set("state", req.State)
+	}
+	u.RawQuery = q.Encode()
+
+	return u.String(), nil
+}
+
+// Token exchanges an authorization code or a refresh token of a client for
+// a new token pair. Failures of the grant are reported as oauth.Error.
+func (s *basicService) Token(ctx context.Context, req oauth.TokenRequest) (oauth.Token, error) {
+	if req.ClientID == "" {
+		return oauth.Token{}, oauth.Errorf(oauth.ErrorInvalidRequest, "client_id is required")
+	}
+
+	c, err := s.oauthClient(req.ClientID)
+	if err == authsvc.ErrClientNotFound {
+		return oauth.Token{}, oauth.Errorf(oauth.ErrorInvalidClient, "unknown client")
+	}
+	if err != nil {
+		return oauth.Token{}, err
+	}
+
+	switch req.GrantType {
+	case oauth.GrantAuthorizationCode:
+		return s.exchangeCode(ctx, c, req)
+	case oauth.GrantRefreshToken:
+		return s.exchangeRefreshToken(ctx, c, req)
+	}
+	return oauth.Token{}, oauth.Errorf(oauth.ErrorUnsupportedGrantType, "unsupported grant_type %q", req.GrantType)
+}
+
+func (s *basicService) Consents(_ context.Context, userID uint64) ([]oauth.Consent, error) {
+	if userID == 0 {
+		return nil, authsvc.ErrInvalidArgument
+	}
+
+	values, err := s.client.List(consentKey(userID, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	consents := make([]oauth.Consent, 0, len(values))
+	for _, v := range values {
+		var consent oauth.Consent
+		if err := json.Unmarshal(v, &consent); err != nil {
+			return nil, err
+		}
+		consents = append(consents, consent)
+	}
+	return consents, nil
+}
+
+// RevokeConsent withdraws the access of the client and signs out all of
+// its sessions of the user.
+func (s *basicService) RevokeConsent(ctx context.Context, accessUUID string, userID uint64, clientID string) (bool, error) {
+	if userID == 0 || clientID == "" {
+		return false, authsvc.ErrInvalidArgument
+	}
+
+	if _, err := s.validate(ctx, accessUUID); err != nil {
+		return false, err
+	}
+
+	_, err := s.client.Get(consentKey(userID, clientID))
+	if err == inmem.ErrKeyNotFound {
+		return false, authsvc.ErrConsentNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.client.Delete(consentKey(userID, clientID)); err != nil {
+		return false, err
+	}
+
+	sessions, err := s.Sessions(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, session := range sessions {
+		if session.ClientID != clientID {
+			continue
+		}
+		if err := s.revoke(userID, session.AccessUUID); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// exchangeCode redeems the authorization code. The code is bound to the
+// client, the redirect URI and the code challenge of the authorization
+// request.
+func (s *basicService) exchangeCode(ctx context.Context, c oauth.Client, req oauth.TokenRequest) (oauth.Token, error) {
+	if req.Code == "" || req.RedirectURI == "" || req.CodeVerifier == "" {
+		return oauth.Token{}, oauth.Errorf(oauth.ErrorInvalidRequest, "code, redirect_uri and code_verifier are required")
+	}
+
+	value, err := s.client.Get(authCodeKey(req.Code))
+	if err == inmem.ErrKeyNotFound {
+		return oauth.Token{}, oauth.Errorf(oauth.ErrorInvalidGrant, "code is invalid or expired")
+	}
+	if err != nil {
+		return oauth.Token{}, err
+	}
+
+	var ac authCode
+	if err := json.Unmarshal(value, &ac); err != nil {
+		return oauth.Token{}, err
+	}
+
+	// The code is checked before it is marked as used, so that it cannot
+	// be spent, nor its tokens revoked, by a client which merely got hold
+	// of it.
+	if ac.ClientID != c.ID || ac.RedirectURI != req.RedirectURI {
+		return oauth.Token{}, oauth.Errorf(oauth.ErrorInvalidGrant, "code was issued to another client or redirect_uri")
+	}
+	if !oauth.ValidVerifier(req.CodeVerifier) || !oauth.VerifyChallenge(ac.Challenge, req.CodeVerifier) {
+		return oauth.Token{}, oauth.Errorf(oauth.ErrorInvalidGrant, "code_verifier does not match the code_challenge")
+	}
+
+	// The code is marked only if it is unchanged since it was read, so
+	// that of two concurrent exchanges only one succeeds.
+	swapped := false
+	if !ac.Used {
+		ttl := time.Until(ac.ExpiresAt)
+		if ttl <= 0 {
+			return oauth.Token{}, oauth.Errorf(oauth.ErrorInvalidGrant, "code is invalid or expired")
+		}
+
+		ac.Used = true
+		b, err := json.Marshal(ac)
+		if err != nil {
+			return oauth.Token{}, err
+		}
+		if swapped, err = s.client.CompareAndSwap(authCodeKey(req.Code), value, b, ttl); err != nil {
+			return oauth.Token{}, err
+		}
+	}
+
+	// RFC 6749 asks to revoke the tokens issued for a code which is
+	// presented twice, as it might have been intercepted.
+	if !swapped {
+		if err := s.revokeFamily(ac.Family); err != nil {
+			return oauth.Token{}, err
+		}
+		return oauth.Token{}, oauth.Errorf(oauth.ErrorInvalidGrant, "code was already used")
+	}
+
+	// The consent may have been withdrawn since the code was issued.
+	if _, err := s.client.Get(consentKey(ac.UserID, c.ID)); err != nil {
+		if err == inmem.ErrKeyNotFound {
+			return oauth.Token{}, oauth.Errorf(oauth.ErrorInvalidGrant, "consent was revoked")
+		}
+		return oauth.Token{}, err
+	}
+
+	prev := authsvc.Session{ClientID: c.ID, Scopes: ac.Scopes}
+	return s.issue(ctx, ac.UserID, ac.Roles, ac.Family, prev)
+}
+
+// exchangeRefreshToken rotates the refresh token like Refresh, keeping the
+// scopes granted with the code.
+func (s *basicService) exchangeRefreshToken(ctx context.Context, c oauth.Client, req oauth.TokenRequest) (oauth.Token, error) {
+	if req.AccessUUID == "" || req.RefreshUUID == "" || req.UserID == 0 {
+		return oauth.Token{}, oauth.Errorf(oauth.ErrorInvalidGrant, "refresh_token is invalid")
+	}
+
+	rs, prev, err := s.rotate(req.AccessUUID, req.RefreshUUID, req.UserID, c.ID)
+	switch err {
+	case nil:
+	case inmem.ErrKeyNotFound, authsvc.ErrClaimsInvalid:
+		return oauth.Token{}, oauth.Errorf(oauth.ErrorInvalidGrant, "refresh_token is invalid or revoked")
+	case authsvc.ErrRefreshTokenReused:
+		return oauth.Token{}, oauth.Errorf(oauth.ErrorInvalidGrant, "refresh_token was already used")
+	default:
+		return oauth.Token{}, err
+	}
+
+	roles, _ := ctx.Value(authsvc.RolesContextKey).([]string)
+	return s.issue(ctx, req.UserID, roles, rs.Family, prev)
+}
+
+func (s *basicService) issue(ctx context.Context, userID uint64, roles []string, family string, prev authsvc.Session) (oauth.Token, error) {
+	at, rt, err := s.tokenizer.GenerateScoped(userID, roles, prev.ClientID, prev.Scopes)
+	if err != nil {
+		return oauth.Token{}, err
+	}
+
+	if err := s.storeTokens(ctx, userID, family, at, rt, prev); err != nil {
+		return oauth.Token{}, err
+	}
+
+	return oauth.Token{
+		AccessToken:  at.Hash,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(at.Expires).Seconds()),
+		RefreshToken: rt.Hash,
+		Scope:        oauth.Scopes(prev.Scopes).String(),
+	}, nil
+}
+
+// consent adds the scopes to the ones the user already granted the client.
+func (s *basicService) consent(userID uint64, c oauth.Client, scopes oauth.Scopes) error {
+	consent := oauth.Consent{ClientID: c.ID, ClientName: c.Name}
+
+	value, err := s.client.Get(consentKey(userID, c.ID))
+	switch err {
+	case nil:
+		if err := json.Unmarshal(value, &consent); err != nil {
+			return err
+		}
+	case inmem.ErrKeyNotFound:
+	default:
+		return err
+	}
+
+	consent.Scopes = consent.Scopes.Union(scopes)
+	consent.GrantedAt = time.Now().UTC()
+
+	b, err := json.Marshal(consent)
+	if err != nil {
+		return err
+	}
+	return s.client.Put(consentKey(userID, c.ID), b)
+}
+
+func (s *basicService) oauthClient(clientID string) (oauth.Client, error) {
+	var c oauth.Client
+
+	value, err := s.client.Get(clientKey(clientID))
+	if err == inmem.ErrKeyNotFound {
+		return c, authsvc.ErrClientNotFound
+	}
+	if err != nil {
+		return c, err
+	}
+
+	err = json.Unmarshal(value, &c)
+	return c, err
+}
+
+func (s *basicService) putAuthCode(code string, ac authCode) error {
+	ttl := time.Until(ac.ExpiresAt)
+	if ttl <= 0 {
+		return s.client.Delete(authCodeKey(code))
+	}
+
+	b, err := json.Marshal(ac)
+	if err != nil {
+		return err
+	}
+	return s.client.PutTTL(authCodeKey(code), b, ttl)
+}
+
+// randomToken returns 256 random bits, which cannot be guessed within the
+// lifetime of an authorization code or a login state.
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+func clientKey(clientID string) string {
+	return "oauth/clients/" + clientID
+}
+
+func consentKey(userID uint64, clientID string) string {
+	return fmt.Sprintf("oauth/consents/%d/%s", userID, clientID)
+}
+
+func authCodeKey(code string) string {
+	return "oauth/codes/" + code
+}
@@ -0,0 +1,258 @@
+package authservice_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/authz"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authservice"
+)
+
+const (
+	redirectURI = "https://client.example/callback"
+	// verifier is a code verifier of the minimum length of RFC 7636.
+	verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+// claimsOf reads the claims of a token issued by the service, which are
+// verified by the transport before they reach it.
+func claimsOf(t *testing.T, token string) jwt.MapClaims {
+	t.Helper()
+
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+// login signs the user in and returns the tokens of the new session.
+func login(t *testing.T, svc authservice.Service, userID uint64) map[string]string {
+	t.Helper()
+
+	ctx := context.WithValue(context.Background(), authsvc.UserIDContextKey, userID)
+	tokens, err := svc.Login(ctx, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func accessUUID(t *testing.T, tokens map[string]string) string {
+	t.Helper()
+
+	return claimsOf(t, tokens["access"])["uuid"].(string)
+}
+
+// registerClient signs the owner in and registers a client allowed the
+// given scopes. It returns the access UUID of the owner, who then
+// authorizes the client.
+func registerClient(t *testing.T, svc authservice.Service, scopes oauth.Scopes) (oauth.Client, string) {
+	t.Helper()
+
+	owner := accessUUID(t, login(t, svc, 1))
+	c, err := svc.RegisterClient(context.Background(), owner, 1, oauth.Client{
+		Name:         "client",
+		RedirectURIs: []string{redirectURI},
+		Scopes:       scopes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, owner
+}
+
+func authorizeRequest(c oauth.Client, scope string) oauth.AuthorizeRequest {
+	return oauth.AuthorizeRequest{
+		ResponseType:        oauth.ResponseTypeCode,
+		ClientID:            c.ID,
+		RedirectURI:         redirectURI,
+		Scope:               scope,
+		State:               "state",
+		CodeChallenge:       oauth.Challenge(verifier),
+		CodeChallengeMethod: oauth.MethodS256,
+	}
+}
+
+// authorize returns the code the client is redirected back with.
+func authorize(t *testing.T, svc authservice.Service, owner string, req oauth.AuthorizeRequest) string {
+	t.Helper()
+
+	u, err := svc.Authorize(context.Background(), owner, 1, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := redirect.Query().Get("state"); got != req.State {
+		t.Errorf("want the state %q passed back, got %q", req.State, got)
+	}
+	return redirect.Query().Get("code")
+}
+
+func codeRequest(c oauth.Client, code string) oauth.TokenRequest {
+	return oauth.TokenRequest{
+		GrantType:    oauth.GrantAuthorizationCode,
+		Code:         code,
+		RedirectURI:  redirectURI,
+		ClientID:     c.ID,
+		CodeVerifier: verifier,
+	}
+}
+
+func errorCode(err error) string {
+	var e oauth.Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ""
+}
+
+// TestAuthorizeRequiresS256 checks that the authorization request is only
+// accepted with an S256 challenge and a registered redirect URI, matched
+// exactly.
+func TestAuthorizeRequiresS256(t *testing.T) {
+	t.Parallel()
+
+	svc := authservice.NewBasicService(newTokenizer(t), nil, inmem.NewMemoryClient(), nil)
+	c, owner := registerClient(t, svc, nil)
+
+	for name, change := range map[string]func(*oauth.AuthorizeRequest){
+		"plain method":      func(r *oauth.AuthorizeRequest) { r.CodeChallengeMethod = "plain"; r.CodeChallenge = verifier },
+		"no challenge":      func(r *oauth.AuthorizeRequest) { r.CodeChallenge, r.CodeChallengeMethod = "", "" },
+		"trailing slash":    func(r *oauth.AuthorizeRequest) { r.RedirectURI += "/" },
+		"added query":       func(r *oauth.AuthorizeRequest) { r.RedirectURI += "?next=/" },
+		"other host":        func(r *oauth.AuthorizeRequest) { r.RedirectURI = "https://attacker.example/callback" },
+		"other letter case": func(r *oauth.AuthorizeRequest) { r.RedirectURI = strings.ToUpper(r.RedirectURI) },
+	} {
+		req := authorizeRequest(c, "")
+		change(&req)
+		if _, err := svc.Authorize(context.Background(), owner, 1, req); errorCode(err) != oauth.ErrorInvalidRequest {
+			t.Errorf("%s: want %s, got %v", name, oauth.ErrorInvalidRequest, err)
+		}
+	}
+}
+
+// TestExchangeCodeBindsVerifierAndRedirectURI checks that a code is only
+// redeemed with the verifier of its challenge and its redirect URI, and
+// that failed attempts do not use it up.
+func TestExchangeCodeBindsVerifierAndRedirectURI(t *testing.T) {
+	t.Parallel()
+
+	svc := authservice.NewBasicService(newTokenizer(t), nil, inmem.NewMemoryClient(), nil)
+	c, owner := registerClient(t, svc, nil)
+	code := authorize(t, svc, owner, authorizeRequest(c, ""))
+
+	for name, change := range map[string]func(*oauth.TokenRequest){
+		"wrong verifier":        func(r *oauth.TokenRequest) { r.CodeVerifier = strings.Repeat("a", 43) },
+		"challenge as verifier": func(r *oauth.TokenRequest) { r.CodeVerifier = oauth.Challenge(verifier) },
+		"other redirect_uri":    func(r *oauth.TokenRequest) { r.RedirectURI = redirectURI + "/" },
+	} {
+		req := codeRequest(c, code)
+		change(&req)
+		if _, err := svc.Token(context.Background(), req); errorCode(err) != oauth.ErrorInvalidGrant {
+			t.Errorf("%s: want %s, got %v", name, oauth.ErrorInvalidGrant, err)
+		}
+	}
+
+	if _, err := svc.Token(context.Background(), codeRequest(c, code)); err != nil {
+		t.Errorf("want the code redeemed after the failed attempts, got %v", err)
+	}
+}
+
+// TestAuthorizeNarrowsScopes checks that the tokens only grant the scopes
+// requested, which cannot exceed the scopes of the client.
+func TestAuthorizeNarrowsScopes(t *testing.T) {
+	t.Parallel()
+
+	var (
+		read  = string(authz.ReadTasks)
+		write = string(authz.WriteTasks)
+	)
+
+	svc := authservice.NewBasicService(newTokenizer(t), nil, inmem.NewMemoryClient(), nil)
+	c, owner := registerClient(t, svc, oauth.Scopes{read})
+
+	if _, err := svc.Authorize(context.Background(), owner, 1, authorizeRequest(c, read+" "+write)); errorCode(err) != oauth.ErrorInvalidScope {
+		t.Errorf("scope beyond the client: want %s, got %v", oauth.ErrorInvalidScope, err)
+	}
+
+	c, owner = registerClient(t, svc, nil)
+	code := authorize(t, svc, owner, authorizeRequest(c, read))
+	token, err := svc.Token(context.Background(), codeRequest(c, code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Scope != read {
+		t.Errorf("want the token scoped to %q, got %q", read, token.Scope)
+	}
+	if scope := claimsOf(t, token.AccessToken)["scope"]; scope != read {
+		t.Errorf("want the access token scoped to %q, got %v", read, scope)
+	}
+
+	// The refreshed pair keeps the scopes granted with the code.
+	rc := claimsOf(t, token.RefreshToken)
+	refreshed, err := svc.Token(context.Background(), oauth.TokenRequest{
+		GrantType:   oauth.GrantRefreshToken,
+		ClientID:    c.ID,
+		AccessUUID:  rc["access_uuid"].(string),
+		RefreshUUID: rc["refresh_uuid"].(string),
+		UserID:      1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refreshed.Scope != read {
+		t.Errorf("want the refreshed token scoped to %q, got %q", read, refreshed.Scope)
+	}
+}
+
+// TestReplayedCodeRevokesTokens checks that a code presented twice signs
+// out the session it started, including the pairs refreshed since.
+func TestReplayedCodeRevokesTokens(t *testing.T) {
+	t.Parallel()
+
+	svc := authservice.NewBasicService(newTokenizer(t), nil, inmem.NewMemoryClient(), nil)
+	c, owner := registerClient(t, svc, nil)
+	code := authorize(t, svc, owner, authorizeRequest(c, ""))
+	ctx := context.Background()
+
+	token, err := svc.Token(ctx, codeRequest(c, code))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := claimsOf(t, token.RefreshToken)
+	refreshed, err := svc.Token(ctx, oauth.TokenRequest{
+		GrantType:   oauth.GrantRefreshToken,
+		ClientID:    c.ID,
+		AccessUUID:  rc["access_uuid"].(string),
+		RefreshUUID: rc["refresh_uuid"].(string),
+		UserID:      1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := claimsOf(t, refreshed.AccessToken)["uuid"].(string)
+	if _, err := svc.Validate(ctx, current); err != nil {
+		t.Fatalf("want the refreshed session valid, got %v", err)
+	}
+
+	if _, err := svc.Token(ctx, codeRequest(c, code)); errorCode(err) != oauth.ErrorInvalidGrant {
+		t.Errorf("replayed code: want %s, got %v", oauth.ErrorInvalidGrant, err)
+	}
+	if _, err := svc.Validate(ctx, current); err != inmem.ErrKeyNotFound {
+		t.Errorf("want the session of the code revoked, got %v", err)
+	}
+	if _, err := svc.Validate(ctx, owner); err != nil {
+		t.Errorf("want the session of the owner left valid, got %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package authservice
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+)
+
+// OIDCStateTTL is how long the user has to sign in at the identity
+// provider, and so how long the binding has to be kept by the user agent.
+const OIDCStateTTL = 10 * time.Minute
+
+// oidcState is stored under the state parameter of the authorization
+// request until the user returns from the identity provider. Only the hash
+// of the binding is stored, like for the other secrets.
+type oidcState struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Binding  []byte `json:"binding"`
+}
+
+// OIDCLogin starts a login through the identity provider and returns the
+// URL the user is sent to, along with the binding the user agent has to
+// present on the callback. The binding is kept by the user agent only, so
+// that a state handed to another user agent cannot complete the login
+// there, which would sign it in to the account of the attacker.
+func (s *basicService) OIDCLogin(ctx context.Context, provider string) (string, string, error) {
+	p, ok := s.providers[provider]
+	if !ok {
+		return "", "", authsvc.ErrProviderNotFound
+	}
+
+	state, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	binding, err := randomToken()
+	if err != nil {
+		return "", "", err
+	}
+	st := oidcState{Provider: provider, Binding: hashBinding(binding)}
+	if st.Nonce, err = randomToken(); err != nil {
+		return "", "", err
+	}
+	// The verifier binds the code to this login, like PKCE does for the
+	// clients of authsvc.
+	if st.Verifier, err = randomToken(); err != nil {
+		return "", "", err
+	}
+
+	u, err := p.AuthCodeURL(ctx, state, st.Nonce, st.Verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	b, err := json.Marshal(st)
+	if err != nil {
+		return "", "", err
+	}
+	if err := s.client.PutTTL(oidcStateKey(state), b, OIDCStateTTL); err != nil {
+		return "", "", err
+	}
+
+	return u, binding, nil
+}
+
+// OIDCCallback completes the login with the code the identity provider
+// redirected the user back with. The identity is linked to a user by the
+// linker passed through the context, which is only called once the ID
+// token was verified. Like Login, the token pair is only issued once the
+// MFA challenge of the user is answered. The binding returned by OIDCLogin
+// has to match, so that the state is only accepted from the user agent
+// which started the login.
+func (s *basicService) OIDCCallback(ctx context.Context, provider, code, state, binding string) (map[string]string, error) {
+	if provider == "" || code == "" || state == "" {
+		return nil, authsvc.ErrInvalidArgument
+	}
+
+	p, ok := s.providers[provider]
+	if !ok {
+		return nil, authsvc.ErrProviderNotFound
+	}
+
+	link, ok := ctx.Value(authsvc.IdentityLinkerContextKey).(func(issuer, subject, email string) (authsvc.Account, error))
+	if !ok {
+		return nil, authsvc.ErrLinkerContextMissing
+	}
+
+	value, err := s.client.Get(oidcStateKey(state))
+	if err == inmem.ErrKeyNotFound {
+		return nil, authsvc.ErrInvalidLoginState
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The state is used up right away, so that a callback cannot be
+	// replayed even if the exchange fails.
+	if err := s.client.Delete(oidcStateKey(state)); err != nil {
+		return nil, err
+	}
+
+	var st oidcState
+	if err := json.Unmarshal(value, &st); err != nil {
+		return nil, err
+	}
+	if st.Provider != provider {
+		return nil, authsvc.ErrInvalidLoginState
+	}
+	if binding == "" || subtle.ConstantTimeCompare(st.Binding, hashBinding(binding)) != 1 {
+		return nil, authsvc.ErrInvalidLoginState
+	}
+
+	identity, err := p.Exchange(ctx, code, st.Verifier, st.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := link(identity.Issuer, identity.Subject, identity.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if account.MFARequired {
+		return s.challenge(account.UserID)
+	}
+
+	at, rt, err := s.tokenizer.Generate(account.UserID, account.Roles)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.storeTokens(ctx, account.UserID, newFamily(), at, rt, authsvc.Session{}); err != nil {
+		return nil, err
+	}
+
+	return s.compileTokens(at, rt), nil
+}
+
+func oidcStateKey(state string) string {
+	return "oidc/states/" + state
+}
+
+func hashBinding(binding string) []byte {
+	sum := sha256.Sum256([]byte(binding))
+	return sum[:]
+}
@@ -0,0 +1,168 @@
+package authservice_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oidc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oidc/oidctest"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authservice"
+)
+
+// startIDP serves the stub provider of stubidp, whose issuer has to be the
+// URL it is reached at.
+func startIDP(t *testing.T, c oidctest.Config) (*httptest.Server, oidc.Providers) {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(nil)
+	c.Issuer = "http://" + srv.Listener.Addr().String()
+	c.ClientID, c.ClientSecret = "gtdkit", "secret"
+	idp, err := oidctest.NewProvider(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Config.Handler = idp
+	srv.Start()
+
+	p := oidc.NewProvider(oidc.Config{
+		Name:         "sso",
+		Issuer:       c.Issuer,
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		RedirectURL:  "https://app.example/oidc/callback",
+	}, srv.Client())
+	return srv, oidc.Providers{"sso": p}
+}
+
+// signIn starts a login and follows the URL to the provider, after letting
+// change the authorization request. It returns the code and state the
+// provider redirected back with, along with the binding of the login.
+func signIn(t *testing.T, svc authservice.Service, srv *httptest.Server, change func(url.Values)) (code, state, binding string) {
+	t.Helper()
+
+	u, binding, err := svc.OIDCLogin(context.Background(), "sso")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if binding == "" {
+		t.Fatal("login returned no binding")
+	}
+
+	authURL, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if change != nil {
+		q := authURL.Query()
+		change(q)
+		authURL.RawQuery = q.Encode()
+	}
+
+	client := srv.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Get(authURL.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	redirect, err := resp.Location()
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := redirect.Query()
+	return q.Get("code"), q.Get("state"), binding
+}
+
+func withLinker(linked *int) context.Context {
+	link := func(issuer, subject, email string) (authsvc.Account, error) {
+		*linked++
+		return authsvc.Account{UserID: 1, Roles: []string{"user"}}, nil
+	}
+	return context.WithValue(context.Background(), authsvc.IdentityLinkerContextKey, link)
+}
+
+// TestOIDCCallbackBindsStateToUserAgent checks that the state is used up by
+// the callback, and only accepted along with the binding of its login.
+func TestOIDCCallbackBindsStateToUserAgent(t *testing.T) {
+	t.Parallel()
+
+	srv, providers := startIDP(t, oidctest.Config{Email: "user@example.com", EmailVerified: true})
+	defer srv.Close()
+	svc := authservice.NewBasicService(newTokenizer(t), nil, inmem.NewMemoryClient(), providers)
+
+	var linked int
+	ctx := withLinker(&linked)
+
+	code, state, binding := signIn(t, svc, srv, nil)
+	tokens, err := svc.OIDCCallback(ctx, "sso", code, state, binding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tokens["access"] == "" || tokens["refresh"] == "" || linked != 1 {
+		t.Errorf("want a token pair for the linked user, got %v linked %d times", tokens, linked)
+	}
+
+	if _, err := svc.OIDCCallback(ctx, "sso", code, state, binding); err != authsvc.ErrInvalidLoginState {
+		t.Errorf("replayed state: want %v, got %v", authsvc.ErrInvalidLoginState, err)
+	}
+
+	// The attacker starts a login and hands its state and code to the
+	// victim, whose user agent holds the binding of another login.
+	code, state, _ = signIn(t, svc, srv, nil)
+	_, _, victim := signIn(t, svc, srv, nil)
+	if _, err := svc.OIDCCallback(ctx, "sso", code, state, victim); err != authsvc.ErrInvalidLoginState {
+		t.Errorf("foreign state: want %v, got %v", authsvc.ErrInvalidLoginState, err)
+	}
+
+	code, state, _ = signIn(t, svc, srv, nil)
+	if _, err := svc.OIDCCallback(ctx, "sso", code, state, ""); err != authsvc.ErrInvalidLoginState {
+		t.Errorf("missing binding: want %v, got %v", authsvc.ErrInvalidLoginState, err)
+	}
+
+	if linked != 1 {
+		t.Errorf("want the refused callbacks not to link, linked %d times", linked)
+	}
+}
+
+// TestOIDCCallbackVerifiesIDToken checks that an ID token with another
+// nonce or an unverified email is refused before the identity is linked.
+func TestOIDCCallbackVerifiesIDToken(t *testing.T) {
+	t.Parallel()
+
+	for name, tc := range map[string]struct {
+		verified bool
+		change   func(url.Values)
+		err      error
+	}{
+		"nonce mismatch": {
+			verified: true,
+			change:   func(q url.Values) { q.Set("nonce", "forged") },
+			err:      oidc.ErrInvalidIDToken,
+		},
+		"email not verified": {
+			verified: false,
+			err:      oidc.ErrEmailNotVerified,
+		},
+	} {
+		srv, providers := startIDP(t, oidctest.Config{Email: "user@example.com", EmailVerified: tc.verified})
+		defer srv.Close()
+		svc := authservice.NewBasicService(newTokenizer(t), nil, inmem.NewMemoryClient(), providers)
+
+		var linked int
+		code, state, binding := signIn(t, svc, srv, tc.change)
+		if _, err := svc.OIDCCallback(withLinker(&linked), "sso", code, state, binding); err != tc.err {
+			t.Errorf("%s: want %v, got %v", name, tc.err, err)
+		}
+		if linked != 0 {
+			t.Errorf("%s: want the identity not linked, linked %d times", name, linked)
+		}
+	}
+}
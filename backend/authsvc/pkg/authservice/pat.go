@@ -0,0 +1,260 @@
+package authservice
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
+	stduuid "github.com/twinj/uuid"
+)
+
+// maxTokenNameLength keeps the names of personal access tokens short
+// enough for the listing.
+const maxTokenNameLength = 100
+
+// patRecord is stored under the hash of the secret, so that the token can
+// be found from the secret alone, and under the per user key, which makes
+// it listable. The hash is kept to delete the first key on revocation.
+type patRecord struct {
+	pat.Token
+	Hash string `json:"hash"`
+}
+
+// CreatePAT issues a personal access token, which is granted every
+// supported scope unless it asks for fewer. A ttl of zero creates a token
+// which is valid until it is revoked.
+func (s *basicService) CreatePAT(ctx context.Context, accessUUID string, userID uint64, name string, scopes []string, ttl time.Duration) (pat.Token, error) {
+	if userID == 0 || name == "" || len(name) > maxTokenNameLength || ttl < 0 {
+		return pat.Token{}, authsvc.ErrInvalidArgument
+	}
+	if len(scopes) == 0 {
+		scopes = oauth.SupportedScopes
+	}
+	if !oauth.SupportedScopes.Contains(scopes) {
+		return pat.Token{}, authsvc.ErrInvalidArgument
+	}
+
+	if _, err := s.validate(ctx, accessUUID); err != nil {
+		return pat.Token{}, err
+	}
+
+	secret, err := pat.Generate()
+	if err != nil {
+		return pat.Token{}, err
+	}
+
+	now := time.Now().UTC()
+	r := patRecord{
+		Token: pat.Token{
+			ID:        stduuid.NewV4().String(),
+			UserID:    userID,
+			Name:      name,
+			Scopes:    scopes,
+			CreatedAt: now,
+		},
+		Hash: pat.Hash(secret),
+	}
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		r.ExpiresAt = &expiresAt
+	}
+
+	if err := s.putPAT(r); err != nil {
+		return pat.Token{}, err
+	}
+
+	t := r.Token
+	t.Secret = secret
+	return t, nil
+}
+
+func (s *basicService) PATs(_ context.Context, userID uint64) ([]pat.Token, error) {
+	if userID == 0 {
+		return nil, authsvc.ErrInvalidArgument
+	}
+
+	values, err := s.client.List(patKey(userID, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	tokens := make([]pat.Token, 0, len(values))
+	for _, v := range values {
+		var r patRecord
+		if err := json.Unmarshal(v, &r); err != nil {
+			return nil, err
+		}
+		if r.Expired(now) {
+			continue
+		}
+		tokens = append(tokens, r.Token)
+	}
+	return tokens, nil
+}
+
+func (s *basicService) RevokePAT(ctx context.Context, accessUUID string, userID uint64, id string) (bool, error) {
+	if userID == 0 || id == "" {
+		return false, authsvc.ErrInvalidArgument
+	}
+
+	if _, err := s.validate(ctx, accessUUID); err != nil {
+		return false, err
+	}
+
+	// The per user key is looked up so that only the own tokens can be
+	// revoked.
+	value, err := s.client.Get(patKey(userID, id))
+	if err == inmem.ErrKeyNotFound {
+		return false, authsvc.ErrTokenNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var r patRecord
+	if err := json.Unmarshal(value, &r); err != nil {
+		return false, err
+	}
+
+	if err := s.deletePAT(r); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ResolvePAT returns the token of the secret, which is called by the other
+// services on every request authenticated with it. Like Validate, it
+// records the use of the token at most once per sessionTouchInterval. The
+// roles are filled in by the proxing middleware.
+func (s *basicService) ResolvePAT(_ context.Context, secret string) (pat.Token, error) {
+	r, value, err := s.readPAT(secret)
+	if err != nil {
+		return pat.Token{}, err
+	}
+
+	if now := time.Now().UTC(); r.LastUsedAt == nil || now.Sub(*r.LastUsedAt) >= sessionTouchInterval {
+		r.LastUsedAt = &now
+		if err := s.touchPAT(value, r); err != nil {
+			return pat.Token{}, err
+		}
+	}
+	return r.Token, nil
+}
+
+// lookupPAT returns the record of the secret, failing with ErrInvalidToken
+// unless the token exists and has not expired.
+func (s *basicService) lookupPAT(secret string) (patRecord, error) {
+	r, _, err := s.readPAT(secret)
+	return r, err
+}
+
+// readPAT is lookupPAT, which also returns the stored value.
+func (s *basicService) readPAT(secret string) (patRecord, []byte, error) {
+	var r patRecord
+
+	if !pat.IsToken(secret) {
+		return r, nil, authsvc.ErrInvalidToken
+	}
+
+	value, err := s.client.Get(patHashKey(pat.Hash(secret)))
+	if err == inmem.ErrKeyNotFound {
+		return r, nil, authsvc.ErrInvalidToken
+	}
+	if err != nil {
+		return r, nil, err
+	}
+
+	if err := json.Unmarshal(value, &r); err != nil {
+		return r, nil, err
+	}
+
+	// The key may outlive the token by the precision of the store.
+	if r.Expired(time.Now()) {
+		return r, nil, authsvc.ErrInvalidToken
+	}
+	return r, value, nil
+}
+
+// touchPAT replaces the token which was read as old. Both keys are only
+// replaced if they still hold old, so that a token revoked in the meantime
+// is not recreated. A token whose hash key is gone was revoked and fails
+// with ErrInvalidToken, whereas a touch lost to a concurrent one is not
+// retried.
+func (s *basicService) touchPAT(old []byte, r patRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	var ttl time.Duration
+	if r.ExpiresAt != nil {
+		if ttl = time.Until(*r.ExpiresAt); ttl <= 0 {
+			return authsvc.ErrInvalidToken
+		}
+	}
+
+	ok, err := s.client.CompareAndSwap(patHashKey(r.Hash), old, b, ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		_, err := s.client.Get(patHashKey(r.Hash))
+		if err == inmem.ErrKeyNotFound {
+			return authsvc.ErrInvalidToken
+		}
+		return err
+	}
+
+	_, err = s.client.CompareAndSwap(patKey(r.UserID, r.ID), old, b, ttl)
+	return err
+}
+
+// deletePAT deletes both keys of the token.
+func (s *basicService) deletePAT(r patRecord) error {
+	for _, key := range []string{patHashKey(r.Hash), patKey(r.UserID, r.ID)} {
+		if err := s.client.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *basicService) putPAT(r patRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+
+	var ttl time.Duration
+	if r.ExpiresAt != nil {
+		if ttl = time.Until(*r.ExpiresAt); ttl <= 0 {
+			return authsvc.ErrInvalidToken
+		}
+	}
+
+	for _, key := range []string{patHashKey(r.Hash), patKey(r.UserID, r.ID)} {
+		if ttl == 0 {
+			err = s.client.Put(key, b)
+		} else {
+			err = s.client.PutTTL(key, b, ttl)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func patKey(userID uint64, id string) string {
+	return fmt.Sprintf("pats/users/%d/%s", userID, id)
+}
+
+func patHashKey(hash string) string {
+	return "pats/hashes/" + hash
+}
@@ -0,0 +1,126 @@
+package authservice_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authservice"
+)
+
+// TestPATStoredHashed checks that only the hash of the secret is stored,
+// and that the token is resolved from the secret alone.
+func TestPATStoredHashed(t *testing.T) {
+	t.Parallel()
+
+	client := inmem.NewMemoryClient()
+	svc := authservice.NewBasicService(newTokenizer(t), nil, client, nil)
+	ctx := context.Background()
+
+	created, err := svc.CreatePAT(ctx, accessUUID(t, login(t, svc, 1)), 1, "cron", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pat.IsToken(created.Secret) {
+		t.Fatalf("want the secret returned on creation, got %q", created.Secret)
+	}
+	if created.Scopes.String() != oauth.SupportedScopes.String() {
+		t.Errorf("want every supported scope granted, got %q", created.Scopes)
+	}
+
+	values, err := client.List("pats/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 {
+		t.Errorf("want the token stored under its hash and its owner, got %d keys", len(values))
+	}
+	for _, v := range values {
+		if strings.Contains(string(v), created.Secret) || !strings.Contains(string(v), pat.Hash(created.Secret)) {
+			t.Errorf("want the hash stored instead of the secret, got %s", v)
+		}
+	}
+
+	resolved, err := svc.ResolvePAT(ctx, created.Secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.ID != created.ID || resolved.UserID != 1 || resolved.Secret != "" || resolved.LastUsedAt == nil {
+		t.Errorf("want the used token without its secret, got %+v", resolved)
+	}
+
+	listed, err := svc.PATs(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].ID != created.ID || listed[0].Secret != "" {
+		t.Errorf("want the token listed without its secret, got %+v", listed)
+	}
+
+	for _, secret := range []string{
+		created.Secret[:len(created.Secret)-1],
+		strings.TrimPrefix(created.Secret, pat.Prefix),
+		pat.Hash(created.Secret),
+		"",
+	} {
+		if _, err := svc.ResolvePAT(ctx, secret); err != authsvc.ErrInvalidToken {
+			t.Errorf("%q: want %v, got %v", secret, authsvc.ErrInvalidToken, err)
+		}
+	}
+}
+
+// TestRevokePAT checks that a token is only revoked by its owner, after
+// which it no longer resolves.
+func TestRevokePAT(t *testing.T) {
+	t.Parallel()
+
+	svc := authservice.NewBasicService(newTokenizer(t), nil, inmem.NewMemoryClient(), nil)
+	ctx := context.Background()
+
+	owner := accessUUID(t, login(t, svc, 1))
+	created, err := svc.CreatePAT(ctx, owner, 1, "cron", []string{oauth.SupportedScopes[0]}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := svc.RevokePAT(ctx, accessUUID(t, login(t, svc, 2)), 2, created.ID); err != authsvc.ErrTokenNotFound {
+		t.Errorf("token of another user: want %v, got %v", authsvc.ErrTokenNotFound, err)
+	}
+	if _, err := svc.ResolvePAT(ctx, created.Secret); err != nil {
+		t.Errorf("want the token left valid, got %v", err)
+	}
+
+	if ok, err := svc.RevokePAT(ctx, owner, 1, created.ID); err != nil || !ok {
+		t.Fatalf("want the token revoked, got %v, %v", ok, err)
+	}
+	if _, err := svc.ResolvePAT(ctx, created.Secret); err != authsvc.ErrInvalidToken {
+		t.Errorf("revoked token: want %v, got %v", authsvc.ErrInvalidToken, err)
+	}
+	if listed, err := svc.PATs(ctx, 1); err != nil || len(listed) != 0 {
+		t.Errorf("want no token listed, got %v, %v", listed, err)
+	}
+}
+
+func TestCreatePATInvalid(t *testing.T) {
+	t.Parallel()
+
+	svc := authservice.NewBasicService(newTokenizer(t), nil, inmem.NewMemoryClient(), nil)
+	owner := accessUUID(t, login(t, svc, 1))
+
+	for _, tc := range []struct {
+		name   string
+		scopes []string
+	}{
+		{"", nil},
+		{strings.Repeat("a", 101), nil},
+		{"cron", []string{"users:manage"}},
+	} {
+		if _, err := svc.CreatePAT(context.Background(), owner, 1, tc.name, tc.scopes, 0); err != authsvc.ErrInvalidArgument {
+			t.Errorf("%q %v: want %v, got %v", tc.name, tc.scopes, authsvc.ErrInvalidArgument, err)
+		}
+	}
+}
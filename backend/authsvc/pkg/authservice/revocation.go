@@ -0,0 +1,175 @@
+package authservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+)
+
+const (
+	// MaxRevocationWait caps how long Revocations waits for a revocation,
+	// the clients must allow for it in their timeout.
+	MaxRevocationWait = 25 * time.Second
+	// revocationTTL is the lifetime of the access tokens, a revoked token
+	// is rejected for having expired afterwards.
+	revocationTTL = accessTokenTTL
+	// revocationPollInterval is how often the revocations are listed while
+	// waiting, as they cannot be watched.
+	revocationPollInterval = 500 * time.Millisecond
+	// maxRevocationClaims bounds the numbers tried by recordRevocation,
+	// each of which was taken by a concurrent revocation.
+	maxRevocationClaims = 1000
+
+	revocationSeqKey = "revocations/seq"
+)
+
+// errSeqNotNewer stops the update of the sequence hint, which is already
+// past the number.
+var errSeqNotNewer = errors.New("sequence is not newer")
+
+// Revocations returns the access tokens revoked after the revocation
+// numbered since, together with the cursor to pass as since in the next
+// call. It waits up to wait for a revocation if there is none yet, so that
+// the caller learns about it as soon as it happened. A zero since returns
+// every revocation which is kept without waiting. It fails with
+// ErrRevocationsLost if the cursor is ahead of the store, i.e. the store was
+// reset, after which the caller must start over.
+//
+// Each revocation is kept under its own key, see recordRevocation, so that
+// a revocation which is not listed while a later one is has expired along
+// with its token.
+func (s *basicService) Revocations(ctx context.Context, since uint64, wait time.Duration) ([]authsvc.Revocation, uint64, error) {
+	if wait < 0 {
+		return nil, 0, authsvc.ErrInvalidArgument
+	}
+	if wait > MaxRevocationWait {
+		wait = MaxRevocationWait
+	}
+
+	deadline := time.Now().Add(wait)
+	for {
+		// The hint is read first, the revocations it covers are then
+		// either listed or expired.
+		next, err := s.revocationSeq()
+		if err != nil {
+			return nil, 0, err
+		}
+		if since > next {
+			return nil, 0, authsvc.ErrRevocationsLost
+		}
+
+		values, err := s.client.List(revocationKey(0))
+		if err != nil {
+			return nil, 0, err
+		}
+
+		revocations := make([]authsvc.Revocation, 0)
+		for _, v := range values {
+			var r authsvc.Revocation
+			if err := json.Unmarshal(v, &r); err != nil {
+				return nil, 0, err
+			}
+			if r.Seq <= since {
+				continue
+			}
+			revocations = append(revocations, r)
+			if r.Seq > next {
+				next = r.Seq
+			}
+		}
+		if len(revocations) > 0 || since == 0 || !time.Now().Before(deadline) {
+			return revocations, next, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		case <-time.After(revocationPollInterval):
+		}
+	}
+}
+
+// recordRevocation adds the access token to the revocations, which is
+// done whenever a session is deleted before it expired. The revocation
+// claims the lowest free number past the sequence hint by creating its key,
+// so that the numbers have no gap but for the expired revocations, and no
+// key is shared by the concurrent revocations but the hint, which is only
+// advanced on a best effort basis.
+func (s *basicService) recordRevocation(userID uint64, accessUUID string) error {
+	seq, err := s.revocationSeq()
+	if err != nil {
+		return err
+	}
+
+	r := authsvc.Revocation{
+		AccessUUID: accessUUID,
+		UserID:     userID,
+		RevokedAt:  time.Now().UTC(),
+	}
+	for i := 0; i < maxRevocationClaims; i++ {
+		seq++
+		r.Seq = seq
+
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		ok, err := s.client.CompareAndSwap(revocationKey(seq), nil, b, revocationTTL)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return s.advanceRevocationSeq(seq)
+		}
+	}
+	return inmem.ErrConflict
+}
+
+// revocationSeq returns the sequence hint, below which every number was
+// claimed by a revocation.
+func (s *basicService) revocationSeq() (uint64, error) {
+	value, err := s.client.Get(revocationSeqKey)
+	if err == inmem.ErrKeyNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(value), 10, 64)
+}
+
+// advanceRevocationSeq raises the sequence hint to seq. A hint left behind
+// only costs the next revocation more claims, hence a conflict is ignored.
+func (s *basicService) advanceRevocationSeq(seq uint64) error {
+	err := inmem.Update(s.client, revocationSeqKey, func(value []byte) ([]byte, time.Duration, error) {
+		if value != nil {
+			current, err := strconv.ParseUint(string(value), 10, 64)
+			if err != nil {
+				return nil, 0, err
+			}
+			if current >= seq {
+				return nil, 0, errSeqNotNewer
+			}
+		}
+		return []byte(strconv.FormatUint(seq, 10)), 0, nil
+	})
+	if err == errSeqNotNewer || err == inmem.ErrConflict {
+		return nil
+	}
+	return err
+}
+
+// revocationKey returns the key of the revocation, or the prefix of all
+// revocations for a zero seq.
+func revocationKey(seq uint64) string {
+	if seq == 0 {
+		return "revocations/entries/"
+	}
+	return fmt.Sprintf("revocations/entries/%d", seq)
+}
@@ -0,0 +1,66 @@
+package authservice
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+)
+
+func TestRevocationsNumberConcurrentRevocations(t *testing.T) {
+	t.Parallel()
+
+	s := &basicService{client: inmem.NewMemoryClient()}
+	ctx := context.Background()
+
+	const n = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- s.recordRevocation(uint64(i+1), fmt.Sprintf("access-%d", i))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revocations, next, err := s.Revocations(ctx, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revocations) != n || next != n {
+		t.Fatalf("want %d revocations up to %d, got %d up to %d", n, n, len(revocations), next)
+	}
+	seen := make(map[uint64]bool)
+	for _, r := range revocations {
+		if r.Seq == 0 || r.Seq > n || seen[r.Seq] {
+			t.Errorf("revocation of %s numbered %d twice or out of range", r.AccessUUID, r.Seq)
+		}
+		seen[r.Seq] = true
+	}
+
+	if err := s.recordRevocation(1, "access-last"); err != nil {
+		t.Fatal(err)
+	}
+	revocations, next, err = s.Revocations(ctx, n, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revocations) != 1 || revocations[0].AccessUUID != "access-last" || next != n+1 {
+		t.Errorf("want access-last up to %d, got %v up to %d", n+1, revocations, next)
+	}
+
+	if _, _, err := s.Revocations(ctx, n+10, 0); err != authsvc.ErrRevocationsLost {
+		t.Errorf("want %v for a cursor ahead of the store, got %v", authsvc.ErrRevocationsLost, err)
+	}
+}
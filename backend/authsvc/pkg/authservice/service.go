@@ -2,10 +2,18 @@ package authservice
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/ichigozero/gtdkit/backend/authsvc"
 	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oidc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
 	stduuid "github.com/twinj/uuid"
 )
 
@@ -14,12 +22,55 @@ type Service interface {
 	Logout(ctx context.Context, accessUUID string) (bool, error)
 	Refresh(ctx context.Context, accessUUID, refreshUUID string, userID uint64) (map[string]string, error)
 	Validate(ctx context.Context, accessUUID string) (bool, error)
+	Sessions(ctx context.Context, userID uint64) ([]authsvc.Session, error)
+	RevokeSession(ctx context.Context, accessUUID string, userID uint64, sessionID string) (bool, error)
+	RevokeAllSessions(ctx context.Context, accessUUID string, userID uint64) (int, error)
+	// RevokeUserSessions signs the user out of every session on behalf of
+	// an administrator, whose session is accessUUID, e.g. once the user
+	// was disabled or its roles changed.
+	RevokeUserSessions(ctx context.Context, accessUUID string, userID uint64) (int, error)
+	// Unlock clears the failed logins of the username and the IP on behalf
+	// of an administrator, whose session is accessUUID.
+	Unlock(ctx context.Context, accessUUID, username, ip string) (bool, error)
+	VerifyMFA(ctx context.Context, mfaUUID string, userID uint64, code string) (map[string]string, error)
+	EnrollTOTP(ctx context.Context, accessUUID string, userID uint64) (usersvc.TOTPKey, error)
+	ConfirmTOTP(ctx context.Context, accessUUID string, userID uint64, code string) ([]string, error)
+	DisableTOTP(ctx context.Context, accessUUID string, userID uint64, code string) (bool, error)
+	ChangePassword(ctx context.Context, username, oldPassword, newPassword string) (bool, error)
+	JWKS(ctx context.Context) (jwks.KeySet, error)
+
+	RegisterClient(ctx context.Context, accessUUID string, userID uint64, c oauth.Client) (oauth.Client, error)
+	Client(ctx context.Context, clientID string) (oauth.Client, error)
+	Authorize(ctx context.Context, accessUUID string, userID uint64, req oauth.AuthorizeRequest) (string, error)
+	Token(ctx context.Context, req oauth.TokenRequest) (oauth.Token, error)
+	Consents(ctx context.Context, userID uint64) ([]oauth.Consent, error)
+	RevokeConsent(ctx context.Context, accessUUID string, userID uint64, clientID string) (bool, error)
+	Introspect(ctx context.Context, req oauth.IntrospectionRequest) (oauth.Introspection, error)
+	RevokeToken(ctx context.Context, req oauth.IntrospectionRequest) error
+
+	OIDCLogin(ctx context.Context, provider string) (url, binding string, err error)
+	OIDCCallback(ctx context.Context, provider, code, state, binding string) (map[string]string, error)
+
+	CreatePAT(ctx context.Context, accessUUID string, userID uint64, name string, scopes []string, ttl time.Duration) (pat.Token, error)
+	PATs(ctx context.Context, userID uint64) ([]pat.Token, error)
+	RevokePAT(ctx context.Context, accessUUID string, userID uint64, id string) (bool, error)
+	ResolvePAT(ctx context.Context, secret string) (pat.Token, error)
+
+	Revocations(ctx context.Context, since uint64, wait time.Duration) ([]authsvc.Revocation, uint64, error)
 }
 
-func New(t Tokenizer, c inmem.Client, logger log.Logger) Service {
+// maxMFAAttempts is the number of wrong codes after which the MFA
+// challenge is discarded and the user has to log in again.
+const maxMFAAttempts = 5
+
+// sessionTouchInterval limits how often the last use of a session is
+// written, as every request of the other services is validated.
+const sessionTouchInterval = time.Minute
+
+func New(t Tokenizer, th Throttler, c inmem.Client, p oidc.Providers, logger log.Logger) Service {
 	var svc Service
 	{
-		svc = NewBasicService(t, c)
+		svc = NewBasicService(t, th, c, p)
 		svc = LoggingMiddleware(logger)(svc)
 
 	}
@@ -28,11 +79,13 @@ func New(t Tokenizer, c inmem.Client, logger log.Logger) Service {
 
 type basicService struct {
 	tokenizer Tokenizer
+	throttler Throttler
 	client    inmem.Client
+	providers oidc.Providers
 }
 
-func NewBasicService(t Tokenizer, c inmem.Client) Service {
-	return &basicService{tokenizer: t, client: c}
+func NewBasicService(t Tokenizer, th Throttler, c inmem.Client, p oidc.Providers) Service {
+	return &basicService{tokenizer: t, throttler: th, client: c, providers: p}
 }
 
 func (s *basicService) Login(ctx context.Context, _, _ string) (map[string]string, error) {
@@ -41,12 +94,19 @@ func (s *basicService) Login(ctx context.Context, _, _ string) (map[string]strin
 		return nil, authsvc.ErrUserIDContextMissing
 	}
 
-	at, rt, err := s.tokenizer.Generate(userID)
+	if required, _ := ctx.Value(authsvc.MFARequiredContextKey).(bool); required {
+		return s.challenge(userID)
+	}
+
+	roles, _ := ctx.Value(authsvc.RolesContextKey).([]string)
+	at, rt, err := s.tokenizer.Generate(userID, roles)
 	if err != nil {
 		return nil, err
 	}
 
-	s.storeTokens(at, rt)
+	if err := s.storeTokens(ctx, userID, newFamily(), at, rt, authsvc.Session{}); err != nil {
+		return nil, err
+	}
 
 	return s.compileTokens(at, rt), nil
 }
@@ -56,62 +116,565 @@ func (s *basicService) Logout(ctx context.Context, accessUUID string) (bool, err
 		return false, err
 	}
 
-	ruuid := stduuid.NewV5(stduuid.NameSpaceURL, accessUUID).String()
+	session, err := s.session(accessUUID)
+	if err != nil {
+		return false, err
+	}
 
-	var err error
-	{
-		err = s.client.Delete(accessUUID)
-		if err != nil {
-			return false, err
-		}
-		err = s.client.Delete(ruuid)
-		if err != nil {
+	if err := s.revoke(session.UserID, accessUUID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *basicService) Refresh(ctx context.Context, accessUUID, refreshUUID string, userID uint64) (map[string]string, error) {
+	if accessUUID == "" || refreshUUID == "" || userID == 0 {
+		return nil, authsvc.ErrInvalidArgument
+	}
+
+	rs, prev, err := s.rotate(accessUUID, refreshUUID, userID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	roles, _ := ctx.Value(authsvc.RolesContextKey).([]string)
+	at, rt, err := s.tokenizer.Generate(userID, roles)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.storeTokens(ctx, userID, rs.Family, at, rt, prev); err != nil {
+		return nil, err
+	}
+
+	return s.compileTokens(at, rt), nil
+}
+
+// Validate also records the use of the session, at most once per
+// sessionTouchInterval.
+func (s *basicService) Validate(ctx context.Context, accessUUID string) (bool, error) {
+	if accessUUID == "" {
+		return false, authsvc.ErrInvalidArgument
+	}
+
+	value, err := s.client.Get(accessUUID)
+	if err != nil {
+		return false, err
+	}
+
+	var session authsvc.Session
+	if err := json.Unmarshal(value, &session); err != nil {
+		return false, err
+	}
+
+	if now := time.Now().UTC(); now.Sub(session.LastUsedAt) >= sessionTouchInterval {
+		session.LastUsedAt = now
+		if err := s.touchSession(value, session); err != nil {
 			return false, err
 		}
 	}
-
 	return true, nil
 }
 
-func (s *basicService) Refresh(_ context.Context, accessUUID, refreshUUID string, userID uint64) (map[string]string, error) {
-	if accessUUID == "" || refreshUUID == "" || userID == 0 {
+func (s *basicService) Sessions(_ context.Context, userID uint64) ([]authsvc.Session, error) {
+	if userID == 0 {
 		return nil, authsvc.ErrInvalidArgument
 	}
 
-	if err := s.client.Get(refreshUUID); err != nil {
+	values, err := s.client.List(sessionKey(userID, ""))
+	if err != nil {
 		return nil, err
 	}
 
-	var err error
-	{
-		err = s.client.Delete(accessUUID)
-		if err != nil {
+	now := time.Now()
+	sessions := make([]authsvc.Session, 0, len(values))
+	for _, v := range values {
+		var session authsvc.Session
+		if err := json.Unmarshal(v, &session); err != nil {
 			return nil, err
 		}
+		if session.ExpiresAt.Before(now) {
+			continue
+		}
+		sessions = append(sessions, session)
+	}
 
-		err = s.client.Delete(refreshUUID)
-		if err != nil {
-			return nil, err
+	return sessions, nil
+}
+
+// RevokeSession signs out one of the sessions of the user, which may be the
+// session of the caller itself.
+func (s *basicService) RevokeSession(ctx context.Context, accessUUID string, userID uint64, sessionID string) (bool, error) {
+	if userID == 0 || sessionID == "" {
+		return false, authsvc.ErrInvalidArgument
+	}
+
+	if _, err := s.validate(ctx, accessUUID); err != nil {
+		return false, err
+	}
+
+	// The per user key is looked up so that only the own sessions can be
+	// revoked.
+	_, err := s.client.Get(sessionKey(userID, sessionID))
+	if err == inmem.ErrKeyNotFound {
+		return false, authsvc.ErrSessionNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := s.revoke(userID, sessionID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RevokeAllSessions signs out every session of the user but the one of the
+// caller, and returns the number of revoked sessions.
+func (s *basicService) RevokeAllSessions(ctx context.Context, accessUUID string, userID uint64) (int, error) {
+	if userID == 0 {
+		return 0, authsvc.ErrInvalidArgument
+	}
+
+	if _, err := s.validate(ctx, accessUUID); err != nil {
+		return 0, err
+	}
+
+	return s.revokeSessions(ctx, userID, accessUUID)
+}
+
+func (s *basicService) RevokeUserSessions(ctx context.Context, accessUUID string, userID uint64) (int, error) {
+	if userID == 0 {
+		return 0, authsvc.ErrInvalidArgument
+	}
+
+	if _, err := s.validate(ctx, accessUUID); err != nil {
+		return 0, err
+	}
+
+	return s.revokeSessions(ctx, userID, "")
+}
+
+// revokeSessions revokes the sessions of the user but the kept one.
+func (s *basicService) revokeSessions(ctx context.Context, userID uint64, kept string) (int, error) {
+	sessions, err := s.Sessions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	for _, session := range sessions {
+		if session.AccessUUID == kept {
+			continue
 		}
+		if err := s.revoke(userID, session.AccessUUID); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+func (s *basicService) Unlock(ctx context.Context, accessUUID, username, ip string) (bool, error) {
+	if username == "" && ip == "" {
+		return false, authsvc.ErrInvalidArgument
+	}
+
+	if _, err := s.validate(ctx, accessUUID); err != nil {
+		return false, err
+	}
+
+	if err := s.throttler.Unlock(username, ip); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// VerifyMFA completes a login which was answered with an MFA challenge.
+// The code is checked by the verifier passed through the context, which is
+// only called once the challenge is known to be valid so that recovery
+// codes are not used up by stale challenges. The attempt is counted before
+// the code is checked, so that concurrent guesses cannot exceed
+// maxMFAAttempts.
+func (s *basicService) VerifyMFA(ctx context.Context, mfaUUID string, userID uint64, code string) (map[string]string, error) {
+	if mfaUUID == "" || userID == 0 || code == "" {
+		return nil, authsvc.ErrInvalidArgument
 	}
 
-	at, rt, err := s.tokenizer.Generate(userID)
+	var c mfaChallenge
+	err := inmem.Update(s.client, mfaKey(mfaUUID), func(value []byte) ([]byte, time.Duration, error) {
+		if value == nil {
+			return nil, 0, inmem.ErrKeyNotFound
+		}
+
+		c = mfaChallenge{}
+		if err := json.Unmarshal(value, &c); err != nil {
+			return nil, 0, err
+		}
+		if c.UserID != userID {
+			return nil, 0, authsvc.ErrClaimsInvalid
+		}
+
+		// A challenge which ran out of attempts is kept until it expires,
+		// as it cannot be deleted here.
+		ttl := time.Until(c.ExpiresAt)
+		if c.Attempts >= maxMFAAttempts || ttl <= 0 {
+			return nil, 0, inmem.ErrKeyNotFound
+		}
+
+		c.Attempts++
+		b, err := json.Marshal(c)
+		return b, ttl, err
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	s.storeTokens(at, rt)
+	verify, ok := ctx.Value(authsvc.MFAVerifierContextKey).(func(code string) (bool, error))
+	if !ok {
+		return nil, authsvc.ErrMFAVerifierContextMissing
+	}
+
+	verified, err := verify(code)
+	if err != nil {
+		return nil, err
+	}
+	if !verified {
+		if c.Attempts >= maxMFAAttempts {
+			if err := s.client.Delete(mfaKey(mfaUUID)); err != nil {
+				return nil, err
+			}
+		}
+		return nil, authsvc.ErrInvalidMFACode
+	}
+
+	if err := s.client.Delete(mfaKey(mfaUUID)); err != nil {
+		return nil, err
+	}
+
+	roles, _ := ctx.Value(authsvc.RolesContextKey).([]string)
+	at, rt, err := s.tokenizer.Generate(userID, roles)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.storeTokens(ctx, userID, newFamily(), at, rt, authsvc.Session{}); err != nil {
+		return nil, err
+	}
 
 	return s.compileTokens(at, rt), nil
 }
 
-func (s *basicService) Validate(ctx context.Context, accessUUID string) (bool, error) {
+// EnrollTOTP, ConfirmTOTP and DisableTOTP only check that the session is
+// still valid, usersvc is called by the proxing middleware afterwards.
+func (s *basicService) EnrollTOTP(ctx context.Context, accessUUID string, userID uint64) (usersvc.TOTPKey, error) {
+	if userID == 0 {
+		return usersvc.TOTPKey{}, authsvc.ErrInvalidArgument
+	}
+
+	_, err := s.validate(ctx, accessUUID)
+	return usersvc.TOTPKey{}, err
+}
+
+func (s *basicService) ConfirmTOTP(ctx context.Context, accessUUID string, userID uint64, code string) ([]string, error) {
+	if userID == 0 || code == "" {
+		return nil, authsvc.ErrInvalidArgument
+	}
+
+	_, err := s.validate(ctx, accessUUID)
+	return nil, err
+}
+
+func (s *basicService) DisableTOTP(ctx context.Context, accessUUID string, userID uint64, code string) (bool, error) {
+	if userID == 0 || code == "" {
+		return false, authsvc.ErrInvalidArgument
+	}
+
 	return s.validate(ctx, accessUUID)
 }
 
-func (s *basicService) storeTokens(at *AccessToken, rt *RefreshToken) {
-	s.client.Put(at.UUID, []byte(at.Hash))
-	s.client.Put(rt.RefreshUUID, []byte(rt.Hash))
+// ChangePassword does not require a session, as it is the only way to log
+// in again after a password reset was forced. The password is changed by
+// usersvc through the proxing middleware.
+func (s *basicService) ChangePassword(_ context.Context, username, oldPassword, newPassword string) (bool, error) {
+	if username == "" || oldPassword == "" || newPassword == "" {
+		return false, authsvc.ErrInvalidArgument
+	}
+	return true, nil
+}
+
+// JWKS returns the public keys of the access tokens, which are fetched by
+// the other services to verify the tokens by themselves.
+func (s *basicService) JWKS(_ context.Context) (jwks.KeySet, error) {
+	return s.tokenizer.PublicKeys(), nil
+}
+
+type mfaChallenge struct {
+	UserID    uint64    `json:"user_id"`
+	Attempts  int       `json:"attempts"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// challenge issues the MFA token which has to be exchanged for the token
+// pair through VerifyMFA.
+func (s *basicService) challenge(userID uint64) (map[string]string, error) {
+	mt, err := s.tokenizer.GenerateMFA(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	c := mfaChallenge{UserID: userID, ExpiresAt: mt.Expires}
+	if err := s.putChallenge(mt.UUID, c, time.Until(mt.Expires)); err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"mfa": mt.Hash}, nil
+}
+
+func (s *basicService) putChallenge(mfaUUID string, c mfaChallenge, ttl time.Duration) error {
+	if ttl <= 0 {
+		return s.client.Delete(mfaKey(mfaUUID))
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return s.client.PutTTL(mfaKey(mfaUUID), b, ttl)
+}
+
+// rotate marks the refresh token as used and deletes the token pair it
+// belongs to, returning the state of the token and the session which the
+// new pair continues. The token must have been issued to the client, which
+// is empty for first-party sessions.
+func (s *basicService) rotate(accessUUID, refreshUUID string, userID uint64, clientID string) (refreshState, authsvc.Session, error) {
+	var (
+		rs   refreshState
+		prev authsvc.Session
+	)
+
+	value, err := s.client.Get(refreshUUID)
+	if err != nil {
+		return rs, prev, err
+	}
+
+	if err := json.Unmarshal(value, &rs); err != nil {
+		return rs, prev, err
+	}
+	if rs.UserID != userID || rs.ClientID != clientID {
+		return rs, prev, authsvc.ErrClaimsInvalid
+	}
+
+	// The used token is kept until it expires, so that it is recognized
+	// when presented again. It is marked only if it is unchanged since it
+	// was read, so that of two concurrent exchanges only one succeeds.
+	swapped := false
+	if !rs.Used {
+		ttl := time.Until(rs.ExpiresAt)
+		if ttl <= 0 {
+			return rs, prev, inmem.ErrKeyNotFound
+		}
+
+		rs.Used = true
+		b, err := json.Marshal(rs)
+		if err != nil {
+			return rs, prev, err
+		}
+		if swapped, err = s.client.CompareAndSwap(refreshUUID, value, b, ttl); err != nil {
+			return rs, prev, err
+		}
+	}
+
+	// A refresh token which was already exchanged is either replayed by an
+	// attacker or by the legitimate client after the attacker used it. As
+	// both cannot be told apart, the whole family is signed out.
+	if !swapped {
+		if err := s.revokeFamily(rs.Family); err != nil {
+			return rs, prev, err
+		}
+		return rs, prev, authsvc.ErrRefreshTokenReused
+	}
+
+	// The new pair continues the session, so that it keeps its sign-in
+	// time in the listing. The client and the scopes are taken from the
+	// token state, which cannot be altered by the client.
+	prev, _ = s.session(accessUUID)
+	prev.ClientID = rs.ClientID
+	prev.Scopes = rs.Scopes
+
+	if err := s.client.Delete(sessionKey(userID, accessUUID)); err != nil {
+		return rs, prev, err
+	}
+	if err := s.client.Delete(accessUUID); err != nil {
+		return rs, prev, err
+	}
+	// The previous access token may not have expired yet.
+	if err := s.recordRevocation(userID, accessUUID); err != nil {
+		return rs, prev, err
+	}
+	return rs, prev, nil
+}
+
+// storeTokens persists both tokens together with the session metadata. The
+// device is described by the user agent and the client IP passed through
+// the context, falling back to the ones of the previous session. A
+// previous session without CreatedAt starts a new one, which belongs to
+// the client and has the scopes of prev.
+func (s *basicService) storeTokens(ctx context.Context, userID uint64, family string, at *AccessToken, rt *RefreshToken, prev authsvc.Session) error {
+	now := time.Now().UTC()
+
+	session := authsvc.Session{
+		AccessUUID: at.UUID,
+		UserID:     userID,
+		UserAgent:  prev.UserAgent,
+		IP:         prev.IP,
+		CreatedAt:  prev.CreatedAt,
+		LastUsedAt: now,
+		ExpiresAt:  rt.Expires.UTC(),
+		ClientID:   prev.ClientID,
+		Scopes:     prev.Scopes,
+	}
+	if session.CreatedAt.IsZero() {
+		session.CreatedAt = now
+	}
+	if v, _ := ctx.Value(authsvc.UserAgentContextKey).(string); v != "" {
+		session.UserAgent = v
+	}
+	if v, _ := ctx.Value(authsvc.ClientIPContextKey).(string); v != "" {
+		session.IP = v
+	}
+
+	ttl := time.Until(rt.Expires)
+	f, err := json.Marshal(tokenFamily{UserID: userID, AccessUUID: at.UUID})
+	if err != nil {
+		return err
+	}
+	if err := s.client.PutTTL(familyKey(family), f, ttl); err != nil {
+		return err
+	}
+
+	rs := refreshState{
+		Family:    family,
+		UserID:    userID,
+		ClientID:  session.ClientID,
+		Scopes:    session.Scopes,
+		ExpiresAt: rt.Expires.UTC(),
+	}
+	if err := s.putRefreshState(rt.RefreshUUID, rs); err != nil {
+		return err
+	}
+	return s.putSession(session)
+}
+
+// refreshState is stored under the refresh UUID. It links the token to its
+// family, i.e. the chain of token pairs issued by refreshing the pair of a
+// single login.
+type refreshState struct {
+	Family    string    `json:"family"`
+	UserID    uint64    `json:"user_id"`
+	ClientID  string    `json:"client_id,omitempty"`
+	Scopes    []string  `json:"scopes,omitempty"`
+	Used      bool      `json:"used"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// tokenFamily points to the latest token pair of the family, which is the
+// only one that is still valid.
+type tokenFamily struct {
+	UserID     uint64 `json:"user_id"`
+	AccessUUID string `json:"access_uuid"`
+}
+
+func (s *basicService) putRefreshState(refreshUUID string, rs refreshState) error {
+	b, err := json.Marshal(rs)
+	if err != nil {
+		return err
+	}
+	return s.client.PutTTL(refreshUUID, b, time.Until(rs.ExpiresAt))
+}
+
+// revokeFamily signs out the latest token pair of the family. The family
+// itself is kept, so that any other token of it is still detected as
+// reused until it expires.
+func (s *basicService) revokeFamily(family string) error {
+	value, err := s.client.Get(familyKey(family))
+	if err == inmem.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var f tokenFamily
+	if err := json.Unmarshal(value, &f); err != nil {
+		return err
+	}
+	return s.revoke(f.UserID, f.AccessUUID)
+}
+
+// putSession writes the session under the access UUID, so that it can be
+// found from the access token alone, and under the per user key, which
+// makes it listable. Both expire together with the refresh token.
+func (s *basicService) putSession(session authsvc.Session) error {
+	b, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	ttl := time.Until(session.ExpiresAt)
+	if err := s.client.PutTTL(session.AccessUUID, b, ttl); err != nil {
+		return err
+	}
+	return s.client.PutTTL(sessionKey(session.UserID, session.AccessUUID), b, ttl)
+}
+
+// touchSession replaces the session which was read as old. Both keys are
+// only replaced if they still hold old, so that a session revoked in the
+// meantime is not recreated, and a touch lost to a concurrent one is not
+// retried.
+func (s *basicService) touchSession(old []byte, session authsvc.Session) error {
+	ttl := time.Until(session.ExpiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range []string{session.AccessUUID, sessionKey(session.UserID, session.AccessUUID)} {
+		ok, err := s.client.CompareAndSwap(key, old, b, ttl)
+		if err != nil || !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// revoke deletes the session and both of its tokens, and records the
+// revocation of the access token.
+func (s *basicService) revoke(userID uint64, accessUUID string) error {
+	ruuid := stduuid.NewV5(stduuid.NameSpaceURL, accessUUID).String()
+
+	for _, key := range []string{sessionKey(userID, accessUUID), accessUUID, ruuid} {
+		if err := s.client.Delete(key); err != nil {
+			return err
+		}
+	}
+	return s.recordRevocation(userID, accessUUID)
+}
+
+func (s *basicService) session(accessUUID string) (authsvc.Session, error) {
+	var session authsvc.Session
+
+	value, err := s.client.Get(accessUUID)
+	if err != nil {
+		return session, err
+	}
+
+	err = json.Unmarshal(value, &session)
+	return session, err
 }
 
 func (s *basicService) compileTokens(at *AccessToken, rt *RefreshToken) map[string]string {
@@ -126,8 +689,24 @@ func (s *basicService) validate(_ context.Context, accessUUID string) (bool, err
 		return false, authsvc.ErrInvalidArgument
 	}
 
-	if err := s.client.Get(accessUUID); err != nil {
+	if _, err := s.client.Get(accessUUID); err != nil {
 		return false, err
 	}
 	return true, nil
 }
+
+func mfaKey(mfaUUID string) string {
+	return "mfa/" + mfaUUID
+}
+
+func familyKey(family string) string {
+	return "families/" + family
+}
+
+func newFamily() string {
+	return stduuid.NewV4().String()
+}
+
+func sessionKey(userID uint64, accessUUID string) string {
+	return fmt.Sprintf("sessions/%d/%s", userID, accessUUID)
+}
@@ -0,0 +1,124 @@
+package authservice_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authservice"
+)
+
+func newTokenizer(t *testing.T) authservice.Tokenizer {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := authservice.NewKeyProvider(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return authservice.NewTokenizer(keys)
+}
+
+// refresh exchanges the refresh token of the pair for a new pair.
+func refresh(t *testing.T, svc authservice.Service, tokens map[string]string) (map[string]string, error) {
+	t.Helper()
+
+	rc := claimsOf(t, tokens["refresh"])
+	return svc.Refresh(context.Background(), rc["access_uuid"].(string), rc["refresh_uuid"].(string), 1)
+}
+
+// TestRefreshTokenReuseRevokesFamily checks that presenting a rotated
+// refresh token again signs out the pair it was rotated into, and that
+// the other logins of the user are left alone.
+func TestRefreshTokenReuseRevokesFamily(t *testing.T) {
+	t.Parallel()
+
+	svc := authservice.NewBasicService(newTokenizer(t), nil, inmem.NewMemoryClient(), nil)
+	ctx := context.Background()
+
+	first := login(t, svc, 1)
+	other := login(t, svc, 1)
+
+	second, err := refresh(t, svc, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	third, err := refresh(t, svc, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.Validate(ctx, accessUUID(t, third)); err != nil {
+		t.Fatalf("want the latest pair valid, got %v", err)
+	}
+
+	if _, err := refresh(t, svc, first); err != authsvc.ErrRefreshTokenReused {
+		t.Errorf("reused token: want %v, got %v", authsvc.ErrRefreshTokenReused, err)
+	}
+	if _, err := svc.Validate(ctx, accessUUID(t, third)); err != inmem.ErrKeyNotFound {
+		t.Errorf("want the latest access token revoked, got %v", err)
+	}
+	if _, err := refresh(t, svc, third); err == nil {
+		t.Error("want the latest refresh token revoked")
+	}
+
+	if _, err := svc.Validate(ctx, accessUUID(t, other)); err != nil {
+		t.Errorf("want the other login valid, got %v", err)
+	}
+	if _, err := refresh(t, svc, other); err != nil {
+		t.Errorf("want the other login refreshed, got %v", err)
+	}
+}
+
+// TestRevokeSessionLeavesOthers checks that revoking a session signs out
+// that session only, and that the sessions of other users cannot be
+// revoked.
+func TestRevokeSessionLeavesOthers(t *testing.T) {
+	t.Parallel()
+
+	svc := authservice.NewBasicService(newTokenizer(t), nil, inmem.NewMemoryClient(), nil)
+	ctx := context.Background()
+
+	var (
+		caller   = accessUUID(t, login(t, svc, 1))
+		revoked  = login(t, svc, 1)
+		kept     = accessUUID(t, login(t, svc, 1))
+		stranger = accessUUID(t, login(t, svc, 2))
+	)
+
+	ok, err := svc.RevokeSession(ctx, caller, 1, accessUUID(t, revoked))
+	if err != nil || !ok {
+		t.Fatalf("want the session revoked, got %v, %v", ok, err)
+	}
+	if _, err := svc.Validate(ctx, accessUUID(t, revoked)); err != inmem.ErrKeyNotFound {
+		t.Errorf("want the revoked access token refused, got %v", err)
+	}
+	if _, err := refresh(t, svc, revoked); err == nil {
+		t.Error("want the revoked refresh token refused")
+	}
+
+	for _, id := range []string{caller, kept, stranger} {
+		if _, err := svc.Validate(ctx, id); err != nil {
+			t.Errorf("want session %s valid, got %v", id, err)
+		}
+	}
+	sessions, err := svc.Sessions(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Errorf("want 2 sessions left, got %d", len(sessions))
+	}
+
+	if _, err := svc.RevokeSession(ctx, caller, 1, stranger); err != authsvc.ErrSessionNotFound {
+		t.Errorf("session of another user: want %v, got %v", authsvc.ErrSessionNotFound, err)
+	}
+	if _, err := svc.Validate(ctx, stranger); err != nil {
+		t.Errorf("want the session of the other user valid, got %v", err)
+	}
+}
@@ -0,0 +1,188 @@
+package authservice
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+)
+
+// ThrottlePolicy describes how failed login attempts are penalized.
+type ThrottlePolicy struct {
+	// FreeAttempts is the number of failures allowed without any delay.
+	FreeAttempts int
+	// BaseDelay is the delay after the first penalized failure, it is
+	// doubled for every subsequent failure up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// LockoutAttempts is the number of failures after which logins are
+	// refused for LockoutDuration. Zero disables the lockout.
+	LockoutAttempts int
+	LockoutDuration time.Duration
+	// Window is how long failures are remembered after the last one.
+	Window time.Duration
+}
+
+var (
+	DefaultUserPolicy = ThrottlePolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutAttempts: 10,
+		LockoutDuration: 15 * time.Minute,
+		Window:          time.Hour,
+	}
+	DefaultIPPolicy = ThrottlePolicy{
+		FreeAttempts: 20,
+		BaseDelay:    time.Second,
+		MaxDelay:     15 * time.Minute,
+		Window:       time.Hour,
+	}
+)
+
+type Throttler interface {
+	// Allow returns a LockoutError if a login for the username or from
+	// the IP is not allowed at the moment.
+	Allow(username, ip string) error
+	Fail(username, ip string) error
+	Succeed(username string) error
+	Unlock(username, ip string) error
+}
+
+type throttler struct {
+	client inmem.Client
+	user   ThrottlePolicy
+	ip     ThrottlePolicy
+}
+
+func NewThrottler(c inmem.Client, user, ip ThrottlePolicy) Throttler {
+	return &throttler{client: c, user: user, ip: ip}
+}
+
+type attempts struct {
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+	Locked   bool      `json:"locked"`
+}
+
+func (t *throttler) Allow(username, ip string) error {
+	now := time.Now()
+	for _, key := range []string{userAttemptsKey(username), ipAttemptsKey(ip)} {
+		if key == "" {
+			continue
+		}
+
+		a, err := t.attempts(key)
+		if err != nil {
+			return err
+		}
+		if !now.Before(a.Until) {
+			continue
+		}
+		if a.Locked {
+			return authsvc.LockoutError{Err: authsvc.ErrAccountLocked, Until: a.Until}
+		}
+		return authsvc.LockoutError{Err: authsvc.ErrTooManyAttempts, Until: a.Until}
+	}
+	return nil
+}
+
+func (t *throttler) Fail(username, ip string) error {
+	if err := t.fail(userAttemptsKey(username), t.user); err != nil {
+		return err
+	}
+	if ip == "" {
+		return nil
+	}
+	return t.fail(ipAttemptsKey(ip), t.ip)
+}
+
+func (t *throttler) Succeed(username string) error {
+	return t.client.Delete(userAttemptsKey(username))
+}
+
+func (t *throttler) Unlock(username, ip string) error {
+	if username != "" {
+		if err := t.client.Delete(userAttemptsKey(username)); err != nil {
+			return err
+		}
+	}
+	if ip != "" {
+		if err := t.client.Delete(ipAttemptsKey(ip)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fail counts the failure atomically, so that concurrent failures are all
+// counted.
+func (t *throttler) fail(key string, p ThrottlePolicy) error {
+	return inmem.Update(t.client, key, func(value []byte) ([]byte, time.Duration, error) {
+		var a attempts
+		if value != nil {
+			if err := json.Unmarshal(value, &a); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		now := time.Now()
+		a.Failures++
+		switch {
+		case p.LockoutAttempts > 0 && a.Failures >= p.LockoutAttempts:
+			a.Locked = true
+			a.Until = now.Add(p.LockoutDuration)
+		case a.Failures > p.FreeAttempts:
+			a.Until = now.Add(backoff(p, a.Failures-p.FreeAttempts))
+		}
+
+		ttl := p.Window
+		if d := a.Until.Sub(now); d > ttl {
+			ttl = d
+		}
+
+		b, err := json.Marshal(a)
+		return b, ttl, err
+	})
+}
+
+func (t *throttler) attempts(key string) (attempts, error) {
+	var a attempts
+
+	b, err := t.client.Get(key)
+	if err == inmem.ErrKeyNotFound {
+		return a, nil
+	}
+	if err != nil {
+		return a, err
+	}
+
+	err = json.Unmarshal(b, &a)
+	return a, err
+}
+
+// backoff returns the delay for the nth penalized failure.
+func backoff(p ThrottlePolicy, n int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < n && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+func userAttemptsKey(username string) string {
+	return "login/user/" + username
+}
+
+// ipAttemptsKey returns an empty key for an unknown IP, as all such
+// requests would otherwise share the same counter.
+func ipAttemptsKey(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	return "login/ip/" + ip
+}
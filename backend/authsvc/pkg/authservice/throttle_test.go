@@ -0,0 +1,120 @@
+package authservice_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authservice"
+)
+
+var testPolicy = authservice.ThrottlePolicy{
+	FreeAttempts:    2,
+	BaseDelay:       time.Minute,
+	MaxDelay:        4 * time.Minute,
+	LockoutAttempts: 6,
+	LockoutDuration: time.Hour,
+	Window:          time.Hour,
+}
+
+// lockout returns the error and the remaining delay of a refused login.
+func lockout(t *testing.T, err error) (error, time.Duration) {
+	t.Helper()
+
+	var e authsvc.LockoutError
+	if !errors.As(err, &e) {
+		t.Fatalf("want a lockout error, got %v", err)
+	}
+	return e.Err, time.Until(e.Until)
+}
+
+// TestThrottleDelayGrows checks that the delay doubles with every failure
+// after the free attempts up to the maximum, and that the account is then
+// locked.
+func TestThrottleDelayGrows(t *testing.T) {
+	t.Parallel()
+
+	th := authservice.NewThrottler(inmem.NewMemoryClient(), testPolicy, authservice.ThrottlePolicy{})
+
+	for i, want := range []time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute} {
+		if err := th.Fail("ann", ""); err != nil {
+			t.Fatal(err)
+		}
+
+		err := th.Allow("ann", "")
+		if want == 0 {
+			if err != nil {
+				t.Errorf("failure %d: want the login allowed, got %v", i+1, err)
+			}
+			continue
+		}
+		reason, delay := lockout(t, err)
+		if reason != authsvc.ErrTooManyAttempts || delay > want || delay < want-time.Minute/2 {
+			t.Errorf("failure %d: want %v for %v, got %v for %v", i+1, authsvc.ErrTooManyAttempts, want, reason, delay)
+		}
+	}
+
+	if err := th.Fail("ann", ""); err != nil {
+		t.Fatal(err)
+	}
+	reason, delay := lockout(t, th.Allow("ann", ""))
+	if reason != authsvc.ErrAccountLocked || delay < testPolicy.LockoutDuration-time.Minute {
+		t.Errorf("failure %d: want %v for %v, got %v for %v", testPolicy.LockoutAttempts, authsvc.ErrAccountLocked, testPolicy.LockoutDuration, reason, delay)
+	}
+
+	if err := th.Allow("bob", ""); err != nil {
+		t.Errorf("want other users allowed, got %v", err)
+	}
+}
+
+// TestThrottleResets checks that a successful login clears the failures of
+// the user but not those of the IP, while Unlock clears both.
+func TestThrottleResets(t *testing.T) {
+	t.Parallel()
+
+	th := authservice.NewThrottler(inmem.NewMemoryClient(), testPolicy, testPolicy)
+	const ip = "192.0.2.1"
+
+	for i := 0; i < testPolicy.FreeAttempts+1; i++ {
+		if err := th.Fail("ann", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := th.Succeed("ann"); err != nil {
+		t.Fatal(err)
+	}
+	if err := th.Fail("ann", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := th.Allow("ann", ""); err != nil {
+		t.Errorf("want the failures before the success forgotten, got %v", err)
+	}
+
+	for i := 0; i < testPolicy.LockoutAttempts; i++ {
+		if err := th.Fail("bob", ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reason, _ := lockout(t, th.Allow("bob", "")); reason != authsvc.ErrAccountLocked {
+		t.Errorf("want the user locked, got %v", reason)
+	}
+	if reason, _ := lockout(t, th.Allow("carol", ip)); reason != authsvc.ErrAccountLocked {
+		t.Errorf("want the IP locked for every user, got %v", reason)
+	}
+
+	if err := th.Succeed("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if reason, _ := lockout(t, th.Allow("carol", ip)); reason != authsvc.ErrAccountLocked {
+		t.Errorf("want the IP still locked after a success, got %v", reason)
+	}
+
+	if err := th.Unlock("bob", ip); err != nil {
+		t.Fatal(err)
+	}
+	if err := th.Allow("bob", ip); err != nil {
+		t.Errorf("want the user and the IP unlocked, got %v", err)
+	}
+}
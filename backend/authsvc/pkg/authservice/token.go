@@ -1,41 +1,83 @@
 package authservice
 
 import (
-	"os"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
 	"github.com/twinj/uuid"
 )
 
 type AccessToken struct {
-	UUID string
-	Hash string
+	UUID    string
+	Hash    string
+	Expires time.Time
 }
 
 type RefreshToken struct {
 	AccessUUID  string
 	RefreshUUID string
 	Hash        string
+	Expires     time.Time
+}
+
+// MFAToken is issued instead of the token pair when the password was
+// correct but a second factor is still required.
+type MFAToken struct {
+	UUID    string
+	Hash    string
+	Expires time.Time
 }
 
 type Tokenizer interface {
-	Generate(userID uint64) (*AccessToken, *RefreshToken, error)
+	Generate(userID uint64, roles []string) (*AccessToken, *RefreshToken, error)
+	// GenerateScoped issues the pair of a third-party client, whose access
+	// token only grants the given scopes.
+	GenerateScoped(userID uint64, roles []string, clientID string, scopes []string) (*AccessToken, *RefreshToken, error)
+	GenerateMFA(userID uint64) (*MFAToken, error)
+	// PublicKeys returns the keys which the issued tokens are verified
+	// with.
+	PublicKeys() jwks.KeySet
+}
+
+const (
+	accessTokenTTL = time.Minute * 30
+	// refreshTokenTTL is the lifetime of the longest lived token.
+	refreshTokenTTL = time.Hour * 24 * 7
+)
+
+// tokenizer signs all tokens with the key of the provider. The claims tell
+// the kinds of token apart, e.g. a refresh token holds no "uuid" claim and
+// is therefore rejected wherever an access token is expected.
+type tokenizer struct {
+	keys KeyProvider
+}
+
+func NewTokenizer(keys KeyProvider) Tokenizer {
+	return &tokenizer{keys: keys}
 }
 
-type tokenizer struct{}
+func (t *tokenizer) Generate(userID uint64, roles []string) (*AccessToken, *RefreshToken, error) {
+	return t.generate(userID, roles, nil)
+}
 
-func NewTokenizer() Tokenizer {
-	return &tokenizer{}
+// GenerateScoped adds the "client_id" and the space separated "scope"
+// claims of RFC 8693 to the access token.
+func (t *tokenizer) GenerateScoped(userID uint64, roles []string, clientID string, scopes []string) (*AccessToken, *RefreshToken, error) {
+	return t.generate(userID, roles, jwt.MapClaims{
+		"client_id": clientID,
+		"scope":     strings.Join(scopes, " "),
+	})
 }
 
-func (t *tokenizer) Generate(userID uint64) (*AccessToken, *RefreshToken, error) {
-	access, err := generateAccessToken(userID)
+func (t *tokenizer) generate(userID uint64, roles []string, extra jwt.MapClaims) (*AccessToken, *RefreshToken, error) {
+	access, err := t.generateAccessToken(userID, roles, extra)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	refresh, err := generateRefreshToken(userID, access.UUID)
+	refresh, err := t.generateRefreshToken(userID, access.UUID)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -43,46 +85,83 @@ func (t *tokenizer) Generate(userID uint64) (*AccessToken, *RefreshToken, error)
 	return access, refresh, nil
 }
 
+// GenerateMFA signs the challenge with the access token key. It holds no
+// "uuid" claim, so it is rejected wherever an access token is expected.
+func (t *tokenizer) GenerateMFA(userID uint64) (*MFAToken, error) {
+	id := uuidV4().String()
+	expiry := time.Now().Add(time.Minute * 5)
+
+	claims := jwt.MapClaims{
+		"mfa_uuid": id,
+		"user_id":  userID,
+		"exp":      expiry.Unix(),
+	}
+
+	hash, err := t.sign(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MFAToken{id, hash, expiry}, nil
+}
+
+func (t *tokenizer) PublicKeys() jwks.KeySet {
+	return t.keys.PublicKeys()
+}
+
+// sign sets the "kid" header, so that the verifiers can pick the matching
+// key from the published key set.
+func (t *tokenizer) sign(claims jwt.MapClaims) (string, error) {
+	kid, method, key := t.keys.SigningKey()
+
+	tk := jwt.NewWithClaims(method, claims)
+	tk.Header["kid"] = kid
+
+	return tk.SignedString(key)
+}
+
 var (
 	uuidV4 = uuid.NewV4
 	uuidV5 = uuid.NewV5
 )
 
-func generateAccessToken(userID uint64) (*AccessToken, error) {
+func (t *tokenizer) generateAccessToken(userID uint64, roles []string, extra jwt.MapClaims) (*AccessToken, error) {
 	id := uuidV4().String()
-	expiry := time.Now().Add(time.Minute * 30).Unix()
+	expiry := time.Now().Add(accessTokenTTL)
 
 	claims := jwt.MapClaims{
 		"uuid":    id,
 		"user_id": userID,
-		"exp":     expiry,
+		"roles":   roles,
+		"exp":     expiry.Unix(),
+	}
+	for k, v := range extra {
+		claims[k] = v
 	}
 
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	hash, err := t.SignedString([]byte(os.Getenv("ACCESS_SECRET")))
+	hash, err := t.sign(claims)
 	if err != nil {
 		return nil, err
 	}
 
-	return &AccessToken{id, hash}, nil
+	return &AccessToken{id, hash, expiry}, nil
 }
 
-func generateRefreshToken(userID uint64, accessUUID string) (*RefreshToken, error) {
+func (t *tokenizer) generateRefreshToken(userID uint64, accessUUID string) (*RefreshToken, error) {
 	refreshUUID := uuidV5(uuid.NameSpaceURL, accessUUID).String()
-	expiry := time.Now().Add(time.Hour * 24 * 7).Unix()
+	expiry := time.Now().Add(refreshTokenTTL)
 
 	claims := jwt.MapClaims{
 		"access_uuid":  accessUUID,
 		"refresh_uuid": refreshUUID,
 		"user_id":      userID,
-		"exp":          expiry,
+		"exp":          expiry.Unix(),
 	}
 
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	hash, err := t.SignedString([]byte(os.Getenv("REFRESH_SECRET")))
+	hash, err := t.sign(claims)
 	if err != nil {
 		return nil, err
 	}
 
-	return &RefreshToken{accessUUID, refreshUUID, hash}, nil
+	return &RefreshToken{accessUUID, refreshUUID, hash, expiry}, nil
 }
@@ -3,12 +3,16 @@ package authtransport
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
-	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -22,7 +26,12 @@ import (
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
 	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/authz"
 	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oidc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
 	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authendpoint"
 	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authservice"
 	"github.com/ichigozero/gtdkit/backend/usersvc"
@@ -31,7 +40,9 @@ import (
 	"golang.org/x/time/rate"
 )
 
-func NewHTTPHandler(endpoints authendpoint.Set, client inmem.Client, logger log.Logger) http.Handler {
+// NewHTTPHandler verifies the access tokens with the keys resolved by the
+// key cache, whether it is served by authsvc or by the gateway.
+func NewHTTPHandler(endpoints authendpoint.Set, keys *jwks.Cache, logger log.Logger) http.Handler {
 	options := []httptransport.ServerOption{
 		httptransport.ServerErrorEncoder(errorEncoder),
 		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
@@ -41,19 +52,15 @@ func NewHTTPHandler(endpoints authendpoint.Set, client inmem.Client, logger log.
 		endpoints.LoginEndpoint,
 		decodeHTTPLoginRequest,
 		encodeHTTPGenericResponse,
-		options...,
+		append(options, httptransport.ServerBefore(clientIPToContext, userAgentToContext))...,
 	)
 
 	var logoutEndpoint endpoint.Endpoint
 	{
-		kf := func(token *stdjwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("ACCESS_SECRET")), nil
-		}
-
 		logoutEndpoint = endpoints.LogoutEndpoint
 		logoutEndpoint = kitjwt.NewParser(
-			kf,
-			stdjwt.SigningMethodHS256,
+			keys.Keyfunc,
+			keys.Method(),
 			kitjwt.MapClaimsFactory,
 		)(logoutEndpoint)
 	}
@@ -67,14 +74,10 @@ func NewHTTPHandler(endpoints authendpoint.Set, client inmem.Client, logger log.
 
 	var refreshEndpoint endpoint.Endpoint
 	{
-		kf := func(token *stdjwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("REFRESH_SECRET")), nil
-		}
-
 		refreshEndpoint = endpoints.RefreshEndpoint
 		refreshEndpoint = kitjwt.NewParser(
-			kf,
-			stdjwt.SigningMethodHS256,
+			keys.Keyfunc,
+			keys.Method(),
 			kitjwt.MapClaimsFactory,
 		)(refreshEndpoint)
 	}
@@ -83,31 +86,445 @@ func NewHTTPHandler(endpoints authendpoint.Set, client inmem.Client, logger log.
 		refreshEndpoint,
 		decodeHTTPRefreshRequest,
 		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext(), clientIPToContext, userAgentToContext))...,
+	)
+
+	var sessionsEndpoint endpoint.Endpoint
+	{
+		sessionsEndpoint = endpoints.SessionsEndpoint
+		sessionsEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(sessionsEndpoint)
+	}
+
+	sessionsHandler := httptransport.NewServer(
+		sessionsEndpoint,
+		decodeHTTPSessionsRequest,
+		encodeHTTPGenericResponse,
 		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
 	)
 
-	validateHandler := httptransport.NewServer(
-		endpoints.ValidateEndpoint,
-		decodeHTTPValidateRequest,
+	var revokeSessionEndpoint endpoint.Endpoint
+	{
+		revokeSessionEndpoint = endpoints.RevokeSessionEndpoint
+		revokeSessionEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(revokeSessionEndpoint)
+	}
+
+	revokeSessionHandler := httptransport.NewServer(
+		revokeSessionEndpoint,
+		decodeHTTPRevokeSessionRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
+	var revokeAllSessionsEndpoint endpoint.Endpoint
+	{
+		revokeAllSessionsEndpoint = endpoints.RevokeAllSessionsEndpoint
+		revokeAllSessionsEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(revokeAllSessionsEndpoint)
+	}
+
+	revokeAllSessionsHandler := httptransport.NewServer(
+		revokeAllSessionsEndpoint,
+		decodeHTTPRevokeAllSessionsRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
+	var verifyMFAEndpoint endpoint.Endpoint
+	{
+		verifyMFAEndpoint = endpoints.VerifyMFAEndpoint
+		verifyMFAEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(verifyMFAEndpoint)
+	}
+
+	verifyMFAHandler := httptransport.NewServer(
+		verifyMFAEndpoint,
+		decodeHTTPVerifyMFARequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext(), clientIPToContext, userAgentToContext))...,
+	)
+
+	var enrollTOTPEndpoint endpoint.Endpoint
+	{
+		enrollTOTPEndpoint = endpoints.EnrollTOTPEndpoint
+		enrollTOTPEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(enrollTOTPEndpoint)
+	}
+
+	enrollTOTPHandler := httptransport.NewServer(
+		enrollTOTPEndpoint,
+		decodeHTTPEnrollTOTPRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
+	var confirmTOTPEndpoint endpoint.Endpoint
+	{
+		confirmTOTPEndpoint = endpoints.ConfirmTOTPEndpoint
+		confirmTOTPEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(confirmTOTPEndpoint)
+	}
+
+	confirmTOTPHandler := httptransport.NewServer(
+		confirmTOTPEndpoint,
+		decodeHTTPConfirmTOTPRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
+	var disableTOTPEndpoint endpoint.Endpoint
+	{
+		disableTOTPEndpoint = endpoints.DisableTOTPEndpoint
+		disableTOTPEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(disableTOTPEndpoint)
+	}
+
+	disableTOTPHandler := httptransport.NewServer(
+		disableTOTPEndpoint,
+		decodeHTTPDisableTOTPRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
+	jwksHandler := httptransport.NewServer(
+		endpoints.JWKSEndpoint,
+		decodeHTTPJWKSRequest,
+		encodeHTTPJWKSResponse,
+		options...,
+	)
+
+	changePasswordHandler := httptransport.NewServer(
+		endpoints.ChangePasswordEndpoint,
+		decodeHTTPChangePasswordRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(clientIPToContext))...,
+	)
+
+	var registerClientEndpoint endpoint.Endpoint
+	{
+		registerClientEndpoint = endpoints.RegisterClientEndpoint
+		registerClientEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(registerClientEndpoint)
+	}
+
+	registerClientHandler := httptransport.NewServer(
+		registerClientEndpoint,
+		decodeHTTPRegisterClientRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
+	clientHandler := httptransport.NewServer(
+		endpoints.ClientEndpoint,
+		decodeHTTPClientRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	)
+
+	var authorizeEndpoint endpoint.Endpoint
+	{
+		authorizeEndpoint = endpoints.AuthorizeEndpoint
+		authorizeEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(authorizeEndpoint)
+	}
+
+	authorizeHandler := httptransport.NewServer(
+		authorizeEndpoint,
+		decodeHTTPAuthorizeRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
+	var tokenEndpoint endpoint.Endpoint
+	{
+		tokenEndpoint = endpoints.TokenEndpoint
+		tokenEndpoint = refreshTokenParser(keys)(tokenEndpoint)
+	}
+
+	tokenHandler := httptransport.NewServer(
+		tokenEndpoint,
+		decodeHTTPTokenRequest,
+		encodeHTTPTokenResponse,
+		append(options, httptransport.ServerBefore(refreshTokenToContext, clientIPToContext, userAgentToContext))...,
+	)
+
+	var introspectEndpoint endpoint.Endpoint
+	{
+		introspectEndpoint = endpoints.IntrospectEndpoint
+		introspectEndpoint = tokenParser(keys)(introspectEndpoint)
+	}
+
+	// The callers must authenticate as resource servers, see
+	// requireResourceServer.
+	introspectHandler := requireResourceServer(keys, httptransport.NewServer(
+		introspectEndpoint,
+		decodeHTTPIntrospectRequest,
+		encodeHTTPIntrospectResponse,
+		append(options, httptransport.ServerBefore(tokenToContext, callerTokenToContext))...,
+	))
+
+	var revokeTokenEndpoint endpoint.Endpoint
+	{
+		revokeTokenEndpoint = endpoints.RevokeTokenEndpoint
+		revokeTokenEndpoint = tokenParser(keys)(revokeTokenEndpoint)
+	}
+
+	revokeTokenHandler := httptransport.NewServer(
+		revokeTokenEndpoint,
+		decodeHTTPRevokeTokenRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(tokenToContext))...,
+	)
+
+	var consentsEndpoint endpoint.Endpoint
+	{
+		consentsEndpoint = endpoints.ConsentsEndpoint
+		consentsEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(consentsEndpoint)
+	}
+
+	consentsHandler := httptransport.NewServer(
+		consentsEndpoint,
+		decodeHTTPConsentsRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
+	var revokeConsentEndpoint endpoint.Endpoint
+	{
+		revokeConsentEndpoint = endpoints.RevokeConsentEndpoint
+		revokeConsentEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(revokeConsentEndpoint)
+	}
+
+	revokeConsentHandler := httptransport.NewServer(
+		revokeConsentEndpoint,
+		decodeHTTPRevokeConsentRequest,
 		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
+	oidcLoginHandler := httptransport.NewServer(
+		endpoints.OIDCLoginEndpoint,
+		decodeHTTPOIDCLoginRequest,
+		encodeHTTPOIDCLoginResponse,
 		options...,
 	)
 
+	oidcCallbackHandler := httptransport.NewServer(
+		endpoints.OIDCCallbackEndpoint,
+		decodeHTTPOIDCCallbackRequest,
+		encodeHTTPOIDCCallbackResponse,
+		append(options, httptransport.ServerBefore(clientIPToContext, userAgentToContext))...,
+	)
+
+	var createPATEndpoint endpoint.Endpoint
+	{
+		createPATEndpoint = endpoints.CreatePATEndpoint
+		createPATEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(createPATEndpoint)
+	}
+
+	createPATHandler := httptransport.NewServer(
+		createPATEndpoint,
+		decodeHTTPCreatePATRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
+	var patsEndpoint endpoint.Endpoint
+	{
+		patsEndpoint = endpoints.PATsEndpoint
+		patsEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(patsEndpoint)
+	}
+
+	patsHandler := httptransport.NewServer(
+		patsEndpoint,
+		decodeHTTPPATsRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
+	var revokePATEndpoint endpoint.Endpoint
+	{
+		revokePATEndpoint = endpoints.RevokePATEndpoint
+		revokePATEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(revokePATEndpoint)
+	}
+
+	revokePATHandler := httptransport.NewServer(
+		revokePATEndpoint,
+		decodeHTTPRevokePATRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
 	r := mux.NewRouter()
 
 	r.Methods("POST").Path("/login").Handler(loginHandler)
 	r.Methods("POST").Path("/logout").Handler(logoutHandler)
 	r.Methods("POST").Path("/refresh").Handler(refreshHandler)
-	r.Methods("GET").Path("/validate").Handler(validateHandler)
+	r.Methods("POST").Path("/introspect").Handler(introspectHandler)
+	r.Methods("POST").Path("/revoke").Handler(revokeTokenHandler)
+	r.Methods("GET").Path("/sessions").Handler(sessionsHandler)
+	r.Methods("DELETE").Path("/sessions").Handler(revokeAllSessionsHandler)
+	r.Methods("DELETE").Path("/sessions/{id}").Handler(revokeSessionHandler)
+	r.Methods("POST").Path("/mfa/verify").Handler(verifyMFAHandler)
+	r.Methods("POST").Path("/mfa/totp/enroll").Handler(enrollTOTPHandler)
+	r.Methods("POST").Path("/mfa/totp/confirm").Handler(confirmTOTPHandler)
+	r.Methods("POST").Path("/mfa/totp/disable").Handler(disableTOTPHandler)
+	r.Methods("POST").Path("/password").Handler(changePasswordHandler)
+	r.Methods("GET").Path("/.well-known/jwks.json").Handler(jwksHandler)
+	r.Methods("POST").Path("/oauth/clients").Handler(registerClientHandler)
+	r.Methods("GET").Path("/oauth/clients/{id}").Handler(clientHandler)
+	r.Methods("POST").Path("/oauth/authorize").Handler(authorizeHandler)
+	r.Methods("POST").Path("/oauth/token").Handler(tokenHandler)
+	r.Methods("GET").Path("/oauth/consents").Handler(consentsHandler)
+	r.Methods("DELETE").Path("/oauth/consents/{client_id}").Handler(revokeConsentHandler)
+	r.Methods("GET").Path("/oidc/{provider}/login").Handler(oidcLoginHandler)
+	r.Methods("POST").Path("/oidc/{provider}/callback").Handler(oidcCallbackHandler)
+	r.Methods("POST").Path("/pats").Handler(createPATHandler)
+	r.Methods("GET").Path("/pats").Handler(patsHandler)
+	r.Methods("DELETE").Path("/pats/{id}").Handler(revokePATHandler)
 	r.Methods("GET").Path("/metrics").Handler(promhttp.Handler())
 
 	return r
 }
 
-func NewHTTPClient(instance string, logger log.Logger) (authservice.Service, error) {
+// NewAdminHTTPHandler returns the handler of the operations which must not be
+// reachable by end users. It is served by authsvc only, on a listener of its
+// own, and is not mounted by the gateway. Unlock and RevokeUserSessions require the access token of an
+// administrator, the others are called by the services: Validate and
+// ResolvePAT authenticate the callers of the gateway and of tasksvc.
+func NewAdminHTTPHandler(endpoints authendpoint.Set, keys *jwks.Cache, logger log.Logger) http.Handler {
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(errorEncoder),
+		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+	}
+
+	var unlockEndpoint endpoint.Endpoint
+	{
+		unlockEndpoint = endpoints.UnlockEndpoint
+		unlockEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(unlockEndpoint)
+	}
+
+	unlockHandler := httptransport.NewServer(
+		unlockEndpoint,
+		decodeHTTPUnlockRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
+	var revokeUserSessionsEndpoint endpoint.Endpoint
+	{
+		revokeUserSessionsEndpoint = endpoints.RevokeUserSessionsEndpoint
+		revokeUserSessionsEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(revokeUserSessionsEndpoint)
+	}
+
+	// The sessions are revoked by usersvc on behalf of the administrator.
+	revokeUserSessionsHandler := httptransport.NewServer(
+		revokeUserSessionsEndpoint,
+		decodeHTTPRevokeUserSessionsRequest,
+		encodeHTTPGenericResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
+	validateHandler := httptransport.NewServer(
+		endpoints.ValidateEndpoint,
+		decodeHTTPValidateRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	)
+
+	resolvePATHandler := httptransport.NewServer(
+		endpoints.ResolvePATEndpoint,
+		decodeHTTPResolvePATRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	)
+
+	// The revocations are read by the other services to drop the tokens
+	// they cached the validation of.
+	revocationsHandler := httptransport.NewServer(
+		endpoints.RevocationsEndpoint,
+		decodeHTTPRevocationsRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	)
+
+	r := mux.NewRouter()
+
+	r.Methods("POST").Path("/unlock").Handler(unlockHandler)
+	r.Methods("POST").Path("/sessions/revoke").Handler(revokeUserSessionsHandler)
+	r.Methods("POST").Path("/revocations").Handler(revocationsHandler)
+	r.Methods("GET").Path("/validate").Handler(validateHandler)
+	r.Methods("POST").Path("/pats/resolve").Handler(resolvePATHandler)
+
+	return r
+}
+
+// NewHTTPClient returns a client of the instance, which is called over TLS
+// if tlsConfig is not nil.
+func NewHTTPClient(instance string, tlsConfig *tls.Config, logger log.Logger) (authservice.Service, error) {
 	// Quickly sanitize the instance string.
 	if !strings.HasPrefix(instance, "http") {
-		instance = "http://" + instance
+		if tlsConfig != nil {
+			instance = "https://" + instance
+		} else {
+			instance = "http://" + instance
+		}
 	}
 	u, err := url.Parse(instance)
 	if err != nil {
@@ -117,6 +534,11 @@ func NewHTTPClient(instance string, logger log.Logger) (authservice.Service, err
 	limiter := ratelimit.NewErroringLimiter(rate.NewLimiter(rate.Every(time.Second), 100))
 
 	var options []httptransport.ClientOption
+	if tlsConfig != nil {
+		options = append(options, httptransport.SetClient(&http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		}))
+	}
 
 	var loginEndpoint endpoint.Endpoint
 	{
@@ -125,7 +547,7 @@ func NewHTTPClient(instance string, logger log.Logger) (authservice.Service, err
 			copyURL(u, "/login"),
 			encodeHTTPGenericRequest,
 			decodeHTTPLoginResponse,
-			options...,
+			append(options, httptransport.ClientBefore(clientIPToHTTP, userAgentToHTTP))...,
 		).Endpoint()
 		// TODO opentracing
 		loginEndpoint = limiter(loginEndpoint)
@@ -158,7 +580,7 @@ func NewHTTPClient(instance string, logger log.Logger) (authservice.Service, err
 			copyURL(u, "/refresh"),
 			encodeHTTPGenericRequest,
 			decodeHTTPRefreshResponse,
-			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP(), clientIPToHTTP, userAgentToHTTP))...,
 		).Endpoint()
 		refreshEndpoint = limiter(refreshEndpoint)
 		refreshEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
@@ -171,7 +593,7 @@ func NewHTTPClient(instance string, logger log.Logger) (authservice.Service, err
 	{
 		validateEndpoint = httptransport.NewClient(
 			"GET",
-			copyURL(u, "/validate"),
+			copyURL(u, "/admin/validate"),
 			encodeHTTPGenericRequest,
 			decodeHTTPValidateResponse,
 			options...,
@@ -183,111 +605,1411 @@ func NewHTTPClient(instance string, logger log.Logger) (authservice.Service, err
 		}))(validateEndpoint)
 	}
 
-	return authendpoint.Set{
-		LoginEndpoint:    loginEndpoint,
-		LogoutEndpoint:   logoutEndpoint,
-		RefreshEndpoint:  refreshEndpoint,
-		ValidateEndpoint: validateEndpoint,
-	}, nil
-}
-
-func copyURL(base *url.URL, path string) *url.URL {
-	next := *base
-	next.Path = path
-	return &next
-}
-
-func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
-	w.WriteHeader(err2code(err))
-	json.NewEncoder(w).Encode(errorWrapper{Error: err.Error()})
-}
+	var sessionsEndpoint endpoint.Endpoint
+	{
+		sessionsEndpoint = httptransport.NewClient(
+			"GET",
+			copyURL(u, "/sessions"),
+			encodeHTTPGenericRequest,
+			decodeHTTPSessionsResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		sessionsEndpoint = limiter(sessionsEndpoint)
+		sessionsEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "Sessions",
+			Timeout: 30 * time.Second,
+		}))(sessionsEndpoint)
+	}
 
-func err2code(err error) int {
-	switch err {
-	case kitjwt.ErrTokenExpired, usersvc.ErrUserNotFound, authsvc.ErrUserIDContextMissing, inmem.ErrKeyNotFound:
-		return http.StatusUnauthorized
-	case usersvc.ErrInvalidArgument, authsvc.ErrInvalidArgument:
-		return http.StatusBadRequest
+	var revokeSessionEndpoint endpoint.Endpoint
+	{
+		revokeSessionEndpoint = httptransport.NewClient(
+			"DELETE",
+			copyURL(u, "/sessions"),
+			encodeHTTPRevokeSessionRequest,
+			decodeHTTPRevokeSessionResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		revokeSessionEndpoint = limiter(revokeSessionEndpoint)
+		revokeSessionEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "RevokeSession",
+			Timeout: 30 * time.Second,
+		}))(revokeSessionEndpoint)
 	}
-	return http.StatusInternalServerError
-}
 
-func errorDecoder(r *http.Response) error {
-	var w errorWrapper
-	if err := json.NewDecoder(r.Body).Decode(&w); err != nil {
-		return err
+	var revokeAllSessionsEndpoint endpoint.Endpoint
+	{
+		revokeAllSessionsEndpoint = httptransport.NewClient(
+			"DELETE",
+			copyURL(u, "/sessions"),
+			encodeHTTPGenericRequest,
+			decodeHTTPRevokeAllSessionsResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		revokeAllSessionsEndpoint = limiter(revokeAllSessionsEndpoint)
+		revokeAllSessionsEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "RevokeAllSessions",
+			Timeout: 30 * time.Second,
+		}))(revokeAllSessionsEndpoint)
 	}
 
-	switch w.Error {
-	case authsvc.ErrInvalidArgument.Error():
-		return authsvc.ErrInvalidArgument
-	case usersvc.ErrUserNotFound.Error():
-		return usersvc.ErrUserNotFound
-	case authsvc.ErrUserIDContextMissing.Error():
-		return authsvc.ErrUserIDContextMissing
-	case inmem.ErrKeyNotFound.Error():
-		return inmem.ErrKeyNotFound
+	var unlockEndpoint endpoint.Endpoint
+	{
+		unlockEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/admin/unlock"),
+			encodeHTTPGenericRequest,
+			decodeHTTPUnlockResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		unlockEndpoint = limiter(unlockEndpoint)
+		unlockEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "Unlock",
+			Timeout: 30 * time.Second,
+		}))(unlockEndpoint)
 	}
 
-	return errors.New(w.Error)
-}
+	var revokeUserSessionsEndpoint endpoint.Endpoint
+	{
+		revokeUserSessionsEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/admin/sessions/revoke"),
+			encodeHTTPGenericRequest,
+			decodeHTTPRevokeUserSessionsResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		revokeUserSessionsEndpoint = limiter(revokeUserSessionsEndpoint)
+		revokeUserSessionsEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "RevokeUserSessions",
+			Timeout: 30 * time.Second,
+		}))(revokeUserSessionsEndpoint)
+	}
+
+	var verifyMFAEndpoint endpoint.Endpoint
+	{
+		verifyMFAEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/mfa/verify"),
+			encodeHTTPGenericRequest,
+			decodeHTTPVerifyMFAResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP(), clientIPToHTTP, userAgentToHTTP))...,
+		).Endpoint()
+		verifyMFAEndpoint = limiter(verifyMFAEndpoint)
+		verifyMFAEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "VerifyMFA",
+			Timeout: 30 * time.Second,
+		}))(verifyMFAEndpoint)
+	}
+
+	var enrollTOTPEndpoint endpoint.Endpoint
+	{
+		enrollTOTPEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/mfa/totp/enroll"),
+			encodeHTTPGenericRequest,
+			decodeHTTPEnrollTOTPResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		enrollTOTPEndpoint = limiter(enrollTOTPEndpoint)
+		enrollTOTPEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "EnrollTOTP",
+			Timeout: 30 * time.Second,
+		}))(enrollTOTPEndpoint)
+	}
+
+	var confirmTOTPEndpoint endpoint.Endpoint
+	{
+		confirmTOTPEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/mfa/totp/confirm"),
+			encodeHTTPGenericRequest,
+			decodeHTTPConfirmTOTPResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		confirmTOTPEndpoint = limiter(confirmTOTPEndpoint)
+		confirmTOTPEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "ConfirmTOTP",
+			Timeout: 30 * time.Second,
+		}))(confirmTOTPEndpoint)
+	}
+
+	var disableTOTPEndpoint endpoint.Endpoint
+	{
+		disableTOTPEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/mfa/totp/disable"),
+			encodeHTTPGenericRequest,
+			decodeHTTPDisableTOTPResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		disableTOTPEndpoint = limiter(disableTOTPEndpoint)
+		disableTOTPEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "DisableTOTP",
+			Timeout: 30 * time.Second,
+		}))(disableTOTPEndpoint)
+	}
+
+	var changePasswordEndpoint endpoint.Endpoint
+	{
+		changePasswordEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/password"),
+			encodeHTTPGenericRequest,
+			decodeHTTPChangePasswordResponse,
+			append(options, httptransport.ClientBefore(clientIPToHTTP))...,
+		).Endpoint()
+		changePasswordEndpoint = limiter(changePasswordEndpoint)
+		changePasswordEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "ChangePassword",
+			Timeout: 30 * time.Second,
+		}))(changePasswordEndpoint)
+	}
+
+	var jwksEndpoint endpoint.Endpoint
+	{
+		jwksEndpoint = httptransport.NewClient(
+			"GET",
+			copyURL(u, "/.well-known/jwks.json"),
+			encodeHTTPGenericRequest,
+			decodeHTTPJWKSResponse,
+			options...,
+		).Endpoint()
+		jwksEndpoint = limiter(jwksEndpoint)
+		jwksEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "JWKS",
+			Timeout: 30 * time.Second,
+		}))(jwksEndpoint)
+	}
+
+	var registerClientEndpoint endpoint.Endpoint
+	{
+		registerClientEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/oauth/clients"),
+			encodeHTTPGenericRequest,
+			decodeHTTPRegisterClientResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		registerClientEndpoint = limiter(registerClientEndpoint)
+		registerClientEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "RegisterClient",
+			Timeout: 30 * time.Second,
+		}))(registerClientEndpoint)
+	}
+
+	var clientEndpoint endpoint.Endpoint
+	{
+		clientEndpoint = httptransport.NewClient(
+			"GET",
+			copyURL(u, "/oauth/clients"),
+			encodeHTTPClientRequest,
+			decodeHTTPClientResponse,
+			options...,
+		).Endpoint()
+		clientEndpoint = limiter(clientEndpoint)
+		clientEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "Client",
+			Timeout: 30 * time.Second,
+		}))(clientEndpoint)
+	}
+
+	var authorizeEndpoint endpoint.Endpoint
+	{
+		authorizeEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/oauth/authorize"),
+			encodeHTTPGenericRequest,
+			decodeHTTPAuthorizeResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		authorizeEndpoint = limiter(authorizeEndpoint)
+		authorizeEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "Authorize",
+			Timeout: 30 * time.Second,
+		}))(authorizeEndpoint)
+	}
+
+	var tokenEndpoint endpoint.Endpoint
+	{
+		tokenEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/oauth/token"),
+			encodeHTTPTokenRequest,
+			decodeHTTPTokenResponse,
+			append(options, httptransport.ClientBefore(clientIPToHTTP, userAgentToHTTP))...,
+		).Endpoint()
+		tokenEndpoint = limiter(tokenEndpoint)
+		tokenEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "Token",
+			Timeout: 30 * time.Second,
+		}))(tokenEndpoint)
+	}
+
+	var introspectEndpoint endpoint.Endpoint
+	{
+		introspectEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/introspect"),
+			encodeHTTPIntrospectRequest,
+			decodeHTTPIntrospectResponse,
+			append(options, httptransport.ClientBefore(callerTokenToHTTP))...,
+		).Endpoint()
+		introspectEndpoint = limiter(introspectEndpoint)
+		introspectEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "Introspect",
+			Timeout: 30 * time.Second,
+		}))(introspectEndpoint)
+	}
+
+	var revokeTokenEndpoint endpoint.Endpoint
+	{
+		revokeTokenEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/revoke"),
+			encodeHTTPRevokeTokenRequest,
+			decodeHTTPRevokeTokenResponse,
+			options...,
+		).Endpoint()
+		revokeTokenEndpoint = limiter(revokeTokenEndpoint)
+		revokeTokenEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "RevokeToken",
+			Timeout: 30 * time.Second,
+		}))(revokeTokenEndpoint)
+	}
+
+	var consentsEndpoint endpoint.Endpoint
+	{
+		consentsEndpoint = httptransport.NewClient(
+			"GET",
+			copyURL(u, "/oauth/consents"),
+			encodeHTTPGenericRequest,
+			decodeHTTPConsentsResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		consentsEndpoint = limiter(consentsEndpoint)
+		consentsEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "Consents",
+			Timeout: 30 * time.Second,
+		}))(consentsEndpoint)
+	}
+
+	var revokeConsentEndpoint endpoint.Endpoint
+	{
+		revokeConsentEndpoint = httptransport.NewClient(
+			"DELETE",
+			copyURL(u, "/oauth/consents"),
+			encodeHTTPRevokeConsentRequest,
+			decodeHTTPRevokeConsentResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		revokeConsentEndpoint = limiter(revokeConsentEndpoint)
+		revokeConsentEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "RevokeConsent",
+			Timeout: 30 * time.Second,
+		}))(revokeConsentEndpoint)
+	}
+
+	var oidcLoginEndpoint endpoint.Endpoint
+	{
+		oidcLoginEndpoint = httptransport.NewClient(
+			"GET",
+			copyURL(u, "/oidc"),
+			encodeHTTPOIDCLoginRequest,
+			decodeHTTPOIDCLoginResponse,
+			options...,
+		).Endpoint()
+		oidcLoginEndpoint = limiter(oidcLoginEndpoint)
+		oidcLoginEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "OIDCLogin",
+			Timeout: 30 * time.Second,
+		}))(oidcLoginEndpoint)
+	}
+
+	var oidcCallbackEndpoint endpoint.Endpoint
+	{
+		oidcCallbackEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/oidc"),
+			encodeHTTPOIDCCallbackRequest,
+			decodeHTTPOIDCCallbackResponse,
+			append(options, httptransport.ClientBefore(clientIPToHTTP, userAgentToHTTP))...,
+		).Endpoint()
+		oidcCallbackEndpoint = limiter(oidcCallbackEndpoint)
+		oidcCallbackEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "OIDCCallback",
+			Timeout: 30 * time.Second,
+		}))(oidcCallbackEndpoint)
+	}
+
+	var createPATEndpoint endpoint.Endpoint
+	{
+		createPATEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/pats"),
+			encodeHTTPGenericRequest,
+			decodeHTTPCreatePATResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		createPATEndpoint = limiter(createPATEndpoint)
+		createPATEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "CreatePAT",
+			Timeout: 30 * time.Second,
+		}))(createPATEndpoint)
+	}
+
+	var patsEndpoint endpoint.Endpoint
+	{
+		patsEndpoint = httptransport.NewClient(
+			"GET",
+			copyURL(u, "/pats"),
+			encodeHTTPGenericRequest,
+			decodeHTTPPATsResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		patsEndpoint = limiter(patsEndpoint)
+		patsEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "PATs",
+			Timeout: 30 * time.Second,
+		}))(patsEndpoint)
+	}
+
+	var revokePATEndpoint endpoint.Endpoint
+	{
+		revokePATEndpoint = httptransport.NewClient(
+			"DELETE",
+			copyURL(u, "/pats"),
+			encodeHTTPRevokePATRequest,
+			decodeHTTPRevokePATResponse,
+			append(options, httptransport.ClientBefore(kitjwt.ContextToHTTP()))...,
+		).Endpoint()
+		revokePATEndpoint = limiter(revokePATEndpoint)
+		revokePATEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "RevokePAT",
+			Timeout: 30 * time.Second,
+		}))(revokePATEndpoint)
+	}
+
+	var resolvePATEndpoint endpoint.Endpoint
+	{
+		resolvePATEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/admin/pats/resolve"),
+			encodeHTTPGenericRequest,
+			decodeHTTPResolvePATResponse,
+			options...,
+		).Endpoint()
+		resolvePATEndpoint = limiter(resolvePATEndpoint)
+		resolvePATEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "ResolvePAT",
+			Timeout: 30 * time.Second,
+		}))(resolvePATEndpoint)
+	}
+
+	// The admin operations are served under /admin by the internal
+	// listener of authsvc. The circuit breaker must outlast the wait for
+	// a revocation.
+	var revocationsEndpoint endpoint.Endpoint
+	{
+		revocationsEndpoint = httptransport.NewClient(
+			"POST",
+			copyURL(u, "/admin/revocations"),
+			encodeHTTPGenericRequest,
+			decodeHTTPRevocationsResponse,
+			options...,
+		).Endpoint()
+		revocationsEndpoint = limiter(revocationsEndpoint)
+		revocationsEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "Revocations",
+			Timeout: 30 * time.Second,
+		}))(revocationsEndpoint)
+	}
+
+	return authendpoint.Set{
+		LoginEndpoint:       loginEndpoint,
+		LogoutEndpoint:      logoutEndpoint,
+		RefreshEndpoint:     refreshEndpoint,
+		ValidateEndpoint:    validateEndpoint,
+		SessionsEndpoint:    sessionsEndpoint,
+		UnlockEndpoint:      unlockEndpoint,
+		VerifyMFAEndpoint:   verifyMFAEndpoint,
+		EnrollTOTPEndpoint:  enrollTOTPEndpoint,
+		ConfirmTOTPEndpoint: confirmTOTPEndpoint,
+		DisableTOTPEndpoint: disableTOTPEndpoint,
+
+		RevokeSessionEndpoint:      revokeSessionEndpoint,
+		RevokeAllSessionsEndpoint:  revokeAllSessionsEndpoint,
+		RevokeUserSessionsEndpoint: revokeUserSessionsEndpoint,
+
+		ChangePasswordEndpoint: changePasswordEndpoint,
+
+		JWKSEndpoint: jwksEndpoint,
+
+		RegisterClientEndpoint: registerClientEndpoint,
+		ClientEndpoint:         clientEndpoint,
+		AuthorizeEndpoint:      authorizeEndpoint,
+		TokenEndpoint:          tokenEndpoint,
+		ConsentsEndpoint:       consentsEndpoint,
+		RevokeConsentEndpoint:  revokeConsentEndpoint,
+		IntrospectEndpoint:     introspectEndpoint,
+		RevokeTokenEndpoint:    revokeTokenEndpoint,
+
+		OIDCLoginEndpoint:    oidcLoginEndpoint,
+		OIDCCallbackEndpoint: oidcCallbackEndpoint,
+
+		CreatePATEndpoint:  createPATEndpoint,
+		PATsEndpoint:       patsEndpoint,
+		RevokePATEndpoint:  revokePATEndpoint,
+		ResolvePATEndpoint: resolvePATEndpoint,
+
+		RevocationsEndpoint: revocationsEndpoint,
+	}, nil
+}
+
+func copyURL(base *url.URL, path string) *url.URL {
+	next := *base
+	next.Path = path
+	return &next
+}
+
+// clientIPToContext stores the client IP in the context. The first address
+// of X-Forwarded-For is preferred, which the gateway sets to the address of
+// the connection it accepted.
+func clientIPToContext(ctx context.Context, r *http.Request) context.Context {
+	ip := strings.TrimSpace(strings.Split(r.Header.Get("X-Forwarded-For"), ",")[0])
+	if ip == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err == nil {
+			ip = host
+		}
+	}
+	return context.WithValue(ctx, authsvc.ClientIPContextKey, ip)
+}
+
+// clientIPToHTTP forwards the client IP found in the context to authsvc.
+func clientIPToHTTP(ctx context.Context, r *http.Request) context.Context {
+	if ip, ok := ctx.Value(authsvc.ClientIPContextKey).(string); ok && ip != "" {
+		r.Header.Set("X-Forwarded-For", ip)
+	}
+	return ctx
+}
+
+// userAgentToContext stores the user agent in the context, which describes
+// the device in the session listing.
+func userAgentToContext(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, authsvc.UserAgentContextKey, r.UserAgent())
+}
+
+// userAgentToHTTP forwards the user agent found in the context to authsvc.
+func userAgentToHTTP(ctx context.Context, r *http.Request) context.Context {
+	if ua, ok := ctx.Value(authsvc.UserAgentContextKey).(string); ok && ua != "" {
+		r.Header.Set("User-Agent", ua)
+	}
+	return ctx
+}
+
+// refreshTokenToContext stores the refresh token of the refresh token grant
+// in the context, where the JWT parser expects it. The token is sent in the
+// form body, as required by RFC 6749.
+func refreshTokenToContext(ctx context.Context, r *http.Request) context.Context {
+	if err := r.ParseForm(); err != nil {
+		return ctx
+	}
+	if r.PostForm.Get("grant_type") != oauth.GrantRefreshToken {
+		return ctx
+	}
+	if token := r.PostForm.Get("refresh_token"); token != "" {
+		return context.WithValue(ctx, kitjwt.JWTContextKey, token)
+	}
+	return ctx
+}
+
+// refreshTokenParser verifies the token stored by refreshTokenToContext,
+// the other grants are passed through as they carry no token. A token which
+// cannot be verified is reported as an invalid grant.
+func refreshTokenParser(keys *jwks.Cache) endpoint.Middleware {
+	parser := kitjwt.NewParser(keys.Keyfunc, keys.Method(), kitjwt.MapClaimsFactory)
+	parsed := parser(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return ctx, nil
+	})
+
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if _, ok := ctx.Value(kitjwt.JWTContextKey).(string); !ok {
+				return next(ctx, request)
+			}
+
+			c, err := parsed(ctx, request)
+			if err != nil {
+				return nil, oauth.Errorf(oauth.ErrorInvalidGrant, "refresh_token is invalid: %v", err)
+			}
+			return next(c.(context.Context), request)
+		}
+	}
+}
+
+// tokenToContext stores the token of an introspection or a revocation
+// request for tokenParser. Personal access tokens are no JWTs, they are
+// looked up by the service.
+func tokenToContext(ctx context.Context, r *http.Request) context.Context {
+	if err := r.ParseForm(); err != nil {
+		return ctx
+	}
+	if token := r.PostForm.Get("token"); token != "" && !pat.IsToken(token) {
+		return context.WithValue(ctx, kitjwt.JWTContextKey, token)
+	}
+	return ctx
+}
+
+// requireResourceServer lets the requests through which either come with a
+// client certificate verified by the TLS config, i.e. from another service,
+// or carry the access token of a caller allowed to introspect tokens, as
+// RFC 7662 requires the callers to authenticate. The gateway checks the
+// callers before it forwards the requests to authsvc, which in turn trusts
+// the certificate of the gateway.
+func requireResourceServer(keys *jwks.Cache, next http.Handler) http.Handler {
+	parser := kitjwt.NewParser(keys.Keyfunc, keys.Method(), kitjwt.MapClaimsFactory)
+	parsed := parser(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return ctx, nil
+	})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := kitjwt.HTTPToContext()(r.Context(), r)
+		c, err := parsed(ctx, nil)
+		if err != nil {
+			errorEncoder(ctx, oauth.Errorf(oauth.ErrorInvalidClient, "resource server credential is missing or invalid"), w)
+			return
+		}
+		claims, _ := c.(context.Context).Value(kitjwt.JWTClaimsContextKey).(stdjwt.MapClaims)
+		if !authz.Granted(claims, authz.DefaultPolicy, authz.IntrospectTokens) {
+			errorEncoder(ctx, authz.ErrForbidden, w)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// callerTokenToContext stores the access token the caller of an
+// introspection authenticated with, which is not the introspected token.
+func callerTokenToContext(ctx context.Context, r *http.Request) context.Context {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		return context.WithValue(ctx, authsvc.CallerTokenContextKey, auth)
+	}
+	return ctx
+}
+
+// callerTokenToHTTP forwards the access token of the caller found in the
+// context to authsvc.
+func callerTokenToHTTP(ctx context.Context, r *http.Request) context.Context {
+	if auth, ok := ctx.Value(authsvc.CallerTokenContextKey).(string); ok {
+		r.Header.Set("Authorization", auth)
+	}
+	return ctx
+}
+
+// tokenParser verifies the token stored by tokenToContext. Unlike
+// refreshTokenParser, a token which cannot be verified is passed on without
+// claims, as it is reported inactive rather than refused.
+func tokenParser(keys *jwks.Cache) endpoint.Middleware {
+	parser := kitjwt.NewParser(keys.Keyfunc, keys.Method(), kitjwt.MapClaimsFactory)
+	parsed := parser(func(ctx context.Context, _ interface{}) (interface{}, error) {
+		return ctx, nil
+	})
+
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if c, err := parsed(ctx, request); err == nil {
+				ctx = c.(context.Context)
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
+	// The errors of the OAuth endpoints have the format of RFC 6749.
+	var oerr oauth.Error
+	if errors.As(err, &oerr) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(err2code(err))
+		json.NewEncoder(w).Encode(errorWrapper{Error: oerr.Code, Description: oerr.Description})
+		return
+	}
+
+	var lockout authsvc.LockoutError
+	if errors.As(err, &lockout) {
+		secs := int64(math.Ceil(time.Until(lockout.Until).Seconds()))
+		w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	}
+	w.WriteHeader(err2code(err))
+	json.NewEncoder(w).Encode(errorWrapper{Error: err.Error()})
+}
+
+func err2code(err error) int {
+	var oerr oauth.Error
+	if errors.As(err, &oerr) {
+		if oerr.Code == oauth.ErrorInvalidClient {
+			return http.StatusUnauthorized
+		}
+		return http.StatusBadRequest
+	}
+
+	switch {
+	case errors.Is(err, authsvc.ErrAccountLocked):
+		return http.StatusLocked
+	case errors.Is(err, authsvc.ErrTooManyAttempts):
+		return http.StatusTooManyRequests
+	}
+
+	switch err {
+	case kitjwt.ErrTokenExpired, kitjwt.ErrUnexpectedSigningMethod, jwks.ErrKeyIDMissing, jwks.ErrUnknownKey, usersvc.ErrUserNotFound, authsvc.ErrUserIDContextMissing, inmem.ErrKeyNotFound, authsvc.ErrInvalidMFACode, authsvc.ErrRefreshTokenReused, authsvc.ErrClaimsInvalid:
+		return http.StatusUnauthorized
+	case authsvc.ErrInvalidToken:
+		return http.StatusUnauthorized
+	case oidc.ErrExchangeFailed, oidc.ErrInvalidIDToken, oidc.ErrEmailNotVerified:
+		return http.StatusUnauthorized
+	case usersvc.ErrInvalidArgument, authsvc.ErrInvalidArgument, usersvc.ErrInvalidTOTPCode, authsvc.ErrInvalidLoginState:
+		return http.StatusBadRequest
+	case usersvc.ErrTOTPNotEnrolled, usersvc.ErrTOTPAlreadyEnabled:
+		return http.StatusConflict
+	case usersvc.ErrUserDisabled, usersvc.ErrPasswordResetRequired, authz.ErrForbidden:
+		return http.StatusForbidden
+	case authsvc.ErrSessionNotFound, authsvc.ErrClientNotFound, authsvc.ErrConsentNotFound, authsvc.ErrProviderNotFound, authsvc.ErrTokenNotFound:
+		return http.StatusNotFound
+	case oidc.ErrDiscoveryFailed:
+		return http.StatusBadGateway
+	case authsvc.ErrRevocationsLost:
+		return http.StatusGone
+	}
+	return http.StatusInternalServerError
+}
+
+// errorDecoder is used by the response decoders, which return the decoded
+// error within the response rather than as a transport error so that the
+// load balancer does not retry failures reported by authsvc.
+func errorDecoder(r *http.Response) error {
+	var w errorWrapper
+	if err := json.NewDecoder(r.Body).Decode(&w); err != nil {
+		return err
+	}
+
+	// Only the errors of RFC 6749 have a description.
+	if w.Description != "" {
+		return oauth.Error{Code: w.Error, Description: w.Description}
+	}
+
+	switch w.Error {
+	case authsvc.ErrInvalidArgument.Error():
+		return authsvc.ErrInvalidArgument
+	case usersvc.ErrUserNotFound.Error():
+		return usersvc.ErrUserNotFound
+	case authsvc.ErrUserIDContextMissing.Error():
+		return authsvc.ErrUserIDContextMissing
+	case inmem.ErrKeyNotFound.Error():
+		return inmem.ErrKeyNotFound
+	case jwks.ErrKeyIDMissing.Error():
+		return jwks.ErrKeyIDMissing
+	case jwks.ErrUnknownKey.Error():
+		return jwks.ErrUnknownKey
+	case authsvc.ErrInvalidMFACode.Error():
+		return authsvc.ErrInvalidMFACode
+	case usersvc.ErrInvalidTOTPCode.Error():
+		return usersvc.ErrInvalidTOTPCode
+	case usersvc.ErrTOTPNotEnrolled.Error():
+		return usersvc.ErrTOTPNotEnrolled
+	case usersvc.ErrTOTPAlreadyEnabled.Error():
+		return usersvc.ErrTOTPAlreadyEnabled
+	case usersvc.ErrUserDisabled.Error():
+		return usersvc.ErrUserDisabled
+	case usersvc.ErrPasswordResetRequired.Error():
+		return usersvc.ErrPasswordResetRequired
+	case authsvc.ErrSessionNotFound.Error():
+		return authsvc.ErrSessionNotFound
+	case authsvc.ErrRefreshTokenReused.Error():
+		return authsvc.ErrRefreshTokenReused
+	case authsvc.ErrClaimsInvalid.Error():
+		return authsvc.ErrClaimsInvalid
+	case authsvc.ErrClientNotFound.Error():
+		return authsvc.ErrClientNotFound
+	case authsvc.ErrConsentNotFound.Error():
+		return authsvc.ErrConsentNotFound
+	case authz.ErrForbidden.Error():
+		return authz.ErrForbidden
+	case authsvc.ErrProviderNotFound.Error():
+		return authsvc.ErrProviderNotFound
+	case authsvc.ErrInvalidLoginState.Error():
+		return authsvc.ErrInvalidLoginState
+	case oidc.ErrDiscoveryFailed.Error():
+		return oidc.ErrDiscoveryFailed
+	case oidc.ErrExchangeFailed.Error():
+		return oidc.ErrExchangeFailed
+	case oidc.ErrInvalidIDToken.Error():
+		return oidc.ErrInvalidIDToken
+	case oidc.ErrEmailNotVerified.Error():
+		return oidc.ErrEmailNotVerified
+	case authsvc.ErrTokenNotFound.Error():
+		return authsvc.ErrTokenNotFound
+	case authsvc.ErrInvalidToken.Error():
+		return authsvc.ErrInvalidToken
+	case authsvc.ErrRevocationsLost.Error():
+		return authsvc.ErrRevocationsLost
+	case authsvc.ErrTooManyAttempts.Error(), authsvc.ErrAccountLocked.Error():
+		err := authsvc.ErrTooManyAttempts
+		if w.Error == authsvc.ErrAccountLocked.Error() {
+			err = authsvc.ErrAccountLocked
+		}
+		secs, _ := strconv.Atoi(r.Header.Get("Retry-After"))
+		return authsvc.LockoutError{Err: err, Until: time.Now().Add(time.Duration(secs) * time.Second)}
+	}
+
+	return errors.New(w.Error)
+}
 
 type errorWrapper struct {
-	Error string `json:"error"`
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func decodeHTTPLoginRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.LoginRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+func decodeHTTPLoginResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.LoginResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.LoginResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPLogoutRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.LogoutRequest{}, nil
+}
+
+func decodeHTTPLogoutResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.LogoutResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.LogoutResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPRefreshRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.RefreshRequest{}, nil
+}
+
+func decodeHTTPRefreshResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.RefreshResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.RefreshResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPValidateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.ValidateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+func decodeHTTPValidateResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.ValidateResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.ValidateResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPSessionsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.SessionsRequest{}, nil
+}
+
+func decodeHTTPSessionsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.SessionsResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.SessionsResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPRevokeSessionRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.RevokeSessionRequest{SessionID: mux.Vars(r)["id"]}, nil
+}
+
+// encodeHTTPRevokeSessionRequest appends the session ID to the path, as
+// expected by decodeHTTPRevokeSessionRequest.
+func encodeHTTPRevokeSessionRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.RevokeSessionRequest)
+	r.URL.Path += "/" + url.PathEscape(req.SessionID)
+	return nil
+}
+
+func decodeHTTPRevokeSessionResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.RevokeSessionResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.RevokeSessionResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPRevokeAllSessionsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.RevokeAllSessionsRequest{}, nil
 }
 
-func decodeHTTPLoginRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	var req authendpoint.LoginRequest
+func decodeHTTPRevokeAllSessionsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.RevokeAllSessionsResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.RevokeAllSessionsResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPRevokeUserSessionsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.RevokeUserSessionsRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	return req, err
 }
 
-func decodeHTTPLoginResponse(_ context.Context, r *http.Response) (interface{}, error) {
+func decodeHTTPRevokeUserSessionsResponse(_ context.Context, r *http.Response) (interface{}, error) {
 	if r.StatusCode != http.StatusOK {
-		return nil, errorDecoder(r)
+		return authendpoint.RevokeUserSessionsResponse{Err: errorDecoder(r)}, nil
 	}
-	var resp authendpoint.LoginResponse
+	var resp authendpoint.RevokeUserSessionsResponse
 	err := json.NewDecoder(r.Body).Decode(&resp)
 	return resp, err
 }
 
-func decodeHTTPLogoutRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	return authendpoint.LogoutRequest{}, nil
+func decodeHTTPUnlockRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.UnlockRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
 }
 
-func decodeHTTPLogoutResponse(_ context.Context, r *http.Response) (interface{}, error) {
+func decodeHTTPUnlockResponse(_ context.Context, r *http.Response) (interface{}, error) {
 	if r.StatusCode != http.StatusOK {
-		return nil, errorDecoder(r)
+		return authendpoint.UnlockResponse{Err: errorDecoder(r)}, nil
 	}
-	var resp authendpoint.LogoutResponse
+	var resp authendpoint.UnlockResponse
 	err := json.NewDecoder(r.Body).Decode(&resp)
 	return resp, err
 }
 
-func decodeHTTPRefreshRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	return authendpoint.RefreshRequest{}, nil
+func decodeHTTPVerifyMFARequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.VerifyMFARequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
 }
 
-func decodeHTTPRefreshResponse(_ context.Context, r *http.Response) (interface{}, error) {
+func decodeHTTPVerifyMFAResponse(_ context.Context, r *http.Response) (interface{}, error) {
 	if r.StatusCode != http.StatusOK {
-		return nil, errorDecoder(r)
+		return authendpoint.VerifyMFAResponse{Err: errorDecoder(r)}, nil
 	}
-	var resp authendpoint.RefreshResponse
+	var resp authendpoint.VerifyMFAResponse
 	err := json.NewDecoder(r.Body).Decode(&resp)
 	return resp, err
 }
 
-func decodeHTTPValidateRequest(_ context.Context, r *http.Request) (interface{}, error) {
-	var req authendpoint.ValidateRequest
+func decodeHTTPEnrollTOTPRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.EnrollTOTPRequest{}, nil
+}
+
+func decodeHTTPEnrollTOTPResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.EnrollTOTPResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.EnrollTOTPResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPConfirmTOTPRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.ConfirmTOTPRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
 	return req, err
 }
 
-func decodeHTTPValidateResponse(_ context.Context, r *http.Response) (interface{}, error) {
+func decodeHTTPConfirmTOTPResponse(_ context.Context, r *http.Response) (interface{}, error) {
 	if r.StatusCode != http.StatusOK {
-		return nil, errorDecoder(r)
+		return authendpoint.ConfirmTOTPResponse{Err: errorDecoder(r)}, nil
 	}
-	var resp authendpoint.ValidateResponse
+	var resp authendpoint.ConfirmTOTPResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPDisableTOTPRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.DisableTOTPRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+func decodeHTTPDisableTOTPResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.DisableTOTPResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.DisableTOTPResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPChangePasswordRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.ChangePasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+func decodeHTTPChangePasswordResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.ChangePasswordResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.ChangePasswordResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPJWKSRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.JWKSRequest{}, nil
+}
+
+func decodeHTTPJWKSResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.JWKSResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.JWKSResponse
+	err := json.NewDecoder(r.Body).Decode(&resp.KeySet)
+	return resp, err
+}
+
+// encodeHTTPJWKSResponse writes the key set as is, as expected by any JWKS
+// consumer, and allows it to be cached for as long as the verifiers do.
+func encodeHTTPJWKSResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(authendpoint.JWKSResponse)
+	if resp.Err != nil {
+		errorEncoder(ctx, resp.Err, w)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/jwk-set+json")
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwks.DefaultTTL.Seconds())))
+	return json.NewEncoder(w).Encode(resp.KeySet)
+}
+
+func decodeHTTPRegisterClientRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.RegisterClientRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+func decodeHTTPRegisterClientResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.RegisterClientResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.RegisterClientResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPClientRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.ClientRequest{ClientID: mux.Vars(r)["id"]}, nil
+}
+
+// encodeHTTPClientRequest appends the client ID to the path, as expected by
+// decodeHTTPClientRequest.
+func encodeHTTPClientRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.ClientRequest)
+	r.URL.Path += "/" + url.PathEscape(req.ClientID)
+	return nil
+}
+
+func decodeHTTPClientResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.ClientResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.ClientResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPAuthorizeRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.AuthorizeRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+func decodeHTTPAuthorizeResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.AuthorizeResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.AuthorizeResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+// decodeHTTPTokenRequest reads the form parsed by refreshTokenToContext.
+func decodeHTTPTokenRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, oauth.Errorf(oauth.ErrorInvalidRequest, "malformed form body")
+	}
+
+	return authendpoint.TokenRequest{TokenRequest: oauth.TokenRequest{
+		GrantType:    r.PostForm.Get("grant_type"),
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		ClientID:     r.PostForm.Get("client_id"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+	}}, nil
+}
+
+// encodeHTTPTokenRequest sends the request as form, together with the
+// refresh token found in the context.
+func encodeHTTPTokenRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.TokenRequest)
+
+	form := url.Values{}
+	form.Set("grant_type", req.GrantType)
+	form.Set("client_id", req.ClientID)
+	if req.Code != "" {
+		form.Set("code", req.Code)
+		form.Set("redirect_uri", req.RedirectURI)
+		form.Set("code_verifier", req.CodeVerifier)
+	}
+	if token, ok := ctx.Value(kitjwt.JWTContextKey).(string); ok {
+		form.Set("refresh_token", token)
+	}
+
+	body := form.Encode()
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ContentLength = int64(len(body))
+	r.Body = ioutil.NopCloser(strings.NewReader(body))
+	return nil
+}
+
+func decodeHTTPTokenResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.TokenResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.TokenResponse
+	err := json.NewDecoder(r.Body).Decode(&resp.Token)
+	return resp, err
+}
+
+// encodeHTTPTokenResponse writes the token as is and keeps it from being
+// cached, as required by RFC 6749.
+func encodeHTTPTokenResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(authendpoint.TokenResponse)
+	if resp.Err != nil {
+		errorEncoder(ctx, resp.Err, w)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	return json.NewEncoder(w).Encode(resp.Token)
+}
+
+func decodeHTTPIntrospectRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req, err := decodeIntrospectionForm(r)
+	return authendpoint.IntrospectRequest{IntrospectionRequest: req}, err
+}
+
+func encodeHTTPIntrospectRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.IntrospectRequest)
+	encodeIntrospectionForm(r, req.IntrospectionRequest)
+	return nil
+}
+
+func decodeHTTPIntrospectResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.IntrospectResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.IntrospectResponse
+	err := json.NewDecoder(r.Body).Decode(&resp.Introspection)
+	return resp, err
+}
+
+// encodeHTTPIntrospectResponse writes the introspection as is, it must not
+// be cached as the token may be revoked any time.
+func encodeHTTPIntrospectResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(authendpoint.IntrospectResponse)
+	if resp.Err != nil {
+		errorEncoder(ctx, resp.Err, w)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	return json.NewEncoder(w).Encode(resp.Introspection)
+}
+
+func decodeHTTPRevokeTokenRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	req, err := decodeIntrospectionForm(r)
+	return authendpoint.RevokeTokenRequest{IntrospectionRequest: req}, err
+}
+
+func encodeHTTPRevokeTokenRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.RevokeTokenRequest)
+	encodeIntrospectionForm(r, req.IntrospectionRequest)
+	return nil
+}
+
+func decodeHTTPRevokeTokenResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.RevokeTokenResponse{Err: errorDecoder(r)}, nil
+	}
+	return authendpoint.RevokeTokenResponse{}, nil
+}
+
+// decodeIntrospectionForm reads the form shared by RFC 7662 and RFC 7009.
+func decodeIntrospectionForm(r *http.Request) (oauth.IntrospectionRequest, error) {
+	if err := r.ParseForm(); err != nil {
+		return oauth.IntrospectionRequest{}, oauth.Errorf(oauth.ErrorInvalidRequest, "malformed form body")
+	}
+
+	return oauth.IntrospectionRequest{
+		Token:         r.PostForm.Get("token"),
+		TokenTypeHint: r.PostForm.Get("token_type_hint"),
+		ClientID:      r.PostForm.Get("client_id"),
+	}, nil
+}
+
+// encodeIntrospectionForm sends the token as form, the claims are read
+// from the token by authsvc again.
+func encodeIntrospectionForm(r *http.Request, req oauth.IntrospectionRequest) {
+	form := url.Values{}
+	form.Set("token", req.Token)
+	if req.TokenTypeHint != "" {
+		form.Set("token_type_hint", req.TokenTypeHint)
+	}
+	if req.ClientID != "" {
+		form.Set("client_id", req.ClientID)
+	}
+
+	body := form.Encode()
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.ContentLength = int64(len(body))
+	r.Body = ioutil.NopCloser(strings.NewReader(body))
+}
+
+func decodeHTTPConsentsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.ConsentsRequest{}, nil
+}
+
+func decodeHTTPConsentsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.ConsentsResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.ConsentsResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPRevokeConsentRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.RevokeConsentRequest{ClientID: mux.Vars(r)["client_id"]}, nil
+}
+
+// encodeHTTPRevokeConsentRequest appends the client ID to the path, as
+// expected by decodeHTTPRevokeConsentRequest.
+func encodeHTTPRevokeConsentRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.RevokeConsentRequest)
+	r.URL.Path += "/" + url.PathEscape(req.ClientID)
+	return nil
+}
+
+func decodeHTTPRevokeConsentResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.RevokeConsentResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.RevokeConsentResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPOIDCLoginRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.OIDCLoginRequest{Provider: mux.Vars(r)["provider"]}, nil
+}
+
+// encodeHTTPOIDCLoginRequest completes the path with the provider, as
+// expected by decodeHTTPOIDCLoginRequest.
+func encodeHTTPOIDCLoginRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.OIDCLoginRequest)
+	r.URL.Path += "/" + url.PathEscape(req.Provider) + "/login"
+	return nil
+}
+
+// encodeHTTPOIDCLoginResponse hands the binding to the user agent in a
+// cookie, which is sent along with the callback but cannot be read by
+// scripts.
+func encodeHTTPOIDCLoginResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if resp, ok := response.(authendpoint.OIDCLoginResponse); ok && resp.Err == nil {
+		http.SetCookie(w, bindingCookie(resp.Binding, int(authservice.OIDCStateTTL/time.Second)))
+	}
+	return encodeHTTPGenericResponse(ctx, w, response)
+}
+
+// decodeHTTPOIDCLoginResponse takes the binding from the cookie set by
+// encodeHTTPOIDCLoginResponse, so that the gateway passes it on.
+func decodeHTTPOIDCLoginResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.OIDCLoginResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.OIDCLoginResponse
+	if err := json.NewDecoder(r.Body).Decode(&resp); err != nil {
+		return nil, err
+	}
+	for _, c := range r.Cookies() {
+		if c.Name == oidcBindingCookie {
+			resp.Binding = c.Value
+		}
+	}
+	return resp, nil
+}
+
+func decodeHTTPOIDCCallbackRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.OIDCCallbackRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, err
+	}
+	req.Provider = mux.Vars(r)["provider"]
+	if c, err := r.Cookie(oidcBindingCookie); err == nil {
+		req.Binding = c.Value
+	}
+	return req, nil
+}
+
+// encodeHTTPOIDCCallbackRequest completes the path with the provider, as
+// expected by decodeHTTPOIDCCallbackRequest.
+func encodeHTTPOIDCCallbackRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.OIDCCallbackRequest)
+	r.URL.Path += "/" + url.PathEscape(req.Provider) + "/callback"
+	if req.Binding != "" {
+		r.AddCookie(&http.Cookie{Name: oidcBindingCookie, Value: req.Binding})
+	}
+	return encodeHTTPGenericRequest(ctx, r, request)
+}
+
+// encodeHTTPOIDCCallbackResponse removes the binding, whose state is used
+// up by the callback whether it succeeded or not.
+func encodeHTTPOIDCCallbackResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	http.SetCookie(w, bindingCookie("", -1))
+	return encodeHTTPGenericResponse(ctx, w, response)
+}
+
+func decodeHTTPOIDCCallbackResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.OIDCCallbackResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.OIDCCallbackResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+// oidcBindingCookie holds the binding of the OIDC login state. The prefix
+// makes browsers refuse it unless it is secure and set for the whole host.
+const oidcBindingCookie = "__Host-oidc_binding"
+
+// bindingCookie is only sent over TLS and to the host which set it. Lax
+// keeps it from cross-site posts, while the callback is posted by the
+// frontend the identity provider redirected to.
+func bindingCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcBindingCookie,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func decodeHTTPCreatePATRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.CreatePATRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+func decodeHTTPCreatePATResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.CreatePATResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.CreatePATResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPPATsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.PATsRequest{}, nil
+}
+
+func decodeHTTPPATsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.PATsResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.PATsResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPRevokePATRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return authendpoint.RevokePATRequest{ID: mux.Vars(r)["id"]}, nil
+}
+
+// encodeHTTPRevokePATRequest appends the token ID to the path, as expected
+// by decodeHTTPRevokePATRequest.
+func encodeHTTPRevokePATRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(authendpoint.RevokePATRequest)
+	r.URL.Path += "/" + url.PathEscape(req.ID)
+	return nil
+}
+
+func decodeHTTPRevokePATResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.RevokePATResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.RevokePATResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPResolvePATRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.ResolvePATRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+func decodeHTTPResolvePATResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.ResolvePATResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.ResolvePATResponse
+	err := json.NewDecoder(r.Body).Decode(&resp)
+	return resp, err
+}
+
+func decodeHTTPRevocationsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req authendpoint.RevocationsRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
+
+func decodeHTTPRevocationsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode != http.StatusOK {
+		return authendpoint.RevocationsResponse{Err: errorDecoder(r)}, nil
+	}
+	var resp authendpoint.RevocationsResponse
 	err := json.NewDecoder(r.Body).Decode(&resp)
 	return resp, err
 }
@@ -0,0 +1,303 @@
+package authtransport_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authendpoint"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authservice"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authtransport"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+)
+
+const (
+	redirectURI = "https://client.example/callback"
+	verifier    = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+)
+
+type server struct {
+	http.Handler
+	svc authservice.Service
+}
+
+func newServer(t *testing.T) server {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := authservice.NewKeyProvider(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenizer := authservice.NewTokenizer(keys)
+	svc := authservice.NewBasicService(tokenizer, nil, inmem.NewMemoryClient(), nil)
+
+	fetch := func(context.Context) (jwks.KeySet, error) {
+		return tokenizer.PublicKeys(), nil
+	}
+	logger := log.NewNopLogger()
+	h := authtransport.NewHTTPHandler(
+		authendpoint.New(svc, logger),
+		jwks.NewCache(fetch, jwks.SigningMethodEdDSA, jwks.DefaultTTL),
+		logger,
+	)
+	return server{Handler: h, svc: svc}
+}
+
+// login signs the user in and returns the tokens of the new session.
+func (s server) login(t *testing.T, userID uint64, roles ...string) map[string]string {
+	t.Helper()
+
+	ctx := context.WithValue(context.Background(), authsvc.UserIDContextKey, userID)
+	ctx = context.WithValue(ctx, authsvc.RolesContextKey, roles)
+	tokens, err := s.svc.Login(ctx, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+// authorize signs the user in to a new client and returns its ID and the
+// tokens issued to it.
+func (s server) authorize(t *testing.T, userID uint64) (string, oauth.Token) {
+	t.Helper()
+
+	ctx := context.Background()
+	owner := claimsOf(t, s.login(t, userID)["access"])["uuid"].(string)
+
+	c, err := s.svc.RegisterClient(ctx, owner, userID, oauth.Client{Name: "client", RedirectURIs: []string{redirectURI}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := s.svc.Authorize(ctx, owner, userID, oauth.AuthorizeRequest{
+		ResponseType:        oauth.ResponseTypeCode,
+		ClientID:            c.ID,
+		RedirectURI:         redirectURI,
+		CodeChallenge:       oauth.Challenge(verifier),
+		CodeChallengeMethod: oauth.MethodS256,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := url.Parse(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := s.svc.Token(ctx, oauth.TokenRequest{
+		GrantType:    oauth.GrantAuthorizationCode,
+		Code:         redirect.Query().Get("code"),
+		RedirectURI:  redirectURI,
+		ClientID:     c.ID,
+		CodeVerifier: verifier,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.ID, token
+}
+
+// post sends the form the way resource servers and clients do, with the
+// access token of the caller if any.
+func (s server) post(path, caller string, form url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if caller != "" {
+		r.Header.Set("Authorization", "Bearer "+caller)
+	}
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	return w
+}
+
+func claimsOf(t *testing.T, token string) jwt.MapClaims {
+	t.Helper()
+
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+// introspect returns the raw response of the introspection of the token by
+// a resource server.
+func (s server) introspect(t *testing.T, caller, token string) (*httptest.ResponseRecorder, oauth.Introspection) {
+	t.Helper()
+
+	w := s.post("/introspect", caller, url.Values{"token": {token}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("want the introspection answered, got %d %s", w.Code, w.Body)
+	}
+	var i oauth.Introspection
+	if err := json.Unmarshal(w.Body.Bytes(), &i); err != nil {
+		t.Fatal(err)
+	}
+	return w, i
+}
+
+func oauthError(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("want a JSON error, got %s", w.Body)
+	}
+	return body.Error
+}
+
+// TestIntrospectAuthenticatesCaller checks that only resource servers may
+// introspect tokens.
+func TestIntrospectAuthenticatesCaller(t *testing.T) {
+	t.Parallel()
+
+	s := newServer(t)
+	token := s.login(t, 1)["access"]
+
+	w := s.post("/introspect", "", url.Values{"token": {token}})
+	if w.Code != http.StatusUnauthorized || oauthError(t, w) != oauth.ErrorInvalidClient {
+		t.Errorf("no credential: want %d %s, got %d %s", http.StatusUnauthorized, oauth.ErrorInvalidClient, w.Code, w.Body)
+	}
+
+	w = s.post("/introspect", "forged."+token, url.Values{"token": {token}})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("invalid credential: want %d, got %d %s", http.StatusUnauthorized, w.Code, w.Body)
+	}
+
+	w = s.post("/introspect", s.login(t, 2, usersvc.RoleUser)["access"], url.Values{"token": {token}})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("end user: want %d, got %d %s", http.StatusForbidden, w.Code, w.Body)
+	}
+
+	if _, i := s.introspect(t, s.login(t, 3, usersvc.RoleService)["access"], token); !i.Active {
+		t.Error("resource server: want the token reported active")
+	}
+}
+
+// TestIntrospectReportsRevokedInactive checks the response of RFC 7662 for
+// active tokens, and that unknown and revoked tokens are reported inactive
+// with no other member.
+func TestIntrospectReportsRevokedInactive(t *testing.T) {
+	t.Parallel()
+
+	s := newServer(t)
+	caller := s.login(t, 9, usersvc.RoleService)["access"]
+	ctx := context.Background()
+
+	tokens := s.login(t, 1)
+	w, i := s.introspect(t, caller, tokens["access"])
+	if !i.Active || i.Sub != "1" || i.TokenType != "Bearer" || i.Jti != claimsOf(t, tokens["access"])["uuid"] || i.Exp == 0 {
+		t.Errorf("access token: want the active token of user 1, got %+v", i)
+	}
+	if w.Header().Get("Cache-Control") != "no-store" || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		t.Errorf("want an uncached JSON response, got %v", w.Header())
+	}
+	if _, i := s.introspect(t, caller, tokens["refresh"]); !i.Active || i.Sub != "1" {
+		t.Errorf("refresh token: want the active token of user 1, got %+v", i)
+	}
+
+	created, err := s.svc.CreatePAT(ctx, i.Jti, 1, "cron", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, i := s.introspect(t, caller, created.Secret); !i.Active || i.Jti != created.ID || i.Scope != created.Scopes.String() {
+		t.Errorf("personal access token: want it active with its scopes, got %+v", i)
+	}
+
+	if _, err := s.svc.RevokePAT(ctx, i.Jti, 1, created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.svc.Logout(ctx, i.Jti); err != nil {
+		t.Fatal(err)
+	}
+
+	other := newServer(t).login(t, 1)["access"]
+	for name, token := range map[string]string{
+		"revoked access token":          tokens["access"],
+		"revoked refresh token":         tokens["refresh"],
+		"revoked personal access token": created.Secret,
+		"token of another issuer":       other,
+		"unknown personal access token": created.Secret[:len(created.Secret)-1],
+		"garbage":                       "garbage",
+	} {
+		w, _ := s.introspect(t, caller, token)
+		if body := strings.TrimSpace(w.Body.String()); body != `{"active":false}` {
+			t.Errorf("%s: want only inactive reported, got %s", name, body)
+		}
+	}
+
+	w = s.post("/introspect", caller, url.Values{})
+	if w.Code != http.StatusBadRequest || oauthError(t, w) != oauth.ErrorInvalidRequest {
+		t.Errorf("no token: want %d %s, got %d %s", http.StatusBadRequest, oauth.ErrorInvalidRequest, w.Code, w.Body)
+	}
+}
+
+// TestRevokeTokenAuthenticatesClient checks that a client revokes the
+// tokens issued to it only, and that invalid tokens are ignored as RFC 7009
+// requires.
+func TestRevokeTokenAuthenticatesClient(t *testing.T) {
+	t.Parallel()
+
+	s := newServer(t)
+	caller := s.login(t, 9, usersvc.RoleService)["access"]
+	clientID, token := s.authorize(t, 1)
+	otherID, _ := s.authorize(t, 1)
+	session := s.login(t, 1)["access"]
+
+	for _, tc := range []struct {
+		name     string
+		clientID string
+		token    string
+		code     int
+		err      string
+	}{
+		{"no client", "", token.AccessToken, http.StatusUnauthorized, oauth.ErrorInvalidClient},
+		{"unknown client", "unknown", token.AccessToken, http.StatusUnauthorized, oauth.ErrorInvalidClient},
+		{"other client", otherID, token.AccessToken, http.StatusBadRequest, oauth.ErrorUnauthorizedClient},
+		{"other client", otherID, token.RefreshToken, http.StatusBadRequest, oauth.ErrorUnauthorizedClient},
+		{"first-party session", clientID, session, http.StatusBadRequest, oauth.ErrorUnauthorizedClient},
+	} {
+		w := s.post("/revoke", "", url.Values{"token": {tc.token}, "client_id": {tc.clientID}})
+		if w.Code != tc.code || oauthError(t, w) != tc.err {
+			t.Errorf("%s: want %d %s, got %d %s", tc.name, tc.code, tc.err, w.Code, w.Body)
+		}
+	}
+	for _, tok := range []string{token.AccessToken, session} {
+		if _, i := s.introspect(t, caller, tok); !i.Active {
+			t.Error("want the tokens left active by the refused revocations")
+		}
+	}
+
+	if w := s.post("/revoke", "", url.Values{"token": {token.RefreshToken}, "client_id": {clientID}}); w.Code != http.StatusOK {
+		t.Fatalf("own token: want %d, got %d %s", http.StatusOK, w.Code, w.Body)
+	}
+	if _, i := s.introspect(t, caller, token.AccessToken); i.Active {
+		t.Error("want the access token of the revoked refresh token inactive")
+	}
+	if _, i := s.introspect(t, caller, session); !i.Active {
+		t.Error("want the first-party session left active")
+	}
+
+	for _, tok := range []string{token.AccessToken, "garbage"} {
+		if w := s.post("/revoke", "", url.Values{"token": {tok}, "client_id": {clientID}}); w.Code != http.StatusOK {
+			t.Errorf("revoked or invalid token: want %d, got %d %s", http.StatusOK, w.Code, w.Body)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package exportsvc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
+	"github.com/ichigozero/gtdkit/backend/tasksvc"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+)
+
+// Export holds every piece of personal data kept about a user across
+// usersvc, tasksvc and authsvc.
+type Export struct {
+	usersvc.PersonalData
+	Tasks    []tasksvc.Task
+	Sessions []authsvc.Session
+	Consents []oauth.Consent
+	// PATs are listed without their secrets, which are not kept.
+	PATs      []pat.Token
+	CreatedAt time.Time
+}
+
+var (
+	ErrInvalidArgument = errors.New("invalid argument")
+	ErrClaimsMissing   = errors.New("JWT claims was not passed through the context")
+)
@@ -0,0 +1,90 @@
+package exportendpoint
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/authsvc/authz"
+	"github.com/ichigozero/gtdkit/backend/exportsvc"
+	"github.com/ichigozero/gtdkit/backend/exportsvc/pkg/exportservice"
+	"github.com/ichigozero/gtdkit/backend/tasksvc"
+)
+
+type Set struct {
+	ExportMyDataEndpoint endpoint.Endpoint
+}
+
+func New(svc exportservice.Service, logger log.Logger) Set {
+	var exportMyDataEndpoint endpoint.Endpoint
+	{
+		exportMyDataEndpoint = MakeExportMyDataEndpoint(svc)
+		// The export holds more than the tasks, so no scope grants it.
+		exportMyDataEndpoint = authz.FirstParty()(exportMyDataEndpoint)
+		exportMyDataEndpoint = LoggingMiddleware(log.With(logger, "method", "ExportMyData"))(exportMyDataEndpoint)
+	}
+
+	return Set{
+		ExportMyDataEndpoint: exportMyDataEndpoint,
+	}
+}
+
+// ExportMyData exports the data of the user whose JWT claims are in the
+// context.
+func (s Set) ExportMyData(ctx context.Context) (exportsvc.Export, error) {
+	resp, err := s.ExportMyDataEndpoint(ctx, ExportMyDataRequest{})
+	if err != nil {
+		return exportsvc.Export{}, err
+	}
+	response := resp.(ExportMyDataResponse)
+	return response.Export, response.Err
+}
+
+func MakeExportMyDataEndpoint(s exportservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		auth, err := claims(ctx)
+		if err != nil {
+			return ExportMyDataResponse{Err: err}, nil
+		}
+
+		_ = request.(ExportMyDataRequest)
+		e, err := s.ExportMyData(ctx, auth)
+		return ExportMyDataResponse{Export: e, Err: err}, nil
+	}
+}
+
+func claims(ctx context.Context) (tasksvc.Auth, error) {
+	claims, ok := ctx.Value(kitjwt.JWTClaimsContextKey).(stdjwt.MapClaims)
+	if !ok {
+		return tasksvc.Auth{}, exportsvc.ErrClaimsMissing
+	}
+
+	uuid, ok := claims["uuid"].(string)
+	if !ok {
+		return tasksvc.Auth{}, exportsvc.ErrClaimsMissing
+	}
+
+	userID, err := strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+	if err != nil {
+		return tasksvc.Auth{}, exportsvc.ErrClaimsMissing
+	}
+
+	return tasksvc.Auth{AccessUUID: uuid, UserID: userID}, nil
+}
+
+var (
+	_ endpoint.Failer = ExportMyDataResponse{}
+)
+
+type ExportMyDataRequest struct{}
+
+type ExportMyDataResponse struct {
+	Export exportsvc.Export `json:"export"`
+	Err    error            `json:"-"`
+}
+
+func (r ExportMyDataResponse) Failed() error { return r.Err }
@@ -0,0 +1,65 @@
+package exportservice
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authendpoint"
+	"github.com/ichigozero/gtdkit/backend/exportsvc"
+	"github.com/ichigozero/gtdkit/backend/tasksvc"
+)
+
+type Middleware func(Service) Service
+
+func LoggingMiddleware(logger log.Logger) Middleware {
+	return func(next Service) Service {
+		return loggingMiddleware{logger, next}
+	}
+}
+
+type loggingMiddleware struct {
+	logger log.Logger
+	next   Service
+}
+
+func (mw loggingMiddleware) ExportMyData(ctx context.Context, a tasksvc.Auth) (e exportsvc.Export, err error) {
+	defer func() {
+		mw.logger.Log(
+			"method", "ExportMyData",
+			"access_uuid", a.AccessUUID,
+			"user_id", a.UserID,
+			"tasks", len(e.Tasks),
+			"sessions", len(e.Sessions),
+			"consents", len(e.Consents),
+			"pats", len(e.PATs),
+			"err", err,
+		)
+	}()
+	return mw.next.ExportMyData(ctx, a)
+}
+
+func ProxingMiddleware(ctx context.Context, validateUUID endpoint.Endpoint) Middleware {
+	return func(next Service) Service {
+		return proxingMiddleware{next, validateUUID}
+	}
+}
+
+type proxingMiddleware struct {
+	next         Service
+	validateUUID endpoint.Endpoint
+}
+
+func (mw proxingMiddleware) ExportMyData(ctx context.Context, a tasksvc.Auth) (exportsvc.Export, error) {
+	response, err := mw.validateUUID(ctx, authendpoint.ValidateRequest{AccessUUID: a.AccessUUID})
+	if err != nil {
+		return exportsvc.Export{}, err
+	}
+
+	resp := response.(authendpoint.ValidateResponse)
+	if resp.Err != nil {
+		return exportsvc.Export{}, resp.Err
+	}
+
+	return mw.next.ExportMyData(ctx, a)
+}
@@ -0,0 +1,118 @@
+package exportservice
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authendpoint"
+	"github.com/ichigozero/gtdkit/backend/exportsvc"
+	"github.com/ichigozero/gtdkit/backend/tasksvc"
+	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/taskendpoint"
+	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/userendpoint"
+)
+
+type Service interface {
+	ExportMyData(ctx context.Context, a tasksvc.Auth) (exportsvc.Export, error)
+}
+
+func New(personalData, tasks, sessions, consents, pats endpoint.Endpoint, logger log.Logger) Service {
+	var svc Service
+	{
+		svc = NewBasicService(personalData, tasks, sessions, consents, pats)
+		svc = LoggingMiddleware(logger)(svc)
+	}
+	return svc
+}
+
+// basicService owns no data, it gathers the caller's data from the other
+// services. The JWT of the caller must be in the context since tasksvc and
+// authsvc authenticate the forwarded requests themselves.
+type basicService struct {
+	personalData endpoint.Endpoint
+	tasks        endpoint.Endpoint
+	sessions     endpoint.Endpoint
+	consents     endpoint.Endpoint
+	pats         endpoint.Endpoint
+}
+
+func NewBasicService(personalData, tasks, sessions, consents, pats endpoint.Endpoint) Service {
+	return basicService{
+		personalData: personalData,
+		tasks:        tasks,
+		sessions:     sessions,
+		consents:     consents,
+		pats:         pats,
+	}
+}
+
+func (s basicService) ExportMyData(ctx context.Context, a tasksvc.Auth) (exportsvc.Export, error) {
+	if a.UserID == 0 {
+		return exportsvc.Export{}, exportsvc.ErrInvalidArgument
+	}
+
+	export := exportsvc.Export{CreatedAt: time.Now().UTC()}
+	{
+		response, err := s.personalData(ctx, userendpoint.PersonalDataRequest{ID: a.UserID})
+		if err != nil {
+			return exportsvc.Export{}, err
+		}
+
+		resp := response.(userendpoint.PersonalDataResponse)
+		if resp.Err != nil {
+			return exportsvc.Export{}, resp.Err
+		}
+		export.PersonalData = resp.Data
+	}
+	{
+		response, err := s.tasks(ctx, taskendpoint.TasksRequest{})
+		if err != nil {
+			return exportsvc.Export{}, err
+		}
+
+		resp := response.(taskendpoint.TasksResponse)
+		if resp.Err != nil {
+			return exportsvc.Export{}, resp.Err
+		}
+		export.Tasks = resp.Tasks
+	}
+	{
+		response, err := s.sessions(ctx, authendpoint.SessionsRequest{})
+		if err != nil {
+			return exportsvc.Export{}, err
+		}
+
+		resp := response.(authendpoint.SessionsResponse)
+		if resp.Err != nil {
+			return exportsvc.Export{}, resp.Err
+		}
+		export.Sessions = resp.Sessions
+	}
+	{
+		response, err := s.consents(ctx, authendpoint.ConsentsRequest{})
+		if err != nil {
+			return exportsvc.Export{}, err
+		}
+
+		resp := response.(authendpoint.ConsentsResponse)
+		if resp.Err != nil {
+			return exportsvc.Export{}, resp.Err
+		}
+		export.Consents = resp.Consents
+	}
+	{
+		response, err := s.pats(ctx, authendpoint.PATsRequest{})
+		if err != nil {
+			return exportsvc.Export{}, err
+		}
+
+		resp := response.(authendpoint.PATsResponse)
+		if resp.Err != nil {
+			return exportsvc.Export{}, resp.Err
+		}
+		export.PATs = resp.Tokens
+	}
+
+	return export, nil
+}
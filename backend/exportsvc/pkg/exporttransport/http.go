@@ -0,0 +1,179 @@
+package exporttransport
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"time"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/authz"
+	"github.com/ichigozero/gtdkit/backend/authsvc/inmem"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/exportsvc"
+	"github.com/ichigozero/gtdkit/backend/exportsvc/pkg/exportendpoint"
+	"github.com/ichigozero/gtdkit/backend/tasksvc"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+)
+
+func NewHTTPHandler(endpoints exportendpoint.Set, keys *jwks.Cache, logger log.Logger) http.Handler {
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(errorEncoder),
+		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+	}
+
+	var exportMyDataEndpoint endpoint.Endpoint
+	{
+		exportMyDataEndpoint = endpoints.ExportMyDataEndpoint
+		exportMyDataEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(exportMyDataEndpoint)
+	}
+
+	exportMyDataHandler := httptransport.NewServer(
+		exportMyDataEndpoint,
+		decodeHTTPExportMyDataRequest,
+		encodeHTTPExportMyDataResponse,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+	)
+
+	r := mux.NewRouter()
+
+	r.Methods("GET").Path("/me").Handler(exportMyDataHandler)
+
+	return r
+}
+
+func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
+	w.WriteHeader(err2code(err))
+	json.NewEncoder(w).Encode(errorWrapper{Error: err.Error()})
+}
+
+type errorWrapper struct {
+	Error string `json:"error"`
+}
+
+func err2code(err error) int {
+	switch err {
+	case authz.ErrForbidden:
+		return http.StatusForbidden
+	case kitjwt.ErrTokenExpired, kitjwt.ErrUnexpectedSigningMethod, kitjwt.ErrTokenInvalid, kitjwt.ErrTokenMalformed, kitjwt.ErrTokenContextMissing, jwks.ErrKeyIDMissing, jwks.ErrUnknownKey, usersvc.ErrUserNotFound, inmem.ErrKeyNotFound, exportsvc.ErrClaimsMissing:
+		return http.StatusUnauthorized
+	case usersvc.ErrInvalidArgument, authsvc.ErrInvalidArgument, tasksvc.ErrInvalidArgument, exportsvc.ErrInvalidArgument:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func decodeHTTPExportMyDataRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	return exportendpoint.ExportMyDataRequest{}, nil
+}
+
+// manifest is written last to the archive and lists the checksum of every
+// other file, so that the archive can be verified after it was downloaded.
+type manifest struct {
+	UserID    uint64         `json:"user_id"`
+	CreatedAt string         `json:"created_at"`
+	Files     []manifestFile `json:"files"`
+}
+
+type manifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// mfa is the TOTP state of the user. The secret and the recovery codes are
+// credentials, so they are not exported.
+type mfa struct {
+	TOTPEnabled       bool `json:"totp_enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+// encodeHTTPExportMyDataResponse streams the export as a ZIP archive which
+// contains one JSON file per kind of data plus manifest.json. The files are
+// encoded straight into the response, so once the first one is written an
+// error can only cut the archive short.
+func encodeHTTPExportMyDataResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	resp := response.(exportendpoint.ExportMyDataResponse)
+	if resp.Err != nil {
+		errorEncoder(ctx, resp.Err, w)
+		return nil
+	}
+
+	e := resp.Export
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", e.Profile},
+		{"mfa.json", mfa{TOTPEnabled: e.Profile.TOTPEnabled, RecoveryCodesLeft: e.RecoveryCodesLeft}},
+		{"identities.json", e.Identities},
+		{"audit_log.json", e.AuditEntries},
+		{"tasks.json", e.Tasks},
+		{"sessions.json", e.Sessions},
+		{"consents.json", e.Consents},
+		{"tokens.json", e.PATs},
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set(
+		"Content-Disposition",
+		fmt.Sprintf(`attachment; filename="gtdkit-export-%d-%s.zip"`, e.Profile.ID, e.CreatedAt.Format("20060102")),
+	)
+
+	zw := zip.NewWriter(w)
+	m := manifest{UserID: e.Profile.ID, CreatedAt: e.CreatedAt.Format(time.RFC3339)}
+	for _, f := range files {
+		d, err := writeFile(zw, f.name, f.data)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, manifestFile{Name: f.name, Size: d.size, SHA256: hex.EncodeToString(d.hash.Sum(nil))})
+	}
+
+	if _, err := writeFile(zw, "manifest.json", m); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// digest hashes and counts what is written to a file of the archive.
+type digest struct {
+	hash hash.Hash
+	size int64
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	return d.hash.Write(p)
+}
+
+// writeFile encodes v as the JSON file name of the archive, and returns the
+// digest of the file.
+func writeFile(zw *zip.Writer, name string, v interface{}) (*digest, error) {
+	f, err := zw.Create(name)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &digest{hash: sha256.New()}
+	enc := json.NewEncoder(io.MultiWriter(f, d))
+	enc.SetIndent("", "  ")
+	return d, enc.Encode(v)
+}
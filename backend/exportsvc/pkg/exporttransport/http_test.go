@@ -0,0 +1,187 @@
+package exporttransport_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/authsvc/oauth"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pkg/authendpoint"
+	"github.com/ichigozero/gtdkit/backend/exportsvc/pkg/exportendpoint"
+	"github.com/ichigozero/gtdkit/backend/exportsvc/pkg/exportservice"
+	"github.com/ichigozero/gtdkit/backend/exportsvc/pkg/exporttransport"
+	"github.com/ichigozero/gtdkit/backend/tasksvc"
+	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/taskendpoint"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/userendpoint"
+)
+
+// reply returns an endpoint which always answers with the response.
+func reply(response interface{}) endpoint.Endpoint {
+	return func(context.Context, interface{}) (interface{}, error) {
+		return response, nil
+	}
+}
+
+// newHandler returns the handler of an export gathered from fixed data of
+// the user 7, together with an access token of the user.
+func newHandler(t *testing.T) (http.Handler, string) {
+	t.Helper()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := jwks.NewKey("k", "EdDSA", pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetch := func(context.Context) (jwks.KeySet, error) {
+		return jwks.KeySet{Keys: []jwks.Key{k}}, nil
+	}
+	keys := jwks.NewCache(fetch, jwks.SigningMethodEdDSA, time.Hour)
+
+	token := stdjwt.NewWithClaims(jwks.SigningMethodEdDSA, stdjwt.MapClaims{
+		"uuid":    "session",
+		"user_id": 7,
+		"roles":   []string{usersvc.RoleUser},
+		"exp":     time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = "k"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().UTC()
+	personalData := func(_ context.Context, request interface{}) (interface{}, error) {
+		if id := request.(userendpoint.PersonalDataRequest).ID; id != 7 {
+			return userendpoint.PersonalDataResponse{Err: usersvc.ErrUserNotFound}, nil
+		}
+		return userendpoint.PersonalDataResponse{Data: usersvc.PersonalData{
+			Profile:           usersvc.User{ID: 7, Name: "ann", TOTPEnabled: true},
+			RecoveryCodesLeft: 9,
+			Identities:        []usersvc.Identity{{ID: 1, UserID: 7, Issuer: "https://idp.example.com", Subject: "sub"}},
+			AuditEntries:      []usersvc.AuditEntry{{ID: 1, ActorID: 7, Action: "link_identity", TargetID: 7}},
+		}}, nil
+	}
+	svc := exportservice.NewBasicService(
+		personalData,
+		reply(taskendpoint.TasksResponse{Tasks: []tasksvc.Task{{ID: 1, Title: "write tests", UserID: 7}}}),
+		reply(authendpoint.SessionsResponse{Sessions: []authsvc.Session{{AccessUUID: "session", UserID: 7}}}),
+		reply(authendpoint.ConsentsResponse{Consents: []oauth.Consent{{ClientID: "app", GrantedAt: now}}}),
+		reply(authendpoint.PATsResponse{Tokens: []pat.Token{{ID: "pat", UserID: 7, Name: "cron", CreatedAt: now}}}),
+	)
+
+	endpoints := exportendpoint.New(svc, log.NewNopLogger())
+	return exporttransport.NewHTTPHandler(endpoints, keys, log.NewNopLogger()), signed
+}
+
+// TestExportManifest unzips an export and checks that the manifest lists
+// every other file of the archive with its size and checksum.
+func TestExportManifest(t *testing.T) {
+	t.Parallel()
+
+	h, token := newHandler(t)
+	r := httptest.NewRequest(http.MethodGet, "/me", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("want a ZIP archive, got %d %s", w.Code, w.Body)
+	}
+
+	body := w.Body.Bytes()
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = b
+	}
+	if last := zr.File[len(zr.File)-1].Name; last != "manifest.json" {
+		t.Errorf("want the manifest written last, got %s", last)
+	}
+
+	var m struct {
+		UserID uint64 `json:"user_id"`
+		Files  []struct {
+			Name   string `json:"name"`
+			Size   int64  `json:"size"`
+			SHA256 string `json:"sha256"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(files["manifest.json"], &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.UserID != 7 {
+		t.Errorf("want the manifest of user 7, got %d", m.UserID)
+	}
+	if len(m.Files) != len(files)-1 {
+		t.Errorf("want every other file listed, got %d of %d", len(m.Files), len(files)-1)
+	}
+	for _, f := range m.Files {
+		b, ok := files[f.Name]
+		if !ok {
+			t.Errorf("%s: listed but missing", f.Name)
+			continue
+		}
+		sum := sha256.Sum256(b)
+		if f.Size != int64(len(b)) || f.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: want size %d and checksum %x, got %d and %s", f.Name, len(b), sum, f.Size, f.SHA256)
+		}
+	}
+
+	for name, want := range map[string]string{
+		"mfa.json":        `"recovery_codes_left": 9`,
+		"identities.json": `"subject": "sub"`,
+		"audit_log.json":  `"action": "link_identity"`,
+		"tasks.json":      `"title": "write tests"`,
+		"sessions.json":   `"access_uuid": "session"`,
+		"consents.json":   `"client_id": "app"`,
+		"tokens.json":     `"name": "cron"`,
+	} {
+		if !bytes.Contains(files[name], []byte(want)) {
+			t.Errorf("%s: want %s, got %s", name, want, files[name])
+		}
+	}
+}
+
+func TestExportUnauthorized(t *testing.T) {
+	t.Parallel()
+
+	h, _ := newHandler(t)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/me", nil))
+
+	if w.Code != http.StatusUnauthorized || w.Header().Get("Content-Type") == "application/zip" {
+		t.Errorf("want %d without an archive, got %d %s", http.StatusUnauthorized, w.Code, w.Header().Get("Content-Type"))
+	}
+}
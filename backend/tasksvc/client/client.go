@@ -1,6 +1,8 @@
 package client
 
 import (
+	"context"
+	"crypto/tls"
 	"io"
 	"time"
 
@@ -13,9 +15,12 @@ import (
 	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/taskservice"
 	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/tasktransport"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-func New(apiclient consulsd.Client, logger log.Logger, retryMax int, retryTimeout time.Duration) (taskendpoint.Set, error) {
+// New returns the endpoints of the instances registered in Consul. They
+// are called over TLS if tlsConfig is not nil.
+func New(apiclient consulsd.Client, tlsConfig *tls.Config, logger log.Logger, retryMax int, retryTimeout time.Duration) (taskendpoint.Set, error) {
 	var (
 		tags        = []string{}
 		passingOnly = true
@@ -23,46 +28,74 @@ func New(apiclient consulsd.Client, logger log.Logger, retryMax int, retryTimeou
 		instancer   = consulsd.NewInstancer(apiclient, logger, "tasksvc", tags, passingOnly)
 	)
 	{
-		factory := factoryFor(taskendpoint.MakeCreateTaskEndpoint, logger)
+		factory := factoryFor(taskendpoint.MakeCreateTaskEndpoint, tlsConfig, logger)
 		endpointer := sd.NewEndpointer(instancer, factory, logger)
 		balancer := lb.NewRoundRobin(endpointer)
 		retry := lb.Retry(retryMax, retryTimeout, balancer)
 		endpoints.CreateTaskEndpoint = retry
 	}
 	{
-		factory := factoryFor(taskendpoint.MakeTasksEndpoint, logger)
+		factory := factoryFor(taskendpoint.MakeTasksEndpoint, tlsConfig, logger)
 		endpointer := sd.NewEndpointer(instancer, factory, logger)
 		balancer := lb.NewRoundRobin(endpointer)
 		retry := lb.Retry(retryMax, retryTimeout, balancer)
 		endpoints.TasksEndpoint = retry
 	}
 	{
-		factory := factoryFor(taskendpoint.MakeTaskEndpoint, logger)
+		factory := factoryFor(taskendpoint.MakeTaskEndpoint, tlsConfig, logger)
 		endpointer := sd.NewEndpointer(instancer, factory, logger)
 		balancer := lb.NewRoundRobin(endpointer)
 		retry := lb.Retry(retryMax, retryTimeout, balancer)
 		endpoints.TaskEndpoint = retry
 	}
 	{
-		factory := factoryFor(taskendpoint.MakeUpdateTaskEndpoint, logger)
+		factory := factoryFor(taskendpoint.MakeUpdateTaskEndpoint, tlsConfig, logger)
 		endpointer := sd.NewEndpointer(instancer, factory, logger)
 		balancer := lb.NewRoundRobin(endpointer)
 		retry := lb.Retry(retryMax, retryTimeout, balancer)
 		endpoints.UpdateTaskEndpoint = retry
 	}
 	{
-		factory := factoryFor(taskendpoint.MakeDeleteTaskEndpoint, logger)
+		factory := factoryFor(taskendpoint.MakeDeleteTaskEndpoint, tlsConfig, logger)
 		endpointer := sd.NewEndpointer(instancer, factory, logger)
 		balancer := lb.NewRoundRobin(endpointer)
 		retry := lb.Retry(retryMax, retryTimeout, balancer)
 		endpoints.DeleteTaskEndpoint = retry
 	}
+	{
+		// The stream lasts as long as the context of the caller, so it is
+		// neither bounded by the retry timeout nor retried. The callers
+		// resume it from the last event instead.
+		factory := factoryFor(taskendpoint.MakeWatchTasksEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		endpoints.WatchTasksEndpoint = func(ctx context.Context, request interface{}) (interface{}, error) {
+			e, err := balancer.Endpoint()
+			if err != nil {
+				return nil, err
+			}
+			return e(ctx, request)
+		}
+	}
+	{
+		// A sync is safe to retry: the tasks created offline are only
+		// created once, and a change applied again is merged as a no-op.
+		factory := factoryFor(taskendpoint.MakeSyncEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.SyncEndpoint = retry
+	}
 	return endpoints, nil
 }
 
-func factoryFor(makeEndpoint func(taskservice.Service) endpoint.Endpoint, logger log.Logger) sd.Factory {
+func factoryFor(makeEndpoint func(taskservice.Service) endpoint.Endpoint, tlsConfig *tls.Config, logger log.Logger) sd.Factory {
 	return func(instance string) (endpoint.Endpoint, io.Closer, error) {
-		conn, err := grpc.Dial(instance, grpc.WithInsecure())
+		creds := grpc.WithInsecure()
+		if tlsConfig != nil {
+			creds = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+		}
+		conn, err := grpc.Dial(instance, creds)
 		if err != nil {
 			return nil, nil, err
 		}
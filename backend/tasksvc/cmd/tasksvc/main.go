@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
@@ -12,23 +13,28 @@ import (
 	"text/tabwriter"
 	"time"
 
+	stdjwt "github.com/dgrijalva/jwt-go"
 	"github.com/go-kit/kit/log"
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	consulsd "github.com/go-kit/kit/sd/consul"
 	kitgrpc "github.com/go-kit/kit/transport/grpc"
 	"github.com/hashicorp/consul/api"
 	authclient "github.com/ichigozero/gtdkit/backend/authsvc/client"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
 	"github.com/ichigozero/gtdkit/backend/tasksvc"
 	"github.com/ichigozero/gtdkit/backend/tasksvc/db/gorm"
 	"github.com/ichigozero/gtdkit/backend/tasksvc/pb"
 	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/taskendpoint"
 	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/taskservice"
 	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/tasktransport"
+	"github.com/ichigozero/gtdkit/backend/tlsconfig"
 	userclient "github.com/ichigozero/gtdkit/backend/usersvc/client"
 	"github.com/oklog/oklog/pkg/group"
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/twinj/uuid"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	libgorm "gorm.io/gorm"
@@ -42,6 +48,11 @@ func main() {
 			getEnv("GRPC_ADDR", ":8082"),
 			"gRPC listen address",
 		)
+		debugAddr = fs.String(
+			"debug.addr",
+			getEnv("DEBUG_ADDR", ""),
+			"Debug and metrics listen address, disabled if empty",
+		)
 		consulAddr = fs.String(
 			"consul.addr",
 			getEnv("CONSUL_ADDR", ""),
@@ -52,6 +63,41 @@ func main() {
 			getEnv("DATABASE_URL", ""),
 			"Database URL",
 		)
+		jwtAlg = fs.String(
+			"jwt.alg",
+			getEnv("JWT_ALG", "RS256"),
+			"Signing algorithm of the access tokens, RS256 or EdDSA",
+		)
+		identityKey = fs.String(
+			"identity.key",
+			getEnv("IDENTITY_KEY", ""),
+			"Key shared with the gateway to verify the identities it signed, identities are ignored if empty",
+		)
+		validationTTL = fs.Duration(
+			"validation.ttl",
+			time.Duration(getEnvAsInt("VALIDATION_TTL", 30))*time.Second,
+			"How long a validated token is trusted without asking authsvc, 0 disables the cache",
+		)
+		eventsRetention = fs.Duration(
+			"events.retention",
+			time.Duration(getEnvAsInt("EVENTS_RETENTION", 24))*time.Hour,
+			"How long the changes of the tasks are kept for the watchers to resume from",
+		)
+		tlsCA = fs.String(
+			"tls.ca",
+			getEnv("TLS_CA", ""),
+			"CA of the certificates the services present to each other, client certificates are not required if empty",
+		)
+		tlsCert = fs.String(
+			"tls.cert",
+			getEnv("TLS_CERT", ""),
+			"Certificate served and presented to the other services, TLS is disabled if empty",
+		)
+		tlsKey = fs.String(
+			"tls.key",
+			getEnv("TLS_KEY", ""),
+			"Private key of the certificate",
+		)
 		retryMax = flag.Int(
 			"retry.max",
 			getEnvAsInt("RETRY_MAX", 3),
@@ -74,8 +120,20 @@ func main() {
 		logger = log.With(logger, "caller", log.DefaultCaller)
 	}
 
+	// The certificate is both served and presented to the other services.
+	tlsFiles := tlsconfig.Files{CA: *tlsCA, Cert: *tlsCert, Key: *tlsKey}
+	serverTLS, err := tlsconfig.Server(tlsFiles)
+	if err != nil {
+		logger.Log("during", "tlsconfig.Server", "err", err)
+		os.Exit(1)
+	}
+	clientTLS, err := tlsconfig.Client(tlsFiles)
+	if err != nil {
+		logger.Log("during", "tlsconfig.Client", "err", err)
+		os.Exit(1)
+	}
+
 	var db *libgorm.DB
-	var err error
 	{
 		if *databaseURL != "" {
 			db, err = libgorm.Open(postgres.Open(*databaseURL), &libgorm.Config{})
@@ -126,13 +184,28 @@ func main() {
 		defer registrar.Deregister()
 	}
 
-	db.AutoMigrate(&tasksvc.Task{})
+	db.AutoMigrate(&tasksvc.Task{}, &tasksvc.Event{}, &tasksvc.FieldClock{}, &tasksvc.ClientTask{}, &tasksvc.EventSequence{})
 	taskRepository := gorm.NewTaskRepository(db)
-	authEndpoints, _ := authclient.New(client, logger, *retryMax, *retryTimeout)
-	userEndpoints, _ := userclient.New(client, logger, *retryMax, *retryTimeout)
+	authEndpoints, _ := authclient.New(client, clientTLS, logger, *retryMax, *retryTimeout)
+	userEndpoints, _ := userclient.New(client, clientTLS, logger, *retryMax, *retryTimeout)
 
 	fieldKeys := []string{"method"}
 
+	// The validations are only cached while the revocations are watched,
+	// see the group below.
+	var validationCache *taskservice.ValidationCache
+	if *validationTTL > 0 {
+		validationCache = taskservice.NewValidationCache(
+			*validationTTL,
+			kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+				Namespace: "api",
+				Subsystem: "task_service",
+				Name:      "validation_cache_lookups",
+				Help:      "Number of cached validation lookups, by kind and hit or miss.",
+			}, []string{"kind", "result"}),
+		)
+	}
+
 	var service taskservice.Service
 	{
 		service = taskservice.New(taskRepository, logger)
@@ -155,12 +228,21 @@ func main() {
 			context.Background(),
 			authEndpoints.ValidateEndpoint,
 			userEndpoints.IsExistsEndpoint,
+			validationCache,
 		)(service)
 	}
 
+	method := stdjwt.GetSigningMethod(*jwtAlg)
+	if method == nil {
+		logger.Log("err", "unsupported JWT signing algorithm", "alg", *jwtAlg)
+		registrar.Deregister()
+		os.Exit(1)
+	}
+
 	var (
+		keys       = jwks.NewCache(authEndpoints.JWKS, method, jwks.DefaultTTL)
 		endpoints  = taskendpoint.New(service, logger)
-		grpcServer = tasktransport.NewGRPCServer(endpoints, logger)
+		grpcServer = tasktransport.NewGRPCServer(endpoints, keys, authEndpoints.ResolvePAT, []byte(*identityKey), logger)
 	)
 
 	var g group.Group
@@ -174,13 +256,49 @@ func main() {
 		}
 		g.Add(func() error {
 			logger.Log("transport", "gRPC", "addr", *grpcAddr)
-			baseServer := grpc.NewServer(grpc.UnaryInterceptor(kitgrpc.Interceptor))
+			options := []grpc.ServerOption{grpc.UnaryInterceptor(kitgrpc.Interceptor)}
+			if serverTLS != nil {
+				options = append(options, grpc.Creds(credentials.NewTLS(serverTLS)))
+			}
+			baseServer := grpc.NewServer(options...)
 			pb.RegisterTaskSVCServer(baseServer, grpcServer)
 			return baseServer.Serve(grpcListener)
 		}, func(error) {
 			grpcListener.Close()
 		})
 	}
+	{
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return taskservice.PruneEvents(ctx, taskRepository, *eventsRetention, log.With(logger, "component", "PruneEvents"))
+		}, func(error) {
+			cancel()
+		})
+	}
+	if validationCache != nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return validationCache.Watch(ctx, authEndpoints.Revocations, log.With(logger, "component", "ValidationCache"))
+		}, func(error) {
+			cancel()
+		})
+	}
+	if *debugAddr != "" {
+		// The debug listener serves the metrics, e.g. the hit rate of the
+		// validation cache.
+		debugListener, err := net.Listen("tcp", *debugAddr)
+		if err != nil {
+			logger.Log("transport", "debug/HTTP", "during", "Listen", "err", err)
+			registrar.Deregister()
+			os.Exit(1)
+		}
+		g.Add(func() error {
+			logger.Log("transport", "debug/HTTP", "addr", *debugAddr)
+			return http.Serve(debugListener, promhttp.Handler())
+		}, func(error) {
+			debugListener.Close()
+		})
+	}
 	{
 		// This function just sits and waits for ctrl-C.
 		cancelInterrupt := make(chan struct{})
@@ -1,6 +1,6 @@
 #!/bin/sh
 
-ACCESS_SECRET=uV3gqXaPOQrvJCQ1CgB2QML3ZhQ66cNk
+JWT_ALG=RS256
 RETRY_TIMEOUT=5000
 
-go run main.go --grpc.addr=127.0.0.1:8082 --consul.addr=127.0.0.1:8500
+go run main.go --grpc.addr=127.0.0.1:8082 --consul.addr=127.0.0.1:8500 --debug.addr=127.0.0.1:8083
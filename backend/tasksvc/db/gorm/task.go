@@ -1,10 +1,16 @@
 package gorm
 
 import (
+	"errors"
+	"time"
+
 	"github.com/ichigozero/gtdkit/backend/tasksvc"
 	libgorm "gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+var errSequenceMissing = errors.New("event sequence could not be created")
+
 type taskRepository struct {
 	db *libgorm.DB
 }
@@ -15,9 +21,18 @@ func NewTaskRepository(db *libgorm.DB) tasksvc.TaskRepository {
 
 func (t *taskRepository) Create(title, description string, userID uint64) (tasksvc.Task, error) {
 	task := tasksvc.Task{Title: title, Description: description, Done: false, UserID: userID}
-	result := t.db.Create(&task)
+	err := t.db.Transaction(func(tx *libgorm.DB) error {
+		if err := tx.Create(&task).Error; err != nil {
+			return err
+		}
+		eventID, err := recordEvent(tx, tasksvc.EventCreated, task)
+		if err != nil {
+			return err
+		}
+		return setClocks(tx, task.ID, tasksvc.Fields, time.Now().UTC(), eventID)
+	})
 
-	return task, result.Error
+	return task, err
 }
 
 func (t *taskRepository) FindAll(userID uint64) ([]tasksvc.Task, error) {
@@ -34,32 +49,282 @@ func (t *taskRepository) Find(userID, taskID uint64) (tasksvc.Task, error) {
 	return task, result.Error
 }
 
+// Update compares the task with the row it locked, so that the clocks are
+// set for the fields this update changed and not a concurrent one.
 func (t *taskRepository) Update(task tasksvc.Task) (tasksvc.Task, error) {
-	tk, err := t.Find(task.UserID, task.ID)
+	var tk tasksvc.Task
+	err := t.db.Transaction(func(tx *libgorm.DB) error {
+		if err := forUpdate(tx).Where("id = ? AND user_id = ?", task.ID, task.UserID).First(&tk).Error; err != nil {
+			return err
+		}
+
+		fields := changedFields(tk, task)
+		result := tx.Model(&tk).Updates(
+			map[string]interface{}{
+				"title":       task.Title,
+				"description": task.Description,
+				"done":        task.Done,
+				"user_id":     task.UserID,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		eventID, err := recordEvent(tx, tasksvc.EventUpdated, tk)
+		if err != nil {
+			return err
+		}
+		return setClocks(tx, tk.ID, fields, time.Now().UTC(), eventID)
+	})
 	if err != nil {
 		return tasksvc.Task{}, err
 	}
 
-	result := t.db.Model(&tk).Updates(
-		map[string]interface{}{
-			"title":       task.Title,
-			"description": task.Description,
-			"done":        task.Done,
-			"user_id":     task.UserID,
-		})
-	if result.Error != nil {
-		return tasksvc.Task{}, result.Error
-	}
-
 	return tk, nil
 }
 
 func (t *taskRepository) Delete(userID, taskID uint64) (bool, error) {
 	task := tasksvc.Task{ID: taskID}
-	result := t.db.Where("user_id", userID).Delete(&task)
+	err := t.db.Transaction(func(tx *libgorm.DB) error {
+		result := tx.Where("user_id", userID).Delete(&task)
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		if _, err := recordEvent(tx, tasksvc.EventDeleted, tasksvc.Task{ID: taskID, UserID: userID}); err != nil {
+			return err
+		}
+		return tx.Where("task_id = ?", taskID).Delete(&tasksvc.FieldClock{}).Error
+	})
 
-	if result.Error != nil {
-		return false, result.Error
+	if err != nil {
+		return false, err
 	}
 	return true, nil
 }
+
+func (t *taskRepository) Events(userID, afterID uint64, limit int) ([]tasksvc.Event, error) {
+	var events []tasksvc.Event
+	result := t.db.Where("user_id = ? AND id > ?", userID, afterID).Order("id").Limit(limit).Find(&events)
+
+	return events, result.Error
+}
+
+func (t *taskRepository) EventRange() (uint64, uint64, error) {
+	var r struct {
+		FirstID uint64
+		LastID  uint64
+	}
+	result := t.db.Model(&tasksvc.Event{}).Select("COALESCE(MIN(id), 0) AS first_id, COALESCE(MAX(id), 0) AS last_id").Scan(&r)
+
+	return r.FirstID, r.LastID, result.Error
+}
+
+func (t *taskRepository) EventUsers(afterID uint64) ([]uint64, uint64, error) {
+	var rows []struct {
+		UserID uint64
+		LastID uint64
+	}
+	result := t.db.Model(&tasksvc.Event{}).Select("user_id, MAX(id) AS last_id").Where("id > ?", afterID).Group("user_id").Scan(&rows)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+
+	userIDs := make([]uint64, len(rows))
+	last := afterID
+	for i, r := range rows {
+		userIDs[i] = r.UserID
+		if r.LastID > last {
+			last = r.LastID
+		}
+	}
+	return userIDs, last, nil
+}
+
+func (t *taskRepository) DeleteEvents(before time.Time) error {
+	return t.db.Where("created_at < ?", before).Delete(&tasksvc.Event{}).Error
+}
+
+func (t *taskRepository) SyncTask(userID, taskID uint64, merge func(tasksvc.Task, map[string]tasksvc.FieldClock, bool) tasksvc.Merge) (tasksvc.Task, uint64, error) {
+	var (
+		task     tasksvc.Task
+		eventID  uint64
+		clientID string
+	)
+	err := t.db.Transaction(func(tx *libgorm.DB) error {
+		found := false
+		clocks := make(map[string]tasksvc.FieldClock)
+		if taskID != 0 {
+			err := forUpdate(tx).Where("id = ? AND user_id = ?", taskID, userID).First(&task).Error
+			switch {
+			case err == nil:
+				found = true
+			case !errors.Is(err, libgorm.ErrRecordNotFound):
+				return err
+			}
+		}
+		if found {
+			var cs []tasksvc.FieldClock
+			if err := tx.Where("task_id = ?", taskID).Find(&cs).Error; err != nil {
+				return err
+			}
+			for _, c := range cs {
+				clocks[c.Field] = c
+			}
+		}
+
+		m := merge(task, clocks, found)
+
+		var (
+			eventType string
+			err       error
+		)
+		switch {
+		case m.Create:
+			task = m.Task
+			task.ID = 0
+			task.UserID = userID
+			err = tx.Create(&task).Error
+			if err == nil {
+				err = tx.Create(&tasksvc.ClientTask{UserID: userID, ClientID: m.ClientID, TaskID: task.ID}).Error
+			}
+			eventType = tasksvc.EventCreated
+			clientID = m.ClientID
+		case m.Delete && found:
+			err = tx.Delete(&task).Error
+			if err == nil {
+				err = tx.Where("task_id = ?", task.ID).Delete(&tasksvc.FieldClock{}).Error
+			}
+			task = tasksvc.Task{ID: task.ID, UserID: userID}
+			eventType = tasksvc.EventDeleted
+		case len(m.Fields) > 0 && found:
+			updates := make(map[string]interface{}, len(m.Fields))
+			for _, f := range m.Fields {
+				switch f {
+				case tasksvc.FieldTitle:
+					updates["title"] = m.Task.Title
+				case tasksvc.FieldDescription:
+					updates["description"] = m.Task.Description
+				case tasksvc.FieldDone:
+					updates["done"] = m.Task.Done
+				}
+			}
+			err = tx.Model(&task).Updates(updates).Error
+			eventType = tasksvc.EventUpdated
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		eventID, err = recordEvent(tx, eventType, task)
+		if err != nil || m.Delete {
+			return err
+		}
+		return setClocks(tx, task.ID, m.Fields, m.ChangedAt, eventID)
+	})
+	if err != nil && clientID != "" {
+		// The primary key of the client tasks was violated if a
+		// concurrent sync created the task first.
+		if id, lookupErr := t.CreatedTask(userID, clientID); lookupErr == nil && id != 0 {
+			return tasksvc.Task{}, 0, tasksvc.ErrClientIDTaken
+		}
+	}
+	if err != nil {
+		return tasksvc.Task{}, 0, err
+	}
+
+	return task, eventID, nil
+}
+
+func (t *taskRepository) CreatedTask(userID uint64, clientID string) (uint64, error) {
+	var tasks []tasksvc.ClientTask
+	result := t.db.Where("user_id = ? AND client_id = ?", userID, clientID).Limit(1).Find(&tasks)
+	if result.Error != nil || len(tasks) == 0 {
+		return 0, result.Error
+	}
+
+	return tasks[0].TaskID, nil
+}
+
+// eventSequenceID is the ID of the single row of the event sequence.
+const eventSequenceID = 1
+
+// recordEvent is called within the transaction of the change, so that the
+// event is only recorded if the change is. It returns the ID of the event.
+// The sequence stays locked until the transaction ends, which should
+// therefore record the event last, or nearly so.
+func recordEvent(tx *libgorm.DB, eventType string, task tasksvc.Task) (uint64, error) {
+	id, err := nextEventID(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	event := tasksvc.Event{ID: id, UserID: task.UserID, Type: eventType, Task: task}
+	if err := tx.Create(&event).Error; err != nil {
+		return 0, err
+	}
+	return event.ID, nil
+}
+
+// nextEventID increments the event sequence, which is created on the first
+// event and continues from the events recorded before it existed.
+func nextEventID(tx *libgorm.DB) (uint64, error) {
+	for i := 0; i < 2; i++ {
+		result := tx.Model(&tasksvc.EventSequence{}).Where("id = ?", eventSequenceID).UpdateColumn("last", libgorm.Expr("last + 1"))
+		if result.Error != nil {
+			return 0, result.Error
+		}
+		if result.RowsAffected == 1 {
+			var seq tasksvc.EventSequence
+			err := tx.Where("id = ?", eventSequenceID).First(&seq).Error
+			return seq.Last, err
+		}
+
+		var last uint64
+		if err := tx.Model(&tasksvc.Event{}).Select("COALESCE(MAX(id), 0)").Scan(&last).Error; err != nil {
+			return 0, err
+		}
+		seq := tasksvc.EventSequence{ID: eventSequenceID, Last: last}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&seq).Error; err != nil {
+			return 0, err
+		}
+	}
+	return 0, errSequenceMissing
+}
+
+// setClocks records that the fields were changed at changedAt by the event.
+func setClocks(tx *libgorm.DB, taskID uint64, fields []string, changedAt time.Time, eventID uint64) error {
+	if len(fields) == 0 {
+		return nil
+	}
+
+	clocks := make([]tasksvc.FieldClock, 0, len(fields))
+	for _, f := range fields {
+		clocks = append(clocks, tasksvc.FieldClock{TaskID: taskID, Field: f, ChangedAt: changedAt, EventID: eventID})
+	}
+	return tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&clocks).Error
+}
+
+// changedFields returns the synced fields whose values differ.
+func changedFields(old, task tasksvc.Task) []string {
+	var fields []string
+	if old.Title != task.Title {
+		fields = append(fields, tasksvc.FieldTitle)
+	}
+	if old.Description != task.Description {
+		fields = append(fields, tasksvc.FieldDescription)
+	}
+	if old.Done != task.Done {
+		fields = append(fields, tasksvc.FieldDone)
+	}
+	return fields
+}
+
+// forUpdate locks the rows read until the end of the transaction. SQLite
+// has no row locks, its transactions are serialized instead.
+func forUpdate(tx *libgorm.DB) *libgorm.DB {
+	if tx.Dialector.Name() == "sqlite" {
+		return tx
+	}
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"})
+}
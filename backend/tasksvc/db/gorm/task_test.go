@@ -0,0 +1,157 @@
+package gorm_test
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/tasksvc"
+	"github.com/ichigozero/gtdkit/backend/tasksvc/db/gorm"
+	"gorm.io/driver/sqlite"
+	libgorm "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newTestRepository(t *testing.T) tasksvc.TaskRepository {
+	db, err := libgorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gorm.db")), &libgorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&tasksvc.Task{}, &tasksvc.Event{}, &tasksvc.FieldClock{}, &tasksvc.ClientTask{}, &tasksvc.EventSequence{}); err != nil {
+		t.Fatal(err)
+	}
+	return gorm.NewTaskRepository(db)
+}
+
+// create returns the merge of a task created offline as clientID.
+func create(clientID string) func(tasksvc.Task, map[string]tasksvc.FieldClock, bool) tasksvc.Merge {
+	return func(tasksvc.Task, map[string]tasksvc.FieldClock, bool) tasksvc.Merge {
+		return tasksvc.Merge{
+			Task:      tasksvc.Task{Title: "created offline"},
+			Fields:    tasksvc.Fields,
+			ChangedAt: time.Now().UTC(),
+			Create:    true,
+			ClientID:  clientID,
+		}
+	}
+}
+
+func TestSyncTaskCreatesOncePerClientID(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestRepository(t)
+	task, _, err := repo.SyncTask(1, 0, create("c1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := repo.SyncTask(1, 0, create("c1")); err != tasksvc.ErrClientIDTaken {
+		t.Errorf("want %v creating the task again, got %v", tasksvc.ErrClientIDTaken, err)
+	}
+	if id, err := repo.CreatedTask(1, "c1"); err != nil || id != task.ID {
+		t.Errorf("want the task %d created as c1, got %d, %v", task.ID, id, err)
+	}
+	if _, _, err := repo.SyncTask(2, 0, create("c1")); err != nil {
+		t.Errorf("want the client ID of another user accepted, got %v", err)
+	}
+
+	// The later changes of the task are not constrained.
+	for _, title := range []string{"first", "second"} {
+		task.Title = title
+		if _, err := repo.Update(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tasks, err := repo.FindAll(1)
+	if err != nil || len(tasks) != 1 || tasks[0].Title != "second" {
+		t.Errorf("want the one task of the user updated, got %+v, %v", tasks, err)
+	}
+}
+
+// TestCreatedTaskOutlivesEvents checks that a task created offline is not
+// created again by a sync retried after its events were pruned.
+func TestCreatedTaskOutlivesEvents(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestRepository(t)
+	task, _, err := repo.SyncTask(1, 0, create("c1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Delete(1, task.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteEvents(time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	if id, err := repo.CreatedTask(1, "c1"); err != nil || id != task.ID {
+		t.Errorf("want the task %d created as c1, got %d, %v", task.ID, id, err)
+	}
+	if _, _, err := repo.SyncTask(1, 0, create("c1")); err != tasksvc.ErrClientIDTaken {
+		t.Errorf("want %v creating the task again, got %v", tasksvc.ErrClientIDTaken, err)
+	}
+}
+
+// TestEventsNumberedWithoutGaps checks that the events of every user share
+// one sequence, which a rolled back change leaves no gap in, so that the
+// watchers may move their cursor past any event they see.
+func TestEventsNumberedWithoutGaps(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestRepository(t)
+	task, err := repo.Create("first", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Create("second", "", 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := repo.SyncTask(1, 0, create("c1")); err != nil {
+		t.Fatal(err)
+	}
+	// Rolled back, as the client ID is taken.
+	if _, _, err := repo.SyncTask(1, 0, create("c1")); err != tasksvc.ErrClientIDTaken {
+		t.Fatalf("want %v, got %v", tasksvc.ErrClientIDTaken, err)
+	}
+	task.Title = "updated"
+	if _, err := repo.Update(task); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Delete(2, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []uint64
+	for _, userID := range []uint64{1, 2} {
+		events, err := repo.Events(userID, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range events {
+			ids = append(ids, e.ID)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for i, id := range ids {
+		if id != uint64(i+1) {
+			t.Fatalf("want the events numbered 1 to %d, got %v", len(ids), ids)
+		}
+	}
+	if first, last, err := repo.EventRange(); err != nil || first != 1 || last != 5 {
+		t.Errorf("want events 1 to 5, got %d to %d, %v", first, last, err)
+	}
+
+	for afterID, want := range map[uint64][]uint64{3: {1, 2}, 4: {2}, 5: {}} {
+		userIDs, last, err := repo.EventUsers(afterID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+		if fmt.Sprint(userIDs) != fmt.Sprint(want) || last != 5 {
+			t.Errorf("after %d: want users %v up to 5, got %v up to %d", afterID, want, userIDs, last)
+		}
+	}
+}
@@ -23,6 +23,12 @@ type TaskSVCClient interface {
 	Task(ctx context.Context, in *TaskRequest, opts ...grpc.CallOption) (*TaskReply, error)
 	UpdateTask(ctx context.Context, in *UpdateTaskRequest, opts ...grpc.CallOption) (*UpdateTaskReply, error)
 	DeleteTask(ctx context.Context, in *DeleteTaskRequest, opts ...grpc.CallOption) (*DeleteTaskReply, error)
+	// WatchTasks streams the changes of the tasks of the caller, as long as
+	// the call lasts.
+	WatchTasks(ctx context.Context, in *WatchTasksRequest, opts ...grpc.CallOption) (TaskSVC_WatchTasksClient, error)
+	// Sync applies the changes a client made offline, and returns the
+	// changes made on the server since its previous sync.
+	Sync(ctx context.Context, in *SyncRequest, opts ...grpc.CallOption) (*SyncReply, error)
 }
 
 type taskSVCClient struct {
@@ -78,6 +84,47 @@ func (c *taskSVCClient) DeleteTask(ctx context.Context, in *DeleteTaskRequest, o
 	return out, nil
 }
 
+func (c *taskSVCClient) WatchTasks(ctx context.Context, in *WatchTasksRequest, opts ...grpc.CallOption) (TaskSVC_WatchTasksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &TaskSVC_ServiceDesc.Streams[0], "/pb.TaskSVC/WatchTasks", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &taskSVCWatchTasksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type TaskSVC_WatchTasksClient interface {
+	Recv() (*TaskEvent, error)
+	grpc.ClientStream
+}
+
+type taskSVCWatchTasksClient struct {
+	grpc.ClientStream
+}
+
+func (x *taskSVCWatchTasksClient) Recv() (*TaskEvent, error) {
+	m := new(TaskEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *taskSVCClient) Sync(ctx context.Context, in *SyncRequest, opts ...grpc.CallOption) (*SyncReply, error) {
+	out := new(SyncReply)
+	err := c.cc.Invoke(ctx, "/pb.TaskSVC/Sync", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // TaskSVCServer is the server API for TaskSVC service.
 // All implementations must embed UnimplementedTaskSVCServer
 // for forward compatibility
@@ -87,6 +134,12 @@ type TaskSVCServer interface {
 	Task(context.Context, *TaskRequest) (*TaskReply, error)
 	UpdateTask(context.Context, *UpdateTaskRequest) (*UpdateTaskReply, error)
 	DeleteTask(context.Context, *DeleteTaskRequest) (*DeleteTaskReply, error)
+	// WatchTasks streams the changes of the tasks of the caller, as long as
+	// the call lasts.
+	WatchTasks(*WatchTasksRequest, TaskSVC_WatchTasksServer) error
+	// Sync applies the changes a client made offline, and returns the
+	// changes made on the server since its previous sync.
+	Sync(context.Context, *SyncRequest) (*SyncReply, error)
 	mustEmbedUnimplementedTaskSVCServer()
 }
 
@@ -109,6 +162,12 @@ func (UnimplementedTaskSVCServer) UpdateTask(context.Context, *UpdateTaskRequest
 func (UnimplementedTaskSVCServer) DeleteTask(context.Context, *DeleteTaskRequest) (*DeleteTaskReply, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteTask not implemented")
 }
+func (UnimplementedTaskSVCServer) WatchTasks(*WatchTasksRequest, TaskSVC_WatchTasksServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchTasks not implemented")
+}
+func (UnimplementedTaskSVCServer) Sync(context.Context, *SyncRequest) (*SyncReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Sync not implemented")
+}
 func (UnimplementedTaskSVCServer) mustEmbedUnimplementedTaskSVCServer() {}
 
 // UnsafeTaskSVCServer may be embedded to opt out of forward compatibility for this service.
@@ -212,6 +271,45 @@ func _TaskSVC_DeleteTask_Handler(srv interface{}, ctx context.Context, dec func(
 	return interceptor(ctx, in, info, handler)
 }
 
+func _TaskSVC_WatchTasks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchTasksRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(TaskSVCServer).WatchTasks(m, &taskSVCWatchTasksServer{stream})
+}
+
+type TaskSVC_WatchTasksServer interface {
+	Send(*TaskEvent) error
+	grpc.ServerStream
+}
+
+type taskSVCWatchTasksServer struct {
+	grpc.ServerStream
+}
+
+func (x *taskSVCWatchTasksServer) Send(m *TaskEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _TaskSVC_Sync_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SyncRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaskSVCServer).Sync(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/pb.TaskSVC/Sync",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaskSVCServer).Sync(ctx, req.(*SyncRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // TaskSVC_ServiceDesc is the grpc.ServiceDesc for TaskSVC service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -239,7 +337,17 @@ var TaskSVC_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteTask",
 			Handler:    _TaskSVC_DeleteTask_Handler,
 		},
+		{
+			MethodName: "Sync",
+			Handler:    _TaskSVC_Sync_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchTasks",
+			Handler:       _TaskSVC_WatchTasks_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "tasksvc.proto",
 }
@@ -4,11 +4,15 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"strings"
 
 	stdjwt "github.com/dgrijalva/jwt-go"
 	kitjwt "github.com/go-kit/kit/auth/jwt"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/authsvc/authz"
+	"github.com/ichigozero/gtdkit/backend/authsvc/identity"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
 	"github.com/ichigozero/gtdkit/backend/tasksvc"
 	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/taskservice"
 )
@@ -19,43 +23,67 @@ type Set struct {
 	TaskEndpoint       endpoint.Endpoint
 	UpdateTaskEndpoint endpoint.Endpoint
 	DeleteTaskEndpoint endpoint.Endpoint
+	WatchTasksEndpoint endpoint.Endpoint
+	SyncEndpoint       endpoint.Endpoint
 }
 
 func New(svc taskservice.Service, logger log.Logger) Set {
 	var createTaskEndpoint endpoint.Endpoint
 	{
 		createTaskEndpoint = MakeCreateTaskEndpoint(svc)
+		createTaskEndpoint = authz.Require(authz.DefaultPolicy, authz.WriteTasks)(createTaskEndpoint)
 		createTaskEndpoint = LoggingMiddleware(log.With(logger, "method", "CreateTask"))(createTaskEndpoint)
 	}
 	var tasksEndpoint endpoint.Endpoint
 	{
 		tasksEndpoint = MakeTasksEndpoint(svc)
+		tasksEndpoint = authz.Require(authz.DefaultPolicy, authz.ReadTasks)(tasksEndpoint)
 		tasksEndpoint = LoggingMiddleware(log.With(logger, "method", "Tasks"))(tasksEndpoint)
 	}
 	var taskEndpoint endpoint.Endpoint
 	{
 		taskEndpoint = MakeTaskEndpoint(svc)
+		taskEndpoint = authz.Require(authz.DefaultPolicy, authz.ReadTasks)(taskEndpoint)
 		taskEndpoint = LoggingMiddleware(log.With(logger, "method", "Task"))(taskEndpoint)
 	}
 
 	var updateTaskEndpoint endpoint.Endpoint
 	{
 		updateTaskEndpoint = MakeUpdateTaskEndpoint(svc)
+		updateTaskEndpoint = authz.Require(authz.DefaultPolicy, authz.WriteTasks)(updateTaskEndpoint)
 		updateTaskEndpoint = LoggingMiddleware(log.With(logger, "method", "UpdateTask"))(updateTaskEndpoint)
 	}
 
 	var deleteTaskEndpoint endpoint.Endpoint
 	{
 		deleteTaskEndpoint = MakeDeleteTaskEndpoint(svc)
+		deleteTaskEndpoint = authz.Require(authz.DefaultPolicy, authz.WriteTasks)(deleteTaskEndpoint)
 		deleteTaskEndpoint = LoggingMiddleware(log.With(logger, "method", "DeleteTask"))(deleteTaskEndpoint)
 	}
 
+	var watchTasksEndpoint endpoint.Endpoint
+	{
+		watchTasksEndpoint = MakeWatchTasksEndpoint(svc)
+		watchTasksEndpoint = authz.Require(authz.DefaultPolicy, authz.ReadTasks)(watchTasksEndpoint)
+		watchTasksEndpoint = LoggingMiddleware(log.With(logger, "method", "WatchTasks"))(watchTasksEndpoint)
+	}
+
+	// A sync both reads and writes the tasks.
+	var syncEndpoint endpoint.Endpoint
+	{
+		syncEndpoint = MakeSyncEndpoint(svc)
+		syncEndpoint = authz.Require(authz.DefaultPolicy, authz.ReadTasks, authz.WriteTasks)(syncEndpoint)
+		syncEndpoint = LoggingMiddleware(log.With(logger, "method", "Sync"))(syncEndpoint)
+	}
+
 	return Set{
 		CreateTaskEndpoint: createTaskEndpoint,
 		TasksEndpoint:      tasksEndpoint,
 		TaskEndpoint:       taskEndpoint,
 		UpdateTaskEndpoint: updateTaskEndpoint,
 		DeleteTaskEndpoint: deleteTaskEndpoint,
+		WatchTasksEndpoint: watchTasksEndpoint,
+		SyncEndpoint:       syncEndpoint,
 	}
 }
 
@@ -112,6 +140,24 @@ func (s Set) DeleteTask(ctx context.Context, a tasksvc.Auth, taskID uint64) (boo
 	return response.Result, response.Err
 }
 
+func (s Set) WatchTasks(ctx context.Context, a tasksvc.Auth, lastEventID uint64, send func(tasksvc.Event) error) error {
+	resp, err := s.WatchTasksEndpoint(ctx, WatchTasksRequest{LastEventID: lastEventID, Send: send})
+	if err != nil {
+		return err
+	}
+	response := resp.(WatchTasksResponse)
+	return response.Err
+}
+
+func (s Set) Sync(ctx context.Context, a tasksvc.Auth, cursor uint64, changes []tasksvc.Change) (tasksvc.SyncResult, error) {
+	resp, err := s.SyncEndpoint(ctx, SyncRequest{Cursor: cursor, Changes: changes})
+	if err != nil {
+		return tasksvc.SyncResult{}, err
+	}
+	response := resp.(SyncResponse)
+	return response.Result, response.Err
+}
+
 func MakeCreateTaskEndpoint(s taskservice.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		auth, err := claims(ctx)
@@ -187,6 +233,35 @@ func MakeDeleteTaskEndpoint(s taskservice.Service) endpoint.Endpoint {
 	}
 }
 
+// MakeWatchTasksEndpoint returns an endpoint which only returns once the
+// stream ended, the events are passed to the Send function of the request
+// meanwhile.
+func MakeWatchTasksEndpoint(s taskservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		auth, err := claims(ctx)
+		if err != nil {
+			return WatchTasksResponse{Err: err}, nil
+		}
+
+		req := request.(WatchTasksRequest)
+		err = s.WatchTasks(ctx, auth, req.LastEventID, req.Send)
+		return WatchTasksResponse{Err: err}, nil
+	}
+}
+
+func MakeSyncEndpoint(s taskservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		auth, err := claims(ctx)
+		if err != nil {
+			return SyncResponse{Err: err}, nil
+		}
+
+		req := request.(SyncRequest)
+		r, err := s.Sync(ctx, auth, req.Cursor, req.Changes)
+		return SyncResponse{Result: r, Err: err}, nil
+	}
+}
+
 func claims(ctx context.Context) (tasksvc.Auth, error) {
 	claims, ok := ctx.Value(kitjwt.JWTClaimsContextKey).(stdjwt.MapClaims)
 	if !ok {
@@ -203,7 +278,21 @@ func claims(ctx context.Context) (tasksvc.Auth, error) {
 		return tasksvc.Auth{}, tasksvc.ErrClaimsMissing
 	}
 
-	return tasksvc.Auth{AccessUUID: uuid, UserID: userID}, nil
+	// The scopes were already enforced by authz.Require, they are passed on
+	// so that the service can tell the requests of clients apart.
+	clientID, _ := claims["client_id"].(string)
+	scope, _ := claims["scope"].(string)
+	personal, _ := claims[pat.Claim].(bool)
+	validated, _ := claims[identity.Claim].(bool)
+
+	return tasksvc.Auth{
+		AccessUUID:          uuid,
+		UserID:              userID,
+		ClientID:            clientID,
+		Scopes:              strings.Fields(scope),
+		PersonalAccessToken: personal,
+		SessionValidated:    validated,
+	}, nil
 }
 
 var (
@@ -212,6 +301,8 @@ var (
 	_ endpoint.Failer = TaskResponse{}
 	_ endpoint.Failer = UpdateTaskResponse{}
 	_ endpoint.Failer = DeleteTaskResponse{}
+	_ endpoint.Failer = WatchTasksResponse{}
+	_ endpoint.Failer = SyncResponse{}
 )
 
 type CreateTaskRequest struct {
@@ -270,3 +361,29 @@ type DeleteTaskResponse struct {
 }
 
 func (r DeleteTaskResponse) Failed() error { return r.Err }
+
+type WatchTasksRequest struct {
+	LastEventID uint64
+	// Send is called with every event of the stream, which ends if it
+	// fails.
+	Send func(tasksvc.Event) error
+}
+
+// WatchTasksResponse holds the error which ended the stream.
+type WatchTasksResponse struct {
+	Err error `json:"-"`
+}
+
+func (r WatchTasksResponse) Failed() error { return r.Err }
+
+type SyncRequest struct {
+	Cursor  uint64
+	Changes []tasksvc.Change
+}
+
+type SyncResponse struct {
+	Result tasksvc.SyncResult `json:"result"`
+	Err    error              `json:"-"`
+}
+
+func (r SyncResponse) Failed() error { return r.Err }
@@ -0,0 +1,180 @@
+package taskservice
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/ichigozero/gtdkit/backend/authsvc"
+)
+
+const (
+	// revocationWait is how long a request for the revocations is held
+	// open by authsvc when there is none.
+	revocationWait = 20 * time.Second
+	// watchBackoff is the pause after a failed request for the revocations.
+	watchBackoff = time.Second
+)
+
+// RevocationFeed returns the access tokens revoked after since and the
+// cursor of the next call, waiting up to wait for a revocation. The
+// Revocations method of the authsvc endpoint set is typically used.
+type RevocationFeed func(ctx context.Context, since uint64, wait time.Duration) ([]authsvc.Revocation, uint64, error)
+
+// ValidationCache remembers the sessions and the users which were
+// successfully validated, so that the proxing middleware does not call
+// authsvc and usersvc on every request. A session is dropped as soon as
+// authsvc reports it revoked, which relies on Watch running. Until Watch
+// is connected, and whenever it loses the connection, nothing is cached.
+type ValidationCache struct {
+	ttl     time.Duration
+	lookups metrics.Counter
+
+	mtx       sync.Mutex
+	connected bool
+	sessions  map[string]time.Time
+	users     map[uint64]time.Time
+	revoked   map[string]time.Time
+}
+
+// NewValidationCache returns a cache which keeps a validation for ttl.
+// The lookups are counted with the "kind" label, either session or user,
+// and the "result" label, either hit or miss.
+func NewValidationCache(ttl time.Duration, lookups metrics.Counter) *ValidationCache {
+	return &ValidationCache{
+		ttl:      ttl,
+		lookups:  lookups,
+		sessions: make(map[string]time.Time),
+		users:    make(map[uint64]time.Time),
+		revoked:  make(map[string]time.Time),
+	}
+}
+
+// Watch drops the sessions revoked in authsvc until ctx is done. The
+// cache is flushed whenever the revocations cannot be read, as a
+// revocation could be missed meanwhile. The revocations are read from
+// the start again if authsvc lost them, e.g. as its store was reset.
+func (c *ValidationCache) Watch(ctx context.Context, feed RevocationFeed, logger log.Logger) error {
+	var since uint64
+	for {
+		revocations, next, err := feed(ctx, since, revocationWait)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			logger.Log("during", "Revocations", "err", err)
+			c.disconnect()
+			if err == authsvc.ErrRevocationsLost {
+				since = 0
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(watchBackoff):
+			}
+			continue
+		}
+
+		c.revoke(revocations)
+		since = next
+	}
+}
+
+// ValidSession reports whether the session was validated within the ttl.
+func (c *ValidationCache) ValidSession(accessUUID string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.lookup("session", c.sessions[accessUUID])
+}
+
+// AddSession caches the validation of the session, unless it was revoked
+// while it was being validated.
+func (c *ValidationCache) AddSession(accessUUID string) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.connected {
+		return
+	}
+	if _, ok := c.revoked[accessUUID]; ok {
+		return
+	}
+	c.sessions[accessUUID] = time.Now().Add(c.ttl)
+}
+
+// UserExists reports whether the user was found within the ttl.
+func (c *ValidationCache) UserExists(userID uint64) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.lookup("user", c.users[userID])
+}
+
+// AddUser caches that the user exists. Deleted users are not reported by
+// authsvc, so they are accepted until the ttl passes.
+func (c *ValidationCache) AddUser(userID uint64) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if !c.connected {
+		return
+	}
+	c.users[userID] = time.Now().Add(c.ttl)
+}
+
+func (c *ValidationCache) lookup(kind string, expiresAt time.Time) bool {
+	hit := c.connected && time.Now().Before(expiresAt)
+
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	c.lookups.With("kind", kind, "result", result).Add(1)
+
+	return hit
+}
+
+// revoke drops the revoked sessions. They are remembered for the ttl, so
+// that a validation which was in flight is not cached afterwards. The
+// expired entries are purged along the way.
+func (c *ValidationCache) revoke(revocations []authsvc.Revocation) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	now := time.Now()
+	for _, r := range revocations {
+		delete(c.sessions, r.AccessUUID)
+		c.revoked[r.AccessUUID] = now.Add(c.ttl)
+	}
+
+	for k, expiresAt := range c.sessions {
+		if !now.Before(expiresAt) {
+			delete(c.sessions, k)
+		}
+	}
+	for k, expiresAt := range c.users {
+		if !now.Before(expiresAt) {
+			delete(c.users, k)
+		}
+	}
+	for k, expiresAt := range c.revoked {
+		if !now.Before(expiresAt) {
+			delete(c.revoked, k)
+		}
+	}
+
+	c.connected = true
+}
+
+func (c *ValidationCache) disconnect() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.connected = false
+	c.sessions = make(map[string]time.Time)
+	c.users = make(map[uint64]time.Time)
+}
@@ -14,6 +14,10 @@ import (
 
 type Middleware func(Service) Service
 
+// watchValidationInterval is how often the proxing middleware validates the
+// streams of WatchTasks again.
+const watchValidationInterval = time.Minute
+
 func LoggingMiddleware(logger log.Logger) Middleware {
 	return func(next Service) Service {
 		return loggingMiddleware{logger, next}
@@ -30,6 +34,7 @@ func (mw loggingMiddleware) CreateTask(ctx context.Context, a tasksvc.Auth, titl
 		mw.logger.Log(
 			"method", "CreateTask",
 			"access_uuid", a.AccessUUID,
+			"client_id", a.ClientID,
 			"title", title,
 			"description", description,
 			"user_id", a.UserID,
@@ -44,6 +49,7 @@ func (mw loggingMiddleware) Tasks(ctx context.Context, a tasksvc.Auth) (t []task
 		mw.logger.Log(
 			"method", "Tasks",
 			"access_uuid", a.AccessUUID,
+			"client_id", a.ClientID,
 			"user_id", a.UserID,
 			"err", err,
 		)
@@ -56,6 +62,7 @@ func (mw loggingMiddleware) Task(ctx context.Context, a tasksvc.Auth, taskID uin
 		mw.logger.Log(
 			"method", "Task",
 			"access_uuid", a.AccessUUID,
+			"client_id", a.ClientID,
 			"user_id", a.UserID,
 			"task_id", taskID,
 			"err", err,
@@ -69,6 +76,7 @@ func (mw loggingMiddleware) UpdateTask(ctx context.Context, a tasksvc.Auth, task
 		mw.logger.Log(
 			"method", "UpdateTask",
 			"access_uuid", a.AccessUUID,
+			"client_id", a.ClientID,
 			"user_id", a.UserID,
 			"task_id", task.ID,
 			"title", task.Title,
@@ -85,6 +93,7 @@ func (mw loggingMiddleware) DeleteTask(ctx context.Context, a tasksvc.Auth, task
 		mw.logger.Log(
 			"method", "DeleteTask",
 			"access_uuid", a.AccessUUID,
+			"client_id", a.ClientID,
 			"user_id", a.UserID,
 			"task_id", taskID,
 			"result", result,
@@ -94,6 +103,44 @@ func (mw loggingMiddleware) DeleteTask(ctx context.Context, a tasksvc.Auth, task
 	return mw.next.DeleteTask(ctx, a, taskID)
 }
 
+func (mw loggingMiddleware) Sync(ctx context.Context, a tasksvc.Auth, cursor uint64, changes []tasksvc.Change) (result tasksvc.SyncResult, err error) {
+	defer func() {
+		mw.logger.Log(
+			"method", "Sync",
+			"access_uuid", a.AccessUUID,
+			"client_id", a.ClientID,
+			"user_id", a.UserID,
+			"cursor", cursor,
+			"changes", len(changes),
+			"next_cursor", result.Cursor,
+			"full", result.Full,
+			"conflicts", len(result.Conflicts),
+			"err", err,
+		)
+	}()
+	return mw.next.Sync(ctx, a, cursor, changes)
+}
+
+func (mw loggingMiddleware) WatchTasks(ctx context.Context, a tasksvc.Auth, lastEventID uint64, send func(tasksvc.Event) error) (err error) {
+	events := 0
+	defer func(begin time.Time) {
+		mw.logger.Log(
+			"method", "WatchTasks",
+			"access_uuid", a.AccessUUID,
+			"client_id", a.ClientID,
+			"user_id", a.UserID,
+			"last_event_id", lastEventID,
+			"events", events,
+			"took", time.Since(begin),
+			"err", err,
+		)
+	}(time.Now())
+	return mw.next.WatchTasks(ctx, a, lastEventID, func(e tasksvc.Event) error {
+		events++
+		return send(e)
+	})
+}
+
 func InstrumentingMiddleware(counter metrics.Counter, latency metrics.Histogram, s Service) Middleware {
 	return func(next Service) Service {
 		return instrumentingMiddleware{counter, latency, next}
@@ -151,9 +198,28 @@ func (mw instrumentingMiddleware) DeleteTask(ctx context.Context, a tasksvc.Auth
 	return mw.next.DeleteTask(ctx, a, taskID)
 }
 
-func ProxingMiddleware(ctx context.Context, validateUUID, isUserExists endpoint.Endpoint) Middleware {
+func (mw instrumentingMiddleware) Sync(ctx context.Context, a tasksvc.Auth, cursor uint64, changes []tasksvc.Change) (result tasksvc.SyncResult, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "sync").Add(1)
+		mw.requestLatency.With("method", "sync").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.Sync(ctx, a, cursor, changes)
+}
+
+// WatchTasks is only counted, its latency is the lifetime of the stream.
+func (mw instrumentingMiddleware) WatchTasks(ctx context.Context, a tasksvc.Auth, lastEventID uint64, send func(tasksvc.Event) error) error {
+	mw.requestCount.With("method", "watch_tasks").Add(1)
+
+	return mw.next.WatchTasks(ctx, a, lastEventID, send)
+}
+
+// ProxingMiddleware validates every request against authsvc and usersvc.
+// The validations are kept in the cache, which may be nil to disable
+// caching.
+func ProxingMiddleware(ctx context.Context, validateUUID, isUserExists endpoint.Endpoint, cache *ValidationCache) Middleware {
 	return func(next Service) Service {
-		return proxingMiddleware{next, validateUUID, isUserExists}
+		return proxingMiddleware{next, validateUUID, isUserExists, cache}
 	}
 }
 
@@ -161,6 +227,7 @@ type proxingMiddleware struct {
 	next         Service
 	validateUUID endpoint.Endpoint
 	isUserExists endpoint.Endpoint
+	cache        *ValidationCache
 }
 
 func (mw proxingMiddleware) CreateTask(ctx context.Context, a tasksvc.Auth, title, description string) (tasksvc.Task, error) {
@@ -208,8 +275,59 @@ func (mw proxingMiddleware) DeleteTask(ctx context.Context, a tasksvc.Auth, task
 	return mw.next.DeleteTask(ctx, a, taskID)
 }
 
+func (mw proxingMiddleware) Sync(ctx context.Context, a tasksvc.Auth, cursor uint64, changes []tasksvc.Change) (tasksvc.SyncResult, error) {
+	err := mw.validate(ctx, a)
+	if err != nil {
+		return tasksvc.SyncResult{}, err
+	}
+
+	return mw.next.Sync(ctx, a, cursor, changes)
+}
+
+// WatchTasks validates the request again every watchValidationInterval, as
+// the stream outlives the first validation, and ends the stream once it
+// fails. The session is checked then even if the identity was signed by the
+// gateway, which only validated it when the stream was opened.
+func (mw proxingMiddleware) WatchTasks(ctx context.Context, a tasksvc.Auth, lastEventID uint64, send func(tasksvc.Event) error) error {
+	err := mw.validate(ctx, a)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- mw.next.WatchTasks(ctx, a, lastEventID, send)
+	}()
+
+	revalidated := a
+	revalidated.SessionValidated = false
+
+	ticker := time.NewTicker(watchValidationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-errc:
+			return err
+		case <-ticker.C:
+			if err := mw.validate(ctx, revalidated); err != nil {
+				cancel()
+				<-errc
+				return err
+			}
+		}
+	}
+}
+
+// validate checks that the session of the access token was not signed out
+// and that the user still exists. Personal access tokens have no session,
+// they were already checked by authsvc when they were resolved, like the
+// sessions of the identities signed by the gateway. Either check is skipped
+// while its result is cached.
 func (mw proxingMiddleware) validate(ctx context.Context, a tasksvc.Auth) error {
-	{
+	if !a.PersonalAccessToken && !a.SessionValidated && (mw.cache == nil || !mw.cache.ValidSession(a.AccessUUID)) {
 		response, err := mw.validateUUID(ctx, authendpoint.ValidateRequest{AccessUUID: a.AccessUUID})
 		if err != nil {
 			return err
@@ -219,8 +337,11 @@ func (mw proxingMiddleware) validate(ctx context.Context, a tasksvc.Auth) error
 		if resp.Err != nil {
 			return resp.Err
 		}
+		if mw.cache != nil && resp.V {
+			mw.cache.AddSession(a.AccessUUID)
+		}
 	}
-	{
+	if mw.cache == nil || !mw.cache.UserExists(a.UserID) {
 		response, err := mw.isUserExists(ctx, userendpoint.IsExistsRequest{ID: a.UserID})
 		if err != nil {
 			return err
@@ -230,6 +351,9 @@ func (mw proxingMiddleware) validate(ctx context.Context, a tasksvc.Auth) error
 		if resp.Err != nil {
 			return resp.Err
 		}
+		if mw.cache != nil && resp.V {
+			mw.cache.AddUser(a.UserID)
+		}
 	}
 	return nil
 }
@@ -13,6 +13,15 @@ type Service interface {
 	Task(ctx context.Context, a tasksvc.Auth, taskID uint64) (tasksvc.Task, error)
 	UpdateTask(ctx context.Context, a tasksvc.Auth, task tasksvc.Task) (tasksvc.Task, error)
 	DeleteTask(ctx context.Context, a tasksvc.Auth, taskID uint64) (bool, error)
+	// WatchTasks sends the changes of the tasks of the user made after the
+	// event lastEventID, or from now on if it is zero, until ctx is done or
+	// send fails. The first event sent is either tasksvc.EventReady or
+	// tasksvc.EventReset.
+	WatchTasks(ctx context.Context, a tasksvc.Auth, lastEventID uint64, send func(tasksvc.Event) error) error
+	// Sync applies the changes the client made offline, in order, and
+	// returns the changes made on the server since cursor, the ID of the
+	// last event the client saw, including those of the sync.
+	Sync(ctx context.Context, a tasksvc.Auth, cursor uint64, changes []tasksvc.Change) (tasksvc.SyncResult, error)
 }
 
 func New(t tasksvc.TaskRepository, logger log.Logger) Service {
@@ -25,11 +34,12 @@ func New(t tasksvc.TaskRepository, logger log.Logger) Service {
 }
 
 type basicService struct {
-	tasks tasksvc.TaskRepository
+	tasks  tasksvc.TaskRepository
+	events *eventPoller
 }
 
 func NewBasicService(t tasksvc.TaskRepository) Service {
-	return basicService{tasks: t}
+	return basicService{tasks: t, events: newEventPoller(t, eventPollInterval)}
 }
 
 func (s basicService) CreateTask(_ context.Context, a tasksvc.Auth, title, description string) (tasksvc.Task, error) {
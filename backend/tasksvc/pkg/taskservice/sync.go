@@ -0,0 +1,243 @@
+package taskservice
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/tasksvc"
+)
+
+// maxSyncChanges is the number of changes a sync may carry, the clients
+// send the others with the next one.
+const maxSyncChanges = 1000
+
+func (s basicService) Sync(_ context.Context, a tasksvc.Auth, cursor uint64, changes []tasksvc.Change) (tasksvc.SyncResult, error) {
+	if a.UserID == 0 || !validChanges(changes) {
+		return tasksvc.SyncResult{}, tasksvc.ErrInvalidArgument
+	}
+
+	first, last, err := s.tasks.EventRange()
+	if err != nil {
+		return tasksvc.SyncResult{}, err
+	}
+
+	// As with WatchTasks, the tasks are sent again if the events after the
+	// cursor were deleted, or the cursor is not one of ours. Every field
+	// changed on the server is then merged as a concurrent change.
+	result := tasksvc.SyncResult{Cursor: cursor, Created: make(map[string]uint64)}
+	switch {
+	case cursor > last:
+		cursor = 0
+		fallthrough
+	case cursor == 0 || cursor+1 < first:
+		result.Full = true
+		result.Cursor = last
+	}
+
+	now := time.Now().UTC()
+	for _, c := range changes {
+		if c.ChangedAt.After(now) {
+			c.ChangedAt = now
+		}
+		task, conflicts, err := s.syncChange(a.UserID, c, cursor)
+		if err != nil {
+			return tasksvc.SyncResult{}, err
+		}
+
+		if c.ClientID != "" && task.ID != 0 {
+			result.Created[c.ClientID] = task.ID
+		}
+		result.Conflicts = append(result.Conflicts, conflicts...)
+	}
+
+	// The latest event of every task holds its state. As the events are
+	// numbered in the order they are committed, the cursor passes none
+	// which is committed later.
+	latest := make(map[uint64]tasksvc.Event)
+	for {
+		events, err := s.tasks.Events(a.UserID, result.Cursor, eventBatch)
+		if err != nil {
+			return tasksvc.SyncResult{}, err
+		}
+
+		for _, e := range events {
+			latest[e.Task.ID] = e
+			result.Cursor = e.ID
+		}
+		if len(events) < eventBatch {
+			break
+		}
+	}
+
+	if result.Full {
+		tasks, err := s.tasks.FindAll(a.UserID)
+		if err != nil {
+			return tasksvc.SyncResult{}, err
+		}
+		result.Tasks = tasks
+	} else {
+		for _, e := range latest {
+			if e.Type == tasksvc.EventDeleted {
+				result.Deleted = append(result.Deleted, e.Task.ID)
+			} else {
+				result.Tasks = append(result.Tasks, e.Task)
+			}
+		}
+	}
+	sort.Slice(result.Tasks, func(i, j int) bool { return result.Tasks[i].ID < result.Tasks[j].ID })
+	sort.Slice(result.Deleted, func(i, j int) bool { return result.Deleted[i] < result.Deleted[j] })
+
+	return result, nil
+}
+
+// syncChange merges the change into its task. A task created offline is
+// looked up by its client ID first, as it was created by the sync whose
+// reply was lost, or by a concurrent sync, in which case the change is
+// merged into it.
+func (s basicService) syncChange(userID uint64, c tasksvc.Change, cursor uint64) (tasksvc.Task, []tasksvc.Conflict, error) {
+	for retried := false; ; retried = true {
+		if c.TaskID == 0 {
+			id, err := s.tasks.CreatedTask(userID, c.ClientID)
+			if err != nil {
+				return tasksvc.Task{}, nil, err
+			}
+			c.TaskID = id
+		}
+
+		var conflicts []tasksvc.Conflict
+		task, _, err := s.tasks.SyncTask(userID, c.TaskID, func(task tasksvc.Task, clocks map[string]tasksvc.FieldClock, found bool) tasksvc.Merge {
+			var m tasksvc.Merge
+			m, conflicts = mergeChange(task, clocks, found, c, cursor)
+			return m
+		})
+		if err == tasksvc.ErrClientIDTaken && !retried {
+			continue
+		}
+		return task, conflicts, err
+	}
+}
+
+// mergeChange merges a change of the client, which saw the tasks up to the
+// event cursor. A field the client changed is a conflict if it was changed
+// on the server after the cursor as well: the latest change wins, the
+// server winning ties. Otherwise the client saw the value it replaced, and
+// its change is applied. A task deleted on the server stays deleted, and a
+// deletion loses to the later changes of the server. The merge only depends
+// on its arguments, so that a sync is always merged the same way.
+func mergeChange(task tasksvc.Task, clocks map[string]tasksvc.FieldClock, found bool, c tasksvc.Change, cursor uint64) (tasksvc.Merge, []tasksvc.Conflict) {
+	if c.TaskID == 0 {
+		return tasksvc.Merge{
+			Task:      tasksvc.Task{Title: c.Title, Description: c.Description, Done: c.Done},
+			Fields:    tasksvc.Fields,
+			ChangedAt: c.ChangedAt,
+			Create:    true,
+			ClientID:  c.ClientID,
+		}, nil
+	}
+
+	if !found {
+		if c.Deleted {
+			return tasksvc.Merge{}, nil
+		}
+		return tasksvc.Merge{}, []tasksvc.Conflict{newConflict(c, tasksvc.FieldDeleted, false)}
+	}
+
+	if c.Deleted {
+		concurrent := false
+		for _, f := range tasksvc.Fields {
+			clock, ok := clocks[f]
+			if !ok || clock.EventID <= cursor {
+				continue
+			}
+			if !c.ChangedAt.After(clock.ChangedAt) {
+				return tasksvc.Merge{}, []tasksvc.Conflict{newConflict(c, tasksvc.FieldDeleted, false)}
+			}
+			concurrent = true
+		}
+
+		m := tasksvc.Merge{Delete: true, ChangedAt: c.ChangedAt}
+		if concurrent {
+			return m, []tasksvc.Conflict{newConflict(c, tasksvc.FieldDeleted, true)}
+		}
+		return m, nil
+	}
+
+	m := tasksvc.Merge{Task: task, ChangedAt: c.ChangedAt}
+	var conflicts []tasksvc.Conflict
+	for _, f := range tasksvc.Fields {
+		if !hasField(c.Fields, f) || sameValue(task, c, f) {
+			continue
+		}
+
+		applied := true
+		if clock, ok := clocks[f]; ok && clock.EventID > cursor {
+			applied = c.ChangedAt.After(clock.ChangedAt)
+			conflicts = append(conflicts, newConflict(c, f, applied))
+		}
+		if applied {
+			setField(&m.Task, c, f)
+			m.Fields = append(m.Fields, f)
+		}
+	}
+	return m, conflicts
+}
+
+func newConflict(c tasksvc.Change, field string, applied bool) tasksvc.Conflict {
+	return tasksvc.Conflict{TaskID: c.TaskID, ClientID: c.ClientID, Field: field, Applied: applied}
+}
+
+// validChanges checks that the created tasks have a client ID and a title,
+// and that only the synced fields are changed.
+func validChanges(changes []tasksvc.Change) bool {
+	if len(changes) > maxSyncChanges {
+		return false
+	}
+
+	for _, c := range changes {
+		if c.ChangedAt.IsZero() {
+			return false
+		}
+		if c.TaskID == 0 && (c.ClientID == "" || c.Title == "" || c.Deleted) {
+			return false
+		}
+		for _, f := range c.Fields {
+			if !hasField(tasksvc.Fields, f) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func hasField(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func sameValue(task tasksvc.Task, c tasksvc.Change, field string) bool {
+	switch field {
+	case tasksvc.FieldTitle:
+		return task.Title == c.Title
+	case tasksvc.FieldDescription:
+		return task.Description == c.Description
+	case tasksvc.FieldDone:
+		return task.Done == c.Done
+	}
+	return true
+}
+
+func setField(task *tasksvc.Task, c tasksvc.Change, field string) {
+	switch field {
+	case tasksvc.FieldTitle:
+		task.Title = c.Title
+	case tasksvc.FieldDescription:
+		task.Description = c.Description
+	case tasksvc.FieldDone:
+		task.Done = c.Done
+	}
+}
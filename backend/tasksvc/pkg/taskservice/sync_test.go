@@ -0,0 +1,246 @@
+package taskservice
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/tasksvc"
+	"github.com/ichigozero/gtdkit/backend/tasksvc/db/gorm"
+	"gorm.io/driver/sqlite"
+	libgorm "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestMergeChange(t *testing.T) {
+	t.Parallel()
+
+	const cursor = 10
+	var (
+		t0     = time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+		server = tasksvc.Task{ID: 1, Title: "server", Description: "description", UserID: 1}
+		// clock returns the clock of a change of the title, which the
+		// client saw if the event is not after the cursor.
+		clock = func(eventID uint64, changedAt time.Time) map[string]tasksvc.FieldClock {
+			return map[string]tasksvc.FieldClock{
+				tasksvc.FieldTitle: {TaskID: 1, Field: tasksvc.FieldTitle, ChangedAt: changedAt, EventID: eventID},
+			}
+		}
+		rename = func(title string, changedAt time.Time) tasksvc.Change {
+			return tasksvc.Change{TaskID: 1, Fields: []string{tasksvc.FieldTitle}, Title: title, Description: "description", ChangedAt: changedAt}
+		}
+		remove = func(changedAt time.Time) tasksvc.Change {
+			return tasksvc.Change{TaskID: 1, Deleted: true, ChangedAt: changedAt}
+		}
+		renamed = func(title string) tasksvc.Task {
+			task := server
+			task.Title = title
+			return task
+		}
+	)
+
+	for _, tc := range []struct {
+		name      string
+		clocks    map[string]tasksvc.FieldClock
+		found     bool
+		change    tasksvc.Change
+		merge     tasksvc.Merge
+		conflicts []tasksvc.Conflict
+	}{
+		{
+			name:   "field changed before the cursor",
+			clocks: clock(cursor, t0.Add(time.Hour)),
+			found:  true,
+			change: rename("client", t0),
+			merge:  tasksvc.Merge{Task: renamed("client"), Fields: []string{tasksvc.FieldTitle}, ChangedAt: t0},
+		},
+		{
+			name:      "client changed the field last",
+			clocks:    clock(cursor+1, t0),
+			found:     true,
+			change:    rename("client", t0.Add(time.Second)),
+			merge:     tasksvc.Merge{Task: renamed("client"), Fields: []string{tasksvc.FieldTitle}, ChangedAt: t0.Add(time.Second)},
+			conflicts: []tasksvc.Conflict{{TaskID: 1, Field: tasksvc.FieldTitle, Applied: true}},
+		},
+		{
+			name:      "server changed the field last",
+			clocks:    clock(cursor+1, t0.Add(time.Second)),
+			found:     true,
+			change:    rename("client", t0),
+			merge:     tasksvc.Merge{Task: server, ChangedAt: t0},
+			conflicts: []tasksvc.Conflict{{TaskID: 1, Field: tasksvc.FieldTitle, Applied: false}},
+		},
+		{
+			name:      "tie goes to the server",
+			clocks:    clock(cursor+1, t0),
+			found:     true,
+			change:    rename("client", t0),
+			merge:     tasksvc.Merge{Task: server, ChangedAt: t0},
+			conflicts: []tasksvc.Conflict{{TaskID: 1, Field: tasksvc.FieldTitle, Applied: false}},
+		},
+		{
+			name:   "same value is no conflict",
+			clocks: clock(cursor+1, t0.Add(time.Second)),
+			found:  true,
+			change: rename("server", t0),
+			merge:  tasksvc.Merge{Task: server, ChangedAt: t0},
+		},
+		{
+			name:   "unlisted field is left",
+			found:  true,
+			change: tasksvc.Change{TaskID: 1, Title: "client", Done: true, ChangedAt: t0},
+			merge:  tasksvc.Merge{Task: server, ChangedAt: t0},
+		},
+		{
+			name:   "delete",
+			clocks: clock(cursor, t0.Add(time.Hour)),
+			found:  true,
+			change: remove(t0),
+			merge:  tasksvc.Merge{Delete: true, ChangedAt: t0},
+		},
+		{
+			name:      "delete after a change of the server",
+			clocks:    clock(cursor+1, t0),
+			found:     true,
+			change:    remove(t0.Add(time.Second)),
+			merge:     tasksvc.Merge{Delete: true, ChangedAt: t0.Add(time.Second)},
+			conflicts: []tasksvc.Conflict{{TaskID: 1, Field: tasksvc.FieldDeleted, Applied: true}},
+		},
+		{
+			name:      "delete before a change of the server",
+			clocks:    clock(cursor+1, t0.Add(time.Second)),
+			found:     true,
+			change:    remove(t0),
+			conflicts: []tasksvc.Conflict{{TaskID: 1, Field: tasksvc.FieldDeleted, Applied: false}},
+		},
+		{
+			name:      "delete tied with a change of the server",
+			clocks:    clock(cursor+1, t0),
+			found:     true,
+			change:    remove(t0),
+			conflicts: []tasksvc.Conflict{{TaskID: 1, Field: tasksvc.FieldDeleted, Applied: false}},
+		},
+		{
+			name:      "change of a task deleted on the server",
+			change:    rename("client", t0),
+			conflicts: []tasksvc.Conflict{{TaskID: 1, Field: tasksvc.FieldDeleted, Applied: false}},
+		},
+		{
+			name:   "delete of a task deleted on the server",
+			change: remove(t0),
+		},
+		{
+			name:   "create",
+			change: tasksvc.Change{ClientID: "c1", Title: "client", Done: true, ChangedAt: t0},
+			merge: tasksvc.Merge{
+				Task:      tasksvc.Task{Title: "client", Done: true},
+				Fields:    tasksvc.Fields,
+				ChangedAt: t0,
+				Create:    true,
+				ClientID:  "c1",
+			},
+		},
+	} {
+		task := server
+		if !tc.found {
+			task = tasksvc.Task{}
+		}
+		merge, conflicts := mergeChange(task, tc.clocks, tc.found, tc.change, cursor)
+		if !reflect.DeepEqual(merge, tc.merge) {
+			t.Errorf("%s: want merge %+v, got %+v", tc.name, tc.merge, merge)
+		}
+		if !reflect.DeepEqual(conflicts, tc.conflicts) {
+			t.Errorf("%s: want conflicts %+v, got %+v", tc.name, tc.conflicts, conflicts)
+		}
+	}
+}
+
+func newTestRepository(t *testing.T) tasksvc.TaskRepository {
+	db, err := libgorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gorm.db")), &libgorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&tasksvc.Task{}, &tasksvc.Event{}, &tasksvc.FieldClock{}, &tasksvc.ClientTask{}, &tasksvc.EventSequence{}); err != nil {
+		t.Fatal(err)
+	}
+	return gorm.NewTaskRepository(db)
+}
+
+// TestSyncInterleavedClients replays the edits two clients made offline to
+// the same task, from the same cursor, and a task created by one of them
+// whose reply was lost.
+func TestSyncInterleavedClients(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestRepository(t)
+	svc := NewBasicService(repo)
+	ctx := context.Background()
+	a := tasksvc.Auth{UserID: 1}
+
+	task, err := repo.Create("title", "description", a.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, cursor, err := repo.EventRange()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t0 := time.Now().UTC().Add(-time.Hour)
+	first := []tasksvc.Change{
+		{TaskID: task.ID, Fields: []string{tasksvc.FieldTitle, tasksvc.FieldDone}, Title: "first", Description: "description", Done: true, ChangedAt: t0.Add(2 * time.Second)},
+		{ClientID: "first-1", Title: "created offline", ChangedAt: t0},
+	}
+	second := []tasksvc.Change{
+		{TaskID: task.ID, Fields: []string{tasksvc.FieldTitle}, Title: "second, earlier", Description: "description", ChangedAt: t0.Add(time.Second)},
+		{TaskID: task.ID, Fields: []string{tasksvc.FieldDescription}, Title: "second, earlier", Description: "second", ChangedAt: t0.Add(3 * time.Second)},
+		{TaskID: task.ID, Fields: []string{tasksvc.FieldTitle}, Title: "second, later", Description: "second", ChangedAt: t0.Add(4 * time.Second)},
+	}
+
+	r1, err := svc.Sync(ctx, a, cursor, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r1.Conflicts) != 0 {
+		t.Errorf("want no conflict for the first client, got %+v", r1.Conflicts)
+	}
+	created := r1.Created["first-1"]
+	if created == 0 {
+		t.Fatalf("want the offline task created, got %+v", r1.Created)
+	}
+
+	r2, err := svc.Sync(ctx, a, cursor, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []tasksvc.Conflict{
+		{TaskID: task.ID, Field: tasksvc.FieldTitle, Applied: false},
+		{TaskID: task.ID, Field: tasksvc.FieldTitle, Applied: true},
+	}
+	if !reflect.DeepEqual(r2.Conflicts, want) {
+		t.Errorf("want conflicts %+v for the second client, got %+v", want, r2.Conflicts)
+	}
+
+	// The first client syncs again, as the reply was lost.
+	r3, err := svc.Sync(ctx, a, cursor, first[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r3.Created["first-1"] != created {
+		t.Errorf("want the offline task %d mapped again, got %+v", created, r3.Created)
+	}
+
+	tasks, err := repo.FindAll(a.UserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTasks := []tasksvc.Task{
+		{ID: task.ID, Title: "second, later", Description: "second", Done: true, UserID: a.UserID},
+		{ID: created, Title: "created offline", UserID: a.UserID},
+	}
+	if !reflect.DeepEqual(tasks, wantTasks) {
+		t.Errorf("want tasks %+v, got %+v", wantTasks, tasks)
+	}
+}
@@ -0,0 +1,203 @@
+package taskservice
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/tasksvc"
+)
+
+const (
+	// eventPollInterval is how often the users with new events are listed
+	// while watching, as the changes made through the other instances are
+	// only found in the database.
+	eventPollInterval = time.Second
+	// eventBatch is the number of events read at once.
+	eventBatch = 100
+	// eventPruneInterval is how often the expired events are deleted.
+	eventPruneInterval = time.Hour
+)
+
+func (s basicService) WatchTasks(ctx context.Context, a tasksvc.Auth, lastEventID uint64, send func(tasksvc.Event) error) error {
+	if a.UserID == 0 {
+		return tasksvc.ErrInvalidArgument
+	}
+
+	// Subscribing first, so that the events committed after the first
+	// listing below wake the watcher.
+	wake, unsubscribe, err := s.events.subscribe(a.UserID)
+	if err != nil {
+		return err
+	}
+	defer unsubscribe()
+
+	first, last, err := s.tasks.EventRange()
+	if err != nil {
+		return err
+	}
+
+	// The events after lastEventID were deleted if it precedes the oldest
+	// event kept, or the ID is not one of ours if it is ahead of the
+	// latest.
+	start := tasksvc.Event{ID: lastEventID, Type: tasksvc.EventReady, CreatedAt: time.Now().UTC()}
+	switch {
+	case lastEventID == 0:
+		start.ID = last
+	case lastEventID > last || lastEventID+1 < first:
+		start.ID = last
+		start.Type = tasksvc.EventReset
+	}
+	if err := send(start); err != nil {
+		return err
+	}
+
+	cursor := start.ID
+	for {
+		events, err := s.tasks.Events(a.UserID, cursor, eventBatch)
+		if err != nil {
+			return err
+		}
+
+		// The events are numbered in the order they are committed, so
+		// that none is committed behind the cursor.
+		for _, e := range events {
+			if err := send(e); err != nil {
+				return err
+			}
+			cursor = e.ID
+		}
+		if len(events) == eventBatch {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// eventPoller lists the users with new events on behalf of every watcher of
+// the instance, so that the database is queried once per interval however
+// many streams are open, and wakes the watchers of those users. It only
+// runs while there are watchers.
+type eventPoller struct {
+	tasks    tasksvc.TaskRepository
+	interval time.Duration
+
+	mtx      sync.Mutex
+	running  bool
+	cursor   uint64
+	watchers map[uint64]map[chan struct{}]struct{}
+}
+
+func newEventPoller(tasks tasksvc.TaskRepository, interval time.Duration) *eventPoller {
+	return &eventPoller{
+		tasks:    tasks,
+		interval: interval,
+		watchers: make(map[uint64]map[chan struct{}]struct{}),
+	}
+}
+
+// subscribe returns a channel which receives a value when events of the user
+// are committed after the call, and the function which ends the
+// subscription. The values are coalesced, the watcher lists its own events.
+func (p *eventPoller) subscribe(userID uint64) (<-chan struct{}, func(), error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if !p.running {
+		_, last, err := p.tasks.EventRange()
+		if err != nil {
+			return nil, nil, err
+		}
+		p.cursor = last
+		p.running = true
+		go p.run()
+	}
+
+	c := make(chan struct{}, 1)
+	if p.watchers[userID] == nil {
+		p.watchers[userID] = make(map[chan struct{}]struct{})
+	}
+	p.watchers[userID][c] = struct{}{}
+
+	unsubscribe := func() {
+		p.mtx.Lock()
+		defer p.mtx.Unlock()
+
+		delete(p.watchers[userID], c)
+		if len(p.watchers[userID]) == 0 {
+			delete(p.watchers, userID)
+		}
+	}
+	return c, unsubscribe, nil
+}
+
+func (p *eventPoller) run() {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.mtx.Lock()
+		if len(p.watchers) == 0 {
+			p.running = false
+			p.mtx.Unlock()
+			return
+		}
+		cursor := p.cursor
+		p.mtx.Unlock()
+
+		userIDs, last, err := p.tasks.EventUsers(cursor)
+
+		p.mtx.Lock()
+		if err != nil {
+			// Every watcher lists its events, and ends its stream with
+			// the error if the database is still failing.
+			for _, cs := range p.watchers {
+				for c := range cs {
+					notify(c)
+				}
+			}
+		} else {
+			p.cursor = last
+			for _, userID := range userIDs {
+				for c := range p.watchers[userID] {
+					notify(c)
+				}
+			}
+		}
+		p.mtx.Unlock()
+	}
+}
+
+// notify wakes the watcher unless it is already due to wake.
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// PruneEvents deletes the events older than retention every hour until ctx
+// is done. The watchers which resume from a deleted event are sent
+// tasksvc.EventReset.
+func PruneEvents(ctx context.Context, tasks tasksvc.TaskRepository, retention time.Duration, logger log.Logger) error {
+	ticker := time.NewTicker(eventPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := tasks.DeleteEvents(time.Now().Add(-retention)); err != nil {
+			logger.Log("during", "DeleteEvents", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
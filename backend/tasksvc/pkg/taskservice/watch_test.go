@@ -0,0 +1,72 @@
+package taskservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/tasksvc"
+)
+
+// TestWatchersShareOnePoller watches the tasks of two users through one
+// instance and checks that each stream only wakes for the events of its
+// user, and that the poller stops with the last stream.
+func TestWatchersShareOnePoller(t *testing.T) {
+	t.Parallel()
+
+	repo := newTestRepository(t)
+	events := newEventPoller(repo, 10*time.Millisecond)
+	svc := basicService{tasks: repo, events: events}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	streams := map[uint64]chan tasksvc.Event{1: make(chan tasksvc.Event, 10), 2: make(chan tasksvc.Event, 10)}
+	done := make(chan error, len(streams))
+	for userID, c := range streams {
+		userID, c := userID, c
+		go func() {
+			done <- svc.WatchTasks(ctx, tasksvc.Auth{UserID: userID}, 0, func(e tasksvc.Event) error {
+				c <- e
+				return nil
+			})
+		}()
+		if e := <-c; e.Type != tasksvc.EventReady {
+			t.Fatalf("user %d: want %s first, got %s", userID, tasksvc.EventReady, e.Type)
+		}
+	}
+
+	task, err := repo.Create("title", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-streams[1]:
+		if e.Type != tasksvc.EventCreated || e.Task.ID != task.ID {
+			t.Errorf("want the task created, got %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want the watcher of the user woken")
+	}
+	select {
+	case e := <-streams[2]:
+		t.Errorf("want no event for another user, got %+v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	for range streams {
+		if err := <-done; err != context.Canceled {
+			t.Errorf("want %v, got %v", context.Canceled, err)
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		events.mtx.Lock()
+		running := events.running
+		events.mtx.Unlock()
+		if !running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("want the poller stopped without watchers")
+		}
+	}
+}
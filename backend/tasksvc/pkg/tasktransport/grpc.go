@@ -3,10 +3,10 @@ package tasktransport
 import (
 	"context"
 	"errors"
-	"os"
+	"io"
+	"sort"
 	"time"
 
-	stdjwt "github.com/dgrijalva/jwt-go"
 	kitjwt "github.com/go-kit/kit/auth/jwt"
 	"github.com/go-kit/kit/circuitbreaker"
 	"github.com/go-kit/kit/endpoint"
@@ -14,6 +14,10 @@ import (
 	"github.com/go-kit/kit/ratelimit"
 	"github.com/go-kit/kit/transport"
 	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/authz"
+	"github.com/ichigozero/gtdkit/backend/authsvc/identity"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
 	"github.com/ichigozero/gtdkit/backend/tasksvc"
 	"github.com/ichigozero/gtdkit/backend/tasksvc/pb"
 	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/taskendpoint"
@@ -21,6 +25,9 @@ import (
 	"github.com/sony/gobreaker"
 	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 type grpcServer struct {
@@ -29,66 +36,84 @@ type grpcServer struct {
 	task       grpctransport.Handler
 	updateTask grpctransport.Handler
 	deleteTask grpctransport.Handler
+	watchTasks grpctransport.Handler
+	sync       grpctransport.Handler
 	pb.UnimplementedTaskSVCServer
 }
 
-func NewGRPCServer(endpoints taskendpoint.Set, logger log.Logger) pb.TaskSVCServer {
+func NewGRPCServer(endpoints taskendpoint.Set, keys *jwks.Cache, resolve pat.Resolver, identityKey []byte, logger log.Logger) pb.TaskSVCServer {
 	options := []grpctransport.ServerOption{
 		grpctransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
 	}
 
-	kf := func(token *stdjwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("ACCESS_SECRET")), nil
-	}
-
 	var createTaskEndpoint endpoint.Endpoint
 	{
 		createTaskEndpoint = endpoints.CreateTaskEndpoint
-		createTaskEndpoint = kitjwt.NewParser(
-			kf,
-			stdjwt.SigningMethodHS256,
+		createTaskEndpoint = identity.NewParser(identityKey, pat.NewParser(resolve, kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
 			kitjwt.MapClaimsFactory,
-		)(createTaskEndpoint)
+		)))(createTaskEndpoint)
 	}
 
 	var tasksEndpoint endpoint.Endpoint
 	{
 		tasksEndpoint = endpoints.TasksEndpoint
-		tasksEndpoint = kitjwt.NewParser(
-			kf,
-			stdjwt.SigningMethodHS256,
+		tasksEndpoint = identity.NewParser(identityKey, pat.NewParser(resolve, kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
 			kitjwt.MapClaimsFactory,
-		)(tasksEndpoint)
+		)))(tasksEndpoint)
 	}
 
 	var taskEndpoint endpoint.Endpoint
 	{
 		taskEndpoint = endpoints.TaskEndpoint
-		taskEndpoint = kitjwt.NewParser(
-			kf,
-			stdjwt.SigningMethodHS256,
+		taskEndpoint = identity.NewParser(identityKey, pat.NewParser(resolve, kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
 			kitjwt.MapClaimsFactory,
-		)(taskEndpoint)
+		)))(taskEndpoint)
 	}
 
 	var updateTaskEndpoint endpoint.Endpoint
 	{
 		updateTaskEndpoint = endpoints.UpdateTaskEndpoint
-		updateTaskEndpoint = kitjwt.NewParser(
-			kf,
-			stdjwt.SigningMethodHS256,
+		updateTaskEndpoint = identity.NewParser(identityKey, pat.NewParser(resolve, kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
 			kitjwt.MapClaimsFactory,
-		)(updateTaskEndpoint)
+		)))(updateTaskEndpoint)
 	}
 
 	var deleteTaskEndpoint endpoint.Endpoint
 	{
 		deleteTaskEndpoint = endpoints.DeleteTaskEndpoint
-		deleteTaskEndpoint = kitjwt.NewParser(
-			kf,
-			stdjwt.SigningMethodHS256,
+		deleteTaskEndpoint = identity.NewParser(identityKey, pat.NewParser(resolve, kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)))(deleteTaskEndpoint)
+	}
+
+	var watchTasksEndpoint endpoint.Endpoint
+	{
+		watchTasksEndpoint = endpoints.WatchTasksEndpoint
+		watchTasksEndpoint = identity.NewParser(identityKey, pat.NewParser(resolve, kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)))(watchTasksEndpoint)
+	}
+
+	var syncEndpoint endpoint.Endpoint
+	{
+		syncEndpoint = endpoints.SyncEndpoint
+		syncEndpoint = identity.NewParser(identityKey, pat.NewParser(resolve, kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
 			kitjwt.MapClaimsFactory,
-		)(deleteTaskEndpoint)
+		)))(syncEndpoint)
 	}
 
 	return &grpcServer{
@@ -96,31 +121,43 @@ func NewGRPCServer(endpoints taskendpoint.Set, logger log.Logger) pb.TaskSVCServ
 			createTaskEndpoint,
 			decodeGRPCCreateTaskRequest,
 			encodeGRPCCreateTaskResponse,
-			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext()))...,
+			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext(), identity.GRPCToContext()))...,
 		),
 		tasks: grpctransport.NewServer(
 			tasksEndpoint,
 			decodeGRPCTasksRequest,
 			encodeGRPCTasksResponse,
-			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext()))...,
+			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext(), identity.GRPCToContext()))...,
 		),
 		task: grpctransport.NewServer(
 			taskEndpoint,
 			decodeGRPCTaskRequest,
 			encodeGRPCTaskResponse,
-			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext()))...,
+			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext(), identity.GRPCToContext()))...,
 		),
 		updateTask: grpctransport.NewServer(
 			updateTaskEndpoint,
 			decodeGRPCUpdateTaskRequest,
 			encodeGRPCUpdateTaskResponse,
-			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext()))...,
+			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext(), identity.GRPCToContext()))...,
 		),
 		deleteTask: grpctransport.NewServer(
 			deleteTaskEndpoint,
 			decodeGRPCDeleteTaskRequest,
 			encodeGRPCDeleteTaskResponse,
-			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext()))...,
+			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext(), identity.GRPCToContext()))...,
+		),
+		watchTasks: grpctransport.NewServer(
+			watchTasksEndpoint,
+			decodeGRPCWatchTasksRequest,
+			encodeGRPCWatchTasksResponse,
+			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext(), identity.GRPCToContext()))...,
+		),
+		sync: grpctransport.NewServer(
+			syncEndpoint,
+			decodeGRPCSyncRequest,
+			encodeGRPCSyncResponse,
+			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext(), identity.GRPCToContext()))...,
 		),
 	}
 }
@@ -128,7 +165,7 @@ func NewGRPCServer(endpoints taskendpoint.Set, logger log.Logger) pb.TaskSVCServ
 func (s *grpcServer) CreateTask(ctx context.Context, req *pb.CreateTaskRequest) (*pb.CreateTaskReply, error) {
 	_, rep, err := s.createTask.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, grpcError(err)
 	}
 	return rep.(*pb.CreateTaskReply), nil
 }
@@ -136,7 +173,7 @@ func (s *grpcServer) CreateTask(ctx context.Context, req *pb.CreateTaskRequest)
 func (s *grpcServer) Tasks(ctx context.Context, req *pb.TasksRequest) (*pb.TasksReply, error) {
 	_, rep, err := s.tasks.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, grpcError(err)
 	}
 	return rep.(*pb.TasksReply), nil
 }
@@ -144,7 +181,7 @@ func (s *grpcServer) Tasks(ctx context.Context, req *pb.TasksRequest) (*pb.Tasks
 func (s *grpcServer) Task(ctx context.Context, req *pb.TaskRequest) (*pb.TaskReply, error) {
 	_, rep, err := s.task.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, grpcError(err)
 	}
 	return rep.(*pb.TaskReply), nil
 }
@@ -152,7 +189,7 @@ func (s *grpcServer) Task(ctx context.Context, req *pb.TaskRequest) (*pb.TaskRep
 func (s *grpcServer) UpdateTask(ctx context.Context, req *pb.UpdateTaskRequest) (*pb.UpdateTaskReply, error) {
 	_, rep, err := s.updateTask.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, grpcError(err)
 	}
 	return rep.(*pb.UpdateTaskReply), nil
 }
@@ -160,11 +197,39 @@ func (s *grpcServer) UpdateTask(ctx context.Context, req *pb.UpdateTaskRequest)
 func (s *grpcServer) DeleteTask(ctx context.Context, req *pb.DeleteTaskRequest) (*pb.DeleteTaskReply, error) {
 	_, rep, err := s.deleteTask.ServeGRPC(ctx, req)
 	if err != nil {
-		return nil, err
+		return nil, grpcError(err)
 	}
 	return rep.(*pb.DeleteTaskReply), nil
 }
 
+// WatchTasks has no reply, the stream ends with the error of the response
+// as its status instead.
+func (s *grpcServer) WatchTasks(req *pb.WatchTasksRequest, stream pb.TaskSVC_WatchTasksServer) error {
+	_, rep, err := s.watchTasks.ServeGRPC(stream.Context(), watchTasksCall{req: req, stream: stream})
+	if err != nil {
+		return grpcError(err)
+	}
+	if err := rep.(taskendpoint.WatchTasksResponse).Err; err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
+
+func (s *grpcServer) Sync(ctx context.Context, req *pb.SyncRequest) (*pb.SyncReply, error) {
+	_, rep, err := s.sync.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return rep.(*pb.SyncReply), nil
+}
+
+// watchTasksCall is the request decoded by the WatchTasks handler, the
+// events are sent on its stream.
+type watchTasksCall struct {
+	req    *pb.WatchTasksRequest
+	stream pb.TaskSVC_WatchTasksServer
+}
+
 func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) taskservice.Service {
 	limiter := ratelimit.NewErroringLimiter(rate.NewLimiter(rate.Every(time.Second), 100))
 
@@ -179,8 +244,11 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) taskservice.Service
 			encodeGRPCCreateTaskRequest,
 			decodeGRPCCreateTaskResponse,
 			pb.CreateTaskReply{},
-			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC()))...,
+			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC(), identity.ContextToGRPC()))...,
 		).Endpoint()
+		createTaskEndpoint = permissionDenied(func(err error) interface{} {
+			return taskendpoint.CreateTaskResponse{Err: err}
+		})(createTaskEndpoint)
 		createTaskEndpoint = limiter(createTaskEndpoint)
 		createTaskEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
 			Name:    "CreateTask",
@@ -197,8 +265,11 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) taskservice.Service
 			encodeGRPCTasksRequest,
 			decodeGRPCTasksResponse,
 			pb.TasksReply{},
-			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC()))...,
+			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC(), identity.ContextToGRPC()))...,
 		).Endpoint()
+		tasksEndpoint = permissionDenied(func(err error) interface{} {
+			return taskendpoint.TasksResponse{Err: err}
+		})(tasksEndpoint)
 		tasksEndpoint = limiter(tasksEndpoint)
 		tasksEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
 			Name:    "Tasks",
@@ -215,8 +286,11 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) taskservice.Service
 			encodeGRPCTaskRequest,
 			decodeGRPCTaskResponse,
 			pb.TaskReply{},
-			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC()))...,
+			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC(), identity.ContextToGRPC()))...,
 		).Endpoint()
+		taskEndpoint = permissionDenied(func(err error) interface{} {
+			return taskendpoint.TaskResponse{Err: err}
+		})(taskEndpoint)
 		taskEndpoint = limiter(taskEndpoint)
 		taskEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
 			Name:    "Task",
@@ -233,8 +307,11 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) taskservice.Service
 			encodeGRPCUpdateTaskRequest,
 			decodeGRPCUpdateTaskResponse,
 			pb.UpdateTaskReply{},
-			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC()))...,
+			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC(), identity.ContextToGRPC()))...,
 		).Endpoint()
+		updateTaskEndpoint = permissionDenied(func(err error) interface{} {
+			return taskendpoint.UpdateTaskResponse{Err: err}
+		})(updateTaskEndpoint)
 		updateTaskEndpoint = limiter(updateTaskEndpoint)
 		updateTaskEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
 			Name:    "UpdateTask",
@@ -251,8 +328,11 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) taskservice.Service
 			encodeGRPCDeleteTaskRequest,
 			decodeGRPCDeleteTaskResponse,
 			pb.DeleteTaskReply{},
-			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC()))...,
+			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC(), identity.ContextToGRPC()))...,
 		).Endpoint()
+		deleteTaskEndpoint = permissionDenied(func(err error) interface{} {
+			return taskendpoint.DeleteTaskResponse{Err: err}
+		})(deleteTaskEndpoint)
 		deleteTaskEndpoint = limiter(deleteTaskEndpoint)
 		deleteTaskEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
 			Name:    "DeleteTask",
@@ -260,12 +340,95 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) taskservice.Service
 		}))(deleteTaskEndpoint)
 	}
 
+	var watchTasksEndpoint endpoint.Endpoint
+	{
+		// Go kit has no streaming client, the stream is opened with the
+		// generated one and the same request functions.
+		watchTasksEndpoint = makeGRPCWatchTasksEndpoint(
+			pb.NewTaskSVCClient(conn),
+			kitjwt.ContextToGRPC(),
+			identity.ContextToGRPC(),
+		)
+		watchTasksEndpoint = permissionDenied(func(err error) interface{} {
+			return taskendpoint.WatchTasksResponse{Err: err}
+		})(watchTasksEndpoint)
+		watchTasksEndpoint = limiter(watchTasksEndpoint)
+		watchTasksEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "WatchTasks",
+			Timeout: 30 * time.Second,
+		}))(watchTasksEndpoint)
+	}
+
+	var syncEndpoint endpoint.Endpoint
+	{
+		syncEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.TaskSVC",
+			"Sync",
+			encodeGRPCSyncRequest,
+			decodeGRPCSyncResponse,
+			pb.SyncReply{},
+			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC(), identity.ContextToGRPC()))...,
+		).Endpoint()
+		syncEndpoint = permissionDenied(func(err error) interface{} {
+			return taskendpoint.SyncResponse{Err: err}
+		})(syncEndpoint)
+		syncEndpoint = limiter(syncEndpoint)
+		syncEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "Sync",
+			Timeout: 30 * time.Second,
+		}))(syncEndpoint)
+	}
+
 	return taskendpoint.Set{
 		CreateTaskEndpoint: createTaskEndpoint,
 		TasksEndpoint:      tasksEndpoint,
 		TaskEndpoint:       taskEndpoint,
 		UpdateTaskEndpoint: updateTaskEndpoint,
 		DeleteTaskEndpoint: deleteTaskEndpoint,
+		WatchTasksEndpoint: watchTasksEndpoint,
+		SyncEndpoint:       syncEndpoint,
+	}
+}
+
+// makeGRPCWatchTasksEndpoint returns an endpoint which passes the events of
+// the stream to the Send function of the request until it ends. The stream
+// failing is an error of the endpoint, like a failed call, while the errors
+// of the service, of Send and of the context end it with a response.
+func makeGRPCWatchTasksEndpoint(client pb.TaskSVCClient, before ...grpctransport.ClientRequestFunc) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(taskendpoint.WatchTasksRequest)
+
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		md := &metadata.MD{}
+		for _, f := range before {
+			ctx = f(ctx, md)
+		}
+		ctx = metadata.NewOutgoingContext(ctx, *md)
+
+		stream, err := client.WatchTasks(ctx, &pb.WatchTasksRequest{LastEventId: req.LastEventID})
+		if err != nil {
+			return nil, err
+		}
+		for {
+			e, err := stream.Recv()
+			switch {
+			case err == io.EOF:
+				return taskendpoint.WatchTasksResponse{}, nil
+			case ctx.Err() != nil:
+				return taskendpoint.WatchTasksResponse{Err: ctx.Err()}, nil
+			case status.Code(err) == codes.Unknown:
+				return taskendpoint.WatchTasksResponse{Err: str2err(status.Convert(err).Message())}, nil
+			case err != nil:
+				return nil, err
+			}
+
+			if err := req.Send(decodeGRPCTaskEvent(e)); err != nil {
+				return taskendpoint.WatchTasksResponse{Err: err}, nil
+			}
+		}
 	}
 }
 
@@ -406,7 +569,7 @@ func decodeGRPCTaskResponse(_ context.Context, grpcReply interface{}) (interface
 func decodeGRPCUpdateTaskRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
 	req := grpcReq.(*pb.UpdateTaskRequest)
 	return taskendpoint.UpdateTaskRequest{
-		TaskID:      req.Id,
+		TaskID:      req.TaskId,
 		Title:       req.Title,
 		Description: req.Description,
 		Done:        req.Done,
@@ -429,7 +592,7 @@ func encodeGRPCUpdateTaskResponse(_ context.Context, response interface{}) (inte
 func encodeGRPCUpdateTaskRequest(_ context.Context, request interface{}) (interface{}, error) {
 	req := request.(taskendpoint.UpdateTaskRequest)
 	return &pb.UpdateTaskRequest{
-		Id:          req.TaskID,
+		TaskId:      req.TaskID,
 		Title:       req.Title,
 		Description: req.Description,
 		Done:        req.Done,
@@ -479,6 +642,217 @@ func decodeGRPCDeleteTaskResponse(_ context.Context, grpcReply interface{}) (int
 	}, nil
 }
 
+func decodeGRPCWatchTasksRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	call := grpcReq.(watchTasksCall)
+	return taskendpoint.WatchTasksRequest{
+		LastEventID: call.req.LastEventId,
+		Send: func(e tasksvc.Event) error {
+			return call.stream.Send(encodeGRPCTaskEvent(e))
+		},
+	}, nil
+}
+
+func encodeGRPCWatchTasksResponse(_ context.Context, response interface{}) (interface{}, error) {
+	return response.(taskendpoint.WatchTasksResponse), nil
+}
+
+// encodeGRPCTaskEvent leaves the task out of the events which have none,
+// those starting the stream.
+func encodeGRPCTaskEvent(e tasksvc.Event) *pb.TaskEvent {
+	event := &pb.TaskEvent{
+		Id:        e.ID,
+		Type:      e.Type,
+		CreatedAt: e.CreatedAt.Unix(),
+	}
+	if e.Task.ID != 0 {
+		event.Task = &pb.Task{
+			Id:          e.Task.ID,
+			Title:       e.Task.Title,
+			Description: e.Task.Description,
+			Done:        e.Task.Done,
+			UserId:      e.Task.UserID,
+		}
+	}
+	return event
+}
+
+func decodeGRPCTaskEvent(e *pb.TaskEvent) tasksvc.Event {
+	return tasksvc.Event{
+		ID:   e.Id,
+		Type: e.Type,
+		Task: tasksvc.Task{
+			ID:          e.Task.GetId(),
+			Title:       e.Task.GetTitle(),
+			Description: e.Task.GetDescription(),
+			Done:        e.Task.GetDone(),
+			UserID:      e.Task.GetUserId(),
+		},
+		CreatedAt: time.Unix(e.CreatedAt, 0).UTC(),
+	}
+}
+
+func decodeGRPCSyncRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.SyncRequest)
+	changes := make([]tasksvc.Change, 0, len(req.Changes))
+	for _, c := range req.Changes {
+		changes = append(changes, tasksvc.Change{
+			TaskID:      c.TaskId,
+			ClientID:    c.ClientId,
+			Fields:      c.Fields,
+			Title:       c.Title,
+			Description: c.Description,
+			Done:        c.Done,
+			Deleted:     c.Deleted,
+			ChangedAt:   millis2time(c.ChangedAt),
+		})
+	}
+
+	return taskendpoint.SyncRequest{
+		Cursor:  req.Cursor,
+		Changes: changes,
+	}, nil
+}
+
+func encodeGRPCSyncResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(taskendpoint.SyncResponse)
+	r := resp.Result
+
+	var tasks []*pb.Task
+	for _, t := range r.Tasks {
+		tasks = append(tasks, &pb.Task{
+			Id:          t.ID,
+			Title:       t.Title,
+			Description: t.Description,
+			Done:        t.Done,
+			UserId:      t.UserID,
+		})
+	}
+	var created []*pb.CreatedTask
+	for clientID, id := range r.Created {
+		created = append(created, &pb.CreatedTask{ClientId: clientID, Id: id})
+	}
+	sort.Slice(created, func(i, j int) bool { return created[i].ClientId < created[j].ClientId })
+	var conflicts []*pb.SyncConflict
+	for _, c := range r.Conflicts {
+		conflicts = append(conflicts, &pb.SyncConflict{
+			TaskId:   c.TaskID,
+			ClientId: c.ClientID,
+			Field:    c.Field,
+			Applied:  c.Applied,
+		})
+	}
+
+	return &pb.SyncReply{
+		Cursor:    r.Cursor,
+		Full:      r.Full,
+		Tasks:     tasks,
+		Deleted:   r.Deleted,
+		Created:   created,
+		Conflicts: conflicts,
+		Err:       err2str(resp.Err),
+	}, nil
+}
+
+func encodeGRPCSyncRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(taskendpoint.SyncRequest)
+	changes := make([]*pb.TaskChange, 0, len(req.Changes))
+	for _, c := range req.Changes {
+		changes = append(changes, &pb.TaskChange{
+			TaskId:      c.TaskID,
+			ClientId:    c.ClientID,
+			Fields:      c.Fields,
+			Title:       c.Title,
+			Description: c.Description,
+			Done:        c.Done,
+			Deleted:     c.Deleted,
+			ChangedAt:   time2millis(c.ChangedAt),
+		})
+	}
+
+	return &pb.SyncRequest{
+		Cursor:  req.Cursor,
+		Changes: changes,
+	}, nil
+}
+
+func decodeGRPCSyncResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.SyncReply)
+	r := tasksvc.SyncResult{
+		Cursor:  reply.Cursor,
+		Full:    reply.Full,
+		Deleted: reply.Deleted,
+		Created: make(map[string]uint64, len(reply.Created)),
+	}
+	for _, t := range reply.Tasks {
+		r.Tasks = append(r.Tasks, tasksvc.Task{
+			ID:          t.Id,
+			Title:       t.Title,
+			Description: t.Description,
+			Done:        t.Done,
+			UserID:      t.UserId,
+		})
+	}
+	for _, c := range reply.Created {
+		r.Created[c.ClientId] = c.Id
+	}
+	for _, c := range reply.Conflicts {
+		r.Conflicts = append(r.Conflicts, tasksvc.Conflict{
+			TaskID:   c.TaskId,
+			ClientID: c.ClientId,
+			Field:    c.Field,
+			Applied:  c.Applied,
+		})
+	}
+
+	return taskendpoint.SyncResponse{
+		Result: r,
+		Err:    str2err(reply.Err),
+	}, nil
+}
+
+// millis2time keeps zero as the zero time, so that a change without its
+// time is refused.
+func millis2time(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
+
+func time2millis(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// grpcError turns the errors which are returned by the endpoint middlewares
+// rather than within the response into the matching gRPC status.
+func grpcError(err error) error {
+	if errors.Is(err, authz.ErrForbidden) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if errors.Is(err, identity.ErrInvalidIdentity) {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return err
+}
+
+// permissionDenied turns a PermissionDenied status back into a response
+// carrying authz.ErrForbidden, so that it is neither retried by the load
+// balancer nor counted as a failure by the circuit breaker.
+func permissionDenied(response func(error) interface{}) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			resp, err := next(ctx, request)
+			if status.Code(err) == codes.PermissionDenied {
+				return response(authz.ErrForbidden), nil
+			}
+			return resp, err
+		}
+	}
+}
+
 func str2err(s string) error {
 	if s == "" {
 		return nil
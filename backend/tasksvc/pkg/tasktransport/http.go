@@ -5,10 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
-	"os"
 	"strconv"
 
-	stdjwt "github.com/dgrijalva/jwt-go"
 	kitjwt "github.com/go-kit/kit/auth/jwt"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
@@ -16,107 +14,119 @@ import (
 	httptransport "github.com/go-kit/kit/transport/http"
 	"github.com/gorilla/mux"
 	"github.com/ichigozero/gtdkit/backend/authsvc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/authz"
+	"github.com/ichigozero/gtdkit/backend/authsvc/identity"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/authsvc/pat"
 	"github.com/ichigozero/gtdkit/backend/tasksvc"
 	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/taskendpoint"
 	"github.com/ichigozero/gtdkit/backend/usersvc"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func NewHTTPHandler(endpoints taskendpoint.Set, logger log.Logger) http.Handler {
+func NewHTTPHandler(endpoints taskendpoint.Set, keys *jwks.Cache, resolve pat.Resolver, identityKey []byte, logger log.Logger) http.Handler {
 	options := []httptransport.ServerOption{
 		httptransport.ServerErrorEncoder(errorEncoder),
 		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
 	}
 
-	kf := func(token *stdjwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("ACCESS_SECRET")), nil
-	}
-
 	var createTaskEndpoint endpoint.Endpoint
 	{
 		createTaskEndpoint = endpoints.CreateTaskEndpoint
-		createTaskEndpoint = kitjwt.NewParser(
-			kf,
-			stdjwt.SigningMethodHS256,
+		createTaskEndpoint = identity.NewParser(identityKey, pat.NewParser(resolve, kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
 			kitjwt.MapClaimsFactory,
-		)(createTaskEndpoint)
+		)))(createTaskEndpoint)
 	}
 
 	createTaskHandler := httptransport.NewServer(
 		createTaskEndpoint,
 		decodeHTTPCreateTaskRequest,
 		encodeHTTPGenericResponse,
-		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext(), identity.HTTPToContext()))...,
 	)
 
 	var tasksEndpoint endpoint.Endpoint
 	{
 		tasksEndpoint = endpoints.TasksEndpoint
-		tasksEndpoint = kitjwt.NewParser(
-			kf,
-			stdjwt.SigningMethodHS256,
+		tasksEndpoint = identity.NewParser(identityKey, pat.NewParser(resolve, kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
 			kitjwt.MapClaimsFactory,
-		)(tasksEndpoint)
+		)))(tasksEndpoint)
 	}
 
 	tasksHandler := httptransport.NewServer(
 		tasksEndpoint,
 		decodeHTTPTasksRequest,
 		encodeHTTPGenericResponse,
-		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext(), identity.HTTPToContext()))...,
 	)
 
 	var taskEndpoint endpoint.Endpoint
 	{
 		taskEndpoint = endpoints.TaskEndpoint
-		taskEndpoint = kitjwt.NewParser(
-			kf,
-			stdjwt.SigningMethodHS256,
+		taskEndpoint = identity.NewParser(identityKey, pat.NewParser(resolve, kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
 			kitjwt.MapClaimsFactory,
-		)(taskEndpoint)
+		)))(taskEndpoint)
 	}
 
 	taskHandler := httptransport.NewServer(
 		taskEndpoint,
 		decodeHTTPTaskRequest,
 		encodeHTTPGenericResponse,
-		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext(), identity.HTTPToContext()))...,
 	)
 
 	var updateTaskEndpoint endpoint.Endpoint
 	{
 		updateTaskEndpoint = endpoints.UpdateTaskEndpoint
-		updateTaskEndpoint = kitjwt.NewParser(
-			kf,
-			stdjwt.SigningMethodHS256,
+		updateTaskEndpoint = identity.NewParser(identityKey, pat.NewParser(resolve, kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
 			kitjwt.MapClaimsFactory,
-		)(updateTaskEndpoint)
+		)))(updateTaskEndpoint)
 	}
 
 	updateTaskHandler := httptransport.NewServer(
 		updateTaskEndpoint,
 		decodeHTTPUpdateTaskRequest,
 		encodeHTTPGenericResponse,
-		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext(), identity.HTTPToContext()))...,
 	)
 
 	var deleteTaskEndpoint endpoint.Endpoint
 	{
 		deleteTaskEndpoint = endpoints.DeleteTaskEndpoint
-		deleteTaskEndpoint = kitjwt.NewParser(
-			kf,
-			stdjwt.SigningMethodHS256,
+		deleteTaskEndpoint = identity.NewParser(identityKey, pat.NewParser(resolve, kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
 			kitjwt.MapClaimsFactory,
-		)(deleteTaskEndpoint)
+		)))(deleteTaskEndpoint)
 	}
 
 	deleteTaskHandler := httptransport.NewServer(
 		deleteTaskEndpoint,
 		decodeHTTPDeleteTaskRequest,
 		encodeHTTPGenericResponse,
-		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext()))...,
+		append(options, httptransport.ServerBefore(kitjwt.HTTPToContext(), identity.HTTPToContext()))...,
 	)
 
+	var watchTasksEndpoint endpoint.Endpoint
+	{
+		watchTasksEndpoint = endpoints.WatchTasksEndpoint
+		watchTasksEndpoint = identity.NewParser(identityKey, pat.NewParser(resolve, kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)))(watchTasksEndpoint)
+	}
+
+	streamHandler := streamHandler{watchTasks: watchTasksEndpoint, logger: logger}
+
 	r := mux.NewRouter()
 
 	r.Methods("POST").Path("/create").Handler(createTaskHandler)
@@ -124,6 +134,8 @@ func NewHTTPHandler(endpoints taskendpoint.Set, logger log.Logger) http.Handler
 	r.Methods("GET").Path("/task/{task_id}").Handler(taskHandler)
 	r.Methods("PUT").Path("/task/{task_id}").Handler(updateTaskHandler)
 	r.Methods("DELETE").Path("/task/{task_id}").Handler(deleteTaskHandler)
+	r.Methods("GET").Path("/stream").HeadersRegexp("Upgrade", "(?i)^websocket$").HandlerFunc(streamHandler.serveWebSocket)
+	r.Methods("GET").Path("/stream").HandlerFunc(streamHandler.serveSSE)
 	r.Methods("GET").Path("/metrics").Handler(promhttp.Handler())
 
 	return r
@@ -140,7 +152,9 @@ type errorWrapper struct {
 
 func err2code(err error) int {
 	switch err {
-	case kitjwt.ErrTokenExpired, usersvc.ErrUserNotFound, authsvc.ErrUserIDContextMissing:
+	case authz.ErrForbidden, usersvc.ErrUserDisabled, usersvc.ErrPasswordResetRequired:
+		return http.StatusForbidden
+	case kitjwt.ErrTokenExpired, kitjwt.ErrUnexpectedSigningMethod, jwks.ErrKeyIDMissing, jwks.ErrUnknownKey, usersvc.ErrUserNotFound, authsvc.ErrUserIDContextMissing, authsvc.ErrInvalidToken, identity.ErrInvalidIdentity:
 		return http.StatusUnauthorized
 	case usersvc.ErrInvalidArgument, authsvc.ErrInvalidArgument, tasksvc.ErrInvalidArgument:
 		return http.StatusBadRequest
@@ -0,0 +1,240 @@
+package tasktransport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/authsvc/identity"
+	"github.com/ichigozero/gtdkit/backend/tasksvc"
+	"github.com/ichigozero/gtdkit/backend/tasksvc/pkg/taskendpoint"
+	"golang.org/x/net/websocket"
+)
+
+// streamHeartbeat is how often an idle stream is written to, so that the
+// proxies in between do not close it.
+const streamHeartbeat = 20 * time.Second
+
+var errStreamingUnsupported = errors.New("streaming is not supported by the connection")
+
+// streamHandler serves the WatchTasks endpoint as Server-Sent Events, or
+// over a WebSocket. Both resume after the last event ID, taken from the
+// Last-Event-ID header sent by EventSource when it reconnects or from the
+// lastEventId query parameter.
+type streamHandler struct {
+	watchTasks endpoint.Endpoint
+	logger     log.Logger
+}
+
+// streamEvent is an event as written to the streams.
+type streamEvent struct {
+	ID        uint64        `json:"id"`
+	Type      string        `json:"type"`
+	Task      *tasksvc.Task `json:"task,omitempty"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+func newStreamEvent(e tasksvc.Event) streamEvent {
+	event := streamEvent{ID: e.ID, Type: e.Type, CreatedAt: e.CreatedAt}
+	if e.Task.ID != 0 {
+		event.Task = &e.Task
+	}
+	return event
+}
+
+// serveSSE writes the response once the first event was sent, so that the
+// errors refusing the stream are returned with their status code.
+func (h streamHandler) serveSSE(w http.ResponseWriter, r *http.Request) {
+	ctx := streamContext(r)
+
+	lastEventID, err := parseLastEventID(r)
+	if err != nil {
+		errorEncoder(ctx, err, w)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errorEncoder(ctx, errStreamingUnsupported, w)
+		return
+	}
+
+	var (
+		mtx     sync.Mutex
+		started bool
+	)
+	send := func(e tasksvc.Event) error {
+		b, err := json.Marshal(newStreamEvent(e))
+		if err != nil {
+			return err
+		}
+
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			// Disables the buffering of nginx.
+			w.Header().Set("X-Accel-Buffering", "no")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		if _, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, b); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	stop := heartbeat(func() error {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		if !started {
+			return nil
+		}
+		if _, err := io.WriteString(w, ":\n\n"); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	})
+	defer stop()
+
+	err = h.watch(ctx, lastEventID, send)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if err != nil && !started {
+		errorEncoder(ctx, err, w)
+	}
+}
+
+// serveWebSocket sends every event as a JSON text message. The socket is
+// closed when the stream ends, after a message holding the error if it
+// failed. The messages of the client are discarded.
+func (h streamHandler) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	ctx := streamContext(r)
+
+	lastEventID, err := parseLastEventID(r)
+	if err != nil {
+		errorEncoder(ctx, err, w)
+		return
+	}
+
+	server := websocket.Server{
+		// The origin is not checked: the socket is authorized by the access
+		// token, which a foreign page does not have, and not by a cookie.
+		Handshake: func(*websocket.Config, *http.Request) error { return nil },
+		Handler: func(ws *websocket.Conn) {
+			defer ws.Close()
+
+			ctx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go func() {
+				io.Copy(ioutil.Discard, ws)
+				cancel()
+			}()
+
+			var mtx sync.Mutex
+			send := func(e tasksvc.Event) error {
+				mtx.Lock()
+				defer mtx.Unlock()
+
+				return websocket.JSON.Send(ws, newStreamEvent(e))
+			}
+
+			stop := heartbeat(func() error {
+				mtx.Lock()
+				defer mtx.Unlock()
+
+				fw, err := ws.NewFrameWriter(websocket.PingFrame)
+				if err != nil {
+					return err
+				}
+				return fw.Close()
+			})
+			defer stop()
+
+			if err := h.watch(ctx, lastEventID, send); err != nil {
+				mtx.Lock()
+				defer mtx.Unlock()
+
+				websocket.JSON.Send(ws, errorWrapper{Error: err.Error()})
+			}
+		},
+	}
+	server.ServeHTTP(w, r)
+}
+
+// watch calls the endpoint and returns the error which ended the stream,
+// other than the client going away.
+func (h streamHandler) watch(ctx context.Context, lastEventID uint64, send func(tasksvc.Event) error) error {
+	response, err := h.watchTasks(ctx, taskendpoint.WatchTasksRequest{LastEventID: lastEventID, Send: send})
+	if err == nil {
+		err = response.(taskendpoint.WatchTasksResponse).Err
+	}
+	if err != nil && ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		h.logger.Log("method", "WatchTasks", "err", err)
+	}
+	return err
+}
+
+// streamContext passes the credentials of the request on, as the
+// ServerBefore functions of the other handlers do.
+func streamContext(r *http.Request) context.Context {
+	ctx := r.Context()
+	ctx = kitjwt.HTTPToContext()(ctx, r)
+	ctx = identity.HTTPToContext()(ctx, r)
+	return ctx
+}
+
+func parseLastEventID(r *http.Request) (uint64, error) {
+	s := r.Header.Get("Last-Event-ID")
+	if s == "" {
+		s = r.URL.Query().Get("lastEventId")
+	}
+	if s == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, tasksvc.ErrInvalidArgument
+	}
+	return id, nil
+}
+
+// heartbeat calls beat every streamHeartbeat until the returned function is
+// called, or beat fails.
+func heartbeat(beat func() error) func() {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(streamHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := beat(); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -1,6 +1,9 @@
 package tasksvc
 
-import "errors"
+import (
+	"errors"
+	"time"
+)
 
 type Task struct {
 	ID          uint64 `json:"id"`
@@ -10,17 +13,163 @@ type Task struct {
 	UserID      uint64 `json:"userId"`
 }
 
+// Event records a change of a task, so that the other devices of its user
+// can follow it. The IDs increase in the order the changes were committed,
+// without gaps, see EventSequence, so that a cursor never passes an event
+// which is not visible yet.
+type Event struct {
+	ID        uint64    `json:"id"`
+	UserID    uint64    `json:"-" gorm:"index"`
+	Type      string    `json:"type"`
+	Task      Task      `json:"task" gorm:"embedded;embeddedPrefix:task_"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// The types of the events. EventReady and EventReset are not recorded,
+// they start the streams of the watchers.
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+	// EventReady carries the ID the stream continues from.
+	EventReady = "ready"
+	// EventReset is sent instead of EventReady when the events after the
+	// requested one are no longer kept, the tasks have to be fetched
+	// again.
+	EventReset = "reset"
+)
+
+// The fields of a task which are synced, and merged, one by one.
+const (
+	FieldTitle       = "title"
+	FieldDescription = "description"
+	FieldDone        = "done"
+	// FieldDeleted names the deletion of the task in the conflicts.
+	FieldDeleted = "deleted"
+)
+
+// Fields lists the synced fields in the order they are merged.
+var Fields = []string{FieldTitle, FieldDescription, FieldDone}
+
+// FieldClock records when a field of a task was last changed, and the event
+// of the change, so that the changes made offline are merged field by
+// field.
+type FieldClock struct {
+	TaskID    uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Field     string `gorm:"primaryKey"`
+	ChangedAt time.Time
+	EventID   uint64
+}
+
+// ClientTask maps the ID a client gave a task it created offline to the ID
+// of the task, so that a sync which is retried does not create it twice.
+// It is kept after the task and its events were deleted, as the client may
+// retry at any time.
+type ClientTask struct {
+	UserID   uint64 `gorm:"primaryKey;autoIncrement:false"`
+	ClientID string `gorm:"primaryKey"`
+	TaskID   uint64
+}
+
+// EventSequence holds the ID of the latest event. Its single row is locked
+// by the transaction recording an event until it commits, so that the
+// events are numbered in the order they are committed.
+type EventSequence struct {
+	ID   uint64 `gorm:"primaryKey;autoIncrement:false"`
+	Last uint64
+}
+
+// Change is a change a client made to a task while offline. TaskID is zero
+// for the tasks it created, which are identified by ClientID instead. Only
+// the listed Fields were changed, unless the task was Deleted.
+type Change struct {
+	TaskID      uint64
+	ClientID    string
+	Fields      []string
+	Title       string
+	Description string
+	Done        bool
+	Deleted     bool
+	ChangedAt   time.Time
+}
+
+// Conflict reports a field changed by the client which was also changed on
+// the server since the cursor of the client. Applied tells whether the
+// change of the client won.
+type Conflict struct {
+	TaskID   uint64
+	ClientID string
+	Field    string
+	Applied  bool
+}
+
+// Merge is how a change is written to a task: the task is created, deleted,
+// or its Fields are updated to the values of Task.
+type Merge struct {
+	Task      Task
+	Fields    []string
+	ChangedAt time.Time
+	Create    bool
+	Delete    bool
+	ClientID  string
+}
+
+// SyncResult holds the changes made on the server since the cursor of the
+// client, up to Cursor. When Full is set, Tasks holds every task of the user
+// and the client drops the others. Created maps the client IDs of the tasks
+// created by the sync to their IDs.
+type SyncResult struct {
+	Cursor    uint64
+	Full      bool
+	Tasks     []Task
+	Deleted   []uint64
+	Created   map[string]uint64
+	Conflicts []Conflict
+}
+
+// TaskRepository records an event along with every change, and keeps the
+// clocks of the fields changed.
 type TaskRepository interface {
 	Create(title, description string, userID uint64) (Task, error)
 	FindAll(userID uint64) ([]Task, error)
 	Find(userID, taskID uint64) (Task, error)
 	Update(task Task) (Task, error)
 	Delete(userID, taskID uint64) (bool, error)
+	// Events returns up to limit events of the user after the event
+	// afterID, oldest first.
+	Events(userID, afterID uint64, limit int) ([]Event, error)
+	// EventRange returns the IDs of the oldest and the latest events kept,
+	// of every user, or zeros if there is none.
+	EventRange() (first, last uint64, err error)
+	// EventUsers returns the users who have events after the event
+	// afterID, and the ID of the latest of those events, or afterID if
+	// there is none.
+	EventUsers(afterID uint64) (userIDs []uint64, last uint64, err error)
+	// DeleteEvents deletes the events recorded before t.
+	DeleteEvents(before time.Time) error
+	// SyncTask calls merge with the task of the user, the clocks of its
+	// fields and whether it was found, then writes the merge along with
+	// its event, in one transaction. It returns the task and the ID of the
+	// event, zero if nothing was written. taskID is zero to create a task.
+	SyncTask(userID, taskID uint64, merge func(task Task, clocks map[string]FieldClock, found bool) Merge) (Task, uint64, error)
+	// CreatedTask returns the ID of the task the user created offline as
+	// clientID, or zero. SyncTask fails with ErrClientIDTaken if it was
+	// created by a concurrent sync.
+	CreatedTask(userID uint64, clientID string) (uint64, error)
 }
 
+// Auth holds the claims of the access token. ClientID and Scopes are only
+// set for the tokens of third-party clients. Personal access tokens have
+// Scopes as well, their AccessUUID is the ID of the token. SessionValidated
+// is set for the identities signed by the gateway, which already validated
+// the session with authsvc.
 type Auth struct {
-	AccessUUID string
-	UserID     uint64
+	AccessUUID          string
+	UserID              uint64
+	ClientID            string
+	Scopes              []string
+	PersonalAccessToken bool
+	SessionValidated    bool
 }
 
 var (
@@ -28,4 +177,5 @@ var (
 	ErrUserIDContextMissing = errors.New("user ID was not passed through the context")
 	ErrClaimsMissing        = errors.New("JWT claims was not passed through the context")
 	ErrClaimsInvalid        = errors.New("JWT claims was invalid")
+	ErrClientIDTaken        = errors.New("client ID was already taken")
 )
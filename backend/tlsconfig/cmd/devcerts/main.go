@@ -0,0 +1,178 @@
+// Command devcerts generates a local CA and a certificate for every
+// service, to try out TLS between the services locally. The certificates
+// are valid for both serving and calling the other services. They must not
+// be used in production.
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func main() {
+	fs := flag.NewFlagSet("devcerts", flag.ExitOnError)
+	var (
+		out = fs.String(
+			"out",
+			"certs",
+			"Directory the PEM files are written to",
+		)
+		services = fs.String(
+			"services",
+			"apigateway,authsvc,usersvc,tasksvc",
+			"Comma separated services to issue a certificate for",
+		)
+		hosts = fs.String(
+			"hosts",
+			"localhost,127.0.0.1,::1",
+			"Comma separated host names and IPs every certificate is valid for, besides the name of the service",
+		)
+		validFor = fs.Duration(
+			"valid-for",
+			365*24*time.Hour,
+			"Validity of the certificates",
+		)
+	)
+
+	fs.Usage = usageFor(fs, os.Args[0]+" [flags]")
+	fs.Parse(os.Args[1:])
+
+	var logger log.Logger
+	{
+		logger = log.NewLogfmtLogger(os.Stderr)
+		logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+		logger = log.With(logger, "caller", log.DefaultCaller)
+	}
+
+	if err := os.MkdirAll(*out, 0o700); err != nil {
+		logger.Log("during", "MkdirAll", "err", err)
+		os.Exit(1)
+	}
+
+	ca, caKey, err := issue(nil, nil, "gtdkit dev CA", nil, *validFor)
+	if err != nil {
+		logger.Log("during", "issue", "name", "ca", "err", err)
+		os.Exit(1)
+	}
+	if err := write(*out, "ca", ca, caKey); err != nil {
+		logger.Log("during", "write", "name", "ca", "err", err)
+		os.Exit(1)
+	}
+
+	for _, service := range split(*services) {
+		cert, key, err := issue(ca, caKey, service, append([]string{service}, split(*hosts)...), *validFor)
+		if err != nil {
+			logger.Log("during", "issue", "name", service, "err", err)
+			os.Exit(1)
+		}
+		if err := write(*out, service, cert, key); err != nil {
+			logger.Log("during", "write", "name", service, "err", err)
+			os.Exit(1)
+		}
+		logger.Log("service", service, "cert", filepath.Join(*out, service+".pem"))
+	}
+}
+
+// issue returns a certificate signed by the parent, or a self-signed CA if
+// there is none.
+func issue(parent *x509.Certificate, parentKey *ecdsa.PrivateKey, name string, hosts []string, validFor time.Duration) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name, Organization: []string{"gtdkit"}},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(validFor),
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		parent, parentKey = tmpl, key
+	} else {
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		for _, h := range hosts {
+			if ip := net.ParseIP(h); ip != nil {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			} else {
+				tmpl.DNSNames = append(tmpl.DNSNames, h)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, key, nil
+}
+
+// write writes <name>.pem and <name>-key.pem, the latter readable by the
+// owner only.
+func write(dir, name string, cert *x509.Certificate, key *ecdsa.PrivateKey) error {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	if err := os.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0o644); err != nil {
+		return err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	return os.WriteFile(filepath.Join(dir, name+"-key.pem"), keyPEM, 0o600)
+}
+
+func split(s string) []string {
+	var values []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func usageFor(fs *flag.FlagSet, short string) func() {
+	return func() {
+		fmt.Fprintf(os.Stderr, "USAGE\n")
+		fmt.Fprintf(os.Stderr, "  %s\n", short)
+		fmt.Fprintf(os.Stderr, "\n")
+		fmt.Fprintf(os.Stderr, "FLAGS\n")
+		w := tabwriter.NewWriter(os.Stderr, 0, 2, 2, ' ', 0)
+		fs.VisitAll(func(f *flag.Flag) {
+			fmt.Fprintf(w, "\t-%s %s\t%s\n", f.Name, f.DefValue, f.Usage)
+		})
+		w.Flush()
+		fmt.Fprintf(os.Stderr, "\n")
+	}
+}
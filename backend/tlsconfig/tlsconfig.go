@@ -0,0 +1,104 @@
+// Package tlsconfig builds the TLS configs of the services from PEM files.
+// The services authenticate each other by certificates issued by a common
+// CA, which devcerts generates for local development: the certificate a
+// service is served with is also the client certificate it presents to the
+// other services.
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+var (
+	ErrKeyPairIncomplete = errors.New("TLS certificate and key must be given together")
+	ErrInvalidCA         = errors.New("TLS CA file contains no certificate")
+)
+
+// Files are the paths of the PEM files. Without a certificate TLS is
+// disabled, without a CA the system roots verify the servers and client
+// certificates are not requested.
+type Files struct {
+	CA   string
+	Cert string
+	Key  string
+}
+
+// Enabled reports whether TLS is configured at all.
+func (f Files) Enabled() bool {
+	return f.Cert != "" || f.Key != "" || f.CA != ""
+}
+
+// Server returns the config of a server, which requires the clients to
+// present a certificate issued by the CA if one is given. It is nil if TLS
+// is disabled.
+func Server(f Files) (*tls.Config, error) {
+	if !f.Enabled() {
+		return nil, nil
+	}
+	if f.Cert == "" || f.Key == "" {
+		return nil, ErrKeyPairIncomplete
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.Cert, f.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if f.CA != "" {
+		pool, err := loadCA(f.CA)
+		if err != nil {
+			return nil, err
+		}
+		c.ClientCAs = pool
+		c.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return c, nil
+}
+
+// Client returns the config to call the other services, presenting the
+// certificate if one is given. It is nil if TLS is disabled.
+func Client(f Files) (*tls.Config, error) {
+	if !f.Enabled() {
+		return nil, nil
+	}
+	if (f.Cert == "") != (f.Key == "") {
+		return nil, ErrKeyPairIncomplete
+	}
+
+	c := &tls.Config{MinVersion: tls.VersionTLS12}
+	if f.CA != "" {
+		pool, err := loadCA(f.CA)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = pool
+	}
+	if f.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(f.Cert, f.Key)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+func loadCA(path string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, ErrInvalidCA
+	}
+	return pool, nil
+}
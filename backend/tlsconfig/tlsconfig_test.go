@@ -0,0 +1,252 @@
+package tlsconfig_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/tlsconfig"
+)
+
+type issuer struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newCA returns a self-signed CA, like the one of devcerts.
+func newCA(t *testing.T, name string) issuer {
+	t.Helper()
+
+	cert, key := issue(t, nil, name)
+	return issuer{cert: cert, key: key}
+}
+
+// issue returns a certificate for 127.0.0.1 signed by the CA, or the CA
+// itself if there is none.
+func issue(t *testing.T, ca *issuer, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	parent, parentKey := tmpl, key
+	if ca == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		tmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+		parent, parentKey = ca.cert, ca.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// write writes the PEM files of a certificate, and of its key if any, and
+// returns their paths.
+func write(t *testing.T, name string, cert *x509.Certificate, key *ecdsa.PrivateKey) (string, string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	certPath := filepath.Join(dir, name+".pem")
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if key == nil {
+		return certPath, ""
+	}
+
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPath := filepath.Join(dir, name+"-key.pem")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certPath, keyPath
+}
+
+// files returns the files of a service whose certificate is issued by the
+// CA, trusting the CA.
+func files(t *testing.T, ca issuer, name string) tlsconfig.Files {
+	t.Helper()
+
+	caPath, _ := write(t, "ca", ca.cert, nil)
+	cert, key := issue(t, &ca, name)
+	certPath, keyPath := write(t, name, cert, key)
+	return tlsconfig.Files{CA: caPath, Cert: certPath, Key: keyPath}
+}
+
+// serve starts a server with the config, returning its URL.
+func serve(t *testing.T, c *tls.Config) string {
+	t.Helper()
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	srv.TLS = c
+	// The refused handshakes are expected.
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0)
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func get(url string, c *tls.Config) error {
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: c}}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// TestServerRequiresClientCertificate checks that a server given a CA only
+// accepts the clients presenting a certificate issued by it.
+func TestServerRequiresClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	ca := newCA(t, "ca")
+	server, err := tlsconfig.Server(files(t, ca, "authsvc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.ClientAuth != tls.RequireAndVerifyClientCert || server.ClientCAs == nil {
+		t.Fatalf("want client certificates required and verified, got %v", server.ClientAuth)
+	}
+	url := serve(t, server)
+
+	client, err := tlsconfig.Client(files(t, ca, "apigateway"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := get(url, client); err != nil {
+		t.Errorf("client of the CA: want it accepted, got %v", err)
+	}
+
+	anonymous := client.Clone()
+	anonymous.Certificates = nil
+	if err := get(url, anonymous); err == nil {
+		t.Error("client without certificate: want it refused")
+	}
+
+	foreign, err := tlsconfig.Client(files(t, newCA(t, "other"), "apigateway"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign.RootCAs = client.RootCAs
+	if err := get(url, foreign); err == nil {
+		t.Error("client of another CA: want it refused")
+	}
+}
+
+// TestServerRefusesOldTLS checks that both configs refuse the versions of
+// TLS before 1.2.
+func TestServerRefusesOldTLS(t *testing.T) {
+	t.Parallel()
+
+	ca := newCA(t, "ca")
+	f := files(t, ca, "tasksvc")
+	server, err := tlsconfig.Server(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := tlsconfig.Client(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if server.MinVersion != tls.VersionTLS12 || client.MinVersion != tls.VersionTLS12 {
+		t.Errorf("want TLS 1.2 at least, got %x and %x", server.MinVersion, client.MinVersion)
+	}
+
+	url := serve(t, server)
+	old := client.Clone()
+	old.MinVersion, old.MaxVersion = tls.VersionTLS10, tls.VersionTLS11
+	if err := get(url, old); err == nil {
+		t.Error("TLS 1.1: want it refused")
+	}
+
+	tls12 := client.Clone()
+	tls12.MaxVersion = tls.VersionTLS12
+	if err := get(url, tls12); err != nil {
+		t.Errorf("TLS 1.2: want it accepted, got %v", err)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	t.Parallel()
+
+	ca := newCA(t, "ca")
+	f := files(t, ca, "usersvc")
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if c, err := tlsconfig.Server(tlsconfig.Files{}); c != nil || err != nil {
+		t.Errorf("no files: want TLS disabled, got %v, %v", c, err)
+	}
+	if c, err := tlsconfig.Server(tlsconfig.Files{Cert: f.Cert, Key: f.Key}); err != nil || c.ClientAuth != tls.NoClientCert {
+		t.Errorf("no CA: want no client certificate requested, got %v", err)
+	}
+	if c, err := tlsconfig.Client(tlsconfig.Files{CA: f.CA}); err != nil || c.RootCAs == nil || len(c.Certificates) != 0 {
+		t.Errorf("CA only: want the client to verify the servers only, got %v", err)
+	}
+
+	for name, tc := range map[string]struct {
+		files  tlsconfig.Files
+		server bool
+		err    error
+	}{
+		"server without key":  {tlsconfig.Files{CA: f.CA, Cert: f.Cert}, true, tlsconfig.ErrKeyPairIncomplete},
+		"server CA only":      {tlsconfig.Files{CA: f.CA}, true, tlsconfig.ErrKeyPairIncomplete},
+		"client without cert": {tlsconfig.Files{Key: f.Key}, false, tlsconfig.ErrKeyPairIncomplete},
+		"server empty CA":     {tlsconfig.Files{CA: empty, Cert: f.Cert, Key: f.Key}, true, tlsconfig.ErrInvalidCA},
+		"client empty CA":     {tlsconfig.Files{CA: empty}, false, tlsconfig.ErrInvalidCA},
+	} {
+		var err error
+		if tc.server {
+			_, err = tlsconfig.Server(tc.files)
+		} else {
+			_, err = tlsconfig.Client(tc.files)
+		}
+		if err != tc.err {
+			t.Errorf("%s: want %v, got %v", name, tc.err, err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"crypto/tls"
 	"io"
 	"time"
 
@@ -13,9 +14,12 @@ import (
 	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/userservice"
 	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/usertransport"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-func New(apiclient consulsd.Client, logger log.Logger, retryMax int, retryTimeout time.Duration) (userendpoint.Set, error) {
+// New returns the endpoints of the instances registered in Consul. They
+// are called over TLS if tlsConfig is not nil.
+func New(apiclient consulsd.Client, tlsConfig *tls.Config, logger log.Logger, retryMax int, retryTimeout time.Duration) (userendpoint.Set, error) {
 	var (
 		tags        = []string{}
 		passingOnly = true
@@ -23,26 +27,121 @@ func New(apiclient consulsd.Client, logger log.Logger, retryMax int, retryTimeou
 		instancer   = consulsd.NewInstancer(apiclient, logger, "usersvc", tags, passingOnly)
 	)
 	{
-		factory := factoryFor(userendpoint.MakeUserIDEndpoint, logger)
+		factory := factoryFor(userendpoint.MakeUserIDEndpoint, tlsConfig, logger)
 		endpointer := sd.NewEndpointer(instancer, factory, logger)
 		balancer := lb.NewRoundRobin(endpointer)
 		retry := lb.Retry(retryMax, retryTimeout, balancer)
 		endpoints.UserIDEndpoint = retry
 	}
 	{
-		factory := factoryFor(userendpoint.MakeIsExistsEndpoint, logger)
+		factory := factoryFor(userendpoint.MakeIsExistsEndpoint, tlsConfig, logger)
 		endpointer := sd.NewEndpointer(instancer, factory, logger)
 		balancer := lb.NewRoundRobin(endpointer)
 		retry := lb.Retry(retryMax, retryTimeout, balancer)
 		endpoints.IsExistsEndpoint = retry
 	}
+	{
+		factory := factoryFor(userendpoint.MakeProfileEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.ProfileEndpoint = retry
+	}
+	{
+		factory := factoryFor(userendpoint.MakeEnrollTOTPEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.EnrollTOTPEndpoint = retry
+	}
+	{
+		factory := factoryFor(userendpoint.MakeConfirmTOTPEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.ConfirmTOTPEndpoint = retry
+	}
+	{
+		factory := factoryFor(userendpoint.MakeVerifyTOTPEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.VerifyTOTPEndpoint = retry
+	}
+	{
+		factory := factoryFor(userendpoint.MakeDisableTOTPEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.DisableTOTPEndpoint = retry
+	}
+	{
+		factory := factoryFor(userendpoint.MakeChangePasswordEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.ChangePasswordEndpoint = retry
+	}
+	{
+		factory := factoryFor(userendpoint.MakeLinkIdentityEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.LinkIdentityEndpoint = retry
+	}
+	{
+		factory := factoryFor(userendpoint.MakeListUsersEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.ListUsersEndpoint = retry
+	}
+	{
+		factory := factoryFor(userendpoint.MakeGetUserEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.GetUserEndpoint = retry
+	}
+	{
+		factory := factoryFor(userendpoint.MakeSetDisabledEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.SetDisabledEndpoint = retry
+	}
+	{
+		factory := factoryFor(userendpoint.MakeForcePasswordResetEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.ForcePasswordResetEndpoint = retry
+	}
+	{
+		factory := factoryFor(userendpoint.MakeAssignRolesEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.AssignRolesEndpoint = retry
+	}
+	{
+		factory := factoryFor(userendpoint.MakeAuditLogEndpoint, tlsConfig, logger)
+		endpointer := sd.NewEndpointer(instancer, factory, logger)
+		balancer := lb.NewRoundRobin(endpointer)
+		retry := lb.Retry(retryMax, retryTimeout, balancer)
+		endpoints.AuditLogEndpoint = retry
+	}
 
 	return endpoints, nil
 }
 
-func factoryFor(makeEndpoint func(userservice.Service) endpoint.Endpoint, logger log.Logger) sd.Factory {
+func factoryFor(makeEndpoint func(userservice.Service) endpoint.Endpoint, tlsConfig *tls.Config, logger log.Logger) sd.Factory {
 	return func(instance string) (endpoint.Endpoint, io.Closer, error) {
-		conn, err := grpc.Dial(instance, grpc.WithInsecure())
+		creds := grpc.WithInsecure()
+		if tlsConfig != nil {
+			creds = grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig))
+		}
+		conn, err := grpc.Dial(instance, creds)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -1,20 +1,28 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"text/tabwriter"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
 
 	"github.com/go-kit/kit/log"
 	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	consulsd "github.com/go-kit/kit/sd/consul"
 	kitgrpc "github.com/go-kit/kit/transport/grpc"
 	"github.com/hashicorp/consul/api"
+	authclient "github.com/ichigozero/gtdkit/backend/authsvc/client"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/tlsconfig"
 	"github.com/ichigozero/gtdkit/backend/usersvc"
 	"github.com/ichigozero/gtdkit/backend/usersvc/db/gorm"
 	"github.com/ichigozero/gtdkit/backend/usersvc/pb"
@@ -25,6 +33,7 @@ import (
 	stdprometheus "github.com/prometheus/client_golang/prometheus"
 	"github.com/twinj/uuid"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	libgorm "gorm.io/gorm"
@@ -33,9 +42,16 @@ import (
 func main() {
 	fs := flag.NewFlagSet("usersvc", flag.ExitOnError)
 	var (
-		grpcAddr    = fs.String("grpc.addr", getEnv("GRPC_ADDR", ":8080"), "gRPC listen address")
-		consulAddr  = fs.String("consul.addr", getEnv("CONSUL_ADDR", ""), "Consul agent address")
-		databaseURL = fs.String("database.url", getEnv("DATABASE_URL", ""), "Database URL")
+		grpcAddr     = fs.String("grpc.addr", getEnv("GRPC_ADDR", ":8080"), "gRPC listen address")
+		consulAddr   = fs.String("consul.addr", getEnv("CONSUL_ADDR", ""), "Consul agent address")
+		databaseURL  = fs.String("database.url", getEnv("DATABASE_URL", ""), "Database URL")
+		adminUsers   = fs.String("admin.users", getEnv("ADMIN_USERS", ""), "Comma separated names of the users granted the admin role")
+		jwtAlg       = fs.String("jwt.alg", getEnv("JWT_ALG", "RS256"), "Signing algorithm of the access tokens, RS256 or EdDSA")
+		tlsCA        = fs.String("tls.ca", getEnv("TLS_CA", ""), "CA of the certificates the services present to each other, client certificates are not required if empty")
+		tlsCert      = fs.String("tls.cert", getEnv("TLS_CERT", ""), "Certificate served and presented to the other services, TLS is disabled if empty")
+		tlsKey       = fs.String("tls.key", getEnv("TLS_KEY", ""), "Private key of the certificate")
+		retryMax     = fs.Int("retry.max", getEnvAsInt("RETRY_MAX", 3), "per-request retries to different instances")
+		retryTimeout = fs.Duration("retry.timeout", time.Duration(getEnvAsInt("RETRY_TIMEOUT", 500))*time.Millisecond, "per-request timeout, including retries")
 	)
 
 	fs.Usage = usageFor(fs, os.Args[0]+" [flags]")
@@ -48,8 +64,20 @@ func main() {
 		logger = log.With(logger, "caller", log.DefaultCaller)
 	}
 
+	// The certificate is both served and presented to the other services.
+	tlsFiles := tlsconfig.Files{CA: *tlsCA, Cert: *tlsCert, Key: *tlsKey}
+	serverTLS, err := tlsconfig.Server(tlsFiles)
+	if err != nil {
+		logger.Log("during", "tlsconfig.Server", "err", err)
+		os.Exit(1)
+	}
+	clientTLS, err := tlsconfig.Client(tlsFiles)
+	if err != nil {
+		logger.Log("during", "tlsconfig.Client", "err", err)
+		os.Exit(1)
+	}
+
 	var db *libgorm.DB
-	var err error
 	{
 		if *databaseURL != "" {
 			db, err = libgorm.Open(postgres.Open(*databaseURL), &libgorm.Config{})
@@ -62,37 +90,26 @@ func main() {
 		}
 	}
 
-	db.AutoMigrate(&usersvc.User{})
+	db.AutoMigrate(&usersvc.User{}, &usersvc.AuditEntry{}, &usersvc.Identity{})
 	userRepository := gorm.NewUserRepository(db)
+	auditRepository := gorm.NewAuditRepository(db)
+	identityRepository := gorm.NewIdentityRepository(db)
 
-	fieldKeys := []string{"method"}
+	if err := grantAdmin(userRepository, *adminUsers); err != nil {
+		logger.Log("during", "grantAdmin", "err", err)
+		os.Exit(1)
+	}
 
-	var service userservice.Service
-	{
-		service = userservice.New(userRepository, logger)
-		service = userservice.InstrumentingMiddleware(
-			kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
-				Namespace: "api",
-				Subsystem: "user_service",
-				Name:      "request_count",
-				Help:      "Number of requests received.",
-			}, fieldKeys),
-			kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
-				Namespace: "api",
-				Subsystem: "user_service",
-				Name:      "request_latency_microseconds",
-				Help:      "Total duration of requests in microseconds.",
-			}, fieldKeys),
-			service,
-		)(service)
+	method := stdjwt.GetSigningMethod(*jwtAlg)
+	if method == nil {
+		logger.Log("err", "unsupported JWT signing algorithm", "alg", *jwtAlg)
+		os.Exit(1)
 	}
 
 	var (
-		endpoints  = userendpoint.New(service, logger)
-		grpcServer = usertransport.NewGRPCServer(endpoints, logger)
+		registrar *consulsd.Registrar
+		client    consulsd.Client
 	)
-
-	var registrar *consulsd.Registrar
 	{
 		consulConfig := api.DefaultConfig()
 		if len(*consulAddr) > 0 {
@@ -121,12 +138,51 @@ func main() {
 			Port:    p,
 		}
 
-		client := consulsd.NewClient(consulClient)
+		client = consulsd.NewClient(consulClient)
 		registrar = consulsd.NewRegistrar(client, asr, logger)
 		registrar.Register()
 		defer registrar.Deregister()
 	}
 
+	// The public keys of the access tokens are fetched from authsvc, which
+	// also validates and revokes the sessions of the administrators and of
+	// the users they change.
+	authEndpoints, _ := authclient.New(client, clientTLS, logger, *retryMax, *retryTimeout)
+	keys := jwks.NewCache(authEndpoints.JWKS, method, jwks.DefaultTTL)
+
+	fieldKeys := []string{"method"}
+
+	var service userservice.Service
+	{
+		service = userservice.New(userRepository, auditRepository, identityRepository, logger)
+		service = userservice.InstrumentingMiddleware(
+			kitprometheus.NewCounterFrom(stdprometheus.CounterOpts{
+				Namespace: "api",
+				Subsystem: "user_service",
+				Name:      "request_count",
+				Help:      "Number of requests received.",
+			}, fieldKeys),
+			kitprometheus.NewSummaryFrom(stdprometheus.SummaryOpts{
+				Namespace: "api",
+				Subsystem: "user_service",
+				Name:      "request_latency_microseconds",
+				Help:      "Total duration of requests in microseconds.",
+			}, fieldKeys),
+			service,
+		)(service)
+		service = userservice.ProxingMiddleware(func(ctx context.Context, userID uint64) error {
+			// The access token of the administrator is forwarded from the
+			// context, the access UUID is taken from its claims.
+			_, err := authEndpoints.RevokeUserSessions(ctx, "", userID)
+			return err
+		})(service)
+	}
+
+	var (
+		endpoints  = userendpoint.New(service, authEndpoints.Validate, logger)
+		grpcServer = usertransport.NewGRPCServer(endpoints, keys, logger)
+	)
+
 	var g group.Group
 	{
 		// The gRPC listener mounts the Go kit gRPC server we created.
@@ -138,7 +194,11 @@ func main() {
 		}
 		g.Add(func() error {
 			logger.Log("transport", "gRPC", "addr", *grpcAddr)
-			baseServer := grpc.NewServer(grpc.UnaryInterceptor(kitgrpc.Interceptor))
+			options := []grpc.ServerOption{grpc.UnaryInterceptor(kitgrpc.Interceptor)}
+			if serverTLS != nil {
+				options = append(options, grpc.Creds(credentials.NewTLS(serverTLS)))
+			}
+			baseServer := grpc.NewServer(options...)
 			pb.RegisterUserServer(baseServer, grpcServer)
 			return baseServer.Serve(grpcListener)
 		}, func(error) {
@@ -179,6 +239,28 @@ func usageFor(fs *flag.FlagSet, short string) func() {
 	}
 }
 
+// grantAdmin adds the admin role to the named users, so that the first
+// administrators can be set up without access to the database.
+func grantAdmin(users usersvc.UserRepository, names string) error {
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		u := users.GetUser(name)
+		if u.ID == 0 || u.Roles.Has(usersvc.RoleAdmin) {
+			continue
+		}
+
+		u.Roles = append(u.Roles, usersvc.RoleAdmin)
+		if err := users.Update(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func getEnv(key, fallback string) string {
 	value, exists := os.LookupEnv(key)
 	if !exists {
@@ -186,3 +268,15 @@ func getEnv(key, fallback string) string {
 	}
 	return value
 }
+
+func getEnvAsInt(key string, fallback int) int {
+	value, exists := os.LookupEnv(key)
+	if !exists {
+		return fallback
+	}
+
+	if v, err := strconv.Atoi(value); err == nil {
+		return v
+	}
+	return fallback
+}
@@ -1,3 +1,6 @@
 #!/bin/sh
 
+JWT_ALG=RS256
+RETRY_TIMEOUT=5000
+
 go run main.go --grpc.addr=127.0.0.1:8080 --consul.addr=127.0.0.1:8500
@@ -0,0 +1,40 @@
+package gorm
+
+import (
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+	libgorm "gorm.io/gorm"
+)
+
+type auditRepository struct {
+	db *libgorm.DB
+}
+
+func NewAuditRepository(db *libgorm.DB) usersvc.AuditRepository {
+	return &auditRepository{db}
+}
+
+func (a *auditRepository) Record(entry *usersvc.AuditEntry) error {
+	return a.db.Create(entry).Error
+}
+
+func (a *auditRepository) Find(from, to time.Time) ([]usersvc.AuditEntry, error) {
+	var entries []usersvc.AuditEntry
+	err := a.db.
+		Where("created_at >= ? AND created_at < ?", from, to).
+		Order("created_at, id").
+		Find(&entries).Error
+
+	return entries, err
+}
+
+func (a *auditRepository) FindByUser(userID uint64) ([]usersvc.AuditEntry, error) {
+	var entries []usersvc.AuditEntry
+	err := a.db.
+		Where("actor_id = ? OR target_id = ?", userID, userID).
+		Order("created_at, id").
+		Find(&entries).Error
+
+	return entries, err
+}
@@ -0,0 +1,32 @@
+package gorm
+
+import (
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+	libgorm "gorm.io/gorm"
+)
+
+type identityRepository struct {
+	db *libgorm.DB
+}
+
+func NewIdentityRepository(db *libgorm.DB) usersvc.IdentityRepository {
+	return &identityRepository{db}
+}
+
+func (i *identityRepository) GetIdentity(issuer, subject string) *usersvc.Identity {
+	var identity usersvc.Identity
+	i.db.Where("issuer = ? AND subject = ?", issuer, subject).First(&identity)
+
+	return &identity
+}
+
+func (i *identityRepository) FindByUser(userID uint64) ([]usersvc.Identity, error) {
+	var identities []usersvc.Identity
+	err := i.db.Where("user_id = ?", userID).Order("created_at, id").Find(&identities).Error
+
+	return identities, err
+}
+
+func (i *identityRepository) Create(identity *usersvc.Identity) error {
+	return i.db.Create(identity).Error
+}
@@ -20,6 +20,20 @@ func (u *userRepository) GetUser(username string) *usersvc.User {
 	return &user
 }
 
+func (u *userRepository) GetUserByEmail(email string) *usersvc.User {
+	var user usersvc.User
+	u.db.Where("LOWER(email) = LOWER(?)", email).Order("id").First(&user)
+
+	return &user
+}
+
+func (u *userRepository) GetUserByID(id uint64) *usersvc.User {
+	var user usersvc.User
+	u.db.First(&user, id)
+
+	return &user
+}
+
 func (u *userRepository) IsExists(id uint64) (bool, error) {
 	var user usersvc.User
 	u.db.First(&user, id)
@@ -30,3 +44,37 @@ func (u *userRepository) IsExists(id uint64) (bool, error) {
 
 	return true, nil
 }
+
+func (u *userRepository) Create(user *usersvc.User) error {
+	return u.db.Create(user).Error
+}
+
+func (u *userRepository) Update(user *usersvc.User) error {
+	return u.db.Save(user).Error
+}
+
+func (u *userRepository) UpdateAudited(user *usersvc.User, entry *usersvc.AuditEntry) error {
+	return u.db.Transaction(func(tx *libgorm.DB) error {
+		if err := tx.Save(user).Error; err != nil {
+			return err
+		}
+		return tx.Create(entry).Error
+	})
+}
+
+func (u *userRepository) List(query string, offset, limit int) ([]usersvc.User, int64, error) {
+	db := u.db.Model(&usersvc.User{})
+	if query != "" {
+		db = db.Where("name LIKE ?", "%"+query+"%")
+	}
+
+	var total int64
+	if err := db.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var users []usersvc.User
+	err := db.Order("id").Offset(offset).Limit(limit).Find(&users).Error
+
+	return users, total, err
+}
@@ -1,5 +1,10 @@
 #!/usr/bin/env sh
 
-protoc --go_out=. --go_opt=paths=source_relative \
+# google/api/annotations.proto is found in a checkout of
+# https://github.com/googleapis/googleapis.
+GOOGLEAPIS=${GOOGLEAPIS:-$HOME/googleapis}
+
+protoc -I . -I "$GOOGLEAPIS" \
+  --go_out=. --go_opt=paths=source_relative \
   --go-grpc_out=. --go-grpc_opt=paths=source_relative \
   usersvc.proto
@@ -4,8 +4,11 @@ import (
 	"context"
 	"time"
 
+	stdjwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
 )
 
 func LoggingMiddleware(logger log.Logger) endpoint.Middleware {
@@ -18,3 +21,31 @@ func LoggingMiddleware(logger log.Logger) endpoint.Middleware {
 		}
 	}
 }
+
+// SessionValidator reports whether the session of the access token is still
+// valid. It is implemented by authsvc, which cannot be imported here as it
+// depends on this package.
+type SessionValidator func(ctx context.Context, accessUUID string) (bool, error)
+
+// SessionMiddleware rejects the access tokens whose session was revoked, e.g.
+// by a logout or because the roles were changed, before the roles in their
+// claims are trusted.
+func SessionMiddleware(validate SessionValidator) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			claims, ok := ctx.Value(kitjwt.JWTClaimsContextKey).(stdjwt.MapClaims)
+			if !ok {
+				return nil, usersvc.ErrClaimsMissing
+			}
+			accessUUID, ok := claims["uuid"].(string)
+			if !ok || accessUUID == "" {
+				return nil, usersvc.ErrClaimsMissing
+			}
+
+			if ok, err := validate(ctx, accessUUID); err != nil || !ok {
+				return nil, usersvc.ErrSessionInvalid
+			}
+			return next(ctx, request)
+		}
+	}
+}
@@ -2,18 +2,42 @@ package userendpoint
 
 import (
 	"context"
+	"fmt"
+	"strconv"
+	"time"
 
+	stdjwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/authsvc/authz"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
 	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/userservice"
 )
 
 type Set struct {
 	UserIDEndpoint   endpoint.Endpoint
 	IsExistsEndpoint endpoint.Endpoint
+	ProfileEndpoint  endpoint.Endpoint
+
+	EnrollTOTPEndpoint  endpoint.Endpoint
+	ConfirmTOTPEndpoint endpoint.Endpoint
+	VerifyTOTPEndpoint  endpoint.Endpoint
+	DisableTOTPEndpoint endpoint.Endpoint
+
+	ChangePasswordEndpoint endpoint.Endpoint
+	LinkIdentityEndpoint   endpoint.Endpoint
+	PersonalDataEndpoint   endpoint.Endpoint
+
+	ListUsersEndpoint          endpoint.Endpoint
+	GetUserEndpoint            endpoint.Endpoint
+	SetDisabledEndpoint        endpoint.Endpoint
+	ForcePasswordResetEndpoint endpoint.Endpoint
+	AssignRolesEndpoint        endpoint.Endpoint
+	AuditLogEndpoint           endpoint.Endpoint
 }
 
-func New(svc userservice.Service, logger log.Logger) Set {
+func New(svc userservice.Service, validate SessionValidator, logger log.Logger) Set {
 	var userIDEndpoint endpoint.Endpoint
 	{
 		userIDEndpoint = MakeUserIDEndpoint(svc)
@@ -26,9 +50,121 @@ func New(svc userservice.Service, logger log.Logger) Set {
 		isExistsEndpoint = LoggingMiddleware(log.With(logger, "method", "IsExists"))(isExistsEndpoint)
 	}
 
+	var profileEndpoint endpoint.Endpoint
+	{
+		profileEndpoint = MakeProfileEndpoint(svc)
+		profileEndpoint = LoggingMiddleware(log.With(logger, "method", "Profile"))(profileEndpoint)
+	}
+
+	var enrollTOTPEndpoint endpoint.Endpoint
+	{
+		enrollTOTPEndpoint = MakeEnrollTOTPEndpoint(svc)
+		enrollTOTPEndpoint = LoggingMiddleware(log.With(logger, "method", "EnrollTOTP"))(enrollTOTPEndpoint)
+	}
+
+	var confirmTOTPEndpoint endpoint.Endpoint
+	{
+		confirmTOTPEndpoint = MakeConfirmTOTPEndpoint(svc)
+		confirmTOTPEndpoint = LoggingMiddleware(log.With(logger, "method", "ConfirmTOTP"))(confirmTOTPEndpoint)
+	}
+
+	var verifyTOTPEndpoint endpoint.Endpoint
+	{
+		verifyTOTPEndpoint = MakeVerifyTOTPEndpoint(svc)
+		verifyTOTPEndpoint = LoggingMiddleware(log.With(logger, "method", "VerifyTOTP"))(verifyTOTPEndpoint)
+	}
+
+	var disableTOTPEndpoint endpoint.Endpoint
+	{
+		disableTOTPEndpoint = MakeDisableTOTPEndpoint(svc)
+		disableTOTPEndpoint = LoggingMiddleware(log.With(logger, "method", "DisableTOTP"))(disableTOTPEndpoint)
+	}
+
+	var changePasswordEndpoint endpoint.Endpoint
+	{
+		changePasswordEndpoint = MakeChangePasswordEndpoint(svc)
+		changePasswordEndpoint = LoggingMiddleware(log.With(logger, "method", "ChangePassword"))(changePasswordEndpoint)
+	}
+
+	var linkIdentityEndpoint endpoint.Endpoint
+	{
+		linkIdentityEndpoint = MakeLinkIdentityEndpoint(svc)
+		linkIdentityEndpoint = LoggingMiddleware(log.With(logger, "method", "LinkIdentity"))(linkIdentityEndpoint)
+	}
+
+	var personalDataEndpoint endpoint.Endpoint
+	{
+		personalDataEndpoint = MakePersonalDataEndpoint(svc)
+		personalDataEndpoint = LoggingMiddleware(log.With(logger, "method", "PersonalData"))(personalDataEndpoint)
+	}
+
+	var listUsersEndpoint endpoint.Endpoint
+	{
+		listUsersEndpoint = MakeListUsersEndpoint(svc)
+		listUsersEndpoint = authz.Require(authz.DefaultPolicy, authz.ManageUsers)(listUsersEndpoint)
+		listUsersEndpoint = SessionMiddleware(validate)(listUsersEndpoint)
+		listUsersEndpoint = LoggingMiddleware(log.With(logger, "method", "ListUsers"))(listUsersEndpoint)
+	}
+
+	var getUserEndpoint endpoint.Endpoint
+	{
+		getUserEndpoint = MakeGetUserEndpoint(svc)
+		getUserEndpoint = authz.Require(authz.DefaultPolicy, authz.ManageUsers)(getUserEndpoint)
+		getUserEndpoint = SessionMiddleware(validate)(getUserEndpoint)
+		getUserEndpoint = LoggingMiddleware(log.With(logger, "method", "GetUser"))(getUserEndpoint)
+	}
+
+	var setDisabledEndpoint endpoint.Endpoint
+	{
+		setDisabledEndpoint = MakeSetDisabledEndpoint(svc)
+		setDisabledEndpoint = authz.Require(authz.DefaultPolicy, authz.ManageUsers)(setDisabledEndpoint)
+		setDisabledEndpoint = SessionMiddleware(validate)(setDisabledEndpoint)
+		setDisabledEndpoint = LoggingMiddleware(log.With(logger, "method", "SetDisabled"))(setDisabledEndpoint)
+	}
+
+	var forcePasswordResetEndpoint endpoint.Endpoint
+	{
+		forcePasswordResetEndpoint = MakeForcePasswordResetEndpoint(svc)
+		forcePasswordResetEndpoint = authz.Require(authz.DefaultPolicy, authz.ManageUsers)(forcePasswordResetEndpoint)
+		forcePasswordResetEndpoint = SessionMiddleware(validate)(forcePasswordResetEndpoint)
+		forcePasswordResetEndpoint = LoggingMiddleware(log.With(logger, "method", "ForcePasswordReset"))(forcePasswordResetEndpoint)
+	}
+
+	var assignRolesEndpoint endpoint.Endpoint
+	{
+		assignRolesEndpoint = MakeAssignRolesEndpoint(svc)
+		assignRolesEndpoint = authz.Require(authz.DefaultPolicy, authz.ManageUsers)(assignRolesEndpoint)
+		assignRolesEndpoint = SessionMiddleware(validate)(assignRolesEndpoint)
+		assignRolesEndpoint = LoggingMiddleware(log.With(logger, "method", "AssignRoles"))(assignRolesEndpoint)
+	}
+
+	var auditLogEndpoint endpoint.Endpoint
+	{
+		auditLogEndpoint = MakeAuditLogEndpoint(svc)
+		auditLogEndpoint = authz.Require(authz.DefaultPolicy, authz.ReadAuditLog)(auditLogEndpoint)
+		auditLogEndpoint = SessionMiddleware(validate)(auditLogEndpoint)
+		auditLogEndpoint = LoggingMiddleware(log.With(logger, "method", "AuditLog"))(auditLogEndpoint)
+	}
+
 	return Set{
-		UserIDEndpoint:   userIDEndpoint,
-		IsExistsEndpoint: isExistsEndpoint,
+		UserIDEndpoint:      userIDEndpoint,
+		IsExistsEndpoint:    isExistsEndpoint,
+		ProfileEndpoint:     profileEndpoint,
+		EnrollTOTPEndpoint:  enrollTOTPEndpoint,
+		ConfirmTOTPEndpoint: confirmTOTPEndpoint,
+		VerifyTOTPEndpoint:  verifyTOTPEndpoint,
+		DisableTOTPEndpoint: disableTOTPEndpoint,
+
+		ChangePasswordEndpoint: changePasswordEndpoint,
+		LinkIdentityEndpoint:   linkIdentityEndpoint,
+		PersonalDataEndpoint:   personalDataEndpoint,
+
+		ListUsersEndpoint:          listUsersEndpoint,
+		GetUserEndpoint:            getUserEndpoint,
+		SetDisabledEndpoint:        setDisabledEndpoint,
+		ForcePasswordResetEndpoint: forcePasswordResetEndpoint,
+		AssignRolesEndpoint:        assignRolesEndpoint,
+		AuditLogEndpoint:           auditLogEndpoint,
 	}
 }
 
@@ -50,6 +186,132 @@ func (s Set) IsExists(ctx context.Context, id uint64) (bool, error) {
 	return response.V, response.Err
 }
 
+func (s Set) Profile(ctx context.Context, id uint64) (usersvc.User, error) {
+	resp, err := s.ProfileEndpoint(ctx, ProfileRequest{ID: id})
+	if err != nil {
+		return usersvc.User{}, err
+	}
+	response := resp.(ProfileResponse)
+	return response.User, response.Err
+}
+
+func (s Set) EnrollTOTP(ctx context.Context, id uint64) (usersvc.TOTPKey, error) {
+	resp, err := s.EnrollTOTPEndpoint(ctx, EnrollTOTPRequest{ID: id})
+	if err != nil {
+		return usersvc.TOTPKey{}, err
+	}
+	response := resp.(EnrollTOTPResponse)
+	return response.Key, response.Err
+}
+
+func (s Set) ConfirmTOTP(ctx context.Context, id uint64, code string) ([]string, error) {
+	resp, err := s.ConfirmTOTPEndpoint(ctx, ConfirmTOTPRequest{ID: id, Code: code})
+	if err != nil {
+		return nil, err
+	}
+	response := resp.(ConfirmTOTPResponse)
+	return response.RecoveryCodes, response.Err
+}
+
+func (s Set) VerifyTOTP(ctx context.Context, id uint64, code string) (bool, error) {
+	resp, err := s.VerifyTOTPEndpoint(ctx, VerifyTOTPRequest{ID: id, Code: code})
+	if err != nil {
+		return false, err
+	}
+	response := resp.(VerifyTOTPResponse)
+	return response.V, response.Err
+}
+
+func (s Set) DisableTOTP(ctx context.Context, id uint64, code string) (bool, error) {
+	resp, err := s.DisableTOTPEndpoint(ctx, DisableTOTPRequest{ID: id, Code: code})
+	if err != nil {
+		return false, err
+	}
+	response := resp.(DisableTOTPResponse)
+	return response.V, response.Err
+}
+
+func (s Set) ChangePassword(ctx context.Context, username, oldPassword, newPassword string) (bool, error) {
+	resp, err := s.ChangePasswordEndpoint(ctx, ChangePasswordRequest{Name: username, OldPassword: oldPassword, NewPassword: newPassword})
+	if err != nil {
+		return false, err
+	}
+	response := resp.(ChangePasswordResponse)
+	return response.V, response.Err
+}
+
+func (s Set) LinkIdentity(ctx context.Context, issuer, subject, email string) (usersvc.User, error) {
+	resp, err := s.LinkIdentityEndpoint(ctx, LinkIdentityRequest{Issuer: issuer, Subject: subject, Email: email})
+	if err != nil {
+		return usersvc.User{}, err
+	}
+	response := resp.(LinkIdentityResponse)
+	return response.User, response.Err
+}
+
+func (s Set) PersonalData(ctx context.Context, id uint64) (usersvc.PersonalData, error) {
+	resp, err := s.PersonalDataEndpoint(ctx, PersonalDataRequest{ID: id})
+	if err != nil {
+		return usersvc.PersonalData{}, err
+	}
+	response := resp.(PersonalDataResponse)
+	return response.Data, response.Err
+}
+
+func (s Set) ListUsers(ctx context.Context, actorID uint64, query string, offset, limit int) ([]usersvc.User, int64, error) {
+	resp, err := s.ListUsersEndpoint(ctx, ListUsersRequest{Query: query, Offset: offset, Limit: limit})
+	if err != nil {
+		return nil, 0, err
+	}
+	response := resp.(ListUsersResponse)
+	return response.Users, response.Total, response.Err
+}
+
+func (s Set) GetUser(ctx context.Context, actorID, id uint64) (usersvc.User, error) {
+	resp, err := s.GetUserEndpoint(ctx, GetUserRequest{ID: id})
+	if err != nil {
+		return usersvc.User{}, err
+	}
+	response := resp.(GetUserResponse)
+	return response.User, response.Err
+}
+
+func (s Set) SetDisabled(ctx context.Context, actorID, id uint64, disabled bool) (bool, error) {
+	resp, err := s.SetDisabledEndpoint(ctx, SetDisabledRequest{ID: id, Disabled: disabled})
+	if err != nil {
+		return false, err
+	}
+	response := resp.(SetDisabledResponse)
+	return response.V, response.Err
+}
+
+func (s Set) ForcePasswordReset(ctx context.Context, actorID, id uint64) (bool, error) {
+	resp, err := s.ForcePasswordResetEndpoint(ctx, ForcePasswordResetRequest{ID: id})
+	if err != nil {
+		return false, err
+	}
+	response := resp.(ForcePasswordResetResponse)
+	return response.V, response.Err
+}
+
+func (s Set) AssignRoles(ctx context.Context, actorID, id uint64, roles []string) (bool, error) {
+	resp, err := s.AssignRolesEndpoint(ctx, AssignRolesRequest{ID: id, Roles: roles})
+	if err != nil {
+		return false, err
+	}
+	response := resp.(AssignRolesResponse)
+	return response.V, response.Err
+}
+
+func (s Set) AuditLog(ctx context.Context, actorID uint64, from, to time.Time) ([]usersvc.AuditEntry, error) {
+	resp, err := s.AuditLogEndpoint(ctx, AuditLogRequest{From: from, To: to})
+	if err != nil {
+		return nil, err
+	}
+	response := resp.(AuditLogResponse)
+	return response.Entries, response.Err
+}
+
 func MakeUserIDEndpoint(s userservice.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(UserIDRequest)
@@ -66,9 +328,180 @@ func MakeIsExistsEndpoint(s userservice.Service) endpoint.Endpoint {
 	}
 }
 
+func MakeProfileEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ProfileRequest)
+		u, err := s.Profile(ctx, req.ID)
+		return ProfileResponse{User: u, Err: err}, nil
+	}
+}
+
+func MakeEnrollTOTPEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(EnrollTOTPRequest)
+		k, err := s.EnrollTOTP(ctx, req.ID)
+		return EnrollTOTPResponse{Key: k, Err: err}, nil
+	}
+}
+
+func MakeConfirmTOTPEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ConfirmTOTPRequest)
+		codes, err := s.ConfirmTOTP(ctx, req.ID, req.Code)
+		return ConfirmTOTPResponse{RecoveryCodes: codes, Err: err}, nil
+	}
+}
+
+func MakeVerifyTOTPEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(VerifyTOTPRequest)
+		v, err := s.VerifyTOTP(ctx, req.ID, req.Code)
+		return VerifyTOTPResponse{V: v, Err: err}, nil
+	}
+}
+
+func MakeDisableTOTPEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(DisableTOTPRequest)
+		v, err := s.DisableTOTP(ctx, req.ID, req.Code)
+		return DisableTOTPResponse{V: v, Err: err}, nil
+	}
+}
+
+func MakeChangePasswordEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(ChangePasswordRequest)
+		v, err := s.ChangePassword(ctx, req.Name, req.OldPassword, req.NewPassword)
+		return ChangePasswordResponse{V: v, Err: err}, nil
+	}
+}
+
+func MakeLinkIdentityEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(LinkIdentityRequest)
+		u, err := s.LinkIdentity(ctx, req.Issuer, req.Subject, req.Email)
+		return LinkIdentityResponse{User: u, Err: err}, nil
+	}
+}
+
+func MakePersonalDataEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		req := request.(PersonalDataRequest)
+		d, err := s.PersonalData(ctx, req.ID)
+		return PersonalDataResponse{Data: d, Err: err}, nil
+	}
+}
+
+func MakeListUsersEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		actorID, err := actor(ctx)
+		if err != nil {
+			return ListUsersResponse{Err: err}, nil
+		}
+
+		req := request.(ListUsersRequest)
+		users, total, err := s.ListUsers(ctx, actorID, req.Query, req.Offset, req.Limit)
+		return ListUsersResponse{Users: users, Total: total, Err: err}, nil
+	}
+}
+
+func MakeGetUserEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		actorID, err := actor(ctx)
+		if err != nil {
+			return GetUserResponse{Err: err}, nil
+		}
+
+		req := request.(GetUserRequest)
+		u, err := s.GetUser(ctx, actorID, req.ID)
+		return GetUserResponse{User: u, Err: err}, nil
+	}
+}
+
+func MakeSetDisabledEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		actorID, err := actor(ctx)
+		if err != nil {
+			return SetDisabledResponse{Err: err}, nil
+		}
+
+		req := request.(SetDisabledRequest)
+		v, err := s.SetDisabled(ctx, actorID, req.ID, req.Disabled)
+		return SetDisabledResponse{V: v, Err: err}, nil
+	}
+}
+
+func MakeForcePasswordResetEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		actorID, err := actor(ctx)
+		if err != nil {
+			return ForcePasswordResetResponse{Err: err}, nil
+		}
+
+		req := request.(ForcePasswordResetRequest)
+		v, err := s.ForcePasswordReset(ctx, actorID, req.ID)
+		return ForcePasswordResetResponse{V: v, Err: err}, nil
+	}
+}
+
+func MakeAssignRolesEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		actorID, err := actor(ctx)
+		if err != nil {
+			return AssignRolesResponse{Err: err}, nil
+		}
+
+		req := request.(AssignRolesRequest)
+		v, err := s.AssignRoles(ctx, actorID, req.ID, req.Roles)
+		return AssignRolesResponse{V: v, Err: err}, nil
+	}
+}
+
+func MakeAuditLogEndpoint(s userservice.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
+		actorID, err := actor(ctx)
+		if err != nil {
+			return AuditLogResponse{Err: err}, nil
+		}
+
+		req := request.(AuditLogRequest)
+		entries, err := s.AuditLog(ctx, actorID, req.From, req.To)
+		return AuditLogResponse{Entries: entries, Err: err}, nil
+	}
+}
+
+// actor returns the ID of the administrator from the access token claims.
+func actor(ctx context.Context) (uint64, error) {
+	claims, ok := ctx.Value(kitjwt.JWTClaimsContextKey).(stdjwt.MapClaims)
+	if !ok {
+		return 0, usersvc.ErrClaimsMissing
+	}
+
+	userID, err := strconv.ParseUint(fmt.Sprintf("%.f", claims["user_id"]), 10, 64)
+	if err != nil {
+		return 0, usersvc.ErrClaimsMissing
+	}
+
+	return userID, nil
+}
+
 var (
 	_ endpoint.Failer = UserIDResponse{}
 	_ endpoint.Failer = IsExistsResponse{}
+	_ endpoint.Failer = ProfileResponse{}
+	_ endpoint.Failer = EnrollTOTPResponse{}
+	_ endpoint.Failer = ConfirmTOTPResponse{}
+	_ endpoint.Failer = VerifyTOTPResponse{}
+	_ endpoint.Failer = DisableTOTPResponse{}
+	_ endpoint.Failer = ChangePasswordResponse{}
+	_ endpoint.Failer = LinkIdentityResponse{}
+	_ endpoint.Failer = PersonalDataResponse{}
+	_ endpoint.Failer = ListUsersResponse{}
+	_ endpoint.Failer = GetUserResponse{}
+	_ endpoint.Failer = SetDisabledResponse{}
+	_ endpoint.Failer = ForcePasswordResetResponse{}
+	_ endpoint.Failer = AssignRolesResponse{}
+	_ endpoint.Failer = AuditLogResponse{}
 )
 
 type UserIDRequest struct {
@@ -92,3 +525,170 @@ type IsExistsResponse struct {
 }
 
 func (r IsExistsResponse) Failed() error { return r.Err }
+
+type ProfileRequest struct {
+	ID uint64
+}
+
+type ProfileResponse struct {
+	User usersvc.User `json:"user"`
+	Err  error        `json:"-"`
+}
+
+func (r ProfileResponse) Failed() error { return r.Err }
+
+type EnrollTOTPRequest struct {
+	ID uint64
+}
+
+type EnrollTOTPResponse struct {
+	Key usersvc.TOTPKey `json:"key"`
+	Err error           `json:"-"`
+}
+
+func (r EnrollTOTPResponse) Failed() error { return r.Err }
+
+type ConfirmTOTPRequest struct {
+	ID   uint64
+	Code string
+}
+
+type ConfirmTOTPResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	Err           error    `json:"-"`
+}
+
+func (r ConfirmTOTPResponse) Failed() error { return r.Err }
+
+type VerifyTOTPRequest struct {
+	ID   uint64
+	Code string
+}
+
+type VerifyTOTPResponse struct {
+	V   bool  `json:"v"`
+	Err error `json:"-"`
+}
+
+func (r VerifyTOTPResponse) Failed() error { return r.Err }
+
+type DisableTOTPRequest struct {
+	ID   uint64
+	Code string
+}
+
+type DisableTOTPResponse struct {
+	V   bool  `json:"v"`
+	Err error `json:"-"`
+}
+
+func (r DisableTOTPResponse) Failed() error { return r.Err }
+
+type ChangePasswordRequest struct {
+	Name        string
+	OldPassword string
+	NewPassword string
+}
+
+type ChangePasswordResponse struct {
+	V   bool  `json:"v"`
+	Err error `json:"-"`
+}
+
+func (r ChangePasswordResponse) Failed() error { return r.Err }
+
+type LinkIdentityRequest struct {
+	Issuer  string
+	Subject string
+	Email   string
+}
+
+type LinkIdentityResponse struct {
+	User usersvc.User `json:"user"`
+	Err  error        `json:"-"`
+}
+
+func (r LinkIdentityResponse) Failed() error { return r.Err }
+
+type PersonalDataRequest struct {
+	ID uint64
+}
+
+type PersonalDataResponse struct {
+	Data usersvc.PersonalData `json:"data"`
+	Err  error                `json:"-"`
+}
+
+func (r PersonalDataResponse) Failed() error { return r.Err }
+
+type ListUsersRequest struct {
+	Query  string
+	Offset int
+	Limit  int
+}
+
+type ListUsersResponse struct {
+	Users []usersvc.User `json:"users"`
+	Total int64          `json:"total"`
+	Err   error          `json:"-"`
+}
+
+func (r ListUsersResponse) Failed() error { return r.Err }
+
+type GetUserRequest struct {
+	ID uint64
+}
+
+type GetUserResponse struct {
+	User usersvc.User `json:"user"`
+	Err  error        `json:"-"`
+}
+
+func (r GetUserResponse) Failed() error { return r.Err }
+
+type SetDisabledRequest struct {
+	ID       uint64
+	Disabled bool
+}
+
+type SetDisabledResponse struct {
+	V   bool  `json:"v"`
+	Err error `json:"-"`
+}
+
+func (r SetDisabledResponse) Failed() error { return r.Err }
+
+type ForcePasswordResetRequest struct {
+	ID uint64
+}
+
+type ForcePasswordResetResponse struct {
+	V   bool  `json:"v"`
+	Err error `json:"-"`
+}
+
+func (r ForcePasswordResetResponse) Failed() error { return r.Err }
+
+type AssignRolesRequest struct {
+	ID    uint64
+	Roles []string `json:"roles"`
+}
+
+type AssignRolesResponse struct {
+	V   bool  `json:"v"`
+	Err error `json:"-"`
+}
+
+func (r AssignRolesResponse) Failed() error { return r.Err }
+
+type AuditLogRequest struct {
+	From time.Time
+	To   time.Time
+}
+
+type AuditLogResponse struct {
+	Entries []usersvc.AuditEntry `json:"entries"`
+	Err     error                `json:"-"`
+}
+
+func (r AuditLogResponse) Failed() error { return r.Err }
@@ -0,0 +1,111 @@
+package userendpoint_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/authsvc/authz"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+	"github.com/ichigozero/gtdkit/backend/usersvc/db/gorm"
+	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/userendpoint"
+	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/userservice"
+	"gorm.io/driver/sqlite"
+	libgorm "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newSet returns the endpoints of a service holding an admin with ID 1 and
+// a user with ID 2. Every session is valid but "revoked".
+func newSet(t *testing.T) userendpoint.Set {
+	t.Helper()
+
+	db, err := libgorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gorm.db")), &libgorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&usersvc.User{}, &usersvc.AuditEntry{}, &usersvc.Identity{}); err != nil {
+		t.Fatal(err)
+	}
+
+	users := gorm.NewUserRepository(db)
+	for _, u := range []*usersvc.User{
+		{Name: "admin", Roles: []string{usersvc.RoleAdmin}},
+		{Name: "ann", Roles: []string{usersvc.RoleUser}},
+	} {
+		if err := users.Create(u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	svc := userservice.NewBasicService(users, gorm.NewAuditRepository(db), gorm.NewIdentityRepository(db))
+	validate := func(_ context.Context, accessUUID string) (bool, error) {
+		return accessUUID != "revoked", nil
+	}
+	return userendpoint.New(svc, validate, log.NewNopLogger())
+}
+
+// claimsContext returns the context the JWT parser hands to the endpoints,
+// whose claims are decoded from JSON.
+func claimsContext(accessUUID string, userID uint64, roles ...string) context.Context {
+	r := make([]interface{}, len(roles))
+	for i, role := range roles {
+		r[i] = role
+	}
+	claims := stdjwt.MapClaims{"uuid": accessUUID, "user_id": float64(userID), "roles": r}
+	return context.WithValue(context.Background(), kitjwt.JWTClaimsContextKey, claims)
+}
+
+// call returns the error of the endpoint, be it returned by a middleware or
+// by the service.
+func call(e endpoint.Endpoint, ctx context.Context, request interface{}) error {
+	resp, err := e(ctx, request)
+	if err != nil {
+		return err
+	}
+	return resp.(endpoint.Failer).Failed()
+}
+
+// TestAdminEndpointsRequirePermission checks that every administrator
+// endpoint is refused to users whose roles do not grant its permission.
+func TestAdminEndpointsRequirePermission(t *testing.T) {
+	t.Parallel()
+
+	set := newSet(t)
+
+	for _, tc := range []struct {
+		name     string
+		perm     authz.Permission
+		endpoint endpoint.Endpoint
+		request  interface{}
+	}{
+		{"ListUsers", authz.ManageUsers, set.ListUsersEndpoint, userendpoint.ListUsersRequest{}},
+		{"GetUser", authz.ManageUsers, set.GetUserEndpoint, userendpoint.GetUserRequest{ID: 2}},
+		{"SetDisabled", authz.ManageUsers, set.SetDisabledEndpoint, userendpoint.SetDisabledRequest{ID: 2}},
+		{"ForcePasswordReset", authz.ManageUsers, set.ForcePasswordResetEndpoint, userendpoint.ForcePasswordResetRequest{ID: 2}},
+		{"AssignRoles", authz.ManageUsers, set.AssignRolesEndpoint, userendpoint.AssignRolesRequest{ID: 2, Roles: []string{usersvc.RoleUser}}},
+		{"AuditLog", authz.ReadAuditLog, set.AuditLogEndpoint, userendpoint.AuditLogRequest{From: time.Now().Add(-time.Hour), To: time.Now()}},
+	} {
+		if authz.DefaultPolicy.Allows([]string{usersvc.RoleUser}, tc.perm) || !authz.DefaultPolicy.Allows([]string{usersvc.RoleAdmin}, tc.perm) {
+			t.Fatalf("%s: want %s granted to admins only", tc.name, tc.perm)
+		}
+
+		for _, roles := range [][]string{nil, {usersvc.RoleUser}, {usersvc.RoleService}} {
+			if err := call(tc.endpoint, claimsContext("session", 2, roles...), tc.request); err != authz.ErrForbidden {
+				t.Errorf("%s as %v: want %v, got %v", tc.name, roles, authz.ErrForbidden, err)
+			}
+		}
+
+		if err := call(tc.endpoint, claimsContext("revoked", 1, usersvc.RoleAdmin), tc.request); err != usersvc.ErrSessionInvalid {
+			t.Errorf("%s with a revoked session: want %v, got %v", tc.name, usersvc.ErrSessionInvalid, err)
+		}
+		if err := call(tc.endpoint, claimsContext("session", 1, usersvc.RoleAdmin), tc.request); err != nil {
+			t.Errorf("%s as admin: want it allowed, got %v", tc.name, err)
+		}
+	}
+}
@@ -2,10 +2,12 @@ package userservice
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
 )
 
 type Middleware func(Service) Service
@@ -35,6 +37,104 @@ func (mw loggingMiddleware) IsExists(ctx context.Context, id uint64) (v bool, er
 	return mw.next.IsExists(ctx, id)
 }
 
+func (mw loggingMiddleware) Profile(ctx context.Context, id uint64) (u usersvc.User, err error) {
+	defer func() {
+		mw.logger.Log("method", "Profile", "id", id, "err", err)
+	}()
+	return mw.next.Profile(ctx, id)
+}
+
+func (mw loggingMiddleware) EnrollTOTP(ctx context.Context, id uint64) (k usersvc.TOTPKey, err error) {
+	defer func() {
+		mw.logger.Log("method", "EnrollTOTP", "id", id, "err", err)
+	}()
+	return mw.next.EnrollTOTP(ctx, id)
+}
+
+func (mw loggingMiddleware) ConfirmTOTP(ctx context.Context, id uint64, code string) (codes []string, err error) {
+	defer func() {
+		mw.logger.Log("method", "ConfirmTOTP", "id", id, "err", err)
+	}()
+	return mw.next.ConfirmTOTP(ctx, id, code)
+}
+
+func (mw loggingMiddleware) VerifyTOTP(ctx context.Context, id uint64, code string) (v bool, err error) {
+	defer func() {
+		mw.logger.Log("method", "VerifyTOTP", "id", id, "v", v, "err", err)
+	}()
+	return mw.next.VerifyTOTP(ctx, id, code)
+}
+
+func (mw loggingMiddleware) DisableTOTP(ctx context.Context, id uint64, code string) (v bool, err error) {
+	defer func() {
+		mw.logger.Log("method", "DisableTOTP", "id", id, "v", v, "err", err)
+	}()
+	return mw.next.DisableTOTP(ctx, id, code)
+}
+
+func (mw loggingMiddleware) ChangePassword(ctx context.Context, username, oldPassword, newPassword string) (v bool, err error) {
+	defer func() {
+		mw.logger.Log("method", "ChangePassword", "username", username, "v", v, "err", err)
+	}()
+	return mw.next.ChangePassword(ctx, username, oldPassword, newPassword)
+}
+
+func (mw loggingMiddleware) LinkIdentity(ctx context.Context, issuer, subject, email string) (u usersvc.User, err error) {
+	defer func() {
+		mw.logger.Log("method", "LinkIdentity", "issuer", issuer, "subject", subject, "id", u.ID, "err", err)
+	}()
+	return mw.next.LinkIdentity(ctx, issuer, subject, email)
+}
+
+func (mw loggingMiddleware) PersonalData(ctx context.Context, id uint64) (d usersvc.PersonalData, err error) {
+	defer func() {
+		mw.logger.Log("method", "PersonalData", "id", id, "identities", len(d.Identities), "audit_entries", len(d.AuditEntries), "err", err)
+	}()
+	return mw.next.PersonalData(ctx, id)
+}
+
+func (mw loggingMiddleware) ListUsers(ctx context.Context, actorID uint64, query string, offset, limit int) (users []usersvc.User, total int64, err error) {
+	defer func() {
+		mw.logger.Log("method", "ListUsers", "actor_id", actorID, "query", query, "offset", offset, "limit", limit, "total", total, "err", err)
+	}()
+	return mw.next.ListUsers(ctx, actorID, query, offset, limit)
+}
+
+func (mw loggingMiddleware) GetUser(ctx context.Context, actorID, id uint64) (u usersvc.User, err error) {
+	defer func() {
+		mw.logger.Log("method", "GetUser", "actor_id", actorID, "id", id, "err", err)
+	}()
+	return mw.next.GetUser(ctx, actorID, id)
+}
+
+func (mw loggingMiddleware) SetDisabled(ctx context.Context, actorID, id uint64, disabled bool) (v bool, err error) {
+	defer func() {
+		mw.logger.Log("method", "SetDisabled", "actor_id", actorID, "id", id, "disabled", disabled, "v", v, "err", err)
+	}()
+	return mw.next.SetDisabled(ctx, actorID, id, disabled)
+}
+
+func (mw loggingMiddleware) ForcePasswordReset(ctx context.Context, actorID, id uint64) (v bool, err error) {
+	defer func() {
+		mw.logger.Log("method", "ForcePasswordReset", "actor_id", actorID, "id", id, "v", v, "err", err)
+	}()
+	return mw.next.ForcePasswordReset(ctx, actorID, id)
+}
+
+func (mw loggingMiddleware) AssignRoles(ctx context.Context, actorID, id uint64, roles []string) (v bool, err error) {
+	defer func() {
+		mw.logger.Log("method", "AssignRoles", "actor_id", actorID, "id", id, "roles", strings.Join(roles, ","), "v", v, "err", err)
+	}()
+	return mw.next.AssignRoles(ctx, actorID, id, roles)
+}
+
+func (mw loggingMiddleware) AuditLog(ctx context.Context, actorID uint64, from, to time.Time) (entries []usersvc.AuditEntry, err error) {
+	defer func() {
+		mw.logger.Log("method", "AuditLog", "actor_id", actorID, "from", from, "to", to, "entries", len(entries), "err", err)
+	}()
+	return mw.next.AuditLog(ctx, actorID, from, to)
+}
+
 func InstrumentingMiddleware(counter metrics.Counter, latency metrics.Histogram, s Service) Middleware {
 	return func(next Service) Service {
 		return instrumentingMiddleware{counter, latency, next}
@@ -64,3 +164,229 @@ func (mw instrumentingMiddleware) IsExists(ctx context.Context, id uint64) (v bo
 
 	return mw.next.IsExists(ctx, id)
 }
+
+func (mw instrumentingMiddleware) Profile(ctx context.Context, id uint64) (u usersvc.User, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "profile").Add(1)
+		mw.requestLatency.With("method", "profile").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.Profile(ctx, id)
+}
+
+func (mw instrumentingMiddleware) EnrollTOTP(ctx context.Context, id uint64) (k usersvc.TOTPKey, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "enroll_totp").Add(1)
+		mw.requestLatency.With("method", "enroll_totp").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.EnrollTOTP(ctx, id)
+}
+
+func (mw instrumentingMiddleware) ConfirmTOTP(ctx context.Context, id uint64, code string) (codes []string, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "confirm_totp").Add(1)
+		mw.requestLatency.With("method", "confirm_totp").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.ConfirmTOTP(ctx, id, code)
+}
+
+func (mw instrumentingMiddleware) VerifyTOTP(ctx context.Context, id uint64, code string) (v bool, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "verify_totp").Add(1)
+		mw.requestLatency.With("method", "verify_totp").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.VerifyTOTP(ctx, id, code)
+}
+
+func (mw instrumentingMiddleware) DisableTOTP(ctx context.Context, id uint64, code string) (v bool, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "disable_totp").Add(1)
+		mw.requestLatency.With("method", "disable_totp").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.DisableTOTP(ctx, id, code)
+}
+
+func (mw instrumentingMiddleware) ChangePassword(ctx context.Context, username, oldPassword, newPassword string) (v bool, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "change_password").Add(1)
+		mw.requestLatency.With("method", "change_password").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.ChangePassword(ctx, username, oldPassword, newPassword)
+}
+
+func (mw instrumentingMiddleware) LinkIdentity(ctx context.Context, issuer, subject, email string) (u usersvc.User, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "link_identity").Add(1)
+		mw.requestLatency.With("method", "link_identity").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.LinkIdentity(ctx, issuer, subject, email)
+}
+
+func (mw instrumentingMiddleware) PersonalData(ctx context.Context, id uint64) (d usersvc.PersonalData, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "personal_data").Add(1)
+		mw.requestLatency.With("method", "personal_data").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.PersonalData(ctx, id)
+}
+
+func (mw instrumentingMiddleware) ListUsers(ctx context.Context, actorID uint64, query string, offset, limit int) (users []usersvc.User, total int64, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "list_users").Add(1)
+		mw.requestLatency.With("method", "list_users").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.ListUsers(ctx, actorID, query, offset, limit)
+}
+
+func (mw instrumentingMiddleware) GetUser(ctx context.Context, actorID, id uint64) (u usersvc.User, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "get_user").Add(1)
+		mw.requestLatency.With("method", "get_user").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.GetUser(ctx, actorID, id)
+}
+
+func (mw instrumentingMiddleware) SetDisabled(ctx context.Context, actorID, id uint64, disabled bool) (v bool, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "set_disabled").Add(1)
+		mw.requestLatency.With("method", "set_disabled").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.SetDisabled(ctx, actorID, id, disabled)
+}
+
+func (mw instrumentingMiddleware) ForcePasswordReset(ctx context.Context, actorID, id uint64) (v bool, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "force_password_reset").Add(1)
+		mw.requestLatency.With("method", "force_password_reset").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.ForcePasswordReset(ctx, actorID, id)
+}
+
+func (mw instrumentingMiddleware) AssignRoles(ctx context.Context, actorID, id uint64, roles []string) (v bool, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "assign_roles").Add(1)
+		mw.requestLatency.With("method", "assign_roles").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.AssignRoles(ctx, actorID, id, roles)
+}
+
+func (mw instrumentingMiddleware) AuditLog(ctx context.Context, actorID uint64, from, to time.Time) (entries []usersvc.AuditEntry, err error) {
+	defer func(begin time.Time) {
+		mw.requestCount.With("method", "audit_log").Add(1)
+		mw.requestLatency.With("method", "audit_log").Observe(time.Since(begin).Seconds())
+	}(time.Now())
+
+	return mw.next.AuditLog(ctx, actorID, from, to)
+}
+
+// SessionRevoker revokes every session of the user. It is implemented by
+// authsvc, which cannot be imported here as it depends on this package.
+type SessionRevoker func(ctx context.Context, userID uint64) error
+
+// ProxingMiddleware revokes the sessions of the users which are disabled or
+// whose roles are changed, so that their access tokens cannot be used with
+// the former roles until they expire. The sessions are revoked after the
+// change is committed; should that fail the error is returned so that the
+// administrator can retry.
+func ProxingMiddleware(revoke SessionRevoker) Middleware {
+	return func(next Service) Service {
+		return proxingMiddleware{next, revoke}
+	}
+}
+
+type proxingMiddleware struct {
+	next   Service
+	revoke SessionRevoker
+}
+
+func (mw proxingMiddleware) UserID(ctx context.Context, username, password string) (uint64, error) {
+	return mw.next.UserID(ctx, username, password)
+}
+
+func (mw proxingMiddleware) IsExists(ctx context.Context, id uint64) (bool, error) {
+	return mw.next.IsExists(ctx, id)
+}
+
+func (mw proxingMiddleware) Profile(ctx context.Context, id uint64) (usersvc.User, error) {
+	return mw.next.Profile(ctx, id)
+}
+
+func (mw proxingMiddleware) EnrollTOTP(ctx context.Context, id uint64) (usersvc.TOTPKey, error) {
+	return mw.next.EnrollTOTP(ctx, id)
+}
+
+func (mw proxingMiddleware) ConfirmTOTP(ctx context.Context, id uint64, code string) ([]string, error) {
+	return mw.next.ConfirmTOTP(ctx, id, code)
+}
+
+func (mw proxingMiddleware) VerifyTOTP(ctx context.Context, id uint64, code string) (bool, error) {
+	return mw.next.VerifyTOTP(ctx, id, code)
+}
+
+func (mw proxingMiddleware) DisableTOTP(ctx context.Context, id uint64, code string) (bool, error) {
+	return mw.next.DisableTOTP(ctx, id, code)
+}
+
+func (mw proxingMiddleware) ChangePassword(ctx context.Context, username, oldPassword, newPassword string) (bool, error) {
+	return mw.next.ChangePassword(ctx, username, oldPassword, newPassword)
+}
+
+func (mw proxingMiddleware) LinkIdentity(ctx context.Context, issuer, subject, email string) (usersvc.User, error) {
+	return mw.next.LinkIdentity(ctx, issuer, subject, email)
+}
+
+func (mw proxingMiddleware) PersonalData(ctx context.Context, id uint64) (usersvc.PersonalData, error) {
+	return mw.next.PersonalData(ctx, id)
+}
+
+func (mw proxingMiddleware) ListUsers(ctx context.Context, actorID uint64, query string, offset, limit int) ([]usersvc.User, int64, error) {
+	return mw.next.ListUsers(ctx, actorID, query, offset, limit)
+}
+
+func (mw proxingMiddleware) GetUser(ctx context.Context, actorID, id uint64) (usersvc.User, error) {
+	return mw.next.GetUser(ctx, actorID, id)
+}
+
+func (mw proxingMiddleware) SetDisabled(ctx context.Context, actorID, id uint64, disabled bool) (bool, error) {
+	v, err := mw.next.SetDisabled(ctx, actorID, id, disabled)
+	if err != nil || !disabled {
+		return v, err
+	}
+
+	if err := mw.revoke(ctx, id); err != nil {
+		return false, err
+	}
+	return v, nil
+}
+
+func (mw proxingMiddleware) ForcePasswordReset(ctx context.Context, actorID, id uint64) (bool, error) {
+	return mw.next.ForcePasswordReset(ctx, actorID, id)
+}
+
+func (mw proxingMiddleware) AssignRoles(ctx context.Context, actorID, id uint64, roles []string) (bool, error) {
+	v, err := mw.next.AssignRoles(ctx, actorID, id, roles)
+	if err != nil {
+		return v, err
+	}
+
+	if err := mw.revoke(ctx, id); err != nil {
+		return false, err
+	}
+	return v, nil
+}
+
+func (mw proxingMiddleware) AuditLog(ctx context.Context, actorID uint64, from, to time.Time) ([]usersvc.AuditEntry, error) {
+	return mw.next.AuditLog(ctx, actorID, from, to)
+}
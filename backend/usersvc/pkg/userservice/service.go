@@ -2,32 +2,77 @@ package userservice
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/ichigozero/gtdkit/backend/usersvc"
+	"github.com/ichigozero/gtdkit/backend/usersvc/totp"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type Service interface {
 	UserID(ctx context.Context, username, password string) (uint64, error)
 	IsExists(ctx context.Context, id uint64) (bool, error)
+	Profile(ctx context.Context, id uint64) (usersvc.User, error)
+	EnrollTOTP(ctx context.Context, id uint64) (usersvc.TOTPKey, error)
+	ConfirmTOTP(ctx context.Context, id uint64, code string) ([]string, error)
+	VerifyTOTP(ctx context.Context, id uint64, code string) (bool, error)
+	DisableTOTP(ctx context.Context, id uint64, code string) (bool, error)
+	ChangePassword(ctx context.Context, username, oldPassword, newPassword string) (bool, error)
+	// LinkIdentity returns the user linked to the account of an external
+	// identity provider. An unlinked account is linked to the user with the
+	// same email, or a new user is provisioned for it. The email must have
+	// been verified by the identity provider.
+	LinkIdentity(ctx context.Context, issuer, subject, email string) (usersvc.User, error)
+	// PersonalData returns what is stored about the user, for it to be
+	// exported.
+	PersonalData(ctx context.Context, id uint64) (usersvc.PersonalData, error)
+
+	// The following are the administrator operations, actorID is the ID of
+	// the administrator which is recorded in the audit log.
+	ListUsers(ctx context.Context, actorID uint64, query string, offset, limit int) ([]usersvc.User, int64, error)
+	GetUser(ctx context.Context, actorID, id uint64) (usersvc.User, error)
+	SetDisabled(ctx context.Context, actorID, id uint64, disabled bool) (bool, error)
+	ForcePasswordReset(ctx context.Context, actorID, id uint64) (bool, error)
+	AssignRoles(ctx context.Context, actorID, id uint64, roles []string) (bool, error)
+	AuditLog(ctx context.Context, actorID uint64, from, to time.Time) ([]usersvc.AuditEntry, error)
 }
 
-func New(u usersvc.UserRepository, logger log.Logger) Service {
+const (
+	totpIssuer = "gtdkit"
+	// totpSkew is the number of time steps a code may be early or late.
+	totpSkew          = 1
+	recoveryCodeCount = 10
+
+	minPasswordLength = 8
+
+	// maxProvisionAttempts bounds the search of a free name for a
+	// provisioned user.
+	maxProvisionAttempts = 100
+
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+func New(u usersvc.UserRepository, a usersvc.AuditRepository, i usersvc.IdentityRepository, logger log.Logger) Service {
 	var svc Service
 	{
-		svc = NewBasicService(u)
+		svc = NewBasicService(u, a, i)
 		svc = LoggingMiddleware(logger)(svc)
 	}
 	return svc
 }
 
 type basicService struct {
-	users usersvc.UserRepository
+	users      usersvc.UserRepository
+	audit      usersvc.AuditRepository
+	identities usersvc.IdentityRepository
 }
 
-func NewBasicService(u usersvc.UserRepository) Service {
-	return basicService{users: u}
+func NewBasicService(u usersvc.UserRepository, a usersvc.AuditRepository, i usersvc.IdentityRepository) Service {
+	return basicService{users: u, audit: a, identities: i}
 }
 
 func (s basicService) UserID(_ context.Context, username, password string) (uint64, error) {
@@ -45,6 +90,23 @@ func (s basicService) UserID(_ context.Context, username, password string) (uint
 		return 0, usersvc.ErrUserNotFound
 	}
 
+	if u.Disabled {
+		return 0, usersvc.ErrUserDisabled
+	}
+	if u.PasswordResetRequired {
+		return 0, usersvc.ErrPasswordResetRequired
+	}
+
+	// The login of the users who enabled TOTP is only complete once their
+	// code is verified.
+	if !u.TOTPEnabled {
+		now := time.Now().UTC()
+		u.LastLoginAt = &now
+		if err := s.users.Update(u); err != nil {
+			return 0, err
+		}
+	}
+
 	return u.ID, nil
 }
 
@@ -55,3 +117,417 @@ func (s basicService) IsExists(_ context.Context, id uint64) (bool, error) {
 
 	return s.users.IsExists(id)
 }
+
+func (s basicService) Profile(_ context.Context, id uint64) (usersvc.User, error) {
+	if id == 0 {
+		return usersvc.User{}, usersvc.ErrInvalidArgument
+	}
+
+	u := s.users.GetUserByID(id)
+	if u.ID == 0 {
+		return usersvc.User{}, usersvc.ErrUserNotFound
+	}
+
+	return profile(u), nil
+}
+
+// EnrollTOTP generates a new secret which replaces any pending enrollment.
+// TOTP is only enabled after a code generated from it is confirmed.
+func (s basicService) EnrollTOTP(_ context.Context, id uint64) (usersvc.TOTPKey, error) {
+	u, err := s.user(id)
+	if err != nil {
+		return usersvc.TOTPKey{}, err
+	}
+	if u.TOTPEnabled {
+		return usersvc.TOTPKey{}, usersvc.ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return usersvc.TOTPKey{}, err
+	}
+
+	u.TOTPSecret = secret
+	u.TOTPCounter = 0
+	if err := s.users.Update(u); err != nil {
+		return usersvc.TOTPKey{}, err
+	}
+
+	return usersvc.TOTPKey{Secret: secret, URI: totp.URI(totpIssuer, u.Name, secret)}, nil
+}
+
+// ConfirmTOTP enables TOTP and returns the recovery codes, which are not
+// retrievable afterwards.
+func (s basicService) ConfirmTOTP(_ context.Context, id uint64, code string) ([]string, error) {
+	u, err := s.user(id)
+	if err != nil {
+		return nil, err
+	}
+	if u.TOTPEnabled {
+		return nil, usersvc.ErrTOTPAlreadyEnabled
+	}
+	if u.TOTPSecret == "" {
+		return nil, usersvc.ErrTOTPNotEnrolled
+	}
+
+	counter, ok := totp.Validate(code, u.TOTPSecret, time.Now(), totpSkew)
+	if !ok {
+		return nil, usersvc.ErrInvalidTOTPCode
+	}
+
+	codes, hashes, err := totp.RecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	u.TOTPEnabled = true
+	u.TOTPCounter = counter
+	u.RecoveryCodes = strings.Join(hashes, "\n")
+	if err := s.users.Update(u); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// VerifyTOTP accepts either a code of the authenticator app or one of the
+// recovery codes, which is then used up.
+func (s basicService) VerifyTOTP(_ context.Context, id uint64, code string) (bool, error) {
+	u, err := s.user(id)
+	if err != nil {
+		return false, err
+	}
+	if !u.TOTPEnabled {
+		return false, usersvc.ErrTOTPNotEnrolled
+	}
+
+	// The code completes the login, the time is saved by verify along with
+	// the used code, and only if the code is valid.
+	now := time.Now().UTC()
+	u.LastLoginAt = &now
+	return s.verify(u, code)
+}
+
+func (s basicService) DisableTOTP(_ context.Context, id uint64, code string) (bool, error) {
+	u, err := s.user(id)
+	if err != nil {
+		return false, err
+	}
+	if !u.TOTPEnabled {
+		return false, usersvc.ErrTOTPNotEnrolled
+	}
+
+	ok, err := s.verify(u, code)
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		return false, usersvc.ErrInvalidTOTPCode
+	}
+
+	u.TOTPEnabled = false
+	u.TOTPSecret = ""
+	u.TOTPCounter = 0
+	u.RecoveryCodes = ""
+	if err := s.users.Update(u); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ChangePassword is also the only way to log in again after a password
+// reset was forced.
+func (s basicService) ChangePassword(_ context.Context, username, oldPassword, newPassword string) (bool, error) {
+	if username == "" || oldPassword == "" || len(newPassword) < minPasswordLength {
+		return false, usersvc.ErrInvalidArgument
+	}
+
+	u := s.users.GetUser(username)
+	if u.ID == 0 {
+		return false, usersvc.ErrUserNotFound
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(oldPassword))
+	if err != nil {
+		return false, usersvc.ErrUserNotFound
+	}
+	if u.Disabled {
+		return false, usersvc.ErrUserDisabled
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return false, err
+	}
+
+	u.Password = string(hash)
+	u.PasswordResetRequired = false
+	if err := s.users.Update(u); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s basicService) LinkIdentity(_ context.Context, issuer, subject, email string) (usersvc.User, error) {
+	if issuer == "" || subject == "" || email == "" {
+		return usersvc.User{}, usersvc.ErrInvalidArgument
+	}
+
+	if i := s.identities.GetIdentity(issuer, subject); i.ID != 0 {
+		u, err := s.user(i.UserID)
+		if err != nil {
+			return usersvc.User{}, err
+		}
+		return profile(u), nil
+	}
+
+	u := s.users.GetUserByEmail(email)
+	action := "link_identity"
+	if u.ID == 0 {
+		var err error
+		if u, err = s.provision(email); err != nil {
+			return usersvc.User{}, err
+		}
+		action = "provision_user"
+	}
+
+	err := s.identities.Create(&usersvc.Identity{
+		UserID:    u.ID,
+		Issuer:    issuer,
+		Subject:   subject,
+		Email:     email,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return usersvc.User{}, err
+	}
+
+	// The user acts on its own behalf, there is no administrator involved.
+	return profile(u), s.record(u.ID, action, u.ID, fmt.Sprintf("issuer=%q subject=%q", issuer, subject))
+}
+
+func (s basicService) PersonalData(_ context.Context, id uint64) (usersvc.PersonalData, error) {
+	u, err := s.user(id)
+	if err != nil {
+		return usersvc.PersonalData{}, err
+	}
+
+	identities, err := s.identities.FindByUser(id)
+	if err != nil {
+		return usersvc.PersonalData{}, err
+	}
+	entries, err := s.audit.FindByUser(id)
+	if err != nil {
+		return usersvc.PersonalData{}, err
+	}
+
+	// Only the number of recovery codes left is told, their hashes are
+	// credentials.
+	left := 0
+	for _, hash := range strings.Split(u.RecoveryCodes, "\n") {
+		if hash != "" {
+			left++
+		}
+	}
+
+	return usersvc.PersonalData{
+		Profile:           profile(u),
+		RecoveryCodesLeft: left,
+		Identities:        identities,
+		AuditEntries:      entries,
+	}, nil
+}
+
+func (s basicService) ListUsers(_ context.Context, actorID uint64, query string, offset, limit int) ([]usersvc.User, int64, error) {
+	if offset < 0 || limit < 0 {
+		return nil, 0, usersvc.ErrInvalidArgument
+	}
+	if limit == 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	users, total, err := s.users.List(query, offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	profiles := make([]usersvc.User, 0, len(users))
+	for i := range users {
+		profiles = append(profiles, profile(&users[i]))
+	}
+
+	return profiles, total, s.record(actorID, "list_users", 0, fmt.Sprintf("query=%q offset=%d limit=%d", query, offset, limit))
+}
+
+func (s basicService) GetUser(_ context.Context, actorID, id uint64) (usersvc.User, error) {
+	u, err := s.user(id)
+	if err != nil {
+		return usersvc.User{}, err
+	}
+
+	return profile(u), s.record(actorID, "get_user", id, "")
+}
+
+func (s basicService) SetDisabled(_ context.Context, actorID, id uint64, disabled bool) (bool, error) {
+	if actorID == id {
+		return false, usersvc.ErrCannotModifySelf
+	}
+
+	u, err := s.user(id)
+	if err != nil {
+		return false, err
+	}
+
+	action := "enable_user"
+	if disabled {
+		action = "disable_user"
+	}
+
+	u.Disabled = disabled
+	if err := s.users.UpdateAudited(u, entry(actorID, action, id, "")); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s basicService) ForcePasswordReset(_ context.Context, actorID, id uint64) (bool, error) {
+	u, err := s.user(id)
+	if err != nil {
+		return false, err
+	}
+
+	u.PasswordResetRequired = true
+	if err := s.users.UpdateAudited(u, entry(actorID, "force_password_reset", id, "")); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// AssignRoles replaces the roles of the user. Administrators cannot remove
+// their own admin role, so that at least one administrator remains.
+func (s basicService) AssignRoles(_ context.Context, actorID, id uint64, roles []string) (bool, error) {
+	if len(roles) == 0 {
+		return false, usersvc.ErrInvalidArgument
+	}
+	for _, role := range roles {
+		if role != usersvc.RoleUser && role != usersvc.RoleAdmin && role != usersvc.RoleService {
+			return false, usersvc.ErrInvalidArgument
+		}
+	}
+	if actorID == id && !usersvc.Roles(roles).Has(usersvc.RoleAdmin) {
+		return false, usersvc.ErrCannotModifySelf
+	}
+
+	u, err := s.user(id)
+	if err != nil {
+		return false, err
+	}
+
+	u.Roles = roles
+	if err := s.users.UpdateAudited(u, entry(actorID, "assign_roles", id, strings.Join(roles, ","))); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (s basicService) AuditLog(_ context.Context, actorID uint64, from, to time.Time) ([]usersvc.AuditEntry, error) {
+	if !from.Before(to) {
+		return nil, usersvc.ErrInvalidArgument
+	}
+
+	entries, err := s.audit.Find(from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, s.record(actorID, "audit_log", 0, fmt.Sprintf("from=%s to=%s", from.Format(time.RFC3339), to.Format(time.RFC3339)))
+}
+
+func (s basicService) record(actorID uint64, action string, targetID uint64, detail string) error {
+	return s.audit.Record(entry(actorID, action, targetID, detail))
+}
+
+func entry(actorID uint64, action string, targetID uint64, detail string) *usersvc.AuditEntry {
+	return &usersvc.AuditEntry{
+		ActorID:   actorID,
+		Action:    action,
+		TargetID:  targetID,
+		Detail:    detail,
+		CreatedAt: time.Now().UTC(),
+	}
+}
+
+// provision creates a user for an identity without a matching email. The
+// user is named after the email and has no password, so that it can only
+// log in through the identity provider.
+func (s basicService) provision(email string) (*usersvc.User, error) {
+	name := email
+	for i := 2; s.users.GetUser(name).ID != 0; i++ {
+		if i > maxProvisionAttempts {
+			return nil, usersvc.ErrInvalidArgument
+		}
+		name = fmt.Sprintf("%s-%d", email, i)
+	}
+
+	u := &usersvc.User{
+		Name:  name,
+		Email: email,
+		Roles: usersvc.Roles{usersvc.RoleUser},
+	}
+	if err := s.users.Create(u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// profile returns the user without any credentials.
+func profile(u *usersvc.User) usersvc.User {
+	return usersvc.User{
+		ID:                    u.ID,
+		Name:                  u.Name,
+		Email:                 u.Email,
+		Roles:                 u.Roles,
+		Disabled:              u.Disabled,
+		PasswordResetRequired: u.PasswordResetRequired,
+		LastLoginAt:           u.LastLoginAt,
+		TOTPEnabled:           u.TOTPEnabled,
+	}
+}
+
+func (s basicService) user(id uint64) (*usersvc.User, error) {
+	if id == 0 {
+		return nil, usersvc.ErrInvalidArgument
+	}
+
+	u := s.users.GetUserByID(id)
+	if u.ID == 0 {
+		return nil, usersvc.ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (s basicService) verify(u *usersvc.User, code string) (bool, error) {
+	if counter, ok := totp.Validate(code, u.TOTPSecret, time.Now(), totpSkew); ok {
+		if counter <= u.TOTPCounter {
+			return false, nil
+		}
+		u.TOTPCounter = counter
+		return true, s.users.Update(u)
+	}
+
+	hashes := strings.Split(u.RecoveryCodes, "\n")
+	i := totp.MatchRecoveryCode(code, hashes)
+	if i < 0 {
+		return false, nil
+	}
+	u.RecoveryCodes = strings.Join(append(hashes[:i], hashes[i+1:]...), "\n")
+	return true, s.users.Update(u)
+}
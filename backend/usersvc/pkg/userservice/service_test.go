@@ -0,0 +1,177 @@
+package userservice_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+	"github.com/ichigozero/gtdkit/backend/usersvc/db/gorm"
+	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/userservice"
+	"github.com/ichigozero/gtdkit/backend/usersvc/totp"
+	"gorm.io/driver/sqlite"
+	libgorm "gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newService(t *testing.T) (userservice.Service, usersvc.UserRepository) {
+	t.Helper()
+
+	db, err := libgorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "gorm.db")), &libgorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&usersvc.User{}, &usersvc.AuditEntry{}, &usersvc.Identity{}); err != nil {
+		t.Fatal(err)
+	}
+
+	users := gorm.NewUserRepository(db)
+	return userservice.NewBasicService(users, gorm.NewAuditRepository(db), gorm.NewIdentityRepository(db)), users
+}
+
+func createUser(t *testing.T, users usersvc.UserRepository, name string, roles ...string) *usersvc.User {
+	t.Helper()
+
+	u := &usersvc.User{Name: name, Roles: roles}
+	if err := users.Create(u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func code(t *testing.T, secret string, counter int64) string {
+	t.Helper()
+
+	c, err := totp.Code(secret, counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// TestTOTPRefusesReplayedCodes enrolls a user and checks that every code,
+// of the authenticator app or a recovery code, is only accepted once. The
+// codes are chosen so that the outcome does not change if the time step
+// ends during the test.
+func TestTOTPRefusesReplayedCodes(t *testing.T) {
+	t.Parallel()
+
+	svc, users := newService(t)
+	u := createUser(t, users, "ann")
+	ctx := context.Background()
+
+	key, err := svc.EnrollTOTP(ctx, u.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current := totp.Counter(time.Now())
+
+	if _, err := svc.ConfirmTOTP(ctx, u.ID, code(t, key.Secret, current-2)); err != usersvc.ErrInvalidTOTPCode {
+		t.Errorf("code of two time steps ago: want %v, got %v", usersvc.ErrInvalidTOTPCode, err)
+	}
+	recovery, err := svc.ConfirmTOTP(ctx, u.ID, code(t, key.Secret, current))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recovery) != 10 {
+		t.Fatalf("want 10 recovery codes, got %d", len(recovery))
+	}
+
+	for _, tc := range []struct {
+		name string
+		code string
+		ok   bool
+	}{
+		{"confirmed code", code(t, key.Secret, current), false},
+		{"code of the next time step", code(t, key.Secret, current+1), true},
+		{"replayed code", code(t, key.Secret, current+1), false},
+		{"code of an earlier time step", code(t, key.Secret, current), false},
+		{"recovery code", recovery[0], true},
+		{"used recovery code", recovery[0], false},
+		{"formatted recovery code", "  " + recovery[1][:5] + recovery[1][6:] + " ", true},
+		{"unknown code", "aaaaa-aaaaa", false},
+	} {
+		ok, err := svc.VerifyTOTP(ctx, u.ID, tc.code)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if ok != tc.ok {
+			t.Errorf("%s: want %v, got %v", tc.name, tc.ok, ok)
+		}
+	}
+
+	if n := strings.Count(users.GetUserByID(u.ID).RecoveryCodes, "\n") + 1; n != 8 {
+		t.Errorf("want 8 recovery codes left, got %d", n)
+	}
+}
+
+// TestPersonalData checks that the data of a user holds its linked
+// identities and the audit entries about it, but no credentials.
+func TestPersonalData(t *testing.T) {
+	t.Parallel()
+
+	svc, users := newService(t)
+	admin := createUser(t, users, "admin", usersvc.RoleAdmin)
+	ann := createUser(t, users, "ann", usersvc.RoleUser)
+	bob := createUser(t, users, "bob", usersvc.RoleUser)
+	ctx := context.Background()
+
+	ann.Email = "ann@example.com"
+	if err := users.Update(ann); err != nil {
+		t.Fatal(err)
+	}
+
+	if d, err := svc.PersonalData(ctx, ann.ID); err != nil || d.RecoveryCodesLeft != 0 || len(d.Identities) != 0 || len(d.AuditEntries) != 0 {
+		t.Errorf("user without data: want nothing, got %+v, %v", d, err)
+	}
+
+	key, err := svc.EnrollTOTP(ctx, ann.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recovery, err := svc.ConfirmTOTP(ctx, ann.ID, code(t, key.Secret, totp.Counter(time.Now())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := svc.VerifyTOTP(ctx, ann.ID, recovery[0]); err != nil || !ok {
+		t.Fatalf("want the recovery code accepted, got %v, %v", ok, err)
+	}
+
+	if _, err := svc.LinkIdentity(ctx, "https://idp.example.com", "sub", ann.Email); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.ForcePasswordReset(ctx, admin.ID, ann.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := svc.SetDisabled(ctx, admin.ID, bob.ID, true); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := svc.PersonalData(ctx, ann.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Profile.ID != ann.ID || !d.Profile.TOTPEnabled || d.Profile.TOTPSecret != "" || d.Profile.RecoveryCodes != "" || d.Profile.Password != "" {
+		t.Errorf("want the profile without credentials, got %+v", d.Profile)
+	}
+	if d.RecoveryCodesLeft != 9 {
+		t.Errorf("want 9 recovery codes left, got %d", d.RecoveryCodesLeft)
+	}
+	if len(d.Identities) != 1 || d.Identities[0].Subject != "sub" || d.Identities[0].UserID != ann.ID {
+		t.Errorf("want the linked identity, got %+v", d.Identities)
+	}
+
+	var actions []string
+	for _, e := range d.AuditEntries {
+		actions = append(actions, e.Action)
+	}
+	if strings.Join(actions, ",") != "link_identity,force_password_reset" {
+		t.Errorf("want the entries about the user only, got %v", actions)
+	}
+
+	if _, err := svc.PersonalData(ctx, 100); err != usersvc.ErrUserNotFound {
+		t.Errorf("unknown user: want %v, got %v", usersvc.ErrUserNotFound, err)
+	}
+}
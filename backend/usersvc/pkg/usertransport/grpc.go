@@ -5,12 +5,15 @@ import (
 	"errors"
 	"time"
 
+	kitjwt "github.com/go-kit/kit/auth/jwt"
 	"github.com/go-kit/kit/circuitbreaker"
 	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/ratelimit"
 	"github.com/go-kit/kit/transport"
 	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"github.com/ichigozero/gtdkit/backend/authsvc/authz"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
 	"github.com/ichigozero/gtdkit/backend/usersvc"
 	"github.com/ichigozero/gtdkit/backend/usersvc/pb"
 	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/userendpoint"
@@ -18,19 +21,100 @@ import (
 	"github.com/sony/gobreaker"
 	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type grpcServer struct {
 	userID   grpctransport.Handler
 	isExists grpctransport.Handler
+	profile  grpctransport.Handler
+
+	enrollTOTP  grpctransport.Handler
+	confirmTOTP grpctransport.Handler
+	verifyTOTP  grpctransport.Handler
+	disableTOTP grpctransport.Handler
+
+	changePassword grpctransport.Handler
+	linkIdentity   grpctransport.Handler
+	personalData   grpctransport.Handler
+
+	listUsers          grpctransport.Handler
+	getUser            grpctransport.Handler
+	setDisabled        grpctransport.Handler
+	forcePasswordReset grpctransport.Handler
+	assignRoles        grpctransport.Handler
+	auditLog           grpctransport.Handler
 	pb.UnimplementedUserServer
 }
 
-func NewGRPCServer(endpoints userendpoint.Set, logger log.Logger) pb.UserServer {
+func NewGRPCServer(endpoints userendpoint.Set, keys *jwks.Cache, logger log.Logger) pb.UserServer {
 	options := []grpctransport.ServerOption{
 		grpctransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
 	}
 
+	// The administrator operations are authorized by the roles of the
+	// access token forwarded by the gateway.
+	var listUsersEndpoint endpoint.Endpoint
+	{
+		listUsersEndpoint = endpoints.ListUsersEndpoint
+		listUsersEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(listUsersEndpoint)
+	}
+
+	var getUserEndpoint endpoint.Endpoint
+	{
+		getUserEndpoint = endpoints.GetUserEndpoint
+		getUserEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(getUserEndpoint)
+	}
+
+	var setDisabledEndpoint endpoint.Endpoint
+	{
+		setDisabledEndpoint = endpoints.SetDisabledEndpoint
+		setDisabledEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(setDisabledEndpoint)
+	}
+
+	var forcePasswordResetEndpoint endpoint.Endpoint
+	{
+		forcePasswordResetEndpoint = endpoints.ForcePasswordResetEndpoint
+		forcePasswordResetEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(forcePasswordResetEndpoint)
+	}
+
+	var assignRolesEndpoint endpoint.Endpoint
+	{
+		assignRolesEndpoint = endpoints.AssignRolesEndpoint
+		assignRolesEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(assignRolesEndpoint)
+	}
+
+	var auditLogEndpoint endpoint.Endpoint
+	{
+		auditLogEndpoint = endpoints.AuditLogEndpoint
+		auditLogEndpoint = kitjwt.NewParser(
+			keys.Keyfunc,
+			keys.Method(),
+			kitjwt.MapClaimsFactory,
+		)(auditLogEndpoint)
+	}
+
 	return &grpcServer{
 		userID: grpctransport.NewServer(
 			endpoints.UserIDEndpoint,
@@ -44,6 +128,90 @@ func NewGRPCServer(endpoints userendpoint.Set, logger log.Logger) pb.UserServer
 			encodeGRPCIsExistsResponse,
 			options...,
 		),
+		profile: grpctransport.NewServer(
+			endpoints.ProfileEndpoint,
+			decodeGRPCProfileRequest,
+			encodeGRPCProfileResponse,
+			options...,
+		),
+		enrollTOTP: grpctransport.NewServer(
+			endpoints.EnrollTOTPEndpoint,
+			decodeGRPCEnrollTOTPRequest,
+			encodeGRPCEnrollTOTPResponse,
+			options...,
+		),
+		confirmTOTP: grpctransport.NewServer(
+			endpoints.ConfirmTOTPEndpoint,
+			decodeGRPCConfirmTOTPRequest,
+			encodeGRPCConfirmTOTPResponse,
+			options...,
+		),
+		verifyTOTP: grpctransport.NewServer(
+			endpoints.VerifyTOTPEndpoint,
+			decodeGRPCVerifyTOTPRequest,
+			encodeGRPCVerifyTOTPResponse,
+			options...,
+		),
+		disableTOTP: grpctransport.NewServer(
+			endpoints.DisableTOTPEndpoint,
+			decodeGRPCDisableTOTPRequest,
+			encodeGRPCDisableTOTPResponse,
+			options...,
+		),
+		changePassword: grpctransport.NewServer(
+			endpoints.ChangePasswordEndpoint,
+			decodeGRPCChangePasswordRequest,
+			encodeGRPCChangePasswordResponse,
+			options...,
+		),
+		linkIdentity: grpctransport.NewServer(
+			endpoints.LinkIdentityEndpoint,
+			decodeGRPCLinkIdentityRequest,
+			encodeGRPCLinkIdentityResponse,
+			options...,
+		),
+		personalData: grpctransport.NewServer(
+			endpoints.PersonalDataEndpoint,
+			decodeGRPCPersonalDataRequest,
+			encodeGRPCPersonalDataResponse,
+			options...,
+		),
+		listUsers: grpctransport.NewServer(
+			listUsersEndpoint,
+			decodeGRPCListUsersRequest,
+			encodeGRPCListUsersResponse,
+			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext()))...,
+		),
+		getUser: grpctransport.NewServer(
+			getUserEndpoint,
+			decodeGRPCGetUserRequest,
+			encodeGRPCGetUserResponse,
+			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext()))...,
+		),
+		setDisabled: grpctransport.NewServer(
+			setDisabledEndpoint,
+			decodeGRPCSetDisabledRequest,
+			encodeGRPCSetDisabledResponse,
+			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext()))...,
+		),
+		forcePasswordReset: grpctransport.NewServer(
+			forcePasswordResetEndpoint,
+			decodeGRPCForcePasswordResetRequest,
+			encodeGRPCForcePasswordResetResponse,
+			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext()))...,
+		),
+		assignRoles: grpctransport.NewServer(
+			assignRolesEndpoint,
+			decodeGRPCAssignRolesRequest,
+			encodeGRPCAssignRolesResponse,
+			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext()))...,
+		),
+		auditLog: grpctransport.NewServer(
+			auditLogEndpoint,
+			decodeGRPCAuditLogRequest,
+			encodeGRPCAuditLogResponse,
+			append(options, grpctransport.ServerBefore(kitjwt.GRPCToContext()))...,
+		),
 	}
 }
 
@@ -63,6 +231,118 @@ func (s *grpcServer) IsExists(ctx context.Context, req *pb.IsExistsRequest) (*pb
 	return rep.(*pb.IsExistsReply), nil
 }
 
+func (s *grpcServer) Profile(ctx context.Context, req *pb.ProfileRequest) (*pb.ProfileReply, error) {
+	_, rep, err := s.profile.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.ProfileReply), nil
+}
+
+func (s *grpcServer) EnrollTOTP(ctx context.Context, req *pb.EnrollTOTPRequest) (*pb.EnrollTOTPReply, error) {
+	_, rep, err := s.enrollTOTP.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.EnrollTOTPReply), nil
+}
+
+func (s *grpcServer) ConfirmTOTP(ctx context.Context, req *pb.ConfirmTOTPRequest) (*pb.ConfirmTOTPReply, error) {
+	_, rep, err := s.confirmTOTP.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.ConfirmTOTPReply), nil
+}
+
+func (s *grpcServer) VerifyTOTP(ctx context.Context, req *pb.VerifyTOTPRequest) (*pb.VerifyTOTPReply, error) {
+	_, rep, err := s.verifyTOTP.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.VerifyTOTPReply), nil
+}
+
+func (s *grpcServer) DisableTOTP(ctx context.Context, req *pb.DisableTOTPRequest) (*pb.DisableTOTPReply, error) {
+	_, rep, err := s.disableTOTP.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.DisableTOTPReply), nil
+}
+
+func (s *grpcServer) ChangePassword(ctx context.Context, req *pb.ChangePasswordRequest) (*pb.ChangePasswordReply, error) {
+	_, rep, err := s.changePassword.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.ChangePasswordReply), nil
+}
+
+func (s *grpcServer) LinkIdentity(ctx context.Context, req *pb.LinkIdentityRequest) (*pb.LinkIdentityReply, error) {
+	_, rep, err := s.linkIdentity.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.LinkIdentityReply), nil
+}
+
+func (s *grpcServer) PersonalData(ctx context.Context, req *pb.PersonalDataRequest) (*pb.PersonalDataReply, error) {
+	_, rep, err := s.personalData.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return rep.(*pb.PersonalDataReply), nil
+}
+
+func (s *grpcServer) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersReply, error) {
+	_, rep, err := s.listUsers.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return rep.(*pb.ListUsersReply), nil
+}
+
+func (s *grpcServer) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserReply, error) {
+	_, rep, err := s.getUser.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return rep.(*pb.GetUserReply), nil
+}
+
+func (s *grpcServer) SetDisabled(ctx context.Context, req *pb.SetDisabledRequest) (*pb.SetDisabledReply, error) {
+	_, rep, err := s.setDisabled.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return rep.(*pb.SetDisabledReply), nil
+}
+
+func (s *grpcServer) ForcePasswordReset(ctx context.Context, req *pb.ForcePasswordResetRequest) (*pb.ForcePasswordResetReply, error) {
+	_, rep, err := s.forcePasswordReset.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return rep.(*pb.ForcePasswordResetReply), nil
+}
+
+func (s *grpcServer) AssignRoles(ctx context.Context, req *pb.AssignRolesRequest) (*pb.AssignRolesReply, error) {
+	_, rep, err := s.assignRoles.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return rep.(*pb.AssignRolesReply), nil
+}
+
+func (s *grpcServer) AuditLog(ctx context.Context, req *pb.AuditLogRequest) (*pb.AuditLogReply, error) {
+	_, rep, err := s.auditLog.ServeGRPC(ctx, req)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	return rep.(*pb.AuditLogReply), nil
+}
+
 func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) userservice.Service {
 	limiter := ratelimit.NewErroringLimiter(rate.NewLimiter(rate.Every(time.Second), 100))
 
@@ -104,9 +384,295 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) userservice.Service
 		}))(isExistsEndpoint)
 	}
 
+	var profileEndpoint endpoint.Endpoint
+	{
+		profileEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"Profile",
+			encodeGRPCProfileRequest,
+			decodeGRPCProfileResponse,
+			pb.ProfileReply{},
+			options...,
+		).Endpoint()
+		profileEndpoint = limiter(profileEndpoint)
+		profileEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "Profile",
+			Timeout: 30 * time.Second,
+		}))(profileEndpoint)
+	}
+
+	var enrollTOTPEndpoint endpoint.Endpoint
+	{
+		enrollTOTPEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"EnrollTOTP",
+			encodeGRPCEnrollTOTPRequest,
+			decodeGRPCEnrollTOTPResponse,
+			pb.EnrollTOTPReply{},
+			options...,
+		).Endpoint()
+		enrollTOTPEndpoint = limiter(enrollTOTPEndpoint)
+		enrollTOTPEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "EnrollTOTP",
+			Timeout: 30 * time.Second,
+		}))(enrollTOTPEndpoint)
+	}
+
+	var confirmTOTPEndpoint endpoint.Endpoint
+	{
+		confirmTOTPEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"ConfirmTOTP",
+			encodeGRPCConfirmTOTPRequest,
+			decodeGRPCConfirmTOTPResponse,
+			pb.ConfirmTOTPReply{},
+			options...,
+		).Endpoint()
+		confirmTOTPEndpoint = limiter(confirmTOTPEndpoint)
+		confirmTOTPEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "ConfirmTOTP",
+			Timeout: 30 * time.Second,
+		}))(confirmTOTPEndpoint)
+	}
+
+	var verifyTOTPEndpoint endpoint.Endpoint
+	{
+		verifyTOTPEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"VerifyTOTP",
+			encodeGRPCVerifyTOTPRequest,
+			decodeGRPCVerifyTOTPResponse,
+			pb.VerifyTOTPReply{},
+			options...,
+		).Endpoint()
+		verifyTOTPEndpoint = limiter(verifyTOTPEndpoint)
+		verifyTOTPEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "VerifyTOTP",
+			Timeout: 30 * time.Second,
+		}))(verifyTOTPEndpoint)
+	}
+
+	var disableTOTPEndpoint endpoint.Endpoint
+	{
+		disableTOTPEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"DisableTOTP",
+			encodeGRPCDisableTOTPRequest,
+			decodeGRPCDisableTOTPResponse,
+			pb.DisableTOTPReply{},
+			options...,
+		).Endpoint()
+		disableTOTPEndpoint = limiter(disableTOTPEndpoint)
+		disableTOTPEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "DisableTOTP",
+			Timeout: 30 * time.Second,
+		}))(disableTOTPEndpoint)
+	}
+
+	var changePasswordEndpoint endpoint.Endpoint
+	{
+		changePasswordEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"ChangePassword",
+			encodeGRPCChangePasswordRequest,
+			decodeGRPCChangePasswordResponse,
+			pb.ChangePasswordReply{},
+			options...,
+		).Endpoint()
+		changePasswordEndpoint = limiter(changePasswordEndpoint)
+		changePasswordEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "ChangePassword",
+			Timeout: 30 * time.Second,
+		}))(changePasswordEndpoint)
+	}
+
+	var linkIdentityEndpoint endpoint.Endpoint
+	{
+		linkIdentityEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"LinkIdentity",
+			encodeGRPCLinkIdentityRequest,
+			decodeGRPCLinkIdentityResponse,
+			pb.LinkIdentityReply{},
+			options...,
+		).Endpoint()
+		linkIdentityEndpoint = limiter(linkIdentityEndpoint)
+		linkIdentityEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "LinkIdentity",
+			Timeout: 30 * time.Second,
+		}))(linkIdentityEndpoint)
+	}
+
+	var personalDataEndpoint endpoint.Endpoint
+	{
+		personalDataEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"PersonalData",
+			encodeGRPCPersonalDataRequest,
+			decodeGRPCPersonalDataResponse,
+			pb.PersonalDataReply{},
+			options...,
+		).Endpoint()
+		personalDataEndpoint = limiter(personalDataEndpoint)
+		personalDataEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "PersonalData",
+			Timeout: 30 * time.Second,
+		}))(personalDataEndpoint)
+	}
+
+	var listUsersEndpoint endpoint.Endpoint
+	{
+		listUsersEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"ListUsers",
+			encodeGRPCListUsersRequest,
+			decodeGRPCListUsersResponse,
+			pb.ListUsersReply{},
+			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC()))...,
+		).Endpoint()
+		listUsersEndpoint = permissionDenied(func(err error) interface{} {
+			return userendpoint.ListUsersResponse{Err: err}
+		})(listUsersEndpoint)
+		listUsersEndpoint = limiter(listUsersEndpoint)
+		listUsersEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "ListUsers",
+			Timeout: 30 * time.Second,
+		}))(listUsersEndpoint)
+	}
+
+	var getUserEndpoint endpoint.Endpoint
+	{
+		getUserEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"GetUser",
+			encodeGRPCGetUserRequest,
+			decodeGRPCGetUserResponse,
+			pb.GetUserReply{},
+			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC()))...,
+		).Endpoint()
+		getUserEndpoint = permissionDenied(func(err error) interface{} {
+			return userendpoint.GetUserResponse{Err: err}
+		})(getUserEndpoint)
+		getUserEndpoint = limiter(getUserEndpoint)
+		getUserEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "GetUser",
+			Timeout: 30 * time.Second,
+		}))(getUserEndpoint)
+	}
+
+	var setDisabledEndpoint endpoint.Endpoint
+	{
+		setDisabledEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"SetDisabled",
+			encodeGRPCSetDisabledRequest,
+			decodeGRPCSetDisabledResponse,
+			pb.SetDisabledReply{},
+			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC()))...,
+		).Endpoint()
+		setDisabledEndpoint = permissionDenied(func(err error) interface{} {
+			return userendpoint.SetDisabledResponse{Err: err}
+		})(setDisabledEndpoint)
+		setDisabledEndpoint = limiter(setDisabledEndpoint)
+		setDisabledEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "SetDisabled",
+			Timeout: 30 * time.Second,
+		}))(setDisabledEndpoint)
+	}
+
+	var forcePasswordResetEndpoint endpoint.Endpoint
+	{
+		forcePasswordResetEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"ForcePasswordReset",
+			encodeGRPCForcePasswordResetRequest,
+			decodeGRPCForcePasswordResetResponse,
+			pb.ForcePasswordResetReply{},
+			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC()))...,
+		).Endpoint()
+		forcePasswordResetEndpoint = permissionDenied(func(err error) interface{} {
+			return userendpoint.ForcePasswordResetResponse{Err: err}
+		})(forcePasswordResetEndpoint)
+		forcePasswordResetEndpoint = limiter(forcePasswordResetEndpoint)
+		forcePasswordResetEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "ForcePasswordReset",
+			Timeout: 30 * time.Second,
+		}))(forcePasswordResetEndpoint)
+	}
+
+	var assignRolesEndpoint endpoint.Endpoint
+	{
+		assignRolesEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"AssignRoles",
+			encodeGRPCAssignRolesRequest,
+			decodeGRPCAssignRolesResponse,
+			pb.AssignRolesReply{},
+			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC()))...,
+		).Endpoint()
+		assignRolesEndpoint = permissionDenied(func(err error) interface{} {
+			return userendpoint.AssignRolesResponse{Err: err}
+		})(assignRolesEndpoint)
+		assignRolesEndpoint = limiter(assignRolesEndpoint)
+		assignRolesEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "AssignRoles",
+			Timeout: 30 * time.Second,
+		}))(assignRolesEndpoint)
+	}
+
+	var auditLogEndpoint endpoint.Endpoint
+	{
+		auditLogEndpoint = grpctransport.NewClient(
+			conn,
+			"pb.User",
+			"AuditLog",
+			encodeGRPCAuditLogRequest,
+			decodeGRPCAuditLogResponse,
+			pb.AuditLogReply{},
+			append(options, grpctransport.ClientBefore(kitjwt.ContextToGRPC()))...,
+		).Endpoint()
+		auditLogEndpoint = permissionDenied(func(err error) interface{} {
+			return userendpoint.AuditLogResponse{Err: err}
+		})(auditLogEndpoint)
+		auditLogEndpoint = limiter(auditLogEndpoint)
+		auditLogEndpoint = circuitbreaker.Gobreaker(gobreaker.NewCircuitBreaker(gobreaker.Settings{
+			Name:    "AuditLog",
+			Timeout: 30 * time.Second,
+		}))(auditLogEndpoint)
+	}
+
 	return userendpoint.Set{
-		UserIDEndpoint:   userIDEndpoint,
-		IsExistsEndpoint: isExistsEndpoint,
+		UserIDEndpoint:      userIDEndpoint,
+		IsExistsEndpoint:    isExistsEndpoint,
+		ProfileEndpoint:     profileEndpoint,
+		EnrollTOTPEndpoint:  enrollTOTPEndpoint,
+		ConfirmTOTPEndpoint: confirmTOTPEndpoint,
+		VerifyTOTPEndpoint:  verifyTOTPEndpoint,
+		DisableTOTPEndpoint: disableTOTPEndpoint,
+
+		ChangePasswordEndpoint: changePasswordEndpoint,
+		LinkIdentityEndpoint:   linkIdentityEndpoint,
+		PersonalDataEndpoint:   personalDataEndpoint,
+
+		ListUsersEndpoint:          listUsersEndpoint,
+		GetUserEndpoint:            getUserEndpoint,
+		SetDisabledEndpoint:        setDisabledEndpoint,
+		ForcePasswordResetEndpoint: forcePasswordResetEndpoint,
+		AssignRolesEndpoint:        assignRolesEndpoint,
+		AuditLogEndpoint:           auditLogEndpoint,
 	}
 }
 
@@ -150,6 +716,485 @@ func decodeGRPCIsExistsResponse(_ context.Context, grpcReply interface{}) (inter
 	return userendpoint.IsExistsResponse{V: reply.V, Err: str2err(reply.Err)}, nil
 }
 
+func decodeGRPCProfileRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ProfileRequest)
+	return userendpoint.ProfileRequest{ID: req.Id}, nil
+}
+
+func encodeGRPCProfileResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.ProfileResponse)
+	return &pb.ProfileReply{
+		Profile: &pb.Profile{
+			Id:          resp.User.ID,
+			Name:        resp.User.Name,
+			TotpEnabled: resp.User.TOTPEnabled,
+			Roles:       resp.User.Roles,
+			Disabled:    resp.User.Disabled,
+
+			PasswordResetRequired: resp.User.PasswordResetRequired,
+		},
+		Err: err2str(resp.Err),
+	}, nil
+}
+
+func encodeGRPCProfileRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.ProfileRequest)
+	return &pb.ProfileRequest{Id: req.ID}, nil
+}
+
+func decodeGRPCProfileResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ProfileReply)
+	return userendpoint.ProfileResponse{
+		User: usersvc.User{
+			ID:          reply.Profile.GetId(),
+			Name:        reply.Profile.GetName(),
+			TOTPEnabled: reply.Profile.GetTotpEnabled(),
+			Roles:       reply.Profile.GetRoles(),
+			Disabled:    reply.Profile.GetDisabled(),
+
+			PasswordResetRequired: reply.Profile.GetPasswordResetRequired(),
+		},
+		Err: str2err(reply.Err),
+	}, nil
+}
+
+func decodeGRPCEnrollTOTPRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.EnrollTOTPRequest)
+	return userendpoint.EnrollTOTPRequest{ID: req.Id}, nil
+}
+
+func encodeGRPCEnrollTOTPResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.EnrollTOTPResponse)
+	return &pb.EnrollTOTPReply{Secret: resp.Key.Secret, Uri: resp.Key.URI, Err: err2str(resp.Err)}, nil
+}
+
+func encodeGRPCEnrollTOTPRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.EnrollTOTPRequest)
+	return &pb.EnrollTOTPRequest{Id: req.ID}, nil
+}
+
+func decodeGRPCEnrollTOTPResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.EnrollTOTPReply)
+	return userendpoint.EnrollTOTPResponse{
+		Key: usersvc.TOTPKey{Secret: reply.Secret, URI: reply.Uri},
+		Err: str2err(reply.Err),
+	}, nil
+}
+
+func decodeGRPCConfirmTOTPRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ConfirmTOTPRequest)
+	return userendpoint.ConfirmTOTPRequest{ID: req.Id, Code: req.Code}, nil
+}
+
+func encodeGRPCConfirmTOTPResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.ConfirmTOTPResponse)
+	return &pb.ConfirmTOTPReply{RecoveryCodes: resp.RecoveryCodes, Err: err2str(resp.Err)}, nil
+}
+
+func encodeGRPCConfirmTOTPRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.ConfirmTOTPRequest)
+	return &pb.ConfirmTOTPRequest{Id: req.ID, Code: req.Code}, nil
+}
+
+func decodeGRPCConfirmTOTPResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ConfirmTOTPReply)
+	return userendpoint.ConfirmTOTPResponse{RecoveryCodes: reply.RecoveryCodes, Err: str2err(reply.Err)}, nil
+}
+
+func decodeGRPCVerifyTOTPRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.VerifyTOTPRequest)
+	return userendpoint.VerifyTOTPRequest{ID: req.Id, Code: req.Code}, nil
+}
+
+func encodeGRPCVerifyTOTPResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.VerifyTOTPResponse)
+	return &pb.VerifyTOTPReply{V: resp.V, Err: err2str(resp.Err)}, nil
+}
+
+func encodeGRPCVerifyTOTPRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.VerifyTOTPRequest)
+	return &pb.VerifyTOTPRequest{Id: req.ID, Code: req.Code}, nil
+}
+
+func decodeGRPCVerifyTOTPResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.VerifyTOTPReply)
+	return userendpoint.VerifyTOTPResponse{V: reply.V, Err: str2err(reply.Err)}, nil
+}
+
+func decodeGRPCDisableTOTPRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.DisableTOTPRequest)
+	return userendpoint.DisableTOTPRequest{ID: req.Id, Code: req.Code}, nil
+}
+
+func encodeGRPCDisableTOTPResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.DisableTOTPResponse)
+	return &pb.DisableTOTPReply{V: resp.V, Err: err2str(resp.Err)}, nil
+}
+
+func encodeGRPCDisableTOTPRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.DisableTOTPRequest)
+	return &pb.DisableTOTPRequest{Id: req.ID, Code: req.Code}, nil
+}
+
+func decodeGRPCDisableTOTPResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.DisableTOTPReply)
+	return userendpoint.DisableTOTPResponse{V: reply.V, Err: str2err(reply.Err)}, nil
+}
+
+func decodeGRPCChangePasswordRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ChangePasswordRequest)
+	return userendpoint.ChangePasswordRequest{Name: req.Name, OldPassword: req.OldPassword, NewPassword: req.NewPassword}, nil
+}
+
+func encodeGRPCChangePasswordResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.ChangePasswordResponse)
+	return &pb.ChangePasswordReply{V: resp.V, Err: err2str(resp.Err)}, nil
+}
+
+func encodeGRPCChangePasswordRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.ChangePasswordRequest)
+	return &pb.ChangePasswordRequest{Name: req.Name, OldPassword: req.OldPassword, NewPassword: req.NewPassword}, nil
+}
+
+func decodeGRPCChangePasswordResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ChangePasswordReply)
+	return userendpoint.ChangePasswordResponse{V: reply.V, Err: str2err(reply.Err)}, nil
+}
+
+func decodeGRPCLinkIdentityRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.LinkIdentityRequest)
+	return userendpoint.LinkIdentityRequest{Issuer: req.Issuer, Subject: req.Subject, Email: req.Email}, nil
+}
+
+func encodeGRPCLinkIdentityResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.LinkIdentityResponse)
+	return &pb.LinkIdentityReply{
+		Profile: &pb.Profile{
+			Id:          resp.User.ID,
+			Name:        resp.User.Name,
+			TotpEnabled: resp.User.TOTPEnabled,
+			Roles:       resp.User.Roles,
+			Disabled:    resp.User.Disabled,
+
+			PasswordResetRequired: resp.User.PasswordResetRequired,
+		},
+		Err: err2str(resp.Err),
+	}, nil
+}
+
+func encodeGRPCLinkIdentityRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.LinkIdentityRequest)
+	return &pb.LinkIdentityRequest{Issuer: req.Issuer, Subject: req.Subject, Email: req.Email}, nil
+}
+
+func decodeGRPCLinkIdentityResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.LinkIdentityReply)
+	return userendpoint.LinkIdentityResponse{
+		User: usersvc.User{
+			ID:          reply.Profile.GetId(),
+			Name:        reply.Profile.GetName(),
+			TOTPEnabled: reply.Profile.GetTotpEnabled(),
+			Roles:       reply.Profile.GetRoles(),
+			Disabled:    reply.Profile.GetDisabled(),
+
+			PasswordResetRequired: reply.Profile.GetPasswordResetRequired(),
+		},
+		Err: str2err(reply.Err),
+	}, nil
+}
+
+func decodeGRPCPersonalDataRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.PersonalDataRequest)
+	return userendpoint.PersonalDataRequest{ID: req.Id}, nil
+}
+
+func encodeGRPCPersonalDataResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.PersonalDataResponse)
+	identities := make([]*pb.Identity, 0, len(resp.Data.Identities))
+	for _, i := range resp.Data.Identities {
+		identities = append(identities, identity2pb(i))
+	}
+	entries := make([]*pb.AuditEntry, 0, len(resp.Data.AuditEntries))
+	for _, e := range resp.Data.AuditEntries {
+		entries = append(entries, entry2pb(e))
+	}
+	return &pb.PersonalDataReply{
+		User:              user2pb(resp.Data.Profile),
+		RecoveryCodesLeft: int32(resp.Data.RecoveryCodesLeft),
+		Identities:        identities,
+		AuditEntries:      entries,
+		Err:               err2str(resp.Err),
+	}, nil
+}
+
+func encodeGRPCPersonalDataRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.PersonalDataRequest)
+	return &pb.PersonalDataRequest{Id: req.ID}, nil
+}
+
+func decodeGRPCPersonalDataResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.PersonalDataReply)
+	identities := make([]usersvc.Identity, 0, len(reply.Identities))
+	for _, i := range reply.Identities {
+		identities = append(identities, pb2identity(i))
+	}
+	entries := make([]usersvc.AuditEntry, 0, len(reply.AuditEntries))
+	for _, e := range reply.AuditEntries {
+		entries = append(entries, pb2entry(e))
+	}
+	return userendpoint.PersonalDataResponse{
+		Data: usersvc.PersonalData{
+			Profile:           pb2user(reply.User),
+			RecoveryCodesLeft: int(reply.RecoveryCodesLeft),
+			Identities:        identities,
+			AuditEntries:      entries,
+		},
+		Err: str2err(reply.Err),
+	}, nil
+}
+
+func decodeGRPCListUsersRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ListUsersRequest)
+	return userendpoint.ListUsersRequest{Query: req.Query, Offset: int(req.Offset), Limit: int(req.Limit)}, nil
+}
+
+func encodeGRPCListUsersResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.ListUsersResponse)
+	users := make([]*pb.UserInfo, 0, len(resp.Users))
+	for _, u := range resp.Users {
+		users = append(users, user2pb(u))
+	}
+	return &pb.ListUsersReply{Users: users, Total: resp.Total, Err: err2str(resp.Err)}, nil
+}
+
+func encodeGRPCListUsersRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.ListUsersRequest)
+	return &pb.ListUsersRequest{Query: req.Query, Offset: int32(req.Offset), Limit: int32(req.Limit)}, nil
+}
+
+func decodeGRPCListUsersResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ListUsersReply)
+	users := make([]usersvc.User, 0, len(reply.Users))
+	for _, u := range reply.Users {
+		users = append(users, pb2user(u))
+	}
+	return userendpoint.ListUsersResponse{Users: users, Total: reply.Total, Err: str2err(reply.Err)}, nil
+}
+
+func decodeGRPCGetUserRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.GetUserRequest)
+	return userendpoint.GetUserRequest{ID: req.Id}, nil
+}
+
+func encodeGRPCGetUserResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.GetUserResponse)
+	return &pb.GetUserReply{User: user2pb(resp.User), Err: err2str(resp.Err)}, nil
+}
+
+func encodeGRPCGetUserRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.GetUserRequest)
+	return &pb.GetUserRequest{Id: req.ID}, nil
+}
+
+func decodeGRPCGetUserResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.GetUserReply)
+	return userendpoint.GetUserResponse{User: pb2user(reply.User), Err: str2err(reply.Err)}, nil
+}
+
+func decodeGRPCSetDisabledRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.SetDisabledRequest)
+	return userendpoint.SetDisabledRequest{ID: req.Id, Disabled: req.Disabled}, nil
+}
+
+func encodeGRPCSetDisabledResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.SetDisabledResponse)
+	return &pb.SetDisabledReply{V: resp.V, Err: err2str(resp.Err)}, nil
+}
+
+func encodeGRPCSetDisabledRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.SetDisabledRequest)
+	return &pb.SetDisabledRequest{Id: req.ID, Disabled: req.Disabled}, nil
+}
+
+func decodeGRPCSetDisabledResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.SetDisabledReply)
+	return userendpoint.SetDisabledResponse{V: reply.V, Err: str2err(reply.Err)}, nil
+}
+
+func decodeGRPCForcePasswordResetRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.ForcePasswordResetRequest)
+	return userendpoint.ForcePasswordResetRequest{ID: req.Id}, nil
+}
+
+func encodeGRPCForcePasswordResetResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.ForcePasswordResetResponse)
+	return &pb.ForcePasswordResetReply{V: resp.V, Err: err2str(resp.Err)}, nil
+}
+
+func encodeGRPCForcePasswordResetRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.ForcePasswordResetRequest)
+	return &pb.ForcePasswordResetRequest{Id: req.ID}, nil
+}
+
+func decodeGRPCForcePasswordResetResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.ForcePasswordResetReply)
+	return userendpoint.ForcePasswordResetResponse{V: reply.V, Err: str2err(reply.Err)}, nil
+}
+
+func decodeGRPCAssignRolesRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.AssignRolesRequest)
+	return userendpoint.AssignRolesRequest{ID: req.Id, Roles: req.Roles}, nil
+}
+
+func encodeGRPCAssignRolesResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.AssignRolesResponse)
+	return &pb.AssignRolesReply{V: resp.V, Err: err2str(resp.Err)}, nil
+}
+
+func encodeGRPCAssignRolesRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.AssignRolesRequest)
+	return &pb.AssignRolesRequest{Id: req.ID, Roles: req.Roles}, nil
+}
+
+func decodeGRPCAssignRolesResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.AssignRolesReply)
+	return userendpoint.AssignRolesResponse{V: reply.V, Err: str2err(reply.Err)}, nil
+}
+
+func decodeGRPCAuditLogRequest(_ context.Context, grpcReq interface{}) (interface{}, error) {
+	req := grpcReq.(*pb.AuditLogRequest)
+	return userendpoint.AuditLogRequest{From: time.Unix(req.From, 0).UTC(), To: time.Unix(req.To, 0).UTC()}, nil
+}
+
+func encodeGRPCAuditLogResponse(_ context.Context, response interface{}) (interface{}, error) {
+	resp := response.(userendpoint.AuditLogResponse)
+	entries := make([]*pb.AuditEntry, 0, len(resp.Entries))
+	for _, e := range resp.Entries {
+		entries = append(entries, entry2pb(e))
+	}
+	return &pb.AuditLogReply{Entries: entries, Err: err2str(resp.Err)}, nil
+}
+
+func encodeGRPCAuditLogRequest(_ context.Context, request interface{}) (interface{}, error) {
+	req := request.(userendpoint.AuditLogRequest)
+	return &pb.AuditLogRequest{From: req.From.Unix(), To: req.To.Unix()}, nil
+}
+
+func decodeGRPCAuditLogResponse(_ context.Context, grpcReply interface{}) (interface{}, error) {
+	reply := grpcReply.(*pb.AuditLogReply)
+	entries := make([]usersvc.AuditEntry, 0, len(reply.Entries))
+	for _, e := range reply.Entries {
+		entries = append(entries, pb2entry(e))
+	}
+	return userendpoint.AuditLogResponse{Entries: entries, Err: str2err(reply.Err)}, nil
+}
+
+func user2pb(u usersvc.User) *pb.UserInfo {
+	var lastLoginAt int64
+	if u.LastLoginAt != nil {
+		lastLoginAt = u.LastLoginAt.Unix()
+	}
+	return &pb.UserInfo{
+		Id:                    u.ID,
+		Name:                  u.Name,
+		Roles:                 u.Roles,
+		Disabled:              u.Disabled,
+		PasswordResetRequired: u.PasswordResetRequired,
+		TotpEnabled:           u.TOTPEnabled,
+		LastLoginAt:           lastLoginAt,
+		Email:                 u.Email,
+	}
+}
+
+func pb2user(u *pb.UserInfo) usersvc.User {
+	var lastLoginAt *time.Time
+	if u.GetLastLoginAt() != 0 {
+		t := time.Unix(u.GetLastLoginAt(), 0).UTC()
+		lastLoginAt = &t
+	}
+	return usersvc.User{
+		ID:                    u.GetId(),
+		Name:                  u.GetName(),
+		Roles:                 u.GetRoles(),
+		Disabled:              u.GetDisabled(),
+		PasswordResetRequired: u.GetPasswordResetRequired(),
+		TOTPEnabled:           u.GetTotpEnabled(),
+		LastLoginAt:           lastLoginAt,
+		Email:                 u.GetEmail(),
+	}
+}
+
+func entry2pb(e usersvc.AuditEntry) *pb.AuditEntry {
+	return &pb.AuditEntry{
+		Id:        e.ID,
+		ActorId:   e.ActorID,
+		Action:    e.Action,
+		TargetId:  e.TargetID,
+		Detail:    e.Detail,
+		CreatedAt: e.CreatedAt.Unix(),
+	}
+}
+
+func pb2entry(e *pb.AuditEntry) usersvc.AuditEntry {
+	return usersvc.AuditEntry{
+		ID:        e.GetId(),
+		ActorID:   e.GetActorId(),
+		Action:    e.GetAction(),
+		TargetID:  e.GetTargetId(),
+		Detail:    e.GetDetail(),
+		CreatedAt: time.Unix(e.GetCreatedAt(), 0).UTC(),
+	}
+}
+
+func identity2pb(i usersvc.Identity) *pb.Identity {
+	return &pb.Identity{
+		Id:        i.ID,
+		UserId:    i.UserID,
+		Issuer:    i.Issuer,
+		Subject:   i.Subject,
+		Email:     i.Email,
+		CreatedAt: i.CreatedAt.Unix(),
+	}
+}
+
+func pb2identity(i *pb.Identity) usersvc.Identity {
+	return usersvc.Identity{
+		ID:        i.GetId(),
+		UserID:    i.GetUserId(),
+		Issuer:    i.GetIssuer(),
+		Subject:   i.GetSubject(),
+		Email:     i.GetEmail(),
+		CreatedAt: time.Unix(i.GetCreatedAt(), 0).UTC(),
+	}
+}
+
+// grpcError turns the errors which are returned by the endpoint middlewares
+// rather than within the response into the matching gRPC status.
+func grpcError(err error) error {
+	if errors.Is(err, authz.ErrForbidden) {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if errors.Is(err, usersvc.ErrSessionInvalid) {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	return err
+}
+
+// permissionDenied turns a PermissionDenied status back into a response
+// carrying authz.ErrForbidden, so that it is neither retried by the load
+// balancer nor counted as a failure by the circuit breaker.
+func permissionDenied(response func(error) interface{}) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			resp, err := next(ctx, request)
+			if status.Code(err) == codes.PermissionDenied {
+				return response(authz.ErrForbidden), nil
+			}
+			return resp, err
+		}
+	}
+}
+
 func str2err(s string) error {
 	if s == "" {
 		return nil
@@ -160,6 +1205,24 @@ func str2err(s string) error {
 		return usersvc.ErrInvalidArgument
 	case usersvc.ErrUserNotFound.Error():
 		return usersvc.ErrUserNotFound
+	case usersvc.ErrTOTPNotEnrolled.Error():
+		return usersvc.ErrTOTPNotEnrolled
+	case usersvc.ErrTOTPAlreadyEnabled.Error():
+		return usersvc.ErrTOTPAlreadyEnabled
+	case usersvc.ErrInvalidTOTPCode.Error():
+		return usersvc.ErrInvalidTOTPCode
+	case usersvc.ErrUserDisabled.Error():
+		return usersvc.ErrUserDisabled
+	case usersvc.ErrPasswordResetRequired.Error():
+		return usersvc.ErrPasswordResetRequired
+	case usersvc.ErrCannotModifySelf.Error():
+		return usersvc.ErrCannotModifySelf
+	case usersvc.ErrClaimsMissing.Error():
+		return usersvc.ErrClaimsMissing
+	case usersvc.ErrSessionInvalid.Error():
+		return usersvc.ErrSessionInvalid
+	case authz.ErrForbidden.Error():
+		return authz.ErrForbidden
 	}
 
 	return errors.New(s)
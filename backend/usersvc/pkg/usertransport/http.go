@@ -0,0 +1,222 @@
+package usertransport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	kitjwt "github.com/go-kit/kit/auth/jwt"
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/transport"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/gorilla/mux"
+	"github.com/ichigozero/gtdkit/backend/authsvc/authz"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/userendpoint"
+)
+
+// NewAdminHTTPHandler returns the handler of the administrator operations,
+// it is mounted by the gateway under /admin/v1.
+func NewAdminHTTPHandler(endpoints userendpoint.Set, keys *jwks.Cache, logger log.Logger) http.Handler {
+	options := []httptransport.ServerOption{
+		httptransport.ServerErrorEncoder(errorEncoder),
+		httptransport.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
+		httptransport.ServerBefore(kitjwt.HTTPToContext()),
+	}
+
+	parser := kitjwt.NewParser(
+		keys.Keyfunc,
+		keys.Method(),
+		kitjwt.MapClaimsFactory,
+	)
+
+	listUsersHandler := httptransport.NewServer(
+		parser(endpoints.ListUsersEndpoint),
+		decodeHTTPListUsersRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	)
+
+	getUserHandler := httptransport.NewServer(
+		parser(endpoints.GetUserEndpoint),
+		decodeHTTPGetUserRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	)
+
+	disableHandler := httptransport.NewServer(
+		parser(endpoints.SetDisabledEndpoint),
+		decodeHTTPSetDisabledRequest(true),
+		encodeHTTPGenericResponse,
+		options...,
+	)
+
+	enableHandler := httptransport.NewServer(
+		parser(endpoints.SetDisabledEndpoint),
+		decodeHTTPSetDisabledRequest(false),
+		encodeHTTPGenericResponse,
+		options...,
+	)
+
+	forcePasswordResetHandler := httptransport.NewServer(
+		parser(endpoints.ForcePasswordResetEndpoint),
+		decodeHTTPForcePasswordResetRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	)
+
+	assignRolesHandler := httptransport.NewServer(
+		parser(endpoints.AssignRolesEndpoint),
+		decodeHTTPAssignRolesRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	)
+
+	auditLogHandler := httptransport.NewServer(
+		parser(endpoints.AuditLogEndpoint),
+		decodeHTTPAuditLogRequest,
+		encodeHTTPGenericResponse,
+		options...,
+	)
+
+	r := mux.NewRouter()
+
+	r.Methods("GET").Path("/users").Handler(listUsersHandler)
+	r.Methods("GET").Path("/users/{id}").Handler(getUserHandler)
+	r.Methods("POST").Path("/users/{id}/disable").Handler(disableHandler)
+	r.Methods("POST").Path("/users/{id}/enable").Handler(enableHandler)
+	r.Methods("POST").Path("/users/{id}/password-reset").Handler(forcePasswordResetHandler)
+	r.Methods("PUT").Path("/users/{id}/roles").Handler(assignRolesHandler)
+	r.Methods("GET").Path("/audit").Handler(auditLogHandler)
+
+	return r
+}
+
+func errorEncoder(_ context.Context, err error, w http.ResponseWriter) {
+	w.WriteHeader(err2code(err))
+	json.NewEncoder(w).Encode(errorWrapper{Error: err.Error()})
+}
+
+type errorWrapper struct {
+	Error string `json:"error"`
+}
+
+func err2code(err error) int {
+	switch err {
+	case authz.ErrForbidden, usersvc.ErrCannotModifySelf:
+		return http.StatusForbidden
+	case kitjwt.ErrTokenExpired, kitjwt.ErrUnexpectedSigningMethod, jwks.ErrKeyIDMissing, jwks.ErrUnknownKey, kitjwt.ErrTokenInvalid, kitjwt.ErrTokenMalformed, kitjwt.ErrTokenContextMissing, usersvc.ErrClaimsMissing:
+		return http.StatusUnauthorized
+	case usersvc.ErrUserNotFound:
+		return http.StatusNotFound
+	case usersvc.ErrInvalidArgument:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+func decodeHTTPListUsersRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	req := userendpoint.ListUsersRequest{Query: q.Get("q")}
+
+	var err error
+	if v := q.Get("offset"); v != "" {
+		if req.Offset, err = strconv.Atoi(v); err != nil {
+			return nil, usersvc.ErrInvalidArgument
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if req.Limit, err = strconv.Atoi(v); err != nil {
+			return nil, usersvc.ErrInvalidArgument
+		}
+	}
+	return req, nil
+}
+
+func decodeHTTPGetUserRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := userIDVar(r)
+	if err != nil {
+		return nil, err
+	}
+	return userendpoint.GetUserRequest{ID: id}, nil
+}
+
+func decodeHTTPSetDisabledRequest(disabled bool) httptransport.DecodeRequestFunc {
+	return func(_ context.Context, r *http.Request) (interface{}, error) {
+		id, err := userIDVar(r)
+		if err != nil {
+			return nil, err
+		}
+		return userendpoint.SetDisabledRequest{ID: id, Disabled: disabled}, nil
+	}
+}
+
+func decodeHTTPForcePasswordResetRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := userIDVar(r)
+	if err != nil {
+		return nil, err
+	}
+	return userendpoint.ForcePasswordResetRequest{ID: id}, nil
+}
+
+func decodeHTTPAssignRolesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := userIDVar(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var req userendpoint.AssignRolesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, usersvc.ErrInvalidArgument
+	}
+	req.ID = id
+
+	return req, nil
+}
+
+// decodeHTTPAuditLogRequest reads the RFC 3339 time range, which defaults
+// to the last 24 hours.
+func decodeHTTPAuditLogRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	q := r.URL.Query()
+	req := userendpoint.AuditLogRequest{To: time.Now()}
+
+	var err error
+	if v := q.Get("to"); v != "" {
+		if req.To, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, usersvc.ErrInvalidArgument
+		}
+	}
+	req.From = req.To.Add(-24 * time.Hour)
+	if v := q.Get("from"); v != "" {
+		if req.From, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, usersvc.ErrInvalidArgument
+		}
+	}
+	return req, nil
+}
+
+func userIDVar(r *http.Request) (uint64, error) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return 0, ErrBadRouting
+	}
+	return id, nil
+}
+
+// ErrBadRouting is returned when an expected path variable is missing.
+// It always indicates programmer error.
+var ErrBadRouting = errors.New("inconsistent mapping between route and handler (programmer error)")
+
+func encodeHTTPGenericResponse(ctx context.Context, w http.ResponseWriter, response interface{}) error {
+	if f, ok := response.(endpoint.Failer); ok && f.Failed() != nil {
+		errorEncoder(ctx, f.Failed(), w)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	return json.NewEncoder(w).Encode(response)
+}
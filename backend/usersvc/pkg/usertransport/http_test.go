@@ -0,0 +1,80 @@
+package usertransport_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	stdjwt "github.com/dgrijalva/jwt-go"
+	"github.com/go-kit/kit/log"
+	"github.com/ichigozero/gtdkit/backend/authsvc/jwks"
+	"github.com/ichigozero/gtdkit/backend/usersvc"
+	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/userendpoint"
+	"github.com/ichigozero/gtdkit/backend/usersvc/pkg/usertransport"
+)
+
+// TestAdminHTTPHandlerForbidden checks that the administrator routes answer
+// the users whose roles do not allow them with 403, before reaching the
+// service.
+func TestAdminHTTPHandlerForbidden(t *testing.T) {
+	t.Parallel()
+
+	pub, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := jwks.NewKey("k", "EdDSA", pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetch := func(context.Context) (jwks.KeySet, error) {
+		return jwks.KeySet{Keys: []jwks.Key{k}}, nil
+	}
+	keys := jwks.NewCache(fetch, jwks.SigningMethodEdDSA, time.Hour)
+
+	token := stdjwt.NewWithClaims(jwks.SigningMethodEdDSA, stdjwt.MapClaims{
+		"uuid":    "session",
+		"user_id": 2,
+		"roles":   []string{usersvc.RoleUser},
+		"exp":     time.Now().Add(time.Minute).Unix(),
+	})
+	token.Header["kid"] = "k"
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	validate := func(context.Context, string) (bool, error) { return true, nil }
+	h := usertransport.NewAdminHTTPHandler(userendpoint.New(nil, validate, log.NewNopLogger()), keys, log.NewNopLogger())
+
+	for _, tc := range []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/users", ""},
+		{http.MethodGet, "/users/1", ""},
+		{http.MethodPost, "/users/1/disable", ""},
+		{http.MethodPost, "/users/1/password-reset", ""},
+		{http.MethodPut, "/users/1/roles", `{"roles":["admin"]}`},
+		{http.MethodGet, "/audit", ""},
+	} {
+		r := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s %s without token: want %d, got %d", tc.method, tc.path, http.StatusUnauthorized, w.Code)
+		}
+
+		r = httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		r.Header.Set("Authorization", "Bearer "+signed)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("%s %s as user: want %d, got %d %s", tc.method, tc.path, http.StatusForbidden, w.Code, w.Body)
+		}
+	}
+}
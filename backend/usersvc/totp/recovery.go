@@ -0,0 +1,45 @@
+package totp
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+)
+
+// RecoveryCodes returns n random single use codes, formatted as
+// xxxxx-xxxxx, together with their hashes which are meant to be stored.
+func RecoveryCodes(n int) (codes, hashes []string, err error) {
+	for i := 0; i < n; i++ {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		hashes = append(hashes, HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode hashes a recovery code ignoring its formatting. A fast
+// hash is enough since the codes are random.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// MatchRecoveryCode returns the index of the hash matching code, or -1.
+func MatchRecoveryCode(code string, hashes []string) int {
+	h := []byte(HashRecoveryCode(code))
+	for i, hash := range hashes {
+		if subtle.ConstantTimeCompare(h, []byte(hash)) == 1 {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,66 @@
+package totp_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ichigozero/gtdkit/backend/usersvc/totp"
+)
+
+// rfcSecret is the base32 encoding of the SHA-1 seed of RFC 6238,
+// "12345678901234567890".
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRFC6238 checks the SHA-1 test vectors of RFC 6238 appendix B,
+// which have 8 digits of which the last 6 are kept.
+func TestCodeRFC6238(t *testing.T) {
+	t.Parallel()
+
+	for _, tc := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		code, err := totp.Code(rfcSecret, totp.Counter(time.Unix(tc.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tc.code {
+			t.Errorf("%d: want %s, got %s", tc.unix, tc.code, code)
+		}
+	}
+}
+
+// TestValidateSkew checks that the codes of one time step before or after
+// are accepted, and that those further away are refused.
+func TestValidateSkew(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1234567890, 0)
+	current := totp.Counter(now)
+
+	for drift, valid := range map[int64]bool{-2: false, -1: true, 0: true, 1: true, 2: false} {
+		code, err := totp.Code(rfcSecret, current+drift)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		counter, ok := totp.Validate(code, rfcSecret, now, 1)
+		if ok != valid {
+			t.Errorf("drift %d: want valid %v, got %v", drift, valid, ok)
+		}
+		if ok && counter != current+drift {
+			t.Errorf("drift %d: want time step %d, got %d", drift, current+drift, counter)
+		}
+	}
+
+	if _, ok := totp.Validate("28708", rfcSecret, time.Unix(59, 0), 1); ok {
+		t.Error("want a code of the wrong length refused")
+	}
+}
@@ -1,19 +1,162 @@
 package usersvc
 
-import "errors"
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+	// RoleService is held by the accounts of resource servers, which may
+	// introspect the tokens presented to them.
+	RoleService = "service"
+)
 
 type User struct {
 	ID       uint64 `json:"id" gorm:"primaryKey"`
 	Name     string `json:"name" gorm:"unique"`
-	Password string `json:"password"`
+	Password string `json:"-"`
+	Roles    Roles  `json:"roles" gorm:"type:text;default:user"`
+	// Email is only used to link accounts of external identity providers,
+	// it is matched case-insensitively.
+	Email string `json:"email,omitempty" gorm:"index"`
+
+	Disabled bool `json:"disabled"`
+	// PasswordResetRequired refuses logins until the password is changed.
+	PasswordResetRequired bool       `json:"password_reset_required"`
+	LastLoginAt           *time.Time `json:"last_login_at,omitempty"`
+
+	// TOTPSecret is set on enrollment, but it is only required at login
+	// once TOTPEnabled is set after the first code was confirmed.
+	TOTPSecret  string `json:"-"`
+	TOTPEnabled bool   `json:"totp_enabled"`
+	// TOTPCounter is the time step of the last accepted code, so that a
+	// code cannot be used twice.
+	TOTPCounter int64 `json:"-"`
+	// RecoveryCodes holds the newline separated hashes of the unused
+	// recovery codes.
+	RecoveryCodes string `json:"-"`
+}
+
+// Roles is stored as a comma separated list.
+type Roles []string
+
+func (r Roles) Has(role string) bool {
+	for _, v := range r {
+		if v == role {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Roles) Value() (driver.Value, error) {
+	return strings.Join(r, ","), nil
+}
+
+func (r *Roles) Scan(src interface{}) error {
+	var s string
+	switch v := src.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("cannot scan %T into Roles", src)
+	}
+
+	*r = nil
+	for _, role := range strings.Split(s, ",") {
+		if role != "" {
+			*r = append(*r, role)
+		}
+	}
+	return nil
+}
+
+// TOTPKey is returned on enrollment to be added to an authenticator app.
+type TOTPKey struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// AuditEntry records an action of an administrator.
+type AuditEntry struct {
+	ID        uint64    `json:"id" gorm:"primaryKey"`
+	ActorID   uint64    `json:"actor_id"`
+	Action    string    `json:"action"`
+	TargetID  uint64    `json:"target_id,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// Identity links the account of an external identity provider, identified
+// by its issuer and subject, to a user.
+type Identity struct {
+	ID        uint64    `json:"id" gorm:"primaryKey"`
+	UserID    uint64    `json:"user_id" gorm:"index"`
+	Issuer    string    `json:"issuer" gorm:"uniqueIndex:idx_identities_issuer_subject"`
+	Subject   string    `json:"subject" gorm:"uniqueIndex:idx_identities_issuer_subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// PersonalData is what is stored about a user, as handed to the user on
+// export. The credentials are left out.
+type PersonalData struct {
+	Profile           User       `json:"profile"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+	Identities        []Identity `json:"identities"`
+	// AuditEntries are the entries whose actor or target is the user.
+	AuditEntries []AuditEntry `json:"audit_entries"`
 }
 
 type UserRepository interface {
 	GetUser(username string) *User
+	// GetUserByEmail returns the oldest user with the given email.
+	GetUserByEmail(email string) *User
+	GetUserByID(id uint64) *User
 	IsExists(id uint64) (bool, error)
+	Create(user *User) error
+	Update(user *User) error
+	// UpdateAudited saves the user and records the entry in one
+	// transaction, so that no change is left without its audit record.
+	UpdateAudited(user *User, entry *AuditEntry) error
+	// List returns the users whose name contains query, ordered by ID,
+	// together with the total number of matching users.
+	List(query string, offset, limit int) ([]User, int64, error)
+}
+
+type AuditRepository interface {
+	Record(entry *AuditEntry) error
+	// Find returns the entries created in [from, to), oldest first.
+	Find(from, to time.Time) ([]AuditEntry, error)
+	// FindByUser returns the entries whose actor or target is the user,
+	// oldest first.
+	FindByUser(userID uint64) ([]AuditEntry, error)
+}
+
+type IdentityRepository interface {
+	GetIdentity(issuer, subject string) *Identity
+	// FindByUser returns the identities linked to the user, oldest first.
+	FindByUser(userID uint64) ([]Identity, error)
+	Create(identity *Identity) error
 }
 
 var (
-	ErrInvalidArgument = errors.New("invalid argument")
-	ErrUserNotFound    = errors.New("user not found")
+	ErrInvalidArgument       = errors.New("invalid argument")
+	ErrUserNotFound          = errors.New("user not found")
+	ErrTOTPNotEnrolled       = errors.New("TOTP is not enrolled")
+	ErrTOTPAlreadyEnabled    = errors.New("TOTP is already enabled")
+	ErrInvalidTOTPCode       = errors.New("invalid TOTP code")
+	ErrUserDisabled          = errors.New("user is disabled")
+	ErrPasswordResetRequired = errors.New("password reset is required")
+	ErrCannotModifySelf      = errors.New("cannot change own account")
+	ErrClaimsMissing         = errors.New("JWT claims was not passed through the context")
+	ErrSessionInvalid        = errors.New("session was revoked or expired")
 )